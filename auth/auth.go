@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Context keys read by middleware.SecurityMiddleware, see middleware.WithUser
+const (
+	UserIDKey = middleware.UserIDKey
+	RolesKey  = middleware.RolesKey
+)
+
+var (
+	ErrMissingToken = &errors.StorageError{Code: "UNAUTHORIZED", Message: "Missing bearer token"}
+	ErrInvalidToken = &errors.StorageError{Code: "INVALID_TOKEN", Message: "Invalid token"}
+)
+
+// Config represents JWT authentication configuration
+type Config struct {
+	Issuer   string        `json:"issuer,omitempty"`   // Required iss claim when set
+	Audience string        `json:"audience,omitempty"` // Required aud claim when set
+	Leeway   time.Duration `json:"leeway,omitempty"`   // Allowed clock skew for exp/nbf
+
+	// Signing keys: an HMAC secret and/or public keys (RSA, ECDSA, Ed25519) by kid header,
+	// the "" entry is used for tokens without kid
+	Secret     []byte                      `json:"-"`
+	PublicKeys map[string]crypto.PublicKey `json:"-"`
+	// Keyfunc replaces the built-in key lookup, e.g. for JWKS endpoints
+	Keyfunc jwt.Keyfunc `json:"-"`
+	// Allowed signing algorithms, derived from the configured keys when empty
+	Algorithms []string `json:"algorithms,omitempty"`
+
+	UserIDClaim string `json:"user_id_claim,omitempty"` // Default "sub"
+	RolesClaim  string `json:"roles_claim,omitempty"`   // Default "roles", array or space separated string
+
+	// Optional lets requests without a token through unauthenticated; invalid tokens are still rejected
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Identity is the authenticated user extracted from a token
+type Identity struct {
+	UserID string        `json:"user_id"`
+	Roles  []string      `json:"roles,omitempty"`
+	Claims jwt.MapClaims `json:"claims,omitempty"`
+}
+
+// Authenticator validates JWTs and propagates the identity through request contexts
+type Authenticator struct {
+	config Config
+	parser *jwt.Parser
+}
+
+// identityKey is the context key of the full identity
+type identityKey struct{}
+
+// New creates a new authenticator
+func New(config Config) (*Authenticator, error) {
+	if config.Keyfunc == nil && len(config.Secret) == 0 && len(config.PublicKeys) == 0 {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "Secret, PublicKeys or Keyfunc is required"}
+	}
+	if config.UserIDClaim == "" {
+		config.UserIDClaim = "sub"
+	}
+	if config.RolesClaim == "" {
+		config.RolesClaim = "roles"
+	}
+
+	algorithms := config.Algorithms
+	if len(algorithms) == 0 {
+		if len(config.Secret) > 0 {
+			algorithms = append(algorithms, "HS256", "HS384", "HS512")
+		}
+		if len(config.PublicKeys) > 0 || config.Keyfunc != nil {
+			algorithms = append(algorithms, "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA")
+		}
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(algorithms),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(config.Leeway),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+
+	return &Authenticator{
+		config: config,
+		parser: jwt.NewParser(options...),
+	}, nil
+}
+
+// Parse validates a token and extracts the identity
+func (a *Authenticator) Parse(tokenString string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	if _, err := a.parser.ParseWithClaims(tokenString, claims, a.key); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	userID, _ := claims[a.config.UserIDClaim].(string)
+	if userID == "" {
+		return nil, fmt.Errorf("%w: missing %s claim", ErrInvalidToken, a.config.UserIDClaim)
+	}
+
+	return &Identity{
+		UserID: userID,
+		Roles:  rolesFromClaim(claims[a.config.RolesClaim]),
+		Claims: claims,
+	}, nil
+}
+
+// Authenticate validates the bearer token of a request
+// It returns nil without error for requests without a token when the config is Optional
+func (a *Authenticator) Authenticate(r *http.Request) (*Identity, error) {
+	tokenString := BearerToken(r)
+	if tokenString == "" {
+		if a.config.Optional {
+			return nil, nil
+		}
+		return nil, ErrMissingToken
+	}
+	return a.Parse(tokenString)
+}
+
+// key returns the verification key for a token
+func (a *Authenticator) key(token *jwt.Token) (interface{}, error) {
+	if a.config.Keyfunc != nil {
+		return a.config.Keyfunc(token)
+	}
+
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if len(a.config.Secret) == 0 {
+			return nil, fmt.Errorf("no HMAC secret configured")
+		}
+		return a.config.Secret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key, exists := a.config.PublicKeys[kid]
+	if !exists {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+	return key, nil
+}
+
+// BearerToken returns the token of the Authorization header, or ""
+func BearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
+
+// WithIdentity returns a context carrying the identity under the keys the security middleware reads
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	ctx = context.WithValue(ctx, identityKey{}, identity)
+	return middleware.WithUser(ctx, &middleware.User{ID: identity.UserID, Roles: identity.Roles})
+}
+
+// FromContext returns the identity stored by WithIdentity
+func FromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(*Identity)
+	return identity, ok
+}
+
+// UserID returns the authenticated user ID of a context, or ""
+func UserID(ctx context.Context) string {
+	if user, ok := middleware.UserFromContext(ctx); ok {
+		return user.ID
+	}
+	return ""
+}
+
+// rolesFromClaim converts an array or space separated roles claim
+func rolesFromClaim(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		roles := make([]string, 0, len(value))
+		for _, role := range value {
+			if s, ok := role.(string); ok && s != "" {
+				roles = append(roles, s)
+			}
+		}
+		return roles
+	case []string:
+		return value
+	}
+	return nil
+}
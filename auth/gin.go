@@ -0,0 +1,25 @@
+package auth
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// Gin returns gin middleware authenticating requests with bearer tokens
+// The identity is stored in the request context and as gin keys user_id and user_roles
+func (a *Authenticator) Gin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, err := a.Authenticate(c.Request)
+		if err != nil {
+			writeUnauthorized(c.Writer, err)
+			c.Abort()
+			return
+		}
+
+		if identity != nil {
+			c.Request = c.Request.WithContext(WithIdentity(c.Request.Context(), identity))
+			c.Set(string(UserIDKey), identity.UserID)
+			c.Set(string(RolesKey), identity.Roles)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	storageErrors "github.com/darmawan01/storage/errors"
+)
+
+// Middleware returns net/http middleware authenticating requests with bearer tokens
+// The identity is stored in the request context; failed requests get a 401 JSON error
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := a.Authenticate(r)
+		if err != nil {
+			writeUnauthorized(w, err)
+			return
+		}
+		if identity != nil {
+			r = r.WithContext(WithIdentity(r.Context(), identity))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeUnauthorized writes a 401 response for an authentication error
+func writeUnauthorized(w http.ResponseWriter, err error) {
+	code := ErrInvalidToken.Code
+	var storageErr *storageErrors.StorageError
+	if errors.As(err, &storageErr) {
+		code = storageErr.Code
+	}
+
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"error":   code,
+		"message": err.Error(),
+	})
+}
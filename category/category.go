@@ -1,8 +1,13 @@
 package category
 
 import (
+	"strings"
+
 	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/media"
 	"github.com/darmawan01/storage/middleware"
+	"github.com/darmawan01/storage/watermark"
+	"github.com/minio/minio-go/v7/pkg/tags"
 )
 
 // CategoryConfig represents category-specific configuration
@@ -17,12 +22,133 @@ type CategoryConfig struct {
 
 	// Category-specific middlewares (overrides handler defaults)
 	Middlewares []string `json:"middlewares,omitempty"`
+	// Configuration of custom middlewares by name, passed to their factory (overrides handler defaults)
+	MiddlewareConfigs map[string]map[string]interface{} `json:"middleware_configs,omitempty"`
 
 	// Category-specific security
 	Security middleware.SecurityConfig `json:"security,omitempty"`
 
 	// Category-specific preview settings
 	Preview PreviewConfig `json:"preview,omitempty"`
+
+	// Category-specific rate limits (overrides handler defaults when limits are set)
+	RateLimit middleware.RateLimitConfig `json:"rate_limit,omitempty"`
+
+	// Category-specific compression (overrides handler defaults when an algorithm or content types are set)
+	Compression middleware.CompressionConfig `json:"compression,omitempty"`
+
+	// Category-specific server-side encryption (overrides handler defaults when a type is set)
+	ServerSideEncryption SSEConfig `json:"server_side_encryption,omitempty"`
+
+	// Object tags applied to every upload, e.g. for lifecycle rules or ListFiles filters
+	DefaultTags map[string]string `json:"default_tags,omitempty"`
+
+	// Uploads without a user, e.g. from a public submission form
+	Anonymous AnonymousConfig `json:"anonymous,omitempty"`
+
+	// Review of uploads before they are served
+	Moderation ModerationConfig `json:"moderation,omitempty"`
+
+	// Labeling of uploaded images and videos
+	Classification ClassificationConfig `json:"classification,omitempty"`
+
+	// Text recognition of uploaded images and scanned documents, e.g. receipts
+	OCR OCRConfig `json:"ocr,omitempty"`
+
+	// Analysis of uploaded audio files
+	Audio AudioConfig `json:"audio,omitempty"`
+
+	// Transcoding of uploaded videos for adaptive streaming
+	Video VideoConfig `json:"video,omitempty"`
+
+	// Marks drawn on files as they are served, e.g. the identity of the requesting user
+	Watermark WatermarkConfig `json:"watermark,omitempty"`
+}
+
+// WatermarkConfig represents marks drawn on the files of a category as they are served, with the
+// Watermarker of the handler, so shared copies carry the identity of the user who requested them.
+// Files are watermarked whole in memory, so ranges of them are not served; thumbnails are served as
+// generated and are not watermarked. Files get no file URL, and preview URLs and GET presigns of
+// them are refused
+type WatermarkConfig struct {
+	Enabled bool `json:"enabled"`
+	// Text drawn on files, {user_id}, {date}, {file_key} and {request_id} are replaced; default "{user_id} {date}"
+	Text     string  `json:"text,omitempty"`
+	Opacity  float64 `json:"opacity,omitempty"`  // From 0 to 1, default 0.3
+	Position string  `json:"position,omitempty"` // "tile" (default), "center" or "bottom-right"
+	// Operations watermarked, "download" and "preview" (derived files such as document previews); default both
+	Operations []string `json:"operations,omitempty"`
+	MaxSize    int64    `json:"max_size,omitempty"` // Larger files are refused rather than served unmarked, default 50 MB
+}
+
+// Watermarks reports whether files served by an operation are watermarked
+func (c WatermarkConfig) Watermarks(operation string) bool {
+	if !c.Enabled {
+		return false
+	}
+	if len(c.Operations) == 0 {
+		return operation == "download" || operation == "preview"
+	}
+	for _, op := range c.Operations {
+		if op == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// VideoConfig represents the transcoding of the videos of a category to an HLS rendition ladder in
+// a background job, with the Transcoder of the handler. The ladder is stored as derived files and
+// streamed with StreamRequest.Path; set a timeout for transcode jobs in the async configuration
+type VideoConfig struct {
+	Transcode      bool              `json:"transcode"`
+	Renditions     []media.Rendition `json:"renditions,omitempty"`      // Default media.DefaultRenditions
+	SegmentSeconds int               `json:"segment_seconds,omitempty"` // Duration of segments, default 6
+}
+
+// AudioConfig represents the analysis of the audio files of a category in a background job, with
+// the audio analyzer of the handler. Duration, bitrate, sample rate and channels are stored as
+// metadata and a waveform as a derived file, see Handler.GetWaveform
+type AudioConfig struct {
+	Enabled        bool `json:"enabled"`
+	WaveformPoints int  `json:"waveform_points,omitempty"` // Points of the waveform, default 1000, negative skips it
+}
+
+// OCRConfig represents text recognition of the files of a category in a background job, with the
+// OCR engine of the handler. The text is indexed for Search when the handler has a search index,
+// PDFs with a text layer are indexed as they are
+type OCRConfig struct {
+	Enabled   bool `json:"enabled"`
+	StoreText bool `json:"store_text,omitempty"` // Store the beginning of the text as ocr-text metadata
+}
+
+// ClassificationConfig represents the labeling of images and videos uploaded to a category, with
+// the Classifier of the handler. The NSFW score and labels are stored as nsfw-score and
+// content-labels metadata; files scoring NSFWThreshold or more are held for moderation
+type ClassificationConfig struct {
+	Enabled       bool    `json:"enabled"`
+	Async         bool    `json:"async,omitempty"`          // Classify in a background job instead of before Upload returns
+	NSFWThreshold float64 `json:"nsfw_threshold,omitempty"` // Score from 0 to 1 holding files, 0 holds none
+}
+
+// ModerationConfig represents the review of uploads, see Handler.Approve and Handler.Reject
+// Uploads, new versions and written ranges are pending until approved; the security middleware of
+// the category keeps pending and rejected files from everyone but their uploader and moderators.
+// Anonymous uploads are always pending
+type ModerationConfig struct {
+	Enabled        bool `json:"enabled"`
+	DeleteRejected bool `json:"delete_rejected,omitempty"` // Rejected files are deleted instead of kept
+}
+
+// AnonymousConfig represents uploads of unauthenticated users to a category, with abuse controls
+// Anonymous uploads are rate limited per client address, marked pending moderation and deleted
+// after ExpiryDays by a bucket lifecycle rule; every file of the category is scanned for malware
+// and cannot be read before the scan passed. Requires a Scanner on the handler
+type AnonymousConfig struct {
+	Enabled           bool  `json:"enabled"`
+	RequestsPerMinute int   `json:"requests_per_minute,omitempty"` // Uploads per client address, default 10
+	BytesPerMinute    int64 `json:"bytes_per_minute,omitempty"`    // Bytes per client address, default 50 MB
+	ExpiryDays        int   `json:"expiry_days,omitempty"`         // Days anonymous uploads are kept, default 7, negative keeps them
 }
 
 // ValidationConfig represents basic validation configuration
@@ -129,15 +255,27 @@ type AudioValidationConfig struct {
 type PreviewConfig struct {
 	// Thumbnail settings
 	GenerateThumbnails bool     `json:"generate_thumbnails,omitempty"`
-	ThumbnailSizes     []string `json:"thumbnail_sizes,omitempty"` // ["150x150", "300x300", "600x600"]
+	ThumbnailSizes     []string `json:"thumbnail_sizes,omitempty"` // ["150x150", "300x300:crop", "600x600:smart"]
+	// Compute a BlurHash placeholder of images at upload, returned as Blurhash of uploads and file info
+	Placeholder bool `json:"placeholder,omitempty"`
 
 	// Preview settings
 	EnablePreview  bool     `json:"enable_preview,omitempty"`
 	PreviewFormats []string `json:"preview_formats,omitempty"` // ["image", "pdf", "video"]
+	// Convert office documents to PDF previews in the background, requires a Converter on the handler
+	ConvertDocuments bool `json:"convert_documents,omitempty"`
 
 	// CDN settings
 	UseCDN      bool   `json:"use_cdn,omitempty"`
 	CDNEndpoint string `json:"cdn_endpoint,omitempty"`
+	CDNProvider string `json:"cdn_provider,omitempty"` // "cloudflare", "aws_cloudfront" or "custom" (default)
+	// Private files are served through signed CDN URLs, requires the cdn middleware
+	CDNSigning middleware.CDNSigningConfig `json:"cdn_signing,omitempty"`
+
+	// Derived files (thumbnails, previews) can always be regenerated,
+	// so they may live in a cheaper bucket or storage class than originals
+	DerivedBucket       string `json:"derived_bucket,omitempty"`        // Defaults to the originals bucket
+	DerivedStorageClass string `json:"derived_storage_class,omitempty"` // e.g. "REDUCED_REDUNDANCY"
 }
 
 func (c *CategoryConfig) Validate() error {
@@ -147,6 +285,54 @@ func (c *CategoryConfig) Validate() error {
 	if c.MaxSize <= 0 {
 		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "MaxSize must be greater than 0"}
 	}
+	if err := c.ServerSideEncryption.Validate(); err != nil {
+		return err
+	}
+	if err := c.Compression.Validate(); err != nil {
+		return err
+	}
+	if err := c.Security.Validate(); err != nil {
+		return err
+	}
+	if c.Anonymous.Enabled {
+		if c.Security.RequireAuth || c.Security.RequireOwner {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Anonymous uploads cannot require authentication or an owner"}
+		}
+		if c.Anonymous.RequestsPerMinute < 0 || c.Anonymous.BytesPerMinute < 0 {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Anonymous upload limits must be non-negative"}
+		}
+	}
+	names := make(map[string]bool, len(c.Video.Renditions))
+	for _, rendition := range c.Video.Renditions {
+		if rendition.Name == "" || strings.ContainsAny(rendition.Name, "/\\") || rendition.Name == "." || rendition.Name == ".." || names[rendition.Name] {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Renditions need unique names usable as directories"}
+		}
+		if rendition.Height <= 0 || rendition.Width <= 0 || rendition.VideoBitrate <= 0 || rendition.AudioBitrate <= 0 {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Rendition " + rendition.Name + " needs a size and bitrates"}
+		}
+		names[rendition.Name] = true
+	}
+	if c.Watermark.Opacity < 0 || c.Watermark.Opacity > 1 {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Watermark opacity must be between 0 and 1"}
+	}
+	switch c.Watermark.Position {
+	case "", watermark.PositionTile, watermark.PositionCenter, watermark.PositionBottomRight:
+	default:
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Unknown watermark position " + c.Watermark.Position}
+	}
+	for _, op := range c.Watermark.Operations {
+		if op != "download" && op != "preview" {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Unknown watermark operation " + op}
+		}
+	}
+	if c.Classification.NSFWThreshold < 0 || c.Classification.NSFWThreshold > 1 {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "NSFWThreshold must be between 0 and 1"}
+	}
+	if len(c.DefaultTags) > 0 {
+		if _, err := tags.NewTags(c.DefaultTags, true); err != nil {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Invalid default tags", Details: err.Error(), Err: err}
+		}
+	}
 	return nil
 }
 
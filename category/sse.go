@@ -0,0 +1,108 @@
+package category
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/secrets"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// Server-side encryption types
+const (
+	SSENone = ""
+	SSES3   = "SSE-S3"  // Keys managed by the object store
+	SSEKMS  = "SSE-KMS" // Keys managed by the KMS configured on the object store
+	SSEC    = "SSE-C"   // Keys provided by the application on every request
+)
+
+// SSEConfig represents MinIO server-side encryption configuration
+// SSE-C objects can only be read with their key, so background jobs such as thumbnails do not process them
+type SSEConfig struct {
+	Type string `json:"type,omitempty"` // SSE-S3, SSE-KMS or SSE-C, empty disables server-side encryption
+
+	// SSE-KMS settings
+	KMSKeyID   string                 `json:"kms_key_id,omitempty"`
+	KMSContext map[string]interface{} `json:"kms_context,omitempty"`
+
+	// SSE-C key, 32 bytes; read as hex from CustomerKeySecret or CustomerKeyEnvVar when empty
+	CustomerKey       []byte `json:"-"`
+	CustomerKeyEnvVar string `json:"customer_key_env_var,omitempty"`
+	CustomerKeySecret string `json:"customer_key_secret,omitempty"`
+	// Secrets resolves CustomerKeySecret, set to the handler's provider when nil
+	// Rotating the key makes objects written with the previous one unreadable
+	Secrets secrets.Provider `json:"-"`
+}
+
+// Enabled reports whether server-side encryption is configured
+func (c SSEConfig) Enabled() bool {
+	return c.Type != SSENone
+}
+
+// Validate checks the configuration without resolving keys
+func (c SSEConfig) Validate() error {
+	switch c.Type {
+	case SSENone, SSES3, SSEKMS:
+		return nil
+	case SSEC:
+		if len(c.CustomerKey) == 0 && c.CustomerKeyEnvVar == "" && c.CustomerKeySecret == "" {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "SSE-C requires CustomerKey, CustomerKeyEnvVar or CustomerKeySecret"}
+		}
+		return nil
+	default:
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Unsupported server-side encryption type " + c.Type}
+	}
+}
+
+// ServerSide returns the MinIO encryption options, nil when disabled
+func (c SSEConfig) ServerSide() (encrypt.ServerSide, error) {
+	switch c.Type {
+	case SSENone:
+		return nil, nil
+	case SSES3:
+		return encrypt.NewSSE(), nil
+	case SSEKMS:
+		var context interface{}
+		if len(c.KMSContext) > 0 {
+			context = c.KMSContext
+		}
+		sse, err := encrypt.NewSSEKMS(c.KMSKeyID, context)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure SSE-KMS: %w", err)
+		}
+		return sse, nil
+	case SSEC:
+		key := c.CustomerKey
+		if len(key) == 0 && c.CustomerKeySecret != "" {
+			if c.Secrets == nil {
+				return nil, fmt.Errorf("no secret provider for SSE-C key %s", c.CustomerKeySecret)
+			}
+			secret, err := secrets.GetKey(context.Background(), c.Secrets, c.CustomerKeySecret, 32)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read SSE-C key: %w", err)
+			}
+			key = secret
+		}
+		if len(key) == 0 {
+			value := os.Getenv(c.CustomerKeyEnvVar)
+			if value == "" {
+				return nil, fmt.Errorf("%s environment variable not set", c.CustomerKeyEnvVar)
+			}
+			decoded, err := hex.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid hex key in environment variable: %w", err)
+			}
+			key = decoded
+		}
+		sse, err := encrypt.NewSSEC(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure SSE-C: %w", err)
+		}
+		return sse, nil
+	default:
+		return nil, fmt.Errorf("unsupported server-side encryption type: %s", c.Type)
+	}
+}
@@ -0,0 +1,133 @@
+// Package clientinfo carries the network address and user agent of the client of a request, so
+// audit events, access records and per-address rate limits record them whichever API the storage
+// is used through. Extractor reads them from HTTP requests, behind proxies as well
+package clientinfo
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Info represents the client of a request
+type Info struct {
+	IPAddress string `json:"ip_address,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+type contextKey struct{}
+
+// WithInfo returns a context carrying the client of a request
+func WithInfo(ctx context.Context, info Info) context.Context {
+	return context.WithValue(ctx, contextKey{}, info)
+}
+
+// FromContext returns the client set by WithInfo, empty when there is none
+func FromContext(ctx context.Context) Info {
+	info, _ := ctx.Value(contextKey{}).(Info)
+	return info
+}
+
+// Extractor reads the client of HTTP requests; the address is the peer's unless the peer is a
+// trusted proxy, then the forwarded address of X-Forwarded-For or X-Real-IP
+type Extractor struct {
+	trusted []*net.IPNet
+}
+
+// NewExtractor creates an extractor trusting the forwarding headers of the given proxies, IP
+// addresses or CIDR ranges, e.g. "10.0.0.0/8"; without proxies the peer address is used
+func NewExtractor(trustedProxies ...string) (*Extractor, error) {
+	e := &Extractor{}
+	for _, proxy := range trustedProxies {
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			proxy = fmt.Sprintf("%s/%d", proxy, bits)
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		e.trusted = append(e.trusted, network)
+	}
+	return e, nil
+}
+
+// FromRequest returns the client of a request
+func (e *Extractor) FromRequest(r *http.Request) Info {
+	return Info{IPAddress: e.address(r), UserAgent: r.UserAgent()}
+}
+
+// address returns the client address of a request, following the forwarding headers of trusted
+// proxies from the nearest hop back
+func (e *Extractor) address(r *http.Request) string {
+	ip := peerAddress(r.RemoteAddr)
+	if !e.isTrusted(ip) {
+		return ip
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		hops := strings.Split(forwarded, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+			ip = hop
+			if !e.isTrusted(hop) {
+				break
+			}
+		}
+		return ip
+	}
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+	return ip
+}
+
+// isTrusted reports whether an address is a trusted proxy
+func (e *Extractor) isTrusted(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range e.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Middleware returns net/http middleware storing the client of every request in its context
+func (e *Extractor) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(WithInfo(r.Context(), e.FromRequest(r))))
+	})
+}
+
+// FromRequest returns the client of a request: the one stored in its context, e.g. by
+// Extractor.Middleware, or else its peer address and user agent
+func FromRequest(r *http.Request) Info {
+	if info := FromContext(r.Context()); info.IPAddress != "" || info.UserAgent != "" {
+		return info
+	}
+	return Info{IPAddress: peerAddress(r.RemoteAddr), UserAgent: r.UserAgent()}
+}
+
+// peerAddress returns the address of a peer without the port
+func peerAddress(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
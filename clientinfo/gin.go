@@ -0,0 +1,15 @@
+package clientinfo
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// Gin returns gin middleware storing the client of every request in its context
+// The address is gin's ClientIP, which honors the trusted proxies of the engine
+func Gin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		info := Info{IPAddress: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+		c.Request = c.Request.WithContext(WithInfo(c.Request.Context(), info))
+		c.Next()
+	}
+}
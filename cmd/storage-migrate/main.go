@@ -0,0 +1,121 @@
+// Command storage-migrate imports the objects of an existing bucket into a storage handler
+//
+//	storage-migrate -config storage.yaml -handler cat -source-bucket legacy \
+//		-prefix cats/ -pattern "cats/{entity_id}/{filename}" -category profile -entity-type cat
+//
+// The handler is configured by the config file, see config.Load. Objects are copied server-side
+// when the source bucket is on the same backend, or read from -source-endpoint otherwise
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/darmawan01/storage/config"
+	"github.com/darmawan01/storage/migrate"
+	"github.com/darmawan01/storage/registry"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+func main() {
+	configPath := flag.String("config", "", "storage configuration file, YAML or JSON")
+	handlerName := flag.String("handler", "", "handler of the config file to import into")
+	sourceBucket := flag.String("source-bucket", "", "bucket to import from")
+	prefix := flag.String("prefix", "", "prefix of the objects to import")
+	pattern := flag.String("pattern", "*{filename}", "pattern of the source keys, with {category}, {entity_type}, {entity_id}, {user_id}, {filename} and *")
+	category := flag.String("category", "", "category of keys without {category}")
+	entityType := flag.String("entity-type", "", "entity type of keys without {entity_type}")
+	entityID := flag.String("entity-id", "", "entity ID of keys without {entity_id}")
+	userID := flag.String("user", "", "uploader of keys without {user_id}")
+	concurrency := flag.Int("concurrency", 4, "objects imported at once")
+	dryRun := flag.Bool("dry-run", false, "print the mapping of every object without importing")
+	sourceEndpoint := flag.String("source-endpoint", "", "endpoint of the source bucket, the configured backend when empty")
+	sourceAccessKey := flag.String("source-access-key", os.Getenv("SOURCE_ACCESS_KEY"), "access key of the source endpoint")
+	sourceSecretKey := flag.String("source-secret-key", os.Getenv("SOURCE_SECRET_KEY"), "secret key of the source endpoint")
+	sourceSSL := flag.Bool("source-ssl", true, "use TLS for the source endpoint")
+	flag.Parse()
+
+	if *configPath == "" || *handlerName == "" || *sourceBucket == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	file, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handlerConfig, ok := file.Handlers[*handlerName]
+	if !ok {
+		log.Fatalf("handler %s is not configured in %s", *handlerName, *configPath)
+	}
+
+	mapper, err := migrate.KeyPattern(*pattern, migrate.Target{
+		Category:   *category,
+		EntityType: *entityType,
+		EntityID:   *entityID,
+		UserID:     *userID,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var source *minio.Client
+	if *sourceEndpoint != "" {
+		source, err = minio.New(*sourceEndpoint, &minio.Options{
+			Creds:  credentials.NewStaticV4(*sourceAccessKey, *sourceSecretKey, ""),
+			Secure: *sourceSSL,
+		})
+		if err != nil {
+			log.Fatalf("failed to create source client: %v", err)
+		}
+	}
+
+	storageRegistry := registry.NewRegistry()
+	if err := storageRegistry.Initialize(file.Storage); err != nil {
+		log.Fatal(err)
+	}
+	h, err := storageRegistry.Register(*handlerName, handlerConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	output := json.NewEncoder(os.Stdout)
+	report, err := migrate.Run(ctx, h, migrate.Config{
+		Source:      source,
+		Bucket:      *sourceBucket,
+		Prefix:      *prefix,
+		Map:         mapper,
+		Concurrency: *concurrency,
+		DryRun:      *dryRun,
+		OnFile: func(result migrate.Result) {
+			if result.Err != nil {
+				log.Printf("failed to import %s: %v", result.SourceKey, result.Err)
+				return
+			}
+			if !result.Skipped {
+				output.Encode(result)
+			}
+		},
+	})
+	if report != nil {
+		fmt.Fprintf(os.Stderr, "scanned %d, migrated %d, skipped %d, failed %d\n", report.Scanned, report.Migrated, report.Skipped, report.Failed)
+	}
+	if err != nil {
+		log.Print(err)
+	}
+	// Background work of the imports, e.g. thumbnails and events, finishes before exiting
+	storageRegistry.Close()
+	if err != nil || report.Failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -1,6 +1,10 @@
 package config
 
-import "github.com/darmawan01/storage/errors"
+import (
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/logger"
+	"github.com/darmawan01/storage/secrets"
+)
 
 // StorageConfig represents the central storage configuration
 type StorageConfig struct {
@@ -20,6 +24,20 @@ type StorageConfig struct {
 	RequestTimeout    int `json:"request_timeout"`    // Request timeout in seconds
 	RetryAttempts     int `json:"retry_attempts"`     // Number of retry attempts
 	RetryDelay        int `json:"retry_delay"`        // Delay between retries in milliseconds
+
+	// Credentials read from secrets instead of AccessKey/SecretKey, e.g. Docker or Kubernetes secret files
+	// Files are paths, secret names are resolved by Secrets; both are read again every SecretRefreshInterval
+	AccessKeyFile         string `json:"access_key_file,omitempty"`
+	SecretKeyFile         string `json:"secret_key_file,omitempty"`
+	AccessKeySecret       string `json:"access_key_secret,omitempty"`
+	SecretKeySecret       string `json:"secret_key_secret,omitempty"`
+	SecretRefreshInterval int    `json:"secret_refresh_interval,omitempty"` // Seconds, default 300
+
+	// Secrets resolves secret names (Vault, AWS Secrets Manager, files) and is passed on to handlers without their own
+	Secrets secrets.Provider `json:"-"`
+
+	// Logger receives internal logs and is passed on to handlers without their own; defaults to logger.Default()
+	Logger logger.Logger `json:"-"`
 }
 
 // Default configurations
@@ -49,11 +67,17 @@ func (c *StorageConfig) Validate() error {
 	if c.Endpoint == "" {
 		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Endpoint is required"}
 	}
-	if c.AccessKey == "" {
-		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "AccessKey is required"}
+	if c.AccessKey == "" && c.AccessKeyFile == "" && c.AccessKeySecret == "" {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "AccessKey, AccessKeyFile or AccessKeySecret is required"}
+	}
+	if c.SecretKey == "" && c.SecretKeyFile == "" && c.SecretKeySecret == "" {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "SecretKey, SecretKeyFile or SecretKeySecret is required"}
+	}
+	if (c.AccessKeySecret != "" || c.SecretKeySecret != "") && c.Secrets == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Secrets is required to resolve AccessKeySecret and SecretKeySecret"}
 	}
-	if c.SecretKey == "" {
-		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "SecretKey is required"}
+	if c.SecretRefreshInterval < 0 {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "SecretRefreshInterval must be non-negative"}
 	}
 	if c.MaxFileSize <= 0 {
 		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "MaxFileSize must be greater than 0"}
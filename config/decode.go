@@ -0,0 +1,260 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// normalize checks a decoded document against the type it is loaded into, reporting unknown
+// fields by path, and converts duration strings such as "1h" to the nanoseconds encoding/json expects
+func normalize(value interface{}, t reflect.Type, path string) (interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		// YAML mappings with non-string keys
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = item
+		}
+		return normalize(m, t, path)
+
+	case map[string]interface{}:
+		var fields map[string]reflect.StructField
+		if t.Kind() == reflect.Struct {
+			fields = jsonFields(t)
+		}
+		for key, item := range v {
+			itemPath := joinPath(path, key)
+
+			var itemType reflect.Type
+			switch t.Kind() {
+			case reflect.Struct:
+				field, exists := fields[strings.ToLower(key)]
+				if !exists {
+					return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "Unknown config field " + itemPath}
+				}
+				itemType = field.Type
+			case reflect.Map:
+				itemType = t.Elem()
+			default:
+				continue
+			}
+
+			normalized, err := normalize(item, itemType, itemPath)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = normalized
+		}
+		return v, nil
+
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return v, nil
+		}
+		for i, item := range v {
+			normalized, err := normalize(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			v[i] = normalized
+		}
+		return v, nil
+
+	case string:
+		if t == durationType {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: fmt.Sprintf("Invalid duration for %s: %q", path, v)}
+			}
+			return int64(d), nil
+		}
+	}
+	return value, nil
+}
+
+// jsonFields maps the lower-cased json names of a struct to its fields, flattening embedded structs
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := jsonName(field)
+		if !ok {
+			continue
+		}
+		if name == "" && field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for embedded, embeddedField := range jsonFields(field.Type) {
+				fields[embedded] = embeddedField
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[strings.ToLower(name)] = field
+	}
+	return fields
+}
+
+// jsonName returns the json tag name of a field, false for fields encoding/json skips
+func jsonName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() && !field.Anonymous {
+		return "", false
+	}
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return "", false
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	return name, true
+}
+
+// applyEnv overrides configuration fields from environment variables, see Parse
+func (f *File) applyEnv() error {
+	if err := applyEnv(reflect.ValueOf(&f.Storage).Elem(), EnvPrefix); err != nil {
+		return err
+	}
+	for name, handlerConfig := range f.Handlers {
+		if handlerConfig == nil {
+			continue
+		}
+		if err := applyEnv(reflect.ValueOf(handlerConfig).Elem(), EnvPrefix+"_HANDLERS_"+envName(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyEnv walks a value, setting every field whose environment variable is present
+func applyEnv(v reflect.Value, name string) error {
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag, ok := jsonName(field)
+			if !ok || !field.IsExported() {
+				continue
+			}
+
+			fieldName := name
+			if tag != "" || !field.Anonymous {
+				if tag == "" {
+					tag = field.Name
+				}
+				fieldName = name + "_" + envName(tag)
+			}
+			if err := applyEnv(v.Field(i), fieldName); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Ptr:
+		if v.IsNil() || v.Elem().Kind() != reflect.Struct {
+			return nil
+		}
+		return applyEnv(v.Elem(), name)
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return nil
+		}
+		// Map values are not addressable, override a copy and store it back
+		for _, key := range v.MapKeys() {
+			item := reflect.New(v.Type().Elem()).Elem()
+			item.Set(v.MapIndex(key))
+			if err := applyEnv(item, name+"_"+envName(key.String())); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, item)
+		}
+		return nil
+	}
+
+	value, exists := os.LookupEnv(name)
+	if !exists {
+		return nil
+	}
+	if err := setValue(v, value); err != nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Invalid value for " + name + ": " + err.Error()}
+	}
+	return nil
+}
+
+// setValue parses an environment variable into a field, lists are comma separated
+func setValue(v reflect.Value, value string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return nil
+		}
+		items := reflect.MakeSlice(v.Type(), 0, strings.Count(value, ",")+1)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = reflect.Append(items, reflect.ValueOf(item).Convert(v.Type().Elem()))
+			}
+		}
+		v.Set(items)
+	}
+	return nil
+}
+
+// envName upper-cases a config key, replacing characters not allowed in variable names
+func envName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+}
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/handler"
+)
+
+// EnvPrefix prefixes the environment variables read by Load and FromEnv
+const EnvPrefix = "STORAGE"
+
+// ConfigFileEnv names the configuration file loaded by FromEnv
+const ConfigFileEnv = EnvPrefix + "_CONFIG_FILE"
+
+// Format is the encoding of a configuration file
+type Format string
+
+const (
+	FormatJSON Format = "json"
+	FormatYAML Format = "yaml"
+)
+
+// File represents a configuration file: the storage connection and the handlers to register
+//
+//	storage:
+//	  endpoint: minio:9000
+//	  bucket_name: photos
+//	handlers:
+//	  cat:
+//	    middlewares: [security, validation, thumbnail]
+//	    categories:
+//	      profile:
+//	        bucket_suffix: profile
+//	        max_size: 5242880
+//	        allowed_types: [image/jpeg, image/png]
+//	    security:
+//	      presigned_url_expiry: 1h
+//
+// Keys are the json tags of StorageConfig and handler.HandlerConfig, durations are
+// strings such as "1h". Unset storage fields keep DefaultStorageConfig, unset handler
+// fields keep handler.DefaultHandlerConfig
+type File struct {
+	Storage  StorageConfig                     `json:"storage"`
+	Handlers map[string]*handler.HandlerConfig `json:"handlers,omitempty"`
+}
+
+// Load reads a YAML or JSON configuration file, chosen by extension, applies the
+// environment overrides and validates the result
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	format := FormatYAML
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		format = FormatJSON
+	}
+
+	file, err := Parse(data, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// FromEnv loads the file named by STORAGE_CONFIG_FILE, or starts from the defaults when
+// it is unset, and applies the environment overrides
+func FromEnv() (*File, error) {
+	if path := os.Getenv(ConfigFileEnv); path != "" {
+		return Load(path)
+	}
+
+	file := &File{Storage: DefaultStorageConfig()}
+	if err := file.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := file.Validate(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Parse decodes a configuration, applies the environment overrides and validates the result
+//
+// Every field can be overridden by an environment variable named after its path in the
+// file, upper-cased and joined by underscores. Storage fields drop the "storage" level:
+//
+//	STORAGE_ENDPOINT=minio:9000
+//	STORAGE_HANDLERS_CAT_SECURITY_PRESIGNED_URL_EXPIRY=30m
+//	STORAGE_HANDLERS_CAT_CATEGORIES_PROFILE_ALLOWED_TYPES=image/jpeg,image/png
+//
+// Overrides only reach handlers and categories present in the file
+func Parse(data []byte, format Format) (*File, error) {
+	var document interface{}
+	switch format {
+	case FormatYAML:
+		if err := yaml.Unmarshal(data, &document); err != nil {
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+	case FormatJSON:
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&document); err != nil {
+			return nil, fmt.Errorf("failed to parse JSON: %w", err)
+		}
+	default:
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "Unsupported config format " + string(format)}
+	}
+
+	document, err := normalize(document, reflect.TypeOf(File{}), "")
+	if err != nil {
+		return nil, err
+	}
+	normalized, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	// Decode each section over its defaults
+	var sections struct {
+		Storage  json.RawMessage            `json:"storage"`
+		Handlers map[string]json.RawMessage `json:"handlers"`
+	}
+	if err := json.Unmarshal(normalized, &sections); err != nil {
+		return nil, decodeError("", err)
+	}
+
+	file := &File{
+		Storage:  DefaultStorageConfig(),
+		Handlers: make(map[string]*handler.HandlerConfig, len(sections.Handlers)),
+	}
+	if len(sections.Storage) > 0 {
+		if err := json.Unmarshal(sections.Storage, &file.Storage); err != nil {
+			return nil, decodeError("storage", err)
+		}
+	}
+	for name, section := range sections.Handlers {
+		handlerConfig := handler.DefaultHandlerConfig(name)
+		if err := json.Unmarshal(section, &handlerConfig); err != nil {
+			return nil, decodeError("handlers."+name, err)
+		}
+		file.Handlers[name] = &handlerConfig
+	}
+
+	if err := file.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := file.Validate(); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Validate validates the storage configuration and every handler
+func (f *File) Validate() error {
+	if err := f.Storage.Validate(); err != nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Storage config is invalid: " + err.Error()}
+	}
+
+	names := make([]string, 0, len(f.Handlers))
+	for name := range f.Handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		handlerConfig := f.Handlers[name]
+		if handlerConfig == nil {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Handler " + name + " has no configuration"}
+		}
+		if err := handlerConfig.Validate(); err != nil {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Handler " + name + " is invalid: " + err.Error()}
+		}
+	}
+	return nil
+}
+
+// decodeError reports a type mismatch with the path of the offending field
+func decodeError(section string, err error) error {
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		path := joinPath(section, typeErr.Field)
+		return &errors.StorageError{
+			Code:    "INVALID_CONFIG",
+			Message: fmt.Sprintf("Invalid value for %s: expected %s, got %s", path, typeErr.Type, typeErr.Value),
+		}
+	}
+	return fmt.Errorf("failed to decode config %s: %w", section, err)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	if key == "" {
+		return path
+	}
+	return path + "." + key
+}
@@ -0,0 +1,207 @@
+// Package convert turns office documents into PDF previews
+// Converters are pluggable, GotenbergConverter uses a Gotenberg server and LibreOfficeConverter
+// runs LibreOffice on the same machine
+package convert
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+var (
+	ErrInvalidConfig = &errors.StorageError{Code: "INVALID_CONFIG", Message: "Invalid converter configuration"}
+	ErrUnsupported   = &errors.StorageError{Code: "UNSUPPORTED_TYPE", Message: "No converter for this file type"}
+)
+
+// Converter turns documents into PDF
+type Converter interface {
+	// Supports reports whether files of a content type can be converted
+	Supports(contentType string) bool
+	// Convert returns the PDF of a document, which must be closed
+	Convert(ctx context.Context, contentType string, data io.Reader) (io.ReadCloser, error)
+}
+
+// officeExtensions are the file extensions of the office formats LibreOffice converts, by content type
+// Converters identify the format of a document by its extension
+var officeExtensions = map[string]string{
+	"application/msword": ".doc",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": ".docx",
+	"application/vnd.ms-excel": ".xls",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         ".xlsx",
+	"application/vnd.ms-powerpoint":                                             ".ppt",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": ".pptx",
+	"application/vnd.oasis.opendocument.text":                                   ".odt",
+	"application/vnd.oasis.opendocument.spreadsheet":                            ".ods",
+	"application/vnd.oasis.opendocument.presentation":                           ".odp",
+	"application/rtf": ".rtf",
+	"text/csv":        ".csv",
+}
+
+// extension returns the file extension of an office content type, empty when it is not one
+func extension(contentType string) string {
+	return officeExtensions[strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))]
+}
+
+// GotenbergConfig represents Gotenberg server configuration
+type GotenbergConfig struct {
+	URL        string       `json:"url"` // e.g. http://gotenberg:3000
+	HTTPClient *http.Client `json:"-"`
+}
+
+// GotenbergConverter converts documents with the LibreOffice route of a Gotenberg server
+type GotenbergConverter struct {
+	config GotenbergConfig
+}
+
+// NewGotenbergConverter creates a new Gotenberg converter
+func NewGotenbergConverter(config GotenbergConfig) (*GotenbergConverter, error) {
+	if config.URL == "" {
+		return nil, ErrInvalidConfig
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &GotenbergConverter{config: config}, nil
+}
+
+// Supports reports whether a content type is an office document
+func (c *GotenbergConverter) Supports(contentType string) bool {
+	return extension(contentType) != ""
+}
+
+// Convert posts the document to /forms/libreoffice/convert and returns the PDF of the response
+func (c *GotenbergConverter) Convert(ctx context.Context, contentType string, data io.Reader) (io.ReadCloser, error) {
+	ext := extension(contentType)
+	if ext == "" {
+		return nil, ErrUnsupported
+	}
+
+	// The form is streamed, so the document is not held in memory
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		part, err := form.CreateFormFile("files", "document"+ext)
+		if err == nil {
+			_, err = io.Copy(part, data)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.URL+"/forms/libreoffice/convert", body)
+	if err != nil {
+		body.Close()
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := c.config.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert document with Gotenberg: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("failed to convert document with Gotenberg: status %d: %s", resp.StatusCode, bytes.TrimSpace(excerpt))
+	}
+	return resp.Body, nil
+}
+
+// LibreOfficeConfig represents the LibreOffice executable used for conversions
+type LibreOfficeConfig struct {
+	Path string `json:"path,omitempty"` // soffice executable, default "soffice" in PATH
+}
+
+// LibreOfficeConverter converts documents by running LibreOffice headless
+// Every conversion starts LibreOffice, so it suits low volumes; prefer Gotenberg
+type LibreOfficeConverter struct {
+	config LibreOfficeConfig
+}
+
+// NewLibreOfficeConverter creates a new LibreOffice converter
+func NewLibreOfficeConverter(config LibreOfficeConfig) *LibreOfficeConverter {
+	if config.Path == "" {
+		config.Path = "soffice"
+	}
+	return &LibreOfficeConverter{config: config}
+}
+
+// Supports reports whether a content type is an office document
+func (c *LibreOfficeConverter) Supports(contentType string) bool {
+	return extension(contentType) != ""
+}
+
+// Convert writes the document to a temporary directory and converts it there, the returned PDF
+// removes the directory when closed
+func (c *LibreOfficeConverter) Convert(ctx context.Context, contentType string, data io.Reader) (io.ReadCloser, error) {
+	ext := extension(contentType)
+	if ext == "" {
+		return nil, ErrUnsupported
+	}
+
+	dir, err := os.MkdirTemp("", "convert-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create conversion directory: %w", err)
+	}
+	pdf, err := c.convert(ctx, dir, ext, data)
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &tempFile{File: pdf, dir: dir}, nil
+}
+
+// convert runs LibreOffice on a document written to dir and opens the PDF
+func (c *LibreOfficeConverter) convert(ctx context.Context, dir, ext string, data io.Reader) (*os.File, error) {
+	input := filepath.Join(dir, "document"+ext)
+	file, err := os.Create(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write document: %w", err)
+	}
+	_, err = io.Copy(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write document: %w", err)
+	}
+
+	// A profile per conversion keeps concurrent conversions from locking each other out
+	cmd := exec.CommandContext(ctx, c.config.Path, "--headless", "--norestore",
+		"-env:UserInstallation=file://"+filepath.ToSlash(filepath.Join(dir, "profile")),
+		"--convert-to", "pdf", "--outdir", dir, input)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("failed to convert document with LibreOffice: %w: %s", err, bytes.TrimSpace(output))
+	}
+	pdf, err := os.Open(filepath.Join(dir, "document.pdf"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open converted document: %w", err)
+	}
+	return pdf, nil
+}
+
+// tempFile is a file that removes its directory when closed
+type tempFile struct {
+	*os.File
+	dir string
+}
+
+// Close closes the file and removes its directory
+func (f *tempFile) Close() error {
+	err := f.File.Close()
+	os.RemoveAll(f.dir)
+	return err
+}
@@ -1,25 +1,189 @@
 package errors
 
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
 // Error types
 type StorageError struct {
 	Code    string `json:"code"`
 	Message string `json:"message"`
 	Details string `json:"details,omitempty"`
+	// RequestID is the request the error occurred in, see WithRequestID
+	RequestID string `json:"request_id,omitempty"`
+	Err       error  `json:"-"` // Underlying cause, see Wrap
 }
 
 func (e *StorageError) Error() string {
-	return e.Message
+	message := e.Message
+	if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+	if e.RequestID != "" {
+		message += " (request " + e.RequestID + ")"
+	}
+	return message
+}
+
+// Unwrap returns the underlying cause, so errors.Is and errors.As see through storage errors
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is a storage error with the same code, so errors.Is(err, ErrFileNotFound)
+// matches every file not found error and not only the ErrFileNotFound value
+func (e *StorageError) Is(target error) bool {
+	t, ok := target.(*StorageError)
+	return ok && t.Code == e.Code
+}
+
+// Wrap returns an error with the code and message of base caused by err, e.g. Wrap(ErrUploadFailed, err)
+// The cause is kept in Details for clients
+func Wrap(base *StorageError, err error) *StorageError {
+	return &StorageError{Code: base.Code, Message: base.Message, Details: err.Error(), Err: err}
 }
 
 var (
-	ErrFileNotFound     = &StorageError{Code: "FILE_NOT_FOUND", Message: "File not found"}
-	ErrAccessDenied     = &StorageError{Code: "ACCESS_DENIED", Message: "Access denied"}
-	ErrInvalidFile      = &StorageError{Code: "INVALID_FILE", Message: "Invalid file"}
-	ErrFileTooLarge     = &StorageError{Code: "FILE_TOO_LARGE", Message: "File too large"}
-	ErrUnsupportedType  = &StorageError{Code: "UNSUPPORTED_TYPE", Message: "Unsupported file type"}
-	ErrValidationFailed = &StorageError{Code: "VALIDATION_FAILED", Message: "Validation failed"}
-	ErrBucketNotFound   = &StorageError{Code: "BUCKET_NOT_FOUND", Message: "Bucket not found"}
-	ErrUploadFailed     = &StorageError{Code: "UPLOAD_FAILED", Message: "Upload failed"}
-	ErrDownloadFailed   = &StorageError{Code: "DOWNLOAD_FAILED", Message: "Download failed"}
-	ErrDeleteFailed     = &StorageError{Code: "DELETE_FAILED", Message: "Delete failed"}
+	ErrFileNotFound       = &StorageError{Code: "FILE_NOT_FOUND", Message: "File not found"}
+	ErrAccessDenied       = &StorageError{Code: "ACCESS_DENIED", Message: "Access denied"}
+	ErrUnauthorized       = &StorageError{Code: "UNAUTHORIZED", Message: "Authentication required"}
+	ErrInvalidFile        = &StorageError{Code: "INVALID_FILE", Message: "Invalid file"}
+	ErrInvalidRequest     = &StorageError{Code: "INVALID_REQUEST", Message: "Invalid request"}
+	ErrInvalidRange       = &StorageError{Code: "INVALID_RANGE", Message: "Range not satisfiable"}
+	ErrFileTooLarge       = &StorageError{Code: "FILE_TOO_LARGE", Message: "File too large"}
+	ErrUnsupportedType    = &StorageError{Code: "UNSUPPORTED_TYPE", Message: "Unsupported file type"}
+	ErrValidationFailed   = &StorageError{Code: "VALIDATION_FAILED", Message: "Validation failed"}
+	ErrBucketNotFound     = &StorageError{Code: "BUCKET_NOT_FOUND", Message: "Bucket not found"}
+	ErrUploadFailed       = &StorageError{Code: "UPLOAD_FAILED", Message: "Upload failed"}
+	ErrDownloadFailed     = &StorageError{Code: "DOWNLOAD_FAILED", Message: "Download failed"}
+	ErrDeleteFailed       = &StorageError{Code: "DELETE_FAILED", Message: "Delete failed"}
+	ErrMiddlewareFailed   = &StorageError{Code: "MIDDLEWARE_FAILED", Message: "Middleware failed"}
+	ErrBackendUnavailable = &StorageError{Code: "BACKEND_UNAVAILABLE", Message: "Storage backend unavailable"}
+	ErrHandlerClosed      = &StorageError{Code: "HANDLER_CLOSED", Message: "Handler is closed"}
+	ErrRegistryClosed     = &StorageError{Code: "REGISTRY_CLOSED", Message: "Registry is closed"}
+
+	ErrDownloadLimitExceeded = &StorageError{Code: "DOWNLOAD_LIMIT_EXCEEDED", Message: "Download limit exceeded"}
+	ErrRateLimited           = &StorageError{Code: "RATE_LIMITED", Message: "Rate limit exceeded"}
+	ErrInvalidDownloadToken  = &StorageError{Code: "INVALID_DOWNLOAD_TOKEN", Message: "Invalid download token"}
+	ErrDownloadTokenExpired  = &StorageError{Code: "DOWNLOAD_TOKEN_EXPIRED", Message: "Download token expired"}
+	ErrEncryptionKeyMismatch = &StorageError{Code: "ENCRYPTION_KEY_MISMATCH", Message: "File is not encrypted with the expected key"}
+	ErrConflict              = &StorageError{Code: "CONFLICT", Message: "File exists or was changed"}
+	ErrNotScanned            = &StorageError{Code: "NOT_SCANNED", Message: "File has not passed its malware scan"}
+	ErrInvalidURL            = &StorageError{Code: "INVALID_URL", Message: "URL cannot be fetched"}
+	ErrFetchFailed           = &StorageError{Code: "FETCH_FAILED", Message: "Failed to fetch file from URL"}
+	ErrShareNotFound         = &StorageError{Code: "SHARE_NOT_FOUND", Message: "Share not found"}
+	ErrLinkNotFound          = &StorageError{Code: "LINK_NOT_FOUND", Message: "Link not found"}
+	ErrLinkExpired           = &StorageError{Code: "LINK_EXPIRED", Message: "Link expired"}
+	ErrLinkPasswordRequired  = &StorageError{Code: "LINK_PASSWORD_REQUIRED", Message: "Link requires a password"}
+	ErrInvalidLinkPassword   = &StorageError{Code: "INVALID_LINK_PASSWORD", Message: "Invalid link password"}
+	ErrInvalidManifestToken  = &StorageError{Code: "INVALID_MANIFEST_TOKEN", Message: "Invalid upload manifest token"}
+	ErrManifestExpired       = &StorageError{Code: "MANIFEST_EXPIRED", Message: "Upload manifest expired"}
+	ErrManifestIncomplete    = &StorageError{Code: "MANIFEST_INCOMPLETE", Message: "Files of the upload manifest are missing"}
 )
+
+// WithRequestID returns err marked with the ID of the request it occurred in; storage errors are
+// copied with RequestID set, so errors.Is matches them as before, other errors are wrapped
+func WithRequestID(err error, requestID string) error {
+	if err == nil || requestID == "" {
+		return err
+	}
+	var storageErr *StorageError
+	if stderrors.As(err, &storageErr) && storageErr.RequestID != "" {
+		return err
+	}
+	if storageErr, ok := err.(*StorageError); ok {
+		marked := *storageErr
+		marked.RequestID = requestID
+		return &marked
+	}
+	return fmt.Errorf("%w (request %s)", err, requestID)
+}
+
+// Code returns the code of the first storage error in err's chain, or "" for other errors
+func Code(err error) string {
+	var storageErr *StorageError
+	if stderrors.As(err, &storageErr) {
+		return storageErr.Code
+	}
+	return ""
+}
+
+// httpStatus maps error codes to HTTP status codes, codes not listed are server errors
+// unless they start with INVALID_ or VALIDATION_
+var httpStatus = map[string]int{
+	"FILE_NOT_FOUND":      http.StatusNotFound,
+	"BUCKET_NOT_FOUND":    http.StatusNotFound,
+	"CATEGORY_NOT_FOUND":  http.StatusNotFound,
+	"HANDLER_NOT_FOUND":   http.StatusNotFound,
+	"THUMBNAIL_NOT_FOUND": http.StatusNotFound,
+	"TENANT_NOT_FOUND":    http.StatusNotFound,
+	"JOB_NOT_FOUND":       http.StatusNotFound,
+	"NOT_FOUND":           http.StatusNotFound,
+
+	"ACCESS_DENIED":           http.StatusForbidden,
+	"ENCRYPTION_KEY_MISMATCH": http.StatusForbidden,
+	"NOT_SCANNED":             http.StatusForbidden,
+
+	"UNAUTHORIZED":           http.StatusUnauthorized,
+	"INVALID_TOKEN":          http.StatusUnauthorized,
+	"INVALID_DOWNLOAD_TOKEN": http.StatusUnauthorized,
+	"DOWNLOAD_TOKEN_EXPIRED": http.StatusUnauthorized,
+
+	"FILE_TOO_LARGE":             http.StatusRequestEntityTooLarge,
+	"THUMBNAIL_SOURCE_TOO_LARGE": http.StatusRequestEntityTooLarge,
+	"UNSUPPORTED_TYPE":           http.StatusUnsupportedMediaType,
+	"INVALID_RANGE":              http.StatusRequestedRangeNotSatisfiable,
+	"METHOD_NOT_ALLOWED":         http.StatusMethodNotAllowed,
+	"HANDLER_EXISTS":             http.StatusConflict,
+	"JOB_CANCELLED":              http.StatusConflict,
+	"CONFLICT":                   http.StatusConflict,
+	"BATCH_SIZE_EXCEEDED":        http.StatusBadRequest,
+	"TENANT_REQUIRED":            http.StatusBadRequest,
+
+	"RATE_LIMITED":            http.StatusTooManyRequests,
+	"DOWNLOAD_LIMIT_EXCEEDED": http.StatusTooManyRequests,
+
+	"HANDLER_CLOSED":      http.StatusServiceUnavailable,
+	"REGISTRY_CLOSED":     http.StatusServiceUnavailable,
+	"QUEUE_FULL":          http.StatusServiceUnavailable,
+	"QUEUE_CLOSED":        http.StatusServiceUnavailable,
+	"NOT_INITIALIZED":     http.StatusServiceUnavailable,
+	"INSUFFICIENT_MEMORY": http.StatusServiceUnavailable,
+	"BACKEND_UNAVAILABLE": http.StatusServiceUnavailable,
+
+	// The remote server of UploadFromURL failed
+	"FETCH_FAILED": http.StatusBadGateway,
+
+	// Configuration and key management problems are not the client's fault
+	"INVALID_CONFIG": http.StatusInternalServerError,
+	"INVALID_KEY":    http.StatusInternalServerError,
+	"INVALID_SECRET": http.StatusInternalServerError,
+}
+
+// ErrorToHTTPStatus returns the HTTP status code API layers should answer an error with,
+// e.g. 404 for FILE_NOT_FOUND, 403 for ACCESS_DENIED and 413 for FILE_TOO_LARGE
+// Errors without a code are internal errors, except for expired contexts
+func ErrorToHTTPStatus(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+
+	code := Code(err)
+	if code == "" {
+		if stderrors.Is(err, context.DeadlineExceeded) {
+			return http.StatusGatewayTimeout
+		}
+		return http.StatusInternalServerError
+	}
+	if status, ok := httpStatus[code]; ok {
+		return status
+	}
+	if strings.HasPrefix(code, "INVALID_") || strings.HasPrefix(code, "VALIDATION_") {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,138 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// TopicMapping resolves the Kafka topic or NATS subject an event is published to
+// Lookup order is Overrides["<handler>/<type>"], Handlers[handler], Types[type], then Default.
+// Topics may contain {handler} and {type} placeholders, e.g. "storage.{handler}.{type}"
+type TopicMapping struct {
+	Default   string            `json:"default"`
+	Types     map[Type]string   `json:"types,omitempty"`
+	Handlers  map[string]string `json:"handlers,omitempty"`
+	Overrides map[string]string `json:"overrides,omitempty"`
+}
+
+// Topic returns the destination for an event
+func (m TopicMapping) Topic(event *Event) string {
+	topic, ok := m.Overrides[event.Handler+"/"+string(event.Type)]
+	if !ok {
+		topic, ok = m.Handlers[event.Handler]
+	}
+	if !ok {
+		topic, ok = m.Types[event.Type]
+	}
+	if !ok {
+		topic = m.Default
+	}
+	if topic == "" {
+		topic = "storage.events"
+	}
+
+	handler := event.Handler
+	if handler == "" {
+		handler = "default"
+	}
+	return strings.NewReplacer("{handler}", handler, "{type}", string(event.Type)).Replace(topic)
+}
+
+// KafkaProducer is the subset of a Kafka client used by KafkaSink
+// Adapting e.g. segmentio/kafka-go takes a few lines:
+//
+//	func (p producer) Produce(ctx context.Context, topic string, key, value []byte, headers map[string]string) error {
+//		msg := kafka.Message{Topic: topic, Key: key, Value: value}
+//		for k, v := range headers {
+//			msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+//		}
+//		return p.writer.WriteMessages(ctx, msg)
+//	}
+type KafkaProducer interface {
+	Produce(ctx context.Context, topic string, key, value []byte, headers map[string]string) error
+}
+
+// KafkaSink publishes events as JSON messages keyed by file key, so events of a file keep their order
+type KafkaSink struct {
+	producer KafkaProducer
+	mapping  TopicMapping
+}
+
+// NewKafkaSink creates a new Kafka sink
+func NewKafkaSink(producer KafkaProducer, mapping TopicMapping) *KafkaSink {
+	return &KafkaSink{
+		producer: producer,
+		mapping:  mapping,
+	}
+}
+
+// Publish sends the event to its mapped topic
+func (s *KafkaSink) Publish(ctx context.Context, event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	headers := map[string]string{
+		"event-id":   event.ID,
+		"event-type": string(event.Type),
+	}
+	if err := s.producer.Produce(ctx, s.mapping.Topic(event), []byte(event.FileKey), body, headers); err != nil {
+		return fmt.Errorf("failed to publish event to Kafka: %w", err)
+	}
+	return nil
+}
+
+// NATSSink publishes events as JSON messages to NATS subjects
+// With a JetStream context publishes are acknowledged by the server and deduplicated by event ID
+type NATSSink struct {
+	conn    *nats.Conn
+	js      jetstream.JetStream
+	mapping TopicMapping
+}
+
+// NewNATSSink creates a sink using core NATS (fire and forget)
+func NewNATSSink(conn *nats.Conn, mapping TopicMapping) *NATSSink {
+	return &NATSSink{
+		conn:    conn,
+		mapping: mapping,
+	}
+}
+
+// NewJetStreamSink creates a sink publishing to JetStream streams
+func NewJetStreamSink(js jetstream.JetStream, mapping TopicMapping) *NATSSink {
+	return &NATSSink{
+		js:      js,
+		mapping: mapping,
+	}
+}
+
+// Publish sends the event to its mapped subject
+func (s *NATSSink) Publish(ctx context.Context, event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	msg := nats.NewMsg(s.mapping.Topic(event))
+	msg.Data = body
+	msg.Header.Set("Event-Type", string(event.Type))
+	msg.Header.Set(nats.MsgIdHdr, event.ID)
+
+	if s.js != nil {
+		if _, err := s.js.PublishMsg(ctx, msg); err != nil {
+			return fmt.Errorf("failed to publish event to JetStream: %w", err)
+		}
+		return nil
+	}
+
+	if err := s.conn.PublishMsg(msg); err != nil {
+		return fmt.Errorf("failed to publish event to NATS: %w", err)
+	}
+	return nil
+}
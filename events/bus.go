@@ -0,0 +1,137 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/darmawan01/storage/logger"
+	"github.com/google/uuid"
+)
+
+// Bus fans events out to in-process subscribers and external sinks
+// Delivery happens in the background so publishers are never blocked by slow sinks
+type Bus struct {
+	subscribers map[int]*subscription
+	sinks       []*sinkEntry
+	nextID      int
+	closed      bool
+	wg          sync.WaitGroup
+	logger      logger.Logger
+	mutex       sync.RWMutex
+}
+
+// subscription is an in-process event handler
+type subscription struct {
+	types   []Type
+	handler func(ctx context.Context, event *Event)
+}
+
+// sinkEntry is an external sink with its event filter
+type sinkEntry struct {
+	types []Type
+	sink  Sink
+}
+
+// NewBus creates a new event bus logging failed deliveries to log (logger.Default() when nil)
+func NewBus(log logger.Logger) *Bus {
+	return &Bus{
+		subscribers: make(map[int]*subscription),
+		logger:      logger.OrDefault(log),
+	}
+}
+
+// Subscribe registers an in-process handler for the given event types (all types when none are given)
+// Call the returned func to unsubscribe
+func (b *Bus) Subscribe(handler func(ctx context.Context, event *Event), types ...Type) func() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = &subscription{types: types, handler: handler}
+
+	return func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// AddSink registers an external sink for the given event types (all types when none are given)
+func (b *Bus) AddSink(sink Sink, types ...Type) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.sinks = append(b.sinks, &sinkEntry{types: types, sink: sink})
+}
+
+// Publish fills in the event ID and timestamp and delivers the event in the background
+func (b *Bus) Publish(ctx context.Context, event *Event) {
+	if event.ID == "" {
+		event.ID = uuid.NewString()
+	}
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if b.closed {
+		return
+	}
+
+	// Deliveries outlive the publishing request
+	ctx = context.WithoutCancel(ctx)
+
+	for _, sub := range b.subscribers {
+		if !matches(sub.types, event.Type) {
+			continue
+		}
+		b.wg.Add(1)
+		go func(handler func(context.Context, *Event)) {
+			defer b.wg.Done()
+			handler(ctx, event)
+		}(sub.handler)
+	}
+
+	for _, entry := range b.sinks {
+		if !matches(entry.types, event.Type) {
+			continue
+		}
+		b.wg.Add(1)
+		go func(sink Sink) {
+			defer b.wg.Done()
+			if err := sink.Publish(ctx, event); err != nil {
+				b.logger.Warn("failed to deliver event", map[string]interface{}{
+					"event_id":   event.ID,
+					"event_type": event.Type,
+					"handler":    event.Handler,
+					"error":      err,
+				})
+			}
+		}(entry.sink)
+	}
+}
+
+// Close stops accepting events and waits for pending deliveries until ctx expires
+func (b *Bus) Close(ctx context.Context) error {
+	b.mutex.Lock()
+	b.closed = true
+	b.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("failed to deliver pending events: %w", ctx.Err())
+	}
+}
@@ -0,0 +1,65 @@
+package events
+
+import (
+	"context"
+	"time"
+)
+
+// Type identifies what happened
+type Type string
+
+const (
+	TypeFileUploaded     Type = "file.uploaded"
+	TypeFileDeleted      Type = "file.deleted"
+	TypeThumbnailsReady  Type = "thumbnails.ready"
+	TypeValidationFailed Type = "validation.failed"
+	TypeMetadataUpdated  Type = "metadata.updated"
+	TypeUserDataErased   Type = "user_data.erased"
+	TypeFileReplaced     Type = "file.replaced"
+	TypeFileAppended     Type = "file.appended"
+	TypeFilePatched      Type = "file.patched"
+	TypeFileInfected     Type = "file.infected"
+	TypeFileApproved     Type = "file.approved"
+	TypeFileRejected     Type = "file.rejected"
+	TypeFileFlagged      Type = "file.flagged"
+	TypeVideoReady       Type = "video.ready"
+)
+
+// Event is a structured notification about a storage operation
+type Event struct {
+	ID         string                 `json:"id"`
+	Type       Type                   `json:"type"`
+	Handler    string                 `json:"handler,omitempty"` // Name of the handler that emitted the event
+	Category   string                 `json:"category,omitempty"`
+	FileKey    string                 `json:"file_key,omitempty"`
+	UserID     string                 `json:"user_id,omitempty"`
+	RequestID  string                 `json:"request_id,omitempty"` // Request of the operation, see requestid
+	Data       map[string]interface{} `json:"data,omitempty"`
+	OccurredAt time.Time              `json:"occurred_at"`
+}
+
+// Sink delivers events to an external system
+type Sink interface {
+	Publish(ctx context.Context, event *Event) error
+}
+
+// SinkFunc adapts a function to the Sink interface
+type SinkFunc func(ctx context.Context, event *Event) error
+
+// Publish calls f(ctx, event)
+func (f SinkFunc) Publish(ctx context.Context, event *Event) error {
+	return f(ctx, event)
+}
+
+// matches reports whether an event type is in the filter; an empty filter matches everything
+func matches(types []Type, eventType Type) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
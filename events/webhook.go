@@ -0,0 +1,143 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// SignatureHeader carries "sha256=<hex HMAC of timestamp.body>"
+	SignatureHeader = "X-Storage-Signature"
+	// TimestampHeader carries the unix time the delivery was signed at
+	TimestampHeader = "X-Storage-Timestamp"
+	// EventTypeHeader carries the event type
+	EventTypeHeader = "X-Storage-Event"
+)
+
+// WebhookConfig represents a webhook endpoint configuration
+type WebhookConfig struct {
+	URL        string        `json:"url"`
+	Secret     string        `json:"secret,omitempty"`      // HMAC-SHA256 signing secret, deliveries are unsigned when empty
+	Events     []Type        `json:"events,omitempty"`      // Event types to deliver, all when empty
+	MaxRetries int           `json:"max_retries,omitempty"` // Retries after the first attempt, default 3, negative disables retries
+	RetryDelay time.Duration `json:"retry_delay,omitempty"` // Initial backoff, doubled after every attempt, default 1s
+	Timeout    time.Duration `json:"timeout,omitempty"`     // Per attempt timeout, default 10s
+}
+
+// WebhookSink posts events as JSON to an HTTP endpoint
+type WebhookSink struct {
+	config WebhookConfig
+	client *http.Client
+}
+
+// NewWebhookSink creates a new webhook sink
+func NewWebhookSink(config WebhookConfig) *WebhookSink {
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 3
+	} else if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	return &WebhookSink{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+	}
+}
+
+// Publish delivers the event, retrying with exponential backoff on network errors and 5xx/429 responses
+func (s *WebhookSink) Publish(ctx context.Context, event *Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	delay := s.config.RetryDelay
+	var lastErr error
+	for attempt := 0; attempt <= s.config.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(delay):
+				delay *= 2
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		retryable, err := s.send(ctx, event, body)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retryable {
+			break
+		}
+	}
+
+	return fmt.Errorf("webhook delivery to %s failed: %w", s.config.URL, lastErr)
+}
+
+// send performs a single delivery attempt
+func (s *WebhookSink) send(ctx context.Context, event *Event, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventTypeHeader, string(event.Type))
+
+	if s.config.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, "sha256="+Sign(s.config.Secret, timestamp, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retryable, fmt.Errorf("unexpected status %d", resp.StatusCode)
+}
+
+// Sign returns the hex HMAC-SHA256 of "timestamp.body"
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks a webhook delivery on the receiving side
+// maxAge rejects deliveries signed too long ago, 0 disables the check
+func VerifySignature(secret, signature, timestamp string, body []byte, maxAge time.Duration) bool {
+	if maxAge > 0 {
+		unix, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || time.Since(time.Unix(unix, 0)) > maxAge {
+			return false
+		}
+	}
+
+	expected := "sha256=" + Sign(secret, timestamp, body)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
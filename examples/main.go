@@ -7,15 +7,21 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 
+	"github.com/darmawan01/storage/auth"
 	"github.com/darmawan01/storage/category"
+	"github.com/darmawan01/storage/clientinfo"
 	"github.com/darmawan01/storage/config"
 	"github.com/darmawan01/storage/handler"
 	"github.com/darmawan01/storage/interfaces"
 	"github.com/darmawan01/storage/middleware"
 	"github.com/darmawan01/storage/registry"
+	"github.com/darmawan01/storage/requestid"
 
 	_ "github.com/darmawan01/storage/docs" // This will be generated
 	"github.com/gin-gonic/gin"
@@ -194,24 +200,6 @@ type DeleteResult struct {
 	Error   string `json:"error,omitempty" example:"File not found"`
 }
 
-// PerformanceStats represents performance monitoring statistics
-type PerformanceStats struct {
-	TotalOperations int64                  `json:"total_operations" example:"1250"`
-	SuccessRate     float64                `json:"success_rate" example:"0.95"`
-	AvgLatency      float64                `json:"avg_latency_ms" example:"125.5"`
-	Throughput      ThroughputStats        `json:"throughput"`
-	OperationStats  map[string]interface{} `json:"operation_stats"`
-	Uptime          float64                `json:"uptime_seconds" example:"3600.0"`
-}
-
-// ThroughputStats represents throughput statistics
-type ThroughputStats struct {
-	FilesProcessed int64   `json:"files_processed" example:"500"`
-	BytesProcessed int64   `json:"bytes_processed" example:"52428800"`
-	FilesPerSecond float64 `json:"files_per_second" example:"0.14"`
-	BytesPerSecond float64 `json:"bytes_per_second" example:"14563.56"`
-}
-
 func main() {
 	// Initialize storage registry
 	initStorage()
@@ -223,7 +211,8 @@ func main() {
 	router.Use(func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-User-ID")
+		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization, X-User-ID, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -233,6 +222,11 @@ func main() {
 		c.Next()
 	})
 
+	// Request IDs correlating storage logs, audit events and jobs with API requests
+	router.Use(requestid.Gin())
+	// Client address and user agent for audit logs and access records
+	router.Use(clientinfo.Gin())
+
 	// Swagger documentation
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
@@ -242,6 +236,22 @@ func main() {
 		// Health check
 		api.GET("/health", healthCheck)
 
+		// Metrics snapshot
+		api.GET("/metrics", metrics)
+
+		// JWT authentication for the file routes when JWT_SECRET is set,
+		// otherwise the demo X-User-ID header is trusted
+		if secret := os.Getenv("JWT_SECRET"); secret != "" {
+			authenticator, err := auth.New(auth.Config{
+				Secret: []byte(secret),
+				Issuer: os.Getenv("JWT_ISSUER"),
+			})
+			if err != nil {
+				log.Fatalf("Failed to configure JWT authentication: %v", err)
+			}
+			api.Use(authenticator.Gin())
+		}
+
 		// Cat file operations
 		cats := api.Group("/cats")
 		{
@@ -312,7 +322,28 @@ func main() {
 	log.Println("  Batch Upload: POST /api/v1/dogs/{id}/batch/upload")
 	log.Println("  Batch Download: POST /api/v1/dogs/{id}/batch/download")
 	log.Println("  Batch Delete: POST /api/v1/dogs/{id}/batch/delete")
-	router.Run(":8080")
+
+	server := &http.Server{Addr: ":8080", Handler: router}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	// Stop taking requests, then let storage finish uploads and queued thumbnails
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	log.Println("Shutting down...")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Server shutdown failed: %v", err)
+	}
+	if err := storageRegistry.Shutdown(ctx); err != nil {
+		log.Printf("Storage shutdown failed: %v", err)
+	}
 }
 
 // initStorage initializes the storage registry
@@ -493,8 +524,8 @@ func initStorage() {
 // @Tags         System
 // @Accept       json
 // @Produce      json
-// @Success      200 {object} map[string]interface{} "Service is healthy"
-// @Failure      503 {object} map[string]interface{} "Service is unhealthy"
+// @Success      200 {object} registry.HealthReport "Service is healthy"
+// @Failure      503 {object} registry.HealthReport "Service is unhealthy"
 // @Router       /health [get]
 func healthCheck(c *gin.Context) {
 	if storageRegistry == nil {
@@ -510,20 +541,33 @@ func healthCheck(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel()
 
-	if err := storageRegistry.HealthCheck(ctx); err != nil {
+	// Degraded components still serve requests, only down ones fail readiness
+	report := storageRegistry.HealthReport(ctx)
+	if !report.Ready() {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// Metrics godoc
+// @Summary      Metrics
+// @Description  Get a JSON snapshot of registry, handler, cache and async job statistics
+// @Tags         System
+// @Produce      json
+// @Success      200 {object} registry.MetricsSnapshot "Metrics snapshot"
+// @Failure      503 {object} registry.MetricsSnapshot "Service is unhealthy"
+// @Router       /metrics [get]
+func metrics(c *gin.Context) {
+	if storageRegistry == nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"status": "unhealthy",
-			"error":  err.Error(),
-			"mode":   "demo",
+			"error":  "storage not initialized",
 		})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"status": "healthy",
-		"time":   time.Now().Format(time.RFC3339),
-		"mode":   "production",
-	})
+	storageRegistry.MetricsHandler(registry.MetricsConfig{}).ServeHTTP(c.Writer, c.Request)
 }
 
 func uploadCatFile(c *gin.Context) {
@@ -778,10 +822,14 @@ func getCatFile(c *gin.Context) {
 		fileKey = decodedFileID
 	}
 
-	// Create download request
+	// Create download request, browsers revalidate cached copies with their validators
 	downloadReq := &interfaces.DownloadRequest{
-		FileKey: fileKey,
-		UserID:  getCurrentUserID(c),
+		FileKey:     fileKey,
+		UserID:      getCurrentUserID(c),
+		IfNoneMatch: c.GetHeader("If-None-Match"),
+	}
+	if since, err := http.ParseTime(c.GetHeader("If-Modified-Since")); err == nil {
+		downloadReq.IfModifiedSince = since
 	}
 
 	// Download file
@@ -799,6 +847,12 @@ func getCatFile(c *gin.Context) {
 	}
 
 	// Set headers
+	c.Header("ETag", response.ETag)
+	c.Header("Last-Modified", response.LastModified.UTC().Format(http.TimeFormat))
+	if response.NotModified {
+		c.Status(http.StatusNotModified)
+		return
+	}
 	c.Header("Content-Type", response.ContentType)
 	c.Header("Content-Length", strconv.FormatInt(response.FileSize, 10))
 
@@ -1273,9 +1327,14 @@ func streamFile(c *gin.Context) {
 	})
 }
 
-// Helper function to get current user ID (mock implementation)
+// Helper function to get current user ID
 func getCurrentUserID(c *gin.Context) string {
-	// In a real implementation, extract from JWT token
+	// Identity from the JWT middleware
+	if userID := auth.UserID(c.Request.Context()); userID != "" {
+		return userID
+	}
+
+	// Demo fallback without JWT_SECRET
 	userID := c.GetHeader("X-User-ID")
 	if userID == "" {
 		userID = "demo-user-123" // Default for demo
@@ -1611,8 +1670,10 @@ func batchUploadCatFiles(c *gin.Context) {
 
 	// Create batch upload request
 	batchReq := &interfaces.BatchUploadRequest{
-		Files:  batchFiles,
-		UserID: userID,
+		Files:      batchFiles,
+		EntityType: "cat",
+		EntityID:   catID,
+		UserID:     userID,
 	}
 
 	// Perform batch upload
@@ -2061,8 +2122,10 @@ func batchUploadDogFiles(c *gin.Context) {
 
 	// Create batch upload request
 	batchReq := &interfaces.BatchUploadRequest{
-		Files:  batchFiles,
-		UserID: userID,
+		Files:      batchFiles,
+		EntityType: "dog",
+		EntityID:   dogID,
+		UserID:     userID,
 	}
 
 	// Perform batch upload
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/darmawan01/storage/clientinfo"
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// GetAccessHistory returns who downloaded, streamed or previewed a file and when, newest first
+// Only reads made while an AccessLog was configured are known; requests of a tenant only see its files
+func (h *Handler) GetAccessHistory(ctx context.Context, fileKey string) ([]interfaces.AccessRecord, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	accessLog := h.config().AccessLog
+	if accessLog == nil {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "No access log configured"}
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.checkTenantKey(t, fileKey); err != nil {
+		return nil, err
+	}
+
+	history, err := accessLog.History(ctx, fileKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access history: %w", err)
+	}
+	return history, nil
+}
+
+// recordAccess adds a read of a file to the access log, failures only log a warning
+func (h *Handler) recordAccess(ctx context.Context, record *interfaces.AccessRecord) {
+	config := h.config()
+	if config.AccessLog == nil && config.AccessCallback == nil {
+		return
+	}
+	record.Time = time.Now()
+	if record.IPAddress == "" && record.UserAgent == "" {
+		client := clientinfo.FromContext(ctx)
+		record.IPAddress = client.IPAddress
+		record.UserAgent = client.UserAgent
+	}
+	if config.AccessLog != nil {
+		if err := config.AccessLog.Record(ctx, record); err != nil {
+			h.logger.Warn("failed to record file access", map[string]interface{}{
+				"handler":  h.Name,
+				"file_key": record.FileKey,
+				"error":    err,
+			})
+		}
+	}
+	if config.AccessCallback != nil {
+		h.deliver(ctx, &Delivery{Target: DeliveryAccessCallback, FileKey: record.FileKey, Access: record})
+	}
+}
+
+// forgetAccess removes the access history of a deleted file
+func (h *Handler) forgetAccess(ctx context.Context, fileKey string) {
+	accessLog := h.config().AccessLog
+	if accessLog == nil {
+		return
+	}
+	if err := accessLog.Delete(ctx, fileKey); err != nil {
+		h.logger.Warn("failed to delete access history", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
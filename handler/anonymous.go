@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/darmawan01/storage/category"
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/events"
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// Scan states of files of categories accepting anonymous uploads, stored as scan-status metadata
+const (
+	scanPending  = "pending"
+	scanClean    = "clean"
+	scanInfected = "infected"
+)
+
+// anonymousDefaults fills the unset limits of anonymous uploads
+func anonymousDefaults(c category.AnonymousConfig) category.AnonymousConfig {
+	if c.RequestsPerMinute == 0 {
+		c.RequestsPerMinute = 10
+	}
+	if c.BytesPerMinute == 0 {
+		c.BytesPerMinute = 50 * 1024 * 1024
+	}
+	if c.ExpiryDays == 0 {
+		c.ExpiryDays = 7
+	}
+	return c
+}
+
+// anonymousLimiter limits the uploads of a category without a user per client address, it runs in
+// front of the middlewares of the category; called with configMutex held
+func (h *Handler) anonymousLimiter(categoryName string, c category.AnonymousConfig) middleware.Middleware {
+	c = anonymousDefaults(c)
+	return middleware.NewRateLimitMiddleware(middleware.RateLimitConfig{
+		Limits: map[string]middleware.RateLimit{
+			"upload": {RequestsPerMinute: c.RequestsPerMinute, BytesPerMinute: c.BytesPerMinute},
+		},
+		PerAddress:    true,
+		AnonymousOnly: true,
+		Limiter:       h.rateLimiter,
+	}, "anonymous:"+categoryName)
+}
+
+// anonymousUpload applies the abuse controls of a category to an upload: every file is scanned
+// before it can be read, anonymous uploads are marked for moderation and tagged to expire
+// It returns the tags of the upload
+func anonymousUpload(c category.AnonymousConfig, userID string, userMetadata, defaultTags map[string]string) map[string]string {
+	if !c.Enabled {
+		return defaultTags
+	}
+	userMetadata["scan-status"] = scanPending
+	if userID != "" {
+		return defaultTags
+	}
+	userMetadata[middleware.ModerationStatusMetadataKey] = middleware.ModerationPending
+
+	c = anonymousDefaults(c)
+	if c.ExpiryDays <= 0 {
+		return defaultTags
+	}
+	userTags := make(map[string]string, len(defaultTags)+1)
+	for key, value := range defaultTags {
+		userTags[key] = value
+	}
+	userTags[expiryTag] = strconv.Itoa(c.ExpiryDays)
+	return userTags
+}
+
+// scanned reports whether a file may be read, files of categories without scans have no scan status
+func scanned(scanStatus string) bool {
+	return scanStatus != scanPending && scanStatus != scanInfected
+}
+
+// scanFile queues the malware scan of a stored file
+func (h *Handler) scanFile(ctx context.Context, bucketName, fileKey string) {
+	if err := h.SubmitJob(ctx, &jobs.Job{Type: jobs.TypeAVScan, FileKey: fileKey, BucketName: bucketName}); err != nil {
+		// The file stays unreadable until it is scanned
+		h.logger.Warn("failed to queue malware scan", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
+
+// handleScanJob scans a file with the Scanner of the handler, clean files become readable and
+// infected ones are deleted
+func (h *Handler) handleScanJob(ctx context.Context, job *jobs.Job) error {
+	scanner := h.config().Scanner
+	if scanner == nil {
+		return fmt.Errorf("no scanner configured")
+	}
+
+	sse, err := h.keyServerSideEncryption(job.FileKey)
+	if err != nil {
+		return err
+	}
+	objInfo, err := h.statObject(ctx, job.BucketName, job.FileKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			// Deleted before its scan
+			return nil
+		}
+		return fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	// Middlewares may have encrypted or compressed the file, the scanner gets the original
+	fileData, err := h.openFile(ctx, job.BucketName, &objInfo, objInfo.UserMetadata["Uploaded-By"], 0, middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size)-1)
+	if err != nil {
+		return err
+	}
+	result, err := scanner.Scan(ctx, fileData)
+	if closer, ok := fileData.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to scan object: %w", err)
+	}
+	job.Result = map[string]interface{}{
+		"clean":     result.Clean,
+		"signature": result.Signature,
+		"engine":    result.Engine,
+	}
+
+	categoryName := h.fileKeyInfo(&objInfo).Category
+	if !result.Clean {
+		return h.removeInfected(ctx, job.BucketName, &objInfo, categoryName, result)
+	}
+
+	// A newer version replaced during the scan keeps its pending state
+	userMetadata := make(map[string]string, len(objInfo.UserMetadata)+1)
+	for key, value := range objInfo.UserMetadata {
+		userMetadata[key] = value
+	}
+	userMetadata["Scan-Status"] = scanClean
+	userMetadata["Content-Type"] = objInfo.ContentType
+	if err := h.replaceMetadata(ctx, job.BucketName, job.FileKey, objInfo.ETag, userMetadata); err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			return nil
+		}
+		return fmt.Errorf("failed to record scan of %s: %w", job.FileKey, err)
+	}
+	h.invalidate(ctx, job.FileKey)
+	h.replicate(ctx, job.FileKey, false)
+	return nil
+}
+
+// removeInfected deletes an infected file with its thumbnails and announces it
+func (h *Handler) removeInfected(ctx context.Context, bucketName string, objInfo *minio.ObjectInfo, categoryName string, result *jobs.ScanResult) error {
+	if err := h.removeObject(ctx, bucketName, objInfo.Key, minio.RemoveObjectOptions{}); err != nil {
+		return errors.Wrap(errors.ErrDeleteFailed, err)
+	}
+	if err := h.deleteThumbnails(ctx, categoryName, objInfo.Key); err != nil {
+		h.logger.Warn("failed to delete thumbnails", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": objInfo.Key,
+			"error":    err,
+		})
+	}
+	h.deleted(ctx, objInfo.Key, "")
+
+	h.logger.Warn("deleted infected file", map[string]interface{}{
+		"handler":   h.Name,
+		"file_key":  objInfo.Key,
+		"signature": result.Signature,
+	})
+	h.publish(ctx, events.TypeFileInfected, categoryName, objInfo.Key, objInfo.UserMetadata["Uploaded-By"], map[string]interface{}{
+		"signature": result.Signature,
+		"engine":    result.Engine,
+	})
+	return nil
+}
@@ -0,0 +1,314 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/events"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// minComposeSize is the smallest object S3 composes server-side, except for the last source
+const minComposeSize = 5 << 20
+
+// Append adds data to the end of a stored file, e.g. rows of an exported report assembled in chunks
+// The data is stored as a temporary part next to the file and composed with it server-side, so the
+// file is not transferred; files smaller than 5 MiB, which S3 cannot compose, are rewritten through
+// the handler. Files encrypted or compressed by the middlewares cannot be appended to
+// Appends pass the middlewares of the category as operation "append", the security middleware
+// authorizes them as middleware.ActionReplace; WithIfMatch guards against concurrent writers and a
+// file changed during the append fails with errors.ErrConflict, and one that would outgrow the size
+// limits of its category or tenant with errors.ErrFileTooLarge
+func (h *Handler) Append(ctx context.Context, req *interfaces.AppendRequest, opts ...Option) (*interfaces.AppendResponse, error) {
+	fileSize, etag, err := h.writeRange(ctx, "append", req.FileKey, req.UserID, -1, req.FileData, req.FileSize, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &interfaces.AppendResponse{
+		FileKey:  req.FileKey,
+		FileSize: fileSize,
+		ETag:     etag,
+	}, nil
+}
+
+// writeRange writes size bytes of data at offset of a stored file, -1 appends them, and returns the
+// new size and ETag of the file
+func (h *Handler) writeRange(ctx context.Context, operation, fileKey, userID string, offset int64, data io.Reader, size int64, opts []Option) (int64, string, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	defer done()
+
+	options := callOptions(opts)
+	ctx, cancel := callContext(ctx, options)
+	defer cancel()
+
+	if data == nil || size <= 0 {
+		return 0, "", &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "No data to write to " + fileKey}
+	}
+
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return 0, "", err
+	}
+	if err := h.checkTenantLimits(ctx, t, size); err != nil {
+		return 0, "", err
+	}
+
+	fileInfo, bucketName, err := h.findFile(ctx, fileKey)
+	if err != nil {
+		return 0, "", err
+	}
+	objInfo := fileInfo.(*minio.ObjectInfo)
+	if middleware.IsEncrypted(objInfo.UserMetadata) || middleware.IsCompressed(objInfo.UserMetadata) {
+		return 0, "", &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "File " + fileKey + " is encrypted or compressed and cannot be written in place"}
+	}
+	if options.IfMatch != "" && strings.Trim(objInfo.ETag, `"`) != options.IfMatch {
+		return 0, "", &errors.StorageError{Code: errors.ErrConflict.Code, Message: "File " + fileKey + " was changed"}
+	}
+	if offset < 0 {
+		offset = objInfo.Size
+	}
+	if offset > objInfo.Size {
+		return 0, "", &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Offset is beyond the end of " + fileKey}
+	}
+
+	// Size limits of the category and tenant apply to the file once written, not to the written data
+	fileSize := max(objInfo.Size, offset+size)
+	limits, err := h.categoryUploadLimits(ctx, h.fileKeyInfo(objInfo).Category)
+	if err != nil {
+		return 0, "", err
+	}
+	if limits.maxSize > 0 && fileSize > limits.maxSize {
+		return 0, "", &errors.StorageError{Code: errors.ErrFileTooLarge.Code, Message: fmt.Sprintf("File %s would grow to %d bytes, exceeding maximum %d", fileKey, fileSize, limits.maxSize)}
+	}
+
+	// The request carries the written data, not the stored file
+	chainReq := h.chainRequest(operation, objInfo, bucketName, userID)
+	chainReq.FileData = data
+	chainReq.FileSize = size
+	chainReq.Replace = true
+
+	// Sources other than the last one must be large enough to compose
+	composable := (offset == 0 || offset >= minComposeSize) && (offset+size >= objInfo.Size || size >= minComposeSize)
+
+	// The written data is scanned and moderated like uploads of the category
+	categoryConfig, _, _ := h.category(chainReq.Category)
+	userMetadata := writtenMetadata(objInfo, categoryConfig.Anonymous.Enabled, categoryConfig.Moderation.Enabled)
+
+	var info minio.UploadInfo
+	err = h.runChain(ctx, chainReq, func(ctx context.Context) error {
+		var err error
+		if composable {
+			info, err = h.composeRange(ctx, bucketName, objInfo, userMetadata, offset, chainReq.FileData, size)
+		} else {
+			info, err = h.rewriteRange(ctx, bucketName, objInfo, userMetadata, offset, chainReq.FileData, size)
+		}
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			return &errors.StorageError{Code: errors.ErrConflict.Code, Message: "File " + fileKey + " was changed", Err: err}
+		}
+		if err != nil {
+			return errors.Wrap(errors.ErrUploadFailed, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, "", err
+	}
+
+	h.invalidate(ctx, fileKey)
+	if categoryConfig.Anonymous.Enabled {
+		h.scanFile(ctx, bucketName, fileKey)
+	}
+	if categoryConfig.Classification.Enabled {
+		h.classifyFile(ctx, categoryConfig.Classification, bucketName, fileKey, objInfo.ContentType)
+	}
+	if categoryConfig.Preview.ConvertDocuments {
+		h.convertFile(ctx, bucketName, fileKey, objInfo.ContentType)
+	}
+	if categoryConfig.Audio.Enabled {
+		h.analyzeAudio(ctx, bucketName, fileKey, objInfo.ContentType)
+	}
+	if categoryConfig.Video.Transcode {
+		h.transcodeVideo(ctx, bucketName, fileKey, objInfo.ContentType)
+	}
+	h.replicate(ctx, fileKey, false)
+	h.indexFile(ctx, categoryConfig.OCR, fileKey, objInfo.ContentType, fileSize)
+	h.updateRecord(ctx, fileKey, func(record *interfaces.FileMetadata) {
+		record.FileSize = fileSize
+		record.Checksum = ""
+	})
+
+	eventType := events.TypeFileAppended
+	if operation != "append" {
+		eventType = events.TypeFilePatched
+	}
+	h.publish(ctx, eventType, chainReq.Category, fileKey, userID, map[string]interface{}{
+		"offset":       offset,
+		"written_size": size,
+		"file_size":    fileSize,
+	})
+	return fileSize, strings.Trim(info.ETag, `"`), nil
+}
+
+// writtenMetadata returns the user metadata of a file after a range was written, its checksum no longer
+// matches and files to scan or moderate are pending again
+func writtenMetadata(objInfo *minio.ObjectInfo, scan, moderate bool) map[string]string {
+	userMetadata := make(map[string]string, len(objInfo.UserMetadata)+1)
+	for key, value := range objInfo.UserMetadata {
+		if key != "Checksum-Sha256" {
+			userMetadata[key] = value
+		}
+	}
+	if scan {
+		userMetadata["Scan-Status"] = scanPending
+	}
+	// Labels of the previous content no longer apply
+	delete(userMetadata, "Nsfw-Score")
+	delete(userMetadata, "Content-Labels")
+	delete(userMetadata, "Ocr-Text")
+	delete(userMetadata, "Hls-Renditions")
+	for _, key := range audioMetadata {
+		delete(userMetadata, key)
+	}
+	if moderate {
+		userMetadata["Moderation-Status"] = middleware.ModerationPending
+		delete(userMetadata, "Moderated-By")
+		delete(userMetadata, "Moderated-At")
+		delete(userMetadata, "Moderation-Reason")
+	}
+	return userMetadata
+}
+
+// rewriteRange stores a file with data written at offset under its key, streaming the unchanged
+// ranges through the handler
+func (h *Handler) rewriteRange(ctx context.Context, bucketName string, objInfo *minio.ObjectInfo, userMetadata map[string]string, offset int64, data io.Reader, size int64) (minio.UploadInfo, error) {
+	sse, err := h.keyServerSideEncryption(objInfo.Key)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	fileTags, err := h.objectTags(ctx, bucketName, objInfo.Key)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	readers := []io.Reader{}
+	if offset > 0 {
+		getOptions := minio.GetObjectOptions{ServerSideEncryption: sse}
+		if err := getOptions.SetRange(0, offset-1); err != nil {
+			return minio.UploadInfo{}, err
+		}
+		head, _, err := h.getObject(ctx, bucketName, objInfo.Key, getOptions)
+		if err != nil {
+			return minio.UploadInfo{}, err
+		}
+		defer head.Close()
+		readers = append(readers, head)
+	}
+	readers = append(readers, data)
+	fileSize := offset + size
+	if fileSize < objInfo.Size {
+		getOptions := minio.GetObjectOptions{ServerSideEncryption: sse}
+		if err := getOptions.SetRange(fileSize, 0); err != nil {
+			return minio.UploadInfo{}, err
+		}
+		tail, _, err := h.getObject(ctx, bucketName, objInfo.Key, getOptions)
+		if err != nil {
+			return minio.UploadInfo{}, err
+		}
+		defer tail.Close()
+		readers = append(readers, tail)
+		fileSize = objInfo.Size
+	}
+
+	putOptions := minio.PutObjectOptions{
+		ContentType:          objInfo.ContentType,
+		ServerSideEncryption: sse,
+		UserMetadata:         userMetadata,
+		UserTags:             fileTags.ToMap(),
+	}
+	putOptions.SetMatchETag(objInfo.ETag)
+	return h.Client.PutObject(ctx, bucketName, objInfo.Key, h.throttleUpload(ctx, io.MultiReader(readers...)), fileSize, putOptions)
+}
+
+// composeRange stores the written data as a temporary part and composes the file from it and the
+// unchanged ranges server-side
+func (h *Handler) composeRange(ctx context.Context, bucketName string, objInfo *minio.ObjectInfo, userMetadata map[string]string, offset int64, data io.Reader, size int64) (minio.UploadInfo, error) {
+	sse, err := h.keyServerSideEncryption(objInfo.Key)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+	source := sse
+	if source != nil && source.Type() != encrypt.SSEC {
+		source = nil
+	}
+	fileTags, err := h.objectTags(ctx, bucketName, objInfo.Key)
+	if err != nil {
+		return minio.UploadInfo{}, err
+	}
+
+	partKey := objInfo.Key + ".part-" + uuid.NewString()
+	if _, err := h.putObject(ctx, bucketName, partKey, data, size, minio.PutObjectOptions{ServerSideEncryption: sse}); err != nil {
+		return minio.UploadInfo{}, err
+	}
+	defer func() {
+		// The part is useless once composed or failed, lifecycle rules can catch the ones left behind
+		if err := h.removeObject(context.WithoutCancel(ctx), bucketName, partKey, minio.RemoveObjectOptions{}); err != nil {
+			h.logger.Warn("failed to delete temporary part", map[string]interface{}{
+				"handler":  h.Name,
+				"file_key": objInfo.Key,
+				"part_key": partKey,
+				"error":    err,
+			})
+		}
+	}()
+
+	var sources []minio.CopySrcOptions
+	if offset > 0 {
+		sources = append(sources, minio.CopySrcOptions{
+			Bucket: bucketName, Object: objInfo.Key, MatchETag: objInfo.ETag, Encryption: source,
+			MatchRange: true, Start: 0, End: offset - 1,
+		})
+	}
+	sources = append(sources, minio.CopySrcOptions{Bucket: bucketName, Object: partKey, Encryption: source})
+	if offset+size < objInfo.Size {
+		sources = append(sources, minio.CopySrcOptions{
+			Bucket: bucketName, Object: objInfo.Key, MatchETag: objInfo.ETag, Encryption: source,
+			MatchRange: true, Start: offset + size, End: objInfo.Size - 1,
+		})
+	}
+
+	// Metadata is replaced as a whole, the content type is kept with it
+	composedMetadata := make(map[string]string, len(userMetadata)+1)
+	for key, value := range userMetadata {
+		composedMetadata[key] = value
+	}
+	composedMetadata["Content-Type"] = objInfo.ContentType
+
+	var info minio.UploadInfo
+	err = h.retry(ctx, "compose_object", func() error {
+		var err error
+		info, err = h.Client.ComposeObject(ctx,
+			minio.CopyDestOptions{
+				Bucket:          bucketName,
+				Object:          objInfo.Key,
+				Encryption:      sse,
+				ReplaceMetadata: true,
+				UserMetadata:    composedMetadata,
+				ReplaceTags:     true,
+				UserTags:        fileTags.ToMap(),
+			},
+			sources...,
+		)
+		return err
+	})
+	return info, err
+}
@@ -0,0 +1,321 @@
+package handler
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/category"
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/darmawan01/storage/tenant"
+	"github.com/minio/minio-go/v7"
+)
+
+// Archives are tar streams; every object is preceded by a JSON metadata sidecar
+// Object names are relative to the exported prefix, so archives can be imported under another one
+const (
+	archiveFilesDir    = "files/"
+	archiveDerivedDir  = "derived/"
+	archiveMetadataDir = "metadata/"
+)
+
+// archiveMetadata is the sidecar of an archived object
+type archiveMetadata struct {
+	Name         string            `json:"name"`
+	Bucket       string            `json:"bucket"`
+	ContentType  string            `json:"content_type"`
+	Size         int64             `json:"size"`
+	LastModified time.Time         `json:"last_modified"`
+	UserMetadata map[string]string `json:"user_metadata,omitempty"`
+}
+
+// ExportPrefix writes all files under a key prefix to dst as a tar archive, e.g. for backups,
+// migrations or GDPR data exports of an entity. Thumbnails and other derived files are included,
+// and every file is accompanied by its metadata. Files are written as stored, so files encrypted
+// by the encryption middleware stay encrypted. Wrap dst in a gzip.Writer for a compressed archive
+// Returns the number of files written
+func (h *Handler) ExportPrefix(ctx context.Context, prefix string, dst io.Writer) (int, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	prefix, err = h.archivePrefix(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	archive := tar.NewWriter(dst)
+	count := 0
+
+	buckets := []string{h.tenantBucket(t)}
+	for _, bucketName := range h.DerivedBuckets() {
+		if bucketName != buckets[0] {
+			buckets = append(buckets, bucketName)
+		}
+	}
+	for i, bucketName := range buckets {
+		dir := archiveFilesDir
+		if i > 0 {
+			dir = archiveDerivedDir
+		}
+
+		for object := range h.Client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+			if object.Err != nil {
+				return count, fmt.Errorf("failed to list files of %s: %w", prefix, object.Err)
+			}
+			if err := h.exportObject(ctx, archive, bucketName, dir, prefix, object.Key); err != nil {
+				return count, err
+			}
+			count++
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return count, fmt.Errorf("failed to write archive: %w", err)
+	}
+	return count, nil
+}
+
+// exportObject writes the metadata sidecar and data of an object to an archive
+func (h *Handler) exportObject(ctx context.Context, archive *tar.Writer, bucketName, dir, prefix, fileKey string) error {
+	sse, err := h.keyServerSideEncryption(fileKey)
+	if err != nil {
+		return err
+	}
+	object, objInfo, err := h.getObject(ctx, bucketName, fileKey, minio.GetObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		return fmt.Errorf("failed to export file %s: %w", fileKey, err)
+	}
+	defer object.Close()
+
+	name := strings.TrimPrefix(fileKey, prefix)
+	sidecar, err := json.Marshal(archiveMetadata{
+		Name:         name,
+		Bucket:       bucketName,
+		ContentType:  objInfo.ContentType,
+		Size:         objInfo.Size,
+		LastModified: objInfo.LastModified,
+		UserMetadata: objInfo.UserMetadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode metadata of %s: %w", fileKey, err)
+	}
+
+	if err := writeArchiveEntry(archive, archiveMetadataDir+dir+name+".json", int64(len(sidecar)), objInfo.LastModified, bytes.NewReader(sidecar)); err != nil {
+		return err
+	}
+	return writeArchiveEntry(archive, dir+name, objInfo.Size, objInfo.LastModified, h.throttleDownload(ctx, object))
+}
+
+// writeArchiveEntry writes a file entry to an archive
+func writeArchiveEntry(archive *tar.Writer, name string, size int64, modified time.Time, data io.Reader) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  modified,
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write archive entry %s: %w", name, err)
+	}
+	if _, err := io.Copy(archive, data); err != nil {
+		return fmt.Errorf("failed to write archive entry %s: %w", name, err)
+	}
+	return nil
+}
+
+// ImportArchive stores the files of an archive written by ExportPrefix under a key prefix
+// Files keep their metadata and derived files are restored to the derived bucket they were
+// exported from, or the handler's default one. Existing files with the same key are replaced
+// Archives are not trusted: userID owns the imported files, and their scan and moderation states
+// are those of new uploads of their category, see importedMetadata
+// Returns the number of files stored
+func (h *Handler) ImportArchive(ctx context.Context, src io.Reader, prefix, userID string) (int, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	prefix, err = h.archivePrefix(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	derived := make(map[string]bool)
+	for _, bucketName := range h.DerivedBuckets() {
+		derived[bucketName] = true
+	}
+	h.configMutex.RLock()
+	defaultDerived := h.derivedBucket(category.CategoryConfig{})
+	h.configMutex.RUnlock()
+
+	archive := tar.NewReader(src)
+	metadata := make(map[string]archiveMetadata)
+	count := 0
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if strings.HasPrefix(header.Name, archiveMetadataDir) {
+			var sidecar archiveMetadata
+			if err := json.NewDecoder(archive).Decode(&sidecar); err != nil {
+				return count, &errors.StorageError{Code: "INVALID_ARCHIVE", Message: "Invalid metadata entry " + header.Name, Details: err.Error()}
+			}
+			metadata[strings.TrimSuffix(strings.TrimPrefix(header.Name, archiveMetadataDir), ".json")] = sidecar
+			continue
+		}
+
+		sidecar := metadata[header.Name]
+		delete(metadata, header.Name)
+
+		var bucketName string
+		var original bool
+		name := header.Name
+		switch {
+		case strings.HasPrefix(name, archiveFilesDir):
+			name = strings.TrimPrefix(name, archiveFilesDir)
+			bucketName = h.tenantBucket(t)
+			original = true
+		case strings.HasPrefix(name, archiveDerivedDir):
+			name = strings.TrimPrefix(name, archiveDerivedDir)
+			bucketName = defaultDerived
+			if derived[sidecar.Bucket] {
+				bucketName = sidecar.Bucket
+			}
+		default:
+			return count, &errors.StorageError{Code: "INVALID_ARCHIVE", Message: "Unexpected archive entry " + header.Name}
+		}
+		// Names must stay below the prefix
+		if name == "" || path.IsAbs(name) || path.Clean(name) != name || name == ".." || strings.HasPrefix(name, "../") {
+			return count, &errors.StorageError{Code: "INVALID_ARCHIVE", Message: "Invalid archive entry " + header.Name}
+		}
+
+		fileKey := prefix + name
+		categoryConfig, _, _ := h.category(h.fileKeyInfo(&minio.ObjectInfo{Key: fileKey, UserMetadata: sidecar.UserMetadata}).Category)
+		sidecar.UserMetadata = importedMetadata(sidecar.UserMetadata, original, userID, t, categoryConfig)
+		if err := h.importObject(ctx, archive, bucketName, fileKey, header.Size, sidecar); err != nil {
+			return count, err
+		}
+		if original && categoryConfig.Anonymous.Enabled {
+			h.scanFile(ctx, bucketName, fileKey)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// untrustedMetadata are the user metadata keys of archived objects that grant ownership or access,
+// which imports set themselves
+var untrustedMetadata = map[string]bool{
+	"Uploaded-By":       true,
+	"Tenant-Id":         true,
+	"Scan-Status":       true,
+	"Moderation-Status": true,
+	"Moderated-By":      true,
+	"Moderated-At":      true,
+	"Moderation-Reason": true,
+}
+
+// importedMetadata returns the user metadata an archived object is stored with: keys granting
+// ownership or access and backend headers are dropped, original files are owned by the importing
+// user and are scanned and moderated again like uploads of their category
+func importedMetadata(archived map[string]string, original bool, userID string, t *tenant.Tenant, categoryConfig category.CategoryConfig) map[string]string {
+	userMetadata := make(map[string]string, len(archived))
+	for key, value := range archived {
+		key = http.CanonicalHeaderKey(key)
+		if untrustedMetadata[key] || strings.HasPrefix(key, "X-Amz-") {
+			continue
+		}
+		userMetadata[key] = value
+	}
+	if !original {
+		return userMetadata
+	}
+
+	if userID != "" {
+		userMetadata["Uploaded-By"] = userID
+	}
+	if t != nil {
+		userMetadata["Tenant-Id"] = t.ID
+	}
+	if categoryConfig.Anonymous.Enabled {
+		userMetadata["Scan-Status"] = scanPending
+	}
+	if categoryConfig.Moderation.Enabled || (categoryConfig.Anonymous.Enabled && userID == "") {
+		userMetadata["Moderation-Status"] = middleware.ModerationPending
+	}
+	return userMetadata
+}
+
+// importObject stores an archived object
+func (h *Handler) importObject(ctx context.Context, data io.Reader, bucketName, fileKey string, size int64, sidecar archiveMetadata) error {
+	sse, err := h.keyServerSideEncryption(fileKey)
+	if err != nil {
+		return err
+	}
+
+	contentType := sidecar.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	_, err = h.putObject(ctx, bucketName, fileKey, data, size, minio.PutObjectOptions{
+		ContentType:          contentType,
+		ServerSideEncryption: sse,
+		UserMetadata:         sidecar.UserMetadata,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import file %s: %w", fileKey, err)
+	}
+	h.invalidate(ctx, fileKey)
+	return nil
+}
+
+// archivePrefix validates an export or import prefix against the request's tenant
+// The prefix is treated as a directory, so "cat/1" does not include the files of "cat/10"
+func (h *Handler) archivePrefix(ctx context.Context, prefix string) (string, error) {
+	prefix = strings.TrimPrefix(prefix, "/")
+	if prefix == "" {
+		return "", &errors.StorageError{Code: "INVALID_REQUEST", Message: "Prefix is required"}
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := h.checkTenantKey(t, prefix); err != nil {
+		return "", err
+	}
+	return prefix, nil
+}
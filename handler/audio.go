@@ -0,0 +1,233 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// defaultWaveformPoints is the number of points of waveforms when a category sets none
+const defaultWaveformPoints = 1000
+
+// audioMetadata are the metadata keys of the properties of analyzed audio files, in canonical form
+var audioMetadata = []string{"Audio-Duration", "Audio-Bitrate", "Audio-Sample-Rate", "Audio-Channels", "Audio-Codec"}
+
+// WaveformKey returns the key of the waveform of an audio file: original_file_key_waveform.json,
+// stored next to its thumbnails in the derived bucket of its category
+func WaveformKey(fileKey string) string {
+	return strings.TrimSuffix(fileKey, filepath.Ext(fileKey)) + "_waveform.json"
+}
+
+// audioInfo returns the properties of an analyzed audio file from its metadata, nil before its analysis
+func audioInfo(userMetadata map[string]string) *interfaces.AudioInfo {
+	duration, err := strconv.ParseFloat(userMetadata["Audio-Duration"], 64)
+	if err != nil {
+		return nil
+	}
+	info := &interfaces.AudioInfo{Duration: duration, Codec: userMetadata["Audio-Codec"]}
+	info.Bitrate, _ = strconv.Atoi(userMetadata["Audio-Bitrate"])
+	info.SampleRate, _ = strconv.Atoi(userMetadata["Audio-Sample-Rate"])
+	info.Channels, _ = strconv.Atoi(userMetadata["Audio-Channels"])
+	return info
+}
+
+// analyzeAudio queues the analysis of a stored audio file when the AudioAnalyzer supports it
+func (h *Handler) analyzeAudio(ctx context.Context, bucketName, fileKey, contentType string) {
+	analyzer := h.config().AudioAnalyzer
+	if analyzer == nil || !analyzer.Supports(contentType) {
+		return
+	}
+	if err := h.SubmitJob(ctx, &jobs.Job{Type: jobs.TypeAudio, FileKey: fileKey, BucketName: bucketName}); err != nil {
+		h.logger.Warn("failed to queue audio analysis", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
+
+// handleAudioJob analyzes an audio file with the AudioAnalyzer of the handler, stores its waveform
+// as a derived file and its properties as metadata
+func (h *Handler) handleAudioJob(ctx context.Context, job *jobs.Job) error {
+	analyzer := h.config().AudioAnalyzer
+	if analyzer == nil {
+		return fmt.Errorf("no audio analyzer configured")
+	}
+
+	sse, err := h.keyServerSideEncryption(job.FileKey)
+	if err != nil {
+		return err
+	}
+	objInfo, err := h.statObject(ctx, job.BucketName, job.FileKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			// Deleted before its analysis
+			return nil
+		}
+		return fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	categoryName := h.fileKeyInfo(&objInfo).Category
+	h.configMutex.RLock()
+	categoryConfig := h.Config.Categories[categoryName]
+	bucketName := h.derivedBucket(categoryConfig)
+	storageClass := h.derivedStorageClass(categoryConfig)
+	h.configMutex.RUnlock()
+	points := categoryConfig.Audio.WaveformPoints
+	if points == 0 {
+		points = defaultWaveformPoints
+	}
+
+	// Middlewares may have encrypted or compressed the file, the analyzer gets the original
+	fileData, err := h.openFile(ctx, job.BucketName, &objInfo, objInfo.UserMetadata["Uploaded-By"], 0, middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size)-1)
+	if err != nil {
+		return err
+	}
+	analysis, err := analyzer.Analyze(ctx, objInfo.ContentType, fileData, points)
+	if closer, ok := fileData.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to analyze audio: %w", err)
+	}
+
+	if analysis.Waveform != nil {
+		encoded, err := json.Marshal(analysis.Waveform)
+		if err != nil {
+			return fmt.Errorf("failed to encode waveform: %w", err)
+		}
+		_, err = h.putObject(ctx, bucketName, WaveformKey(job.FileKey), bytes.NewReader(encoded), int64(len(encoded)), minio.PutObjectOptions{
+			ContentType:  "application/json",
+			StorageClass: storageClass,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store waveform of %s: %w", job.FileKey, err)
+		}
+		h.recordDerived(ctx, bucketName, job.FileKey, "", DerivedFile{Key: WaveformKey(job.FileKey), Kind: DerivedWaveform, Size: int64(len(encoded)), CreatedAt: time.Now()})
+	}
+
+	info := &interfaces.AudioInfo{
+		Duration:   analysis.Duration,
+		Bitrate:    analysis.Bitrate,
+		SampleRate: analysis.SampleRate,
+		Channels:   analysis.Channels,
+		Codec:      analysis.Codec,
+	}
+	userMetadata := make(map[string]string, len(objInfo.UserMetadata)+len(audioMetadata)+1)
+	for key, value := range objInfo.UserMetadata {
+		userMetadata[key] = value
+	}
+	userMetadata["Audio-Duration"] = strconv.FormatFloat(info.Duration, 'f', 3, 64)
+	userMetadata["Audio-Bitrate"] = strconv.Itoa(info.Bitrate)
+	userMetadata["Audio-Sample-Rate"] = strconv.Itoa(info.SampleRate)
+	userMetadata["Audio-Channels"] = strconv.Itoa(info.Channels)
+	userMetadata["Audio-Codec"] = info.Codec
+	userMetadata["Content-Type"] = objInfo.ContentType
+	if err := h.replaceMetadata(ctx, job.BucketName, job.FileKey, objInfo.ETag, userMetadata); err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			// A newer version is analyzed on its own
+			return nil
+		}
+		return fmt.Errorf("failed to record audio properties of %s: %w", job.FileKey, err)
+	}
+	h.invalidate(ctx, job.FileKey)
+	h.replicate(ctx, job.FileKey, false)
+	h.updateRecord(ctx, job.FileKey, func(record *interfaces.FileMetadata) {
+		record.Audio = info
+	})
+
+	job.Result = map[string]interface{}{
+		"duration": info.Duration,
+		"bitrate":  info.Bitrate,
+		"waveform": analysis.Waveform != nil,
+	}
+	return nil
+}
+
+// GetWaveform returns the waveform of an analyzed audio file as audiowaveform JSON, which players
+// render without downloading the file; access is decided by the file like previews
+func (h *Handler) GetWaveform(ctx context.Context, req *interfaces.WaveformRequest) (*interfaces.DownloadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	fileInfo, sourceBucket, err := h.findFile(ctx, req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+	objInfo := fileInfo.(*minio.ObjectInfo)
+	categoryName := h.fileKeyInfo(objInfo).Category
+	h.configMutex.RLock()
+	bucketName := h.derivedBucket(h.Config.Categories[categoryName])
+	h.configMutex.RUnlock()
+
+	var resp *interfaces.DownloadResponse
+	err = h.runChain(ctx, h.chainRequest("preview", objInfo, sourceBucket, req.UserID), func(ctx context.Context) error {
+		// Waveforms are small, cached with their original which invalidates them
+		waveformKey := WaveformKey(req.FileKey)
+		cache := h.categoryCache(categoryName)
+		if cache != nil {
+			if preview, ok := cache.Preview(req.FileKey, "waveform"); ok {
+				resp = waveformResponse(req, waveformKey, preview)
+				return nil
+			}
+		}
+
+		object, waveformInfo, err := h.getObject(ctx, bucketName, waveformKey, minio.GetObjectOptions{})
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return &errors.StorageError{Code: "WAVEFORM_NOT_FOUND", Message: "Waveform not found"}
+			}
+			return errors.Wrap(errors.ErrDownloadFailed, err)
+		}
+		data, err := io.ReadAll(object)
+		object.Close()
+		if err != nil {
+			return errors.Wrap(errors.ErrDownloadFailed, err)
+		}
+		preview := middleware.CachedPreview{
+			Data:         data,
+			ContentType:  waveformInfo.ContentType,
+			LastModified: waveformInfo.LastModified,
+		}
+		if cache != nil && cache.Cacheable(int64(len(data))) {
+			cache.SetPreview(req.FileKey, "waveform", preview)
+		}
+		resp = waveformResponse(req, waveformKey, preview)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// waveformResponse returns the download response of a waveform
+func waveformResponse(req *interfaces.WaveformRequest, waveformKey string, preview middleware.CachedPreview) *interfaces.DownloadResponse {
+	return &interfaces.DownloadResponse{
+		Success:     true,
+		FileData:    bytes.NewReader(preview.Data),
+		FileSize:    int64(len(preview.Data)),
+		ContentType: preview.ContentType,
+		Metadata: map[string]interface{}{
+			"file_name":    waveformKey,
+			"original_key": req.FileKey,
+			"uploaded_at":  preview.LastModified,
+			"content_type": preview.ContentType,
+		},
+	}
+}
@@ -0,0 +1,303 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// auditAnchorPrefix is the key prefix of the anchors of audit chains, kept apart from the keys of
+// files so listings of entities do not return them
+const auditAnchorPrefix = "_audit/"
+
+// AuditLogConfig represents where the events of the audit middlewares are kept for QueryAudit
+// and how long; read by Initialize
+type AuditLogConfig struct {
+	// Store keeps the events of the audit middlewares of all categories, e.g.
+	// middleware.NewFileAuditStore; events are only logged when nil
+	Store middleware.AuditStore `json:"-"`
+	// Retention is the age after which events are pruned from the store, 0 keeps them forever
+	Retention time.Duration `json:"retention,omitempty"`
+	// PruneInterval is how often old events are pruned, default 1 hour
+	PruneInterval time.Duration `json:"prune_interval,omitempty"`
+	// HashChain links every stored event to the one before it, so VerifyAudit detects events
+	// changed, removed or inserted; the handler must be the only writer of the store
+	HashChain bool `json:"hash_chain,omitempty"`
+	// AnchorInterval is how often the head of the chain is written to AnchorBucket, default 1 hour
+	AnchorInterval time.Duration `json:"anchor_interval,omitempty"`
+	// AnchorBucket keeps the anchors, default the handler bucket; a bucket with object locking
+	// keeps them out of reach of whoever can change the store
+	AnchorBucket string `json:"anchor_bucket,omitempty"`
+}
+
+// withDefaults returns the configuration with defaults for unset values
+func (c AuditLogConfig) withDefaults() AuditLogConfig {
+	if c.PruneInterval <= 0 {
+		c.PruneInterval = time.Hour
+	}
+	if c.AnchorInterval <= 0 {
+		c.AnchorInterval = time.Hour
+	}
+	return c
+}
+
+// AuditAnchor represents the head of an audit chain at a point in time, stored as an object
+// Events up to the anchored one cannot be changed without VerifyAudit noticing
+type AuditAnchor struct {
+	Key       string    `json:"-"`
+	Handler   string    `json:"handler"`
+	Hash      string    `json:"hash"`
+	EventTime time.Time `json:"event_time"` // Time of the anchored event
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditVerification represents the result of VerifyAudit
+type AuditVerification struct {
+	Valid          bool      `json:"valid"`
+	Events         int       `json:"events"`
+	Anchors        int       `json:"anchors"`                   // Anchors of events of the range
+	MissingAnchors []string  `json:"missing_anchors,omitempty"` // Keys of anchors whose event is gone or changed
+	Error          string    `json:"error,omitempty"`           // The first break of the chain
+	From           time.Time `json:"from,omitempty"`
+	To             time.Time `json:"to,omitempty"`
+}
+
+// IsAuditAnchorKey reports whether a key is the key of an anchor of an audit chain
+func IsAuditAnchorKey(key string) bool {
+	return strings.HasPrefix(key, auditAnchorPrefix)
+}
+
+// auditAnchorBucket returns the bucket the anchors of the audit chain are stored in
+func (h *Handler) auditAnchorBucket() string {
+	if bucketName := h.config().Audit.AnchorBucket; bucketName != "" {
+		return bucketName
+	}
+	return h.BucketName
+}
+
+// QueryAudit returns the audit events matching a query, oldest first, e.g. who downloaded a file
+// in a month. Only events of operations audited while a store was configured are known;
+// requests of a tenant only see the events of its files
+func (h *Handler) QueryAudit(ctx context.Context, query middleware.AuditQuery) ([]middleware.AuditEvent, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if h.auditStore == nil {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "No audit store configured"}
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if query.FileKey != "" {
+		if err := h.checkTenantKey(t, query.FileKey); err != nil {
+			return nil, err
+		}
+	}
+	if t != nil {
+		query.KeyPrefix = t.Key(query.KeyPrefix)
+	}
+
+	events, err := h.auditStore.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	if tenants := h.config().Tenants; t == nil && tenants != nil {
+		// Requests without a tenant do not see the files of tenants
+		kept := events[:0]
+		for _, event := range events {
+			if tenants.Owner(event.FileKey) == nil {
+				kept = append(kept, event)
+			}
+		}
+		events = kept
+	}
+	return events, nil
+}
+
+// PruneAudit removes the audit events older than the retention from the store, returning how
+// many were removed; they are also pruned in the background every PruneInterval
+func (h *Handler) PruneAudit(ctx context.Context) (int, error) {
+	retention := h.config().Audit.Retention
+	if h.auditStore == nil || retention <= 0 {
+		return 0, nil
+	}
+	pruned, err := h.auditStore.Prune(ctx, time.Now().Add(-retention))
+	if err != nil {
+		return pruned, fmt.Errorf("failed to prune audit events: %w", err)
+	}
+	return pruned, nil
+}
+
+// AnchorAudit writes the head of the audit chain to the anchor bucket, nil when the chain has no
+// new event since the last anchor; anchors are also written in the background every AnchorInterval
+func (h *Handler) AnchorAudit(ctx context.Context) (*AuditAnchor, error) {
+	chain, ok := h.auditStore.(*middleware.ChainedAuditStore)
+	if !ok {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "Audit hash chaining is not enabled for handler " + h.Name}
+	}
+
+	h.auditAnchorMutex.Lock()
+	defer h.auditAnchorMutex.Unlock()
+
+	head, err := chain.Head(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if head.Hash == "" || head.Hash == h.lastAuditAnchor {
+		return nil, nil
+	}
+
+	now := time.Now().UTC()
+	anchor := &AuditAnchor{
+		Key:       auditAnchorPrefix + h.Name + "/" + now.Format("20060102T150405.000000000Z") + ".json",
+		Handler:   h.Name,
+		Hash:      head.Hash,
+		EventTime: head.Timestamp,
+		CreatedAt: now,
+	}
+	encoded, err := json.Marshal(anchor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit anchor: %w", err)
+	}
+	_, err = h.putObject(ctx, h.auditAnchorBucket(), anchor.Key, bytes.NewReader(encoded), int64(len(encoded)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to store audit anchor: %w", err)
+	}
+	h.lastAuditAnchor = head.Hash
+	return anchor, nil
+}
+
+// auditAnchors reads the anchors of the handler's audit chain, oldest first
+func (h *Handler) auditAnchors(ctx context.Context) ([]*AuditAnchor, error) {
+	bucketName := h.auditAnchorBucket()
+
+	// Stop listing on the first error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var anchors []*AuditAnchor
+	objects := h.Client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: auditAnchorPrefix + h.Name + "/", Recursive: true})
+	for object := range objects {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list audit anchors: %w", object.Err)
+		}
+		reader, _, err := h.getObject(ctx, bucketName, object.Key, minio.GetObjectOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get audit anchor %s: %w", object.Key, err)
+		}
+		anchor := &AuditAnchor{Key: object.Key}
+		err = json.NewDecoder(reader).Decode(anchor)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode audit anchor %s: %w", object.Key, err)
+		}
+		anchors = append(anchors, anchor)
+	}
+	return anchors, nil
+}
+
+// VerifyAudit checks the hash chain of the audit events between from and to (zero for no bound)
+// and that the events of the anchors written meanwhile are still in it, e.g. for a compliance
+// review. Events pruned by the retention end the chain; its first kept event is trusted
+func (h *Handler) VerifyAudit(ctx context.Context, from, to time.Time) (*AuditVerification, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if _, ok := h.auditStore.(*middleware.ChainedAuditStore); !ok {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "Audit hash chaining is not enabled for handler " + h.Name}
+	}
+	events, err := h.auditStore.Query(ctx, middleware.AuditQuery{From: from, To: to})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit events: %w", err)
+	}
+	anchors, err := h.auditAnchors(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &AuditVerification{Valid: true, Events: len(events), From: from, To: to}
+	if err := middleware.VerifyAuditChain(events); err != nil {
+		result.Valid = false
+		result.Error = err.Error()
+	}
+
+	hashes := make(map[string]bool, len(events))
+	for _, event := range events {
+		hashes[event.Hash] = true
+	}
+	// Anchors of pruned events have nothing left to check
+	var oldest time.Time
+	if len(events) > 0 {
+		oldest = events[0].Timestamp
+	}
+	for _, anchor := range anchors {
+		if (!from.IsZero() && anchor.EventTime.Before(from)) || (!to.IsZero() && !anchor.EventTime.Before(to)) ||
+			anchor.EventTime.Before(oldest) {
+			continue
+		}
+		result.Anchors++
+		if !hashes[anchor.Hash] {
+			result.Valid = false
+			result.MissingAnchors = append(result.MissingAnchors, anchor.Key)
+		}
+	}
+	return result, nil
+}
+
+// maintainAudit prunes old audit events and anchors the audit chain in the background until the
+// handler is closed
+func (h *Handler) maintainAudit(config AuditLogConfig) {
+	ctx, cancel := context.WithCancel(h.abortCtx)
+	h.stopAudit = cancel
+
+	if config.Retention > 0 {
+		go everyInterval(ctx, config.PruneInterval, func() {
+			if _, err := h.PruneAudit(ctx); err != nil && ctx.Err() == nil {
+				h.logger.Warn("failed to prune audit events", map[string]interface{}{
+					"handler": h.Name,
+					"error":   err,
+				})
+			}
+		})
+	}
+	if config.HashChain {
+		go everyInterval(ctx, config.AnchorInterval, func() {
+			if _, err := h.AnchorAudit(ctx); err != nil && ctx.Err() == nil {
+				h.logger.Warn("failed to anchor audit chain", map[string]interface{}{
+					"handler": h.Name,
+					"error":   err,
+				})
+			}
+		})
+	}
+}
+
+// everyInterval calls fn now and then every interval until ctx is done
+func everyInterval(ctx context.Context, interval time.Duration, fn func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fn()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
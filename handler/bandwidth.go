@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"context"
+	"io"
+
+	"github.com/darmawan01/storage/middleware"
+)
+
+// bandwidthLimiters are the transfer rate limiters of a handler, nil when unlimited
+type bandwidthLimiters struct {
+	upload   *middleware.BandwidthLimiter
+	download *middleware.BandwidthLimiter
+	total    *middleware.BandwidthLimiter
+}
+
+// updateBandwidth applies new limits; transfers in progress switch to changed rates, and
+// limits that were removed no longer apply to new transfers. Must be called with configMutex held
+func (h *Handler) updateBandwidth(config BandwidthConfig) {
+	h.bandwidth.upload = updateLimiter(h.bandwidth.upload, config.Upload)
+	h.bandwidth.download = updateLimiter(h.bandwidth.download, config.Download)
+	h.bandwidth.total = updateLimiter(h.bandwidth.total, config.Total)
+}
+
+// updateLimiter changes the rate of a limiter, creating it when needed
+func updateLimiter(limiter *middleware.BandwidthLimiter, bytesPerSecond int64) *middleware.BandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	if limiter == nil {
+		return middleware.NewBandwidthLimiter(bytesPerSecond)
+	}
+	limiter.SetRate(bytesPerSecond)
+	return limiter
+}
+
+// limiters returns the current limiters
+func (h *Handler) limiters() bandwidthLimiters {
+	h.configMutex.RLock()
+	defer h.configMutex.RUnlock()
+	return h.bandwidth
+}
+
+// throttleUpload limits data uploaded to MinIO by the upload and total limits
+func (h *Handler) throttleUpload(ctx context.Context, data io.Reader) io.Reader {
+	limiters := h.limiters()
+	return middleware.ThrottleReader(ctx, data, limiters.upload, limiters.total)
+}
+
+// throttleDownload limits an object read from MinIO by the download and total limits
+func (h *Handler) throttleDownload(ctx context.Context, object io.ReadCloser) io.ReadCloser {
+	limiters := h.limiters()
+	return middleware.ThrottleReader(ctx, object, limiters.download, limiters.total).(io.ReadCloser)
+}
@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// BatchUpload uploads multiple files in a single operation
+func (h *Handler) BatchUpload(ctx context.Context, req *interfaces.BatchUploadRequest) (*interfaces.BatchUploadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if len(req.Files) == 0 {
+		return &interfaces.BatchUploadResponse{
+			Success: false,
+			Error:   &errors.StorageError{Code: "INVALID_REQUEST", Message: "No files provided"},
+		}, nil
+	}
+
+	// Limit batch size to prevent memory issues
+	maxBatchSize := h.config().Batch.withDefaults().MaxUploadFiles
+	if len(req.Files) > maxBatchSize {
+		return &interfaces.BatchUploadResponse{
+			Success: false,
+			Error:   &errors.StorageError{Code: "BATCH_SIZE_EXCEEDED", Message: fmt.Sprintf("Batch size %d exceeds maximum %d", len(req.Files), maxBatchSize)},
+		}, nil
+	}
+
+	results := make([]*interfaces.UploadResponse, len(req.Files))
+	errs := h.runBatch(ctx, len(req.Files), func(ctx context.Context, index int) {
+		file := req.Files[index]
+		uploadReq := &interfaces.UploadRequest{
+			FileData:    file.FileData,
+			FileSize:    file.FileSize,
+			ContentType: file.ContentType,
+			FileName:    file.FileName,
+			Category:    file.Category,
+			EntityType:  file.EntityType,
+			EntityID:    file.EntityID,
+			UserID:      req.UserID,
+			Metadata:    file.Metadata,
+			Config:      file.Config,
+		}
+		if uploadReq.EntityType == "" && uploadReq.EntityID == "" {
+			uploadReq.EntityType = req.EntityType
+			uploadReq.EntityID = req.EntityID
+		}
+
+		// Upload runs the middleware chain of the file's category
+		resp, err := h.Upload(ctx, uploadReq)
+		if err != nil {
+			resp = &interfaces.UploadResponse{Success: false, Error: err}
+		}
+		results[index] = resp
+	})
+
+	successCount := 0
+	for i, resp := range results {
+		if errs[i] != nil {
+			results[i] = &interfaces.UploadResponse{Success: false, Error: errs[i]}
+			continue
+		}
+		if resp != nil && resp.Success {
+			successCount++
+		}
+	}
+
+	return &interfaces.BatchUploadResponse{
+		Success:      successCount > 0,
+		Results:      results,
+		SuccessCount: successCount,
+		TotalCount:   len(req.Files),
+	}, nil
+}
+
+// BatchDelete deletes multiple files in a single operation
+func (h *Handler) BatchDelete(ctx context.Context, req *interfaces.BatchDeleteRequest) (*interfaces.BatchDeleteResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if len(req.FileKeys) == 0 {
+		return &interfaces.BatchDeleteResponse{
+			Success: false,
+			Error:   &errors.StorageError{Code: "INVALID_REQUEST", Message: "No file keys provided"},
+		}, nil
+	}
+
+	// Limit batch size to prevent memory issues
+	maxBatchSize := h.config().Batch.withDefaults().MaxDeleteFiles
+	if len(req.FileKeys) > maxBatchSize {
+		return &interfaces.BatchDeleteResponse{
+			Success: false,
+			Error:   &errors.StorageError{Code: "BATCH_SIZE_EXCEEDED", Message: fmt.Sprintf("Batch size %d exceeds maximum %d", len(req.FileKeys), maxBatchSize)},
+		}, nil
+	}
+
+	results := make([]*interfaces.DeleteResponse, len(req.FileKeys))
+	errs := h.runBatch(ctx, len(req.FileKeys), func(ctx context.Context, index int) {
+		deleteReq := &interfaces.DeleteRequest{
+			FileKey: req.FileKeys[index],
+			UserID:  req.UserID,
+		}
+
+		err := h.Delete(ctx, deleteReq)
+		// Convert error to DeleteResponse
+		results[index] = &interfaces.DeleteResponse{
+			Success: err == nil,
+			Error:   err,
+		}
+	})
+
+	successCount := 0
+	for i, resp := range results {
+		if errs[i] != nil {
+			results[i] = &interfaces.DeleteResponse{Success: false, Error: errs[i]}
+			continue
+		}
+		if resp != nil && resp.Success {
+			successCount++
+		}
+	}
+
+	return &interfaces.BatchDeleteResponse{
+		Success:      successCount > 0,
+		Results:      results,
+		SuccessCount: successCount,
+		TotalCount:   len(req.FileKeys),
+	}, nil
+}
+
+// BatchGet retrieves multiple files in a single operation
+func (h *Handler) BatchGet(ctx context.Context, req *interfaces.BatchGetRequest) (*interfaces.BatchGetResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if len(req.FileKeys) == 0 {
+		return &interfaces.BatchGetResponse{
+			Success: false,
+			Error:   &errors.StorageError{Code: "INVALID_REQUEST", Message: "No file keys provided"},
+		}, nil
+	}
+
+	// Limit batch size to prevent memory issues
+	maxBatchSize := h.config().Batch.withDefaults().MaxGetFiles
+	if len(req.FileKeys) > maxBatchSize {
+		return &interfaces.BatchGetResponse{
+			Success: false,
+			Error:   &errors.StorageError{Code: "BATCH_SIZE_EXCEEDED", Message: fmt.Sprintf("Batch size %d exceeds maximum %d", len(req.FileKeys), maxBatchSize)},
+		}, nil
+	}
+
+	results := make([]*interfaces.DownloadResponse, len(req.FileKeys))
+	errs := h.runBatch(ctx, len(req.FileKeys), func(ctx context.Context, index int) {
+		downloadReq := &interfaces.DownloadRequest{
+			FileKey: req.FileKeys[index],
+			UserID:  req.UserID,
+		}
+
+		resp, err := h.Download(ctx, downloadReq)
+		if err != nil {
+			resp = &interfaces.DownloadResponse{Success: false, Error: err}
+		}
+		results[index] = resp
+	})
+
+	successCount := 0
+	for i, resp := range results {
+		if errs[i] != nil {
+			results[i] = &interfaces.DownloadResponse{Success: false, Error: errs[i]}
+			continue
+		}
+		if resp != nil && resp.Success {
+			successCount++
+		}
+	}
+
+	return &interfaces.BatchGetResponse{
+		Success:      successCount > 0,
+		Results:      results,
+		SuccessCount: successCount,
+		TotalCount:   len(req.FileKeys),
+	}, nil
+}
+
+// runBatch processes the items of a batch on a bounded worker pool
+// At most Batch.Concurrency items of the batch run at once, and at most Batch.MaxConcurrent
+// across all batches of the handler. Items that could not start before ctx ended get its error
+func (h *Handler) runBatch(ctx context.Context, count int, process func(ctx context.Context, index int)) []error {
+	h.configMutex.RLock()
+	concurrency := h.Config.Batch.withDefaults().Concurrency
+	slots := h.batchSlots
+	h.configMutex.RUnlock()
+
+	errs := make([]error, count)
+
+	var group errgroup.Group
+	group.SetLimit(concurrency)
+	for i := 0; i < count; i++ {
+		index := i
+		group.Go(func() error {
+			if err := slots.Acquire(ctx, 1); err != nil {
+				errs[index] = err
+				return nil
+			}
+			defer slots.Release(1)
+
+			process(ctx, index)
+			return nil
+		})
+	}
+	group.Wait()
+
+	return errs
+}
+
+// updateBatchSlots replaces the handler wide batch semaphore when MaxConcurrent changed
+// Batches already running keep the previous one. Must be called with configMutex held
+func (h *Handler) updateBatchSlots(config BatchConfig) {
+	limit := config.withDefaults().MaxConcurrent
+	if h.batchSlots != nil && h.batchLimit == limit {
+		return
+	}
+	h.batchSlots = semaphore.NewWeighted(int64(limit))
+	h.batchLimit = limit
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"sync"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+// Circuit breaker states
+const (
+	CircuitClosed   = "closed"    // Calls go through
+	CircuitOpen     = "open"      // Calls fail fast with BACKEND_UNAVAILABLE until the cooldown has passed
+	CircuitHalfOpen = "half_open" // A single probe call goes through, its outcome closes or reopens the circuit
+)
+
+// CircuitBreakerConfig represents circuit breaker configuration, the breaker is disabled when FailureThreshold is 0
+type CircuitBreakerConfig struct {
+	FailureThreshold int           `json:"failure_threshold,omitempty"` // Consecutive backend failures that open the circuit
+	Cooldown         time.Duration `json:"cooldown,omitempty"`          // Time the circuit stays open before a probe, default 30s
+}
+
+// withDefaults returns the configuration with defaults for unset values
+func (c CircuitBreakerConfig) withDefaults() CircuitBreakerConfig {
+	if c.Cooldown <= 0 {
+		c.Cooldown = 30 * time.Second
+	}
+	return c
+}
+
+// CircuitBreakerStats represents the state of a circuit breaker
+type CircuitBreakerStats struct {
+	State    string    `json:"state"`
+	Failures int       `json:"consecutive_failures"`
+	OpenedAt time.Time `json:"opened_at"`
+}
+
+// circuitBreaker stops calls to the backend after consecutive failures, so requests fail fast
+// during outages instead of waiting for timeouts and retries
+type circuitBreaker struct {
+	state    string
+	failures int
+	openedAt time.Time
+	mutex    sync.Mutex
+}
+
+// allow returns BACKEND_UNAVAILABLE while the circuit is open
+// Once the cooldown has passed, the first caller becomes the probe and the circuit half-opens
+func (b *circuitBreaker) allow(config CircuitBreakerConfig) error {
+	if config.FailureThreshold <= 0 {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < config.Cooldown {
+			return errors.ErrBackendUnavailable
+		}
+		b.state = CircuitHalfOpen
+	case CircuitHalfOpen:
+		// The probe is still running
+		return errors.ErrBackendUnavailable
+	}
+	return nil
+}
+
+// record counts the outcome of a call, returning true when it opened the circuit
+// Canceled calls say nothing about the backend; a canceled probe lets the next call probe again
+func (b *circuitBreaker) record(config CircuitBreakerConfig, err error) bool {
+	if config.FailureThreshold <= 0 {
+		return false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch {
+	case stderrors.Is(err, context.Canceled) || stderrors.Is(err, context.DeadlineExceeded):
+		if b.state == CircuitHalfOpen {
+			b.state = CircuitOpen
+		}
+		return false
+	case err == nil || !retryable(err):
+		b.state = CircuitClosed
+		b.failures = 0
+		return false
+	}
+
+	b.failures++
+	if b.state == CircuitHalfOpen || (b.state != CircuitOpen && b.failures >= config.FailureThreshold) {
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+		return true
+	}
+	return false
+}
+
+// stats returns the state of the breaker
+func (b *circuitBreaker) stats() CircuitBreakerStats {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	stats := CircuitBreakerStats{State: b.state, Failures: b.failures}
+	if stats.State == "" {
+		stats.State = CircuitClosed
+	}
+	if stats.State != CircuitClosed {
+		stats.OpenedAt = b.openedAt
+	}
+	return stats
+}
+
+// guard runs a backend call through the handler's circuit breaker
+func (h *Handler) guard(operation string, call func() error) error {
+	config := h.config().CircuitBreaker.withDefaults()
+	if err := h.breaker.allow(config); err != nil {
+		return err
+	}
+
+	err := call()
+	if h.breaker.record(config, err) {
+		h.logger.Warn("circuit breaker opened, failing fast until the backend recovers", map[string]interface{}{
+			"handler":   h.Name,
+			"operation": operation,
+			"cooldown":  config.Cooldown,
+			"error":     err,
+		})
+	}
+	return err
+}
+
+// CircuitBreakerStats returns the state of the handler's circuit breaker
+func (h *Handler) CircuitBreakerStats() CircuitBreakerStats {
+	return h.breaker.stats()
+}
@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/events"
+	"github.com/minio/minio-go/v7"
+)
+
+// BulkDeleteOptions represents options of bulk deletes
+type BulkDeleteOptions struct {
+	// DryRun lists the files that would be deleted without removing them
+	DryRun bool
+	// Progress is called for every file once it is removed (or found, in a dry run); err is set when removing it failed
+	Progress func(fileKey string, err error)
+	// UserID is recorded in the delete events
+	UserID string
+}
+
+// BulkDeleteResult represents the outcome of a bulk delete
+type BulkDeleteResult struct {
+	Deleted int              // Files removed, or found in a dry run
+	Failed  map[string]error // Files that could not be removed
+	DryRun  bool
+}
+
+// DeleteByEntity removes all files of an entity, originals and derived files such as thumbnails,
+// e.g. when an account is deleted. Objects are removed in batches with multi-object deletes
+// A failed file does not stop the others; they are reported in the result
+func (h *Handler) DeleteByEntity(ctx context.Context, entityType, entityID string, opts BulkDeleteOptions) (*BulkDeleteResult, error) {
+	if entityType == "" || entityID == "" {
+		return nil, &errors.StorageError{Code: "INVALID_REQUEST", Message: "Entity type and ID are required"}
+	}
+
+	prefix := entityType + "/" + entityID + "/"
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		prefix = t.Key(prefix)
+	}
+	return h.deletePrefix(ctx, prefix, opts)
+}
+
+// deletePrefix removes all files under a key prefix from the handler's buckets
+func (h *Handler) deletePrefix(ctx context.Context, prefix string, opts BulkDeleteOptions) (*BulkDeleteResult, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	prefix, err = h.archivePrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BulkDeleteResult{
+		Failed: make(map[string]error),
+		DryRun: opts.DryRun,
+	}
+
+	originals := h.tenantBucket(t)
+	buckets := []string{originals}
+	for _, bucketName := range h.DerivedBuckets() {
+		if bucketName != originals {
+			buckets = append(buckets, bucketName)
+		}
+	}
+
+	for _, bucketName := range buckets {
+		if err := h.deleteBucketPrefix(ctx, bucketName, prefix, bucketName == originals, opts, result); err != nil {
+			return result, err
+		}
+	}
+	if !opts.DryRun {
+		h.deleteManifests(ctx, prefix)
+	}
+	return result, nil
+}
+
+// deleteBucketPrefix removes the files under a prefix from one bucket
+// Listing errors abort the delete, errors of single files are recorded in the result
+func (h *Handler) deleteBucketPrefix(ctx context.Context, bucketName, prefix string, originals bool, opts BulkDeleteOptions, result *BulkDeleteResult) error {
+	// Stop listing when the delete is aborted
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := h.Client.ListObjects(listCtx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true})
+
+	if opts.DryRun {
+		for object := range objects {
+			if object.Err != nil {
+				return fmt.Errorf("failed to list files of %s: %w", prefix, object.Err)
+			}
+			result.Deleted++
+			if opts.Progress != nil {
+				opts.Progress(object.Key, nil)
+			}
+		}
+		return nil
+	}
+
+	// Feed the listing to the multi-object delete, which removes up to 1000 objects per request
+	removeCh := make(chan minio.ObjectInfo)
+	listed := make(chan error, 1)
+	go func() {
+		defer close(removeCh)
+		for object := range objects {
+			if object.Err != nil {
+				listed <- object.Err
+				return
+			}
+			select {
+			case removeCh <- object:
+			case <-listCtx.Done():
+				listed <- listCtx.Err()
+				return
+			}
+		}
+		listed <- nil
+	}()
+
+	for removed := range h.Client.RemoveObjectsWithResult(listCtx, bucketName, removeCh, minio.RemoveObjectsOptions{}) {
+		if removed.Err != nil {
+			result.Failed[removed.ObjectName] = removed.Err
+		} else {
+			result.Deleted++
+			if originals {
+				h.deleted(ctx, removed.ObjectName, opts.UserID)
+			}
+		}
+		if opts.Progress != nil {
+			opts.Progress(removed.ObjectName, removed.Err)
+		}
+	}
+
+	// Release the listing when the delete stopped early
+	cancel()
+	if err := <-listed; err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to list files of %s: %w", prefix, err)
+	}
+	return ctx.Err()
+}
+
+// deleted cleans up after a removed file and announces it
+func (h *Handler) deleted(ctx context.Context, fileKey, userID string) {
+	h.invalidate(ctx, fileKey)
+
+	// A file uploaded later under the same key starts with fresh counters
+	if err := h.downloads.Reset(ctx, fileKey); err != nil {
+		h.logger.Warn("failed to reset download counters", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+
+	h.deliver(ctx, &Delivery{Target: DeliveryStoreDelete, FileKey: fileKey})
+
+	h.unindexFile(ctx, fileKey)
+	h.forgetAccess(ctx, fileKey)
+	h.forgetShares(ctx, fileKey)
+	h.forgetLinks(ctx, fileKey)
+	h.replicate(ctx, fileKey, true)
+
+	h.deliver(ctx, &Delivery{Target: DeliveryDeleteCallback, FileKey: fileKey, UserID: userID})
+	h.publish(ctx, events.TypeFileDeleted, "", fileKey, userID, nil)
+}
@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/darmawan01/storage/middleware"
+)
+
+// artifactCache returns the cache of the cache middlewares, nil when no category caches
+// All categories share one cache, so stat results are found before the category of a file is known
+func (h *Handler) artifactCache() *middleware.Cache {
+	for _, chain := range h.chains() {
+		if cache := cacheMiddleware(chain); cache != nil && cache.Enabled() {
+			return cache.Cache()
+		}
+	}
+	return nil
+}
+
+// categoryCache returns the cache of a category, nil when its chain has no enabled cache middleware
+func (h *Handler) categoryCache(categoryName string) *middleware.Cache {
+	_, chain, _ := h.category(categoryName)
+	if cache := cacheMiddleware(chain); cache != nil && cache.Enabled() {
+		return cache.Cache()
+	}
+	return nil
+}
+
+// cacheMiddleware returns the cache middleware of a chain, nil when there is none
+func cacheMiddleware(chain *middleware.MiddlewareChain) *middleware.CacheMiddleware {
+	if chain == nil {
+		return nil
+	}
+	for _, m := range chain.Middlewares() {
+		if cache, ok := m.(*middleware.CacheMiddleware); ok {
+			return cache
+		}
+	}
+	return nil
+}
+
+// cachedURL returns a URL of fileKey from cache, building and caching it on a miss
+// URLs without expiry are cheap to build and not cached
+func cachedURL(cache *middleware.Cache, fileKey, variant string, build func() (string, time.Time, error)) (string, time.Time, error) {
+	if cache != nil {
+		if url, expiresAt, ok := cache.URL(fileKey, variant); ok {
+			return url, expiresAt, nil
+		}
+	}
+
+	url, expiresAt, err := build()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if cache != nil && !expiresAt.IsZero() {
+		cache.SetURL(fileKey, variant, url, expiresAt)
+	}
+	return url, expiresAt, nil
+}
+
+// invalidate drops the cached artifacts of a file after it was replaced or deleted,
+// in this instance and through Cache.Invalidator in the others
+func (h *Handler) invalidate(ctx context.Context, fileKey string) {
+	if h.cache == nil {
+		return
+	}
+	if err := h.cache.Invalidate(ctx, fileKey); err != nil {
+		h.logger.Warn("failed to invalidate cache", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
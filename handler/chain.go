@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// chainRequest describes an operation on a stored file to the middlewares of its category
+func (h *Handler) chainRequest(operation string, objInfo *minio.ObjectInfo, bucketName, userID string) *middleware.StorageRequest {
+	info := h.fileKeyInfo(objInfo)
+	// Middlewares act on the scan and moderation of files, e.g. security blocks unapproved ones
+	metadata := make(map[string]interface{})
+	if scanStatus, exists := objInfo.UserMetadata["Scan-Status"]; exists {
+		metadata["scan_status"] = scanStatus
+	}
+	if moderationStatus, exists := objInfo.UserMetadata["Moderation-Status"]; exists {
+		metadata["moderation_status"] = moderationStatus
+	}
+	return &middleware.StorageRequest{
+		Operation:   operation,
+		FileKey:     objInfo.Key,
+		FileName:    objInfo.UserMetadata["Original-Filename"],
+		FileSize:    middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size),
+		ContentType: objInfo.ContentType,
+		Category:    info.Category,
+		EntityType:  info.EntityType,
+		EntityID:    info.EntityID,
+		UserID:      userID,
+		BucketName:  bucketName,
+		Metadata:    metadata,
+		Object:      objInfo,
+	}
+}
+
+// runChain passes an operation on a stored file through the middleware chain of its category,
+// so security, rate limits, audit and monitoring apply as they do to uploads; perform runs once
+// every middleware let the request pass. Files of unknown categories, e.g. of a removed category,
+// are served without middlewares
+func (h *Handler) runChain(ctx context.Context, req *middleware.StorageRequest, perform func(ctx context.Context) error) error {
+	// Files are not served before their malware scan passed
+	if scanStatus, _ := req.Metadata["scan_status"].(string); !scanned(scanStatus) && readOperations[req.Operation] {
+		return errors.ErrNotScanned
+	}
+
+	categoryConfig, chain, exists := h.category(req.Category)
+	if !exists {
+		return perform(ctx)
+	}
+	// Authorizers let anyone read files of public categories
+	if req.Metadata == nil {
+		req.Metadata = make(map[string]interface{})
+	}
+	req.Metadata["is_public"] = categoryConfig.IsPublic
+
+	// Errors of the operation are returned as is, not as middleware failures
+	var performErr error
+	resp, err := chain.ProcessWith(ctx, req, func(ctx context.Context, req *middleware.StorageRequest) (*middleware.StorageResponse, error) {
+		if performErr = perform(ctx); performErr != nil {
+			return &middleware.StorageResponse{Success: false, Error: performErr}, nil
+		}
+		return &middleware.StorageResponse{
+			Success:     true,
+			FileKey:     req.FileKey,
+			FileSize:    req.FileSize,
+			ContentType: req.ContentType,
+		}, nil
+	})
+	if performErr != nil {
+		return performErr
+	}
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		if resp.Error != nil {
+			return resp.Error
+		}
+		return fmt.Errorf("%s of %s rejected by middlewares", req.Operation, req.FileKey)
+	}
+	return nil
+}
+
+// authorizeRead passes a read of a file that sends none of its data, such as a 304 Not Modified or
+// HEAD response, through the middleware chain as a stream, so it is authorized without being
+// counted as a download
+func (h *Handler) authorizeRead(ctx context.Context, objInfo *minio.ObjectInfo, bucketName, userID string) error {
+	return h.runChain(ctx, h.chainRequest("stream", objInfo, bucketName, userID), func(ctx context.Context) error { return nil })
+}
+
+// listable reports whether a user may see a file in query and search results, i.e. whether the
+// middleware chain of its category lets the user preview it; files removed since they were indexed
+// are not listable
+func (h *Handler) listable(ctx context.Context, fileKey, userID string) (bool, error) {
+	object, bucketName, err := h.findFile(ctx, fileKey)
+	if stderrors.Is(err, errors.ErrFileNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	objInfo := object.(*minio.ObjectInfo)
+
+	// Operation "list" is authorized like a preview, without counting as one
+	err = h.runChain(ctx, h.chainRequest("list", objInfo, bucketName, userID), func(ctx context.Context) error { return nil })
+	switch {
+	case err == nil:
+		return true, nil
+	case stderrors.Is(err, errors.ErrAccessDenied), stderrors.Is(err, errors.ErrUnauthorized):
+		return false, nil
+	}
+	return false, err
+}
+
+// readOperations are the operations serving the content of a file
+var readOperations = map[string]bool{"download": true, "preview": true, "stream": true}
+
+// securityMiddleware returns the security middleware of a category, nil when it has none
+func (h *Handler) securityMiddleware(categoryName string) *middleware.SecurityMiddleware {
+	if _, chain, exists := h.category(categoryName); exists {
+		for _, m := range chain.Middlewares() {
+			if security, ok := m.(*middleware.SecurityMiddleware); ok {
+				return security
+			}
+		}
+	}
+	return nil
+}
+
+// countDownload counts a download that passed the middleware chain; the security middleware
+// counts downloads itself and enforces their limit
+func (h *Handler) countDownload(ctx context.Context, req *middleware.StorageRequest) error {
+	if err := h.countLinkDownload(ctx); err != nil {
+		return err
+	}
+	if h.securityMiddleware(req.Category) != nil {
+		return nil
+	}
+	if _, _, err := h.downloads.Increment(ctx, req.FileKey, req.UserID, 0); err != nil {
+		return fmt.Errorf("failed to record download: %w", err)
+	}
+	return nil
+}
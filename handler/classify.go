@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/darmawan01/storage/category"
+	"github.com/darmawan01/storage/events"
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// classifyFile labels a stored image or video with the Classifier of the handler, in a background
+// job when the category classifies asynchronously; failures are only logged
+func (h *Handler) classifyFile(ctx context.Context, c category.ClassificationConfig, bucketName, fileKey, contentType string) {
+	if !strings.HasPrefix(contentType, "image/") && !strings.HasPrefix(contentType, "video/") {
+		return
+	}
+
+	var err error
+	if c.Async {
+		err = h.SubmitJob(ctx, &jobs.Job{Type: jobs.TypeClassify, FileKey: fileKey, BucketName: bucketName})
+	} else {
+		_, err = h.classify(ctx, bucketName, fileKey)
+	}
+	if err != nil {
+		h.logger.Warn("failed to classify file", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
+
+// handleClassifyJob classifies a file in the background
+func (h *Handler) handleClassifyJob(ctx context.Context, job *jobs.Job) error {
+	result, err := h.classify(ctx, job.BucketName, job.FileKey)
+	if err != nil || result == nil {
+		return err
+	}
+	job.Result = map[string]interface{}{
+		"nsfw_score": result.NSFWScore,
+		"labels":     result.Labels,
+		"engine":     result.Engine,
+	}
+	return nil
+}
+
+// classify stores the labels of a file as metadata and holds it for moderation when its NSFW score
+// reaches the threshold of its category; files deleted or replaced meanwhile are skipped
+func (h *Handler) classify(ctx context.Context, bucketName, fileKey string) (*jobs.Classification, error) {
+	classifier := h.config().Classifier
+	if classifier == nil {
+		return nil, fmt.Errorf("no classifier configured")
+	}
+
+	sse, err := h.keyServerSideEncryption(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	objInfo, err := h.statObject(ctx, bucketName, fileKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	// Middlewares may have encrypted or compressed the file, the classifier gets the original
+	fileData, err := h.openFile(ctx, bucketName, &objInfo, objInfo.UserMetadata["Uploaded-By"], 0, middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size)-1)
+	if err != nil {
+		return nil, err
+	}
+	result, err := classifier.Classify(ctx, fileData, objInfo.ContentType)
+	if closer, ok := fileData.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to classify object: %w", err)
+	}
+
+	categoryName := h.fileKeyInfo(&objInfo).Category
+	categoryConfig, _, _ := h.category(categoryName)
+	threshold := categoryConfig.Classification.NSFWThreshold
+	flagged := threshold > 0 && result.NSFWScore >= threshold
+
+	userMetadata := make(map[string]string, len(objInfo.UserMetadata)+3)
+	for key, value := range objInfo.UserMetadata {
+		userMetadata[key] = value
+	}
+	userMetadata["Nsfw-Score"] = strconv.FormatFloat(result.NSFWScore, 'f', -1, 64)
+	userMetadata["Content-Labels"] = strings.Join(result.Labels, ",")
+	if flagged {
+		userMetadata["Moderation-Status"] = middleware.ModerationPending
+	}
+	userMetadata["Content-Type"] = objInfo.ContentType
+	if err := h.replaceMetadata(ctx, bucketName, fileKey, objInfo.ETag, userMetadata); err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			// A newer version is classified on its own
+			return result, nil
+		}
+		return nil, fmt.Errorf("failed to record classification of %s: %w", fileKey, err)
+	}
+	h.invalidate(ctx, fileKey)
+	h.replicate(ctx, fileKey, false)
+
+	if flagged {
+		h.publish(ctx, events.TypeFileFlagged, categoryName, fileKey, objInfo.UserMetadata["Uploaded-By"], map[string]interface{}{
+			"nsfw_score": result.NSFWScore,
+			"labels":     result.Labels,
+			"engine":     result.Engine,
+		})
+	}
+	return result, nil
+}
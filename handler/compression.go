@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/darmawan01/storage/middleware"
+)
+
+// decompressedObject decompresses an object while it is read; closing it closes the object
+type decompressedObject struct {
+	io.Reader
+	decompressor io.Closer
+	object       io.Closer
+}
+
+// Close releases the decompressor and closes the object
+func (d decompressedObject) Close() error {
+	d.decompressor.Close()
+	return d.object.Close()
+}
+
+// decompressObject returns the original bytes [start, end] of a file compressed by the compression middleware
+// data must hold the whole stored file, decrypted when the file is encrypted as well
+func decompressObject(data io.Reader, object io.Closer, metadata map[string]string, start, end int64) (io.Reader, error) {
+	decompressor, err := middleware.Decompress(data, metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress file: %w", err)
+	}
+
+	// Compressed data cannot be seeked, skip to the start of the range
+	if start > 0 {
+		if _, err := io.CopyN(io.Discard, decompressor, start); err != nil {
+			decompressor.Close()
+			return nil, fmt.Errorf("failed to decompress file: %w", err)
+		}
+	}
+	return decompressedObject{Reader: io.LimitReader(decompressor, end-start+1), decompressor: decompressor, object: object}, nil
+}
@@ -4,9 +4,19 @@ import (
 	"time"
 
 	"github.com/darmawan01/storage/category"
+	"github.com/darmawan01/storage/convert"
 	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/events"
 	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/kms"
+	"github.com/darmawan01/storage/logger"
+	"github.com/darmawan01/storage/media"
 	"github.com/darmawan01/storage/middleware"
+	"github.com/darmawan01/storage/search"
+	"github.com/darmawan01/storage/secrets"
+	"github.com/darmawan01/storage/tenant"
+	"github.com/darmawan01/storage/watermark"
 )
 
 // HandlerConfig represents handler-specific configuration
@@ -15,9 +25,194 @@ type HandlerConfig struct {
 	Categories  map[string]category.CategoryConfig `json:"categories"`
 	Security    middleware.SecurityConfig          `json:"security,omitempty"`
 	Preview     category.PreviewConfig             `json:"preview,omitempty"`
+	// MiddlewareFactories creates custom middlewares by name, they are listed in Middlewares like
+	// the built-in ones; inherited from Registry.RegisterMiddlewareFactory
+	MiddlewareFactories map[string]middleware.Factory `json:"-"`
+	// MiddlewareConfigs holds the default configuration of custom middlewares by name, an entry
+	// (possibly empty) declares a middleware whose factory is registered later
+	MiddlewareConfigs map[string]map[string]interface{} `json:"middleware_configs,omitempty"`
+	// RateLimit holds the default limits of the ratelimit middleware
+	RateLimit middleware.RateLimitConfig `json:"rate_limit,omitempty"`
+	// Compression holds the default settings of the compression middleware
+	Compression middleware.CompressionConfig `json:"compression,omitempty"`
+	// ServerSideEncryption is the default MinIO server-side encryption for all categories
+	ServerSideEncryption category.SSEConfig `json:"server_side_encryption,omitempty"`
+	// URLBuilder configures the file and thumbnail URLs returned by uploads and file info
+	URLBuilder URLBuilderConfig `json:"url_builder,omitempty"`
+	// DownloadTokens configures signed download tokens served through the application's own endpoint
+	DownloadTokens DownloadTokenConfig `json:"download_tokens,omitempty"`
+	// Batch limits the size and concurrency of batch operations
+	Batch BatchConfig `json:"batch,omitempty"`
+	// Retry is the retry policy of MinIO object operations; inherited from the registry's RetryAttempts and RetryDelay when zero
+	Retry RetryConfig `json:"retry,omitempty"`
+	// CircuitBreaker fails backend calls fast with BACKEND_UNAVAILABLE after consecutive failures, disabled by default
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker,omitempty"`
+	// Bandwidth caps the transfer rates between the handler and MinIO, unlimited by default
+	Bandwidth BandwidthConfig `json:"bandwidth,omitempty"`
+	// Region of the buckets the handler creates; inherited from the registry when empty
+	Region string `json:"region,omitempty"`
+	// SkipBucketPolicies leaves bucket policies alone, e.g. when they are managed elsewhere; otherwise
+	// the files of IsPublic categories are made readable without credentials by a bucket policy
+	SkipBucketPolicies bool `json:"skip_bucket_policies,omitempty"`
+	// StatsCacheTTL is how long GetStorageStats results are cached, default 5 minutes, negative disables caching
+	StatsCacheTTL time.Duration `json:"stats_cache_ttl,omitempty"`
+	// Buffer is the buffering policy of upload data read more than once by middlewares, DefaultBufferConfig
+	// values when unset; it is set up once by Initialize
+	Buffer middleware.BufferConfig `json:"buffer,omitempty"`
+	// Cache configures the artifact cache shared by the cache middlewares of all categories,
+	// DefaultCacheConfig when MaxSize is unset; it is set up once by Initialize
+	Cache middleware.CacheConfig `json:"cache,omitempty"`
+	// Async configures the background job processor shared by all categories
+	Async middleware.AsyncConfig `json:"async,omitempty"`
+	// Webhooks receive signed event notifications (uploads, deletes, thumbnails, validation failures)
+	Webhooks []events.WebhookConfig `json:"webhooks,omitempty"`
+	// Events is an optional bus shared between handlers; a dedicated one is created when nil
+	Events *events.Bus `json:"-"`
+	// Authorizer decides file access for all categories without their own Security.Authorizer
+	// When nil the development authorizer is used, which derives roles from user ID prefixes
+	Authorizer middleware.Authorizer `json:"-"`
+	// DownloadCounter stores download counters shared by all categories, in-memory when nil
+	// Use middleware.NewRedisDownloadCounter to enforce MaxDownloadCount across instances
+	DownloadCounter middleware.DownloadCounter `json:"-"`
+	// KeyProvider enables envelope encryption in the encryption middleware: every object gets
+	// its own data key, wrapped by the provider (AWS KMS, Cloud KMS, Vault) and stored with the object
+	KeyProvider kms.KeyProvider `json:"-"`
+	// KeyResolver selects the KeyProvider master key per upload, e.g. per tenant or entity type
+	// The selected key is recorded with the file and must match again on download
+	KeyResolver middleware.KeyResolver `json:"-"`
+	// Secrets resolves EncryptionKeySecret and SSE-C CustomerKeySecret; inherited from the registry when nil
+	// Wrap providers in secrets.NewCache to pick up rotated secrets without reading them on every request
+	Secrets secrets.Provider `json:"-"`
+	// EncryptionKeySecret names the hex encoded AES-256 key of the encryption middleware when no KeyProvider is set
+	EncryptionKeySecret string `json:"encryption_key_secret,omitempty"`
+	// Tenants scopes requests to tenants (key prefix, bucket, limits); inherited from the registry when nil
+	// Requests carry their tenant in the context, see tenant.WithID
+	Tenants tenant.Resolver `json:"-"`
+	// Logger receives internal logs of the handler and its middlewares; inherited from the registry when nil
+	Logger logger.Logger `json:"-"`
 	// MetadataCallback provides a callback for storing file metadata after upload
-	// If not provided, metadata will only be stored in MinIO object metadata
+	// If not provided, metadata will only be stored in MinIO object metadata; failed calls are
+	// retried, see MetadataDelivery, so callbacks should be idempotent
 	MetadataCallback interfaces.MetadataCallback `json:"-"`
+	// MetadataUpdatedCallback is called with the record of a file after UpdateMetadata changed it
+	MetadataUpdatedCallback interfaces.MetadataCallback `json:"-"`
+	// DeleteCallback is called with the key of every deleted file, e.g. to remove it from an index
+	DeleteCallback interfaces.DeleteCallback `json:"-"`
+	// AccessCallback is called with every download, stream and preview of a file, e.g. to track usage
+	AccessCallback interfaces.AccessCallback `json:"-"`
+	// MetadataStore keeps a record per file, saved on upload and removed on delete
+	// Use metadata.NewMemoryStore for development; Registry.Reconcile compares it with the buckets
+	MetadataStore interfaces.MetadataStore `json:"-"`
+	// MetadataDelivery retries records the MetadataStore or the callbacks failed to take in the background
+	MetadataDelivery DeliveryConfig `json:"metadata_delivery,omitempty"`
+	// AccessLog records who downloaded, streamed or previewed which file, see GetAccessHistory
+	// Use metadata.NewMemoryAccessLog for development
+	AccessLog interfaces.AccessLog `json:"-"`
+	// Shares keeps the shares of files and entities, see Share; the security middleware grants
+	// them. Use metadata.NewMemoryShareStore for development
+	Shares interfaces.ShareStore `json:"-"`
+	// PublicLinks keeps revocable links to files, see CreatePublicLink
+	PublicLinks PublicLinkConfig `json:"public_links,omitempty"`
+	// Audit keeps the events of audit middlewares for QueryAudit, with a retention
+	Audit AuditLogConfig `json:"audit,omitempty"`
+	// Replication mirrors uploads, and optionally deletes, to a secondary backend in the background
+	Replication ReplicationConfig `json:"replication,omitempty"`
+	// Search indexes the text of uploaded documents in the background for Search, disabled without an Index
+	Search SearchConfig `json:"search,omitempty"`
+	// URLUpload limits the fetches of UploadFromURL
+	URLUpload URLUploadConfig `json:"url_upload,omitempty"`
+	// Staging keeps uploads made WithStaging out of sight until they are committed
+	Staging StagingConfig `json:"staging,omitempty"`
+	// DirectUploads lets clients upload new files straight to the backend, see PresignUpload
+	DirectUploads DirectUploadConfig `json:"direct_uploads,omitempty"`
+	// Scanner scans the uploads of categories accepting anonymous uploads for malware in the background,
+	// required by them; infected files are deleted
+	Scanner jobs.Scanner `json:"-"`
+	// Classifier labels the images and videos of categories with classification, required by them
+	Classifier jobs.Classifier `json:"-"`
+	// OCR recognizes the text of images and scanned documents of categories with OCR, required by them
+	// e.g. search.NewTesseractEngine
+	OCR search.OCREngine `json:"-"`
+	// Converter turns the office documents of categories converting documents into PDF previews,
+	// required by them; e.g. convert.NewGotenbergConverter
+	Converter convert.Converter `json:"-"`
+	// AudioAnalyzer extracts the properties and waveforms of the audio files of categories analyzing
+	// audio, required by them; e.g. media.NewFFmpegAnalyzer
+	AudioAnalyzer media.AudioAnalyzer `json:"-"`
+	// Transcoder produces the rendition ladders of the videos of categories transcoding videos,
+	// required by them; e.g. media.NewFFmpegTranscoder
+	Transcoder media.Transcoder `json:"-"`
+	// Watermarker draws the marks of categories watermarking files, required by them; e.g.
+	// watermark.Combine(watermark.NewImageWatermarker(...), watermark.NewPDFCPUWatermarker(...))
+	Watermarker watermark.Watermarker `json:"-"`
+}
+
+// DownloadTokenConfig represents signed download token configuration
+type DownloadTokenConfig struct {
+	Secret  []byte        `json:"-"`                  // HMAC key, tokens are disabled when empty
+	Expiry  time.Duration `json:"expiry,omitempty"`   // Default token lifetime, default 15 minutes
+	BaseURL string        `json:"base_url,omitempty"` // Application download endpoint, the token is appended as ?token=
+}
+
+// BatchConfig represents batch operation limits, zero values use the defaults
+type BatchConfig struct {
+	MaxUploadFiles int `json:"max_upload_files,omitempty"` // Files per BatchUpload, default 10
+	MaxGetFiles    int `json:"max_get_files,omitempty"`    // Files per BatchGet, default 20
+	MaxDeleteFiles int `json:"max_delete_files,omitempty"` // Files per BatchDelete, default 50
+	// Concurrency is the number of files a single batch processes at once, default 4
+	Concurrency int `json:"concurrency,omitempty"`
+	// MaxConcurrent is the number of batch files processed at once across all batches of the handler, default 16
+	MaxConcurrent int `json:"max_concurrent,omitempty"`
+}
+
+// withDefaults returns the limits with defaults for unset values
+func (c BatchConfig) withDefaults() BatchConfig {
+	if c.MaxUploadFiles <= 0 {
+		c.MaxUploadFiles = 10
+	}
+	if c.MaxGetFiles <= 0 {
+		c.MaxGetFiles = 20
+	}
+	if c.MaxDeleteFiles <= 0 {
+		c.MaxDeleteFiles = 50
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = 4
+	}
+	if c.MaxConcurrent <= 0 {
+		c.MaxConcurrent = 16
+	}
+	return c
+}
+
+// RetryConfig represents the retry policy of MinIO operations
+// Delays grow exponentially from InitialDelay with random jitter
+type RetryConfig struct {
+	Attempts     int           `json:"attempts,omitempty"`      // Retries after the first failure, 0 disables retries
+	InitialDelay time.Duration `json:"initial_delay,omitempty"` // Delay before the first retry, default 100ms
+	MaxDelay     time.Duration `json:"max_delay,omitempty"`     // Upper bound of delays, default 5s
+}
+
+// BandwidthConfig represents transfer rate limits in bytes per second, 0 means unlimited
+// Each limit is shared by all transfers of the handler it applies to
+type BandwidthConfig struct {
+	Upload   int64 `json:"upload,omitempty"`   // Uploads to MinIO
+	Download int64 `json:"download,omitempty"` // Downloads, streams and exports from MinIO
+	Total    int64 `json:"total,omitempty"`    // Uploads and downloads together
+}
+
+// withDefaults returns the policy with defaults for unset delays
+func (c RetryConfig) withDefaults() RetryConfig {
+	if c.InitialDelay <= 0 {
+		c.InitialDelay = 100 * time.Millisecond
+	}
+	if c.MaxDelay <= 0 {
+		c.MaxDelay = 5 * time.Second
+	}
+	if c.MaxDelay < c.InitialDelay {
+		c.MaxDelay = c.InitialDelay
+	}
+	return c
 }
 
 func DefaultHandlerConfig(basePath string) HandlerConfig {
@@ -43,10 +238,59 @@ func (c *HandlerConfig) Validate() error {
 		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "At least one category must be defined"}
 	}
 
+	if err := c.ServerSideEncryption.Validate(); err != nil {
+		return err
+	}
+	if err := c.Compression.Validate(); err != nil {
+		return err
+	}
+	if err := c.Security.Validate(); err != nil {
+		return err
+	}
+	if err := c.URLBuilder.Validate(); err != nil {
+		return err
+	}
+	if err := c.validateMiddlewares(c.Middlewares, nil); err != nil {
+		return err
+	}
+	if c.Retry.Attempts < 0 {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Retry attempts must be non-negative"}
+	}
+	if c.Bandwidth.Upload < 0 || c.Bandwidth.Download < 0 || c.Bandwidth.Total < 0 {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Bandwidth limits must be non-negative"}
+	}
+	if c.Buffer.MemoryThreshold < 0 || c.Buffer.MaxMemory < 0 {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Buffer sizes must be non-negative"}
+	}
+
 	for name, category := range c.Categories {
 		if err := category.Validate(); err != nil {
 			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " is invalid: " + err.Error()}
 		}
+		if err := c.validateMiddlewares(category.Middlewares, category.MiddlewareConfigs); err != nil {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " is invalid: " + err.Error()}
+		}
+		if category.Anonymous.Enabled && c.Scanner == nil {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " accepts anonymous uploads and requires a Scanner"}
+		}
+		if category.Classification.Enabled && c.Classifier == nil {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " classifies uploads and requires a Classifier"}
+		}
+		if category.OCR.Enabled && c.OCR == nil {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " recognizes text and requires an OCR engine"}
+		}
+		if category.Preview.ConvertDocuments && c.Converter == nil {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " converts documents and requires a Converter"}
+		}
+		if category.Audio.Enabled && c.AudioAnalyzer == nil {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " analyzes audio and requires an AudioAnalyzer"}
+		}
+		if category.Video.Transcode && c.Transcoder == nil {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " transcodes videos and requires a Transcoder"}
+		}
+		if category.Watermark.Enabled && c.Watermarker == nil {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " watermarks files and requires a Watermarker"}
+		}
 	}
 
 	return nil
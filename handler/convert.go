@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// DocumentPreviewKey returns the key of the PDF preview of a document: original_file_key_preview.pdf,
+// stored next to its thumbnails in the derived bucket of its category
+func DocumentPreviewKey(fileKey string) string {
+	return strings.TrimSuffix(fileKey, filepath.Ext(fileKey)) + "_preview.pdf"
+}
+
+// convertedPreview is the PDF preview of a document in its bucket
+type convertedPreview struct {
+	minio.ObjectInfo
+	bucketName string
+}
+
+// convertFile queues the PDF conversion of a stored document when the Converter supports it
+func (h *Handler) convertFile(ctx context.Context, bucketName, fileKey, contentType string) {
+	converter := h.config().Converter
+	if converter == nil || !converter.Supports(contentType) {
+		return
+	}
+	if err := h.SubmitJob(ctx, &jobs.Job{Type: jobs.TypeConvert, FileKey: fileKey, BucketName: bucketName}); err != nil {
+		h.logger.Warn("failed to queue document conversion", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
+
+// handleConvertJob converts a document with the Converter of the handler and stores the PDF as a
+// derived file. Documents encrypted by the encryption middleware are not converted, derived files
+// are stored unencrypted
+func (h *Handler) handleConvertJob(ctx context.Context, job *jobs.Job) error {
+	converter := h.config().Converter
+	if converter == nil {
+		return fmt.Errorf("no converter configured")
+	}
+
+	sse, err := h.keyServerSideEncryption(job.FileKey)
+	if err != nil {
+		return err
+	}
+	objInfo, err := h.statObject(ctx, job.BucketName, job.FileKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			// Deleted before its conversion
+			return nil
+		}
+		return fmt.Errorf("failed to stat object: %w", err)
+	}
+	if middleware.IsEncrypted(objInfo.UserMetadata) || !converter.Supports(objInfo.ContentType) {
+		job.Result = map[string]interface{}{"converted": false}
+		return nil
+	}
+
+	fileData, err := h.openFile(ctx, job.BucketName, &objInfo, objInfo.UserMetadata["Uploaded-By"], 0, middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size)-1)
+	if err != nil {
+		return err
+	}
+	pdf, err := converter.Convert(ctx, objInfo.ContentType, fileData)
+	if closer, ok := fileData.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to convert document: %w", err)
+	}
+	defer pdf.Close()
+
+	categoryName := h.fileKeyInfo(&objInfo).Category
+	h.configMutex.RLock()
+	categoryConfig := h.Config.Categories[categoryName]
+	bucketName := h.derivedBucket(categoryConfig)
+	storageClass := h.derivedStorageClass(categoryConfig)
+	h.configMutex.RUnlock()
+
+	previewKey := DocumentPreviewKey(job.FileKey)
+	info, err := h.putObject(ctx, bucketName, previewKey, pdf, -1, minio.PutObjectOptions{
+		ContentType:  "application/pdf",
+		StorageClass: storageClass,
+		UserMetadata: map[string]string{"source-etag": strings.Trim(objInfo.ETag, `"`)},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store preview of %s: %w", job.FileKey, err)
+	}
+	h.recordDerived(ctx, bucketName, job.FileKey, "", DerivedFile{Key: previewKey, Kind: DerivedPreview, Size: info.Size, CreatedAt: time.Now()})
+	// Cached preview URLs still point at the original
+	h.invalidate(ctx, job.FileKey)
+
+	job.Result = map[string]interface{}{"converted": true, "preview_key": previewKey, "preview_size": info.Size}
+	return nil
+}
+
+// documentPreview returns the PDF preview of a document of a category converting documents, nil
+// when it has none yet
+func (h *Handler) documentPreview(ctx context.Context, objInfo *minio.ObjectInfo) (*convertedPreview, error) {
+	categoryName := h.fileKeyInfo(objInfo).Category
+	h.configMutex.RLock()
+	categoryConfig := h.Config.Categories[categoryName]
+	bucketName := h.derivedBucket(categoryConfig)
+	h.configMutex.RUnlock()
+	if !categoryConfig.Preview.ConvertDocuments {
+		return nil, nil
+	}
+
+	previewInfo, err := h.statObject(ctx, bucketName, DocumentPreviewKey(objInfo.Key), minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to stat preview: %w", err)
+	}
+	return &convertedPreview{ObjectInfo: previewInfo, bucketName: bucketName}, nil
+}
+
+// deleteDerivedFiles deletes the derived files listed in the manifest of a file, then the PDF
+// preview, the waveform and the rendition ladder of files derived before manifests were kept
+func (h *Handler) deleteDerivedFiles(ctx context.Context, category, fileKey string) error {
+	h.configMutex.RLock()
+	categoryConfig := h.Config.Categories[category]
+	bucketName := h.derivedBucket(categoryConfig)
+	h.configMutex.RUnlock()
+
+	if err := h.deleteManifest(ctx, bucketName, fileKey); err != nil {
+		return err
+	}
+
+	var derivedKeys []string
+	if categoryConfig.Preview.ConvertDocuments {
+		derivedKeys = append(derivedKeys, DocumentPreviewKey(fileKey))
+	}
+	if categoryConfig.Audio.Enabled {
+		derivedKeys = append(derivedKeys, WaveformKey(fileKey))
+	}
+	for _, derivedKey := range derivedKeys {
+		if err := h.removeObject(ctx, bucketName, derivedKey, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("failed to delete derived file %s: %w", derivedKey, err)
+		}
+	}
+	if categoryConfig.Video.Transcode {
+		return h.deleteRenditions(ctx, bucketName, fileKey)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/jobs"
+)
+
+// Targets of deliveries
+const (
+	DeliveryStore           = "store"            // MetadataStore.Save
+	DeliveryStoreDelete     = "store_delete"     // MetadataStore.Delete
+	DeliveryCallback        = "callback"         // MetadataCallback
+	DeliveryUpdatedCallback = "updated_callback" // MetadataUpdatedCallback
+	DeliveryDeleteCallback  = "delete_callback"  // DeleteCallback
+	DeliveryAccessCallback  = "access_callback"  // AccessCallback
+)
+
+// Delivery represents a record passed to the metadata store or a callback
+type Delivery struct {
+	Target   string                   `json:"target"`
+	FileKey  string                   `json:"file_key"`
+	UserID   string                   `json:"user_id,omitempty"`  // User deleting the file, for DeliveryDeleteCallback
+	Metadata *interfaces.FileMetadata `json:"metadata,omitempty"` // Record of DeliveryStore and metadata callbacks
+	Access   *interfaces.AccessRecord `json:"access,omitempty"`   // Record of DeliveryAccessCallback
+}
+
+// DeliveryConfig represents the retries of deliveries the metadata store or a callback failed to
+// take. Retries are background jobs of the handler: with a durable jobs queue and store, e.g.
+// jobs.NewRedisQueue, pending retries survive restarts, so records are eventually stored
+type DeliveryConfig struct {
+	// Retries is the number of deliveries after the failed one, default 5; they are spaced by the
+	// retry delay of the job processor
+	Retries int `json:"retries,omitempty"`
+	// OnFailure is called with deliveries given up after the retries, e.g. to write them to a dead
+	// letter table
+	OnFailure func(ctx context.Context, delivery *Delivery, err error) `json:"-"`
+}
+
+// withDefaults returns the configuration with defaults for unset values
+func (c DeliveryConfig) withDefaults() DeliveryConfig {
+	if c.Retries <= 0 {
+		c.Retries = 5
+	}
+	return c
+}
+
+// deliverMetadata passes the record of a file to a metadata target, see deliver
+func (h *Handler) deliverMetadata(ctx context.Context, target string, metadata *interfaces.FileMetadata) {
+	h.deliver(ctx, &Delivery{Target: target, FileKey: metadata.FileKey, Metadata: metadata})
+}
+
+// deliver passes a record to its target, queueing retries when it fails
+// The change it records is made either way, so failures are not returned
+func (h *Handler) deliver(ctx context.Context, delivery *Delivery) {
+	err := h.send(ctx, delivery)
+	if err == nil {
+		return
+	}
+	h.logger.Warn("delivery failed, retrying in the background", map[string]interface{}{
+		"handler":  h.Name,
+		"file_key": delivery.FileKey,
+		"target":   delivery.Target,
+		"error":    err,
+	})
+
+	encoded, queueErr := json.Marshal(delivery)
+	if queueErr == nil {
+		queueErr = h.SubmitJob(ctx, &jobs.Job{
+			Type:        jobs.TypeMetadata,
+			FileKey:     delivery.FileKey,
+			Payload:     map[string]interface{}{"target": delivery.Target, "delivery": string(encoded)},
+			MaxAttempts: h.config().MetadataDelivery.withDefaults().Retries,
+		})
+	}
+	if queueErr != nil {
+		h.undelivered(ctx, delivery, fmt.Errorf("%w; failed to queue retry: %v", err, queueErr))
+	}
+}
+
+// send passes a record to its target, targets that are not configured take nothing
+func (h *Handler) send(ctx context.Context, delivery *Delivery) error {
+	config := h.config()
+	switch delivery.Target {
+	case DeliveryStore:
+		if config.MetadataStore != nil {
+			return config.MetadataStore.Save(ctx, delivery.Metadata)
+		}
+	case DeliveryStoreDelete:
+		if config.MetadataStore != nil {
+			return config.MetadataStore.Delete(ctx, delivery.FileKey)
+		}
+	case DeliveryCallback:
+		if config.MetadataCallback != nil {
+			return config.MetadataCallback(ctx, delivery.Metadata)
+		}
+	case DeliveryUpdatedCallback:
+		if config.MetadataUpdatedCallback != nil {
+			return config.MetadataUpdatedCallback(ctx, delivery.Metadata)
+		}
+	case DeliveryDeleteCallback:
+		if config.DeleteCallback != nil {
+			return config.DeleteCallback(ctx, delivery.FileKey, delivery.UserID)
+		}
+	case DeliveryAccessCallback:
+		if config.AccessCallback != nil {
+			return config.AccessCallback(ctx, delivery.Access)
+		}
+	default:
+		return fmt.Errorf("unknown delivery target %s", delivery.Target)
+	}
+	return nil
+}
+
+// handleMetadataJob retries a delivery, the last failed attempt gives it up
+func (h *Handler) handleMetadataJob(ctx context.Context, job *jobs.Job) error {
+	var delivery Delivery
+	if err := json.Unmarshal([]byte(job.String("delivery")), &delivery); err != nil {
+		return fmt.Errorf("failed to decode delivery of %s: %w", job.FileKey, err)
+	}
+
+	err := h.send(ctx, &delivery)
+	// Deliveries interrupted by a shutdown are retried once the processor runs again
+	if err != nil && job.Attempts >= job.MaxAttempts && ctx.Err() == nil {
+		h.undelivered(ctx, &delivery, err)
+	}
+	return err
+}
+
+// undelivered reports a delivery given up, to DeliveryConfig.OnFailure and the logs
+func (h *Handler) undelivered(ctx context.Context, delivery *Delivery, err error) {
+	h.logger.Error("delivery given up", map[string]interface{}{
+		"handler":  h.Name,
+		"file_key": delivery.FileKey,
+		"target":   delivery.Target,
+		"error":    err,
+	})
+	if onFailure := h.config().MetadataDelivery.OnFailure; onFailure != nil {
+		onFailure(ctx, delivery, err)
+	}
+}
@@ -0,0 +1,314 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// derivedManifestPrefix is the key prefix of the manifests of derived files, kept apart from the
+// keys of originals so listings of entities do not return them
+const derivedManifestPrefix = "_derived/"
+
+// Kinds of derived files
+const (
+	DerivedThumbnail = "thumbnail"
+	DerivedPreview   = "preview"   // PDF preview of a document
+	DerivedWaveform  = "waveform"  // Waveform JSON of an audio file
+	DerivedRendition = "rendition" // HLS rendition ladder of a video, recorded by its key prefix
+)
+
+// DerivedFile represents a file generated from an original
+type DerivedFile struct {
+	Key       string    `json:"key"` // Ends with "/" for kinds stored as several files, e.g. renditions
+	Kind      string    `json:"kind"`
+	Variant   string    `json:"variant,omitempty"` // e.g. the size of a thumbnail, the renditions of a ladder
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// DerivedManifest lists the derived files of an original, stored with them in the derived bucket
+// of its category
+type DerivedManifest struct {
+	FileKey   string        `json:"file_key"`
+	Bucket    string        `json:"bucket"`
+	Files     []DerivedFile `json:"files"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// DerivedManifestKey returns the key of the manifest of the derived files of a file
+func DerivedManifestKey(fileKey string) string {
+	return derivedManifestPrefix + fileKey + ".json"
+}
+
+// IsDerivedManifestKey reports whether a key is the key of a manifest of derived files
+func IsDerivedManifestKey(key string) bool {
+	return strings.HasPrefix(key, derivedManifestPrefix)
+}
+
+// contains reports whether a key is the derived file or one of its files
+func (f DerivedFile) contains(key string) bool {
+	if strings.HasSuffix(f.Key, "/") {
+		return strings.HasPrefix(key, f.Key) && key != f.Key
+	}
+	return key == f.Key
+}
+
+// DerivedFiles returns the manifest of the derived files of a file, without files for files that
+// have no derived files or only some derived before manifests were kept
+func (h *Handler) DerivedFiles(ctx context.Context, fileKey string) (*DerivedManifest, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	fileInfo, _, err := h.findFile(ctx, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	bucketName := h.fileDerivedBucket(fileInfo.(*minio.ObjectInfo))
+	manifest, err := h.derivedManifest(ctx, bucketName, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	if manifest == nil {
+		manifest = &DerivedManifest{FileKey: fileKey, Bucket: bucketName, Files: []DerivedFile{}}
+	}
+	return manifest, nil
+}
+
+// GetDerivedFile reads a derived file listed in the manifest of a file, or a file of a rendition
+// ladder listed there; access is decided by the original like previews
+func (h *Handler) GetDerivedFile(ctx context.Context, req *interfaces.DerivedFileRequest) (*interfaces.DownloadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	fileInfo, sourceBucket, err := h.findFile(ctx, req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+	objInfo := fileInfo.(*minio.ObjectInfo)
+	manifest, err := h.derivedManifest(ctx, h.fileDerivedBucket(objInfo), req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+	listed := false
+	if manifest != nil {
+		for _, file := range manifest.Files {
+			listed = listed || file.contains(req.Key)
+		}
+	}
+	if !listed {
+		return nil, &errors.StorageError{Code: "DERIVED_FILE_NOT_FOUND", Message: "Derived file not found"}
+	}
+
+	var resp *interfaces.DownloadResponse
+	err = h.runChain(ctx, h.chainRequest("preview", objInfo, sourceBucket, req.UserID), func(ctx context.Context) error {
+		object, derivedInfo, err := h.getObject(ctx, manifest.Bucket, req.Key, minio.GetObjectOptions{})
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return &errors.StorageError{Code: "DERIVED_FILE_NOT_FOUND", Message: "Derived file not found"}
+			}
+			return errors.Wrap(errors.ErrDownloadFailed, err)
+		}
+		if mark, marked := h.fileWatermark("preview", objInfo, derivedInfo.ContentType); marked {
+			data, contentType, err := h.watermarkFile(ctx, mark, req.FileKey, req.UserID, derivedInfo.ContentType, h.throttleDownload(ctx, object))
+			object.Close()
+			if err != nil {
+				return err
+			}
+			derivedInfo.Size, derivedInfo.ContentType = int64(len(data)), contentType
+			resp = derivedResponse(req, derivedInfo, bytes.NewReader(data))
+			return nil
+		}
+		resp = derivedResponse(req, derivedInfo, h.throttleDownload(ctx, object))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// derivedResponse returns the download response of a derived file
+func derivedResponse(req *interfaces.DerivedFileRequest, derivedInfo minio.ObjectInfo, data io.Reader) *interfaces.DownloadResponse {
+	return &interfaces.DownloadResponse{
+		Success:     true,
+		FileData:    data,
+		FileSize:    derivedInfo.Size,
+		ContentType: derivedInfo.ContentType,
+		Metadata: map[string]interface{}{
+			"file_name":    req.Key,
+			"original_key": req.FileKey,
+			"uploaded_at":  derivedInfo.LastModified,
+			"content_type": derivedInfo.ContentType,
+		},
+	}
+}
+
+// fileDerivedBucket returns the derived bucket of the category of a stored file
+func (h *Handler) fileDerivedBucket(objInfo *minio.ObjectInfo) string {
+	categoryName := h.fileKeyInfo(objInfo).Category
+	h.configMutex.RLock()
+	defer h.configMutex.RUnlock()
+	return h.derivedBucket(h.Config.Categories[categoryName])
+}
+
+// derivedManifest reads the manifest of the derived files of a file, nil when it has none
+func (h *Handler) derivedManifest(ctx context.Context, bucketName, fileKey string) (*DerivedManifest, error) {
+	object, _, err := h.getObject(ctx, bucketName, DerivedManifestKey(fileKey), minio.GetObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get derived files of %s: %w", fileKey, err)
+	}
+	defer object.Close()
+
+	var manifest DerivedManifest
+	if err := json.NewDecoder(object).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode derived files of %s: %w", fileKey, err)
+	}
+	return &manifest, nil
+}
+
+// recordDerived adds derived files stored in a bucket to the manifest of their original, replacing
+// those with the same key and, when replaceKind is set, all files of that kind
+// The files are stored already, so failures are only logged; cleanup falls back to key conventions
+func (h *Handler) recordDerived(ctx context.Context, bucketName, fileKey, replaceKind string, files ...DerivedFile) {
+	err := h.updateManifest(ctx, bucketName, fileKey, replaceKind, files)
+	if err != nil {
+		h.logger.Warn("failed to record derived files", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
+
+// updateManifest changes the manifest of a file, see recordDerived
+func (h *Handler) updateManifest(ctx context.Context, bucketName, fileKey, replaceKind string, files []DerivedFile) error {
+	h.derivedMutex.Lock()
+	defer h.derivedMutex.Unlock()
+
+	manifest, err := h.derivedManifest(ctx, bucketName, fileKey)
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		manifest = &DerivedManifest{FileKey: fileKey, Bucket: bucketName}
+	}
+
+	kept := make([]DerivedFile, 0, len(manifest.Files)+len(files))
+	for _, existing := range manifest.Files {
+		if existing.Kind == replaceKind {
+			continue
+		}
+		replaced := false
+		for _, file := range files {
+			replaced = replaced || file.Key == existing.Key
+		}
+		if !replaced {
+			kept = append(kept, existing)
+		}
+	}
+	manifest.Files = append(kept, files...)
+	manifest.UpdatedAt = time.Now()
+
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode derived files: %w", err)
+	}
+	_, err = h.putObject(ctx, bucketName, DerivedManifestKey(fileKey), bytes.NewReader(encoded), int64(len(encoded)), minio.PutObjectOptions{
+		ContentType: "application/json",
+	})
+	return err
+}
+
+// deleteManifest deletes the derived files listed in the manifest of a file and the manifest
+func (h *Handler) deleteManifest(ctx context.Context, bucketName, fileKey string) error {
+	h.derivedMutex.Lock()
+	defer h.derivedMutex.Unlock()
+
+	manifest, err := h.derivedManifest(ctx, bucketName, fileKey)
+	if err != nil || manifest == nil {
+		return err
+	}
+	for _, file := range manifest.Files {
+		if err := h.removeDerived(ctx, bucketName, file); err != nil {
+			return err
+		}
+	}
+	return h.removeObject(ctx, bucketName, DerivedManifestKey(fileKey), minio.RemoveObjectOptions{})
+}
+
+// removeDerived deletes a derived file, all of its files when it is stored as several
+func (h *Handler) removeDerived(ctx context.Context, bucketName string, file DerivedFile) error {
+	if !strings.HasSuffix(file.Key, "/") {
+		if err := h.removeObject(ctx, bucketName, file.Key, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("failed to delete derived file %s: %w", file.Key, err)
+		}
+		return nil
+	}
+
+	// Stop listing on the first error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := h.Client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: file.Key, Recursive: true})
+	for object := range objects {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list derived files of %s: %w", file.Key, object.Err)
+		}
+		if err := h.removeObject(ctx, bucketName, object.Key, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("failed to delete derived file %s: %w", object.Key, err)
+		}
+	}
+	return nil
+}
+
+// recordThumbnails adds the thumbnails of a finished thumbnail job to the manifest of their original
+// Sizes generated before are kept, regenerations may backfill only some
+func (h *Handler) recordThumbnails(ctx context.Context, status *middleware.ThumbnailStatus) {
+	if len(status.Thumbnails) == 0 || status.Bucket == "" {
+		return
+	}
+	files := make([]DerivedFile, 0, len(status.Thumbnails))
+	for _, thumbnail := range status.Thumbnails {
+		files = append(files, DerivedFile{
+			Key:       middleware.ThumbnailKey(status.FileKey, thumbnail.Size),
+			Kind:      DerivedThumbnail,
+			Variant:   thumbnail.Size,
+			Size:      thumbnail.FileSize,
+			CreatedAt: status.UpdatedAt,
+		})
+	}
+	h.recordDerived(ctx, status.Bucket, status.FileKey, "", files...)
+}
+
+// deleteManifests deletes the manifests of the files under a key prefix from the derived buckets
+// Failures are only logged, the derived files they list are deleted already
+func (h *Handler) deleteManifests(ctx context.Context, prefix string) {
+	for _, bucketName := range h.DerivedBuckets() {
+		if err := h.removeDerived(ctx, bucketName, DerivedFile{Key: derivedManifestPrefix + prefix}); err != nil {
+			h.logger.Warn("failed to delete manifests of derived files", map[string]interface{}{
+				"handler": h.Name,
+				"prefix":  prefix,
+				"error":   err,
+			})
+		}
+	}
+}
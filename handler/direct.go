@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/requestid"
+	"github.com/darmawan01/storage/tenant"
+	"github.com/minio/minio-go/v7"
+)
+
+// DirectUploadConfig represents uploads clients send straight to the backend with PresignUpload
+// Direct uploads land in an incoming area and are processed like uploads through the handler,
+// middlewares included, once ConfirmUpload is called or the backend reports them
+type DirectUploadConfig struct {
+	Enabled bool   `json:"enabled"`
+	Prefix  string `json:"prefix,omitempty"` // Key prefix of uploads awaiting processing, default "incoming/"
+	// ExpiryDays is the age after which uploads never processed are deleted by a bucket lifecycle
+	// rule, default 1
+	ExpiryDays int `json:"expiry_days,omitempty"`
+	// Notifications processes uploads to the handler bucket as background jobs once MinIO reports
+	// them, without waiting for ConfirmUpload; read by Initialize. Uploads made while no handler
+	// listens still need ConfirmUpload, as do uploads to buckets of tenants
+	Notifications bool `json:"notifications,omitempty"`
+	// ManifestSecret is the HMAC key of the completion tokens of upload manifests, manifests are
+	// disabled when empty; see CreateUploadManifest
+	ManifestSecret []byte `json:"-"`
+}
+
+// withDefaults returns the configuration with defaults for unset values
+func (c DirectUploadConfig) withDefaults() DirectUploadConfig {
+	if c.Prefix == "" {
+		c.Prefix = "incoming/"
+	}
+	if c.ExpiryDays <= 0 {
+		c.ExpiryDays = 1
+	}
+	return c
+}
+
+// DirectUploadPrefix returns the key prefix of direct uploads awaiting processing, empty when
+// direct uploads are disabled
+func (h *Handler) DirectUploadPrefix() string {
+	config := h.config().DirectUploads
+	if !config.Enabled {
+		return ""
+	}
+	return config.withDefaults().Prefix
+}
+
+// PresignUpload returns a PUT URL uploading a new file straight to the backend, without passing
+// its data through the application. The file is processed like an Upload, validation,
+// thumbnails and callbacks included, when ConfirmUpload is called with the returned key, or
+// in the background when DirectUploadConfig.Notifications is set; until then it is not visible
+func (h *Handler) PresignUpload(ctx context.Context, req *interfaces.DirectUploadRequest) (*interfaces.DirectUploadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	prefix := h.DirectUploadPrefix()
+	if prefix == "" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Direct uploads are not enabled for handler " + h.Name}
+	}
+	if req.Category == "" || req.EntityType == "" || req.EntityID == "" || req.FileName == "" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Category, entity and file name are required"}
+	}
+	categoryConfig, _, exists := h.category(req.Category)
+	if !exists {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Unknown category " + req.Category}
+	}
+	// Processing runs without the client, whose address limits anonymous uploads
+	if categoryConfig.Anonymous.Enabled && req.UserID == "" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Direct uploads of anonymous categories require a user"}
+	}
+	limits, err := h.categoryUploadLimits(ctx, req.Category)
+	if err != nil {
+		return nil, err
+	}
+	if len(limits.types) > 0 && !slices.Contains(limits.types, req.ContentType) {
+		return nil, &errors.StorageError{Code: errors.ErrUnsupportedType.Code, Message: "Content type " + req.ContentType + " is not allowed"}
+	}
+
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bucketName, err := h.requestBucket(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	fileKey := h.GenerateFileKey(req.EntityType, req.EntityID, req.Category, req.FileName)
+	if t != nil {
+		fileKey = t.Key(fileKey)
+	}
+	sse, err := h.serverSideEncryption(req.Category)
+	if err != nil {
+		return nil, err
+	}
+
+	// The metadata is signed into the URL, so processing knows the upload without a record of it
+	headers := encryptionHeaders(sse, http.MethodPut)
+	if req.ContentType != "" {
+		headers.Set("Content-Type", req.ContentType)
+	}
+	headers.Set("X-Amz-Meta-Original-Filename", req.FileName)
+	headers.Set("X-Amz-Meta-Category", req.Category)
+	headers.Set("X-Amz-Meta-Entity-Type", req.EntityType)
+	headers.Set("X-Amz-Meta-Entity-Id", req.EntityID)
+	headers.Set("X-Amz-Meta-Uploaded-By", req.UserID)
+	headers.Set("X-Amz-Tagging", expiryTag+"="+strconv.Itoa(h.config().DirectUploads.withDefaults().ExpiryDays))
+
+	expires := req.Expires
+	if expires <= 0 {
+		expires = 15 * time.Minute
+	}
+	expiresAt := time.Now().Add(expires)
+	presignedURL, err := h.Client.PresignHeader(ctx, http.MethodPut, bucketName, prefix+fileKey, expires, nil, headers)
+	if err != nil {
+		return nil, errors.Wrap(errors.ErrUploadFailed, err)
+	}
+
+	return &interfaces.DirectUploadResponse{
+		Success:   true,
+		FileKey:   fileKey,
+		URL:       presignedURL.String(),
+		Headers:   flattenHeaders(headers),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// ConfirmUpload processes a file uploaded with a URL of PresignUpload: the middlewares of its
+// category run as for uploads, then the file is moved to its key and announced. Files the
+// middlewares reject are deleted and reported in the response like rejected uploads; failures
+// keep the upload for another attempt. Files processed already are reported as stored
+func (h *Handler) ConfirmUpload(ctx context.Context, fileKey string, opts ...Option) (*interfaces.UploadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	prefix := h.DirectUploadPrefix()
+	if prefix == "" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Direct uploads are not enabled for handler " + h.Name}
+	}
+	options := callOptions(opts)
+	ctx, cancel := callContext(ctx, options)
+	defer cancel()
+
+	bucketName, err := h.locateFile(ctx, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	sse, err := h.keyServerSideEncryption(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	incomingKey := prefix + fileKey
+	objInfo, err := h.statObject(ctx, bucketName, incomingKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			return nil, errors.Wrap(errors.ErrDownloadFailed, err)
+		}
+		// Processed by an earlier call, or never uploaded
+		stored, err := h.statObject(ctx, bucketName, fileKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return nil, errors.ErrFileNotFound
+			}
+			return nil, errors.Wrap(errors.ErrDownloadFailed, err)
+		}
+		return &interfaces.UploadResponse{
+			Success:     true,
+			FileKey:     fileKey,
+			FileSize:    stored.Size,
+			ContentType: stored.ContentType,
+			RequestID:   requestid.FromContext(ctx),
+		}, nil
+	}
+
+	// The upload gets the key it was presigned for, which carries the tenant prefix already
+	key := fileKey
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		key = strings.TrimPrefix(fileKey, t.KeyPrefix+"/")
+	}
+	resp, err := h.Import(ctx, ImportSource{Bucket: bucketName, Key: incomingKey}, &interfaces.UploadRequest{
+		FileName:    objInfo.UserMetadata["Original-Filename"],
+		ContentType: objInfo.ContentType,
+		Category:    objInfo.UserMetadata["Category"],
+		EntityType:  objInfo.UserMetadata["Entity-Type"],
+		EntityID:    objInfo.UserMetadata["Entity-Id"],
+		UserID:      objInfo.UserMetadata["Uploaded-By"],
+	}, append(opts, WithFileKey(key))...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := h.removeObject(ctx, bucketName, incomingKey, minio.RemoveObjectOptions{}); err != nil {
+		// The upload expires with the incoming area
+		h.logger.Warn("failed to remove direct upload", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+	return resp, nil
+}
+
+// handleDirectJob processes a direct upload reported by a bucket notification
+func (h *Handler) handleDirectJob(ctx context.Context, job *jobs.Job) error {
+	if tenants := h.config().Tenants; tenants != nil {
+		if owner := tenants.Owner(job.FileKey); owner != nil {
+			ctx = tenant.WithID(ctx, owner.ID)
+		}
+	}
+	resp, err := h.ConfirmUpload(ctx, job.FileKey)
+	if err != nil {
+		if stderrors.Is(err, errors.ErrFileNotFound) {
+			// Expired, or processed by an earlier job
+			return nil
+		}
+		return err
+	}
+	job.Result = map[string]interface{}{"stored": resp.Success}
+	return nil
+}
+
+// listenDirectUploads submits a job for every direct upload MinIO reports in the handler bucket,
+// until the handler is closed
+func (h *Handler) listenDirectUploads(prefix string) {
+	ctx, cancel := context.WithCancel(h.abortCtx)
+	h.stopDirectUploads = cancel
+
+	go func() {
+		for ctx.Err() == nil {
+			for info := range h.Client.ListenBucketNotification(ctx, h.BucketName, prefix, "", []string{"s3:ObjectCreated:*"}) {
+				if info.Err != nil {
+					h.logger.Warn("bucket notifications failed, reconnecting", map[string]interface{}{
+						"handler": h.Name,
+						"error":   info.Err,
+					})
+					continue
+				}
+				for _, record := range info.Records {
+					key, err := url.QueryUnescape(record.S3.Object.Key)
+					if err != nil || !strings.HasPrefix(key, prefix) {
+						continue
+					}
+					fileKey := strings.TrimPrefix(key, prefix)
+					err = h.SubmitJob(ctx, &jobs.Job{Type: jobs.TypeDirect, FileKey: fileKey, BucketName: h.BucketName})
+					if err != nil {
+						h.logger.Warn("failed to queue direct upload", map[string]interface{}{
+							"handler":  h.Name,
+							"file_key": fileKey,
+							"error":    err,
+						})
+					}
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(5 * time.Second):
+			}
+		}
+	}()
+}
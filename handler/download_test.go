@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/darmawan01/storage/category"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// fakeBackend serves a single object and accepts every bucket operation, counting object requests
+type fakeBackend struct {
+	data     []byte
+	requests atomic.Int64
+}
+
+func (b *fakeBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.Count(strings.Trim(r.URL.Path, "/"), "/") == 0 {
+		// Bucket operations, e.g. the bucket creation of Initialize
+		if _, ok := r.URL.Query()["location"]; ok {
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><LocationConstraint xmlns="http://s3.amazonaws.com/doc/2006-03-01/">us-east-1</LocationConstraint>`))
+		}
+		return
+	}
+
+	b.requests.Add(1)
+	header := w.Header()
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("Content-Length", strconv.Itoa(len(b.data)))
+	header.Set("ETag", `"0123456789abcdef0123456789abcdef"`)
+	header.Set("Last-Modified", time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+	header.Set("X-Amz-Meta-Category", "files")
+	header.Set("X-Amz-Meta-Uploaded-By", "user-1")
+	if r.Method == http.MethodGet {
+		w.Write(b.data)
+	}
+}
+
+// newBenchmarkHandler returns a handler of the category "files" backed by backend, with the
+// artifact cache when cache is set
+func newBenchmarkHandler(b *testing.B, backend *fakeBackend, middlewares []string, cache bool) *Handler {
+	server := httptest.NewServer(backend)
+	b.Cleanup(server.Close)
+
+	endpoint, _ := url.Parse(server.URL)
+	client, err := minio.New(endpoint.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4("access", "secret", ""),
+		Region: "us-east-1",
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	config := &HandlerConfig{
+		Middlewares:        middlewares,
+		Categories:         map[string]category.CategoryConfig{"files": {}},
+		Region:             "us-east-1",
+		SkipBucketPolicies: true,
+	}
+	if cache {
+		config.Cache = middleware.DefaultCacheConfig()
+	}
+	h := &Handler{
+		Name:       "benchmark",
+		Client:     client,
+		BucketName: "benchmark",
+		Config:     config,
+	}
+	if err := h.Initialize(); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { h.Close(context.Background()) })
+	return h
+}
+
+// BenchmarkDownload compares downloads of the default configuration, which state files before
+// requesting them, with downloads of files whose stat is cached, which take a single request to
+// the backend. The security middleware authorizes both on the stat, without stating files again
+func BenchmarkDownload(b *testing.B) {
+	for _, bench := range []struct {
+		name        string
+		middlewares []string
+		cache       bool
+	}{
+		{"stat_and_get", nil, false},
+		{"secured_stat_and_get", []string{"security"}, false},
+		{"single_request", []string{"security", "cache"}, true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			backend := &fakeBackend{data: make([]byte, 64*1024)}
+			h := newBenchmarkHandler(b, backend, bench.middlewares, bench.cache)
+			req := &interfaces.DownloadRequest{FileKey: "files/report.bin", UserID: "user-1"}
+
+			backend.requests.Store(0)
+			b.SetBytes(int64(len(backend.data)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := h.Download(context.Background(), req)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, resp.FileData); err != nil {
+					b.Fatal(err)
+				}
+				if closer, ok := resp.FileData.(interface{ Close() error }); ok {
+					closer.Close()
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(backend.requests.Load())/float64(b.N), "requests/op")
+		})
+	}
+}
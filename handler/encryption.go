@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// encryptionMiddleware returns the encryption middleware of a category
+func (h *Handler) encryptionMiddleware(categoryName string) (*middleware.EncryptionMiddleware, error) {
+	if _, chain, exists := h.category(categoryName); exists {
+		for _, m := range chain.Middlewares() {
+			if encryption, ok := m.(*middleware.EncryptionMiddleware); ok {
+				return encryption, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no encryption middleware configured for category %s", categoryName)
+}
+
+// decryptedObject decrypts an object while it is read; closing it closes the object
+type decryptedObject struct {
+	io.Reader
+	io.Closer
+}
+
+// decryptObject returns the plaintext bytes [start, end] of a file encrypted by the encryption middleware
+// object must hold the stored range given by middleware.EncryptedRange, or the whole object
+func (h *Handler) decryptObject(ctx context.Context, object io.ReadCloser, objInfo *minio.ObjectInfo, userID string, start, end int64) (io.Reader, error) {
+	userMetadata := objInfo.UserMetadata
+	encryption, err := h.encryptionMiddleware(userMetadata["Category"])
+	if err != nil {
+		return nil, err
+	}
+
+	// The key resolver sees the same request fields as on upload
+	if err := encryption.CheckKey(ctx, &middleware.StorageRequest{
+		Operation:   "download",
+		FileKey:     objInfo.Key,
+		ContentType: objInfo.ContentType,
+		Category:    userMetadata["Category"],
+		EntityType:  userMetadata["Entity-Type"],
+		EntityID:    userMetadata["Entity-Id"],
+		UserID:      userID,
+	}, userMetadata); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := encryption.DecryptRange(ctx, object, userMetadata, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt file: %w", err)
+	}
+	return decryptedObject{Reader: plaintext, Closer: object}, nil
+}
+
+// RotateEncryptionKey rewraps the data key of an envelope encrypted file with the current master key
+// Only the object metadata is rewritten; the file data keeps its data key and is not re-encrypted
+func (h *Handler) RotateEncryptionKey(ctx context.Context, fileKey string) error {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	fileInfo, bucketName, err := h.findFile(ctx, fileKey)
+	if err != nil {
+		return err
+	}
+	objInfo := fileInfo.(*minio.ObjectInfo)
+
+	encryption, err := h.encryptionMiddleware(objInfo.UserMetadata["Category"])
+	if err != nil {
+		return err
+	}
+	rewrapped, err := encryption.RewrapKey(ctx, objInfo.UserMetadata)
+	if err != nil {
+		return fmt.Errorf("failed to rotate encryption key of %s: %w", fileKey, err)
+	}
+
+	// Metadata is replaced as a whole, so carry over everything else
+	userMetadata := make(map[string]string, len(objInfo.UserMetadata)+1)
+	for key, value := range objInfo.UserMetadata {
+		userMetadata[key] = value
+	}
+	for key, value := range rewrapped {
+		delete(userMetadata, http.CanonicalHeaderKey(key))
+		userMetadata[key] = value
+	}
+	userMetadata["Content-Type"] = objInfo.ContentType
+
+	sse, err := h.keyServerSideEncryption(fileKey)
+	if err != nil {
+		return err
+	}
+	source := sse
+	if source != nil && source.Type() != encrypt.SSEC {
+		source = nil
+	}
+
+	_, err = h.Client.CopyObject(ctx,
+		minio.CopyDestOptions{
+			Bucket:          bucketName,
+			Object:          fileKey,
+			Encryption:      sse,
+			ReplaceMetadata: true,
+			UserMetadata:    userMetadata,
+		},
+		minio.CopySrcOptions{
+			Bucket:     bucketName,
+			Object:     fileKey,
+			Encryption: source,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to rotate encryption key of %s: %w", fileKey, err)
+	}
+	h.invalidate(ctx, fileKey)
+	return nil
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+// FileKeyInfo represents the parts of a file key generated by GenerateFileKey
+type FileKeyInfo struct {
+	TenantPrefix string `json:"tenant_prefix,omitempty"`
+	EntityType   string `json:"entity_type"`
+	EntityID     string `json:"entity_id"`
+	Category     string `json:"category"`
+	Name         string `json:"name"` // {unix time}_{uuid}{ext}
+}
+
+// ParseFileKey splits a file key generated by GenerateFileKey into its parts, the key prefix of its
+// tenant is removed first. Entity IDs may contain slashes; keys of other shapes, e.g. of thumbnails,
+// are rejected
+func (h *Handler) ParseFileKey(fileKey string) (*FileKeyInfo, error) {
+	info := &FileKeyInfo{}
+	key := fileKey
+	if tenants := h.config().Tenants; tenants != nil {
+		if t := tenants.Owner(fileKey); t != nil {
+			info.TenantPrefix = t.KeyPrefix
+			key = strings.TrimPrefix(fileKey, t.KeyPrefix+"/")
+		}
+	}
+
+	parts := strings.Split(key, "/")
+	if len(parts) < 4 || !generatedName(parts[len(parts)-1]) {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Invalid file key " + fileKey}
+	}
+	for _, part := range parts {
+		if part == "" {
+			return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Invalid file key " + fileKey}
+		}
+	}
+
+	info.EntityType = parts[0]
+	info.EntityID = strings.Join(parts[1:len(parts)-2], "/")
+	info.Category = parts[len(parts)-2]
+	info.Name = parts[len(parts)-1]
+	return info, nil
+}
+
+// generatedName reports whether the last segment of a key is {unix time}_{uuid}{ext}
+func generatedName(name string) bool {
+	timestamp, rest, found := strings.Cut(name, "_")
+	if !found {
+		return false
+	}
+	if _, err := strconv.ParseInt(timestamp, 10, 64); err != nil {
+		return false
+	}
+	_, err := uuid.Parse(strings.TrimSuffix(rest, filepath.Ext(rest)))
+	return err == nil
+}
+
+// fileKeyInfo returns the category and entity of a stored file from its metadata, falling back to
+// its key for objects stored without them, e.g. copied in by other tools
+func (h *Handler) fileKeyInfo(objInfo *minio.ObjectInfo) *FileKeyInfo {
+	info := &FileKeyInfo{
+		Category:   objInfo.UserMetadata["Category"],
+		EntityType: objInfo.UserMetadata["Entity-Type"],
+		EntityID:   objInfo.UserMetadata["Entity-Id"],
+	}
+	if info.Category != "" {
+		return info
+	}
+	if parsed, err := h.ParseFileKey(objInfo.Key); err == nil {
+		return parsed
+	}
+	return info
+}
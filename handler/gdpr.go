@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/events"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// UserFiles returns the records of the files a user uploaded, e.g. for GDPR data exports
+// Records come from the MetadataStore when there is one, otherwise the bucket is listed and
+// the uploaded-by metadata of every object is checked; requests of a tenant only see its files
+func (h *Handler) UserFiles(ctx context.Context, userID string) ([]interfaces.FileMetadata, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	return h.userFiles(ctx, userID)
+}
+
+// userFiles returns the records of the files a user uploaded
+func (h *Handler) userFiles(ctx context.Context, userID string) ([]interfaces.FileMetadata, error) {
+	if userID == "" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "User ID is required"}
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix := ""
+	if t != nil {
+		prefix = t.Key(prefix)
+	}
+
+	files := []interfaces.FileMetadata{}
+	if store := h.config().MetadataStore; store != nil {
+		// Stores may be shared between handlers
+		err := store.List(ctx, prefix, func(record *interfaces.FileMetadata) error {
+			if record.UploadedBy == userID && (record.Namespace == "" || record.Namespace == h.Name) {
+				files = append(files, *record)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list files of user %s: %w", userID, err)
+		}
+		return files, nil
+	}
+
+	// Stop listing on the first error
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	bucketName := h.tenantBucket(t)
+	objects := h.Client.ListObjects(listCtx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithMetadata: true})
+	for object := range objects {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list files of user %s: %w", userID, object.Err)
+		}
+		if middleware.ThumbnailOriginalKeys(object.Key) != nil || IsDerivedManifestKey(object.Key) || IsAuditAnchorKey(object.Key) {
+			continue
+		}
+		objInfo, _, err := h.listedObject(ctx, bucketName, object, false)
+		if err != nil {
+			return nil, err
+		}
+		if objInfo.UserMetadata["Uploaded-By"] == userID {
+			files = append(files, *objectRecord(objInfo))
+		}
+	}
+	return files, nil
+}
+
+// ReadFile opens the plain content of a file, decrypted and decompressed, for data exports
+// Unlike Download it neither counts a download nor adds to the access history
+// The returned size is the number of bytes the reader yields
+func (h *Handler) ReadFile(ctx context.Context, fileKey string) (io.ReadCloser, int64, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer done()
+
+	fileInfo, bucketName, err := h.findFile(ctx, fileKey)
+	if err != nil {
+		return nil, 0, err
+	}
+	objInfo := fileInfo.(*minio.ObjectInfo)
+	fileSize := middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size)
+	if fileSize == 0 {
+		return io.NopCloser(strings.NewReader("")), 0, nil
+	}
+
+	fileData, err := h.openFile(ctx, bucketName, objInfo, objInfo.UserMetadata["Uploaded-By"], 0, fileSize-1)
+	if err != nil {
+		return nil, 0, err
+	}
+	if closer, ok := fileData.(io.ReadCloser); ok {
+		return closer, fileSize, nil
+	}
+	return io.NopCloser(fileData), fileSize, nil
+}
+
+// EraseUserData deletes all files a user uploaded with their thumbnails, metadata records and
+// access history, e.g. for GDPR erasure requests, and the shares granted to the user and records
+// of the user's reads of other files (with an interfaces.AccessLogEraser). Erasures are not deletes
+// of the user, so they skip the middleware chain and its role rules, authorizer and rate limits.
+// An events.TypeUserDataErased event records the erasure. Returns the number of files deleted,
+// which is less than found when deletes failed
+func (h *Handler) EraseUserData(ctx context.Context, userID string) (int, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+
+	files, err := h.userFiles(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	erased := 0
+	var failed []string
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return erased, err
+		}
+		fileInfo, bucketName, err := h.findFile(ctx, file.FileKey)
+		if err == nil {
+			objInfo := fileInfo.(*minio.ObjectInfo)
+			err = h.removeFile(ctx, bucketName, h.fileKeyInfo(objInfo).Category, file.FileKey, userID)
+		}
+		if stderrors.Is(err, errors.ErrFileNotFound) {
+			// Records of files deleted before are removed all the same
+			h.deleted(ctx, file.FileKey, userID)
+			err = nil
+		}
+		if err != nil {
+			h.logger.Warn("failed to erase file", map[string]interface{}{
+				"handler":  h.Name,
+				"file_key": file.FileKey,
+				"error":    err,
+			})
+			failed = append(failed, file.FileKey)
+			continue
+		}
+		erased++
+	}
+	recordsErr := h.forgetUser(ctx, userID)
+	if recordsErr != nil {
+		h.logger.Warn("failed to erase access and share records", map[string]interface{}{
+			"handler": h.Name,
+			"user_id": userID,
+			"error":   recordsErr,
+		})
+	}
+
+	h.logger.Info("erased user data", map[string]interface{}{
+		"handler": h.Name,
+		"user_id": userID,
+		"files":   erased,
+		"failed":  len(failed),
+	})
+	h.publish(ctx, events.TypeUserDataErased, "", "", userID, map[string]interface{}{
+		"files":  erased,
+		"failed": failed,
+	})
+
+	if len(failed) > 0 {
+		return erased, &errors.StorageError{
+			Code:    errors.ErrDeleteFailed.Code,
+			Message: fmt.Sprintf("Failed to erase %d of %d files", len(failed), len(files)),
+			Details: strings.Join(failed, ", "),
+		}
+	}
+	if recordsErr != nil {
+		return erased, errors.Wrap(errors.ErrDeleteFailed, recordsErr)
+	}
+	return erased, nil
+}
+
+// forgetUser removes the shares granted to a user and the records of the user's reads, of the
+// files of the request's tenant
+func (h *Handler) forgetUser(ctx context.Context, userID string) error {
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	prefix := ""
+	if t != nil {
+		prefix = t.Key(prefix)
+	}
+
+	config := h.config()
+	if config.Shares != nil {
+		shares, err := config.Shares.List(ctx, interfaces.ShareQuery{Prefix: prefix, Grantee: userID})
+		if err != nil {
+			return fmt.Errorf("failed to list shares of user %s: %w", userID, err)
+		}
+		for _, share := range shares {
+			if err := config.Shares.Delete(ctx, share.ID); err != nil {
+				return fmt.Errorf("failed to delete share %s: %w", share.ID, err)
+			}
+		}
+	}
+	if eraser, ok := config.AccessLog.(interfaces.AccessLogEraser); ok {
+		if err := eraser.DeleteUser(ctx, prefix, userID); err != nil {
+			return fmt.Errorf("failed to delete access records of user %s: %w", userID, err)
+		}
+	}
+	return nil
+}
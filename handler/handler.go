@@ -1,51 +1,280 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
+	"mime"
+	"net/http"
 	"net/url"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/darmawan01/storage/category"
+	"github.com/darmawan01/storage/clientinfo"
 	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/events"
 	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/logger"
+	"github.com/darmawan01/storage/media"
 	"github.com/darmawan01/storage/middleware"
+	"github.com/darmawan01/storage/requestid"
 	"github.com/google/uuid"
 	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"golang.org/x/sync/semaphore"
 )
 
 // Handler represents a storage handler for a specific service/namespace
 type Handler struct {
 	Name string
 
-	Config      *HandlerConfig
+	Config      *HandlerConfig // Replaced as a whole by Reload, see config()
 	Client      *minio.Client
 	BucketName  string                                 // Global bucket name from registry config
 	Categories  map[string]string                      // category -> bucket name (now all use same bucket)
 	Middlewares map[string]*middleware.MiddlewareChain // category -> middleware chain
+
+	// Guards Config, Categories and Middlewares, which are swapped on reload
+	configMutex sync.RWMutex
+
+	// Bounds the batch files processed at once across all batches, see BatchConfig.MaxConcurrent
+	batchSlots *semaphore.Weighted
+	batchLimit int
+
+	// Cached results of GetStorageStats
+	stats statsCache
+
+	// Fails backend calls fast during outages, see CircuitBreakerConfig
+	breaker circuitBreaker
+
+	// Transfer rate limiters, see BandwidthConfig
+	bandwidth bandwidthLimiters
+
+	// Replication counters, see ReplicationConfig
+	replication replicationTracker
+
+	// Serializes changes of the manifests of derived files, see recordDerived
+	derivedMutex sync.Mutex
+
+	// AsyncProcessor runs background jobs (thumbnails, checksums, ...) for all categories
+	AsyncProcessor *middleware.AsyncProcessor
+
+	// Logger used by the handler and its middlewares
+	logger logger.Logger
+
+	// Download counters shared by the security and monitoring middlewares
+	downloads middleware.DownloadCounter
+
+	// Token buckets shared by the ratelimit middlewares of all categories
+	rateLimiter middleware.RateLimiter
+
+	// Artifacts shared by the cache middlewares of all categories, see artifactCache
+	cache *middleware.Cache
+
+	// Buffers of upload data, see BufferConfig
+	buffers *middleware.BufferPool
+
+	// Events publishes structured notifications about handler operations
+	Events               *events.Bus
+	ownsEvents           bool
+	stopThumbnailForward func()
+	stopDirectUploads    context.CancelFunc
+	stopAudit            context.CancelFunc
+
+	// Store of the audit middlewares, chaining events when AuditLogConfig.HashChain is set
+	auditStore       middleware.AuditStore
+	auditAnchorMutex sync.Mutex
+	lastAuditAnchor  string // Hash of the last anchored event
+
+	// In-flight operation tracking for graceful Close
+	inflight sync.WaitGroup
+	closed   bool
+	abortCtx context.Context
+	abort    context.CancelFunc
+	mutex    sync.RWMutex
 }
 
+// inflightKey marks a context as belonging to an operation already tracked by a handler
+type inflightKey struct{}
+
 // initialize sets up the handler and creates necessary buckets
 func (h *Handler) Initialize() error {
 	h.Categories = make(map[string]string)
 	h.Middlewares = make(map[string]*middleware.MiddlewareChain)
+	h.abortCtx, h.abort = context.WithCancel(context.Background())
+	h.logger = logger.OrDefault(h.Config.Logger)
+	h.downloads = h.Config.DownloadCounter
+	if h.downloads == nil {
+		h.downloads = middleware.NewMemoryDownloadCounter()
+	}
+	h.rateLimiter = h.Config.RateLimit.Limiter
+	if h.rateLimiter == nil {
+		h.rateLimiter = middleware.NewMemoryRateLimiter()
+	}
+	cacheConfig := h.Config.Cache
+	if cacheConfig.MaxSize == 0 {
+		cacheConfig = middleware.DefaultCacheConfig()
+	}
+	cacheConfig.Logger = h.logger
+	h.cache = middleware.NewCache(cacheConfig)
+	h.buffers = middleware.NewBufferPool(h.Config.Buffer)
+
+	// Shared background job processor
+	asyncConfig := h.Config.Async
+	if asyncConfig.Workers == 0 {
+		defaults := middleware.DefaultAsyncConfig()
+		defaults.Queue = asyncConfig.Queue
+		defaults.Store = asyncConfig.Store
+		defaults.ProducerOnly = asyncConfig.ProducerOnly
+		if asyncConfig.JobTimeout > 0 {
+			defaults.JobTimeout = asyncConfig.JobTimeout
+		}
+		defaults.Timeouts = asyncConfig.Timeouts
+		asyncConfig = defaults
+	}
+	if asyncConfig.Logger == nil {
+		asyncConfig.Logger = h.logger
+	}
+	h.AsyncProcessor = middleware.NewAsyncProcessor(asyncConfig, h.Client, h.BucketName)
+	if err := h.AsyncProcessor.Jobs().Register(jobs.TypeChecksum, jobs.NewChecksumHandler(h.Client, h.BucketName), 0); err != nil {
+		return fmt.Errorf("failed to register checksum job handler: %w", err)
+	}
+	if err := h.AsyncProcessor.Jobs().Register(jobs.TypeIndex, jobs.HandlerFunc(h.handleIndexJob), 0); err != nil {
+		return fmt.Errorf("failed to register index job handler: %w", err)
+	}
+	if err := h.AsyncProcessor.Jobs().Register(jobs.TypeReplicate, jobs.HandlerFunc(h.handleReplicateJob), 0); err != nil {
+		return fmt.Errorf("failed to register replication job handler: %w", err)
+	}
+	if err := h.AsyncProcessor.Jobs().Register(jobs.TypeMetadata, jobs.HandlerFunc(h.handleMetadataJob), 0); err != nil {
+		return fmt.Errorf("failed to register metadata delivery job handler: %w", err)
+	}
+	if err := h.AsyncProcessor.Jobs().Register(jobs.TypeDirect, jobs.HandlerFunc(h.handleDirectJob), 0); err != nil {
+		return fmt.Errorf("failed to register direct upload job handler: %w", err)
+	}
+	if h.Config.Scanner != nil {
+		if err := h.AsyncProcessor.Jobs().Register(jobs.TypeAVScan, jobs.HandlerFunc(h.handleScanJob), 0); err != nil {
+			return fmt.Errorf("failed to register malware scan job handler: %w", err)
+		}
+	}
+	if h.Config.Classifier != nil {
+		if err := h.AsyncProcessor.Jobs().Register(jobs.TypeClassify, jobs.HandlerFunc(h.handleClassifyJob), 0); err != nil {
+			return fmt.Errorf("failed to register classification job handler: %w", err)
+		}
+	}
+	if h.Config.OCR != nil {
+		if err := h.AsyncProcessor.Jobs().Register(jobs.TypeOCR, jobs.HandlerFunc(h.handleOCRJob), 0); err != nil {
+			return fmt.Errorf("failed to register OCR job handler: %w", err)
+		}
+	}
+	if h.Config.Converter != nil {
+		if err := h.AsyncProcessor.Jobs().Register(jobs.TypeConvert, jobs.HandlerFunc(h.handleConvertJob), 0); err != nil {
+			return fmt.Errorf("failed to register document conversion job handler: %w", err)
+		}
+	}
+	if h.Config.AudioAnalyzer != nil {
+		if err := h.AsyncProcessor.Jobs().Register(jobs.TypeAudio, jobs.HandlerFunc(h.handleAudioJob), 0); err != nil {
+			return fmt.Errorf("failed to register audio analysis job handler: %w", err)
+		}
+	}
+	if h.Config.Transcoder != nil {
+		if err := h.AsyncProcessor.Jobs().Register(jobs.TypeTranscode, jobs.HandlerFunc(h.handleTranscodeJob), 0); err != nil {
+			return fmt.Errorf("failed to register transcode job handler: %w", err)
+		}
+	}
 
-	// All categories now use the same bucket
-	for category, categoryConfig := range h.Config.Categories {
-		h.Categories[category] = h.BucketName
+	// Event bus with configured webhook sinks
+	h.Events = h.Config.Events
+	if h.Events == nil {
+		h.Events = events.NewBus(h.logger)
+		h.ownsEvents = true
+	}
+	for _, webhookConfig := range h.Config.Webhooks {
+		h.Events.AddSink(events.NewWebhookSink(webhookConfig), webhookConfig.Events...)
+	}
+	h.forwardThumbnailEvents()
 
-		// Setup middlewares for this category
-		if err := h.setupMiddlewares(category, categoryConfig); err != nil {
-			return fmt.Errorf("failed to setup middlewares for category %s: %w", category, err)
-		}
+	// The bucket may be missing when the handler is used without a registry
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := h.createBucket(ctx, h.BucketName, h.Config.Region); err != nil {
+		return err
+	}
+
+	h.auditStore = h.Config.Audit.Store
+	if h.auditStore != nil && h.Config.Audit.HashChain {
+		h.auditStore = middleware.NewChainedAuditStore(h.auditStore)
+	}
+
+	// All categories use the same bucket; set up the middlewares of every category
+	if err := h.applyConfig(h.Config, nil); err != nil {
+		return err
 	}
 
+	if direct := h.Config.DirectUploads; direct.Enabled && direct.Notifications {
+		h.listenDirectUploads(direct.withDefaults().Prefix)
+	}
+	if h.auditStore != nil {
+		h.maintainAudit(h.Config.Audit.withDefaults())
+	}
 	return nil
 }
 
+// derivedBucket returns the bucket derived files of a category are stored in
+func (h *Handler) derivedBucket(categoryConfig category.CategoryConfig) string {
+	if categoryConfig.Preview.DerivedBucket != "" {
+		return categoryConfig.Preview.DerivedBucket
+	}
+	if h.Config.Preview.DerivedBucket != "" {
+		return h.Config.Preview.DerivedBucket
+	}
+	return h.BucketName
+}
+
+// DerivedBuckets returns the buckets derived files of all categories are stored in
+func (h *Handler) DerivedBuckets() []string {
+	h.configMutex.RLock()
+	defer h.configMutex.RUnlock()
+
+	seen := make(map[string]bool)
+	buckets := []string{}
+	for _, categoryConfig := range h.Config.Categories {
+		bucketName := h.derivedBucket(categoryConfig)
+		if !seen[bucketName] {
+			seen[bucketName] = true
+			buckets = append(buckets, bucketName)
+		}
+	}
+	sort.Strings(buckets)
+	return buckets
+}
+
+// derivedStorageClass returns the storage class for derived files of a category
+func (h *Handler) derivedStorageClass(categoryConfig category.CategoryConfig) string {
+	if categoryConfig.Preview.DerivedStorageClass != "" {
+		return categoryConfig.Preview.DerivedStorageClass
+	}
+	return h.Config.Preview.DerivedStorageClass
+}
+
+// ensureBucket creates a bucket if it does not exist yet
+func (h *Handler) ensureBucket(bucketName, region string) error {
+	if bucketName == h.BucketName {
+		// Created by Initialize
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return h.createBucket(ctx, bucketName, region)
+}
+
 // GenerateFileKey creates a structured file key
 func (h *Handler) GenerateFileKey(entityType, entityID, fileType, filename string) string {
 	timestamp := time.Now().Unix()
@@ -56,13 +285,49 @@ func (h *Handler) GenerateFileKey(entityType, entityID, fileType, filename strin
 }
 
 // Upload uploads a file to the appropriate bucket
-func (h *Handler) Upload(ctx context.Context, req *interfaces.UploadRequest) (*interfaces.UploadResponse, error) {
-	// Get category configuration
-	_, exists := h.Config.Categories[req.Category]
+func (h *Handler) Upload(ctx context.Context, req *interfaces.UploadRequest, opts ...Option) (*interfaces.UploadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	options := callOptions(opts)
+	ctx, cancel := callContext(ctx, options)
+	defer cancel()
+
+	return h.upload(ctx, req, options, nil)
+}
+
+// upload stores a file, a new one or, for Replace, a new version of the stored file previous
+func (h *Handler) upload(ctx context.Context, req *interfaces.UploadRequest, options *interfaces.CallOptions, previous *minio.ObjectInfo) (*interfaces.UploadResponse, error) {
+	// Get the middleware chain of the category
+	categoryConfig, middlewareChain, exists := h.category(req.Category)
 	if !exists {
 		return nil, &errors.StorageError{Code: "CATEGORY_NOT_FOUND", Message: "Category " + req.Category + " not found"}
 	}
 
+	// Files of a tenant live under its key prefix, optionally in its own bucket
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.checkTenantLimits(ctx, t, req.FileSize); err != nil {
+		return nil, err
+	}
+	bucketName, err := h.requestBucket(ctx, t)
+	if err != nil {
+		return nil, err
+	}
+	if options.Staged && previous != nil {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Replacements cannot be staged"}
+	}
+	// Anonymous uploads are limited per client address
+	client := clientinfo.FromContext(ctx)
+	if categoryConfig.Anonymous.Enabled && req.UserID == "" && client.IPAddress == "" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Anonymous uploads require the client address, see WithClient"}
+	}
+
 	// Convert to middleware request
 	middlewareReq := &middleware.StorageRequest{
 		Operation:   "upload",
@@ -76,16 +341,33 @@ func (h *Handler) Upload(ctx context.Context, req *interfaces.UploadRequest) (*i
 		UserID:      req.UserID,
 		Metadata:    req.Metadata,
 		Config:      req.Config,
-	}
+		BucketName:  bucketName,
+		Buffers:     h.buffers,
 
-	// Get middleware chain for this category
-	middlewareChain, exists := h.Middlewares[req.Category]
-	if !exists {
-		return nil, fmt.Errorf("middleware chain not found for category %s", req.Category)
+		DisableThumbnails: options.DisableThumbnails,
+		IPAddress:         client.IPAddress,
+		UserAgent:         client.UserAgent,
+		Replace:           previous != nil,
+	}
+	// Buffers of middlewares are released once the upload is stored
+	defer middlewareReq.Release()
+
+	// Generate file key first, new versions keep the key of the file
+	var fileKey string
+	switch {
+	case previous != nil:
+		fileKey = previous.Key
+	case options.FileKey != "":
+		fileKey = options.FileKey
+	default:
+		fileKey = h.GenerateFileKey(req.EntityType, req.EntityID, req.Category, req.FileName)
+	}
+	if t != nil && previous == nil {
+		fileKey = t.Key(fileKey)
+	}
+	if err := h.checkWriteConditions(ctx, bucketName, fileKey, options); err != nil {
+		return nil, err
 	}
-
-	// Generate file key first
-	fileKey := h.GenerateFileKey(req.EntityType, req.EntityID, req.Category, req.FileName)
 
 	// Set the file key in the middleware request
 	middlewareReq.FileKey = fileKey
@@ -97,31 +379,160 @@ func (h *Handler) Upload(ctx context.Context, req *interfaces.UploadRequest) (*i
 	}
 
 	if !middlewareResp.Success {
+		data := map[string]interface{}{
+			"file_name":    req.FileName,
+			"file_size":    req.FileSize,
+			"content_type": req.ContentType,
+		}
+		if middlewareResp.Error != nil {
+			data["error"] = middlewareResp.Error.Error()
+		}
+		h.publish(ctx, events.TypeValidationFailed, req.Category, fileKey, req.UserID, data)
+
 		return &interfaces.UploadResponse{
-			Success: false,
-			Error:   middlewareResp.Error,
+			Success:   false,
+			RequestID: requestid.FromContext(ctx),
+			Error:     middlewareResp.Error,
 		}, nil
 	}
 
-	// Upload to MinIO
-	_, err = h.Client.PutObject(ctx, h.BucketName, fileKey, req.FileData, req.FileSize, minio.PutObjectOptions{
-		ContentType: req.ContentType,
-		UserMetadata: map[string]string{
-			"original-filename": req.FileName,
-			"entity-type":       req.EntityType,
-			"entity-id":         req.EntityID,
-			"category":          req.Category,
-			"uploaded-by":       req.UserID,
-			"uploaded-at":       time.Now().Format(time.RFC3339),
-		},
-	})
+	sse, err := h.serverSideEncryption(req.Category)
+	if err != nil {
+		return nil, err
+	}
+
+	// Metadata of the caller is set first, so the handler's keys take precedence; new versions
+	// keep the metadata set with UpdateMetadata
+	userMetadata := make(map[string]string, len(options.Metadata)+8)
+	if previous != nil {
+		for key, value := range applicationMetadata(previous.UserMetadata) {
+			userMetadata[strings.ToLower(key)] = value
+		}
+	}
+	for key, value := range options.Metadata {
+		userMetadata[strings.ToLower(key)] = value
+	}
+	userMetadata["original-filename"] = req.FileName
+	userMetadata["entity-type"] = req.EntityType
+	userMetadata["entity-id"] = req.EntityID
+	userMetadata["category"] = req.Category
+	userMetadata["uploaded-by"] = req.UserID
+	userMetadata["uploaded-at"] = time.Now().Format(time.RFC3339)
+	version := 1
+	if previous != nil {
+		// The owner of the file stays, whoever replaces it
+		version = fileVersion(previous.UserMetadata) + 1
+		userMetadata["uploaded-by"] = previous.UserMetadata["Uploaded-By"]
+		userMetadata["version"] = strconv.Itoa(version)
+	}
+	if t != nil {
+		userMetadata["tenant-id"] = t.ID
+	}
+	for key, value := range middlewareReq.ObjectMetadata {
+		userMetadata[key] = value
+	}
+	userTags := anonymousUpload(categoryConfig.Anonymous, req.UserID, userMetadata, categoryConfig.DefaultTags)
+	if categoryConfig.Moderation.Enabled {
+		userMetadata[middleware.ModerationStatusMetadataKey] = middleware.ModerationPending
+	}
+
+	// Upload to MinIO, middlewares may have replaced the data (e.g. encryption)
+	putOptions := minio.PutObjectOptions{
+		ContentType:          req.ContentType,
+		ServerSideEncryption: sse,
+		UserMetadata:         userMetadata,
+		UserTags:             userTags,
+	}
+	if options.IfMatch != "" && !options.Staged {
+		// Backends supporting conditional writes reject changes made since the check
+		putOptions.SetMatchETag(options.IfMatch)
+	}
+	objectKey := fileKey
+	if options.Staged {
+		objectKey, err = h.stage(fileKey, &putOptions, middlewareResp.Thumbnails)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if source, ok := middlewareReq.FileData.(*importSource); ok && source.copyable(h.Client) {
+		// Imported objects no middleware read are copied within the backend
+		err = h.copyImport(ctx, source, bucketName, objectKey, putOptions)
+	} else {
+		_, err = h.putObject(ctx, bucketName, objectKey, middlewareReq.FileData, middlewareReq.FileSize, putOptions)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to upload file: %w", err)
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			return nil, &errors.StorageError{Code: errors.ErrConflict.Code, Message: "File " + fileKey + " was changed", Err: err}
+		}
+		return nil, errors.Wrap(errors.ErrUploadFailed, err)
+	}
+	if options.Staged {
+		// Staged files are announced by Commit
+		return &interfaces.UploadResponse{
+			Success:     true,
+			FileKey:     fileKey,
+			FileSize:    req.FileSize,
+			ContentType: req.ContentType,
+			Metadata:    req.Metadata,
+			Staged:      true,
+			RequestID:   requestid.FromContext(ctx),
+		}, nil
+	}
+	return h.finishUpload(ctx, &storedUpload{
+		req:          req,
+		bucketName:   bucketName,
+		fileKey:      fileKey,
+		previous:     previous,
+		thumbnails:   middlewareResp.Thumbnails,
+		userMetadata: userMetadata,
+		userTags:     userTags,
+		version:      version,
+		blurhash:     middlewareReq.ObjectMetadata[middleware.BlurHashMetadataKey],
+	})
+}
+
+// storedUpload represents an upload stored under its key, see finishUpload
+type storedUpload struct {
+	req          *interfaces.UploadRequest
+	bucketName   string
+	fileKey      string
+	previous     *minio.ObjectInfo // Replaced version, nil for new files
+	thumbnails   []middleware.ThumbnailInfo
+	userMetadata map[string]string
+	userTags     map[string]string
+	version      int
+	blurhash     string
+}
+
+// finishUpload runs the background work of a stored upload, records and announces it
+func (h *Handler) finishUpload(ctx context.Context, upload *storedUpload) (*interfaces.UploadResponse, error) {
+	req, bucketName, fileKey, previous := upload.req, upload.bucketName, upload.fileKey, upload.previous
+	categoryConfig, _, _ := h.category(req.Category)
+
+	h.invalidate(ctx, fileKey)
+	if previous != nil {
+		h.purgeCDN(ctx, req.Category, bucketName, fileKey, upload.thumbnails)
+	}
+	if categoryConfig.Anonymous.Enabled {
+		h.scanFile(ctx, bucketName, fileKey)
+	}
+	if categoryConfig.Classification.Enabled {
+		h.classifyFile(ctx, categoryConfig.Classification, bucketName, fileKey, req.ContentType)
+	}
+	if categoryConfig.Preview.ConvertDocuments {
+		h.convertFile(ctx, bucketName, fileKey, req.ContentType)
+	}
+	if categoryConfig.Audio.Enabled {
+		h.analyzeAudio(ctx, bucketName, fileKey, req.ContentType)
+	}
+	if categoryConfig.Video.Transcode {
+		h.transcodeVideo(ctx, bucketName, fileKey, req.ContentType)
 	}
 
 	// Convert middleware thumbnails to storage thumbnails
+	h.thumbnailURLs(ctx, req.Category, fileKey, upload.thumbnails)
 	var thumbnails []interfaces.ThumbnailInfo
-	for _, thumb := range middlewareResp.Thumbnails {
+	for _, thumb := range upload.thumbnails {
 		thumbnails = append(thumbnails, interfaces.ThumbnailInfo{
 			Size:     thumb.Size,
 			URL:      thumb.URL,
@@ -138,272 +549,1371 @@ func (h *Handler) Upload(ctx context.Context, req *interfaces.UploadRequest) (*i
 		FileKey:     fileKey,
 		FileSize:    req.FileSize,
 		ContentType: req.ContentType,
+		Namespace:   h.Name,
+		Category:    req.Category,
 		EntityType:  req.EntityType,
 		EntityID:    req.EntityID,
-		UploadedBy:  req.UserID,
+		UploadedBy:  upload.userMetadata["uploaded-by"],
 		UploadedAt:  time.Now(),
+		Tags:        formatTags(upload.userTags),
 		Thumbnails:  thumbnails,
-		Version:     1,
+		Blurhash:    upload.blurhash,
+		Version:     upload.version,
 		Checksum:    "", // Could be calculated if needed
 	}
-
-	// Call metadata callback if provided
-	if h.Config.MetadataCallback != nil {
-		if err := h.Config.MetadataCallback(ctx, fileMetadata); err != nil {
-			// Log error but don't fail the upload
-			// Users can handle this error in their callback implementation
-			fmt.Printf("Warning: metadata callback failed: %v\n", err)
+	if store := h.config().MetadataStore; store != nil && previous != nil {
+		// A new version updates the record of the file
+		if stored, err := store.Get(ctx, fileKey); err == nil {
+			fileMetadata.ID = stored.ID
 		}
 	}
 
+	// Keep the record in the metadata store, failed saves are retried
+	h.deliverMetadata(ctx, DeliveryStore, fileMetadata)
+	h.indexFile(ctx, categoryConfig.OCR, fileKey, req.ContentType, req.FileSize)
+	h.replicate(ctx, fileKey, false)
+
+	// Call metadata callback if provided, failed calls are retried, see DeliveryConfig
+	h.deliverMetadata(ctx, DeliveryCallback, fileMetadata)
+
+	eventType, eventData := events.TypeFileUploaded, map[string]interface{}{
+		"file_name":    req.FileName,
+		"file_size":    req.FileSize,
+		"content_type": req.ContentType,
+		"entity_type":  req.EntityType,
+		"entity_id":    req.EntityID,
+	}
+	if previous != nil {
+		eventType = events.TypeFileReplaced
+		eventData["version"] = upload.version
+	}
+	h.publish(ctx, eventType, req.Category, fileKey, req.UserID, eventData)
+
+	// Build a usable link according to the category policy, once the file passed its scan
+	var fileURL string
+	var err error
+	if scanned(upload.userMetadata["scan-status"]) {
+		fileURL, err = h.fileURL(ctx, req.Category, bucketName, fileKey)
+	}
+	if err != nil {
+		// The file is stored, so only warn about the missing URL
+		h.logger.Warn("failed to build file URL", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+
 	return &interfaces.UploadResponse{
 		Success:     true,
 		FileKey:     fileKey,
+		FileURL:     fileURL,
 		FileSize:    req.FileSize,
 		ContentType: req.ContentType,
 		Metadata:    req.Metadata,
 		Thumbnails:  thumbnails,
+		Blurhash:    fileMetadata.Blurhash,
+		RequestID:   requestid.FromContext(ctx),
 	}, nil
 }
 
-// Download downloads a file from the appropriate bucket
-func (h *Handler) Download(ctx context.Context, req *interfaces.DownloadRequest) (*interfaces.DownloadResponse, error) {
-	// Find the file in buckets
-	_, bucketName, err := h.findFile(ctx, req.FileKey)
-	if err != nil {
-		return nil, err
+// Download downloads a file from the appropriate bucket
+func (h *Handler) Download(ctx context.Context, req *interfaces.DownloadRequest, opts ...Option) (*interfaces.DownloadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	options := callOptions(opts)
+	ctx, cancel := callContext(ctx, options)
+	resp, err := h.download(ctx, req)
+	if resp != nil {
+		resp.RequestID = requestid.FromContext(ctx)
+	}
+	if err != nil || options.Timeout == 0 || resp.NotModified {
+		cancel()
+		return resp, err
+	}
+	// The timeout covers reading the data
+	resp.FileData = &timedObject{Reader: resp.FileData, cancel: cancel}
+	return resp, nil
+}
+
+// download downloads a file, falling back to the replica when the primary backend is unavailable
+// Downloads are authorized on the stat of the file before its data is requested; stats held by
+// the cache are not requested again, so downloads of those files take a single request
+func (h *Handler) download(ctx context.Context, req *interfaces.DownloadRequest) (*interfaces.DownloadResponse, error) {
+	bucketName, err := h.locateFile(ctx, req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	objInfo, cached := h.cachedStat(bucketName, req.FileKey)
+	if !cached {
+		if objInfo, err = h.statFile(ctx, bucketName, req.FileKey); err != nil {
+			if h.failover(err) {
+				return h.downloadReplica(ctx, req, false)
+			}
+			return nil, err
+		}
+	}
+	if unchanged(req.IfNoneMatch, req.IfModifiedSince, entityTag(objInfo.ETag), objInfo.LastModified) {
+		if err := h.authorizeRead(ctx, objInfo, bucketName, req.UserID); err != nil {
+			return nil, err
+		}
+		return notModifiedDownload(objInfo), nil
+	}
+	return h.downloadObject(ctx, req, bucketName, objInfo)
+}
+
+// downloadObject passes the download of a file through the middleware chain and opens its data
+// once the middlewares let it pass; the info read with the data refreshes the cached stat
+func (h *Handler) downloadObject(ctx context.Context, req *interfaces.DownloadRequest, bucketName string, objInfo *minio.ObjectInfo) (*interfaces.DownloadResponse, error) {
+	sse, err := h.keyServerSideEncryption(req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Tenant request limits apply to downloads as well
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.checkTenantLimits(ctx, t, 0); err != nil {
+		return nil, err
+	}
+
+	var resp *interfaces.DownloadResponse
+	chainReq := h.chainRequest("download", objInfo, bucketName, req.UserID)
+	err = h.runChain(ctx, chainReq, func(ctx context.Context) error {
+		// Count the download against the category limit
+		if err := h.countDownload(ctx, chainReq); err != nil {
+			return err
+		}
+
+		// Download from MinIO
+		object, info, err := h.getObject(ctx, bucketName, req.FileKey, minio.GetObjectOptions{ServerSideEncryption: sse})
+		if err != nil {
+			if h.failover(err) {
+				resp, err = h.downloadReplica(ctx, req, true)
+				return err
+			}
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return errors.ErrFileNotFound
+			}
+			return errors.Wrap(errors.ErrDownloadFailed, err)
+		}
+		h.cacheStat(bucketName, req.FileKey, info)
+		objInfo = &info
+
+		fileData, err := h.plainObject(ctx, h.throttleDownload(ctx, object), object, objInfo, req.UserID)
+		if err != nil {
+			return err
+		}
+		resp = h.downloadResponse(ctx, req, objInfo, fileData)
+		return h.watermarkDownload(ctx, req, objInfo, object, resp)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// plainObject returns the original data of an object opened for download, decrypting files encrypted
+// by the encryption middleware and decompressing files compressed by the compression middleware
+// The object is closed when an error is returned
+func (h *Handler) plainObject(ctx context.Context, data io.ReadCloser, object io.Closer, objInfo *minio.ObjectInfo, userID string) (io.Reader, error) {
+	var fileData io.Reader = data
+	var err error
+	plaintextSize := middleware.PlaintextSize(objInfo.UserMetadata, objInfo.Size)
+	if middleware.IsEncrypted(objInfo.UserMetadata) {
+		fileData, err = h.decryptObject(ctx, data, objInfo, userID, 0, plaintextSize-1)
+		if err != nil {
+			object.Close()
+			return nil, err
+		}
+	}
+	fileSize := middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size)
+	if middleware.IsCompressed(objInfo.UserMetadata) {
+		fileData, err = decompressObject(fileData, object, objInfo.UserMetadata, 0, fileSize-1)
+		if err != nil {
+			object.Close()
+			return nil, err
+		}
+	}
+	return fileData, nil
+}
+
+// downloadResponse records the download of a file in the access log and describes it
+func (h *Handler) downloadResponse(ctx context.Context, req *interfaces.DownloadRequest, objInfo *minio.ObjectInfo, fileData io.Reader) *interfaces.DownloadResponse {
+	fileSize := middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size)
+	h.recordAccess(ctx, &interfaces.AccessRecord{
+		FileKey:   req.FileKey,
+		UserID:    req.UserID,
+		Operation: interfaces.AccessDownload,
+		End:       fileSize - 1,
+	})
+
+	return &interfaces.DownloadResponse{
+		Success:      true,
+		FileData:     fileData,
+		FileSize:     fileSize,
+		ContentType:  objInfo.ContentType,
+		ETag:         entityTag(objInfo.ETag),
+		LastModified: objInfo.LastModified,
+		Metadata: map[string]interface{}{
+			"file_name":    objInfo.Key,
+			"uploaded_at":  objInfo.LastModified,
+			"content_type": objInfo.ContentType,
+		},
+	}
+}
+
+// notModifiedDownload returns the response to a download of a file the client has a current copy
+// of. Like ServeFile, conditional requests are authorized as streams, see authorizeRead, and are
+// not counted as downloads
+func notModifiedDownload(objInfo *minio.ObjectInfo) *interfaces.DownloadResponse {
+	return &interfaces.DownloadResponse{
+		Success:      true,
+		NotModified:  true,
+		FileSize:     middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size),
+		ContentType:  objInfo.ContentType,
+		ETag:         entityTag(objInfo.ETag),
+		LastModified: objInfo.LastModified,
+	}
+}
+
+// Delete deletes a file from the appropriate bucket
+func (h *Handler) Delete(ctx context.Context, req *interfaces.DeleteRequest, opts ...Option) error {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	ctx, cancel := callContext(ctx, callOptions(opts))
+	defer cancel()
+
+	// Find the file in buckets
+	fileInfo, bucketName, err := h.findFile(ctx, req.FileKey)
+	if err != nil {
+		return err
+	}
+
+	chainReq := h.chainRequest("delete", fileInfo.(*minio.ObjectInfo), bucketName, req.UserID)
+	return h.runChain(ctx, chainReq, func(ctx context.Context) error {
+		return h.removeFile(ctx, bucketName, chainReq.Category, req.FileKey, req.UserID)
+	})
+}
+
+// removeFile deletes a file with its thumbnails and derived files, without the middleware chain
+func (h *Handler) removeFile(ctx context.Context, bucketName, category, fileKey, userID string) error {
+	// Delete from MinIO
+	err := h.removeObject(ctx, bucketName, fileKey, minio.RemoveObjectOptions{})
+	if err != nil {
+		return errors.Wrap(errors.ErrDeleteFailed, err)
+	}
+
+	// Thumbnails are useless without their original
+	if err := h.deleteThumbnails(ctx, category, fileKey); err != nil {
+		h.logger.Warn("failed to delete thumbnails", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+
+	h.deleted(ctx, fileKey, userID)
+
+	// Note: Records of the MetadataStore are removed with the file; users of MetadataCallback
+	// should implement their own cleanup logic in their metadata storage system
+
+	return nil
+}
+
+// deleteThumbnails removes the thumbnails of all sizes of a file from the derived bucket of its category
+// Thumbnails are found by their key, so sizes removed from the configuration since are deleted as well
+func (h *Handler) deleteThumbnails(ctx context.Context, category, fileKey string) error {
+	h.configMutex.RLock()
+	bucketName := h.derivedBucket(h.Config.Categories[category])
+	h.configMutex.RUnlock()
+
+	// Stop listing on the first error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := h.Client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: middleware.ThumbnailKeyPrefix(fileKey)})
+	for object := range objects {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list thumbnails: %w", object.Err)
+		}
+		if !middleware.IsThumbnailKey(fileKey, object.Key) {
+			continue
+		}
+		if err := h.removeObject(ctx, bucketName, object.Key, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("failed to delete thumbnail %s: %w", object.Key, err)
+		}
+	}
+	return h.deleteDerivedFiles(ctx, category, fileKey)
+}
+
+// MetadataStore returns the metadata store of the handler, nil when it has none
+func (h *Handler) MetadataStore() interfaces.MetadataStore {
+	return h.config().MetadataStore
+}
+
+// Preview generates a preview URL for a file
+// Preview URLs are served by the backend unmarked, so files of watermarked categories have none
+func (h *Handler) Preview(ctx context.Context, req *interfaces.PreviewRequest) (*interfaces.PreviewResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	// Find the file in buckets
+	fileInfo, bucketName, err := h.findFile(ctx, req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get object info for proper metadata
+	objInfo := fileInfo.(*minio.ObjectInfo)
+	if categoryName := h.fileKeyInfo(objInfo).Category; h.watermarked(categoryName) {
+		return nil, errWatermarked(categoryName)
+	}
+
+	sse, err := h.keyServerSideEncryption(req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+	headers := encryptionHeaders(sse, http.MethodGet)
+
+	// Office documents are previewed as their PDF conversion once it exists
+	previewBucket, previewKey, contentType, fileSize := bucketName, req.FileKey, objInfo.ContentType, objInfo.Size
+	documentPreview, err := h.documentPreview(ctx, objInfo)
+	if err != nil {
+		return nil, err
+	}
+	if documentPreview != nil {
+		previewBucket, previewKey, contentType, fileSize = documentPreview.bucketName, documentPreview.Key, documentPreview.ContentType, documentPreview.Size
+		headers = nil
+	}
+
+	var previewURL string
+	err = h.runChain(ctx, h.chainRequest("preview", objInfo, bucketName, req.UserID), func(ctx context.Context) error {
+		// Generate presigned URL for preview (expires in 1 hour)
+		var err error
+		previewURL, _, err = cachedURL(h.categoryCache(h.fileKeyInfo(objInfo).Category), req.FileKey, "preview", func() (string, time.Time, error) {
+			expiresAt := time.Now().Add(time.Hour)
+			presignedURL, err := h.Client.PresignHeader(ctx, http.MethodGet, previewBucket, previewKey, time.Hour, nil, headers)
+			if err != nil {
+				return "", time.Time{}, err
+			}
+			return presignedURL.String(), expiresAt, nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to generate preview URL: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.recordAccess(ctx, &interfaces.AccessRecord{
+		FileKey:   req.FileKey,
+		UserID:    req.UserID,
+		Operation: interfaces.AccessPreview,
+		End:       middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size) - 1,
+	})
+
+	metadata := map[string]interface{}{
+		"file_name":    objInfo.Key,
+		"uploaded_at":  objInfo.LastModified,
+		"content_type": objInfo.ContentType,
+	}
+	if documentPreview != nil {
+		metadata["converted"] = true
+	}
+	if len(headers) > 0 {
+		// SSE-C objects can only be fetched with the key headers
+		metadata["headers"] = flattenHeaders(headers)
+	}
+
+	return &interfaces.PreviewResponse{
+		Success:     true,
+		PreviewURL:  previewURL,
+		ContentType: contentType,
+		FileSize:    fileSize,
+		Metadata:    metadata,
+	}, nil
+}
+
+// GetThumbnail returns the data of a generated thumbnail of a file
+func (h *Handler) GetThumbnail(ctx context.Context, req *interfaces.ThumbnailRequest) (*interfaces.DownloadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	// The original decides access and the bucket of its thumbnails
+	fileInfo, sourceBucket, err := h.findFile(ctx, req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+	objInfo := fileInfo.(*minio.ObjectInfo)
+	var resp *interfaces.DownloadResponse
+	err = h.runChain(ctx, h.chainRequest("preview", objInfo, sourceBucket, req.UserID), func(ctx context.Context) error {
+		resp, err = h.thumbnail(ctx, req, objInfo)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// thumbnail returns the data of a thumbnail of a file, from the cache when it holds it
+func (h *Handler) thumbnail(ctx context.Context, req *interfaces.ThumbnailRequest, objInfo *minio.ObjectInfo) (*interfaces.DownloadResponse, error) {
+	categoryName := h.fileKeyInfo(objInfo).Category
+	h.configMutex.RLock()
+	bucketName := h.derivedBucket(h.Config.Categories[categoryName])
+	h.configMutex.RUnlock()
+
+	// Small thumbnails are served from memory, cached with their original which invalidates them
+	thumbnailKey := middleware.ThumbnailKey(req.FileKey, req.Size)
+	cache := h.categoryCache(categoryName)
+	variant := "thumbnail:" + req.Size
+	if cache != nil {
+		if preview, ok := cache.Preview(req.FileKey, variant); ok {
+			return thumbnailResponse(req, thumbnailKey, io.NopCloser(bytes.NewReader(preview.Data)), preview), nil
+		}
+	}
+
+	object, thumbnailInfo, err := h.getObject(ctx, bucketName, thumbnailKey, minio.GetObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, &errors.StorageError{Code: "THUMBNAIL_NOT_FOUND", Message: "Thumbnail not found"}
+		}
+		return nil, errors.Wrap(errors.ErrDownloadFailed, err)
+	}
+	preview := middleware.CachedPreview{
+		ContentType:  thumbnailInfo.ContentType,
+		LastModified: thumbnailInfo.LastModified,
+	}
+
+	if cache == nil || !cache.Cacheable(thumbnailInfo.Size) {
+		response := thumbnailResponse(req, thumbnailKey, h.throttleDownload(ctx, object), preview)
+		response.FileSize = thumbnailInfo.Size
+		return response, nil
+	}
+
+	data, err := io.ReadAll(h.throttleDownload(ctx, object))
+	object.Close()
+	if err != nil {
+		return nil, errors.Wrap(errors.ErrDownloadFailed, err)
+	}
+	preview.Data = data
+	cache.SetPreview(req.FileKey, variant, preview)
+	return thumbnailResponse(req, thumbnailKey, io.NopCloser(bytes.NewReader(data)), preview), nil
+}
+
+// thumbnailResponse returns the download response of a thumbnail
+func thumbnailResponse(req *interfaces.ThumbnailRequest, thumbnailKey string, data io.Reader, preview middleware.CachedPreview) *interfaces.DownloadResponse {
+	return &interfaces.DownloadResponse{
+		Success:     true,
+		FileData:    data,
+		FileSize:    int64(len(preview.Data)),
+		ContentType: preview.ContentType,
+		Metadata: map[string]interface{}{
+			"file_name":    thumbnailKey,
+			"original_key": req.FileKey,
+			"size":         req.Size,
+			"uploaded_at":  preview.LastModified,
+			"content_type": preview.ContentType,
+		},
+	}
+}
+
+// Stream streams a file from the appropriate bucket
+func (h *Handler) Stream(ctx context.Context, req *interfaces.StreamRequest) (*interfaces.StreamResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	// Find the file in buckets
+	fileInfo, bucketName, err := h.findFile(ctx, req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get object info for proper metadata
+	objInfo := fileInfo.(*minio.ObjectInfo)
+	if req.Path != "" {
+		return h.streamRendition(ctx, req, objInfo, bucketName)
+	}
+
+	// Ranges refer to the original file, encrypted and compressed objects differ in size
+	fileSize := middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size)
+	etag := entityTag(objInfo.ETag)
+	if unchanged(req.IfNoneMatch, req.IfModifiedSince, etag, objInfo.LastModified) {
+		// Validators are only answered to users who may read the file
+		if err := h.authorizeRead(ctx, objInfo, bucketName, req.UserID); err != nil {
+			return nil, err
+		}
+		return &interfaces.StreamResponse{
+			Success:      true,
+			NotModified:  true,
+			FileSize:     fileSize,
+			ContentType:  objInfo.ContentType,
+			ETag:         etag,
+			LastModified: objInfo.LastModified,
+		}, nil
+	}
+	// Like ServeFile, watermarked files are sent whole
+	mark, marked := h.fileWatermark("download", objInfo, objInfo.ContentType)
+	byteRange := req.Range
+	if marked {
+		byteRange = ""
+	}
+	start, end := int64(0), fileSize-1
+	if byteRange != "" {
+		// Parse range header for partial content requests
+		start, end, err = h.parseRangeHeader(byteRange, fileSize)
+		if err != nil {
+			return nil, errors.Wrap(errors.ErrInvalidRange, err)
+		}
+	}
+
+	// Stream from MinIO
+	var fileData io.Reader
+	err = h.runChain(ctx, h.chainRequest("stream", objInfo, bucketName, req.UserID), func(ctx context.Context) error {
+		fileData, err = h.openFile(ctx, bucketName, objInfo, req.UserID, start, end)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	contentType := objInfo.ContentType
+	if marked {
+		data, markedType, err := h.watermarkFile(ctx, mark, req.FileKey, req.UserID, objInfo.ContentType, fileData)
+		if closer, ok := fileData.(io.Closer); ok {
+			closer.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		fileData, contentType = bytes.NewReader(data), markedType
+		fileSize, end = int64(len(data)), int64(len(data))-1
+	}
+	h.recordAccess(ctx, &interfaces.AccessRecord{
+		FileKey:   req.FileKey,
+		UserID:    req.UserID,
+		Operation: interfaces.AccessStream,
+		Start:     start,
+		End:       end,
+	})
+
+	metadata := map[string]interface{}{
+		"file_name":    objInfo.Key,
+		"uploaded_at":  objInfo.LastModified,
+		"content_type": objInfo.ContentType,
+	}
+	if renditions := objInfo.UserMetadata["Hls-Renditions"]; renditions != "" {
+		// Players switch to adaptive streaming with the manifest as Path
+		metadata["hls_manifest"] = media.HLSManifest
+		metadata["renditions"] = strings.Split(renditions, ",")
+	}
+
+	resp := &interfaces.StreamResponse{
+		Success:       true,
+		FileData:      fileData,
+		FileSize:      fileSize,
+		ContentLength: end - start + 1,
+		ContentType:   contentType,
+		ETag:          etag,
+		LastModified:  objInfo.LastModified,
+		Range:         byteRange,
+		Metadata:      metadata,
+	}
+	if byteRange != "" {
+		resp.ContentRange = fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize)
+	}
+	return resp, nil
+}
+
+// GeneratePresignedURL generates a presigned URL for a file
+// URLs are served by the backend without the middlewares, so issuing them passes the middleware
+// chain of the category instead: GET URLs as downloads, PUT and POST URLs as operation "presign",
+// which the security middleware authorizes as middleware.ActionReplace against the stored owner
+func (h *Handler) GeneratePresignedURL(ctx context.Context, req *interfaces.PresignedURLRequest) (*interfaces.PresignedURLResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	// Find the file in buckets
+	fileInfo, bucketName, err := h.findFile(ctx, req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	objInfo := fileInfo.(*minio.ObjectInfo)
+	// Watermarked files are never served unmarked
+	if categoryName := h.fileKeyInfo(objInfo).Category; req.Action == "GET" && h.watermarked(categoryName) {
+		return nil, errWatermarked(categoryName)
+	}
+
+	sse, err := h.keyServerSideEncryption(req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate presigned URL based on action
+	var method string
+	switch req.Action {
+	case "GET":
+		method = http.MethodGet
+	case "PUT":
+		method = http.MethodPut
+	case "POST":
+		method = http.MethodPost
+	default:
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Unsupported action: " + req.Action}
+	}
+	params, err := responseParams(req, objInfo)
+	if err != nil {
+		return nil, err
+	}
+	contentType, minSize, maxSize, err := h.uploadConstraints(ctx, req, objInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	// Unscanned files and files held by moderation are refused by the chain like their downloads
+	chainReq := h.chainRequest("download", objInfo, bucketName, req.UserID)
+	if method != http.MethodGet {
+		chainReq = h.chainRequest("presign", objInfo, bucketName, req.UserID)
+		chainReq.FileSize = 0
+		chainReq.Replace = true
+	}
+	var resp *interfaces.PresignedURLResponse
+	err = h.runChain(ctx, chainReq, func(ctx context.Context) error {
+		// GET URLs count as downloads
+		if method == http.MethodGet {
+			if err := h.countDownload(ctx, chainReq); err != nil {
+				return err
+			}
+		}
+		var err error
+		if method == http.MethodPost {
+			resp, err = h.presignPost(ctx, req, bucketName, sse, contentType, minSize, maxSize)
+		} else {
+			resp, err = h.presignURL(ctx, req, method, bucketName, objInfo, sse, params, contentType)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// presignURL returns a presigned GET or PUT URL of a file, reused from the cache of its category
+func (h *Handler) presignURL(ctx context.Context, req *interfaces.PresignedURLRequest, method, bucketName string, objInfo *minio.ObjectInfo, sse encrypt.ServerSide, params url.Values, contentType string) (*interfaces.PresignedURLResponse, error) {
+	// Encryption headers are signed into the URL, so clients must send them as returned
+	headers := encryptionHeaders(sse, method)
+	cache := h.categoryCache(h.fileKeyInfo(objInfo).Category)
+	variant := method + ":" + req.Expires.String()
+	if len(params) > 0 {
+		variant += ":" + params.Encode()
+	}
+	if contentType != "" {
+		// Uploads with another Content-Type do not match the signature
+		headers.Set("Content-Type", contentType)
+		variant += ":" + contentType
+	}
+	presignedURL, expiresAt, err := cachedURL(cache, req.FileKey, variant, func() (string, time.Time, error) {
+		expiresAt := time.Now().Add(req.Expires)
+		presignedURL, err := h.Client.PresignHeader(ctx, method, bucketName, req.FileKey, req.Expires, params, headers)
+		if err != nil {
+			return "", time.Time{}, err
+		}
+		return presignedURL.String(), expiresAt, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate presigned URL: %w", err)
+	}
+
+	return &interfaces.PresignedURLResponse{
+		Success:   true,
+		URL:       presignedURL,
+		Headers:   flattenHeaders(headers),
+		ExpiresAt: expiresAt,
+		Metadata: map[string]interface{}{
+			"file_name":  req.FileKey,
+			"action":     req.Action,
+			"expires_at": expiresAt,
+		},
+	}, nil
+}
+
+// responseParams returns the signed query parameters overriding the response headers of a presigned
+// GET URL, nil when the request overrides none
+func responseParams(req *interfaces.PresignedURLRequest, objInfo *minio.ObjectInfo) (url.Values, error) {
+	disposition := req.ResponseContentDisposition
+	if req.Attachment && disposition == "" {
+		disposition = contentDisposition(objInfo.UserMetadata["Original-Filename"], objInfo.Key)
+	}
+	if disposition == "" && req.ResponseContentType == "" {
+		return nil, nil
+	}
+	if req.Action != "GET" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Response headers can only be set on GET URLs"}
+	}
+
+	params := make(url.Values)
+	if disposition != "" {
+		if _, _, err := mime.ParseMediaType(disposition); err != nil {
+			return nil, errors.Wrap(&errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Invalid content disposition"}, err)
+		}
+		params.Set("response-content-disposition", disposition)
+	}
+	if req.ResponseContentType != "" {
+		if _, _, err := mime.ParseMediaType(req.ResponseContentType); err != nil {
+			return nil, errors.Wrap(&errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Invalid content type"}, err)
+		}
+		params.Set("response-content-type", req.ResponseContentType)
+	}
+	return params, nil
+}
+
+// defaultListLimit is the page size of ListFiles without a limit
+const defaultListLimit = 100
+
+// ListFiles lists the files of an entity, optionally of one category, ordered by file key
+// Files are filtered by user metadata (Filters) and object tags (Tags); the bucket is listed
+// on every call, so applications with many files per entity should query their MetadataStore
+func (h *Handler) ListFiles(ctx context.Context, req *interfaces.ListRequest) (*interfaces.ListResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	if req.EntityType == "" || req.EntityID == "" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Entity type and ID are required"}
+	}
+	prefix := req.EntityType + "/" + req.EntityID + "/"
+	if req.Category != "" {
+		prefix += req.Category + "/"
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		prefix = t.Key(prefix)
+	}
+	bucketName := h.tenantBucket(t)
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	offset := max(req.Offset, 0)
+	response := &interfaces.ListResponse{
+		Success: true,
+		Files:   []interfaces.FileInfo{},
+		Limit:   limit,
+		Offset:  offset,
+	}
+
+	// Stop listing on the first error
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := h.Client.ListObjects(listCtx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithMetadata: true})
+	for object := range objects {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list files of %s: %w", prefix, object.Err)
+		}
+		// Thumbnails may share the bucket of their originals
+		if middleware.ThumbnailOriginalKeys(object.Key) != nil {
+			continue
+		}
+
+		objInfo, fileTags, err := h.listedObject(ctx, bucketName, object, len(req.Tags) > 0)
+		if err != nil {
+			return nil, err
+		}
+		if !matchMetadata(objInfo.UserMetadata, req.Filters) || !matchTags(fileTags, req.Tags) {
+			continue
+		}
+
+		response.Total++
+		if response.Total <= offset || len(response.Files) >= limit {
+			continue
+		}
+		fileInfo := h.fileInfo(ctx, objInfo, bucketName)
+		fileInfo.Tags = fileTags
+		response.Files = append(response.Files, *fileInfo)
+	}
+	return response, nil
+}
+
+// listedObject completes a listed object with its user metadata and, when withTags is set, its tags
+// MinIO lists both with the objects, other S3 backends take a request per object
+func (h *Handler) listedObject(ctx context.Context, bucketName string, object minio.ObjectInfo, withTags bool) (*minio.ObjectInfo, map[string]string, error) {
+	if object.UserMetadata == nil {
+		sse, err := h.keyServerSideEncryption(object.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		stat, err := h.statObject(ctx, bucketName, object.Key, minio.StatObjectOptions{ServerSideEncryption: sse})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get info of %s: %w", object.Key, err)
+		}
+		object.UserMetadata = stat.UserMetadata
+		object.ContentType = stat.ContentType
+		object.UserTagCount = stat.UserTagCount
+	} else {
+		// Listed keys keep their header form, e.g. X-Amz-Meta-Category
+		userMetadata := make(map[string]string, len(object.UserMetadata))
+		for key, value := range object.UserMetadata {
+			userMetadata[strings.TrimPrefix(http.CanonicalHeaderKey(key), "X-Amz-Meta-")] = value
+		}
+		object.UserMetadata = userMetadata
+		if object.ContentType == "" {
+			object.ContentType = userMetadata["Content-Type"]
+		}
+	}
+
+	fileTags := map[string]string(object.UserTags)
+	if withTags && len(fileTags) == 0 && object.UserTagCount > 0 {
+		current, err := h.objectTags(ctx, bucketName, object.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+		fileTags = current.ToMap()
+	}
+	return &object, fileTags, nil
+}
+
+// matchMetadata reports whether user metadata holds all wanted values
+func matchMetadata(userMetadata, wanted map[string]string) bool {
+	for key, value := range wanted {
+		if userMetadata[http.CanonicalHeaderKey(key)] != value {
+			return false
+		}
+	}
+	return true
+}
+
+// GetFileInfo retrieves file information from MinIO
+func (h *Handler) GetFileInfo(ctx context.Context, req *interfaces.InfoRequest) (*interfaces.FileInfo, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	// Find the file in buckets
+	fileInfo, bucketName, err := h.findFile(ctx, req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return h.fileInfo(ctx, fileInfo.(*minio.ObjectInfo), bucketName), nil
+}
+
+// Stat returns the size, content type, validators and user metadata of a file without opening it
+// It reads the same, possibly cached, stat as the other operations and passes no middlewares, like GetFileInfo
+func (h *Handler) Stat(ctx context.Context, fileKey string) (*interfaces.FileStat, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	fileInfo, _, err := h.findFile(ctx, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	objInfo := fileInfo.(*minio.ObjectInfo)
+
+	// The stat may be cached, callers get their own copy of the metadata
+	userMetadata := make(map[string]string, len(objInfo.UserMetadata))
+	for key, value := range objInfo.UserMetadata {
+		userMetadata[key] = value
+	}
+	return &interfaces.FileStat{
+		FileKey:      objInfo.Key,
+		FileSize:     middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size),
+		ContentType:  objInfo.ContentType,
+		ETag:         entityTag(objInfo.ETag),
+		LastModified: objInfo.LastModified,
+		UserMetadata: userMetadata,
+	}, nil
+}
+
+// fileInfo converts the info of a stored file, with a usable link according to the category policy
+// File info is returned without authorizing the caller, so only files of public categories that
+// passed their scan and moderation get a link; others are presigned by GeneratePresignedURL
+func (h *Handler) fileInfo(ctx context.Context, objInfo *minio.ObjectInfo, bucketName string) *interfaces.FileInfo {
+	info := h.fileKeyInfo(objInfo)
+	var fileURL string
+	var err error
+	if categoryConfig, _, _ := h.category(info.Category); categoryConfig.IsPublic && servable(objInfo.UserMetadata) {
+		fileURL, err = h.fileURL(ctx, info.Category, bucketName, objInfo.Key)
+	}
+	if err != nil {
+		h.logger.Warn("failed to build file URL", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": objInfo.Key,
+			"error":    err,
+		})
+	}
+
+	return &interfaces.FileInfo{
+		ID:          uuid.NewString(),
+		FileName:    objInfo.Key,
+		FileKey:     objInfo.Key,
+		FileSize:    middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size),
+		ContentType: objInfo.ContentType,
+		Category:    info.Category,
+		EntityType:  info.EntityType,
+		EntityID:    info.EntityID,
+		UploadedBy:  objInfo.UserMetadata["Uploaded-By"],
+		UploadedAt:  objInfo.LastModified,
+		Blurhash:    objInfo.UserMetadata["Blurhash"],
+		Audio:       audioInfo(objInfo.UserMetadata),
+		URL:         fileURL,
+		Metadata: map[string]interface{}{
+			"bucket_name": bucketName,
+			"uploaded_at": objInfo.LastModified,
+			"etag":        objInfo.ETag,
+		},
+	}
+}
+
+// Helper methods
+
+func (h *Handler) findFile(ctx context.Context, fileKey string) (interface{}, string, error) {
+	bucketName, err := h.locateFile(ctx, fileKey)
+	if err != nil {
+		return nil, "", err
+	}
+	if object, ok := h.cachedStat(bucketName, fileKey); ok {
+		return object, bucketName, nil
+	}
+	object, err := h.statFile(ctx, bucketName, fileKey)
+	if err != nil {
+		return nil, "", err
+	}
+	return object, bucketName, nil
+}
+
+// locateFile returns the bucket of a file of the request's tenant, without checking it exists
+// All categories use the same bucket (per tenant)
+func (h *Handler) locateFile(ctx context.Context, fileKey string) (string, error) {
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := h.checkTenantKey(t, fileKey); err != nil {
+		return "", err
+	}
+	return h.requestBucket(ctx, t)
+}
+
+// cachedStat returns the cached stat result of a file
+// Stat results are cached until the file changes, see artifactCache
+func (h *Handler) cachedStat(bucketName, fileKey string) (*minio.ObjectInfo, bool) {
+	if cache := h.artifactCache(); cache != nil {
+		if object, ok := cache.Stat(bucketName, fileKey); ok {
+			return &object, true
+		}
+	}
+	return nil, false
+}
+
+// cacheStat caches the stat result of a file, read by a stat or with the data of the file
+func (h *Handler) cacheStat(bucketName, fileKey string, object minio.ObjectInfo) {
+	if cache := h.artifactCache(); cache != nil {
+		cache.SetStat(bucketName, fileKey, object)
+	}
+}
+
+// statFile stats a file in its bucket and caches the result
+func (h *Handler) statFile(ctx context.Context, bucketName, fileKey string) (*minio.ObjectInfo, error) {
+	sse, err := h.keyServerSideEncryption(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	object, err := h.statObject(ctx, bucketName, fileKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		// Handle specific MinIO errors
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, errors.ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to check file existence: %w", err)
+	}
+	h.cacheStat(bucketName, fileKey, object)
+	return &object, nil
+}
+
+// openFile returns the bytes start to end of a file, decrypting files encrypted by the encryption middleware
+// and decompressing files compressed by the compression middleware
+// The returned reader holds a connection and must be closed
+func (h *Handler) openFile(ctx context.Context, bucketName string, objInfo *minio.ObjectInfo, userID string, start, end int64) (io.Reader, error) {
+	sse, err := h.keyServerSideEncryption(objInfo.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := middleware.IsEncrypted(objInfo.UserMetadata)
+	compressed := middleware.IsCompressed(objInfo.UserMetadata)
+	fileSize := middleware.PlaintextSize(objInfo.UserMetadata, objInfo.Size)
+
+	// Compressed files are read as a whole, the range is cut from the decompressed data
+	plainStart, plainEnd := start, end
+	if compressed {
+		plainStart, plainEnd = 0, fileSize-1
+	}
+
+	opts := minio.GetObjectOptions{ServerSideEncryption: sse}
+	if plainStart > 0 || plainEnd < fileSize-1 {
+		if !encrypted {
+			opts.SetRange(plainStart, plainEnd)
+		} else if storedStart, storedEnd, ok := middleware.EncryptedRange(objInfo.UserMetadata, plainStart, plainEnd); ok {
+			opts.SetRange(storedStart, storedEnd)
+		}
+	}
+
+	object, err := h.Client.GetObject(ctx, bucketName, objInfo.Key, opts)
+	if err != nil {
+		return nil, errors.Wrap(errors.ErrDownloadFailed, err)
+	}
+
+	throttled := h.throttleDownload(ctx, object)
+	var fileData io.Reader = throttled
+	if encrypted {
+		fileData, err = h.decryptObject(ctx, throttled, objInfo, userID, plainStart, plainEnd)
+		if err != nil {
+			object.Close()
+			return nil, err
+		}
+	}
+	if compressed {
+		fileData, err = decompressObject(fileData, object, objInfo.UserMetadata, start, end)
+		if err != nil {
+			object.Close()
+			return nil, err
+		}
+	}
+	return fileData, nil
+}
+
+// recordDownload counts a download served outside the middleware chain, e.g. from the replica,
+// through the security middleware of the file's category
+// Categories without the security middleware are counted without a limit
+func (h *Handler) recordDownload(ctx context.Context, objInfo *minio.ObjectInfo, userID string) error {
+	if security := h.securityMiddleware(h.fileKeyInfo(objInfo).Category); security != nil {
+		if err := security.RecordDownload(ctx, h.chainRequest("download", objInfo, "", userID)); err != nil {
+			return err
+		}
+		return h.countLinkDownload(ctx)
 	}
 
-	// Download from MinIO
-	object, err := h.Client.GetObject(ctx, bucketName, req.FileKey, minio.GetObjectOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to download file: %w", err)
+	if err := h.countLinkDownload(ctx); err != nil {
+		return err
 	}
-
-	// Get object info for proper metadata
-	objInfo, err := object.Stat()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get object info: %w", err)
+	if _, _, err := h.downloads.Increment(ctx, objInfo.Key, userID, 0); err != nil {
+		return fmt.Errorf("failed to record download: %w", err)
 	}
+	return nil
+}
 
-	return &interfaces.DownloadResponse{
-		Success:     true,
-		FileData:    object,
-		FileSize:    objInfo.Size,
-		ContentType: objInfo.ContentType,
-		Metadata: map[string]interface{}{
-			"file_name":    objInfo.Key,
-			"uploaded_at":  objInfo.LastModified,
-			"content_type": objInfo.ContentType,
-		},
-	}, nil
+// GetDownloadCount returns the download counters of a file, User is filled when userID is set
+func (h *Handler) GetDownloadCount(ctx context.Context, fileKey, userID string) (*middleware.DownloadCount, error) {
+	return h.downloads.Get(ctx, fileKey, userID)
 }
 
-// Delete deletes a file from the appropriate bucket
-func (h *Handler) Delete(ctx context.Context, req *interfaces.DeleteRequest) error {
-	// Find the file in buckets
-	_, bucketName, err := h.findFile(ctx, req.FileKey)
+// storedOwner returns the uploaded-by metadata of a file
+func (h *Handler) storedOwner(ctx context.Context, fileKey string) (string, error) {
+	fileInfo, _, err := h.findFile(ctx, fileKey)
 	if err != nil {
-		return err
+		return "", err
 	}
+	return fileInfo.(*minio.ObjectInfo).UserMetadata["Uploaded-By"], nil
+}
 
-	// Delete from MinIO
-	err = h.Client.RemoveObject(ctx, bucketName, req.FileKey, minio.RemoveObjectOptions{})
+func (h *Handler) HealthCheck(ctx context.Context) error {
+	// Check if the global bucket exists, failing fast while the circuit breaker is open
+	var exists bool
+	err := h.guard("health_check", func() error {
+		var err error
+		exists, err = h.Client.BucketExists(ctx, h.BucketName)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to delete file: %w", err)
+		return fmt.Errorf("failed to check bucket %s: %w", h.BucketName, err)
+	}
+	if !exists {
+		return fmt.Errorf("bucket %s does not exist", h.BucketName)
 	}
-
-	// Note: For metadata cleanup, users should implement their own cleanup logic
-	// in their metadata storage system (database, Redis, etc.)
-	// This library focuses only on MinIO operations
-
 	return nil
 }
 
-// Preview generates a preview URL for a file
-func (h *Handler) Preview(ctx context.Context, req *interfaces.PreviewRequest) (*interfaces.PreviewResponse, error) {
-	// Find the file in buckets
-	fileInfo, bucketName, err := h.findFile(ctx, req.FileKey)
-	if err != nil {
-		return nil, err
+// publish emits an event for this handler
+func (h *Handler) publish(ctx context.Context, eventType events.Type, category, fileKey, userID string, data map[string]interface{}) {
+	if h.Events == nil {
+		return
 	}
+	h.Events.Publish(ctx, &events.Event{
+		Type:      eventType,
+		Handler:   h.Name,
+		Category:  category,
+		FileKey:   fileKey,
+		UserID:    userID,
+		RequestID: requestid.FromContext(ctx),
+		Data:      data,
+	})
+}
 
-	// Get object info for proper metadata
-	objInfo := fileInfo.(*minio.ObjectInfo)
+// forwardThumbnailEvents publishes ThumbnailsReady for every successful thumbnail job
+func (h *Handler) forwardThumbnailEvents() {
+	statuses, unsubscribe := h.AsyncProcessor.Subscribe(0)
+	h.stopThumbnailForward = unsubscribe
 
-	// Generate presigned URL for preview (expires in 1 hour)
-	previewURL, err := h.Client.PresignedGetObject(ctx, bucketName, req.FileKey, time.Hour, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate preview URL: %w", err)
+	go func() {
+		for status := range statuses {
+			if status.Status != jobs.StatusDone || status.Reason != "" {
+				continue
+			}
+			// Regenerated thumbnails replace cached ones
+			h.invalidate(context.Background(), status.FileKey)
+			h.recordThumbnails(context.Background(), status)
+			h.publish(context.Background(), events.TypeThumbnailsReady, "", status.FileKey, "", map[string]interface{}{
+				"job_id":     status.JobID,
+				"thumbnails": status.Thumbnails,
+			})
+		}
+	}()
+}
+
+// GetStats returns statistics of the background jobs and of every middleware that reports them
+func (h *Handler) GetStats() map[string]interface{} {
+	chains := h.chains()
+	categories := make(map[string]interface{}, len(chains))
+	for category, chain := range chains {
+		middlewareStats := make(map[string]interface{})
+		for _, m := range chain.Middlewares() {
+			if reporter, ok := m.(interface{ GetStats() map[string]interface{} }); ok {
+				middlewareStats[m.Name()] = reporter.GetStats()
+			}
+		}
+		categories[category] = middlewareStats
 	}
 
-	return &interfaces.PreviewResponse{
-		Success:     true,
-		PreviewURL:  previewURL.String(),
-		ContentType: objInfo.ContentType,
-		FileSize:    objInfo.Size,
-		Metadata: map[string]interface{}{
-			"file_name":    objInfo.Key,
-			"uploaded_at":  objInfo.LastModified,
-			"content_type": objInfo.ContentType,
-		},
-	}, nil
+	stats := map[string]interface{}{
+		"name":       h.Name,
+		"bucket":     h.BucketName,
+		"categories": categories,
+	}
+	if h.AsyncProcessor != nil {
+		stats["async"] = h.AsyncProcessor.GetStats()
+	}
+	stats["circuit_breaker"] = h.breaker.stats()
+	if h.config().Replication.enabled() {
+		stats["replication"] = h.replication.snapshot()
+	}
+	if h.buffers != nil {
+		stats["buffers"] = h.buffers.GetStats()
+	}
+	return stats
 }
 
-// Stream streams a file from the appropriate bucket
-func (h *Handler) Stream(ctx context.Context, req *interfaces.StreamRequest) (*interfaces.StreamResponse, error) {
-	// Find the file in buckets
-	fileInfo, bucketName, err := h.findFile(ctx, req.FileKey)
-	if err != nil {
-		return nil, err
+// CacheStats returns the statistics of the handler's cache, shared by the cache middlewares of all
+// categories; nil before Initialize
+func (h *Handler) CacheStats() map[string]interface{} {
+	if h.cache == nil {
+		return nil
 	}
+	return h.cache.GetStats()
+}
 
-	// Get object info for proper metadata
-	objInfo := fileInfo.(*minio.ObjectInfo)
+// MonitoringSeries returns the stats of the monitoring middlewares of all categories, labeled with
+// the handler, category and backend, ordered by category
+func (h *Handler) MonitoringSeries() []middleware.MonitoringSeries {
+	chains := h.chains()
+	categories := make([]string, 0, len(chains))
+	for category := range chains {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
 
-	// Stream from MinIO
-	opts := minio.GetObjectOptions{}
-	if req.Range != "" {
-		// Parse range header for partial content requests
-		start, end, err := h.parseRangeHeader(req.Range, objInfo.Size)
-		if err != nil {
-			return nil, fmt.Errorf("invalid range header: %w", err)
+	series := []middleware.MonitoringSeries{}
+	for _, category := range categories {
+		for _, m := range chains[category].Middlewares() {
+			if monitoring, ok := m.(*middleware.MonitoringMiddleware); ok {
+				series = append(series, monitoring.Series())
+			}
 		}
-		opts.SetRange(start, end)
 	}
+	return series
+}
 
-	object, err := h.Client.GetObject(ctx, bucketName, req.FileKey, opts)
-	if err != nil {
-		return nil, fmt.Errorf("failed to stream file: %w", err)
+// track registers an in-flight operation, failing once Close has started
+// Operations nested in an already tracked one (e.g. uploads inside a batch) are let through
+func (h *Handler) track(ctx context.Context) (context.Context, func(), error) {
+	if ctx.Value(inflightKey{}) == h {
+		return ctx, func() {}, nil
 	}
 
-	return &interfaces.StreamResponse{
-		Success:     true,
-		FileData:    object,
-		FileSize:    objInfo.Size,
-		ContentType: objInfo.ContentType,
-		Range:       req.Range,
-		Metadata: map[string]interface{}{
-			"file_name":    objInfo.Key,
-			"uploaded_at":  objInfo.LastModified,
-			"content_type": objInfo.ContentType,
-		},
-	}, nil
-}
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
 
-// GeneratePresignedURL generates a presigned URL for a file
-func (h *Handler) GeneratePresignedURL(ctx context.Context, req *interfaces.PresignedURLRequest) (*interfaces.PresignedURLResponse, error) {
-	// Find the file in buckets
-	_, bucketName, err := h.findFile(ctx, req.FileKey)
-	if err != nil {
-		return nil, err
+	if h.closed {
+		return ctx, nil, errors.ErrHandlerClosed
 	}
+	h.inflight.Add(1)
 
-	// Generate presigned URL based on action
-	var url *url.URL
-	switch req.Action {
-	case "GET":
-		url, err = h.Client.PresignedGetObject(ctx, bucketName, req.FileKey, req.Expires, nil)
-	case "PUT":
-		url, err = h.Client.PresignedPutObject(ctx, bucketName, req.FileKey, req.Expires)
-	default:
-		return nil, fmt.Errorf("unsupported action: %s", req.Action)
-	}
+	// Every operation carries a request ID, the caller's when it set one
+	ctx, _ = requestid.Ensure(ctx)
 
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate presigned URL: %w", err)
-	}
+	// Abort the operation if Close gives up waiting for it
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, inflightKey{}, h))
+	stop := context.AfterFunc(h.abortCtx, cancel)
 
-	return &interfaces.PresignedURLResponse{
-		Success:   true,
-		URL:       url.String(),
-		ExpiresAt: time.Now().Add(req.Expires),
-		Metadata: map[string]interface{}{
-			"file_name":  req.FileKey,
-			"action":     req.Action,
-			"expires_at": time.Now().Add(req.Expires),
-		},
+	return ctx, func() {
+		stop()
+		h.inflight.Done()
 	}, nil
 }
 
-// ListFiles lists files for a specific entity
-// Note: This is a simplified implementation that returns empty results
-// For file listing, users should implement their own metadata storage and querying
-func (h *Handler) ListFiles(ctx context.Context, req *interfaces.ListRequest) (*interfaces.ListResponse, error) {
-	// This library focuses on MinIO operations only
-	// File listing requires external metadata storage (database, Redis, etc.)
-	return &interfaces.ListResponse{
-		Success: true,
-		Files:   []interfaces.FileInfo{},
-		Total:   0,
-		Limit:   req.Limit,
-		Offset:  req.Offset,
-	}, nil
-}
+// Close stops accepting new operations and waits for in-flight ones and background jobs, those
+// waiting in an in-memory queue included; retries still waiting for their delay are not run. When
+// ctx expires first, the remaining work is cancelled: jobs on a durable queue are redelivered
+// later, while jobs of an in-memory queue that did not run, retries included, are recorded as
+// failed with jobs.ErrProcessorStopped
+func (h *Handler) Close(ctx context.Context) error {
+	h.mutex.Lock()
+	if h.closed {
+		h.mutex.Unlock()
+		return nil
+	}
+	h.closed = true
+	h.mutex.Unlock()
 
-// GetFileInfo retrieves file information from MinIO
-func (h *Handler) GetFileInfo(ctx context.Context, req *interfaces.InfoRequest) (*interfaces.FileInfo, error) {
-	// Find the file in buckets
-	fileInfo, bucketName, err := h.findFile(ctx, req.FileKey)
-	if err != nil {
-		return nil, err
+	// Direct uploads reported from now on are processed by ConfirmUpload or another handler
+	if h.stopDirectUploads != nil {
+		h.stopDirectUploads()
+	}
+	if h.stopAudit != nil {
+		h.stopAudit()
 	}
 
-	// Get object info for proper metadata
-	objInfo := fileInfo.(*minio.ObjectInfo)
+	drained := make(chan struct{})
+	go func() {
+		h.inflight.Wait()
+		close(drained)
+	}()
+
+	var closeErr error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		h.abort()
+		closeErr = fmt.Errorf("failed to drain in-flight operations: %w", ctx.Err())
+	}
 
-	// Convert to FileInfo
-	return &interfaces.FileInfo{
-		ID:          uuid.NewString(),
-		FileName:    objInfo.Key,
-		FileKey:     objInfo.Key,
-		FileSize:    objInfo.Size,
-		ContentType: objInfo.ContentType,
-		UploadedAt:  objInfo.LastModified,
-		Metadata: map[string]interface{}{
-			"bucket_name": bucketName,
-			"uploaded_at": objInfo.LastModified,
-			"etag":        objInfo.ETag,
-		},
-	}, nil
-}
+	// Stop background workers once no operation can submit more jobs
+	if h.AsyncProcessor != nil {
+		if err := h.AsyncProcessor.Shutdown(ctx); err != nil && closeErr == nil {
+			closeErr = fmt.Errorf("failed to drain background jobs: %w", err)
+		}
+	}
 
-// Helper methods
+	// Stop middleware routines (cache cleanup, metrics) and flush audit logs
+	for _, chain := range h.chains() {
+		stopChain(chain)
+	}
+	if h.cache != nil {
+		h.cache.Stop()
+	}
 
-func (h *Handler) findFile(ctx context.Context, fileKey string) (interface{}, string, error) {
-	// Since all categories use the same bucket, directly check that bucket
-	object, err := h.Client.StatObject(ctx, h.BucketName, fileKey, minio.StatObjectOptions{})
-	if err == nil {
-		return &object, h.BucketName, nil
+	// Deliver pending events
+	if h.stopThumbnailForward != nil {
+		h.stopThumbnailForward()
+	}
+	if h.Events != nil && h.ownsEvents {
+		if err := h.Events.Close(ctx); err != nil && closeErr == nil {
+			closeErr = err
+		}
 	}
 
-	// Handle specific MinIO errors
-	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
-		return nil, "", &errors.StorageError{Code: "FILE_NOT_FOUND", Message: "File not found"}
+	if h.abort != nil {
+		h.abort()
 	}
+	return closeErr
+}
 
-	return nil, "", fmt.Errorf("failed to check file existence: %w", err)
+// RegisterJobHandler installs a handler for a background job type
+// concurrency limits how many jobs of this type run at once (0 uses the configured default)
+func (h *Handler) RegisterJobHandler(jobType jobs.Type, jobHandler jobs.Handler, concurrency int) error {
+	return h.AsyncProcessor.Jobs().Register(jobType, jobHandler, concurrency)
 }
 
-func (h *Handler) HealthCheck(ctx context.Context) error {
-	// Check if the global bucket exists
-	exists, err := h.Client.BucketExists(ctx, h.BucketName)
-	if err != nil {
-		return fmt.Errorf("failed to check bucket %s: %w", h.BucketName, err)
-	}
-	if !exists {
-		return fmt.Errorf("bucket %s does not exist", h.BucketName)
+// SubmitJob queues a background job for a file stored by this handler
+func (h *Handler) SubmitJob(ctx context.Context, job *jobs.Job) error {
+	if job.BucketName == "" {
+		t, err := h.tenant(ctx)
+		if err != nil {
+			return err
+		}
+		job.BucketName, err = h.requestBucket(ctx, t)
+		if err != nil {
+			return err
+		}
 	}
-	return nil
+	return h.AsyncProcessor.Jobs().Submit(ctx, job)
 }
 
-func (h *Handler) Close() error {
-	// Cleanup resources if needed
-	return nil
+// CancelJob stops a running or queued background job, it is not retried
+func (h *Handler) CancelJob(ctx context.Context, jobID string) (bool, error) {
+	return h.AsyncProcessor.Cancel(ctx, jobID)
+}
+
+// GetJob returns the status of a background job
+func (h *Handler) GetJob(ctx context.Context, jobID string) (*jobs.Job, error) {
+	return h.AsyncProcessor.GetJob(ctx, jobID)
+}
+
+// ListJobs returns all known background jobs for a file
+func (h *Handler) ListJobs(ctx context.Context, fileKey string) ([]*jobs.Job, error) {
+	return h.AsyncProcessor.JobsForFile(ctx, fileKey)
 }
 
-// setupMiddlewares sets up middleware chains for a category
-func (h *Handler) setupMiddlewares(category string, categoryConfig category.CategoryConfig) error {
+// GetThumbnailStatus returns the status of the latest thumbnail job for a file
+func (h *Handler) GetThumbnailStatus(ctx context.Context, fileKey string) (*middleware.ThumbnailStatus, error) {
+	return h.AsyncProcessor.ThumbnailStatus(ctx, fileKey)
+}
+
+// SubscribeThumbnails returns a channel receiving the status of every finished thumbnail job
+// Call the returned func to unsubscribe
+func (h *Handler) SubscribeThumbnails(buffer int) (<-chan *middleware.ThumbnailStatus, func()) {
+	return h.AsyncProcessor.Subscribe(buffer)
+}
+
+// buildMiddlewares creates the middleware chain of a category, called with configMutex held
+func (h *Handler) buildMiddlewares(category string, categoryConfig category.CategoryConfig) (*middleware.MiddlewareChain, error) {
 	chain := middleware.NewMiddlewareChain()
 
 	// Get middleware names for this category
@@ -413,16 +1923,56 @@ func (h *Handler) setupMiddlewares(category string, categoryConfig category.Cate
 		middlewareNames = h.Config.Middlewares
 	}
 
+	// Anonymous uploads are limited before any other middleware runs
+	if categoryConfig.Anonymous.Enabled {
+		chain.Add(h.anonymousLimiter(category, categoryConfig.Anonymous))
+	}
+
 	// Add middlewares to chain
 	for _, middlewareName := range middlewareNames {
 		middleware, err := h.createMiddleware(middlewareName, category, categoryConfig)
 		if err != nil {
-			return fmt.Errorf("failed to create middleware %s: %w", middlewareName, err)
+			stopChain(chain)
+			return nil, fmt.Errorf("failed to create middleware %s: %w", middlewareName, err)
 		}
 		chain.Add(middleware)
 	}
 
-	h.Middlewares[category] = chain
+	return chain, nil
+}
+
+// middlewareNames lists the middlewares createMiddleware can build
+var middlewareNames = map[string]bool{
+	"security":    true,
+	"validation":  true,
+	"thumbnail":   true,
+	"encryption":  true,
+	"compression": true,
+	"audit":       true,
+	"cdn":         true,
+	"memory":      true,
+	"cache":       true,
+	"ratelimit":   true,
+	"monitoring":  true,
+}
+
+// BuiltinMiddleware reports whether a middleware name is one of the built-in middlewares
+func BuiltinMiddleware(name string) bool {
+	return middlewareNames[name]
+}
+
+// validateMiddlewares checks that every middleware in a list is built in, has a factory or is
+// declared by an entry in MiddlewareConfigs, e.g. in config files which cannot hold factories
+func (c *HandlerConfig) validateMiddlewares(names []string, configs map[string]map[string]interface{}) error {
+	for _, name := range names {
+		_, declared := configs[name]
+		if _, exists := c.MiddlewareConfigs[name]; exists {
+			declared = true
+		}
+		if !middlewareNames[name] && c.MiddlewareFactories[name] == nil && !declared {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Unknown middleware " + name}
+		}
+	}
 	return nil
 }
 
@@ -431,10 +1981,22 @@ func (h *Handler) createMiddleware(name, category string, categoryConfig categor
 	switch name {
 	case "security":
 		securityConfig := categoryConfig.Security
-		if !securityConfig.RequireAuth && !securityConfig.RequireOwner {
+		if !securityConfig.RequireAuth && !securityConfig.RequireOwner && len(securityConfig.Roles) == 0 && !categoryConfig.Anonymous.Enabled {
 			// Use handler default security config
 			securityConfig = h.Config.Security
 		}
+		securityConfig.DownloadCounter = h.downloads
+		securityConfig.BucketName = h.BucketName
+		if securityConfig.OwnerLookup == nil {
+			// Reads through findFile so SSE-C objects are stated with their key
+			securityConfig.OwnerLookup = h.storedOwner
+		}
+		if securityConfig.Authorizer == nil {
+			securityConfig.Authorizer = h.Config.Authorizer
+		}
+		if securityConfig.SharedAccess == nil && h.Config.Shares != nil {
+			securityConfig.SharedAccess = h.sharedAccess
+		}
 
 		return middleware.NewSecurityMiddleware(securityConfig, h.Client), nil
 
@@ -472,13 +2034,19 @@ func (h *Handler) createMiddleware(name, category string, categoryConfig categor
 			// Use handler default preview config
 			previewConfig = h.Config.Preview
 		}
+		derivedBucket := h.derivedBucket(categoryConfig)
 		thumbnailConfig := middleware.ThumbnailConfig{
 			GenerateThumbnails: previewConfig.GenerateThumbnails,
 			ThumbnailSizes:     previewConfig.ThumbnailSizes,
-			ThumbnailBucket:    h.BucketName, // Use the same bucket as original files
+			Placeholder:        previewConfig.Placeholder,
+			SourceBucket:       h.BucketName,
+			ThumbnailBucket:    derivedBucket,
+			StorageClass:       h.derivedStorageClass(categoryConfig),
 			ThumbnailPrefix:    "thumbnails",
 			AsyncProcessing:    true, // Enable async processing by default
 			AsyncConfig:        middleware.DefaultAsyncConfig(),
+			AsyncProcessor:     h.AsyncProcessor,
+			Logger:             h.logger,
 		}
 		return middleware.NewThumbnailMiddleware(thumbnailConfig, h.Client), nil
 
@@ -494,18 +2062,37 @@ func (h *Handler) createMiddleware(name, category string, categoryConfig categor
 			KeySource:     "env",
 			EncryptAtRest: securityConfig.EncryptAtRest,
 		}
+		if h.Config.KeyProvider != nil {
+			encryptionConfig.KeySource = "kms"
+			encryptionConfig.KeyProvider = h.Config.KeyProvider
+			encryptionConfig.KeyResolver = h.Config.KeyResolver
+		} else if h.Config.EncryptionKeySecret != "" {
+			encryptionConfig.KeySource = "secret"
+			encryptionConfig.KeySecret = h.Config.EncryptionKeySecret
+			encryptionConfig.Secrets = h.Config.Secrets
+		}
 		return middleware.NewEncryptionMiddleware(encryptionConfig), nil
 
+	case "compression":
+		compressionConfig := categoryConfig.Compression
+		if compressionConfig.Algorithm == "" && len(compressionConfig.ContentTypes) == 0 {
+			// Use handler default compression config
+			compressionConfig = h.Config.Compression
+		}
+		return middleware.NewCompressionMiddleware(compressionConfig), nil
+
 	case "audit":
 		auditConfig := middleware.AuditConfig{
 			Enabled:     true,
 			LogLevel:    "info",
 			LogFormat:   "json",
-			Operations:  []string{"upload", "download", "delete", "preview"},
+			Operations:  []string{"upload", "download", "delete", "preview", "stream", "append", "patch", "metadata", "presign", "tags"},
 			Fields:      []string{"user_id", "file_key", "operation", "timestamp", "success"},
 			Destination: "stdout",
+			Store:       h.auditStore,
+			Logger:      h.logger,
 		}
-		return middleware.NewAuditMiddleware(auditConfig, nil), nil
+		return middleware.NewAuditMiddleware(auditConfig, h.logger), nil
 
 	case "cdn":
 		previewConfig := categoryConfig.Preview
@@ -516,8 +2103,12 @@ func (h *Handler) createMiddleware(name, category string, categoryConfig categor
 		cdnConfig := middleware.CDNConfig{
 			Enabled:     previewConfig.UseCDN,
 			CDNEndpoint: previewConfig.CDNEndpoint,
-			CDNProvider: "custom",
+			CDNProvider: previewConfig.CDNProvider,
 			CacheTTL:    3600, // 1 hour
+			Signing:     previewConfig.CDNSigning,
+		}
+		if cdnConfig.CDNProvider == "" {
+			cdnConfig.CDNProvider = "custom"
 		}
 		return middleware.NewCDNMiddleware(cdnConfig), nil
 
@@ -527,208 +2118,62 @@ func (h *Handler) createMiddleware(name, category string, categoryConfig categor
 		if categoryConfig.MaxSize > 0 {
 			memoryConfig.MaxFileSize = categoryConfig.MaxSize
 		}
+		memoryConfig.Logger = h.logger
+		memoryConfig.Buffers = h.buffers
 		return middleware.NewMemoryMiddleware(memoryConfig), nil
 
 	case "cache":
-		cacheConfig := middleware.DefaultCacheConfig()
+		cacheConfig := h.Config.Cache
+		if cacheConfig.MaxSize == 0 {
+			cacheConfig = middleware.DefaultCacheConfig()
+		}
+		cacheConfig.Logger = h.logger
+		cacheConfig.Cache = h.cache
 		return middleware.NewCacheMiddleware(cacheConfig), nil
 
+	case "ratelimit":
+		rateLimitConfig := categoryConfig.RateLimit
+		if len(rateLimitConfig.Limits) == 0 {
+			// Use handler default rate limits
+			rateLimitConfig = h.Config.RateLimit
+		}
+		if len(rateLimitConfig.Limits) == 0 {
+			rateLimitConfig = middleware.DefaultRateLimitConfig()
+		}
+		if rateLimitConfig.Limiter == nil {
+			rateLimitConfig.Limiter = h.rateLimiter
+		}
+		return middleware.NewRateLimitMiddleware(rateLimitConfig, category), nil
+
 	case "monitoring":
 		monitoringConfig := middleware.DefaultMonitoringConfig()
+		monitoringConfig.Logger = h.logger
+		monitoringConfig.DownloadCounter = h.downloads
+		monitoringConfig.Labels = map[string]string{
+			middleware.LabelHandler:  h.Name,
+			middleware.LabelCategory: category,
+			middleware.LabelBackend:  h.Client.EndpointURL().Host,
+		}
 		return middleware.NewMonitoringMiddleware(monitoringConfig), nil
 
 	default:
-		return nil, fmt.Errorf("unknown middleware: %s", name)
-	}
-}
-
-// BatchUpload uploads multiple files in a single operation
-func (h *Handler) BatchUpload(ctx context.Context, req *interfaces.BatchUploadRequest) (*interfaces.BatchUploadResponse, error) {
-	if len(req.Files) == 0 {
-		return &interfaces.BatchUploadResponse{
-			Success: false,
-			Error:   &errors.StorageError{Code: "INVALID_REQUEST", Message: "No files provided"},
-		}, nil
-	}
-
-	// Limit batch size to prevent memory issues
-	maxBatchSize := 10
-	if len(req.Files) > maxBatchSize {
-		return &interfaces.BatchUploadResponse{
-			Success: false,
-			Error:   &errors.StorageError{Code: "BATCH_SIZE_EXCEEDED", Message: fmt.Sprintf("Batch size %d exceeds maximum %d", len(req.Files), maxBatchSize)},
-		}, nil
-	}
-
-	results := make([]*interfaces.UploadResponse, len(req.Files))
-	successCount := 0
-
-	// Process files concurrently
-	type result struct {
-		index int
-		resp  *interfaces.UploadResponse
-		err   error
-	}
-
-	resultChan := make(chan result, len(req.Files))
-
-	for i, file := range req.Files {
-		go func(index int, file interfaces.BatchFile) {
-			uploadReq := &interfaces.UploadRequest{
-				FileData:    file.FileData,
-				FileSize:    file.FileSize,
-				ContentType: file.ContentType,
-				FileName:    file.FileName,
-				Category:    file.Category,
-				UserID:      req.UserID,
-				Metadata:    file.Metadata,
-			}
-
-			resp, err := h.Upload(ctx, uploadReq)
-			// resp is already an UploadResponse, no conversion needed
-			resultChan <- result{index: index, resp: resp, err: err}
-		}(i, file)
-	}
-
-	// Collect results
-	for i := 0; i < len(req.Files); i++ {
-		res := <-resultChan
-		results[res.index] = res.resp
-		if res.resp != nil && res.resp.Success {
-			successCount++
+		factory := h.Config.MiddlewareFactories[name]
+		if factory == nil {
+			return nil, fmt.Errorf("no factory registered for middleware %s", name)
 		}
-	}
-
-	return &interfaces.BatchUploadResponse{
-		Success:      successCount > 0,
-		Results:      results,
-		SuccessCount: successCount,
-		TotalCount:   len(req.Files),
-	}, nil
-}
-
-// BatchDelete deletes multiple files in a single operation
-func (h *Handler) BatchDelete(ctx context.Context, req *interfaces.BatchDeleteRequest) (*interfaces.BatchDeleteResponse, error) {
-	if len(req.FileKeys) == 0 {
-		return &interfaces.BatchDeleteResponse{
-			Success: false,
-			Error:   &errors.StorageError{Code: "INVALID_REQUEST", Message: "No file keys provided"},
-		}, nil
-	}
-
-	// Limit batch size to prevent memory issues
-	maxBatchSize := 50
-	if len(req.FileKeys) > maxBatchSize {
-		return &interfaces.BatchDeleteResponse{
-			Success: false,
-			Error:   &errors.StorageError{Code: "BATCH_SIZE_EXCEEDED", Message: fmt.Sprintf("Batch size %d exceeds maximum %d", len(req.FileKeys), maxBatchSize)},
-		}, nil
-	}
-
-	results := make([]*interfaces.DeleteResponse, len(req.FileKeys))
-	successCount := 0
-
-	// Process deletions concurrently
-	type result struct {
-		index int
-		resp  *interfaces.DeleteResponse
-		err   error
-	}
-
-	resultChan := make(chan result, len(req.FileKeys))
-
-	for i, fileKey := range req.FileKeys {
-		go func(index int, fileKey string) {
-			deleteReq := &interfaces.DeleteRequest{
-				FileKey: fileKey,
-				UserID:  req.UserID,
-			}
-
-			err := h.Delete(ctx, deleteReq)
-			// Convert error to DeleteResponse
-			deleteResp := &interfaces.DeleteResponse{
-				Success: err == nil,
-				Error:   err,
-			}
-			resultChan <- result{index: index, resp: deleteResp, err: err}
-		}(i, fileKey)
-	}
-
-	// Collect results
-	for i := 0; i < len(req.FileKeys); i++ {
-		res := <-resultChan
-		results[res.index] = res.resp
-		if res.resp != nil && res.resp.Success {
-			successCount++
+		config, exists := categoryConfig.MiddlewareConfigs[name]
+		if !exists {
+			config = h.Config.MiddlewareConfigs[name]
 		}
-	}
-
-	return &interfaces.BatchDeleteResponse{
-		Success:      successCount > 0,
-		Results:      results,
-		SuccessCount: successCount,
-		TotalCount:   len(req.FileKeys),
-	}, nil
-}
-
-// BatchGet retrieves multiple files in a single operation
-func (h *Handler) BatchGet(ctx context.Context, req *interfaces.BatchGetRequest) (*interfaces.BatchGetResponse, error) {
-	if len(req.FileKeys) == 0 {
-		return &interfaces.BatchGetResponse{
-			Success: false,
-			Error:   &errors.StorageError{Code: "INVALID_REQUEST", Message: "No file keys provided"},
-		}, nil
-	}
-
-	// Limit batch size to prevent memory issues
-	maxBatchSize := 20
-	if len(req.FileKeys) > maxBatchSize {
-		return &interfaces.BatchGetResponse{
-			Success: false,
-			Error:   &errors.StorageError{Code: "BATCH_SIZE_EXCEEDED", Message: fmt.Sprintf("Batch size %d exceeds maximum %d", len(req.FileKeys), maxBatchSize)},
-		}, nil
-	}
-
-	results := make([]*interfaces.DownloadResponse, len(req.FileKeys))
-	successCount := 0
-
-	// Process downloads concurrently
-	type result struct {
-		index int
-		resp  *interfaces.DownloadResponse
-		err   error
-	}
-
-	resultChan := make(chan result, len(req.FileKeys))
-
-	for i, fileKey := range req.FileKeys {
-		go func(index int, fileKey string) {
-			downloadReq := &interfaces.DownloadRequest{
-				FileKey: fileKey,
-				UserID:  req.UserID,
-			}
-
-			resp, err := h.Download(ctx, downloadReq)
-			// resp is already a DownloadResponse, no conversion needed
-			resultChan <- result{index: index, resp: resp, err: err}
-		}(i, fileKey)
-	}
-
-	// Collect results
-	for i := 0; i < len(req.FileKeys); i++ {
-		res := <-resultChan
-		results[res.index] = res.resp
-		if res.resp != nil && res.resp.Success {
-			successCount++
+		custom, err := factory(config, h.Client)
+		if err != nil {
+			return nil, err
+		}
+		if custom == nil {
+			return nil, fmt.Errorf("factory of middleware %s returned no middleware", name)
 		}
+		return custom, nil
 	}
-
-	return &interfaces.BatchGetResponse{
-		Success:      successCount > 0,
-		Results:      results,
-		SuccessCount: successCount,
-		TotalCount:   len(req.FileKeys),
-	}, nil
 }
 
 // parseRangeHeader parses HTTP Range header and returns start and end positions
@@ -770,8 +2215,8 @@ func (h *Handler) parseRangeHeader(rangeHeader string, fileSize int64) (int64, i
 		}
 	}
 
-	// Parse end position
-	if parts[1] != "" {
+	// Parse end position, suffix ranges end with the file
+	if parts[1] != "" && parts[0] != "" {
 		end, err = strconv.ParseInt(parts[1], 10, 64)
 		if err != nil {
 			return 0, 0, fmt.Errorf("invalid end position: %s", parts[1])
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// Health statuses, from best to worst
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded" // Working, but slow or close to a limit
+	HealthDown     = "down"     // Requests fail
+)
+
+// SlowProbeLatency is the probe round trip above which the backend is reported as degraded
+const SlowProbeLatency = time.Second
+
+// healthProbeKey is the object written and read back by health probes, it is removed afterwards
+const healthProbeKey = ".health/"
+
+// ComponentHealth represents the health of a component
+type ComponentHealth struct {
+	Status  string        `json:"status"`
+	Latency time.Duration `json:"latency,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// HandlerHealth represents the health of a handler and its components
+type HandlerHealth struct {
+	Status         string                            `json:"status"`
+	Backend        ComponentHealth                   `json:"backend"` // Write, read and delete of a probe object
+	CircuitBreaker CircuitBreakerStats               `json:"circuit_breaker"`
+	Queue          *middleware.QueueStatus           `json:"queue,omitempty"`  // Background jobs
+	Caches         map[string]middleware.CacheStatus `json:"caches,omitempty"` // Cache middlewares per category
+}
+
+// Health probes the backend with a tiny object and reports it together with the state of the
+// circuit breaker, the job queue and the caches, e.g. for readiness endpoints
+func (h *Handler) Health(ctx context.Context) *HandlerHealth {
+	health := &HandlerHealth{
+		Backend:        h.probe(ctx),
+		CircuitBreaker: h.breaker.stats(),
+	}
+	health.Status = health.Backend.Status
+
+	switch health.CircuitBreaker.State {
+	case CircuitOpen:
+		health.Status = HealthDown
+	case CircuitHalfOpen:
+		health.Status = WorstHealth(health.Status, HealthDegraded)
+	}
+
+	if h.AsyncProcessor != nil {
+		queue := h.AsyncProcessor.QueueStatus()
+		health.Queue = &queue
+		if !queue.Running || (queue.MaxSize > 0 && queue.Backlog >= queue.MaxSize) {
+			health.Status = WorstHealth(health.Status, HealthDegraded)
+		}
+	}
+
+	for category, chain := range h.chains() {
+		for _, m := range chain.Middlewares() {
+			if cache, ok := m.(*middleware.CacheMiddleware); ok {
+				if health.Caches == nil {
+					health.Caches = make(map[string]middleware.CacheStatus)
+				}
+				health.Caches[category] = cache.Status()
+			}
+		}
+	}
+
+	return health
+}
+
+// probe writes, reads back and removes a probe object, measuring the round trip
+func (h *Handler) probe(ctx context.Context) ComponentHealth {
+	key := healthProbeKey + h.Name
+	payload := []byte(time.Now().UTC().Format(time.RFC3339Nano))
+
+	start := time.Now()
+	err := h.guard("health_probe", func() error {
+		if _, err := h.Client.PutObject(ctx, h.BucketName, key, bytes.NewReader(payload), int64(len(payload)), minio.PutObjectOptions{ContentType: "text/plain"}); err != nil {
+			return err
+		}
+		object, err := h.Client.GetObject(ctx, h.BucketName, key, minio.GetObjectOptions{})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(io.Discard, object)
+		object.Close()
+		if err != nil {
+			return err
+		}
+		return h.Client.RemoveObject(ctx, h.BucketName, key, minio.RemoveObjectOptions{})
+	})
+	latency := time.Since(start)
+
+	switch {
+	case err != nil:
+		return ComponentHealth{Status: HealthDown, Latency: latency, Error: err.Error()}
+	case latency > SlowProbeLatency:
+		return ComponentHealth{Status: HealthDegraded, Latency: latency}
+	}
+	return ComponentHealth{Status: HealthOK, Latency: latency}
+}
+
+// WorstHealth returns the worse of two health statuses
+func WorstHealth(a, b string) string {
+	rank := map[string]int{HealthOK: 0, HealthDegraded: 1, HealthDown: 2}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
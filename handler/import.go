@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"path"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/minio/minio-go/v7"
+)
+
+// ImportSource represents an existing object to import
+type ImportSource struct {
+	Client *minio.Client // Backend of the object, the handler's one when nil
+	Bucket string
+	Key    string
+}
+
+// importSource reads an imported object, which is only opened once read
+// Objects on the handler's backend no middleware read are copied server-side instead
+type importSource struct {
+	client *minio.Client
+	bucket string
+	key    string
+	etag   string
+	ctx    context.Context
+	object *minio.Object
+	read   bool
+}
+
+// Read opens the object on first use and reads it
+func (s *importSource) Read(p []byte) (int, error) {
+	if s.object == nil {
+		object, err := s.client.GetObject(s.ctx, s.bucket, s.key, minio.GetObjectOptions{})
+		if err != nil {
+			return 0, err
+		}
+		s.object = object
+	}
+	s.read = true
+	return s.object.Read(p)
+}
+
+// Close closes the object when it was opened
+func (s *importSource) Close() error {
+	if s.object == nil {
+		return nil
+	}
+	return s.object.Close()
+}
+
+// copyable reports whether the object can be copied server-side to a client's backend
+func (s *importSource) copyable(client *minio.Client) bool {
+	return !s.read && s.client == client
+}
+
+// Import uploads an existing object like Upload, e.g. to adopt files stored before this library
+// The middlewares of the category run as for uploads, generating thumbnails and checking files;
+// objects on the handler's backend no middleware reads are copied server-side, others are streamed
+// FileData and FileSize of the request are ignored; ContentType and FileName default to those of
+// the object and the last segment of its key. The source object is left in place
+func (h *Handler) Import(ctx context.Context, source ImportSource, req *interfaces.UploadRequest, opts ...Option) (*interfaces.UploadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	options := callOptions(opts)
+	ctx, cancel := callContext(ctx, options)
+	defer cancel()
+
+	if source.Bucket == "" || source.Key == "" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Source bucket and key are required"}
+	}
+	client := source.Client
+	if client == nil {
+		client = h.Client
+	}
+
+	var objInfo minio.ObjectInfo
+	err = h.retry(ctx, "stat_object", func() error {
+		var err error
+		objInfo, err = client.StatObject(ctx, source.Bucket, source.Key, minio.StatObjectOptions{})
+		return err
+	})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, errors.ErrFileNotFound
+		}
+		return nil, errors.Wrap(errors.ErrDownloadFailed, err)
+	}
+
+	data := &importSource{client: client, bucket: source.Bucket, key: source.Key, etag: objInfo.ETag, ctx: ctx}
+	defer data.Close()
+
+	upload := *req
+	upload.FileData = data
+	upload.FileSize = objInfo.Size
+	if upload.ContentType == "" {
+		upload.ContentType = objInfo.ContentType
+	}
+	if upload.FileName == "" {
+		upload.FileName = path.Base(source.Key)
+	}
+	return h.upload(ctx, &upload, options, nil)
+}
+
+// copyImport copies an imported object server-side, with the metadata and tags of an upload
+func (h *Handler) copyImport(ctx context.Context, source *importSource, bucketName, fileKey string, opts minio.PutObjectOptions) error {
+	userMetadata := make(map[string]string, len(opts.UserMetadata)+1)
+	for key, value := range opts.UserMetadata {
+		userMetadata[key] = value
+	}
+	userMetadata["Content-Type"] = opts.ContentType
+
+	dst := minio.CopyDestOptions{
+		Bucket:          bucketName,
+		Object:          fileKey,
+		Encryption:      opts.ServerSideEncryption,
+		UserMetadata:    userMetadata,
+		ReplaceMetadata: true,
+		UserTags:        opts.UserTags,
+		ReplaceTags:     true,
+	}
+	// The copy fails rather than storing another version when the source changed since its stat
+	src := minio.CopySrcOptions{Bucket: source.bucket, Object: source.key, MatchETag: source.etag}
+	return h.retry(ctx, "copy_object", func() error {
+		_, err := h.Client.ComposeObject(ctx, dst, src)
+		return err
+	})
+}
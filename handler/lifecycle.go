@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// expiryTag is the object tag of files deleted after its value in days by a bucket lifecycle rule
+const expiryTag = "storage-expiry-days"
+
+// expiryRuleID identifies the lifecycle rule of an expiry, shared by all handlers of a bucket
+func expiryRuleID(days int) string {
+	return "StorageExpiry-" + strconv.Itoa(days) + "d"
+}
+
+// applyLifecycleRules adds the lifecycle rules deleting anonymous uploads after the expiry of their
+// category, staged files never committed and direct uploads never processed to the handler bucket; buckets of tenants need rules
+// of their own. Rules are never removed, files tagged earlier still have to expire
+func (h *Handler) applyLifecycleRules(ctx context.Context, config *HandlerConfig) error {
+	days := make(map[int]bool)
+	for _, categoryConfig := range config.Categories {
+		if anonymous := anonymousDefaults(categoryConfig.Anonymous); anonymous.Enabled && anonymous.ExpiryDays > 0 {
+			days[anonymous.ExpiryDays] = true
+		}
+	}
+	if config.Staging.Enabled {
+		days[config.Staging.withDefaults().ExpiryDays] = true
+	}
+	if config.DirectUploads.Enabled {
+		days[config.DirectUploads.withDefaults().ExpiryDays] = true
+	}
+	if len(days) == 0 {
+		return nil
+	}
+	return h.updateLifecycle(ctx, h.BucketName, days)
+}
+
+// updateLifecycle adds missing expiry rules to the lifecycle configuration of a bucket, rules set by others are kept
+func (h *Handler) updateLifecycle(ctx context.Context, bucketName string, days map[int]bool) error {
+	config, err := h.Client.GetBucketLifecycle(ctx, bucketName)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code != "NoSuchLifecycleConfiguration" {
+			return fmt.Errorf("failed to get lifecycle of bucket %s: %w", bucketName, err)
+		}
+		config = lifecycle.NewConfiguration()
+	}
+
+	existing := make(map[string]bool, len(config.Rules))
+	for _, rule := range config.Rules {
+		existing[rule.ID] = true
+	}
+	added := false
+	for expiry := range days {
+		if existing[expiryRuleID(expiry)] {
+			continue
+		}
+		config.Rules = append(config.Rules, lifecycle.Rule{
+			ID:         expiryRuleID(expiry),
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Tag: lifecycle.Tag{Key: expiryTag, Value: strconv.Itoa(expiry)}},
+			Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(expiry)},
+		})
+		added = true
+	}
+	if !added {
+		return nil
+	}
+
+	if err := h.Client.SetBucketLifecycle(ctx, bucketName, config); err != nil {
+		return fmt.Errorf("failed to set lifecycle of bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// setupLifecycleRules applies the lifecycle rules of a configuration, failures are only logged
+// since some backends do not support lifecycle rules; anonymous uploads are kept then
+func (h *Handler) setupLifecycleRules(config *HandlerConfig) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := h.applyLifecycleRules(ctx, config); err != nil {
+		h.logger.Warn("failed to apply lifecycle rules", map[string]interface{}{
+			"handler": h.Name,
+			"error":   err,
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/darmawan01/storage/tenant"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PublicLinkConfig represents public links, see CreatePublicLink
+type PublicLinkConfig struct {
+	// Store keeps the links, links are disabled when nil; use metadata.NewMemoryLinkStore for development
+	Store interfaces.LinkStore `json:"-"`
+	// BaseURL is the application endpoint serving links, the token is appended as a path segment,
+	// e.g. "https://example.com/s" gives "https://example.com/s/<token>"
+	BaseURL string `json:"base_url,omitempty"`
+	// Expiry is the default lifetime of links, 0 for links that do not expire
+	Expiry time.Duration `json:"expiry,omitempty"`
+}
+
+// PublicLinkOptions represents options of a public link
+type PublicLinkOptions struct {
+	// UserID is the user downloads are authorized for, default the user of the context; links stop
+	// working when the user loses access to the file
+	UserID       string
+	Expires      time.Duration // Lifetime of the link, default PublicLinkConfig.Expiry
+	Password     string        // Required to open the link when set, stored as a bcrypt hash
+	MaxDownloads int64         // Downloads of the whole file allowed, 0 for no limit
+}
+
+// CreatePublicLink returns a link to a file that anyone holding it may download, unlike
+// presigned URLs it is served by the application and stops working once revoked with
+// RevokePublicLink. Links are redeemed with DownloadPublicLink or ServePublicLink
+func (h *Handler) CreatePublicLink(ctx context.Context, fileKey string, opts PublicLinkOptions) (*interfaces.PublicLink, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	config := h.config().PublicLinks
+	if config.Store == nil {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "No public link store configured"}
+	}
+	if opts.Expires < 0 || opts.MaxDownloads < 0 {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Link expiry and download limit must be non-negative"}
+	}
+	if _, _, err := h.findFile(ctx, fileKey); err != nil {
+		return nil, err
+	}
+
+	token, err := linkToken()
+	if err != nil {
+		return nil, err
+	}
+	link := &interfaces.PublicLink{
+		Token:        token,
+		Handler:      h.Name,
+		FileKey:      fileKey,
+		URL:          linkURL(config.BaseURL, token),
+		CreatedBy:    opts.UserID,
+		CreatedAt:    time.Now(),
+		MaxDownloads: opts.MaxDownloads,
+	}
+	if link.CreatedBy == "" {
+		if user, ok := middleware.UserFromContext(ctx); ok {
+			link.CreatedBy = user.ID
+		}
+	}
+	expires := opts.Expires
+	if expires == 0 {
+		expires = config.Expiry
+	}
+	if expires > 0 {
+		link.ExpiresAt = link.CreatedAt.Add(expires)
+	}
+	if opts.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(opts.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash link password: %w", err)
+		}
+		link.PasswordHash = string(hash)
+	}
+
+	if err := config.Store.Save(ctx, link); err != nil {
+		return nil, fmt.Errorf("failed to save public link: %w", err)
+	}
+	return link, nil
+}
+
+// RevokePublicLink deletes a link, it stops working at once
+func (h *Handler) RevokePublicLink(ctx context.Context, token string) error {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	store := h.config().PublicLinks.Store
+	if store == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "No public link store configured"}
+	}
+	link, err := store.Get(ctx, token)
+	if err != nil {
+		return err
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	if link.Handler != h.Name || h.checkTenantKey(t, link.FileKey) != nil {
+		return errors.ErrLinkNotFound
+	}
+	if err := store.Delete(ctx, token); err != nil {
+		return fmt.Errorf("failed to delete public link: %w", err)
+	}
+	return nil
+}
+
+// ListPublicLinks returns the links of a file, expired ones included, oldest first
+func (h *Handler) ListPublicLinks(ctx context.Context, fileKey string) ([]interfaces.PublicLink, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	store := h.config().PublicLinks.Store
+	if store == nil {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "No public link store configured"}
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.checkTenantKey(t, fileKey); err != nil {
+		return nil, err
+	}
+
+	links, err := store.List(ctx, fileKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list public links: %w", err)
+	}
+	kept := links[:0]
+	for _, link := range links {
+		if link.Handler == h.Name {
+			kept = append(kept, link)
+		}
+	}
+	return kept, nil
+}
+
+// DownloadPublicLink downloads the file of a link, password is ignored for links without one
+// Every download the security middleware lets through counts against the link's MaxDownloads
+func (h *Handler) DownloadPublicLink(ctx context.Context, token, password string) (*interfaces.DownloadResponse, error) {
+	link, ctx, err := h.openPublicLink(ctx, token, password)
+	if err != nil {
+		return nil, err
+	}
+	return h.Download(ctx, &interfaces.DownloadRequest{
+		FileKey: link.FileKey,
+		UserID:  link.CreatedBy,
+	})
+}
+
+// ServePublicLink writes the file of a link to an HTTP response like ServeFile; GET requests the
+// security middleware lets through count against the link's MaxDownloads when they send the whole
+// file, ranges covering all of it included. Smaller ranges and HEAD requests do not count
+func (h *Handler) ServePublicLink(w http.ResponseWriter, r *http.Request, token, password string) error {
+	link, ctx, err := h.openPublicLink(r.Context(), token, password)
+	if err != nil {
+		return err
+	}
+	return h.ServeFile(w, r.WithContext(ctx), link.FileKey)
+}
+
+// linkKey is the context key of the public link downloads are counted against
+type linkKey struct{}
+
+// openPublicLink checks a link and its password, returning a context acting for its creator in the
+// tenant of its file, which counts downloads against the link
+func (h *Handler) openPublicLink(ctx context.Context, token, password string) (*interfaces.PublicLink, context.Context, error) {
+	config := h.config()
+	if config.PublicLinks.Store == nil {
+		return nil, nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "No public link store configured"}
+	}
+	link, err := config.PublicLinks.Store.Get(ctx, token)
+	if err != nil {
+		return nil, nil, err
+	}
+	if link.Handler != h.Name {
+		return nil, nil, errors.ErrLinkNotFound
+	}
+	if link.Expired(time.Now()) {
+		return nil, nil, errors.ErrLinkExpired
+	}
+	if link.PasswordHash != "" {
+		if password == "" {
+			return nil, nil, errors.ErrLinkPasswordRequired
+		}
+		if bcrypt.CompareHashAndPassword([]byte(link.PasswordHash), []byte(password)) != nil {
+			return nil, nil, errors.ErrInvalidLinkPassword
+		}
+	}
+
+	ctx = context.WithValue(ctx, linkKey{}, link)
+	ctx = middleware.WithUser(ctx, &middleware.User{ID: link.CreatedBy})
+	if config.Tenants != nil {
+		if owner := config.Tenants.Owner(link.FileKey); owner != nil {
+			ctx = tenant.WithID(ctx, owner.ID)
+		}
+	}
+	return link, ctx, nil
+}
+
+// countLinkDownload counts a download against the link of the context, if any, failing once its
+// downloads are used up; downloads call it once the middlewares authorized them
+func (h *Handler) countLinkDownload(ctx context.Context) error {
+	link, ok := ctx.Value(linkKey{}).(*interfaces.PublicLink)
+	if !ok {
+		return nil
+	}
+	counted, err := h.config().PublicLinks.Store.Increment(ctx, link.Token, link.MaxDownloads)
+	if err != nil {
+		return fmt.Errorf("failed to count link download: %w", err)
+	}
+	if !counted {
+		return errors.ErrDownloadLimitExceeded
+	}
+	return nil
+}
+
+// forgetLinks removes the links of a deleted file
+func (h *Handler) forgetLinks(ctx context.Context, fileKey string) {
+	store := h.config().PublicLinks.Store
+	if store == nil {
+		return
+	}
+	links, err := store.List(ctx, fileKey)
+	for _, link := range links {
+		if err != nil {
+			break
+		}
+		if link.Handler == h.Name {
+			err = store.Delete(ctx, link.Token)
+		}
+	}
+	if err != nil {
+		h.logger.Warn("failed to delete public links", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
+
+// linkToken returns a random link token
+func linkToken() (string, error) {
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("failed to generate link token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// linkURL returns the URL of a link, empty without a base URL
+func linkURL(baseURL, token string) string {
+	if baseURL == "" {
+		return ""
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + token
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/events"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// Handler serves the operations of interfaces.StorageClient
+var _ interfaces.StorageClient = (*Handler)(nil)
+
+// reservedMetadata are the user metadata keys written by the handler, its middlewares and jobs,
+// in canonical form; UpdateMetadata does not change them
+var reservedMetadata = canonicalKeys(
+	"original-filename", "entity-type", "entity-id", "category", "uploaded-by", "uploaded-at", "tenant-id", "version", "scan-status",
+	"checksum-sha256", "content-type",
+	middleware.ModerationStatusMetadataKey, "moderated-by", "moderated-at", "moderation-reason",
+	"nsfw-score", "content-labels", "ocr-text",
+	"audio-duration", "audio-bitrate", "audio-sample-rate", "audio-channels", "audio-codec", "hls-renditions",
+	middleware.CompressionMetadataKey, middleware.UncompressedSizeMetadataKey,
+	middleware.EncryptedMetadataKey, middleware.EncryptionAlgorithmMetadataKey, middleware.EncryptionKeyIDMetadataKey,
+	middleware.EncryptionDataKeyMetadataKey, middleware.EncryptionKeyNameMetadataKey,
+	middleware.EncryptionSegmentSizeMetadataKey, middleware.EncryptionNonceMetadataKey,
+	middleware.PlaintextSizeMetadataKey, middleware.EncryptedSizeMetadataKey,
+	middleware.BlurHashMetadataKey, stagedThumbnailsMetadataKey,
+)
+
+// canonicalKeys returns a set of metadata keys in the form MinIO returns them
+func canonicalKeys(keys ...string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[http.CanonicalHeaderKey(key)] = true
+	}
+	return set
+}
+
+// UpdateMetadata sets user metadata of a file, values are stored as strings and nil values remove a key
+// The object metadata is rewritten in place with a server-side copy, the data is not transferred;
+// keys written by the handler and its middlewares (category, owner, encryption, ...) are rejected
+// The MetadataStore record follows and MetadataUpdatedCallback is called with it. Updates pass the
+// middlewares of the category as operation "metadata", the security middleware authorizes them as
+// middleware.ActionReplace against the stored owner
+func (h *Handler) UpdateMetadata(ctx context.Context, req *interfaces.UpdateMetadataRequest) error {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	if len(req.Metadata) == 0 {
+		return &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "No metadata to update"}
+	}
+	for key := range req.Metadata {
+		if key == "" || reservedMetadata[http.CanonicalHeaderKey(key)] {
+			return &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Metadata key " + key + " cannot be updated"}
+		}
+	}
+
+	fileInfo, bucketName, err := h.findFile(ctx, req.FileKey)
+	if err != nil {
+		return err
+	}
+	objInfo := fileInfo.(*minio.ObjectInfo)
+
+	// Metadata is replaced as a whole, so carry over everything else
+	userMetadata := make(map[string]string, len(objInfo.UserMetadata)+len(req.Metadata)+1)
+	for key, value := range objInfo.UserMetadata {
+		userMetadata[key] = value
+	}
+	changes := make(map[string]string, len(req.Metadata))
+	for key, value := range req.Metadata {
+		key = http.CanonicalHeaderKey(key)
+		if value == nil {
+			delete(userMetadata, key)
+			continue
+		}
+		userMetadata[key] = fmt.Sprint(value)
+		changes[key] = userMetadata[key]
+	}
+	userMetadata["Content-Type"] = objInfo.ContentType
+
+	// No data is written, only the metadata
+	chainReq := h.chainRequest("metadata", objInfo, bucketName, req.UserID)
+	chainReq.FileSize = 0
+	chainReq.Replace = true
+	err = h.runChain(ctx, chainReq, func(ctx context.Context) error {
+		if err := h.replaceMetadata(ctx, bucketName, req.FileKey, "", userMetadata); err != nil {
+			return fmt.Errorf("failed to update metadata of %s: %w", req.FileKey, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	h.invalidate(ctx, req.FileKey)
+	h.replicate(ctx, req.FileKey, false)
+
+	// Stored records keep their fields, only the application metadata changes
+	metadata := applicationMetadata(userMetadata)
+	h.updateRecord(ctx, req.FileKey, func(record *interfaces.FileMetadata) {
+		record.Metadata = metadata
+	})
+	record := objectRecord(objInfo)
+	record.Metadata = metadata
+	if store := h.config().MetadataStore; store != nil {
+		if stored, err := store.Get(ctx, req.FileKey); err == nil {
+			record = stored
+		}
+	}
+
+	h.deliverMetadata(ctx, DeliveryUpdatedCallback, record)
+
+	h.publish(ctx, events.TypeMetadataUpdated, objInfo.UserMetadata["Category"], req.FileKey, req.UserID, map[string]interface{}{
+		"metadata": changes,
+	})
+	return nil
+}
+
+// replaceMetadata rewrites the user metadata of an object with a server-side copy onto itself
+// When etag is set the object must still have it, otherwise the copy fails with PreconditionFailed
+func (h *Handler) replaceMetadata(ctx context.Context, bucketName, fileKey, etag string, userMetadata map[string]string) error {
+	sse, err := h.keyServerSideEncryption(fileKey)
+	if err != nil {
+		return err
+	}
+	source := sse
+	if source != nil && source.Type() != encrypt.SSEC {
+		source = nil
+	}
+
+	return h.retry(ctx, "copy_object", func() error {
+		_, err := h.Client.CopyObject(ctx,
+			minio.CopyDestOptions{
+				Bucket:          bucketName,
+				Object:          fileKey,
+				Encryption:      sse,
+				ReplaceMetadata: true,
+				UserMetadata:    userMetadata,
+			},
+			minio.CopySrcOptions{
+				Bucket:     bucketName,
+				Object:     fileKey,
+				MatchETag:  etag,
+				Encryption: source,
+			},
+		)
+		return err
+	})
+}
+
+// applicationMetadata returns the user metadata set through UpdateMetadata, without reserved keys
+func applicationMetadata(userMetadata map[string]string) map[string]string {
+	metadata := make(map[string]string)
+	for key, value := range userMetadata {
+		if !reservedMetadata[http.CanonicalHeaderKey(key)] {
+			metadata[key] = value
+		}
+	}
+	return metadata
+}
+
+// objectRecord builds the metadata record of a stored file from its object info
+func objectRecord(objInfo *minio.ObjectInfo) *interfaces.FileMetadata {
+	uploadedAt, err := time.Parse(time.RFC3339, objInfo.UserMetadata["Uploaded-At"])
+	if err != nil {
+		uploadedAt = objInfo.LastModified
+	}
+	fileName := objInfo.UserMetadata["Original-Filename"]
+	if fileName == "" {
+		fileName = objInfo.Key[strings.LastIndex(objInfo.Key, "/")+1:]
+	}
+	return &interfaces.FileMetadata{
+		FileName:    fileName,
+		FileKey:     objInfo.Key,
+		FileSize:    middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size),
+		ContentType: objInfo.ContentType,
+		Category:    objInfo.UserMetadata["Category"],
+		EntityType:  objInfo.UserMetadata["Entity-Type"],
+		EntityID:    objInfo.UserMetadata["Entity-Id"],
+		UploadedBy:  objInfo.UserMetadata["Uploaded-By"],
+		UploadedAt:  uploadedAt,
+		Blurhash:    objInfo.UserMetadata["Blurhash"],
+		Audio:       audioInfo(objInfo.UserMetadata),
+		Checksum:    objInfo.UserMetadata["Checksum-Sha256"],
+		Version:     fileVersion(objInfo.UserMetadata),
+		Metadata:    applicationMetadata(objInfo.UserMetadata),
+	}
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/events"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// Approve releases a file held by moderation, the security middleware serves it to everyone
+// allowed to read it from then on. Callers check that UserID is a moderator
+func (h *Handler) Approve(ctx context.Context, req *interfaces.ModerationRequest) error {
+	return h.moderate(ctx, req, middleware.ModerationApproved)
+}
+
+// Reject keeps a file held by moderation from everyone but its uploader and moderators, or deletes
+// it when its category has ModerationConfig.DeleteRejected. Callers check that UserID is a moderator
+func (h *Handler) Reject(ctx context.Context, req *interfaces.ModerationRequest) error {
+	return h.moderate(ctx, req, middleware.ModerationRejected)
+}
+
+// moderate records the decision of a moderator on a file and announces it
+func (h *Handler) moderate(ctx context.Context, req *interfaces.ModerationRequest, status string) error {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	fileInfo, bucketName, err := h.findFile(ctx, req.FileKey)
+	if err != nil {
+		return err
+	}
+	objInfo := fileInfo.(*minio.ObjectInfo)
+	categoryName := h.fileKeyInfo(objInfo).Category
+	categoryConfig, _, _ := h.category(categoryName)
+
+	eventType := events.TypeFileApproved
+	if status == middleware.ModerationRejected {
+		eventType = events.TypeFileRejected
+	}
+	data := map[string]interface{}{"reason": req.Reason}
+
+	if status == middleware.ModerationRejected && categoryConfig.Moderation.DeleteRejected {
+		if err := h.removeObject(ctx, bucketName, req.FileKey, minio.RemoveObjectOptions{}); err != nil {
+			return errors.Wrap(errors.ErrDeleteFailed, err)
+		}
+		if err := h.deleteThumbnails(ctx, categoryName, req.FileKey); err != nil {
+			h.logger.Warn("failed to delete thumbnails", map[string]interface{}{
+				"handler":  h.Name,
+				"file_key": req.FileKey,
+				"error":    err,
+			})
+		}
+		h.deleted(ctx, req.FileKey, req.UserID)
+
+		data["deleted"] = true
+		h.publish(ctx, eventType, categoryName, req.FileKey, req.UserID, data)
+		return nil
+	}
+
+	userMetadata := make(map[string]string, len(objInfo.UserMetadata)+4)
+	for key, value := range objInfo.UserMetadata {
+		userMetadata[key] = value
+	}
+	userMetadata["Moderation-Status"] = status
+	userMetadata["Moderated-By"] = req.UserID
+	userMetadata["Moderated-At"] = time.Now().UTC().Format(time.RFC3339)
+	delete(userMetadata, "Moderation-Reason")
+	if req.Reason != "" {
+		userMetadata["Moderation-Reason"] = req.Reason
+	}
+	userMetadata["Content-Type"] = objInfo.ContentType
+
+	// A version written since the file was read is moderated on its own
+	if err := h.replaceMetadata(ctx, bucketName, req.FileKey, objInfo.ETag, userMetadata); err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			return &errors.StorageError{Code: errors.ErrConflict.Code, Message: "File " + req.FileKey + " was changed", Err: err}
+		}
+		return fmt.Errorf("failed to record moderation of %s: %w", req.FileKey, err)
+	}
+	h.invalidate(ctx, req.FileKey)
+	h.replicate(ctx, req.FileKey, false)
+
+	h.publish(ctx, eventType, categoryName, req.FileKey, req.UserID, data)
+	return nil
+}
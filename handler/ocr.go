@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/darmawan01/storage/search"
+	"github.com/minio/minio-go/v7"
+)
+
+// maxOCRMetadataSize is the number of bytes of recognized text stored as ocr-text metadata,
+// object metadata is limited to 2KB as a whole
+const maxOCRMetadataSize = 1024
+
+// recognizeFile queues the text recognition of a stored file
+func (h *Handler) recognizeFile(ctx context.Context, fileKey string) {
+	if err := h.SubmitJob(ctx, &jobs.Job{Type: jobs.TypeOCR, FileKey: fileKey}); err != nil {
+		h.logger.Warn("failed to queue text recognition", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
+
+// handleOCRJob recognizes the text of a file with the OCR engine of the handler, indexes it when
+// a search index is configured and stores its beginning as metadata when the category asks for it
+// Jobs carry no tenant, the object is read from the bucket the job names
+func (h *Handler) handleOCRJob(ctx context.Context, job *jobs.Job) error {
+	handlerConfig := h.config()
+	if handlerConfig.OCR == nil {
+		return fmt.Errorf("no OCR engine configured")
+	}
+	config := handlerConfig.Search.withDefaults()
+
+	sse, err := h.keyServerSideEncryption(job.FileKey)
+	if err != nil {
+		return err
+	}
+	objInfo, err := h.statObject(ctx, job.BucketName, job.FileKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			// Deleted before its recognition
+			return nil
+		}
+		return fmt.Errorf("failed to stat object: %w", err)
+	}
+	fileSize := middleware.PlaintextSize(objInfo.UserMetadata, objInfo.Size)
+	if fileSize == 0 || fileSize > config.MaxFileSize {
+		job.Result = map[string]interface{}{"recognized": false}
+		return nil
+	}
+
+	// Documents with text of their own, e.g. PDFs with a text layer, are not recognized
+	extractor := search.OCRExtractor{Engine: handlerConfig.OCR, Fallback: config.Extractor}
+	fileData, err := h.openFile(ctx, job.BucketName, &objInfo, objInfo.UserMetadata["Uploaded-By"], 0, fileSize-1)
+	if err != nil {
+		return err
+	}
+	text, err := extractor.Extract(ctx, objInfo.ContentType, fileData)
+	if closer, ok := fileData.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to recognize text: %w", err)
+	}
+	text = truncateText(text, config.MaxTextSize)
+
+	if config.Index != nil {
+		if err := config.Index.Index(ctx, h.searchDocument(&objInfo, text)); err != nil {
+			return err
+		}
+	}
+	job.Result = map[string]interface{}{"recognized": true, "indexed": config.Index != nil, "text_size": len(text)}
+
+	categoryConfig, _, _ := h.category(h.fileKeyInfo(&objInfo).Category)
+	if !categoryConfig.OCR.StoreText {
+		return nil
+	}
+	userMetadata := make(map[string]string, len(objInfo.UserMetadata)+2)
+	for key, value := range objInfo.UserMetadata {
+		userMetadata[key] = value
+	}
+	userMetadata["Ocr-Text"] = ocrMetadata(text)
+	userMetadata["Content-Type"] = objInfo.ContentType
+	if err := h.replaceMetadata(ctx, job.BucketName, job.FileKey, objInfo.ETag, userMetadata); err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			// A newer version is recognized on its own
+			return nil
+		}
+		return fmt.Errorf("failed to record text of %s: %w", job.FileKey, err)
+	}
+	h.invalidate(ctx, job.FileKey)
+	h.replicate(ctx, job.FileKey, false)
+	return nil
+}
+
+// ocrMetadata returns the beginning of a recognized text on a single line, metadata values are
+// sent as headers, so text that is not ASCII is encoded as RFC 2047 words like S3 does
+func ocrMetadata(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	for _, r := range text {
+		if r >= 0x80 {
+			// Encoded bytes take up to four times their size
+			return mime.QEncoding.Encode("utf-8", truncateText(text, maxOCRMetadataSize/4))
+		}
+	}
+	return truncateText(text, maxOCRMetadataSize)
+}
@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/clientinfo"
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/tenant"
+	"github.com/minio/minio-go/v7"
+)
+
+// Option overrides the behavior of a single Upload, Download or Delete call without changing
+// the configuration of the handler
+type Option = interfaces.Option
+
+// WithTimeout limits the duration of the call; for Download it covers reading FileData, which
+// must then be closed
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *interfaces.CallOptions) {
+		o.Timeout = timeout
+	}
+}
+
+// WithBucket uses another bucket than the handler bucket, it must exist
+// Requests of a tenant cannot override their bucket
+func WithBucket(bucketName string) Option {
+	return func(o *interfaces.CallOptions) {
+		o.Bucket = bucketName
+	}
+}
+
+// WithMetadata stores additional user metadata with an uploaded object, ignored by Download and Delete
+// Keys of the metadata the handler sets, e.g. uploaded-by, cannot be overridden
+func WithMetadata(metadata map[string]string) Option {
+	return func(o *interfaces.CallOptions) {
+		if o.Metadata == nil {
+			o.Metadata = make(map[string]string, len(metadata))
+		}
+		for key, value := range metadata {
+			o.Metadata[key] = value
+		}
+	}
+}
+
+// WithDisableThumbnails skips thumbnail generation of an upload, ignored by Download and Delete
+func WithDisableThumbnails() Option {
+	return func(o *interfaces.CallOptions) {
+		o.DisableThumbnails = true
+	}
+}
+
+// WithFileKey stores an upload under a key of the caller instead of a generated one, prefixed
+// with the key prefix of the request's tenant. An existing file is overwritten unless
+// WithIfNotExists or WithIfMatch is given; ignored by Download and Delete
+func WithFileKey(fileKey string) Option {
+	return func(o *interfaces.CallOptions) {
+		o.FileKey = fileKey
+	}
+}
+
+// WithIfNotExists fails an upload with errors.ErrConflict when its key exists, ignored by Download and Delete
+func WithIfNotExists() Option {
+	return func(o *interfaces.CallOptions) {
+		o.IfNotExists = true
+	}
+}
+
+// WithIfMatch fails an upload with errors.ErrConflict unless the file it overwrites has the given
+// ETag, e.g. from GetFileInfo, so concurrent writers do not overwrite each other's changes
+// Ignored by Download and Delete
+func WithIfMatch(etag string) Option {
+	return func(o *interfaces.CallOptions) {
+		o.IfMatch = strings.Trim(etag, `"`)
+	}
+}
+
+// WithStaging stores an upload in the staging area of the handler, see StagingConfig; the file
+// becomes visible under its key with Commit and is discarded with Rollback, e.g. once the database
+// transaction it belongs to is committed or rolled back. Thumbnails are generated on upload;
+// ignored by Download and Delete, replacements cannot be staged
+func WithStaging() Option {
+	return func(o *interfaces.CallOptions) {
+		o.Staged = true
+	}
+}
+
+// WithClient sets the address and user agent of the client, recorded in audit logs and access
+// records and used by per-address limits; it overrides the client of the context, see clientinfo
+func WithClient(ipAddress, userAgent string) Option {
+	return func(o *interfaces.CallOptions) {
+		o.IPAddress = ipAddress
+		o.UserAgent = userAgent
+	}
+}
+
+// callOptions applies options in order
+func callOptions(opts []Option) *interfaces.CallOptions {
+	o := &interfaces.CallOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// bucketKey is the context key of the bucket set by WithBucket
+type bucketKey struct{}
+
+// callContext returns the context of a call with its timeout and bucket
+func callContext(ctx context.Context, o *interfaces.CallOptions) (context.Context, context.CancelFunc) {
+	if o.Bucket != "" {
+		ctx = context.WithValue(ctx, bucketKey{}, o.Bucket)
+	}
+	if o.IPAddress != "" || o.UserAgent != "" {
+		ctx = clientinfo.WithInfo(ctx, clientinfo.Info{IPAddress: o.IPAddress, UserAgent: o.UserAgent})
+	}
+	if o.Timeout > 0 {
+		return context.WithTimeout(ctx, o.Timeout)
+	}
+	return ctx, func() {}
+}
+
+// requestBucket returns the bucket of a request, the bucket of WithBucket or the bucket of its tenant
+func (h *Handler) requestBucket(ctx context.Context, t *tenant.Tenant) (string, error) {
+	bucketName, _ := ctx.Value(bucketKey{}).(string)
+	if bucketName == "" {
+		return h.tenantBucket(t), nil
+	}
+	if t != nil {
+		return "", &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Requests of a tenant cannot override the bucket"}
+	}
+	return bucketName, nil
+}
+
+// checkWriteConditions checks WithIfNotExists and WithIfMatch against the stored file before an
+// upload; the check and the write are not atomic, WithIfMatch is checked by the backend again
+func (h *Handler) checkWriteConditions(ctx context.Context, bucketName, fileKey string, o *interfaces.CallOptions) error {
+	if !o.IfNotExists && o.IfMatch == "" {
+		return nil
+	}
+
+	sse, err := h.keyServerSideEncryption(fileKey)
+	if err != nil {
+		return err
+	}
+	objInfo, err := h.statObject(ctx, bucketName, fileKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+	exists := err == nil
+	if err != nil && minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return fmt.Errorf("failed to check file existence: %w", err)
+	}
+
+	if o.IfNotExists && exists {
+		return &errors.StorageError{Code: errors.ErrConflict.Code, Message: "File " + fileKey + " already exists"}
+	}
+	if o.IfMatch != "" && (!exists || strings.Trim(objInfo.ETag, `"`) != o.IfMatch) {
+		return &errors.StorageError{Code: errors.ErrConflict.Code, Message: "File " + fileKey + " was changed"}
+	}
+	return nil
+}
+
+// timedObject cancels the context of a download once its data is closed
+type timedObject struct {
+	io.Reader
+	cancel context.CancelFunc
+}
+
+// Close closes the data and cancels the download context
+func (o *timedObject) Close() error {
+	defer o.cancel()
+	if closer, ok := o.Reader.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
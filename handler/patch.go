@@ -0,0 +1,28 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// PatchRange overwrites a region of a stored file starting at Offset, e.g. to sync changed blocks
+// of a large binary asset; data past the end extends the file, an Offset past the end is rejected
+// The unchanged ranges and a temporary part holding the data are composed server-side when every
+// range but the last is at least 5 MiB, otherwise the file is rewritten through the handler
+// Patches pass the middlewares of the category as operation "patch" and otherwise behave like Append
+func (h *Handler) PatchRange(ctx context.Context, req *interfaces.PatchRangeRequest, opts ...Option) (*interfaces.PatchRangeResponse, error) {
+	if req.Offset < 0 {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Offset must not be negative"}
+	}
+	fileSize, etag, err := h.writeRange(ctx, "patch", req.FileKey, req.UserID, req.Offset, req.FileData, req.FileSize, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &interfaces.PatchRangeResponse{
+		FileKey:  req.FileKey,
+		FileSize: fileSize,
+		ETag:     etag,
+	}, nil
+}
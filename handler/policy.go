@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// bucketPolicy is an S3 bucket policy; statements are kept as decoded so foreign ones survive updates
+type bucketPolicy struct {
+	Version   string                   `json:"Version"`
+	Statement []map[string]interface{} `json:"Statement"`
+}
+
+// EnsureBuckets creates the buckets of the handler and its categories when missing, in
+// HandlerConfig.Region, and applies the public-read policies of public categories and the expiry
+// rules of anonymous uploads, e.g. after a bucket was removed. Initialize and Reload do the same
+func (h *Handler) EnsureBuckets(ctx context.Context) error {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	config := h.config()
+	for _, bucketName := range append([]string{h.BucketName}, h.DerivedBuckets()...) {
+		if err := h.createBucket(ctx, bucketName, config.Region); err != nil {
+			return err
+		}
+	}
+	if err := h.applyBucketPolicies(ctx, config); err != nil {
+		return err
+	}
+	return h.applyLifecycleRules(ctx, config)
+}
+
+// createBucket creates a bucket in a region if it does not exist yet
+func (h *Handler) createBucket(ctx context.Context, bucketName, region string) error {
+	exists, err := h.Client.BucketExists(ctx, bucketName)
+	if err != nil {
+		return fmt.Errorf("failed to check bucket existence: %w", err)
+	}
+	if exists {
+		return nil
+	}
+	err = h.Client.MakeBucket(ctx, bucketName, minio.MakeBucketOptions{Region: region})
+	if err != nil && minio.ToErrorResponse(err).Code != "BucketAlreadyOwnedByYou" {
+		return fmt.Errorf("failed to create bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// policySid identifies the public-read statement of a handler, handlers share buckets
+func (h *Handler) policySid() string {
+	return "StoragePublicRead-" + h.Name
+}
+
+// applyBucketPolicies grants anonymous read access to the files of public categories, scoped by key
+// pattern to {bucket}/*/*/{category}/* (see GenerateFileKey), which covers their thumbnails as well
+// Statements of other handlers and policies set by others are kept
+func (h *Handler) applyBucketPolicies(ctx context.Context, config *HandlerConfig) error {
+	if config.SkipBucketPolicies {
+		return nil
+	}
+
+	// Every bucket of the handler is updated, so statements of categories no longer public are removed
+	h.configMutex.RLock()
+	resources := map[string][]string{h.BucketName: nil}
+	for name, categoryConfig := range config.Categories {
+		bucketNames := []string{h.BucketName}
+		if derived := h.derivedBucket(categoryConfig); derived != h.BucketName {
+			bucketNames = append(bucketNames, derived)
+		}
+		for _, bucketName := range bucketNames {
+			if categoryConfig.IsPublic {
+				resources[bucketName] = append(resources[bucketName], "arn:aws:s3:::"+bucketName+"/*/*/"+name+"/*")
+			} else if _, exists := resources[bucketName]; !exists {
+				resources[bucketName] = nil
+			}
+		}
+	}
+	h.configMutex.RUnlock()
+
+	for bucketName, bucketResources := range resources {
+		sort.Strings(bucketResources)
+		if err := h.updateBucketPolicy(ctx, bucketName, bucketResources); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateBucketPolicy replaces the handler's statement in the policy of a bucket
+func (h *Handler) updateBucketPolicy(ctx context.Context, bucketName string, resources []string) error {
+	current, err := h.Client.GetBucketPolicy(ctx, bucketName)
+	if err != nil {
+		return fmt.Errorf("failed to get policy of bucket %s: %w", bucketName, err)
+	}
+
+	policy := bucketPolicy{Version: "2012-10-17"}
+	if current != "" {
+		if err := json.Unmarshal([]byte(current), &policy); err != nil {
+			return fmt.Errorf("failed to parse policy of bucket %s: %w", bucketName, err)
+		}
+	}
+
+	sid := h.policySid()
+	statements := make([]map[string]interface{}, 0, len(policy.Statement)+1)
+	for _, statement := range policy.Statement {
+		if statement["Sid"] != sid {
+			statements = append(statements, statement)
+		}
+	}
+	if len(resources) > 0 {
+		statements = append(statements, map[string]interface{}{
+			"Sid":       sid,
+			"Effect":    "Allow",
+			"Principal": map[string]interface{}{"AWS": []string{"*"}},
+			"Action":    []string{"s3:GetObject"},
+			"Resource":  resources,
+		})
+	}
+	if len(statements) == len(policy.Statement) && len(resources) == 0 {
+		// Nothing of the handler's to remove or add
+		return nil
+	}
+
+	updated := ""
+	if len(statements) > 0 {
+		policy.Statement = statements
+		encoded, err := json.Marshal(policy)
+		if err != nil {
+			return fmt.Errorf("failed to encode policy of bucket %s: %w", bucketName, err)
+		}
+		updated = string(encoded)
+	}
+	if err := h.Client.SetBucketPolicy(ctx, bucketName, updated); err != nil {
+		return fmt.Errorf("failed to set policy of bucket %s: %w", bucketName, err)
+	}
+	return nil
+}
+
+// setupBucketPolicies applies the bucket policies of a configuration, failures are only logged
+// since backends blocking public access reject them
+func (h *Handler) setupBucketPolicies(config *HandlerConfig) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := h.applyBucketPolicies(ctx, config); err != nil {
+		h.logger.Warn("failed to apply bucket policies", map[string]interface{}{
+			"handler": h.Name,
+			"error":   err,
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// uploadLimits represents the content types and sizes a category accepts
+type uploadLimits struct {
+	types   []string // Any type when empty
+	minSize int64
+	maxSize int64 // Any size when 0
+}
+
+// categoryUploadLimits returns the limits of uploads of a category, the tightest of the category,
+// its validation and the tenant of the request
+func (h *Handler) categoryUploadLimits(ctx context.Context, categoryName string) (uploadLimits, error) {
+	var limits uploadLimits
+	if categoryConfig, _, exists := h.category(categoryName); exists {
+		limits.types = categoryConfig.Validation.AllowedTypes
+		if len(limits.types) == 0 {
+			limits.types = categoryConfig.AllowedTypes
+		}
+		limits.minSize = categoryConfig.Validation.MinFileSize
+		limits.maxSize = tighter(categoryConfig.MaxSize, categoryConfig.Validation.MaxFileSize)
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return limits, err
+	}
+	if t != nil {
+		limits.maxSize = tighter(limits.maxSize, t.MaxFileSize)
+	}
+	return limits, nil
+}
+
+// tighter returns the smaller of two limits, where 0 is no limit
+func tighter(a, b int64) int64 {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// uploadConstraints returns the content type and size range presigned uploads of a file are limited
+// to, checking those of the request against the category
+func (h *Handler) uploadConstraints(ctx context.Context, req *interfaces.PresignedURLRequest, objInfo *minio.ObjectInfo) (string, int64, int64, error) {
+	if req.Action != "PUT" && req.Action != "POST" {
+		if req.ContentType != "" || req.MaxSize != 0 {
+			return "", 0, 0, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Upload constraints can only be set on PUT and POST URLs"}
+		}
+		return "", 0, 0, nil
+	}
+	// PUT URLs can sign headers, but not a range of sizes
+	if req.Action == "PUT" && req.MaxSize != 0 {
+		return "", 0, 0, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Size limits need POST URLs"}
+	}
+
+	limits, err := h.categoryUploadLimits(ctx, h.fileKeyInfo(objInfo).Category)
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	contentType := req.ContentType
+	if contentType == "" && len(limits.types) > 0 {
+		// Replacements keep the type of the stored file unless asked otherwise
+		contentType = objInfo.ContentType
+	}
+	if len(limits.types) > 0 && !slices.Contains(limits.types, contentType) {
+		return "", 0, 0, &errors.StorageError{Code: errors.ErrUnsupportedType.Code, Message: fmt.Sprintf("Content type %s is not allowed, allowed types: %v", contentType, limits.types)}
+	}
+
+	if req.MaxSize < 0 {
+		return "", 0, 0, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Maximum size cannot be negative"}
+	}
+	maxSize := limits.maxSize
+	if req.MaxSize > 0 && maxSize > 0 && req.MaxSize > maxSize {
+		return "", 0, 0, &errors.StorageError{Code: errors.ErrFileTooLarge.Code, Message: fmt.Sprintf("Maximum size %d exceeds the allowed size %d", req.MaxSize, maxSize)}
+	}
+	if req.MaxSize > 0 {
+		maxSize = req.MaxSize
+	}
+	if maxSize > 0 && limits.minSize > maxSize {
+		return "", 0, 0, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: fmt.Sprintf("Maximum size %d is below the minimum size %d", maxSize, limits.minSize)}
+	}
+	return contentType, limits.minSize, maxSize, nil
+}
+
+// presignPost returns a POST policy upload of a file limited to a content type and size range
+// The policy conditions are checked by the backend, unlike PUT URLs a form cannot send a file of
+// another size or type
+func (h *Handler) presignPost(ctx context.Context, req *interfaces.PresignedURLRequest, bucketName string, sse encrypt.ServerSide, contentType string, minSize, maxSize int64) (*interfaces.PresignedURLResponse, error) {
+	expiresAt := time.Now().Add(req.Expires)
+	policy := minio.NewPostPolicy()
+	if err := policy.SetBucket(bucketName); err != nil {
+		return nil, errors.Wrap(errors.ErrInvalidRequest, err)
+	}
+	if err := policy.SetKey(req.FileKey); err != nil {
+		return nil, errors.Wrap(errors.ErrInvalidRequest, err)
+	}
+	if err := policy.SetExpires(expiresAt.UTC()); err != nil {
+		return nil, errors.Wrap(errors.ErrInvalidRequest, err)
+	}
+	if contentType != "" {
+		if err := policy.SetContentType(contentType); err != nil {
+			return nil, errors.Wrap(errors.ErrInvalidRequest, err)
+		}
+	}
+	if maxSize > 0 {
+		if err := policy.SetContentLengthRange(minSize, maxSize); err != nil {
+			return nil, errors.Wrap(errors.ErrInvalidRequest, err)
+		}
+	}
+	policy.SetEncryption(sse)
+
+	postURL, formData, err := h.Client.PresignedPostPolicy(ctx, policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate presigned POST policy: %w", err)
+	}
+
+	return &interfaces.PresignedURLResponse{
+		Success:   true,
+		URL:       postURL.String(),
+		FormData:  formData,
+		ExpiresAt: expiresAt,
+		Metadata: map[string]interface{}{
+			"file_name":    req.FileKey,
+			"action":       req.Action,
+			"expires_at":   expiresAt,
+			"content_type": contentType,
+			"max_size":     maxSize,
+		},
+	}, nil
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/metadata"
+)
+
+// Query searches the records of the MetadataStore, e.g. for file browsers, without listing buckets
+// Stores implementing interfaces.MetadataQuerier answer the query themselves, others are scanned
+// Requests of a tenant only see its files, and only files the middlewares let req.UserID preview are
+// returned, so pages may hold fewer files than the limit
+func (h *Handler) Query(ctx context.Context, req *interfaces.QueryRequest) (*interfaces.QueryResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	store := h.config().MetadataStore
+	if store == nil {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "No metadata store configured"}
+	}
+	switch req.SortBy {
+	case "", interfaces.SortByUploadedAt, interfaces.SortByFileSize, interfaces.SortByFileName, interfaces.SortByFileKey:
+	default:
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Unsupported sort order " + req.SortBy}
+	}
+
+	query := *req
+	if query.Limit <= 0 {
+		query.Limit = defaultListLimit
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		query.Prefix = t.Key(query.Prefix)
+	}
+
+	var response *interfaces.QueryResponse
+	if querier, ok := store.(interfaces.MetadataQuerier); ok {
+		response, err = querier.Query(ctx, &query)
+	} else {
+		response, err = metadata.Scan(ctx, store, &query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := response.Files[:0]
+	for _, file := range response.Files {
+		listable, err := h.listable(ctx, file.FileKey, req.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to authorize %s: %w", file.FileKey, err)
+		}
+		if listable {
+			files = append(files, file)
+		}
+	}
+	response.Files = files
+	return response, nil
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/darmawan01/storage/category"
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/middleware"
+)
+
+// config returns the current configuration, which is never modified once in use
+func (h *Handler) config() *HandlerConfig {
+	h.configMutex.RLock()
+	defer h.configMutex.RUnlock()
+
+	return h.Config
+}
+
+// category returns the configuration and middleware chain of a category
+func (h *Handler) category(name string) (category.CategoryConfig, *middleware.MiddlewareChain, bool) {
+	h.configMutex.RLock()
+	defer h.configMutex.RUnlock()
+
+	categoryConfig, exists := h.Config.Categories[name]
+	if !exists {
+		return category.CategoryConfig{}, nil, false
+	}
+	chain, exists := h.Middlewares[name]
+	return categoryConfig, chain, exists
+}
+
+// chains returns the middleware chains of all categories
+func (h *Handler) chains() map[string]*middleware.MiddlewareChain {
+	h.configMutex.RLock()
+	defer h.configMutex.RUnlock()
+
+	return h.Middlewares
+}
+
+// Reload replaces the handler configuration and rebuilds the middleware chains of all categories
+// Operations already running finish with the previous chains. Async, Events, Webhooks,
+// DownloadCounter, the rate limiter, the artifact cache and the Scanner job are set up once by Initialize
+// and are not reloaded
+func (h *Handler) Reload(config *HandlerConfig) error {
+	current := h.config()
+	if config.Logger == nil {
+		config.Logger = current.Logger
+	}
+	if config.Tenants == nil {
+		config.Tenants = current.Tenants
+	}
+	if config.Secrets == nil {
+		config.Secrets = current.Secrets
+	}
+	if config.MiddlewareFactories == nil {
+		config.MiddlewareFactories = current.MiddlewareFactories
+	}
+	if config.Scanner == nil {
+		config.Scanner = current.Scanner
+	}
+	if config.Scanner != nil && current.Scanner == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Scanner is set up by Initialize and cannot be added by Reload"}
+	}
+	if config.Classifier == nil {
+		config.Classifier = current.Classifier
+	}
+	if config.Classifier != nil && current.Classifier == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Classifier is set up by Initialize and cannot be added by Reload"}
+	}
+	if config.OCR == nil {
+		config.OCR = current.OCR
+	}
+	if config.OCR != nil && current.OCR == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "OCR is set up by Initialize and cannot be added by Reload"}
+	}
+	if config.Converter == nil {
+		config.Converter = current.Converter
+	}
+	if config.Converter != nil && current.Converter == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Converter is set up by Initialize and cannot be added by Reload"}
+	}
+	if config.AudioAnalyzer == nil {
+		config.AudioAnalyzer = current.AudioAnalyzer
+	}
+	if config.AudioAnalyzer != nil && current.AudioAnalyzer == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "AudioAnalyzer is set up by Initialize and cannot be added by Reload"}
+	}
+	if config.Transcoder == nil {
+		config.Transcoder = current.Transcoder
+	}
+	if config.Transcoder != nil && current.Transcoder == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Transcoder is set up by Initialize and cannot be added by Reload"}
+	}
+	if config.Watermarker == nil {
+		config.Watermarker = current.Watermarker
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	return h.applyConfig(config, nil)
+}
+
+// SetCategory adds a category or replaces its configuration at runtime
+// Only the chain of this category is rebuilt
+func (h *Handler) SetCategory(name string, categoryConfig category.CategoryConfig) error {
+	if err := categoryConfig.Validate(); err != nil {
+		return err
+	}
+	if categoryConfig.Anonymous.Enabled && h.config().Scanner == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " accepts anonymous uploads and requires a Scanner"}
+	}
+	if categoryConfig.Classification.Enabled && h.config().Classifier == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " classifies uploads and requires a Classifier"}
+	}
+	if categoryConfig.OCR.Enabled && h.config().OCR == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " recognizes text and requires an OCR engine"}
+	}
+	if categoryConfig.Preview.ConvertDocuments && h.config().Converter == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " converts documents and requires a Converter"}
+	}
+	if categoryConfig.Audio.Enabled && h.config().AudioAnalyzer == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " analyzes audio and requires an AudioAnalyzer"}
+	}
+	if categoryConfig.Video.Transcode && h.config().Transcoder == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " transcodes videos and requires a Transcoder"}
+	}
+	if categoryConfig.Watermark.Enabled && h.config().Watermarker == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Category " + name + " watermarks files and requires a Watermarker"}
+	}
+
+	config := h.config().withCategories()
+	config.Categories[name] = categoryConfig
+	return h.applyConfig(config, map[string]bool{name: true})
+}
+
+// RemoveCategory removes a category at runtime, its files are kept
+func (h *Handler) RemoveCategory(name string) error {
+	if _, _, exists := h.category(name); !exists {
+		return &errors.StorageError{Code: "CATEGORY_NOT_FOUND", Message: "Category " + name + " not found"}
+	}
+
+	config := h.config().withCategories()
+	delete(config.Categories, name)
+	return h.applyConfig(config, map[string]bool{})
+}
+
+// withCategories returns a shallow copy of the configuration with its own categories map
+func (c *HandlerConfig) withCategories() *HandlerConfig {
+	config := *c
+	config.Categories = make(map[string]category.CategoryConfig, len(c.Categories))
+	for name, categoryConfig := range c.Categories {
+		config.Categories[name] = categoryConfig
+	}
+	return &config
+}
+
+// applyConfig swaps in a configuration with its middleware chains
+// Chains of categories in rebuild are created anew, nil rebuilds all; other chains are kept
+func (h *Handler) applyConfig(config *HandlerConfig, rebuild map[string]bool) error {
+	h.configMutex.Lock()
+
+	previous, previousChains := h.Config, h.Middlewares
+	h.Config = config
+
+	chains := make(map[string]*middleware.MiddlewareChain, len(config.Categories))
+	categories := make(map[string]string, len(config.Categories))
+	for name, categoryConfig := range config.Categories {
+		categories[name] = h.BucketName
+
+		if chain, exists := previousChains[name]; exists && rebuild != nil && !rebuild[name] {
+			chains[name] = chain
+			continue
+		}
+
+		// Derived files may be stored in a separate bucket
+		err := h.ensureBucket(h.derivedBucket(categoryConfig), config.Region)
+		if err == nil {
+			chains[name], err = h.buildMiddlewares(name, categoryConfig)
+			if err != nil {
+				err = fmt.Errorf("failed to setup middlewares for category %s: %w", name, err)
+			}
+		}
+		if err != nil {
+			h.Config = previous
+			h.configMutex.Unlock()
+
+			for built, chain := range chains {
+				if previousChains[built] != chain {
+					stopChain(chain)
+				}
+			}
+			return err
+		}
+	}
+
+	h.Middlewares = chains
+	h.Categories = categories
+	h.updateBatchSlots(config.Batch)
+	h.updateBandwidth(config.Bandwidth)
+	h.configMutex.Unlock()
+
+	// Public and anonymous categories may have changed
+	h.setupBucketPolicies(config)
+	h.setupLifecycleRules(config)
+
+	// Release replaced chains; operations still holding them finish normally
+	for name, chain := range previousChains {
+		if chains[name] != chain {
+			stopChain(chain)
+		}
+	}
+	return nil
+}
+
+// stopChain stops the background work of the middlewares in a chain
+func stopChain(chain *middleware.MiddlewareChain) {
+	for _, m := range chain.Middlewares() {
+		if stopper, ok := m.(interface{ Stop() }); ok {
+			stopper.Stop()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// Replace writes a new version of a stored file under its key, unlike Upload which always stores a
+// new file. The file keeps its category, entity and owner, FileMetadata.Version is increased, its
+// thumbnails are generated again and cached copies are dropped, including CDN copies when the cdn
+// middleware purges on update. The new version passes the middlewares of the category as an upload,
+// the security middleware authorizes it as middleware.ActionReplace against the stored owner
+// WithIfMatch guards against concurrent replaces; WithFileKey and WithIfNotExists are ignored
+func (h *Handler) Replace(ctx context.Context, req *interfaces.ReplaceRequest, opts ...Option) (*interfaces.UploadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	options := callOptions(opts)
+	options.FileKey, options.IfNotExists = "", false
+	ctx, cancel := callContext(ctx, options)
+	defer cancel()
+
+	if req.FileData == nil {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "No file data to replace " + req.FileKey + " with"}
+	}
+
+	fileInfo, _, err := h.findFile(ctx, req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+	previous := fileInfo.(*minio.ObjectInfo)
+	info := h.fileKeyInfo(previous)
+
+	uploadReq := &interfaces.UploadRequest{
+		FileData:    req.FileData,
+		FileSize:    req.FileSize,
+		ContentType: req.ContentType,
+		FileName:    req.FileName,
+		Category:    info.Category,
+		EntityType:  info.EntityType,
+		EntityID:    info.EntityID,
+		UserID:      req.UserID,
+		Metadata:    req.Metadata,
+	}
+	if uploadReq.ContentType == "" {
+		uploadReq.ContentType = previous.ContentType
+	}
+	if uploadReq.FileName == "" {
+		uploadReq.FileName = objectRecord(previous).FileName
+	}
+
+	// Thumbnails of sizes no longer configured would outlive the version they show
+	if err := h.deleteThumbnails(ctx, info.Category, previous.Key); err != nil {
+		h.logger.Warn("failed to delete thumbnails", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": previous.Key,
+			"error":    err,
+		})
+	}
+
+	return h.upload(ctx, uploadReq, options, previous)
+}
+
+// fileVersion returns the version of a stored file, files never replaced are version 1
+func fileVersion(userMetadata map[string]string) int {
+	version, err := strconv.Atoi(userMetadata["Version"])
+	if err != nil || version < 1 {
+		return 1
+	}
+	return version
+}
+
+// purgeCDN purges the CDN copies of a replaced file and its thumbnails when the cdn middleware of its
+// category purges on update; failures are only logged, the copies expire with their TTL
+func (h *Handler) purgeCDN(ctx context.Context, categoryName, bucketName, fileKey string, thumbnails []middleware.ThumbnailInfo) {
+	_, chain, _ := h.category(categoryName)
+	cdn := cdnMiddleware(chain)
+	if cdn == nil || !cdn.IsCDNEnabled() || !cdn.ShouldPurgeOnUpdate() {
+		return
+	}
+
+	config := h.config()
+	h.configMutex.RLock()
+	thumbnailBucket := h.derivedBucket(h.Config.Categories[categoryName])
+	h.configMutex.RUnlock()
+
+	objects := map[string]string{fileKey: bucketName}
+	for _, thumbnail := range thumbnails {
+		objects[middleware.ThumbnailKey(fileKey, thumbnail.Size)] = thumbnailBucket
+	}
+	for key, objectBucket := range objects {
+		objectURL, err := h.directURL(config.URLBuilder, objectBucket, key)
+		if err == nil {
+			err = cdn.PurgeCache(ctx, objectURL)
+		}
+		if err != nil {
+			h.logger.Warn("failed to purge CDN cache", map[string]interface{}{
+				"handler":  h.Name,
+				"file_key": key,
+				"error":    err,
+			})
+		}
+	}
+}
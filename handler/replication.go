@@ -0,0 +1,345 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// ReplicationConfig represents mirroring of files to a secondary backend, e.g. another region or
+// S3 compatible provider. Replication is disabled without a Client and Bucket
+// Files are copied as stored, so encrypted files stay encrypted; thumbnails are not replicated
+// Files of tenants with their own bucket are replicated into the same replica bucket
+type ReplicationConfig struct {
+	// Client of the secondary backend, e.g. created with minio.New
+	Client *minio.Client `json:"-"`
+	// Bucket on the secondary backend, it must exist
+	Bucket string `json:"bucket,omitempty"`
+	// Deletes removes replicas of deleted files, otherwise the replica keeps them
+	Deletes bool `json:"deletes,omitempty"`
+	// Failover serves downloads from the replica while the primary backend is unavailable
+	Failover bool `json:"failover,omitempty"`
+}
+
+// enabled reports whether replication is configured
+func (c ReplicationConfig) enabled() bool {
+	return c.Client != nil && c.Bucket != ""
+}
+
+// ReplicationStats represents the progress of replication, queued jobs are reported with the async stats
+type ReplicationStats struct {
+	Replicated       int64         `json:"replicated"`
+	Deleted          int64         `json:"deleted"`
+	Failed           int64         `json:"failed"`    // Failed attempts, jobs are retried
+	Failovers        int64         `json:"failovers"` // Downloads served from the replica
+	LastLag          time.Duration `json:"last_lag"`  // Time between the change and its replication
+	MaxLag           time.Duration `json:"max_lag"`
+	LastReplicatedAt time.Time     `json:"last_replicated_at,omitempty"`
+}
+
+// replicationTracker counts replication outcomes
+type replicationTracker struct {
+	stats ReplicationStats
+	mutex sync.Mutex
+}
+
+// replicated records a finished replication job
+func (r *replicationTracker) replicated(job *jobs.Job, deleted bool) time.Duration {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if deleted {
+		r.stats.Deleted++
+	} else {
+		r.stats.Replicated++
+	}
+	r.stats.LastReplicatedAt = time.Now()
+	r.stats.LastLag = r.stats.LastReplicatedAt.Sub(job.CreatedAt)
+	r.stats.MaxLag = max(r.stats.MaxLag, r.stats.LastLag)
+	return r.stats.LastLag
+}
+
+// failed records a failed replication attempt
+func (r *replicationTracker) failed() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stats.Failed++
+}
+
+// failedOver records a download served from the replica
+func (r *replicationTracker) failedOver() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.stats.Failovers++
+}
+
+// snapshot returns a copy of the counters
+func (r *replicationTracker) snapshot() ReplicationStats {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.stats
+}
+
+// ReplicationEnabled reports whether the handler replicates files to a secondary backend
+func (h *Handler) ReplicationEnabled() bool {
+	return h.config().Replication.enabled()
+}
+
+// GetReplicationStats returns the replication counters and lag of the handler
+func (h *Handler) GetReplicationStats() ReplicationStats {
+	return h.replication.snapshot()
+}
+
+// replicate queues the replication of a changed or deleted file when replication is enabled
+func (h *Handler) replicate(ctx context.Context, fileKey string, deleted bool) {
+	replication := h.config().Replication
+	if !replication.enabled() || (deleted && !replication.Deletes) {
+		return
+	}
+
+	job := &jobs.Job{Type: jobs.TypeReplicate, FileKey: fileKey}
+	if deleted {
+		job.Payload = map[string]interface{}{"delete": true}
+	}
+	if err := h.SubmitJob(ctx, job); err != nil {
+		h.logger.Warn("failed to queue replication", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
+
+// handleReplicateJob copies a file to the replica, or removes it there for delete jobs
+// Jobs carry no tenant, the object is read from the bucket the job names
+func (h *Handler) handleReplicateJob(ctx context.Context, job *jobs.Job) error {
+	replication := h.config().Replication
+	if !replication.enabled() {
+		return nil
+	}
+
+	if deleted, _ := job.Payload["delete"].(bool); deleted {
+		if err := replication.Client.RemoveObject(ctx, replication.Bucket, job.FileKey, minio.RemoveObjectOptions{}); err != nil {
+			h.replication.failed()
+			return fmt.Errorf("failed to delete replica: %w", err)
+		}
+		lag := h.replication.replicated(job, true)
+		job.Result = map[string]interface{}{"deleted": true, "lag": lag.String()}
+		return nil
+	}
+
+	sse, err := h.keyServerSideEncryption(job.FileKey)
+	if err != nil {
+		return err
+	}
+	object, objInfo, err := h.getObject(ctx, job.BucketName, job.FileKey, minio.GetObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			// Deleted since, a delete job follows when deletes are replicated
+			job.Result = map[string]interface{}{"replicated": false}
+			return nil
+		}
+		h.replication.failed()
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+	defer object.Close()
+
+	opts := minio.PutObjectOptions{
+		ContentType:          objInfo.ContentType,
+		UserMetadata:         objInfo.UserMetadata,
+		ServerSideEncryption: sse,
+	}
+	if objInfo.UserTagCount > 0 {
+		current, err := h.objectTags(ctx, job.BucketName, job.FileKey)
+		if err != nil {
+			h.replication.failed()
+			return err
+		}
+		opts.UserTags = current.ToMap()
+	}
+	if _, err := replication.Client.PutObject(ctx, replication.Bucket, job.FileKey, object, objInfo.Size, opts); err != nil {
+		h.replication.failed()
+		return fmt.Errorf("failed to write replica: %w", err)
+	}
+
+	lag := h.replication.replicated(job, false)
+	job.Result = map[string]interface{}{"replicated": true, "lag": lag.String()}
+	return nil
+}
+
+// failover reports whether a failed read of the primary backend should be served from the replica
+func (h *Handler) failover(err error) bool {
+	replication := h.config().Replication
+	if !replication.enabled() || !replication.Failover {
+		return false
+	}
+	if stderrors.Is(err, errors.ErrBackendUnavailable) {
+		return true
+	}
+	// Backend errors are wrapped by the callers
+	for ; err != nil; err = stderrors.Unwrap(err) {
+		if retryable(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadReplica serves a download from the replica, counted tells whether the download was
+// already counted before the primary failed
+func (h *Handler) downloadReplica(ctx context.Context, req *interfaces.DownloadRequest, counted bool) (*interfaces.DownloadResponse, error) {
+	replication := h.config().Replication
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.checkTenantKey(t, req.FileKey); err != nil {
+		return nil, err
+	}
+	sse, err := h.keyServerSideEncryption(req.FileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := replication.Client.GetObject(ctx, replication.Bucket, req.FileKey, minio.GetObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		return nil, errors.Wrap(errors.ErrDownloadFailed, err)
+	}
+	objInfo, err := object.Stat()
+	if err != nil {
+		object.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, errors.ErrFileNotFound
+		}
+		return nil, errors.Wrap(errors.ErrDownloadFailed, err)
+	}
+
+	if !counted {
+		if err := h.checkTenantLimits(ctx, t, 0); err != nil {
+			object.Close()
+			return nil, err
+		}
+		if err := h.recordDownload(ctx, &objInfo, req.UserID); err != nil {
+			object.Close()
+			return nil, err
+		}
+	}
+
+	h.replication.failedOver()
+	h.logger.Warn("serving download from replica", map[string]interface{}{
+		"handler":  h.Name,
+		"file_key": req.FileKey,
+	})
+
+	fileData, err := h.plainObject(ctx, object, object, &objInfo, req.UserID)
+	if err != nil {
+		return nil, err
+	}
+	resp := h.downloadResponse(ctx, req, &objInfo, fileData)
+	if err := h.watermarkDownload(ctx, req, &objInfo, object, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ReplicaReconcileOptions represents options of a replica reconciliation run
+type ReplicaReconcileOptions struct {
+	Prefix string `json:"prefix,omitempty"` // Limits the run to file keys under it
+	Fix    bool   `json:"fix,omitempty"`    // Queue replication of missing and outdated files, and deletes of extra replicas
+}
+
+// ReplicaReport represents the differences between the files and their replicas
+type ReplicaReport struct {
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	ObjectsScanned int       `json:"objects_scanned"`
+	Missing        []string  `json:"missing"`  // Files without a replica
+	Outdated       []string  `json:"outdated"` // Replicas of a different size or older than their file
+	Extra          []string  `json:"extra"`    // Replicas of deleted files
+	Queued         int       `json:"queued"`   // Jobs queued by Fix
+}
+
+// ReconcileReplica compares the files of the handler with their replicas, e.g. after an outage
+// of the replica or when replication is enabled for existing files, and fixes them on request
+// Requests of a tenant only compare its files
+func (h *Handler) ReconcileReplica(ctx context.Context, opts ReplicaReconcileOptions) (*ReplicaReport, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	replication := h.config().Replication
+	if !replication.enabled() {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "Replication is not configured"}
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix := opts.Prefix
+	if t != nil {
+		prefix = t.Key(prefix)
+	}
+
+	// Stop listings on the first error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	report := &ReplicaReport{StartedAt: time.Now(), Missing: []string{}, Outdated: []string{}, Extra: []string{}}
+	replicas := make(map[string]minio.ObjectInfo)
+	for object := range replication.Client.ListObjects(ctx, replication.Bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list replica bucket %s: %w", replication.Bucket, object.Err)
+		}
+		replicas[object.Key] = object
+	}
+
+	for object := range h.Client.ListObjects(ctx, h.tenantBucket(t), minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list files of %s: %w", prefix, object.Err)
+		}
+		if middleware.ThumbnailOriginalKeys(object.Key) != nil || IsDerivedManifestKey(object.Key) || IsAuditAnchorKey(object.Key) {
+			continue
+		}
+		report.ObjectsScanned++
+
+		replica, exists := replicas[object.Key]
+		delete(replicas, object.Key)
+		switch {
+		case !exists:
+			report.Missing = append(report.Missing, object.Key)
+		case replica.Size != object.Size || replica.LastModified.Before(object.LastModified):
+			report.Outdated = append(report.Outdated, object.Key)
+		default:
+			continue
+		}
+		if opts.Fix {
+			h.replicate(ctx, object.Key, false)
+			report.Queued++
+		}
+	}
+
+	for fileKey := range replicas {
+		report.Extra = append(report.Extra, fileKey)
+	}
+	sort.Strings(report.Extra)
+	if opts.Fix && replication.Deletes {
+		for _, fileKey := range report.Extra {
+			h.replicate(ctx, fileKey, true)
+			report.Queued++
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
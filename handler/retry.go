@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// retry runs op until it succeeds, fails with an error that is not transient, or the
+// attempts of the retry policy are used up; the last error is returned
+func (h *Handler) retry(ctx context.Context, operation string, op func() error) error {
+	policy := h.config().Retry.withDefaults()
+	delay := policy.InitialDelay
+
+	for attempt := 0; ; attempt++ {
+		err := h.guard(operation, op)
+		if err == nil || attempt >= policy.Attempts || !retryable(err) {
+			return err
+		}
+
+		// Equal jitter: half the delay plus a random part of the other half
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		h.logger.Debug("retrying storage operation", map[string]interface{}{
+			"handler":   h.Name,
+			"operation": operation,
+			"attempt":   attempt + 1,
+			"delay":     wait,
+			"error":     err,
+		})
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+
+		delay *= 2
+		if delay > policy.MaxDelay {
+			delay = policy.MaxDelay
+		}
+	}
+}
+
+// retryable reports whether an error is transient: network errors, timeouts, throttling
+// and server errors. Errors of the request itself, e.g. a missing object, are not retried
+func retryable(err error) bool {
+	if stderrors.Is(err, context.Canceled) || stderrors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if stderrors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if stderrors.As(err, &netErr) {
+		return true
+	}
+
+	resp := minio.ToErrorResponse(err)
+	switch resp.Code {
+	case "RequestTimeout", "SlowDown", "SlowDownRead", "SlowDownWrite", "InternalError",
+		"ServiceUnavailable", "OperationTimedOut", "XMinioServerNotInitialized":
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// putObject uploads an object, retrying transient failures when data can be rewound
+// Readers that are not io.Seeker, e.g. request bodies, are uploaded once
+func (h *Handler) putObject(ctx context.Context, bucketName, objectName string, data io.Reader, size int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	seeker, ok := data.(io.Seeker)
+	var start int64
+	if ok {
+		var err error
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			ok = false
+		}
+	}
+	if !ok {
+		return h.Client.PutObject(ctx, bucketName, objectName, h.throttleUpload(ctx, data), size, opts)
+	}
+
+	var info minio.UploadInfo
+	attempted := false
+	err := h.retry(ctx, "put_object", func() error {
+		if attempted {
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		attempted = true
+
+		var err error
+		info, err = h.Client.PutObject(ctx, bucketName, objectName, h.throttleUpload(ctx, data), size, opts)
+		return err
+	})
+	return info, err
+}
+
+// getObject opens an object and returns its info, retrying transient failures
+// Objects are fetched lazily; the first call checks the object, so failures show up here. Whole
+// objects are requested by an empty read and their info taken from the same response, as a Stat
+// first would send a stat of its own; ranged reads keep the stat, which has the size of the object
+func (h *Handler) getObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (*minio.Object, minio.ObjectInfo, error) {
+	var object *minio.Object
+	var objInfo minio.ObjectInfo
+	err := h.retry(ctx, "get_object", func() error {
+		var err error
+		object, err = h.Client.GetObject(ctx, bucketName, objectName, opts)
+		if err != nil {
+			return err
+		}
+		if opts.Header().Get("Range") == "" {
+			_, err = object.Read(nil)
+		}
+		if err == nil {
+			objInfo, err = object.Stat()
+		}
+		if err != nil {
+			object.Close()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, minio.ObjectInfo{}, err
+	}
+	return object, objInfo, nil
+}
+
+// statObject returns the info of an object, retrying transient failures
+func (h *Handler) statObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error) {
+	var objInfo minio.ObjectInfo
+	err := h.retry(ctx, "stat_object", func() error {
+		var err error
+		objInfo, err = h.Client.StatObject(ctx, bucketName, objectName, opts)
+		return err
+	})
+	return objInfo, err
+}
+
+// removeObject removes an object, retrying transient failures
+func (h *Handler) removeObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error {
+	return h.retry(ctx, "remove_object", func() error {
+		return h.Client.RemoveObject(ctx, bucketName, objectName, opts)
+	})
+}
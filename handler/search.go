@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/darmawan01/storage/category"
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/darmawan01/storage/search"
+	"github.com/minio/minio-go/v7"
+)
+
+// defaultSearchLimit is the number of hits returned when a search sets no limit
+const defaultSearchLimit = 20
+
+// searchBatchSize is the number of hits read from the index at a time while they are authorized
+const searchBatchSize = 100
+
+// SearchConfig represents full-text indexing configuration, indexing is disabled without an Index
+type SearchConfig struct {
+	// Index stores the extracted text, e.g. search.NewMemoryIndex or search.NewElasticsearchIndex
+	Index search.Index `json:"-"`
+	// Extractor turns files into text, search.DefaultExtractor when nil
+	Extractor search.Extractor `json:"-"`
+	// MaxFileSize is the size of the largest file indexed, default 50MB
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+	// MaxTextSize is the number of bytes of text indexed per file, default 1MB
+	MaxTextSize int `json:"max_text_size,omitempty"`
+}
+
+// withDefaults returns the configuration with defaults for unset values
+func (c SearchConfig) withDefaults() SearchConfig {
+	if c.Extractor == nil {
+		c.Extractor = search.DefaultExtractor()
+	}
+	if c.MaxFileSize <= 0 {
+		c.MaxFileSize = 50 * 1024 * 1024
+	}
+	if c.MaxTextSize <= 0 {
+		c.MaxTextSize = 1024 * 1024
+	}
+	return c
+}
+
+// Search finds files by the text of their content, most relevant first
+// Only files uploaded while an index was configured are found; requests of a tenant only see its files,
+// and only files the middlewares let req.UserID preview are counted and returned, so every match is
+// authorized before the requested page is returned
+func (h *Handler) Search(ctx context.Context, req *interfaces.SearchRequest) (*interfaces.SearchResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	index := h.config().Search.Index
+	if index == nil {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "No search index configured"}
+	}
+	if req.Query == "" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Search query is required"}
+	}
+
+	limit := req.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	offset := max(req.Offset, 0)
+	query := *req
+	query.Namespace = h.Name
+	query.Offset = 0
+	query.Limit = searchBatchSize
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		query.Prefix = t.Key(query.Prefix)
+	}
+
+	response := &interfaces.SearchResponse{Success: true, Hits: []interfaces.SearchHit{}}
+	for {
+		batch, err := index.Search(ctx, &query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to search files: %w", err)
+		}
+		for _, hit := range batch.Hits {
+			listable, err := h.listable(ctx, hit.FileKey, req.UserID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to authorize %s: %w", hit.FileKey, err)
+			}
+			if !listable {
+				continue
+			}
+			response.Total++
+			if response.Total > offset && len(response.Hits) < limit {
+				response.Hits = append(response.Hits, hit)
+			}
+		}
+		query.Offset += len(batch.Hits)
+		if len(batch.Hits) < query.Limit || query.Offset >= batch.Total {
+			return response, nil
+		}
+	}
+}
+
+// indexFile queues the text extraction of an uploaded file when indexing is enabled, files the OCR
+// engine supports are recognized instead when their category has OCR
+func (h *Handler) indexFile(ctx context.Context, ocr category.OCRConfig, fileKey, contentType string, fileSize int64) {
+	handlerConfig := h.config()
+	config := handlerConfig.Search.withDefaults()
+	if fileSize > config.MaxFileSize {
+		return
+	}
+	if ocr.Enabled && handlerConfig.OCR != nil && handlerConfig.OCR.Supports(contentType) {
+		h.recognizeFile(ctx, fileKey)
+		return
+	}
+	if config.Index == nil || !config.Extractor.Supports(contentType) {
+		return
+	}
+
+	if err := h.SubmitJob(ctx, &jobs.Job{Type: jobs.TypeIndex, FileKey: fileKey}); err != nil {
+		h.logger.Warn("failed to queue search indexing", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
+
+// unindexFile removes a deleted file from the search index
+func (h *Handler) unindexFile(ctx context.Context, fileKey string) {
+	index := h.config().Search.Index
+	if index == nil {
+		return
+	}
+	if err := index.Delete(ctx, fileKey); err != nil {
+		h.logger.Warn("failed to remove file from search index", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
+
+// handleIndexJob extracts the text of a file and stores it in the search index
+// Jobs carry no tenant, the object is read from the bucket the job names
+func (h *Handler) handleIndexJob(ctx context.Context, job *jobs.Job) error {
+	config := h.config().Search.withDefaults()
+	if config.Index == nil {
+		return nil
+	}
+
+	sse, err := h.keyServerSideEncryption(job.FileKey)
+	if err != nil {
+		return err
+	}
+	objInfo, err := h.statObject(ctx, job.BucketName, job.FileKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		return fmt.Errorf("failed to stat object: %w", err)
+	}
+	fileSize := middleware.PlaintextSize(objInfo.UserMetadata, objInfo.Size)
+	if fileSize == 0 || fileSize > config.MaxFileSize || !config.Extractor.Supports(objInfo.ContentType) {
+		job.Result = map[string]interface{}{"indexed": false}
+		return nil
+	}
+
+	fileData, err := h.openFile(ctx, job.BucketName, &objInfo, objInfo.UserMetadata["Uploaded-By"], 0, fileSize-1)
+	if err != nil {
+		return err
+	}
+	if closer, ok := fileData.(io.Closer); ok {
+		defer closer.Close()
+	}
+	text, err := config.Extractor.Extract(ctx, objInfo.ContentType, fileData)
+	if err != nil {
+		return fmt.Errorf("failed to extract text: %w", err)
+	}
+	text = truncateText(text, config.MaxTextSize)
+
+	if err := config.Index.Index(ctx, h.searchDocument(&objInfo, text)); err != nil {
+		return err
+	}
+
+	job.Result = map[string]interface{}{"indexed": true, "text_size": len(text)}
+	return nil
+}
+
+// searchDocument returns the search document of a stored file with its text
+func (h *Handler) searchDocument(objInfo *minio.ObjectInfo, text string) *search.Document {
+	return &search.Document{
+		FileKey:     objInfo.Key,
+		FileName:    objInfo.UserMetadata["Original-Filename"],
+		ContentType: objInfo.ContentType,
+		Namespace:   h.Name,
+		Category:    objInfo.UserMetadata["Category"],
+		EntityType:  objInfo.UserMetadata["Entity-Type"],
+		EntityID:    objInfo.UserMetadata["Entity-Id"],
+		Text:        text,
+	}
+}
+
+// truncateText cuts a text to at most size bytes without splitting a character
+func truncateText(text string, size int) string {
+	if len(text) <= size {
+		return text
+	}
+	for size > 0 && !utf8.RuneStart(text[size]) {
+		size--
+	}
+	return text[:size]
+}
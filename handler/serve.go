@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/auth"
+	"github.com/darmawan01/storage/clientinfo"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// ServeFile writes a file to an HTTP response, for download and streaming endpoints
+// It sets Content-Disposition with the original filename, ETag and Last-Modified, answers
+// If-None-Match and If-Modified-Since with 304 Not Modified and Range requests with
+// 206 Partial Content (416 when the range cannot be satisfied). HEAD requests get the headers only
+// Downloads of whole files count against the download limits, like Download; ranges do not, like Stream.
+// Responses without data (304, 416 and HEAD) are authorized like streams before they are written
+// The user is the one set by auth.Authenticator, the client the one of clientinfo.FromRequest. Failures such as FILE_NOT_FOUND or ACCESS_DENIED
+// are returned before anything is written, so callers can write their own error response
+// A Content-Disposition header set by the caller is kept, e.g. to serve a file inline
+func (h *Handler) ServeFile(w http.ResponseWriter, r *http.Request, fileKey string) error {
+	ctx, done, err := h.track(clientinfo.WithInfo(r.Context(), clientinfo.FromRequest(r)))
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	fileInfo, bucketName, err := h.findFile(ctx, fileKey)
+	if err != nil {
+		return err
+	}
+	objInfo := fileInfo.(*minio.ObjectInfo)
+
+	fileSize := middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size)
+	etag := entityTag(objInfo.ETag)
+	modified := objInfo.LastModified.UTC().Truncate(time.Second)
+
+	// Headers tell the file exists and its size, so the user must be allowed to read it first
+	userID := auth.UserID(r.Context())
+	authorize := func() error {
+		return h.authorizeRead(ctx, objInfo, bucketName, userID)
+	}
+
+	header := w.Header()
+	if notModified(r, etag, modified) {
+		if err := authorize(); err != nil {
+			return err
+		}
+		header.Set("ETag", etag)
+		header.Set("Last-Modified", modified.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	contentType := objInfo.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	// Watermarked files are marked whole, so they are always sent whole
+	mark, marked := h.fileWatermark("download", objInfo, objInfo.ContentType)
+
+	start, end := int64(0), fileSize-1
+	ranged := !marked && r.Header.Get("Range") != "" && fileSize > 0 && rangeApplies(r, etag, modified)
+	if ranged {
+		start, end, err = h.parseRangeHeader(r.Header.Get("Range"), fileSize)
+		if err != nil {
+			if err := authorize(); err != nil {
+				return err
+			}
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", fileSize))
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return nil
+		}
+	}
+
+	var fileData io.Reader
+	if r.Method == http.MethodHead {
+		if err := authorize(); err != nil {
+			return err
+		}
+	} else {
+		// Tenant request limits apply to downloads as well
+		t, err := h.tenant(ctx)
+		if err != nil {
+			return err
+		}
+		if err := h.checkTenantLimits(ctx, t, 0); err != nil {
+			return err
+		}
+
+		// A range covering the whole file is a download of it, counted like one
+		whole := start == 0 && end == fileSize-1
+		operation := "download"
+		if !whole {
+			operation = "stream"
+		}
+		chainReq := h.chainRequest(operation, objInfo, bucketName, userID)
+		err = h.runChain(ctx, chainReq, func(ctx context.Context) error {
+			if whole {
+				if err := h.countDownload(ctx, chainReq); err != nil {
+					return err
+				}
+			}
+			fileData, err = h.openFile(ctx, bucketName, objInfo, userID, start, end)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if closer, ok := fileData.(io.Closer); ok {
+			defer closer.Close()
+		}
+		if marked {
+			data, markedType, err := h.watermarkFile(ctx, mark, fileKey, userID, objInfo.ContentType, fileData)
+			if err != nil {
+				return err
+			}
+			fileData, fileSize, contentType = bytes.NewReader(data), int64(len(data)), markedType
+		}
+		h.recordAccess(ctx, &interfaces.AccessRecord{
+			FileKey:   fileKey,
+			UserID:    userID,
+			Operation: interfaces.AccessDownload,
+			Start:     start,
+			End:       end,
+		})
+	}
+
+	header.Set("Content-Type", contentType)
+	header.Set("ETag", etag)
+	header.Set("Last-Modified", modified.Format(http.TimeFormat))
+	header.Set("Accept-Ranges", "bytes")
+	if marked {
+		// Every user gets a copy of their own, shared caches must not keep it
+		header.Set("Accept-Ranges", "none")
+		header.Set("Cache-Control", "private")
+	}
+	if header.Get("Content-Disposition") == "" {
+		header.Set("Content-Disposition", contentDisposition(objInfo.UserMetadata["Original-Filename"], fileKey))
+	}
+
+	status := http.StatusOK
+	length := fileSize
+	if ranged {
+		status = http.StatusPartialContent
+		length = end - start + 1
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, fileSize))
+	}
+	// The size of a watermarked copy is only known once it is drawn, which HEAD requests skip
+	if !marked || fileData != nil {
+		header.Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	w.WriteHeader(status)
+
+	// The response is under way, a failed copy can only cut it short
+	if fileData != nil {
+		_, _ = io.CopyN(w, fileData, length)
+	}
+	return nil
+}
+
+// notModified reports whether the client's copy of a file is current
+// If-None-Match takes precedence over If-Modified-Since
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	since, _ := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return unchanged(r.Header.Get("If-None-Match"), since, etag, modified)
+}
+
+// unchanged reports whether a copy with the validators ifNoneMatch and ifModifiedSince is current,
+// unset validators are zero. If-None-Match takes precedence over If-Modified-Since
+func unchanged(ifNoneMatch string, ifModifiedSince time.Time, etag string, modified time.Time) bool {
+	if ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || entityTag(strings.TrimPrefix(candidate, "W/")) == etag {
+				return true
+			}
+		}
+		return false
+	}
+	// HTTP dates have a resolution of seconds
+	return !ifModifiedSince.IsZero() && !modified.UTC().Truncate(time.Second).After(ifModifiedSince)
+}
+
+// entityTag returns the quoted ETag of an object as sent in HTTP headers
+func entityTag(etag string) string {
+	return `"` + strings.Trim(etag, `"`) + `"`
+}
+
+// rangeApplies reports whether a Range request is served as a range, which If-Range limits
+// to unchanged files; changed files are sent whole
+func rangeApplies(r *http.Request, etag string, modified time.Time) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+	date, err := http.ParseTime(ifRange)
+	return err == nil && modified.Equal(date)
+}
+
+// contentDisposition returns an attachment disposition with the original filename, or the
+// last part of the key for files stored without one
+func contentDisposition(filename, fileKey string) string {
+	if filename != "" {
+		if disposition := mime.FormatMediaType("attachment", map[string]string{"filename": filename}); disposition != "" {
+			return disposition
+		}
+	}
+	return mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(fileKey)})
+}
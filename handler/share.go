@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/google/uuid"
+)
+
+// Share grants a user or group (interfaces.GroupGrantee) access to a file, or to all files under
+// a key prefix ending with "/", e.g. "user/123/" for the files of an entity; the security
+// middleware lets the grantee read, or with write permission also replace, what the authorizer
+// denies. expiry is the lifetime of the share, 0 for a share until Unshare. The user of the
+// context is recorded as the granter, deciding who may share is left to the application
+func (h *Handler) Share(ctx context.Context, target, grantee, permission string, expiry time.Duration) (*interfaces.Share, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	store := h.config().Shares
+	if store == nil {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "No share store configured"}
+	}
+	if grantee == "" || grantee == interfaces.GroupGrantee("") {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Grantee is required"}
+	}
+	if permission != interfaces.ShareRead && permission != interfaces.ShareWrite {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Unknown share permission " + permission}
+	}
+	if expiry < 0 {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Share expiry must be non-negative"}
+	}
+
+	if strings.HasSuffix(target, "/") {
+		if target, err = h.archivePrefix(ctx, target); err != nil {
+			return nil, err
+		}
+	} else if _, _, err := h.findFile(ctx, target); err != nil {
+		return nil, err
+	}
+
+	share := &interfaces.Share{
+		ID:         "share_" + uuid.NewString(),
+		Target:     target,
+		Grantee:    grantee,
+		Permission: permission,
+		CreatedAt:  time.Now(),
+	}
+	if user, ok := middleware.UserFromContext(ctx); ok {
+		share.GrantedBy = user.ID
+	}
+	if expiry > 0 {
+		share.ExpiresAt = share.CreatedAt.Add(expiry)
+	}
+	if err := store.Save(ctx, share); err != nil {
+		return nil, fmt.Errorf("failed to save share: %w", err)
+	}
+	return share, nil
+}
+
+// Unshare revokes a share; requests of a tenant only revoke shares of its files
+func (h *Handler) Unshare(ctx context.Context, shareID string) error {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	store := h.config().Shares
+	if store == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "No share store configured"}
+	}
+	share, err := store.Get(ctx, shareID)
+	if err != nil {
+		return err
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	if err := h.checkTenantKey(t, share.Target); err != nil {
+		return errors.ErrShareNotFound
+	}
+	if err := store.Delete(ctx, shareID); err != nil {
+		return fmt.Errorf("failed to delete share: %w", err)
+	}
+	return nil
+}
+
+// ListShares returns the shares matching a query that have not expired, oldest first, e.g. the
+// shares of a file or those of a grantee; requests of a tenant only see shares of its files
+func (h *Handler) ListShares(ctx context.Context, query interfaces.ShareQuery) ([]interfaces.Share, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	store := h.config().Shares
+	if store == nil {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "No share store configured"}
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	shares, err := store.List(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list shares: %w", err)
+	}
+	now := time.Now()
+	kept := shares[:0]
+	for _, share := range shares {
+		if !share.Expired(now) && h.checkTenantKey(t, share.Target) == nil {
+			kept = append(kept, share)
+		}
+	}
+	return kept, nil
+}
+
+// sharedAccess returns the highest permission the shares of a file give a user, see
+// SecurityConfig.SharedAccess; the roles of the user are its groups
+func (h *Handler) sharedAccess(ctx context.Context, user *middleware.User, fileKey string) (string, error) {
+	store := h.config().Shares
+	if store == nil {
+		return "", nil
+	}
+	shares, err := store.List(ctx, interfaces.ShareQuery{FileKey: fileKey})
+	if err != nil {
+		return "", err
+	}
+	permission := ""
+	now := time.Now()
+	for _, share := range shares {
+		if share.Expired(now) || !share.Grants(user.ID, user.Roles) {
+			continue
+		}
+		if share.Permission == interfaces.ShareWrite {
+			return interfaces.ShareWrite, nil
+		}
+		permission = share.Permission
+	}
+	return permission, nil
+}
+
+// forgetShares removes the shares of a deleted file, those of prefixes stay for later files
+func (h *Handler) forgetShares(ctx context.Context, fileKey string) {
+	store := h.config().Shares
+	if store == nil {
+		return
+	}
+	shares, err := store.List(ctx, interfaces.ShareQuery{FileKey: fileKey})
+	for _, share := range shares {
+		if err != nil {
+			break
+		}
+		if share.Target == fileKey {
+			err = store.Delete(ctx, share.ID)
+		}
+	}
+	if err != nil {
+		h.logger.Warn("failed to delete shares", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
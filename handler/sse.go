@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/darmawan01/storage/category"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// sseConfig returns the server-side encryption settings of a category
+func (h *Handler) sseConfig(categoryName string) category.SSEConfig {
+	config := h.config()
+	sseConfig := config.ServerSideEncryption
+	if categoryConfig, exists := config.Categories[categoryName]; exists && categoryConfig.ServerSideEncryption.Enabled() {
+		sseConfig = categoryConfig.ServerSideEncryption
+	}
+	if sseConfig.Secrets == nil {
+		sseConfig.Secrets = config.Secrets
+	}
+	return sseConfig
+}
+
+// serverSideEncryption returns the MinIO encryption options of a category, nil when disabled
+func (h *Handler) serverSideEncryption(categoryName string) (encrypt.ServerSide, error) {
+	return h.sseConfig(categoryName).ServerSide()
+}
+
+// keyServerSideEncryption returns the encryption options of an existing file
+// GET and HEAD requests only send them for SSE-C, where the key is required to read the object
+func (h *Handler) keyServerSideEncryption(fileKey string) (encrypt.ServerSide, error) {
+	return h.serverSideEncryption(categoryFromKey(fileKey))
+}
+
+// categoryFromKey returns the category of a key generated by GenerateFileKey
+// (entityType/entityID/category/file), or "" for other keys
+func categoryFromKey(fileKey string) string {
+	parts := strings.Split(fileKey, "/")
+	if len(parts) < 4 {
+		return ""
+	}
+	return parts[len(parts)-2]
+}
+
+// encryptionHeaders returns the headers clients must send to use a presigned URL
+func encryptionHeaders(sse encrypt.ServerSide, method string) http.Header {
+	headers := make(http.Header)
+	if sse == nil {
+		return headers
+	}
+	// Reads only need headers for SSE-C; writes need them to request encryption
+	if method == http.MethodPut || sse.Type() == encrypt.SSEC {
+		sse.Marshal(headers)
+	}
+	return headers
+}
+
+// flattenHeaders converts headers to a simple map for responses
+func flattenHeaders(headers http.Header) map[string]string {
+	if len(headers) == 0 {
+		return nil
+	}
+	flat := make(map[string]string, len(headers))
+	for key := range headers {
+		flat[key] = headers.Get(key)
+	}
+	return flat
+}
+
+// RotateServerSideEncryption re-encrypts a file with the current settings of its category
+// previous describes how the file is encrypted now, e.g. the old SSE-C key or KMS key ID.
+// The object is copied in place, so its metadata is preserved
+func (h *Handler) RotateServerSideEncryption(ctx context.Context, fileKey string, previous category.SSEConfig) error {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return err
+	}
+	if err := h.checkTenantKey(t, fileKey); err != nil {
+		return err
+	}
+	bucketName := h.tenantBucket(t)
+
+	source, err := previous.ServerSide()
+	if err != nil {
+		return err
+	}
+	// Only SSE-C sources need their key to be read
+	if source != nil && source.Type() != encrypt.SSEC {
+		source = nil
+	}
+	destination, err := h.keyServerSideEncryption(fileKey)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.Client.CopyObject(ctx,
+		minio.CopyDestOptions{
+			Bucket:     bucketName,
+			Object:     fileKey,
+			Encryption: destination,
+		},
+		minio.CopySrcOptions{
+			Bucket:     bucketName,
+			Object:     fileKey,
+			Encryption: source,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to rotate encryption of %s: %w", fileKey, err)
+	}
+	h.invalidate(ctx, fileKey)
+	return nil
+}
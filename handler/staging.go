@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+)
+
+// stagedThumbnailsMetadataKey is the metadata key of the thumbnails of a staged file, restored by Commit
+const stagedThumbnailsMetadataKey = "staged-thumbnails"
+
+// StagingConfig represents the staging area of uploads made WithStaging
+type StagingConfig struct {
+	Enabled bool   `json:"enabled"`
+	Prefix  string `json:"prefix,omitempty"` // Key prefix of staged files, default "staging/"
+	// ExpiryDays is the age after which files never committed or rolled back are deleted by a
+	// bucket lifecycle rule, default 1
+	ExpiryDays int `json:"expiry_days,omitempty"`
+}
+
+// withDefaults returns the configuration with defaults for unset values
+func (c StagingConfig) withDefaults() StagingConfig {
+	if c.Prefix == "" {
+		c.Prefix = "staging/"
+	}
+	if c.ExpiryDays <= 0 {
+		c.ExpiryDays = 1
+	}
+	return c
+}
+
+// StagingPrefix returns the key prefix of staged files, empty when staging is disabled
+func (h *Handler) StagingPrefix() string {
+	config := h.config().Staging
+	if !config.Enabled {
+		return ""
+	}
+	return config.withDefaults().Prefix
+}
+
+// stage prepares the options of a staged upload and returns the key it is stored under
+// Staged files are tagged to expire, their thumbnails are kept in metadata until Commit
+func (h *Handler) stage(fileKey string, opts *minio.PutObjectOptions, thumbnails []middleware.ThumbnailInfo) (string, error) {
+	config := h.config().Staging
+	if !config.Enabled {
+		return "", &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Staging is not enabled for handler " + h.Name}
+	}
+	config = config.withDefaults()
+
+	userTags := make(map[string]string, len(opts.UserTags)+1)
+	for key, value := range opts.UserTags {
+		userTags[key] = value
+	}
+	userTags[expiryTag] = strconv.Itoa(config.ExpiryDays)
+	opts.UserTags = userTags
+
+	if len(thumbnails) > 0 {
+		encoded, err := json.Marshal(thumbnails)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode thumbnails: %w", err)
+		}
+		opts.UserMetadata[stagedThumbnailsMetadataKey] = string(encoded)
+	}
+	return config.Prefix + fileKey, nil
+}
+
+// Commit moves a file uploaded WithStaging to its key, where it becomes visible, and runs what
+// Upload runs for other files: background jobs, the metadata store, MetadataCallback and the
+// upload event. Commit once the transaction the upload belongs to is committed, Rollback otherwise
+func (h *Handler) Commit(ctx context.Context, fileKey string) (*interfaces.UploadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	bucketName, stagedKey, objInfo, err := h.stagedFile(ctx, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	sse, err := h.keyServerSideEncryption(fileKey)
+	if err != nil {
+		return nil, err
+	}
+	source := sse
+	if source != nil && source.Type() != encrypt.SSEC {
+		source = nil
+	}
+
+	req := &interfaces.UploadRequest{
+		FileSize:    objInfo.Size,
+		ContentType: objInfo.ContentType,
+		FileName:    objInfo.UserMetadata["Original-Filename"],
+		Category:    objInfo.UserMetadata["Category"],
+		EntityType:  objInfo.UserMetadata["Entity-Type"],
+		EntityID:    objInfo.UserMetadata["Entity-Id"],
+		UserID:      objInfo.UserMetadata["Uploaded-By"],
+	}
+	var thumbnails []middleware.ThumbnailInfo
+	if encoded := objInfo.UserMetadata["Staged-Thumbnails"]; encoded != "" {
+		if err := json.Unmarshal([]byte(encoded), &thumbnails); err != nil {
+			return nil, fmt.Errorf("failed to decode thumbnails of %s: %w", fileKey, err)
+		}
+	}
+
+	userMetadata := make(map[string]string, len(objInfo.UserMetadata)+1)
+	lowerMetadata := make(map[string]string, len(objInfo.UserMetadata))
+	for key, value := range objInfo.UserMetadata {
+		if key == "Staged-Thumbnails" {
+			continue
+		}
+		userMetadata[key] = value
+		lowerMetadata[strings.ToLower(key)] = value
+	}
+	userMetadata["Content-Type"] = objInfo.ContentType
+	// The tags of the file replace the expiry of the staging area
+	categoryConfig, _, _ := h.category(req.Category)
+	userTags := anonymousUpload(categoryConfig.Anonymous, req.UserID, make(map[string]string), categoryConfig.DefaultTags)
+
+	err = h.retry(ctx, "copy_object", func() error {
+		_, err := h.Client.ComposeObject(ctx,
+			minio.CopyDestOptions{
+				Bucket:          bucketName,
+				Object:          fileKey,
+				Encryption:      sse,
+				UserMetadata:    userMetadata,
+				ReplaceMetadata: true,
+				UserTags:        userTags,
+				ReplaceTags:     true,
+			},
+			minio.CopySrcOptions{
+				Bucket:     bucketName,
+				Object:     stagedKey,
+				MatchETag:  objInfo.ETag,
+				Encryption: source,
+			},
+		)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(errors.ErrUploadFailed, err)
+	}
+	if err := h.removeObject(ctx, bucketName, stagedKey, minio.RemoveObjectOptions{}); err != nil {
+		// The staged copy expires with the staging area
+		h.logger.Warn("failed to remove staged file", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+
+	return h.finishUpload(ctx, &storedUpload{
+		req:          req,
+		bucketName:   bucketName,
+		fileKey:      fileKey,
+		thumbnails:   thumbnails,
+		userMetadata: lowerMetadata,
+		userTags:     userTags,
+		version:      1,
+		blurhash:     lowerMetadata[middleware.BlurHashMetadataKey],
+	})
+}
+
+// Rollback deletes a file uploaded WithStaging and its thumbnails, nothing of it was visible
+func (h *Handler) Rollback(ctx context.Context, fileKey string) error {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	bucketName, stagedKey, objInfo, err := h.stagedFile(ctx, fileKey)
+	if err != nil {
+		return err
+	}
+	if err := h.removeObject(ctx, bucketName, stagedKey, minio.RemoveObjectOptions{}); err != nil {
+		return errors.Wrap(errors.ErrDeleteFailed, err)
+	}
+	if objInfo.UserMetadata["Staged-Thumbnails"] != "" {
+		if err := h.deleteThumbnails(ctx, objInfo.UserMetadata["Category"], fileKey); err != nil {
+			h.logger.Warn("failed to delete thumbnails", map[string]interface{}{
+				"handler":  h.Name,
+				"file_key": fileKey,
+				"error":    err,
+			})
+		}
+	}
+	return nil
+}
+
+// stagedFile returns the bucket, staged key and stat result of a file uploaded WithStaging
+func (h *Handler) stagedFile(ctx context.Context, fileKey string) (string, string, *minio.ObjectInfo, error) {
+	prefix := h.StagingPrefix()
+	if prefix == "" {
+		return "", "", nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Staging is not enabled for handler " + h.Name}
+	}
+	bucketName, err := h.locateFile(ctx, fileKey)
+	if err != nil {
+		return "", "", nil, err
+	}
+	sse, err := h.keyServerSideEncryption(fileKey)
+	if err != nil {
+		return "", "", nil, err
+	}
+	stagedKey := prefix + fileKey
+	objInfo, err := h.statObject(ctx, bucketName, stagedKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return "", "", nil, errors.ErrFileNotFound
+		}
+		return "", "", nil, fmt.Errorf("failed to check staged file: %w", err)
+	}
+	return bucketName, stagedKey, &objInfo, nil
+}
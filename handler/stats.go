@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// DefaultStatsCacheTTL is how long storage statistics are cached by default
+const DefaultStatsCacheTTL = 5 * time.Minute
+
+// StorageStatsOptions represents storage statistics options
+type StorageStatsOptions struct {
+	Prefix   string `json:"prefix,omitempty"`    // Only count files under this key prefix, e.g. an entity type
+	ByEntity bool   `json:"by_entity,omitempty"` // Break usage down per entity, which can be large
+	Refresh  bool   `json:"refresh,omitempty"`   // Bypass the cache
+}
+
+// StorageStats represents the storage used by the files of a handler
+// Files are attributed to categories and entities by their key, see GenerateFileKey
+type StorageStats struct {
+	TotalBytes   int64 `json:"total_bytes"`
+	TotalObjects int   `json:"total_objects"`
+	// Derived files such as thumbnails, not included in the totals
+	DerivedBytes   int64                 `json:"derived_bytes"`
+	DerivedObjects int                   `json:"derived_objects"`
+	Categories     map[string]UsageStats `json:"categories"`
+	Entities       map[string]UsageStats `json:"entities,omitempty"` // Keyed by "entity_type/entity_id"
+	ComputedAt     time.Time             `json:"computed_at"`
+}
+
+// UsageStats represents the storage used by a group of files
+type UsageStats struct {
+	Bytes   int64 `json:"bytes"`
+	Objects int   `json:"objects"`
+}
+
+// statsCache keeps computed storage statistics per tenant and options
+type statsCache struct {
+	entries map[string]*StorageStats
+	mutex   sync.Mutex
+}
+
+// GetStorageStats returns the number and size of the handler's files, in total and per category
+// and optionally per entity. Statistics are computed by listing the buckets and cached for
+// HandlerConfig.StatsCacheTTL, so dashboards can poll them without MinIO admin access
+// Requests of a tenant only see the files of their tenant
+func (h *Handler) GetStorageStats(ctx context.Context, opts StorageStatsOptions) (*StorageStats, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	prefix := opts.Prefix
+	cacheKey := fmt.Sprintf("%s|%t", prefix, opts.ByEntity)
+	if t != nil {
+		prefix = t.Key(prefix)
+		cacheKey = t.ID + "|" + cacheKey
+	}
+
+	config := h.config()
+	ttl := config.StatsCacheTTL
+	if ttl == 0 {
+		ttl = DefaultStatsCacheTTL
+	}
+
+	h.stats.mutex.Lock()
+	cached := h.stats.entries[cacheKey]
+	h.stats.mutex.Unlock()
+	if cached != nil && !opts.Refresh && time.Since(cached.ComputedAt) < ttl {
+		return cached, nil
+	}
+
+	stats := &StorageStats{
+		Categories: make(map[string]UsageStats),
+	}
+	if opts.ByEntity {
+		stats.Entities = make(map[string]UsageStats)
+	}
+
+	originals := h.tenantBucket(t)
+	if err := h.countBucket(ctx, originals, prefix, t == nil, config, stats); err != nil {
+		return nil, err
+	}
+	for _, bucketName := range h.DerivedBuckets() {
+		if bucketName == originals {
+			continue
+		}
+		if err := h.countDerived(ctx, bucketName, prefix, t == nil, config, stats); err != nil {
+			return nil, err
+		}
+	}
+	stats.ComputedAt = time.Now()
+
+	if ttl > 0 {
+		h.stats.mutex.Lock()
+		if h.stats.entries == nil {
+			h.stats.entries = make(map[string]*StorageStats)
+		}
+		h.stats.entries[cacheKey] = stats
+		h.stats.mutex.Unlock()
+	}
+	return stats, nil
+}
+
+// countBucket adds the files of the handler's categories in a bucket to stats
+// Without a tenant, files of tenants are skipped
+func (h *Handler) countBucket(ctx context.Context, bucketName, prefix string, skipTenants bool, config *HandlerConfig, stats *StorageStats) error {
+	return h.listForStats(ctx, bucketName, prefix, skipTenants, config, func(object minio.ObjectInfo) {
+		if middleware.ThumbnailOriginalKeys(object.Key) != nil || IsDerivedManifestKey(object.Key) || IsAuditAnchorKey(object.Key) {
+			stats.DerivedBytes += object.Size
+			stats.DerivedObjects++
+			return
+		}
+
+		entityType, entityID, category, ok := splitFileKey(object.Key)
+		if !ok {
+			return
+		}
+		if _, exists := config.Categories[category]; !exists {
+			return
+		}
+
+		stats.TotalBytes += object.Size
+		stats.TotalObjects++
+		stats.Categories[category] = stats.Categories[category].add(object.Size)
+		if stats.Entities != nil {
+			entity := entityType + "/" + entityID
+			stats.Entities[entity] = stats.Entities[entity].add(object.Size)
+		}
+	})
+}
+
+// countDerived adds the derived files in a bucket to stats
+func (h *Handler) countDerived(ctx context.Context, bucketName, prefix string, skipTenants bool, config *HandlerConfig, stats *StorageStats) error {
+	return h.listForStats(ctx, bucketName, prefix, skipTenants, config, func(object minio.ObjectInfo) {
+		stats.DerivedBytes += object.Size
+		stats.DerivedObjects++
+	})
+}
+
+// listForStats calls count for the objects under prefix in a bucket
+func (h *Handler) listForStats(ctx context.Context, bucketName, prefix string, skipTenants bool, config *HandlerConfig, count func(object minio.ObjectInfo)) error {
+	// Stop listing on the first error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for object := range h.Client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list bucket %s: %w", bucketName, object.Err)
+		}
+		if skipTenants && config.Tenants != nil && config.Tenants.Owner(object.Key) != nil {
+			continue
+		}
+		count(object)
+	}
+	return nil
+}
+
+// splitFileKey returns the entity and category of a key created by GenerateFileKey
+// Keys of tenants carry the tenant prefix in front, so the parts are taken from the end
+func splitFileKey(fileKey string) (entityType, entityID, category string, ok bool) {
+	parts := strings.Split(fileKey, "/")
+	if len(parts) < 4 {
+		return "", "", "", false
+	}
+	parts = parts[len(parts)-4:]
+	return parts[0], parts[1], parts[2], true
+}
+
+// add returns the usage with a file of size added
+func (u UsageStats) add(size int64) UsageStats {
+	u.Bytes += size
+	u.Objects++
+	return u
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"sort"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/tags"
+)
+
+// Tag sets object tags of a file for a user, existing tags with other keys are kept
+// Objects hold at most 10 tags; CategoryConfig.DefaultTags are applied at upload
+func (h *Handler) Tag(ctx context.Context, fileKey, userID string, fileTags map[string]string) error {
+	return h.updateTags(ctx, fileKey, userID, func(current *tags.Tags) error {
+		for key, value := range fileTags {
+			if err := current.Set(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Untag removes object tags of a file by key for a user, unknown keys are ignored
+func (h *Handler) Untag(ctx context.Context, fileKey, userID string, keys ...string) error {
+	return h.updateTags(ctx, fileKey, userID, func(current *tags.Tags) error {
+		for _, key := range keys {
+			current.Remove(key)
+		}
+		return nil
+	})
+}
+
+// GetTags returns the object tags of a file to a user
+// Reads pass the middlewares of the category as operation "preview", so users read the tags of
+// the files they may preview
+func (h *Handler) GetTags(ctx context.Context, fileKey, userID string) (map[string]string, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	fileInfo, bucketName, err := h.findFile(ctx, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	var current *tags.Tags
+	err = h.runChain(ctx, h.chainRequest("preview", fileInfo.(*minio.ObjectInfo), bucketName, userID), func(ctx context.Context) error {
+		var err error
+		current, err = h.objectTags(ctx, bucketName, fileKey)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return current.ToMap(), nil
+}
+
+// updateTags rewrites the tags of a file with the changes of update
+// Changes pass the middlewares of the category as operation "tags", the security middleware
+// authorizes them as middleware.ActionReplace against the stored owner. The record of the
+// MetadataStore follows, see FileMetadata.Tags
+func (h *Handler) updateTags(ctx context.Context, fileKey, userID string, update func(current *tags.Tags) error) error {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	fileInfo, bucketName, err := h.findFile(ctx, fileKey)
+	if err != nil {
+		return err
+	}
+
+	// No data is written, only the tags
+	chainReq := h.chainRequest("tags", fileInfo.(*minio.ObjectInfo), bucketName, userID)
+	chainReq.FileSize = 0
+	chainReq.Replace = true
+	var current *tags.Tags
+	err = h.runChain(ctx, chainReq, func(ctx context.Context) error {
+		var err error
+		if current, err = h.objectTags(ctx, bucketName, fileKey); err != nil {
+			return err
+		}
+		if err := update(current); err != nil {
+			return &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Invalid tags", Details: err.Error(), Err: err}
+		}
+
+		err = h.retry(ctx, "put_object_tagging", func() error {
+			if current.Count() == 0 {
+				return h.Client.RemoveObjectTagging(ctx, bucketName, fileKey, minio.RemoveObjectTaggingOptions{})
+			}
+			return h.Client.PutObjectTagging(ctx, bucketName, fileKey, current, minio.PutObjectTaggingOptions{})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to update tags of %s: %w", fileKey, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Stat results carry the tag count
+	h.invalidate(ctx, fileKey)
+	h.replicate(ctx, fileKey, false)
+
+	fileTags := formatTags(current.ToMap())
+	h.updateRecord(ctx, fileKey, func(record *interfaces.FileMetadata) {
+		record.Tags = fileTags
+	})
+	return nil
+}
+
+// objectTags returns the tags of an object
+func (h *Handler) objectTags(ctx context.Context, bucketName, fileKey string) (*tags.Tags, error) {
+	var current *tags.Tags
+	err := h.retry(ctx, "get_object_tagging", func() error {
+		var err error
+		current, err = h.Client.GetObjectTagging(ctx, bucketName, fileKey, minio.GetObjectTaggingOptions{})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags of %s: %w", fileKey, err)
+	}
+	return current, nil
+}
+
+// updateRecord changes the MetadataStore record of a file, files without a record are skipped
+// The file itself is already changed, so failures are only logged
+func (h *Handler) updateRecord(ctx context.Context, fileKey string, update func(record *interfaces.FileMetadata)) {
+	store := h.config().MetadataStore
+	if store == nil {
+		return
+	}
+	record, err := store.Get(ctx, fileKey)
+	if err == nil {
+		update(record)
+		err = store.Save(ctx, record)
+	}
+	if err != nil && !stderrors.Is(err, errors.ErrFileNotFound) {
+		h.logger.Warn("failed to update file metadata", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
+
+// formatTags returns tags as sorted "key=value" entries, the form of FileMetadata.Tags
+func formatTags(fileTags map[string]string) []string {
+	formatted := make([]string, 0, len(fileTags))
+	for key, value := range fileTags {
+		formatted = append(formatted, key+"="+value)
+	}
+	sort.Strings(formatted)
+	return formatted
+}
+
+// matchTags reports whether fileTags holds all wanted tags
+func matchTags(fileTags, wanted map[string]string) bool {
+	for key, value := range wanted {
+		if actual, ok := fileTags[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
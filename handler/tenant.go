@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/tenant"
+)
+
+// tenant returns the tenant of a request, nil when the handler or request has none
+func (h *Handler) tenant(ctx context.Context) (*tenant.Tenant, error) {
+	tenants := h.config().Tenants
+	if tenants == nil {
+		return nil, nil
+	}
+	return tenants.Resolve(ctx)
+}
+
+// tenantBucket returns the bucket files of a tenant are stored in
+func (h *Handler) tenantBucket(t *tenant.Tenant) string {
+	if t != nil && t.BucketName != "" {
+		return t.BucketName
+	}
+	return h.BucketName
+}
+
+// checkTenantKey hides files outside the request's tenant; requests without a tenant
+// cannot reach files of any tenant
+func (h *Handler) checkTenantKey(t *tenant.Tenant, fileKey string) error {
+	tenants := h.config().Tenants
+	if tenants == nil {
+		return nil
+	}
+	if t != nil {
+		if !t.Owns(fileKey) {
+			return errors.ErrFileNotFound
+		}
+		return nil
+	}
+	if tenants.Owner(fileKey) != nil {
+		return errors.ErrFileNotFound
+	}
+	return nil
+}
+
+// checkTenantLimits applies the size and rate limits of a tenant, size is 0 for downloads
+func (h *Handler) checkTenantLimits(ctx context.Context, t *tenant.Tenant, size int64) error {
+	if t == nil {
+		return nil
+	}
+	if t.MaxFileSize > 0 && size > t.MaxFileSize {
+		return &errors.StorageError{
+			Code:    errors.ErrFileTooLarge.Code,
+			Message: errors.ErrFileTooLarge.Message,
+			Details: fmt.Sprintf("tenant %s allows at most %d bytes", t.ID, t.MaxFileSize),
+		}
+	}
+
+	if t.RequestsPerMinute > 0 {
+		if err := h.takeTenant(ctx, "tenant:"+t.ID+":requests", t.RequestsPerMinute, 1); err != nil {
+			return err
+		}
+	}
+	if t.BytesPerMinute > 0 && size > 0 {
+		// A file larger than the burst would never fit, it takes the whole bucket instead
+		cost := math.Min(float64(size), t.BytesPerMinute)
+		if err := h.takeTenant(ctx, "tenant:"+t.ID+":bytes", t.BytesPerMinute, cost); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// takeTenant takes tokens from a tenant bucket of the shared rate limiter
+func (h *Handler) takeTenant(ctx context.Context, key string, perMinute, cost float64) error {
+	allowed, retryAfter, err := h.rateLimiter.Take(ctx, key, perMinute/60, perMinute, cost)
+	if err != nil {
+		return fmt.Errorf("failed to check tenant rate limit: %w", err)
+	}
+	if !allowed {
+		return &errors.StorageError{
+			Code:    errors.ErrRateLimited.Code,
+			Message: errors.ErrRateLimited.Message,
+			Details: fmt.Sprintf("retry after %s", retryAfter.Round(time.Second)),
+		}
+	}
+	return nil
+}
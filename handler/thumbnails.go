@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+// RegenerateOptions represents options of thumbnail regeneration
+type RegenerateOptions struct {
+	// Sizes are generated instead of the thumbnail sizes of each file's category, e.g. to backfill
+	// a size added to ThumbnailSizes without generating the others again
+	Sizes []string
+	// Progress is called for every file once its job is submitted; err is set when submitting failed
+	Progress func(fileKey, jobID string, err error)
+}
+
+// RegenerateResult represents the thumbnail jobs submitted by RegenerateThumbnails
+type RegenerateResult struct {
+	Submitted int              // Files whose thumbnails are generated again
+	Skipped   int              // Files without thumbnails: other content types, or categories generating none
+	Failed    map[string]error // Files whose job could not be submitted
+	JobIDs    []string         // Jobs of the submitted files, see RegenerationProgress
+}
+
+// RegenerateProgress represents the state of the jobs of a regeneration
+type RegenerateProgress struct {
+	Total      int
+	Pending    int
+	Processing int
+	Done       int
+	Failed     int
+	Cancelled  int
+	Missing    int // Jobs the jobs store no longer knows
+}
+
+// Finished reports whether no job of the regeneration is left to run
+func (p *RegenerateProgress) Finished() bool {
+	return p.Pending == 0 && p.Processing == 0
+}
+
+// RegenerateThumbnails generates the thumbnails of stored files again, e.g. after ThumbnailSizes
+// changed. target is a file key, or a key prefix ending with "/" for all files under it, e.g.
+// "user/123/". Every file is a background thumbnail job reading the stored original; the jobs are
+// reported in the result, their progress by RegenerationProgress, GetJob and SubscribeThumbnails
+func (h *Handler) RegenerateThumbnails(ctx context.Context, target string, opts RegenerateOptions) (*RegenerateResult, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	result := &RegenerateResult{
+		Failed: make(map[string]error),
+		JobIDs: []string{},
+	}
+
+	if !strings.HasSuffix(target, "/") {
+		fileInfo, bucketName, err := h.findFile(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		h.regenerate(ctx, bucketName, fileInfo.(*minio.ObjectInfo), opts, result)
+		return result, nil
+	}
+
+	prefix, err := h.archivePrefix(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	t, err := h.tenant(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bucketName := h.tenantBucket(t)
+	staging, direct := h.StagingPrefix(), h.DirectUploadPrefix()
+
+	// Stop listing on the first error
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := h.Client.ListObjects(listCtx, bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true, WithMetadata: true})
+	for object := range objects {
+		if object.Err != nil {
+			return result, fmt.Errorf("failed to list files of %s: %w", prefix, object.Err)
+		}
+		// Thumbnails may share the bucket of their originals, staged files and direct uploads get
+		// theirs when committed or processed
+		if middleware.ThumbnailOriginalKeys(object.Key) != nil || IsDerivedManifestKey(object.Key) || IsAuditAnchorKey(object.Key) ||
+			(staging != "" && strings.HasPrefix(object.Key, staging)) || (direct != "" && strings.HasPrefix(object.Key, direct)) {
+			continue
+		}
+		objInfo, _, err := h.listedObject(ctx, bucketName, object, false)
+		if err != nil {
+			return result, err
+		}
+		h.regenerate(ctx, bucketName, objInfo, opts, result)
+	}
+	return result, nil
+}
+
+// regenerate submits the thumbnail job of a stored file, with the sizes and destination its
+// category's thumbnail middleware uses
+func (h *Handler) regenerate(ctx context.Context, bucketName string, objInfo *minio.ObjectInfo, opts RegenerateOptions, result *RegenerateResult) {
+	categoryName := objInfo.UserMetadata["Category"]
+
+	h.configMutex.RLock()
+	categoryConfig := h.Config.Categories[categoryName]
+	previewConfig := categoryConfig.Preview
+	if !previewConfig.GenerateThumbnails {
+		previewConfig = h.Config.Preview
+	}
+	thumbnails := false
+	if chain, exists := h.Middlewares[categoryName]; exists {
+		for _, m := range chain.Middlewares() {
+			thumbnails = thumbnails || m.Name() == "thumbnail"
+		}
+	}
+	derivedBucket := h.derivedBucket(categoryConfig)
+	storageClass := h.derivedStorageClass(categoryConfig)
+	h.configMutex.RUnlock()
+
+	sizes := opts.Sizes
+	if len(sizes) == 0 && thumbnails && previewConfig.GenerateThumbnails {
+		sizes = previewConfig.ThumbnailSizes
+	}
+	if len(sizes) == 0 || !middleware.SupportsThumbnail(objInfo.ContentType) {
+		result.Skipped++
+		return
+	}
+
+	jobID := "thumb_" + uuid.NewString()
+	err := h.AsyncProcessor.SubmitJob(ctx, middleware.ThumbnailJob{
+		ID:            jobID,
+		FileKey:       objInfo.Key,
+		FileSize:      objInfo.Size,
+		ContentType:   objInfo.ContentType,
+		Sizes:         sizes,
+		BucketName:    bucketName,
+		DerivedBucket: derivedBucket,
+		StorageClass:  storageClass,
+	})
+	if err != nil {
+		result.Failed[objInfo.Key] = fmt.Errorf("failed to submit thumbnail job: %w", err)
+	} else {
+		result.Submitted++
+		result.JobIDs = append(result.JobIDs, jobID)
+	}
+	if opts.Progress != nil {
+		opts.Progress(objInfo.Key, jobID, err)
+	}
+}
+
+// RegenerationProgress returns the state of the jobs of a regeneration, see RegenerateResult.JobIDs
+func (h *Handler) RegenerationProgress(ctx context.Context, jobIDs []string) (*RegenerateProgress, error) {
+	progress := &RegenerateProgress{Total: len(jobIDs)}
+	for _, id := range jobIDs {
+		job, err := h.GetJob(ctx, id)
+		if err == jobs.ErrJobNotFound {
+			progress.Missing++
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get job %s: %w", id, err)
+		}
+		switch job.Status {
+		case jobs.StatusPending:
+			progress.Pending++
+		case jobs.StatusProcessing:
+			progress.Processing++
+		case jobs.StatusDone:
+			progress.Done++
+		case jobs.StatusFailed:
+			progress.Failed++
+		case jobs.StatusCancelled:
+			progress.Cancelled++
+		}
+	}
+	return progress, nil
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// DownloadTokenClaims is the payload of a signed download token
+type DownloadTokenClaims struct {
+	Handler   string `json:"h"`
+	FileKey   string `json:"k"`
+	UserID    string `json:"u,omitempty"`
+	ExpiresAt int64  `json:"e"` // Unix seconds
+}
+
+// GenerateDownloadToken issues an HMAC-signed, time-limited token for downloading a file
+// Applications serve the download from their own domain and redeem the token with DownloadWithToken,
+// so MinIO presigned URLs are never exposed
+func (h *Handler) GenerateDownloadToken(ctx context.Context, req *interfaces.DownloadTokenRequest) (*interfaces.DownloadTokenResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	config := h.config().DownloadTokens
+	if len(config.Secret) == 0 {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "Download tokens require DownloadTokens.Secret"}
+	}
+
+	if _, _, err := h.findFile(ctx, req.FileKey); err != nil {
+		return nil, err
+	}
+
+	expires := req.Expires
+	if expires <= 0 {
+		expires = config.Expiry
+	}
+	if expires <= 0 {
+		expires = 15 * time.Minute
+	}
+	expiresAt := time.Now().Add(expires)
+
+	token, err := signToken(config.Secret, downloadTokenPrefix, &DownloadTokenClaims{
+		Handler:   h.Name,
+		FileKey:   req.FileKey,
+		UserID:    req.UserID,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := &interfaces.DownloadTokenResponse{
+		Success:   true,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}
+	if config.BaseURL != "" {
+		separator := "?"
+		if strings.Contains(config.BaseURL, "?") {
+			separator = "&"
+		}
+		response.URL = config.BaseURL + separator + "token=" + url.QueryEscape(token)
+	}
+	return response, nil
+}
+
+// VerifyDownloadToken checks the signature and expiry of a download token issued by this handler
+func (h *Handler) VerifyDownloadToken(token string) (*DownloadTokenClaims, error) {
+	secret := h.config().DownloadTokens.Secret
+	if len(secret) == 0 {
+		return nil, errors.ErrInvalidDownloadToken
+	}
+
+	claims := &DownloadTokenClaims{}
+	if !parseToken(secret, downloadTokenPrefix, token, claims) {
+		return nil, errors.ErrInvalidDownloadToken
+	}
+	if claims.Handler != h.Name {
+		return nil, errors.ErrInvalidDownloadToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errors.ErrDownloadTokenExpired
+	}
+	return claims, nil
+}
+
+// DownloadWithToken verifies a download token and downloads the file it grants access to
+// When the token is bound to a user, userID must match it
+func (h *Handler) DownloadWithToken(ctx context.Context, token, userID string) (*interfaces.DownloadResponse, error) {
+	claims, err := h.VerifyDownloadToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.UserID != "" && claims.UserID != userID {
+		return nil, errors.ErrAccessDenied
+	}
+
+	return h.Download(ctx, &interfaces.DownloadRequest{
+		FileKey: claims.FileKey,
+		UserID:  claims.UserID,
+	})
+}
+
+// Prefixes of signed tokens, so tokens of one kind are never valid as another signed with the same key
+const (
+	downloadTokenPrefix = "storage-download-token:"
+	manifestTokenPrefix = "storage-upload-manifest:"
+)
+
+// signToken encodes claims as base64url(payload).base64url(HMAC-SHA256(prefix + payload))
+func signToken(secret []byte, prefix string, claims interface{}) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(tokenMAC(secret, prefix, encoded)), nil
+}
+
+// parseToken verifies the signature of a token signed with prefix and decodes it into claims,
+// reporting whether the token is valid
+func parseToken(secret []byte, prefix, token string, claims interface{}) bool {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return false
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, tokenMAC(secret, prefix, encoded)) {
+		return false
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(payload, claims) == nil
+}
+
+// tokenMAC computes the signature of a token payload
+func tokenMAC(secret []byte, prefix, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(prefix))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,299 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/events"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/media"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// TranscodeStatus describes the state of the latest transcode job of a video
+type TranscodeStatus struct {
+	JobID      string      `json:"job_id"`
+	FileKey    string      `json:"file_key"`
+	Status     jobs.Status `json:"status"` // pending, processing, done or failed
+	Renditions []string    `json:"renditions,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Attempts   int         `json:"attempts"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// RenditionPrefix returns the prefix of the rendition ladder of a video: original_file_key_hls/,
+// stored next to its thumbnails in the derived bucket of its category
+func RenditionPrefix(fileKey string) string {
+	return strings.TrimSuffix(fileKey, filepath.Ext(fileKey)) + "_hls/"
+}
+
+// hlsContentTypes are the content types of the files of rendition ladders, by extension
+var hlsContentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".ts":   "video/mp2t",
+	".m4s":  "video/iso.segment",
+	".mp4":  "video/mp4",
+}
+
+// transcodeVideo queues the transcoding of a stored video when the Transcoder supports it
+func (h *Handler) transcodeVideo(ctx context.Context, bucketName, fileKey, contentType string) {
+	transcoder := h.config().Transcoder
+	if transcoder == nil || !transcoder.Supports(contentType) {
+		return
+	}
+	if err := h.SubmitJob(ctx, &jobs.Job{Type: jobs.TypeTranscode, FileKey: fileKey, BucketName: bucketName}); err != nil {
+		h.logger.Warn("failed to queue video transcoding", map[string]interface{}{
+			"handler":  h.Name,
+			"file_key": fileKey,
+			"error":    err,
+		})
+	}
+}
+
+// handleTranscodeJob transcodes a video with the Transcoder of the handler, replaces its rendition
+// ladder in the derived bucket and records the renditions as hls-renditions metadata. Videos
+// encrypted by the encryption middleware are not transcoded, derived files are stored unencrypted
+func (h *Handler) handleTranscodeJob(ctx context.Context, job *jobs.Job) error {
+	transcoder := h.config().Transcoder
+	if transcoder == nil {
+		return fmt.Errorf("no transcoder configured")
+	}
+
+	sse, err := h.keyServerSideEncryption(job.FileKey)
+	if err != nil {
+		return err
+	}
+	objInfo, err := h.statObject(ctx, job.BucketName, job.FileKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			// Deleted before its transcoding
+			return nil
+		}
+		return fmt.Errorf("failed to stat object: %w", err)
+	}
+	if middleware.IsEncrypted(objInfo.UserMetadata) || !transcoder.Supports(objInfo.ContentType) {
+		job.Result = map[string]interface{}{"transcoded": false}
+		return nil
+	}
+
+	categoryName := h.fileKeyInfo(&objInfo).Category
+	h.configMutex.RLock()
+	categoryConfig := h.Config.Categories[categoryName]
+	bucketName := h.derivedBucket(categoryConfig)
+	storageClass := h.derivedStorageClass(categoryConfig)
+	h.configMutex.RUnlock()
+	renditions := categoryConfig.Video.Renditions
+	if len(renditions) == 0 {
+		renditions = media.DefaultRenditions()
+	}
+
+	dir, err := os.MkdirTemp("", "transcode-")
+	if err != nil {
+		return fmt.Errorf("failed to create transcode directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileData, err := h.openFile(ctx, job.BucketName, &objInfo, objInfo.UserMetadata["Uploaded-By"], 0, middleware.OriginalSize(objInfo.UserMetadata, objInfo.Size)-1)
+	if err != nil {
+		return err
+	}
+	ladder, err := transcoder.Transcode(ctx, objInfo.ContentType, fileData, renditions, categoryConfig.Video.SegmentSeconds, dir)
+	if closer, ok := fileData.(io.Closer); ok {
+		closer.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to transcode video: %w", err)
+	}
+
+	// Renditions no longer configured would outlive the version they show
+	if err := h.deleteRenditions(ctx, bucketName, job.FileKey); err != nil {
+		return err
+	}
+	prefix := RenditionPrefix(job.FileKey)
+	var ladderSize int64
+	err = filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		relative, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		data, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer data.Close()
+		info, err := data.Stat()
+		if err != nil {
+			return err
+		}
+		ladderSize += info.Size()
+		_, err = h.putObject(ctx, bucketName, prefix+filepath.ToSlash(relative), data, info.Size(), minio.PutObjectOptions{
+			ContentType:  hlsContentTypes[filepath.Ext(file)],
+			StorageClass: storageClass,
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store renditions of %s: %w", job.FileKey, err)
+	}
+
+	names := make([]string, 0, len(ladder))
+	for _, rendition := range ladder {
+		names = append(names, rendition.Name)
+	}
+	h.recordDerived(ctx, bucketName, job.FileKey, DerivedRendition, DerivedFile{
+		Key:       prefix,
+		Kind:      DerivedRendition,
+		Variant:   strings.Join(names, ","),
+		Size:      ladderSize,
+		CreatedAt: time.Now(),
+	})
+	userMetadata := make(map[string]string, len(objInfo.UserMetadata)+2)
+	for key, value := range objInfo.UserMetadata {
+		userMetadata[key] = value
+	}
+	userMetadata["Hls-Renditions"] = strings.Join(names, ",")
+	userMetadata["Content-Type"] = objInfo.ContentType
+	if err := h.replaceMetadata(ctx, job.BucketName, job.FileKey, objInfo.ETag, userMetadata); err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			// A newer version is transcoded on its own
+			return nil
+		}
+		return fmt.Errorf("failed to record renditions of %s: %w", job.FileKey, err)
+	}
+	h.invalidate(ctx, job.FileKey)
+	h.replicate(ctx, job.FileKey, false)
+
+	job.Result = map[string]interface{}{"transcoded": true, "renditions": names}
+	h.publish(ctx, events.TypeVideoReady, categoryName, job.FileKey, objInfo.UserMetadata["Uploaded-By"], map[string]interface{}{
+		"renditions": names,
+		"manifest":   media.HLSManifest,
+	})
+	return nil
+}
+
+// deleteRenditions deletes the rendition ladder of a video
+func (h *Handler) deleteRenditions(ctx context.Context, bucketName, fileKey string) error {
+	// Stop listing on the first error
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := h.Client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: RenditionPrefix(fileKey), Recursive: true})
+	for object := range objects {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list renditions: %w", object.Err)
+		}
+		if err := h.removeObject(ctx, bucketName, object.Key, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("failed to delete rendition file %s: %w", object.Key, err)
+		}
+	}
+	return nil
+}
+
+// streamRendition streams a file of the rendition ladder of a video, access is decided by the video
+func (h *Handler) streamRendition(ctx context.Context, req *interfaces.StreamRequest, objInfo *minio.ObjectInfo, sourceBucket string) (*interfaces.StreamResponse, error) {
+	renditionPath := path.Clean("/" + req.Path)[1:]
+	if renditionPath == "" || renditionPath != req.Path || hlsContentTypes[path.Ext(renditionPath)] == "" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Invalid rendition path " + req.Path}
+	}
+	categoryName := h.fileKeyInfo(objInfo).Category
+	h.configMutex.RLock()
+	bucketName := h.derivedBucket(h.Config.Categories[categoryName])
+	h.configMutex.RUnlock()
+
+	var object io.ReadCloser
+	var renditionInfo minio.ObjectInfo
+	err := h.runChain(ctx, h.chainRequest("stream", objInfo, sourceBucket, req.UserID), func(ctx context.Context) error {
+		var err error
+		object, renditionInfo, err = h.getObject(ctx, bucketName, RenditionPrefix(req.FileKey)+renditionPath, minio.GetObjectOptions{})
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return &errors.StorageError{Code: "RENDITION_NOT_FOUND", Message: "Rendition file not found"}
+			}
+			return errors.Wrap(errors.ErrDownloadFailed, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Validators are those of the rendition file, playlists change when a video is transcoded again
+	etag := entityTag(renditionInfo.ETag)
+	if unchanged(req.IfNoneMatch, req.IfModifiedSince, etag, renditionInfo.LastModified) {
+		object.Close()
+		return &interfaces.StreamResponse{
+			Success:      true,
+			NotModified:  true,
+			FileSize:     renditionInfo.Size,
+			ContentType:  renditionInfo.ContentType,
+			ETag:         etag,
+			LastModified: renditionInfo.LastModified,
+		}, nil
+	}
+
+	return &interfaces.StreamResponse{
+		Success:       true,
+		FileData:      h.throttleDownload(ctx, object),
+		FileSize:      renditionInfo.Size,
+		ContentLength: renditionInfo.Size,
+		ContentType:   renditionInfo.ContentType,
+		ETag:          etag,
+		LastModified:  renditionInfo.LastModified,
+		Metadata: map[string]interface{}{
+			"file_name":    renditionPath,
+			"original_key": req.FileKey,
+			"uploaded_at":  renditionInfo.LastModified,
+			"content_type": renditionInfo.ContentType,
+		},
+	}, nil
+}
+
+// GetTranscodeStatus returns the status of the latest transcode job of a video
+func (h *Handler) GetTranscodeStatus(ctx context.Context, fileKey string) (*TranscodeStatus, error) {
+	fileJobs, err := h.AsyncProcessor.JobsForFile(ctx, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(fileJobs) - 1; i >= 0; i-- {
+		job := fileJobs[i]
+		if job.Type != jobs.TypeTranscode {
+			continue
+		}
+		status := &TranscodeStatus{
+			JobID:     job.ID,
+			FileKey:   job.FileKey,
+			Status:    job.Status,
+			Error:     job.Error,
+			Attempts:  job.Attempts,
+			CreatedAt: job.CreatedAt,
+			UpdatedAt: job.UpdatedAt,
+		}
+		// Results that went through a durable store come back as generic slices
+		switch renditions := job.Result["renditions"].(type) {
+		case []string:
+			status.Renditions = renditions
+		case []interface{}:
+			for _, name := range renditions {
+				if name, ok := name.(string); ok {
+					status.Renditions = append(status.Renditions, name)
+				}
+			}
+		}
+		return status, nil
+	}
+	return nil, jobs.ErrJobNotFound
+}
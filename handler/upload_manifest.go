@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/google/uuid"
+	"github.com/minio/minio-go/v7"
+)
+
+// UploadManifestClaims is the payload of the completion token of an upload manifest
+type UploadManifestClaims struct {
+	Handler   string          `json:"h"`
+	ID        string          `json:"i"`
+	Files     []ManifestClaim `json:"f"`
+	ExpiresAt int64           `json:"e"` // Unix seconds
+}
+
+// ManifestClaim is a file of an upload manifest
+type ManifestClaim struct {
+	FileKey  string `json:"k"`
+	FileSize int64  `json:"s"`
+}
+
+// CreateUploadManifest checks the files a client intends to upload against the limits, types and
+// extensions of their category and returns a PresignUpload URL for each, with a completion token
+// signing their keys and sizes. Nothing is presigned when a file is refused. The client uploads
+// every file, then posts the token back to CompleteUploadManifest
+func (h *Handler) CreateUploadManifest(ctx context.Context, req *interfaces.UploadManifestRequest) (*interfaces.UploadManifest, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	config := h.config()
+	if len(config.DirectUploads.ManifestSecret) == 0 {
+		return nil, &errors.StorageError{Code: "INVALID_CONFIG", Message: "Upload manifests require DirectUploads.ManifestSecret"}
+	}
+	if h.DirectUploadPrefix() == "" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Direct uploads are not enabled for handler " + h.Name}
+	}
+	if len(req.Files) == 0 {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "No files provided"}
+	}
+	maxFiles := config.Batch.withDefaults().MaxUploadFiles
+	if len(req.Files) > maxFiles {
+		return nil, &errors.StorageError{Code: "BATCH_SIZE_EXCEEDED", Message: fmt.Sprintf("Manifest of %d files exceeds maximum %d", len(req.Files), maxFiles)}
+	}
+	categoryConfig, _, exists := h.category(req.Category)
+	if !exists {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Unknown category " + req.Category}
+	}
+	limits, err := h.categoryUploadLimits(ctx, req.Category)
+	if err != nil {
+		return nil, err
+	}
+	extensions := categoryConfig.Validation.AllowedExtensions
+	for _, file := range req.Files {
+		if err := checkManifestFile(file, limits, extensions); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest := &interfaces.UploadManifest{
+		Success: true,
+		ID:      "manifest_" + uuid.NewString(),
+		Uploads: make([]interfaces.DirectUploadResponse, 0, len(req.Files)),
+	}
+	claims := &UploadManifestClaims{
+		Handler: h.Name,
+		ID:      manifest.ID,
+		Files:   make([]ManifestClaim, 0, len(req.Files)),
+		// Tokens last as long as the incoming area keeps the uploads
+		ExpiresAt: time.Now().AddDate(0, 0, config.DirectUploads.withDefaults().ExpiryDays).Unix(),
+	}
+	for _, file := range req.Files {
+		upload, err := h.PresignUpload(ctx, &interfaces.DirectUploadRequest{
+			FileName:    file.FileName,
+			ContentType: file.ContentType,
+			Category:    req.Category,
+			EntityType:  req.EntityType,
+			EntityID:    req.EntityID,
+			UserID:      req.UserID,
+			Expires:     req.Expires,
+		})
+		if err != nil {
+			return nil, err
+		}
+		manifest.Uploads = append(manifest.Uploads, *upload)
+		manifest.ExpiresAt = upload.ExpiresAt
+		claims.Files = append(claims.Files, ManifestClaim{FileKey: upload.FileKey, FileSize: file.FileSize})
+	}
+
+	if manifest.CompletionToken, err = signToken(config.DirectUploads.ManifestSecret, manifestTokenPrefix, claims); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// CompleteUploadManifest verifies a completion token and that every file of its manifest arrived
+// with its declared size, then processes the files like ConfirmUpload, middlewares and callbacks
+// included. Nothing is processed while a file is missing or differs: the error lists them and the
+// token stays usable. Files completed by an earlier call are reported as stored
+func (h *Handler) CompleteUploadManifest(ctx context.Context, token string, opts ...Option) (*interfaces.BatchUploadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	claims, err := h.VerifyManifestToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.checkManifestUploads(ctx, claims); err != nil {
+		return nil, err
+	}
+
+	results := make([]*interfaces.UploadResponse, len(claims.Files))
+	errs := h.runBatch(ctx, len(claims.Files), func(ctx context.Context, index int) {
+		resp, err := h.ConfirmUpload(ctx, claims.Files[index].FileKey, opts...)
+		if err != nil {
+			resp = &interfaces.UploadResponse{Success: false, FileKey: claims.Files[index].FileKey, Error: err}
+		}
+		results[index] = resp
+	})
+
+	successCount := 0
+	for i, resp := range results {
+		if errs[i] != nil {
+			results[i] = &interfaces.UploadResponse{Success: false, FileKey: claims.Files[i].FileKey, Error: errs[i]}
+			continue
+		}
+		if resp != nil && resp.Success {
+			successCount++
+		}
+	}
+	return &interfaces.BatchUploadResponse{
+		Success:      successCount == len(results),
+		Results:      results,
+		SuccessCount: successCount,
+		TotalCount:   len(results),
+	}, nil
+}
+
+// VerifyManifestToken checks the signature and expiry of a completion token issued by this handler
+func (h *Handler) VerifyManifestToken(token string) (*UploadManifestClaims, error) {
+	secret := h.config().DirectUploads.ManifestSecret
+	if len(secret) == 0 {
+		return nil, errors.ErrInvalidManifestToken
+	}
+
+	claims := &UploadManifestClaims{}
+	if !parseToken(secret, manifestTokenPrefix, token, claims) {
+		return nil, errors.ErrInvalidManifestToken
+	}
+	if claims.Handler != h.Name || len(claims.Files) == 0 {
+		return nil, errors.ErrInvalidManifestToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errors.ErrManifestExpired
+	}
+	return claims, nil
+}
+
+// checkManifestUploads checks that every file of a manifest is uploaded with its declared size,
+// or stored by an earlier completion
+func (h *Handler) checkManifestUploads(ctx context.Context, claims *UploadManifestClaims) error {
+	prefix := h.DirectUploadPrefix()
+	if prefix == "" {
+		return &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Direct uploads are not enabled for handler " + h.Name}
+	}
+
+	var missing, mismatched []string
+	for _, file := range claims.Files {
+		// Keys of other tenants are refused here
+		bucketName, err := h.locateFile(ctx, file.FileKey)
+		if err != nil {
+			return err
+		}
+		sse, err := h.keyServerSideEncryption(file.FileKey)
+		if err != nil {
+			return err
+		}
+		objInfo, err := h.statObject(ctx, bucketName, prefix+file.FileKey, minio.StatObjectOptions{ServerSideEncryption: sse})
+		if err == nil {
+			if objInfo.Size != file.FileSize {
+				mismatched = append(mismatched, file.FileKey)
+			}
+			continue
+		}
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+			return errors.Wrap(errors.ErrDownloadFailed, err)
+		}
+		if _, err := h.statObject(ctx, bucketName, file.FileKey, minio.StatObjectOptions{ServerSideEncryption: sse}); err != nil {
+			if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+				return errors.Wrap(errors.ErrDownloadFailed, err)
+			}
+			missing = append(missing, file.FileKey)
+		}
+	}
+
+	if len(missing) == 0 && len(mismatched) == 0 {
+		return nil
+	}
+	var details []string
+	if len(missing) > 0 {
+		details = append(details, "missing: "+strings.Join(missing, ", "))
+	}
+	if len(mismatched) > 0 {
+		details = append(details, "size differs: "+strings.Join(mismatched, ", "))
+	}
+	return &errors.StorageError{Code: errors.ErrManifestIncomplete.Code, Message: errors.ErrManifestIncomplete.Message, Details: strings.Join(details, "; ")}
+}
+
+// checkManifestFile checks a file of a manifest against the limits and extensions of its category
+func checkManifestFile(file interfaces.ManifestFile, limits uploadLimits, extensions []string) error {
+	if file.FileName == "" || file.FileSize <= 0 {
+		return &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Files need a name and a size"}
+	}
+	if limits.maxSize > 0 && file.FileSize > limits.maxSize {
+		return &errors.StorageError{Code: errors.ErrFileTooLarge.Code, Message: fmt.Sprintf("File %s of %d bytes exceeds maximum %d", file.FileName, file.FileSize, limits.maxSize)}
+	}
+	if file.FileSize < limits.minSize {
+		return &errors.StorageError{Code: errors.ErrInvalidFile.Code, Message: fmt.Sprintf("File %s of %d bytes is below minimum %d", file.FileName, file.FileSize, limits.minSize)}
+	}
+	if len(limits.types) > 0 && !slices.Contains(limits.types, file.ContentType) {
+		return &errors.StorageError{Code: errors.ErrUnsupportedType.Code, Message: fmt.Sprintf("Content type %s of file %s is not allowed", file.ContentType, file.FileName)}
+	}
+	if len(extensions) > 0 && !slices.Contains(extensions, strings.ToLower(filepath.Ext(file.FileName))) {
+		return &errors.StorageError{Code: errors.ErrUnsupportedType.Code, Message: fmt.Sprintf("File extension of %s is not allowed", file.FileName)}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// URL styles, how the bucket appears in direct file URLs
+const (
+	URLStylePath        = "path"         // BaseURL/bucket/key (default)
+	URLStyleVirtualHost = "virtual_host" // scheme://bucket.host/key
+	URLStyleKey         = "key"          // BaseURL/key, for proxies serving a single bucket
+)
+
+// URLBuilderConfig configures the URLs returned for files and thumbnails
+// Public categories get direct URLs, from the CDN when one is configured; private categories get
+// presigned URLs, which are always signed for the MinIO endpoint and ignore BaseURL
+type URLBuilderConfig struct {
+	BaseURL     string `json:"base_url,omitempty"`     // Public address of MinIO or a proxy in front of it, the client endpoint when empty
+	CDNEndpoint string `json:"cdn_endpoint,omitempty"` // Public files are served from CDNEndpoint/key, overridden by Preview.CDNEndpoint
+	PathStyle   string `json:"path_style,omitempty"`   // URLStylePath, URLStyleVirtualHost or URLStyleKey
+}
+
+// Validate checks the URL builder configuration
+func (c URLBuilderConfig) Validate() error {
+	for _, endpoint := range []string{c.BaseURL, c.CDNEndpoint} {
+		if endpoint == "" {
+			continue
+		}
+		if u, err := url.Parse(endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Invalid URL " + endpoint + ", an absolute URL is required"}
+		}
+	}
+	switch c.PathStyle {
+	case "", URLStylePath, URLStyleVirtualHost, URLStyleKey:
+		return nil
+	}
+	return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Unknown URL path style " + c.PathStyle}
+}
+
+// FileURL returns the URL clients should use to fetch a file, see URLBuilderConfig
+// Files of watermarked categories have none and are served by Download, ServeFile and Stream;
+// files not scanned yet or held by moderation are refused, callers authorize the rest
+func (h *Handler) FileURL(ctx context.Context, fileKey string) (string, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer done()
+
+	fileInfo, bucketName, err := h.findFile(ctx, fileKey)
+	if err != nil {
+		return "", err
+	}
+	objInfo := fileInfo.(*minio.ObjectInfo)
+	categoryName := h.fileKeyInfo(objInfo).Category
+	if h.watermarked(categoryName) {
+		return "", errWatermarked(categoryName)
+	}
+	if !scanned(objInfo.UserMetadata["Scan-Status"]) {
+		return "", errors.ErrNotScanned
+	}
+	if !servable(objInfo.UserMetadata) {
+		return "", &errors.StorageError{Code: errors.ErrAccessDenied.Code, Message: "File is held by moderation"}
+	}
+	return h.fileURL(ctx, categoryName, bucketName, fileKey)
+}
+
+// servable reports whether a file passed its malware scan and is not held by moderation, so it can
+// be linked to without checking who asks
+func servable(userMetadata map[string]string) bool {
+	switch userMetadata[http.CanonicalHeaderKey(middleware.ModerationStatusMetadataKey)] {
+	case middleware.ModerationPending, middleware.ModerationRejected:
+		return false
+	}
+	return scanned(userMetadata["Scan-Status"])
+}
+
+// fileURL returns the URL clients should use to fetch a file of a category
+// Signed URLs are reused from the cache of the category while they are valid long enough; files
+// of watermarked categories get none
+func (h *Handler) fileURL(ctx context.Context, categoryName, bucketName, fileKey string) (string, error) {
+	if h.watermarked(categoryName) {
+		return "", nil
+	}
+	fileURL, _, err := cachedURL(h.categoryCache(categoryName), fileKey, "file", func() (string, time.Time, error) {
+		return h.buildFileURL(ctx, categoryName, bucketName, fileKey)
+	})
+	return fileURL, err
+}
+
+// buildFileURL builds the URL of a file of a category and returns when it expires, zero for unsigned URLs
+// Public categories get a CDN URL when a CDN is configured and a direct object URL otherwise, private
+// categories a signed CDN URL when the cdn middleware signs URLs and a presigned GET URL otherwise
+func (h *Handler) buildFileURL(ctx context.Context, categoryName, bucketName, fileKey string) (string, time.Time, error) {
+	config := h.config()
+	categoryConfig, chain, _ := h.category(categoryName)
+	if categoryConfig.IsPublic {
+		previewConfig := categoryConfig.Preview
+		if !previewConfig.UseCDN {
+			previewConfig = config.Preview
+		}
+		var publicURL string
+		var err error
+		switch {
+		case previewConfig.UseCDN && previewConfig.CDNEndpoint != "":
+			publicURL, err = joinURL(previewConfig.CDNEndpoint, fileKey)
+		case config.URLBuilder.CDNEndpoint != "":
+			publicURL, err = joinURL(config.URLBuilder.CDNEndpoint, fileKey)
+		default:
+			publicURL, err = h.directURL(config.URLBuilder, bucketName, fileKey)
+		}
+		return publicURL, time.Time{}, err
+	}
+
+	expiry := categoryConfig.Security.PresignedURLExpiry
+	if expiry <= 0 {
+		expiry = config.Security.PresignedURLExpiry
+	}
+	if expiry <= 0 {
+		expiry = time.Hour
+	}
+
+	if cdn := cdnMiddleware(chain); cdn != nil && cdn.SignsURLs() {
+		if cdn.SigningExpiry() > 0 {
+			expiry = cdn.SigningExpiry()
+		}
+		expiresAt := time.Now().Add(expiry)
+		signedURL, err := cdn.SignedURL(fileKey, expiresAt)
+		return signedURL, expiresAt, err
+	}
+
+	expiresAt := time.Now().Add(expiry)
+	presignedURL, err := h.Client.PresignedGetObject(ctx, bucketName, fileKey, expiry, nil)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to generate presigned URL: %w", err)
+	}
+	return presignedURL.String(), expiresAt, nil
+}
+
+// thumbnailURLs sets the URLs of thumbnails of a file, which live in the derived bucket of its category
+// Thumbnails generated in the background may not exist yet when the URL is handed out
+func (h *Handler) thumbnailURLs(ctx context.Context, categoryName, fileKey string, thumbnails []middleware.ThumbnailInfo) {
+	h.configMutex.RLock()
+	bucketName := h.derivedBucket(h.Config.Categories[categoryName])
+	h.configMutex.RUnlock()
+
+	// Thumbnail URLs are cached with their original, which invalidates them
+	cache := h.categoryCache(categoryName)
+	for i := range thumbnails {
+		thumbnailKey := middleware.ThumbnailKey(fileKey, thumbnails[i].Size)
+		thumbnailURL, _, err := cachedURL(cache, fileKey, "thumbnail:"+thumbnails[i].Size, func() (string, time.Time, error) {
+			return h.buildFileURL(ctx, categoryName, bucketName, thumbnailKey)
+		})
+		if err != nil {
+			h.logger.Warn("failed to build thumbnail URL", map[string]interface{}{
+				"handler":  h.Name,
+				"file_key": fileKey,
+				"size":     thumbnails[i].Size,
+				"error":    err,
+			})
+			continue
+		}
+		thumbnails[i].URL = thumbnailURL
+	}
+}
+
+// cdnMiddleware returns the cdn middleware of a chain, nil when there is none
+func cdnMiddleware(chain *middleware.MiddlewareChain) *middleware.CDNMiddleware {
+	if chain == nil {
+		return nil
+	}
+	for _, m := range chain.Middlewares() {
+		if cdn, ok := m.(*middleware.CDNMiddleware); ok {
+			return cdn
+		}
+	}
+	return nil
+}
+
+// directURL returns the unsigned URL of an object
+func (h *Handler) directURL(config URLBuilderConfig, bucketName, fileKey string) (string, error) {
+	base := h.Client.EndpointURL()
+	if config.BaseURL != "" {
+		var err error
+		if base, err = url.Parse(config.BaseURL); err != nil {
+			return "", fmt.Errorf("invalid base URL: %w", err)
+		}
+	}
+
+	u := *base
+	switch config.PathStyle {
+	case URLStyleVirtualHost:
+		u.Host = bucketName + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + fileKey
+	case URLStyleKey:
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + fileKey
+	default:
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + bucketName + "/" + fileKey
+	}
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// joinURL appends a file key to a base URL, escaping it as needed
+func joinURL(base, fileKey string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %w", base, err)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + fileKey
+	u.RawPath = ""
+	return u.String(), nil
+}
@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// URLUploadConfig represents the limits of UploadFromURL
+type URLUploadConfig struct {
+	// MaxSize is the size of the largest file fetched, default 25MB; category and tenant limits apply as well
+	MaxSize int64 `json:"max_size,omitempty"`
+	// Timeout covers fetching and storing a file, default 30 seconds
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// MaxRedirects caps the requests of a fetch, like the limit of net/http, so at most
+	// MaxRedirects-1 redirects are followed; default 5
+	MaxRedirects int `json:"max_redirects,omitempty"`
+	// AllowPrivateNetworks lets URLs reach loopback, private and link-local addresses, e.g. for
+	// imports from internal services; never enable it for URLs supplied by users
+	AllowPrivateNetworks bool   `json:"allow_private_networks,omitempty"`
+	UserAgent            string `json:"user_agent,omitempty"` // default "storage-fetcher/1.0"
+}
+
+// withDefaults returns the configuration with defaults for unset values
+func (c URLUploadConfig) withDefaults() URLUploadConfig {
+	if c.MaxSize <= 0 {
+		c.MaxSize = 25 * 1024 * 1024
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = 30 * time.Second
+	}
+	if c.MaxRedirects <= 0 {
+		c.MaxRedirects = 5
+	}
+	if c.UserAgent == "" {
+		c.UserAgent = "storage-fetcher/1.0"
+	}
+	return c
+}
+
+// reservedNetworks are the networks besides loopback, private, link-local and multicast ones
+// fetches may not reach: this network, shared address space, IETF protocol assignments,
+// benchmarking and reserved addresses
+var reservedNetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+	return networks
+}()
+
+// publicAddress reports whether an IP address is routable on the internet
+func publicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsMulticast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// denyPrivateNetworks refuses connections to addresses that are not public
+// It checks the address actually dialed, after name resolution, so names resolving to internal
+// addresses and redirects to them are refused as well
+func denyPrivateNetworks(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicAddress(ip) {
+		return &errors.StorageError{Code: errors.ErrInvalidURL.Code, Message: "URL resolves to a non-public address " + host}
+	}
+	return nil
+}
+
+// urlUploadClient returns the HTTP client of fetches, without proxies, which would dial on its behalf
+func urlUploadClient(config URLUploadConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	if !config.AllowPrivateNetworks {
+		dialer.Control = denyPrivateNetworks
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= config.MaxRedirects {
+				return fmt.Errorf("stopped after %d requests", config.MaxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %s", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// UploadFromURL fetches a remote file and uploads it like Upload, e.g. to import the avatar of an
+// OAuth profile. FileData and FileSize of the request are ignored; ContentType and FileName
+// default to those of the response and the URL. Files larger than URLUploadConfig.MaxSize or the
+// category allows and types the category does not accept are refused before they are read
+// URLs resolving to loopback, private or link-local addresses are refused, see URLUploadConfig
+func (h *Handler) UploadFromURL(ctx context.Context, sourceURL string, req *interfaces.UploadRequest, opts ...Option) (*interfaces.UploadResponse, error) {
+	ctx, done, err := h.track(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	options := callOptions(opts)
+	ctx, cancel := callContext(ctx, options)
+	defer cancel()
+
+	source, err := url.Parse(sourceURL)
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+		return nil, &errors.StorageError{Code: errors.ErrInvalidURL.Code, Message: "Only absolute http and https URLs can be fetched"}
+	}
+
+	config := h.config().URLUpload.withDefaults()
+	limits, err := h.categoryUploadLimits(ctx, req.Category)
+	if err != nil {
+		return nil, err
+	}
+	maxSize := tighter(config.MaxSize, limits.maxSize)
+
+	// The timeout covers storing the file, whose data is read from the response
+	fetchCtx, cancelFetch := context.WithTimeout(ctx, config.Timeout)
+	defer cancelFetch()
+	fetchReq, err := http.NewRequestWithContext(fetchCtx, http.MethodGet, source.String(), nil)
+	if err != nil {
+		return nil, errors.Wrap(errors.ErrInvalidURL, err)
+	}
+	fetchReq.Header.Set("User-Agent", config.UserAgent)
+
+	client := urlUploadClient(config)
+	defer client.CloseIdleConnections()
+	resp, err := client.Do(fetchReq)
+	if err != nil {
+		var storageErr *errors.StorageError
+		if stderrors.As(err, &storageErr) {
+			return nil, storageErr
+		}
+		return nil, errors.Wrap(errors.ErrFetchFailed, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Wrap(errors.ErrFetchFailed, fmt.Errorf("%s answered %s", source.Host, resp.Status))
+	}
+
+	upload := *req
+	if upload.ContentType == "" {
+		upload.ContentType, _, _ = mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	}
+	if len(limits.types) > 0 && !slices.Contains(limits.types, upload.ContentType) {
+		return nil, &errors.StorageError{Code: errors.ErrUnsupportedType.Code, Message: fmt.Sprintf("Content type %s is not allowed, allowed types: %v", upload.ContentType, limits.types)}
+	}
+	if upload.FileName == "" {
+		upload.FileName = remoteFileName(resp, source)
+	}
+
+	tooLarge := &errors.StorageError{Code: errors.ErrFileTooLarge.Code, Message: fmt.Sprintf("File exceeds maximum allowed size %d", maxSize)}
+	if resp.ContentLength > maxSize {
+		return nil, tooLarge
+	}
+	if resp.ContentLength >= 0 {
+		upload.FileData = io.LimitReader(resp.Body, resp.ContentLength)
+		upload.FileSize = resp.ContentLength
+	} else {
+		// Sizes are needed before storing, responses without a length are read first
+		data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+		if err != nil {
+			return nil, errors.Wrap(errors.ErrFetchFailed, err)
+		}
+		if int64(len(data)) > maxSize {
+			return nil, tooLarge
+		}
+		upload.FileData = bytes.NewReader(data)
+		upload.FileSize = int64(len(data))
+	}
+
+	return h.upload(ctx, &upload, options, nil)
+}
+
+// remoteFileName returns the filename of a fetched file, from its Content-Disposition or the last
+// segment of the URL path
+func remoteFileName(resp *http.Response, source *url.URL) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+		return path.Base(params["filename"])
+	}
+	if name := path.Base(resp.Request.URL.Path); name != "." && name != "/" {
+		return name
+	}
+	return source.Hostname()
+}
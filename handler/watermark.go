@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/category"
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/requestid"
+	"github.com/darmawan01/storage/watermark"
+	"github.com/minio/minio-go/v7"
+)
+
+// fileWatermark returns the watermark of the category of a file served by an operation as
+// contentType, false when it is served unmarked
+func (h *Handler) fileWatermark(operation string, objInfo *minio.ObjectInfo, contentType string) (category.WatermarkConfig, bool) {
+	watermarker := h.config().Watermarker
+	categoryConfig, _, _ := h.category(h.fileKeyInfo(objInfo).Category)
+	if watermarker == nil || !categoryConfig.Watermark.Watermarks(operation) || !watermarker.Supports(contentType) {
+		return category.WatermarkConfig{}, false
+	}
+	return categoryConfig.Watermark, true
+}
+
+// watermarked reports whether a category watermarks files it serves, which are then never handed
+// out as URLs served by the backend unmarked
+func (h *Handler) watermarked(categoryName string) bool {
+	categoryConfig, _, _ := h.category(categoryName)
+	return categoryConfig.Watermark.Enabled
+}
+
+// errWatermarked returns the error refusing URLs of files of a watermarked category
+func errWatermarked(categoryName string) error {
+	return &errors.StorageError{Code: errors.ErrAccessDenied.Code, Message: "Files of category " + categoryName + " are watermarked and have no direct URL"}
+}
+
+// watermarkFile draws a watermark on the data of a file served to a user, returning the marked
+// file and its content type; files over the size limit are refused rather than served unmarked
+func (h *Handler) watermarkFile(ctx context.Context, config category.WatermarkConfig, fileKey, userID, contentType string, data io.Reader) ([]byte, string, error) {
+	maxSize := config.MaxSize
+	if maxSize <= 0 {
+		maxSize = 50 * 1024 * 1024
+	}
+	raw, err := io.ReadAll(io.LimitReader(data, maxSize+1))
+	if err != nil {
+		return nil, "", errors.Wrap(errors.ErrDownloadFailed, err)
+	}
+	if int64(len(raw)) > maxSize {
+		return nil, "", watermark.ErrTooLarge
+	}
+
+	marked, contentType, err := h.config().Watermarker.Apply(ctx, contentType, bytes.NewReader(raw), watermark.Mark{
+		Text:     watermarkText(ctx, config.Text, fileKey, userID),
+		Opacity:  config.Opacity,
+		Position: config.Position,
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to watermark %s: %w", fileKey, err)
+	}
+	return marked, contentType, nil
+}
+
+// watermarkDownload replaces the data of a download with its watermarked copy when its category
+// watermarks downloads; object is closed once the data is read
+func (h *Handler) watermarkDownload(ctx context.Context, req *interfaces.DownloadRequest, objInfo *minio.ObjectInfo, object io.Closer, resp *interfaces.DownloadResponse) error {
+	config, ok := h.fileWatermark("download", objInfo, resp.ContentType)
+	if !ok {
+		return nil
+	}
+	defer object.Close()
+	marked, contentType, err := h.watermarkFile(ctx, config, req.FileKey, req.UserID, resp.ContentType, resp.FileData)
+	if err != nil {
+		return err
+	}
+	resp.FileData = bytes.NewReader(marked)
+	resp.FileSize = int64(len(marked))
+	resp.ContentType = contentType
+	return nil
+}
+
+// watermarkText returns the text of a watermark with its placeholders replaced
+func watermarkText(ctx context.Context, template, fileKey, userID string) string {
+	if template == "" {
+		template = "{user_id} {date}"
+	}
+	if userID == "" {
+		userID = "anonymous"
+	}
+	return strings.NewReplacer(
+		"{user_id}", userID,
+		"{date}", time.Now().UTC().Format("2006-01-02"),
+		"{file_key}", fileKey,
+		"{request_id}", requestid.FromContext(ctx),
+	).Replace(template)
+}
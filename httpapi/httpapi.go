@@ -0,0 +1,674 @@
+// Package httpapi serves the operations of registry handlers over plain net/http, so
+// applications using the standard library, chi, echo or any other router get upload,
+// download, streaming, thumbnails, presigned URLs and batch endpoints without writing them
+//
+// Routes are relative to where the API is mounted, {handler} is a registered handler name
+// and file keys are passed as the key query parameter because they contain slashes:
+//
+//	POST   /{handler}/upload            multipart form: file, category, entity_type, entity_id, metadata (JSON)
+//	GET    /{handler}/files?key=        download, honoring conditional and Range headers
+//	DELETE /{handler}/files?key=        delete
+//	GET    /{handler}/stream?key=       the same, served inline
+//	GET    /{handler}/thumbnail?key=&size=150x150
+//	GET    /{handler}/list?entity_type=&entity_id=&category=&tag=key:value&limit=&offset=
+//	POST   /{handler}/query             JSON: interfaces.QueryRequest, searched in the handler's metadata store
+//	POST   /{handler}/search            JSON: interfaces.SearchRequest, full-text search of indexed documents
+//	GET    /{handler}/tags?key=         object tags of a file
+//	PUT    /{handler}/tags?key=         JSON: tags to set, e.g. {"status": "approved"}
+//	DELETE /{handler}/tags?key=&tag=    remove the named tags
+//	GET    /{handler}/access?key=       access history of a file, only for the user who uploaded it
+//	POST   /{handler}/presign           JSON: file_key, action (GET or PUT), expires_in (seconds)
+//	POST   /{handler}/batch/upload      multipart form: files, category, entity_type, entity_id
+//	POST   /{handler}/batch/delete      JSON: file_keys
+//
+// Mount it with http.StripPrefix, e.g. mux.Handle("/files/", http.StripPrefix("/files", api))
+package httpapi
+
+import (
+	"encoding/json"
+	stderrors "errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/auth"
+	"github.com/darmawan01/storage/clientinfo"
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/handler"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/registry"
+	"github.com/darmawan01/storage/requestid"
+)
+
+// Config represents HTTP API configuration
+type Config struct {
+	// UserID returns the user a request acts for, by default the identity set by auth.Authenticator.Middleware
+	UserID func(r *http.Request) string `json:"-"`
+	// MaxUploadSize limits request bodies of uploads, default 100MB
+	MaxUploadSize int64 `json:"max_upload_size,omitempty"`
+	// MaxMemory is the part of multipart forms kept in memory, the rest is buffered in temporary files; default 32MB
+	MaxMemory int64 `json:"max_memory,omitempty"`
+	// PresignExpiry is the lifetime of presigned URLs without expires_in, default 15 minutes
+	PresignExpiry time.Duration `json:"presign_expiry,omitempty"`
+}
+
+// DefaultConfig returns the default HTTP API configuration
+func DefaultConfig() Config {
+	return Config{
+		MaxUploadSize: 100 * 1024 * 1024,
+		MaxMemory:     32 * 1024 * 1024,
+		PresignExpiry: 15 * time.Minute,
+	}
+}
+
+// API serves the handlers of a registry over HTTP
+type API struct {
+	registry *registry.Registry
+	config   Config
+}
+
+// New creates a new HTTP API for the handlers of a registry
+func New(registry *registry.Registry, config Config) *API {
+	defaults := DefaultConfig()
+	if config.UserID == nil {
+		config.UserID = func(r *http.Request) string {
+			return auth.UserID(r.Context())
+		}
+	}
+	if config.MaxUploadSize <= 0 {
+		config.MaxUploadSize = defaults.MaxUploadSize
+	}
+	if config.MaxMemory <= 0 {
+		config.MaxMemory = defaults.MaxMemory
+	}
+	if config.PresignExpiry <= 0 {
+		config.PresignExpiry = defaults.PresignExpiry
+	}
+
+	return &API{
+		registry: registry,
+		config:   config,
+	}
+}
+
+// ServeHTTP routes a request to the endpoint of its handler
+// Requests get an ID, the client's X-Request-ID when valid, returned in the response header and error bodies
+// Clients are those of clientinfo.FromRequest; behind proxies, mount the API behind clientinfo.Extractor.Middleware
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := requestid.FromContext(r.Context())
+	if id == "" {
+		id = requestid.FromRequest(r)
+		r = r.WithContext(requestid.WithID(r.Context(), id))
+	}
+	w.Header().Set(requestid.Header, id)
+	r = r.WithContext(clientinfo.WithInfo(r.Context(), clientinfo.FromRequest(r)))
+
+	name, route, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	h, err := a.registry.GetHandler(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	switch route {
+	case "upload":
+		a.allow(w, r, http.MethodPost, func() { a.upload(w, r, h) })
+	case "files":
+		if r.Method == http.MethodDelete {
+			a.delete(w, r, h)
+			return
+		}
+		a.allow(w, r, http.MethodGet, func() { a.download(w, r, h) })
+	case "stream":
+		a.allow(w, r, http.MethodGet, func() { a.stream(w, r, h) })
+	case "thumbnail":
+		a.allow(w, r, http.MethodGet, func() { a.thumbnail(w, r, h) })
+	case "list":
+		a.allow(w, r, http.MethodGet, func() { a.list(w, r, h) })
+	case "query":
+		a.allow(w, r, http.MethodPost, func() { a.query(w, r, h) })
+	case "search":
+		a.allow(w, r, http.MethodPost, func() { a.search(w, r, h) })
+	case "tags":
+		switch r.Method {
+		case http.MethodPut:
+			a.tag(w, r, h)
+		case http.MethodDelete:
+			a.untag(w, r, h)
+		default:
+			a.allow(w, r, http.MethodGet, func() { a.tags(w, r, h) })
+		}
+	case "access":
+		a.allow(w, r, http.MethodGet, func() { a.access(w, r, h) })
+	case "presign":
+		a.allow(w, r, http.MethodPost, func() { a.presign(w, r, h) })
+	case "batch/upload":
+		a.allow(w, r, http.MethodPost, func() { a.batchUpload(w, r, h) })
+	case "batch/delete":
+		a.allow(w, r, http.MethodPost, func() { a.batchDelete(w, r, h) })
+	default:
+		writeError(w, &errors.StorageError{Code: "NOT_FOUND", Message: "Unknown endpoint " + r.URL.Path})
+	}
+}
+
+// allow runs serve for requests with the given method and rejects others
+func (a *API) allow(w http.ResponseWriter, r *http.Request, method string, serve func()) {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		writeJSON(w, http.StatusMethodNotAllowed, errorBody{Error: "METHOD_NOT_ALLOWED", Message: "Method not allowed"})
+		return
+	}
+	serve()
+}
+
+// upload stores the file of a multipart form
+func (a *API) upload(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	r.Body = http.MaxBytesReader(w, r.Body, a.config.MaxUploadSize)
+	if err := r.ParseMultipartForm(a.config.MaxMemory); err != nil {
+		writeError(w, invalidRequest("Invalid multipart form", err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeError(w, invalidRequest("Missing file", err))
+		return
+	}
+	defer file.Close()
+
+	var metadata map[string]interface{}
+	if value := r.FormValue("metadata"); value != "" {
+		if err := json.Unmarshal([]byte(value), &metadata); err != nil {
+			writeError(w, invalidRequest("Invalid metadata", err))
+			return
+		}
+	}
+
+	resp, err := h.Upload(r.Context(), &interfaces.UploadRequest{
+		FileData:    file,
+		FileSize:    header.Size,
+		ContentType: partContentType(header.Header.Get("Content-Type")),
+		FileName:    header.Filename,
+		Category:    r.FormValue("category"),
+		EntityType:  r.FormValue("entity_type"),
+		EntityID:    r.FormValue("entity_id"),
+		UserID:      a.config.UserID(r),
+		Metadata:    metadata,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !resp.Success {
+		writeError(w, resp.Error)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, uploadResult(resp))
+}
+
+// download serves a file as an attachment, see handler.ServeFile
+func (a *API) download(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	fileKey, ok := requireKey(w, r)
+	if !ok {
+		return
+	}
+	if err := h.ServeFile(w, a.withUser(r), fileKey); err != nil {
+		writeError(w, err)
+	}
+}
+
+// delete removes a file
+func (a *API) delete(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	fileKey, ok := requireKey(w, r)
+	if !ok {
+		return
+	}
+
+	err := h.Delete(r.Context(), &interfaces.DeleteRequest{
+		FileKey: fileKey,
+		UserID:  a.config.UserID(r),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// stream serves a file inline, or the requested range of it with 206 Partial Content
+func (a *API) stream(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	fileKey, ok := requireKey(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Disposition", "inline")
+	if err := h.ServeFile(w, a.withUser(r), fileKey); err != nil {
+		w.Header().Del("Content-Disposition")
+		writeError(w, err)
+	}
+}
+
+// thumbnail serves a generated thumbnail of a file
+func (a *API) thumbnail(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	fileKey, ok := requireKey(w, r)
+	if !ok {
+		return
+	}
+	size := r.URL.Query().Get("size")
+	if size == "" {
+		size = "150x150"
+	}
+
+	resp, err := h.GetThumbnail(r.Context(), &interfaces.ThumbnailRequest{
+		FileKey: fileKey,
+		UserID:  a.config.UserID(r),
+		Size:    size,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer closeData(resp.FileData)
+
+	w.Header().Set("Content-Type", resp.ContentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(resp.FileSize, 10))
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.Copy(w, resp.FileData)
+}
+
+// list returns the files of an entity, tag parameters are key:value pairs files must have
+func (a *API) list(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	query := r.URL.Query()
+	req := &interfaces.ListRequest{
+		EntityType: query.Get("entity_type"),
+		EntityID:   query.Get("entity_id"),
+		Category:   query.Get("category"),
+		UserID:     a.config.UserID(r),
+	}
+	for _, tag := range query["tag"] {
+		key, value, _ := strings.Cut(tag, ":")
+		if req.Tags == nil {
+			req.Tags = make(map[string]string)
+		}
+		req.Tags[key] = value
+	}
+	req.Limit, _ = strconv.Atoi(query.Get("limit"))
+	req.Offset, _ = strconv.Atoi(query.Get("offset"))
+
+	resp, err := h.ListFiles(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// query searches the metadata records of a handler
+func (a *API) query(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	var req interfaces.QueryRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, invalidRequest("Invalid request body", err))
+		return
+	}
+	req.UserID = a.config.UserID(r)
+
+	resp, err := h.Query(r.Context(), &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// search searches the text of the documents of a handler
+func (a *API) search(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	var req interfaces.SearchRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, invalidRequest("Invalid request body", err))
+		return
+	}
+	req.UserID = a.config.UserID(r)
+
+	resp, err := h.Search(r.Context(), &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// tags returns the object tags of a file
+func (a *API) tags(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	fileKey, ok := requireKey(w, r)
+	if !ok {
+		return
+	}
+	fileTags, err := h.GetTags(r.Context(), fileKey, a.config.UserID(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tags": fileTags})
+}
+
+// access returns the access history of a file to its uploader
+func (a *API) access(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	fileKey, ok := requireKey(w, r)
+	if !ok {
+		return
+	}
+	stat, err := h.Stat(r.Context(), fileKey)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if uploadedBy := stat.UserMetadata["Uploaded-By"]; uploadedBy == "" || uploadedBy != a.config.UserID(r) {
+		writeError(w, errors.ErrAccessDenied)
+		return
+	}
+
+	history, err := h.GetAccessHistory(r.Context(), fileKey)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"access": history})
+}
+
+// tag sets the object tags of the JSON body on a file
+func (a *API) tag(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	fileKey, ok := requireKey(w, r)
+	if !ok {
+		return
+	}
+	var fileTags map[string]string
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&fileTags); err != nil {
+		writeError(w, invalidRequest("Invalid request body", err))
+		return
+	}
+	if err := h.Tag(r.Context(), fileKey, a.config.UserID(r), fileTags); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// untag removes the tags named by the tag parameters from a file
+func (a *API) untag(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	fileKey, ok := requireKey(w, r)
+	if !ok {
+		return
+	}
+	if err := h.Untag(r.Context(), fileKey, a.config.UserID(r), r.URL.Query()["tag"]...); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// presignRequest represents the body of presign requests
+type presignRequest struct {
+	FileKey   string `json:"file_key"`
+	Action    string `json:"action"`
+	ExpiresIn int    `json:"expires_in,omitempty"` // Seconds
+	// Upload constraints of PUT and POST URLs, see interfaces.PresignedURLRequest
+	ContentType string `json:"content_type,omitempty"`
+	MaxSize     int64  `json:"max_size,omitempty"`
+	// Response header overrides of GET URLs, see interfaces.PresignedURLRequest
+	ResponseContentDisposition string `json:"response_content_disposition,omitempty"`
+	ResponseContentType        string `json:"response_content_type,omitempty"`
+	Attachment                 bool   `json:"attachment,omitempty"`
+}
+
+// presign returns a presigned URL for a file
+func (a *API) presign(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	var req presignRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, invalidRequest("Invalid request body", err))
+		return
+	}
+	if req.FileKey == "" {
+		writeError(w, &errors.StorageError{Code: "INVALID_REQUEST", Message: "file_key is required"})
+		return
+	}
+	if req.Action == "" {
+		req.Action = http.MethodGet
+	}
+	expires := a.config.PresignExpiry
+	if req.ExpiresIn > 0 {
+		expires = time.Duration(req.ExpiresIn) * time.Second
+	}
+
+	resp, err := h.GeneratePresignedURL(r.Context(), &interfaces.PresignedURLRequest{
+		FileKey: req.FileKey,
+		UserID:  a.config.UserID(r),
+		Expires: expires,
+		Action:  strings.ToUpper(req.Action),
+
+		ResponseContentDisposition: req.ResponseContentDisposition,
+		ResponseContentType:        req.ResponseContentType,
+		Attachment:                 req.Attachment,
+		ContentType:                req.ContentType,
+		MaxSize:                    req.MaxSize,
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"url":        resp.URL,
+		"headers":    resp.Headers,
+		"form_data":  resp.FormData,
+		"expires_at": resp.ExpiresAt,
+	})
+}
+
+// batchUpload stores all files of a multipart form
+func (a *API) batchUpload(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	r.Body = http.MaxBytesReader(w, r.Body, a.config.MaxUploadSize)
+	if err := r.ParseMultipartForm(a.config.MaxMemory); err != nil {
+		writeError(w, invalidRequest("Invalid multipart form", err))
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	headers := r.MultipartForm.File["files"]
+	files := make([]interfaces.BatchFile, 0, len(headers))
+	for _, header := range headers {
+		file, err := header.Open()
+		if err != nil {
+			writeError(w, invalidRequest("Invalid file "+header.Filename, err))
+			return
+		}
+		defer file.Close()
+
+		files = append(files, interfaces.BatchFile{
+			FileData:    file,
+			FileName:    header.Filename,
+			ContentType: partContentType(header.Header.Get("Content-Type")),
+			FileSize:    header.Size,
+			Category:    r.FormValue("category"),
+		})
+	}
+
+	resp, err := h.BatchUpload(r.Context(), &interfaces.BatchUploadRequest{
+		Files:      files,
+		EntityType: r.FormValue("entity_type"),
+		EntityID:   r.FormValue("entity_id"),
+		UserID:     a.config.UserID(r),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if resp.Error != nil {
+		writeError(w, resp.Error)
+		return
+	}
+
+	results := make([]interface{}, len(resp.Results))
+	for i, result := range resp.Results {
+		if result.Success {
+			results[i] = uploadResult(result)
+		} else {
+			results[i] = map[string]interface{}{"success": false, "error": newErrorBody(result.Error)}
+		}
+	}
+	writeJSON(w, batchStatus(resp.SuccessCount, resp.TotalCount), map[string]interface{}{
+		"results":       results,
+		"success_count": resp.SuccessCount,
+		"total_count":   resp.TotalCount,
+	})
+}
+
+// batchDeleteRequest represents the body of batch delete requests
+type batchDeleteRequest struct {
+	FileKeys []string `json:"file_keys"`
+}
+
+// batchDelete removes several files
+func (a *API) batchDelete(w http.ResponseWriter, r *http.Request, h *handler.Handler) {
+	var req batchDeleteRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeError(w, invalidRequest("Invalid request body", err))
+		return
+	}
+
+	resp, err := h.BatchDelete(r.Context(), &interfaces.BatchDeleteRequest{
+		FileKeys: req.FileKeys,
+		UserID:   a.config.UserID(r),
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if resp.Error != nil {
+		writeError(w, resp.Error)
+		return
+	}
+
+	results := make([]interface{}, len(resp.Results))
+	for i, result := range resp.Results {
+		entry := map[string]interface{}{"file_key": req.FileKeys[i], "success": result.Success}
+		if !result.Success {
+			entry["error"] = newErrorBody(result.Error)
+		}
+		results[i] = entry
+	}
+	writeJSON(w, batchStatus(resp.SuccessCount, resp.TotalCount), map[string]interface{}{
+		"results":       results,
+		"success_count": resp.SuccessCount,
+		"total_count":   resp.TotalCount,
+	})
+}
+
+// uploadResult returns the JSON body of a stored file
+func uploadResult(resp *interfaces.UploadResponse) map[string]interface{} {
+	return map[string]interface{}{
+		"success":      true,
+		"file_key":     resp.FileKey,
+		"file_url":     resp.FileURL,
+		"file_size":    resp.FileSize,
+		"content_type": resp.ContentType,
+		"metadata":     resp.Metadata,
+		"thumbnails":   resp.Thumbnails,
+		"request_id":   resp.RequestID,
+	}
+}
+
+// batchStatus returns 200 when all files succeeded and 207 Multi-Status otherwise
+func batchStatus(successCount, totalCount int) int {
+	if successCount == totalCount {
+		return http.StatusOK
+	}
+	return http.StatusMultiStatus
+}
+
+// requireKey returns the key query parameter, writing an error when it is missing
+func requireKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	fileKey := r.URL.Query().Get("key")
+	if fileKey == "" {
+		writeError(w, &errors.StorageError{Code: "INVALID_REQUEST", Message: "key is required"})
+		return "", false
+	}
+	return fileKey, true
+}
+
+// partContentType returns the content type of a multipart file
+func partContentType(contentType string) string {
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+	return contentType
+}
+
+// withUser returns the request with the user of Config.UserID as its authenticated user, which
+// handler.ServeFile reads
+func (a *API) withUser(r *http.Request) *http.Request {
+	userID := a.config.UserID(r)
+	if userID == auth.UserID(r.Context()) {
+		return r
+	}
+	identity := auth.Identity{UserID: userID}
+	if current, ok := auth.FromContext(r.Context()); ok {
+		identity = *current
+		identity.UserID = userID
+	}
+	return r.WithContext(auth.WithIdentity(r.Context(), &identity))
+}
+
+// closeData closes response data that holds a connection
+func closeData(data io.Reader) {
+	if closer, ok := data.(io.Closer); ok {
+		closer.Close()
+	}
+}
+
+// invalidRequest returns a 400 error carrying the cause in its details
+func invalidRequest(message string, err error) error {
+	var tooLarge *http.MaxBytesError
+	if stderrors.As(err, &tooLarge) {
+		return &errors.StorageError{Code: errors.ErrFileTooLarge.Code, Message: errors.ErrFileTooLarge.Message, Details: err.Error()}
+	}
+	return &errors.StorageError{Code: "INVALID_REQUEST", Message: message, Details: err.Error()}
+}
+
+// errorBody represents JSON error responses
+type errorBody struct {
+	Error     string `json:"error"`
+	Message   string `json:"message"`
+	Details   string `json:"details,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// newErrorBody returns the JSON body of an error, internal errors and the causes of server errors are not exposed
+func newErrorBody(err error) errorBody {
+	var storageErr *errors.StorageError
+	if !stderrors.As(err, &storageErr) {
+		return errorBody{Error: "INTERNAL_ERROR", Message: "Internal error"}
+	}
+	body := errorBody{Error: storageErr.Code, Message: storageErr.Message, Details: storageErr.Details}
+	if errors.ErrorToHTTPStatus(storageErr) >= http.StatusInternalServerError {
+		body.Details = ""
+	}
+	return body
+}
+
+// writeError writes an error response with the status matching its code, see errors.ErrorToHTTPStatus
+func writeError(w http.ResponseWriter, err error) {
+	body := newErrorBody(err)
+	body.RequestID = w.Header().Get(requestid.Header)
+	writeJSON(w, errors.ErrorToHTTPStatus(err), body)
+}
+
+// writeJSON writes a JSON response
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -3,15 +3,20 @@ package interfaces
 import (
 	"context"
 	"io"
+	"slices"
+	"strings"
 	"time"
 )
 
 // StorageClient defines the main interface for storage operations
 type StorageClient interface {
 	// Basic operations
-	Upload(ctx context.Context, req *UploadRequest) (*UploadResponse, error)
-	Download(ctx context.Context, req *DownloadRequest) (*DownloadResponse, error)
-	Delete(ctx context.Context, req *DeleteRequest) error
+	Upload(ctx context.Context, req *UploadRequest, opts ...Option) (*UploadResponse, error)
+	Download(ctx context.Context, req *DownloadRequest, opts ...Option) (*DownloadResponse, error)
+	Delete(ctx context.Context, req *DeleteRequest, opts ...Option) error
+	Replace(ctx context.Context, req *ReplaceRequest, opts ...Option) (*UploadResponse, error)
+	Commit(ctx context.Context, fileKey string) (*UploadResponse, error)
+	Rollback(ctx context.Context, fileKey string) error
 
 	// Preview operations
 	Preview(ctx context.Context, req *PreviewRequest) (*PreviewResponse, error)
@@ -19,17 +24,213 @@ type StorageClient interface {
 
 	// Security operations
 	GeneratePresignedURL(ctx context.Context, req *PresignedURLRequest) (*PresignedURLResponse, error)
+	PresignUpload(ctx context.Context, req *DirectUploadRequest) (*DirectUploadResponse, error)
+	ConfirmUpload(ctx context.Context, fileKey string, opts ...Option) (*UploadResponse, error)
 
 	// Management operations
 	ListFiles(ctx context.Context, req *ListRequest) (*ListResponse, error)
 	GetFileInfo(ctx context.Context, req *InfoRequest) (*FileInfo, error)
+	Stat(ctx context.Context, fileKey string) (*FileStat, error)
 	UpdateMetadata(ctx context.Context, req *UpdateMetadataRequest) error
 }
 
+// CallOptions holds the overrides of a single Upload, Download or Delete call, set by the With
+// options of the handler package
+type CallOptions struct {
+	Timeout           time.Duration
+	Bucket            string
+	Metadata          map[string]string
+	DisableThumbnails bool
+	IPAddress         string
+	UserAgent         string
+	FileKey           string // Key of the upload instead of a generated one
+	IfNotExists       bool   // Fail an upload whose key exists
+	IfMatch           string // ETag the overwritten file must have
+	Staged            bool   // Keep an upload in the staging area until it is committed
+}
+
+// Option sets an override of a call
+type Option func(*CallOptions)
+
 // MetadataCallback defines a simple callback for storing file metadata after upload
 // This allows users to store metadata in their preferred storage system (database, Redis, etc.)
 type MetadataCallback func(ctx context.Context, metadata *FileMetadata) error
 
+// DeleteCallback is called after a file was deleted, userID is empty for deletions of the handler
+type DeleteCallback func(ctx context.Context, fileKey, userID string) error
+
+// AccessCallback is called after a file was read
+type AccessCallback func(ctx context.Context, record *AccessRecord) error
+
+// MetadataStore keeps a metadata record per file, e.g. in a database
+// Unlike MetadataCallback, records are removed with their files and can be listed,
+// which reconciliation uses to find objects without records and the other way around
+type MetadataStore interface {
+	Save(ctx context.Context, metadata *FileMetadata) error
+	// Get returns errors.ErrFileNotFound when there is no record
+	Get(ctx context.Context, fileKey string) (*FileMetadata, error)
+	Delete(ctx context.Context, fileKey string) error
+	// List calls fn for every record with a file key under prefix, stopping at the first error
+	List(ctx context.Context, prefix string, fn func(metadata *FileMetadata) error) error
+}
+
+// MetadataQuerier is implemented by metadata stores that search their records themselves,
+// e.g. with database indexes; other stores are searched by listing their records
+// Page tokens are opaque to callers and only valid for the store that returned them
+type MetadataQuerier interface {
+	Query(ctx context.Context, query *QueryRequest) (*QueryResponse, error)
+}
+
+// Sort orders of metadata queries
+const (
+	SortByUploadedAt = "uploaded_at" // Default
+	SortByFileSize   = "file_size"
+	SortByFileName   = "file_name"
+	SortByFileKey    = "file_key"
+)
+
+// AccessLog keeps the reads of files (downloads, streams, previews), e.g. in a database
+// Unlike audit logs the records can be looked up per file; they are removed with their files
+type AccessLog interface {
+	Record(ctx context.Context, record *AccessRecord) error
+	// History returns the records of a file, newest first
+	History(ctx context.Context, fileKey string) ([]AccessRecord, error)
+	Delete(ctx context.Context, fileKey string) error
+}
+
+// AccessLogEraser is implemented by access logs that can remove the reads of a user, e.g. for GDPR
+// erasures; the reads of files of other logs are only removed with the files
+type AccessLogEraser interface {
+	// DeleteUser removes the records of a user's reads of files under a key prefix
+	DeleteUser(ctx context.Context, prefix, userID string) error
+}
+
+// Access operations of AccessRecord
+const (
+	AccessDownload = "download"
+	AccessStream   = "stream"
+	AccessPreview  = "preview"
+)
+
+// AccessRecord is a single read of a file
+type AccessRecord struct {
+	FileKey   string    `json:"file_key"`
+	UserID    string    `json:"user_id,omitempty"`
+	Operation string    `json:"operation"`
+	Start     int64     `json:"start"` // First byte served
+	End       int64     `json:"end"`   // Last byte served, inclusive
+	IPAddress string    `json:"ip_address,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// ShareStore keeps the shares of files and entities, e.g. in a database
+type ShareStore interface {
+	Save(ctx context.Context, share *Share) error
+	// Get returns errors.ErrShareNotFound when there is no share with the ID
+	Get(ctx context.Context, id string) (*Share, error)
+	Delete(ctx context.Context, id string) error
+	// List returns the shares matching a query, expired ones included, oldest first
+	List(ctx context.Context, query ShareQuery) ([]Share, error)
+}
+
+// Permissions of shares
+const (
+	ShareRead  = "read"  // Download, preview and stream
+	ShareWrite = "write" // Read, and upload new versions, append and patch; not delete
+)
+
+// groupGranteePrefix marks grantees that are groups
+const groupGranteePrefix = "group:"
+
+// GroupGrantee returns the grantee of a share with the members of a group, the users having the
+// group as a role
+func GroupGrantee(group string) string {
+	return groupGranteePrefix + group
+}
+
+// Share grants a user or group access to a file, or to all files under a key prefix
+type Share struct {
+	ID         string    `json:"id"`
+	Target     string    `json:"target"`  // File key, or key prefix ending with "/", e.g. the files of an entity
+	Grantee    string    `json:"grantee"` // User ID, or GroupGrantee
+	Permission string    `json:"permission"`
+	GrantedBy  string    `json:"granted_by,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"` // Zero for shares that do not expire
+}
+
+// Covers reports whether the share applies to a file
+func (s *Share) Covers(fileKey string) bool {
+	if strings.HasSuffix(s.Target, "/") {
+		return strings.HasPrefix(fileKey, s.Target)
+	}
+	return fileKey == s.Target
+}
+
+// Expired reports whether the share has expired at now
+func (s *Share) Expired(now time.Time) bool {
+	return !s.ExpiresAt.IsZero() && !now.Before(s.ExpiresAt)
+}
+
+// Grants reports whether the share is for a user ID or one of the groups
+func (s *Share) Grants(userID string, groups []string) bool {
+	if group, ok := strings.CutPrefix(s.Grantee, groupGranteePrefix); ok {
+		return slices.Contains(groups, group)
+	}
+	return userID != "" && s.Grantee == userID
+}
+
+// ShareQuery represents the filters of a share listing, empty fields match all shares
+type ShareQuery struct {
+	FileKey string `json:"file_key,omitempty"` // Shares covering a file, on it or a prefix of it
+	Prefix  string `json:"prefix,omitempty"`   // Shares whose target is under a key prefix
+	Grantee string `json:"grantee,omitempty"`
+}
+
+// Matches reports whether a share matches the query
+func (q ShareQuery) Matches(share *Share) bool {
+	if q.FileKey != "" && !share.Covers(q.FileKey) {
+		return false
+	}
+	if q.Prefix != "" && !strings.HasPrefix(share.Target, q.Prefix) {
+		return false
+	}
+	return q.Grantee == "" || share.Grantee == q.Grantee
+}
+
+// LinkStore keeps public links, e.g. in a database
+type LinkStore interface {
+	Save(ctx context.Context, link *PublicLink) error
+	// Get returns errors.ErrLinkNotFound when there is no link with the token
+	Get(ctx context.Context, token string) (*PublicLink, error)
+	Delete(ctx context.Context, token string) error
+	// List returns the links of a file, oldest first
+	List(ctx context.Context, fileKey string) ([]PublicLink, error)
+	// Increment counts a download of a link unless max (0 for no limit) downloads are counted
+	// already, reporting whether it was counted; concurrent calls must not exceed max
+	Increment(ctx context.Context, token string, max int64) (bool, error)
+}
+
+// PublicLink is a revocable link to a file, usable without credentials
+type PublicLink struct {
+	Token        string    `json:"token"`
+	Handler      string    `json:"handler"`
+	FileKey      string    `json:"file_key"`
+	URL          string    `json:"url,omitempty"`
+	CreatedBy    string    `json:"created_by,omitempty"` // Downloads are authorized for this user
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"` // Zero for links that do not expire
+	PasswordHash string    `json:"password_hash,omitempty"`
+	MaxDownloads int64     `json:"max_downloads,omitempty"` // 0 for no limit
+	Downloads    int64     `json:"downloads"`
+}
+
+// Expired reports whether the link has expired at now
+func (l *PublicLink) Expired(now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && !now.Before(l.ExpiresAt)
+}
+
 // Request/Response structures
 type UploadRequest struct {
 	FileData    io.Reader              `json:"-"`
@@ -41,7 +242,47 @@ type UploadRequest struct {
 	EntityID    string                 `json:"entity_id"`
 	UserID      string                 `json:"user_id"`
 	Metadata    map[string]interface{} `json:"metadata"`
-	Config      map[string]interface{} `json:"config"`
+	Config      map[string]interface{} `json:"config"` // Passed to middlewares as is, prefer the handler options, e.g. WithClient
+}
+
+// ReplaceRequest writes a new version of a stored file under its key
+type ReplaceRequest struct {
+	FileKey     string                 `json:"file_key"`
+	FileData    io.Reader              `json:"-"`
+	FileSize    int64                  `json:"file_size"`
+	ContentType string                 `json:"content_type,omitempty"` // Content type of the stored file when empty
+	FileName    string                 `json:"file_name,omitempty"`    // Original file name of the stored file when empty
+	UserID      string                 `json:"user_id"`
+	Metadata    map[string]interface{} `json:"metadata"`
+}
+
+// AppendRequest adds data to the end of a stored file
+type AppendRequest struct {
+	FileKey  string    `json:"file_key"`
+	FileData io.Reader `json:"-"`
+	FileSize int64     `json:"file_size"` // Size of FileData, required
+	UserID   string    `json:"user_id"`
+}
+
+type AppendResponse struct {
+	FileKey  string `json:"file_key"`
+	FileSize int64  `json:"file_size"` // Size of the file with the appended data
+	ETag     string `json:"etag"`
+}
+
+// PatchRangeRequest overwrites a region of a stored file
+type PatchRangeRequest struct {
+	FileKey  string    `json:"file_key"`
+	Offset   int64     `json:"offset"` // Position of the first written byte, at most the file size
+	FileData io.Reader `json:"-"`
+	FileSize int64     `json:"file_size"` // Size of FileData, required
+	UserID   string    `json:"user_id"`
+}
+
+type PatchRangeResponse struct {
+	FileKey  string `json:"file_key"`
+	FileSize int64  `json:"file_size"` // Size of the patched file
+	ETag     string `json:"etag"`
 }
 
 type UploadResponse struct {
@@ -52,21 +293,32 @@ type UploadResponse struct {
 	ContentType string                 `json:"content_type"`
 	Metadata    map[string]interface{} `json:"metadata"`
 	Thumbnails  []ThumbnailInfo        `json:"thumbnails,omitempty"`
+	Blurhash    string                 `json:"blurhash,omitempty"`   // Placeholder of images, see PreviewConfig.Placeholder
+	Staged      bool                   `json:"staged,omitempty"`     // Visible once committed, see WithStaging
+	RequestID   string                 `json:"request_id,omitempty"` // Request of the upload, in its audit events, jobs and errors
 	Error       error                  `json:"error,omitempty"`
 }
 
 type DownloadRequest struct {
 	FileKey string `json:"file_key"`
 	UserID  string `json:"user_id"`
+	// Validators of the client's copy, from ETag and LastModified of an earlier response; when it
+	// is current the response has NotModified set and no data. IfNoneMatch takes precedence
+	IfNoneMatch     string    `json:"if_none_match,omitempty"` // e.g. "9b2cf535f27731c974343645a3985328", * matches any
+	IfModifiedSince time.Time `json:"if_modified_since,omitempty"`
 }
 
 type DownloadResponse struct {
-	Success     bool                   `json:"success"`
-	FileData    io.Reader              `json:"-"`
-	FileSize    int64                  `json:"file_size"`
-	ContentType string                 `json:"content_type"`
-	Metadata    map[string]interface{} `json:"metadata"`
-	Error       error                  `json:"error,omitempty"`
+	Success      bool                   `json:"success"`
+	NotModified  bool                   `json:"not_modified,omitempty"` // The client's copy is current, FileData is nil
+	FileData     io.Reader              `json:"-"`
+	FileSize     int64                  `json:"file_size"`
+	ContentType  string                 `json:"content_type"`
+	ETag         string                 `json:"etag"` // Quoted, as in HTTP headers
+	LastModified time.Time              `json:"last_modified"`
+	Metadata     map[string]interface{} `json:"metadata"`
+	RequestID    string                 `json:"request_id,omitempty"` // Request of the download, in its audit events and errors
+	Error        error                  `json:"error,omitempty"`
 }
 
 type DeleteRequest struct {
@@ -108,40 +360,124 @@ type StreamRequest struct {
 	FileKey string `json:"file_key"`
 	UserID  string `json:"user_id"`
 	Range   string `json:"range,omitempty"` // HTTP Range header
+	// Validators of the client's copy, as in DownloadRequest
+	IfNoneMatch     string    `json:"if_none_match,omitempty"`
+	IfModifiedSince time.Time `json:"if_modified_since,omitempty"`
+	// File of the HLS rendition ladder of a transcoded video instead of the video, e.g. master.m3u8,
+	// 720p/index.m3u8 or 720p/segment_0001.ts; playlists refer to the others relative to themselves
+	Path string `json:"path,omitempty"`
 }
 
 type StreamResponse struct {
-	Success     bool                   `json:"success"`
-	FileData    io.Reader              `json:"-"`
-	FileSize    int64                  `json:"file_size"`
-	ContentType string                 `json:"content_type"`
-	Range       string                 `json:"range,omitempty"`
-	Metadata    map[string]interface{} `json:"metadata"`
-	Error       error                  `json:"error,omitempty"`
+	Success       bool                   `json:"success"`
+	NotModified   bool                   `json:"not_modified,omitempty"` // The client's copy is current, FileData is nil
+	FileData      io.Reader              `json:"-"`
+	FileSize      int64                  `json:"file_size"`               // Size of the whole file
+	ContentLength int64                  `json:"content_length"`          // Size of FileData
+	ContentRange  string                 `json:"content_range,omitempty"` // Content-Range of partial content, e.g. bytes 0-1023/4096
+	ContentType   string                 `json:"content_type"`
+	ETag          string                 `json:"etag"` // Quoted, as in HTTP headers
+	LastModified  time.Time              `json:"last_modified"`
+	Range         string                 `json:"range,omitempty"`
+	Metadata      map[string]interface{} `json:"metadata"`
+	Error         error                  `json:"error,omitempty"`
 }
 
 type PresignedURLRequest struct {
 	FileKey string        `json:"file_key"`
 	UserID  string        `json:"user_id"`
 	Expires time.Duration `json:"expires"`
-	Action  string        `json:"action"` // "GET", "PUT", "DELETE"
+	Action  string        `json:"action"` // "GET", "PUT", "POST"
+	// Constraints of uploads with PUT and POST URLs, within what the category of the file allows
+	ContentType string `json:"content_type,omitempty"` // Signed into the URL, default the type of the stored file when the category restricts types
+	MaxSize     int64  `json:"max_size,omitempty"`     // POST only, default the largest size the category allows
+	// Headers of the response to GET URLs, overriding those stored with the file
+	ResponseContentDisposition string `json:"response_content_disposition,omitempty"` // e.g. attachment; filename="report.pdf"
+	ResponseContentType        string `json:"response_content_type,omitempty"`
+	// Attachment sets ResponseContentDisposition to an attachment with the original filename,
+	// so browsers download the file under the name it was uploaded with
+	Attachment bool `json:"attachment,omitempty"`
 }
 
 type PresignedURLResponse struct {
 	Success   bool                   `json:"success"`
 	URL       string                 `json:"url"`
+	Headers   map[string]string      `json:"headers,omitempty"`   // Headers the client must send with the request, e.g. for server-side encryption
+	FormData  map[string]string      `json:"form_data,omitempty"` // Fields of POST uploads, sent before the file field
 	ExpiresAt time.Time              `json:"expires_at"`
 	Metadata  map[string]interface{} `json:"metadata"`
 	Error     error                  `json:"error,omitempty"`
 }
 
+// DirectUploadRequest asks for a URL uploading a new file straight to the backend
+type DirectUploadRequest struct {
+	FileName    string        `json:"file_name"`
+	ContentType string        `json:"content_type"` // Signed into the URL, required when the category restricts types
+	Category    string        `json:"category"`
+	EntityType  string        `json:"entity_type"`
+	EntityID    string        `json:"entity_id"`
+	UserID      string        `json:"user_id"`
+	Expires     time.Duration `json:"expires,omitempty"` // Default 15 minutes
+}
+
+type DirectUploadResponse struct {
+	Success   bool              `json:"success"`
+	FileKey   string            `json:"file_key"` // Key of the file once it is processed
+	URL       string            `json:"url"`
+	Headers   map[string]string `json:"headers"` // Headers the client must send with the PUT request
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// UploadManifestRequest asks for direct upload URLs of several files of an entity at once, e.g.
+// the photos a mobile client sends; see Handler.CreateUploadManifest
+type UploadManifestRequest struct {
+	Files      []ManifestFile `json:"files"`
+	Category   string         `json:"category"`
+	EntityType string         `json:"entity_type"`
+	EntityID   string         `json:"entity_id"`
+	UserID     string         `json:"user_id"`
+	Expires    time.Duration  `json:"expires,omitempty"` // Lifetime of the upload URLs, default 15 minutes
+}
+
+// ManifestFile describes a file a client intends to upload
+type ManifestFile struct {
+	FileName    string `json:"file_name"`
+	FileSize    int64  `json:"file_size"`
+	ContentType string `json:"content_type"`
+}
+
+// UploadManifest holds the upload URLs of the files of a manifest request, in its order
+type UploadManifest struct {
+	Success bool                   `json:"success"`
+	ID      string                 `json:"id"`
+	Uploads []DirectUploadResponse `json:"uploads"`
+	// CompletionToken signs the keys and sizes of the files, the client posts it back once all are uploaded
+	CompletionToken string    `json:"completion_token"`
+	ExpiresAt       time.Time `json:"expires_at"` // Of the upload URLs
+}
+
+type DownloadTokenRequest struct {
+	FileKey string        `json:"file_key"`
+	UserID  string        `json:"user_id"` // Bound to the token, empty for tokens usable by anyone holding them
+	Expires time.Duration `json:"expires"` // Defaults to the handler's token expiry
+}
+
+type DownloadTokenResponse struct {
+	Success   bool      `json:"success"`
+	Token     string    `json:"token"`
+	URL       string    `json:"url,omitempty"` // Set when the handler has a token BaseURL
+	ExpiresAt time.Time `json:"expires_at"`
+	Error     error     `json:"error,omitempty"`
+}
+
 type ListRequest struct {
 	EntityType string            `json:"entity_type"`
 	EntityID   string            `json:"entity_id"`
 	Category   string            `json:"category,omitempty"`
 	UserID     string            `json:"user_id"`
-	Filters    map[string]string `json:"filters,omitempty"`
-	Limit      int               `json:"limit,omitempty"`
+	Filters    map[string]string `json:"filters,omitempty"` // User metadata values, e.g. "uploaded-by"
+	Tags       map[string]string `json:"tags,omitempty"`    // Object tags files must have
+	Limit      int               `json:"limit,omitempty"`   // Default 100
 	Offset     int               `json:"offset,omitempty"`
 }
 
@@ -159,10 +495,73 @@ type InfoRequest struct {
 	UserID  string `json:"user_id"`
 }
 
+// QueryRequest searches the records of a MetadataStore, unset fields match every record
+type QueryRequest struct {
+	Prefix         string            `json:"prefix,omitempty"`    // File key prefix
+	Namespace      string            `json:"namespace,omitempty"` // Name of the handler that stored the file
+	EntityType     string            `json:"entity_type,omitempty"`
+	EntityID       string            `json:"entity_id,omitempty"`
+	Category       string            `json:"category,omitempty"`
+	ContentType    string            `json:"content_type,omitempty"` // Exact type, or a family ending in "/" such as "image/"
+	Tags           map[string]string `json:"tags,omitempty"`         // Object tags files must have
+	UploadedBy     string            `json:"uploaded_by,omitempty"`
+	UploadedAfter  time.Time         `json:"uploaded_after,omitempty"`  // Inclusive
+	UploadedBefore time.Time         `json:"uploaded_before,omitempty"` // Exclusive
+	MinSize        int64             `json:"min_size,omitempty"`
+	MaxSize        int64             `json:"max_size,omitempty"`
+	SortBy         string            `json:"sort_by,omitempty"` // SortByUploadedAt, SortByFileSize, SortByFileName or SortByFileKey
+	Descending     bool              `json:"descending,omitempty"`
+	Limit          int               `json:"limit,omitempty"`      // Default 100
+	PageToken      string            `json:"page_token,omitempty"` // NextPageToken of the previous page
+	UserID         string            `json:"user_id,omitempty"`    // Only files the user may read are returned
+}
+
+type QueryResponse struct {
+	Success       bool           `json:"success"`
+	Files         []FileMetadata `json:"files"`
+	NextPageToken string         `json:"next_page_token,omitempty"` // Empty on the last page
+	Error         error          `json:"error,omitempty"`
+}
+
+// SearchRequest searches the text of indexed documents, files must contain all words of Query
+type SearchRequest struct {
+	Query      string `json:"query"`
+	Prefix     string `json:"prefix,omitempty"`    // File key prefix
+	Namespace  string `json:"namespace,omitempty"` // Name of the handler that stored the file
+	Category   string `json:"category,omitempty"`
+	EntityType string `json:"entity_type,omitempty"`
+	EntityID   string `json:"entity_id,omitempty"`
+	Limit      int    `json:"limit,omitempty"` // Default 20
+	Offset     int    `json:"offset,omitempty"`
+	UserID     string `json:"user_id,omitempty"` // Only files the user may read are found
+}
+
+type SearchHit struct {
+	FileKey     string   `json:"file_key"`
+	FileName    string   `json:"file_name,omitempty"`
+	ContentType string   `json:"content_type,omitempty"`
+	Score       float64  `json:"score"`
+	Highlights  []string `json:"highlights,omitempty"` // Snippets with matches wrapped in <em></em>
+}
+
+type SearchResponse struct {
+	Success bool        `json:"success"`
+	Hits    []SearchHit `json:"hits"`
+	Total   int         `json:"total"`
+	Error   error       `json:"error,omitempty"`
+}
+
 type UpdateMetadataRequest struct {
 	FileKey  string                 `json:"file_key"`
 	UserID   string                 `json:"user_id"`
-	Metadata map[string]interface{} `json:"metadata"`
+	Metadata map[string]interface{} `json:"metadata"` // Values are stored as strings, nil removes a key
+}
+
+// ModerationRequest approves or rejects a file held by moderation
+type ModerationRequest struct {
+	FileKey string `json:"file_key"`
+	UserID  string `json:"user_id"` // The moderator
+	Reason  string `json:"reason,omitempty"`
 }
 
 // File metadata structure
@@ -173,15 +572,20 @@ type FileMetadata struct {
 	FileSize    int64           `json:"file_size"`
 	ContentType string          `json:"content_type"`
 	Namespace   string          `json:"namespace"`
+	Category    string          `json:"category,omitempty"`
 	EntityType  string          `json:"entity_type"`
 	EntityID    string          `json:"entity_id"`
 	UploadedBy  string          `json:"uploaded_by"`
 	UploadedAt  time.Time       `json:"uploaded_at"`
-	Tags        []string        `json:"tags"`
+	Tags        []string        `json:"tags"` // Object tags as "key=value"
 	Thumbnails  []ThumbnailInfo `json:"thumbnails"`
+	Blurhash    string          `json:"blurhash,omitempty"`
 	Version     int             `json:"version"`
 	Checksum    string          `json:"checksum"`
 	ExpiresAt   *time.Time      `json:"expires_at,omitempty"`
+	Audio       *AudioInfo      `json:"audio,omitempty"` // Set once audio files are analyzed
+	// Application metadata set through UpdateMetadata
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 type FileInfo struct {
@@ -196,10 +600,43 @@ type FileInfo struct {
 	UploadedBy  string                 `json:"uploaded_by"`
 	UploadedAt  time.Time              `json:"uploaded_at"`
 	Thumbnails  []ThumbnailInfo        `json:"thumbnails"`
-	URL         string                 `json:"url,omitempty"`
+	Blurhash    string                 `json:"blurhash,omitempty"`
+	Audio       *AudioInfo             `json:"audio,omitempty"`
+	URL         string                 `json:"url,omitempty"` // Files of public categories that passed their scan and moderation only
+	Tags        map[string]string      `json:"tags,omitempty"`
 	Metadata    map[string]interface{} `json:"metadata"`
 }
 
+// FileStat represents the stored attributes of a file, read without opening its data
+type FileStat struct {
+	FileKey      string            `json:"file_key"`
+	FileSize     int64             `json:"file_size"` // Original size, compressed and encrypted files are stored at another size
+	ContentType  string            `json:"content_type"`
+	ETag         string            `json:"etag"` // Quoted, as in HTTP headers
+	LastModified time.Time         `json:"last_modified"`
+	UserMetadata map[string]string `json:"user_metadata"` // e.g. Original-Filename, Uploaded-By
+}
+
+// AudioInfo represents the properties of an analyzed audio file
+type AudioInfo struct {
+	Duration   float64 `json:"duration"` // Seconds
+	Bitrate    int     `json:"bitrate"`  // Bits per second
+	SampleRate int     `json:"sample_rate"`
+	Channels   int     `json:"channels"`
+	Codec      string  `json:"codec,omitempty"`
+}
+
+type WaveformRequest struct {
+	FileKey string `json:"file_key"`
+	UserID  string `json:"user_id"`
+}
+
+type DerivedFileRequest struct {
+	FileKey string `json:"file_key"` // Original the derived file belongs to
+	Key     string `json:"key"`      // Key of the derived file, see handler.Handler.DerivedFiles
+	UserID  string `json:"user_id"`
+}
+
 type ThumbnailInfo struct {
 	Size     string `json:"size"` // e.g., "150x150"
 	URL      string `json:"url"`
@@ -222,12 +659,17 @@ type BatchFile struct {
 	ContentType string                 `json:"content_type"`
 	FileSize    int64                  `json:"file_size"`
 	Category    string                 `json:"category"`
+	EntityType  string                 `json:"entity_type,omitempty"` // Defaults to the request EntityType
+	EntityID    string                 `json:"entity_id,omitempty"`   // Defaults to the request EntityID
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	Config      map[string]interface{} `json:"config,omitempty"`
 }
 
 type BatchUploadRequest struct {
-	Files  []BatchFile `json:"files"`
-	UserID string      `json:"user_id"`
+	Files      []BatchFile `json:"files"`
+	EntityType string      `json:"entity_type"` // Entity of all files without their own
+	EntityID   string      `json:"entity_id"`
+	UserID     string      `json:"user_id"`
 }
 
 type BatchUploadResponse struct {
@@ -0,0 +1,155 @@
+package jobs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ChecksumHandler backfills a SHA-256 checksum into the object metadata
+type ChecksumHandler struct {
+	client *minio.Client
+	bucket string
+}
+
+// NewChecksumHandler creates a new checksum backfill handler
+// bucket is used when the job does not name one
+func NewChecksumHandler(client *minio.Client, bucket string) *ChecksumHandler {
+	return &ChecksumHandler{
+		client: client,
+		bucket: bucket,
+	}
+}
+
+// Handle computes the checksum and rewrites the object metadata with it
+func (h *ChecksumHandler) Handle(ctx context.Context, job *Job) error {
+	bucket := job.BucketName
+	if bucket == "" {
+		bucket = h.bucket
+	}
+
+	objInfo, err := h.client.StatObject(ctx, bucket, job.FileKey, minio.StatObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to stat object: %w", err)
+	}
+
+	object, err := h.client.GetObject(ctx, bucket, job.FileKey, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get object: %w", err)
+	}
+	defer object.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, object); err != nil {
+		return fmt.Errorf("failed to read object: %w", err)
+	}
+	checksum := hex.EncodeToString(hash.Sum(nil))
+
+	// Rewrite metadata in place, keeping existing user metadata
+	userMetadata := make(map[string]string, len(objInfo.UserMetadata)+2)
+	for k, v := range objInfo.UserMetadata {
+		userMetadata[strings.ToLower(k)] = v
+	}
+	userMetadata["checksum-sha256"] = checksum
+	userMetadata["Content-Type"] = objInfo.ContentType
+
+	_, err = h.client.CopyObject(ctx,
+		minio.CopyDestOptions{
+			Bucket:          bucket,
+			Object:          job.FileKey,
+			UserMetadata:    userMetadata,
+			ReplaceMetadata: true,
+		},
+		minio.CopySrcOptions{
+			Bucket:    bucket,
+			Object:    job.FileKey,
+			MatchETag: objInfo.ETag,
+		},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update object metadata: %w", err)
+	}
+
+	job.Result = map[string]interface{}{
+		"checksum":  checksum,
+		"algorithm": "sha256",
+	}
+	return nil
+}
+
+// ScanResult represents the outcome of an anti-virus scan
+type ScanResult struct {
+	Clean     bool   `json:"clean"`
+	Signature string `json:"signature,omitempty"` // Name of the detected threat
+	Engine    string `json:"engine,omitempty"`
+}
+
+// Scanner scans file content for malware (e.g. a ClamAV client)
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (*ScanResult, error)
+}
+
+// Classification represents the labels of an image or video
+type Classification struct {
+	NSFWScore float64  `json:"nsfw_score"` // Likelihood of adult content, from 0 to 1
+	Labels    []string `json:"labels,omitempty"`
+	Engine    string   `json:"engine,omitempty"`
+}
+
+// Classifier labels image and video content (e.g. a client of a vision API)
+type Classifier interface {
+	Classify(ctx context.Context, r io.Reader, contentType string) (*Classification, error)
+}
+
+// AVScanHandler scans stored objects with a pluggable Scanner
+type AVScanHandler struct {
+	client     *minio.Client
+	bucket     string
+	scanner    Scanner
+	OnInfected func(ctx context.Context, job *Job, result *ScanResult)
+}
+
+// NewAVScanHandler creates a new anti-virus scan handler
+func NewAVScanHandler(client *minio.Client, bucket string, scanner Scanner) *AVScanHandler {
+	return &AVScanHandler{
+		client:  client,
+		bucket:  bucket,
+		scanner: scanner,
+	}
+}
+
+// Handle streams the object through the scanner and records the verdict
+func (h *AVScanHandler) Handle(ctx context.Context, job *Job) error {
+	bucket := job.BucketName
+	if bucket == "" {
+		bucket = h.bucket
+	}
+
+	object, err := h.client.GetObject(ctx, bucket, job.FileKey, minio.GetObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get object: %w", err)
+	}
+	defer object.Close()
+
+	result, err := h.scanner.Scan(ctx, object)
+	if err != nil {
+		return fmt.Errorf("failed to scan object: %w", err)
+	}
+
+	job.Result = map[string]interface{}{
+		"clean":     result.Clean,
+		"signature": result.Signature,
+		"engine":    result.Engine,
+	}
+
+	if !result.Clean && h.OnInfected != nil {
+		h.OnInfected(ctx, job, result)
+	}
+
+	return nil
+}
@@ -0,0 +1,136 @@
+package jobs
+
+import (
+	"context"
+	"time"
+)
+
+// Type identifies the kind of work a job performs
+type Type string
+
+const (
+	TypeThumbnail Type = "thumbnail"
+	TypeTranscode Type = "transcode"
+	TypeAVScan    Type = "av_scan"
+	TypeClassify  Type = "classify" // Content classification, handled by the storage handler
+	TypeOCR       Type = "ocr"      // Text recognition for search, handled by the storage handler
+	TypeConvert   Type = "convert"  // PDF previews of documents, handled by the storage handler
+	TypeAudio     Type = "audio"    // Audio properties and waveforms, handled by the storage handler
+	TypeChecksum  Type = "checksum"
+	TypeIndex     Type = "index"     // Full-text indexing, handled by the storage handler
+	TypeReplicate Type = "replicate" // Mirroring to the replica backend, handled by the storage handler
+	TypeMetadata  Type = "metadata"  // Retried metadata records and callbacks, handled by the storage handler
+	TypeDirect    Type = "direct"    // Processing of direct uploads, handled by the storage handler
+)
+
+// Status represents the lifecycle state of a job
+type Status string
+
+const (
+	StatusPending    Status = "pending"
+	StatusProcessing Status = "processing"
+	StatusDone       Status = "done"
+	StatusFailed     Status = "failed"
+	StatusCancelled  Status = "cancelled"
+)
+
+// Job represents a unit of background work tied to a stored file
+type Job struct {
+	ID          string                 `json:"id"`
+	Type        Type                   `json:"type"`
+	FileKey     string                 `json:"file_key"`
+	BucketName  string                 `json:"bucket_name"`
+	Payload     map[string]interface{} `json:"payload,omitempty"`
+	Result      map[string]interface{} `json:"result,omitempty"`
+	Status      Status                 `json:"status"`
+	Error       string                 `json:"error,omitempty"`
+	Attempts    int                    `json:"attempts"`
+	MaxAttempts int                    `json:"max_attempts"`
+	Timeout     time.Duration          `json:"timeout,omitempty"`    // Per attempt, overrides the processor timeouts
+	RequestID   string                 `json:"request_id,omitempty"` // Request that submitted the job, carried by the context of its handler
+	CreatedAt   time.Time              `json:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+}
+
+// Handler processes jobs of a single type
+type Handler interface {
+	Handle(ctx context.Context, job *Job) error
+}
+
+// HandlerFunc adapts a plain function to the Handler interface
+type HandlerFunc func(ctx context.Context, job *Job) error
+
+// Handle calls f(ctx, job)
+func (f HandlerFunc) Handle(ctx context.Context, job *Job) error {
+	return f(ctx, job)
+}
+
+// Clone returns a copy of the job that does not share maps with the original
+func (j *Job) Clone() *Job {
+	clone := *j
+	if j.Payload != nil {
+		clone.Payload = make(map[string]interface{}, len(j.Payload))
+		for k, v := range j.Payload {
+			clone.Payload[k] = v
+		}
+	}
+	if j.Result != nil {
+		clone.Result = make(map[string]interface{}, len(j.Result))
+		for k, v := range j.Result {
+			clone.Result[k] = v
+		}
+	}
+	return &clone
+}
+
+// IsFinished reports whether the job reached a terminal state
+func (j *Job) IsFinished() bool {
+	return j.Status == StatusDone || j.Status == StatusFailed || j.Status == StatusCancelled
+}
+
+// String returns a string payload value
+func (j *Job) String(key string) string {
+	if j.Payload == nil {
+		return ""
+	}
+	value, _ := j.Payload[key].(string)
+	return value
+}
+
+// Int64 returns a numeric payload value
+// Values that went through JSON encoding come back as float64, so both forms are accepted
+func (j *Job) Int64(key string) int64 {
+	if j.Payload == nil {
+		return 0
+	}
+	switch value := j.Payload[key].(type) {
+	case int:
+		return int64(value)
+	case int64:
+		return value
+	case float64:
+		return int64(value)
+	}
+	return 0
+}
+
+// StringSlice returns a string list payload value
+// Values that went through JSON encoding come back as []interface{}, so both forms are accepted
+func (j *Job) StringSlice(key string) []string {
+	if j.Payload == nil {
+		return nil
+	}
+	switch value := j.Payload[key].(type) {
+	case []string:
+		return value
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
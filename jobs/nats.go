@@ -0,0 +1,201 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// NATSQueueConfig represents NATS JetStream queue configuration
+type NATSQueueConfig struct {
+	Stream         string        `json:"stream"`          // Stream name
+	SubjectPrefix  string        `json:"subject_prefix"`  // Jobs are published to <prefix>.<type>
+	ConsumerPrefix string        `json:"consumer_prefix"` // Durable consumer name prefix, one consumer per job type
+	AckWait        time.Duration `json:"ack_wait"`        // Unacked jobs are redelivered after this, must exceed the longest job
+	FetchTimeout   time.Duration `json:"fetch_timeout"`   // How long a single fetch waits for jobs
+}
+
+// DefaultNATSQueueConfig returns default NATS queue configuration
+func DefaultNATSQueueConfig() NATSQueueConfig {
+	return NATSQueueConfig{
+		Stream:         "STORAGE_JOBS",
+		SubjectPrefix:  "storage.jobs",
+		ConsumerPrefix: "storage-jobs",
+		AckWait:        5 * time.Minute,
+		FetchTimeout:   5 * time.Second,
+	}
+}
+
+// NATSQueue is a durable queue backed by a JetStream work-queue stream
+// Every worker sharing the stream and consumer names competes for the same jobs
+type NATSQueue struct {
+	js        jetstream.JetStream
+	config    NATSQueueConfig
+	consumers map[Type]jetstream.Consumer
+	pending   map[*Job]jetstream.Msg // Dequeued job -> delivery to ack
+	mutex     sync.Mutex
+}
+
+// NewNATSQueue creates the work-queue stream if needed and returns a queue on it
+// The connection is owned by the caller and is not closed by Close
+func NewNATSQueue(ctx context.Context, js jetstream.JetStream, config NATSQueueConfig) (*NATSQueue, error) {
+	defaults := DefaultNATSQueueConfig()
+	if config.Stream == "" {
+		config.Stream = defaults.Stream
+	}
+	if config.SubjectPrefix == "" {
+		config.SubjectPrefix = defaults.SubjectPrefix
+	}
+	if config.ConsumerPrefix == "" {
+		config.ConsumerPrefix = defaults.ConsumerPrefix
+	}
+	if config.AckWait <= 0 {
+		config.AckWait = defaults.AckWait
+	}
+	if config.FetchTimeout <= 0 {
+		config.FetchTimeout = defaults.FetchTimeout
+	}
+
+	_, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:      config.Stream,
+		Subjects:  []string{config.SubjectPrefix + ".>"},
+		Retention: jetstream.WorkQueuePolicy,
+		Storage:   jetstream.FileStorage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job stream: %w", err)
+	}
+
+	return &NATSQueue{
+		js:        js,
+		config:    config,
+		consumers: make(map[Type]jetstream.Consumer),
+		pending:   make(map[*Job]jetstream.Msg),
+	}, nil
+}
+
+// subject returns the subject jobs of a type are published to
+func (q *NATSQueue) subject(jobType Type) string {
+	return q.config.SubjectPrefix + "." + string(jobType)
+}
+
+// consumer returns the durable consumer for a job type, creating it on first use
+func (q *NATSQueue) consumer(ctx context.Context, jobType Type) (jetstream.Consumer, error) {
+	q.mutex.Lock()
+	consumer, exists := q.consumers[jobType]
+	q.mutex.Unlock()
+	if exists {
+		return consumer, nil
+	}
+
+	consumer, err := q.js.CreateOrUpdateConsumer(ctx, q.config.Stream, jetstream.ConsumerConfig{
+		Durable:       q.config.ConsumerPrefix + "-" + string(jobType),
+		FilterSubject: q.subject(jobType),
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       q.config.AckWait,
+		MaxDeliver:    -1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create job consumer: %w", err)
+	}
+
+	q.mutex.Lock()
+	q.consumers[jobType] = consumer
+	q.mutex.Unlock()
+	return consumer, nil
+}
+
+// Enqueue publishes the job to the subject for its type
+func (q *NATSQueue) Enqueue(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	if _, err := q.js.Publish(ctx, q.subject(job.Type), data); err != nil {
+		return fmt.Errorf("failed to publish job: %w", err)
+	}
+	return nil
+}
+
+// Dequeue waits for the next job of the given type
+func (q *NATSQueue) Dequeue(ctx context.Context, jobType Type) (*Job, error) {
+	consumer, err := q.consumer(ctx, jobType)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		msg, err := consumer.Next(jetstream.FetchMaxWait(q.config.FetchTimeout))
+		if errors.Is(err, nats.ErrTimeout) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch job: %w", err)
+		}
+
+		var job Job
+		if err := json.Unmarshal(msg.Data(), &job); err != nil {
+			// Drop messages that can never be processed
+			_ = msg.Term()
+			continue
+		}
+
+		q.mutex.Lock()
+		q.pending[&job] = msg
+		q.mutex.Unlock()
+		return &job, nil
+	}
+}
+
+// Ack acknowledges a dequeued job so it is removed from the stream
+func (q *NATSQueue) Ack(ctx context.Context, job *Job) error {
+	q.mutex.Lock()
+	msg, exists := q.pending[job]
+	delete(q.pending, job)
+	q.mutex.Unlock()
+
+	if !exists {
+		return nil
+	}
+
+	if err := msg.Ack(); err != nil {
+		return fmt.Errorf("failed to ack job: %w", err)
+	}
+	return nil
+}
+
+// Len returns the number of waiting and unacked jobs of a type
+func (q *NATSQueue) Len(jobType Type) int {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	consumer, err := q.consumer(ctx, jobType)
+	if err != nil {
+		return 0
+	}
+	info, err := consumer.Info(ctx)
+	if err != nil {
+		return 0
+	}
+	return int(info.NumPending) + info.NumAckPending
+}
+
+// Close forgets locally tracked deliveries; unacked jobs are redelivered after AckWait
+func (q *NATSQueue) Close() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.pending = make(map[*Job]jetstream.Msg)
+	return nil
+}
@@ -0,0 +1,523 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/darmawan01/storage/requestid"
+	"github.com/google/uuid"
+)
+
+// Config represents job processor configuration
+type Config struct {
+	DefaultConcurrency int           `json:"default_concurrency"` // Workers per job type when not set explicitly
+	Concurrency        map[Type]int  `json:"concurrency"`         // Per job type worker limits
+	RetryAttempts      int           `json:"retry_attempts"`      // Number of retry attempts after the first failure
+	RetryDelay         time.Duration `json:"retry_delay"`         // Delay between retries
+	ProducerOnly       bool          `json:"producer_only"`       // Only enqueue jobs, dedicated workers sharing the queue process them
+
+	// Time limit of a single attempt, 0 means none; a timed out attempt fails and is retried
+	Timeout  time.Duration          `json:"timeout,omitempty"`
+	Timeouts map[Type]time.Duration `json:"timeouts,omitempty"` // Per job type time limits
+}
+
+// Processor dispatches queued jobs to the handler registered for their type
+type Processor struct {
+	config   Config
+	queue    Queue
+	store    Store
+	handlers map[Type]Handler
+	workers  map[Type]int
+	counters map[Type]*typeCounters
+	ctx      context.Context // Cancelled to abort running jobs
+	cancel   context.CancelFunc
+	intake   context.Context // Cancelled to stop taking new jobs
+	stop     context.CancelFunc
+	wg       sync.WaitGroup
+	mutex    sync.RWMutex
+
+	// Running jobs by ID, and jobs cancelled before a worker picked them up
+	running   map[string]context.CancelCauseFunc
+	cancelled map[string]bool
+}
+
+// typeCounters tracks processing totals for a job type
+type typeCounters struct {
+	Processed int64
+	Succeeded int64
+	Failed    int64
+	Retried   int64
+}
+
+// NewProcessor creates a new job processor
+// A nil queue or store falls back to the in-memory implementations
+func NewProcessor(config Config, queue Queue, store Store) *Processor {
+	if config.DefaultConcurrency <= 0 {
+		config.DefaultConcurrency = 1
+	}
+	if queue == nil {
+		queue = NewMemoryQueue(100)
+	}
+	if store == nil {
+		store = NewMemoryStore(0)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	intake, stop := context.WithCancel(ctx)
+
+	return &Processor{
+		config:   config,
+		queue:    queue,
+		store:    store,
+		handlers: make(map[Type]Handler),
+		workers:  make(map[Type]int),
+		counters: make(map[Type]*typeCounters),
+		ctx:      ctx,
+		cancel:   cancel,
+		intake:   intake,
+		stop:     stop,
+
+		running:   make(map[string]context.CancelCauseFunc),
+		cancelled: make(map[string]bool),
+	}
+}
+
+// Register installs the handler for a job type and starts its workers
+// concurrency <= 0 uses the configured limit for the type, then the default
+// In producer-only mode the type is accepted by Submit but no workers are started
+func (p *Processor) Register(jobType Type, handler Handler, concurrency int) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.intake.Err() != nil {
+		return fmt.Errorf("job processor is stopped")
+	}
+	if _, exists := p.handlers[jobType]; exists {
+		return fmt.Errorf("handler for job type %s already registered", jobType)
+	}
+
+	if concurrency <= 0 {
+		concurrency = p.config.Concurrency[jobType]
+	}
+	if concurrency <= 0 {
+		concurrency = p.config.DefaultConcurrency
+	}
+	if p.config.ProducerOnly {
+		concurrency = 0
+	}
+
+	p.handlers[jobType] = handler
+	p.workers[jobType] = concurrency
+	p.counters[jobType] = &typeCounters{}
+
+	for i := 0; i < concurrency; i++ {
+		p.wg.Add(1)
+		go p.worker(jobType)
+	}
+
+	return nil
+}
+
+// Submit validates the job, records it as pending and enqueues it
+func (p *Processor) Submit(ctx context.Context, job *Job) error {
+	if p.intake.Err() != nil {
+		return fmt.Errorf("job processor is shutting down")
+	}
+
+	p.mutex.RLock()
+	_, registered := p.handlers[job.Type]
+	p.mutex.RUnlock()
+	if !registered {
+		return fmt.Errorf("no handler registered for job type %s", job.Type)
+	}
+
+	// Fill in defaults
+	if job.ID == "" {
+		job.ID = uuid.NewString()
+	}
+	if job.CreatedAt.IsZero() {
+		job.CreatedAt = time.Now()
+	}
+	if job.MaxAttempts <= 0 {
+		job.MaxAttempts = p.config.RetryAttempts + 1
+	}
+	if job.RequestID == "" {
+		job.RequestID = requestid.FromContext(ctx)
+	}
+	job.Status = StatusPending
+	job.UpdatedAt = time.Now()
+
+	if err := p.store.Save(ctx, job); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+
+	if err := p.queue.Enqueue(ctx, job); err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		job.UpdatedAt = time.Now()
+		_ = p.store.Save(ctx, job)
+		return fmt.Errorf("failed to enqueue job: %w", err)
+	}
+
+	return nil
+}
+
+// Get returns the current state of a job
+func (p *Processor) Get(ctx context.Context, id string) (*Job, error) {
+	return p.store.Get(ctx, id)
+}
+
+// JobsForFile returns all known jobs for a file key
+func (p *Processor) JobsForFile(ctx context.Context, fileKey string) ([]*Job, error) {
+	return p.store.ListByFileKey(ctx, fileKey)
+}
+
+// worker pulls jobs of one type until the processor stops taking new jobs, then drains volatile
+// queues until they are empty or running jobs are aborted
+func (p *Processor) worker(jobType Type) {
+	defer p.wg.Done()
+
+	for {
+		job, err := p.queue.Dequeue(p.intake, jobType)
+		if err != nil {
+			if p.intake.Err() != nil {
+				p.drain(jobType)
+				return
+			}
+			// Back off briefly on transient queue errors
+			select {
+			case <-time.After(time.Second):
+				continue
+			case <-p.intake.Done():
+				return
+			}
+		}
+
+		p.process(job)
+	}
+}
+
+// volatile reports whether waiting jobs are lost once the processor stops
+func (p *Processor) volatile() bool {
+	queue, ok := p.queue.(volatileQueue)
+	return ok && queue.Volatile()
+}
+
+// drain processes the jobs of a type left in a volatile queue; durable queues keep them for
+// the next worker
+func (p *Processor) drain(jobType Type) {
+	if !p.volatile() {
+		return
+	}
+	for p.ctx.Err() == nil && p.queue.Len(jobType) > 0 {
+		// Other workers may take the last jobs, so waits are short
+		ctx, cancel := context.WithTimeout(p.ctx, 100*time.Millisecond)
+		job, err := p.queue.Dequeue(ctx, jobType)
+		cancel()
+		if err == nil {
+			p.process(job)
+		}
+	}
+}
+
+// process runs a single job and records the outcome
+func (p *Processor) process(job *Job) {
+	ctx, cancel := context.WithCancelCause(p.ctx)
+	defer cancel(nil)
+
+	p.mutex.Lock()
+	handler := p.handlers[job.Type]
+	counters := p.counters[job.Type]
+	cancelled := p.cancelled[job.ID]
+	delete(p.cancelled, job.ID)
+	if !cancelled {
+		p.running[job.ID] = cancel
+	}
+	p.mutex.Unlock()
+
+	if cancelled {
+		p.finishCancelled(job)
+		return
+	}
+	defer func() {
+		p.mutex.Lock()
+		delete(p.running, job.ID)
+		p.mutex.Unlock()
+	}()
+
+	job.Attempts++
+	job.Status = StatusProcessing
+	job.Error = ""
+	job.UpdatedAt = time.Now()
+	_ = p.store.Save(p.ctx, job)
+
+	timeout := p.timeout(job)
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+		defer cancelTimeout()
+	}
+
+	if job.RequestID != "" {
+		ctx = requestid.WithID(ctx, job.RequestID)
+	}
+	err := handler.Handle(ctx, job)
+
+	if err != nil && p.ctx.Err() == nil {
+		if context.Cause(ctx) == ErrJobCancelled {
+			p.finishCancelled(job)
+			return
+		}
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("job timed out after %s: %w", timeout, err)
+		}
+	}
+
+	if err != nil && p.ctx.Err() != nil {
+		// Interrupted by shutdown, leave the job unacked so durable queues redeliver it
+		if p.volatile() {
+			p.fail(job, ErrProcessorStopped)
+			return
+		}
+		job.Status = StatusPending
+		job.Error = err.Error()
+		job.UpdatedAt = time.Now()
+		_ = p.store.Save(context.Background(), job)
+		return
+	}
+
+	p.mutex.Lock()
+	counters.Processed++
+	if err == nil {
+		counters.Succeeded++
+	}
+	p.mutex.Unlock()
+
+	job.UpdatedAt = time.Now()
+
+	if err == nil {
+		job.Status = StatusDone
+		_ = p.store.Save(p.ctx, job)
+		_ = p.queue.Ack(p.ctx, job)
+		return
+	}
+
+	job.Error = err.Error()
+
+	// Retry if attempts remain
+	// The delivery is acked only once the retry is enqueued, so a crash in between redelivers it
+	if job.Attempts < job.MaxAttempts {
+		job.Status = StatusPending
+		_ = p.store.Save(p.ctx, job)
+
+		p.mutex.Lock()
+		counters.Retried++
+		p.mutex.Unlock()
+
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			timer := time.NewTimer(p.config.RetryDelay)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				if err := p.queue.Enqueue(p.ctx, job); err != nil {
+					p.fail(job, err)
+				}
+				_ = p.queue.Ack(p.ctx, job)
+			case <-p.intake.Done():
+				// No worker may be left for the retry; durable queues redeliver the unacked job
+				if p.volatile() {
+					p.fail(job, ErrProcessorStopped)
+				}
+			}
+		}()
+		return
+	}
+
+	p.fail(job, err)
+	_ = p.queue.Ack(p.ctx, job)
+}
+
+// timeout returns the time limit of a job attempt, 0 for none
+func (p *Processor) timeout(job *Job) time.Duration {
+	if job.Timeout > 0 {
+		return job.Timeout
+	}
+	if timeout := p.config.Timeouts[job.Type]; timeout > 0 {
+		return timeout
+	}
+	return p.config.Timeout
+}
+
+// Cancel stops a running job, or skips a queued one when a worker of this processor picks it up
+// Cancelled jobs are not retried. It reports whether the job was known and not finished
+func (p *Processor) Cancel(ctx context.Context, id string) (bool, error) {
+	job, err := p.store.Get(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if job.IsFinished() {
+		return false, nil
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if cancel, exists := p.running[id]; exists {
+		cancel(ErrJobCancelled)
+		return true, nil
+	}
+	p.cancelled[id] = true
+	return true, nil
+}
+
+// finishCancelled records a cancelled job and removes it from the queue
+func (p *Processor) finishCancelled(job *Job) {
+	job.Status = StatusCancelled
+	job.Error = ErrJobCancelled.Error()
+	job.UpdatedAt = time.Now()
+	_ = p.store.Save(context.Background(), job)
+	_ = p.queue.Ack(p.ctx, job)
+}
+
+// fail marks a job as permanently failed
+func (p *Processor) fail(job *Job, err error) {
+	p.mutex.Lock()
+	p.counters[job.Type].Failed++
+	p.mutex.Unlock()
+
+	job.Status = StatusFailed
+	job.Error = err.Error()
+	job.UpdatedAt = time.Now()
+	_ = p.store.Save(context.Background(), job)
+}
+
+// Stats returns processor statistics per job type
+func (p *Processor) Stats() map[string]interface{} {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	types := make(map[string]interface{}, len(p.handlers))
+	for jobType := range p.handlers {
+		counters := p.counters[jobType]
+		types[string(jobType)] = map[string]interface{}{
+			"workers":    p.workers[jobType],
+			"queue_size": p.queue.Len(jobType),
+			"processed":  counters.Processed,
+			"succeeded":  counters.Succeeded,
+			"failed":     counters.Failed,
+			"retried":    counters.Retried,
+		}
+	}
+
+	return map[string]interface{}{
+		"is_running":     p.intake.Err() == nil,
+		"retry_attempts": p.config.RetryAttempts,
+		"retry_delay":    p.config.RetryDelay,
+		"producer_only":  p.config.ProducerOnly,
+		"types":          types,
+	}
+}
+
+// Running reports whether the processor takes new jobs
+func (p *Processor) Running() bool {
+	return p.intake.Err() == nil
+}
+
+// QueueLen returns the number of waiting jobs of a type
+func (p *Processor) QueueLen(jobType Type) int {
+	return p.queue.Len(jobType)
+}
+
+// Backlog returns the number of waiting jobs of all types with a handler
+func (p *Processor) Backlog() int {
+	p.mutex.RLock()
+	types := make([]Type, 0, len(p.handlers))
+	for jobType := range p.handlers {
+		types = append(types, jobType)
+	}
+	p.mutex.RUnlock()
+
+	backlog := 0
+	for _, jobType := range types {
+		backlog += p.queue.Len(jobType)
+	}
+	return backlog
+}
+
+// Workers returns the number of workers serving a job type
+func (p *Processor) Workers(jobType Type) int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	return p.workers[jobType]
+}
+
+// Shutdown stops taking new jobs and waits for running jobs to finish, and for the jobs waiting in
+// volatile queues such as MemoryQueue; pending retries are not waited for. When ctx expires first,
+// running jobs are cancelled and left unacked so durable queues redeliver them, while the jobs of
+// volatile queues, running or waiting, are recorded as failed with ErrProcessorStopped
+func (p *Processor) Shutdown(ctx context.Context) error {
+	p.stop()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	p.cancel()
+	<-done
+	p.failWaiting()
+	_ = p.queue.Close()
+	return err
+}
+
+// failWaiting records the jobs left in a volatile queue after the workers stopped as failed
+func (p *Processor) failWaiting() {
+	if !p.volatile() {
+		return
+	}
+	p.mutex.RLock()
+	types := make([]Type, 0, len(p.handlers))
+	for jobType := range p.handlers {
+		types = append(types, jobType)
+	}
+	p.mutex.RUnlock()
+
+	// No worker is left, so waiting jobs are dequeued at once
+	for _, jobType := range types {
+		for p.queue.Len(jobType) > 0 {
+			job, err := p.queue.Dequeue(context.Background(), jobType)
+			if err != nil {
+				break
+			}
+			p.mutex.Lock()
+			cancelled := p.cancelled[job.ID]
+			delete(p.cancelled, job.ID)
+			p.mutex.Unlock()
+			if cancelled {
+				p.finishCancelled(job)
+				continue
+			}
+			p.fail(job, ErrProcessorStopped)
+		}
+	}
+}
+
+// Stop cancels running jobs, stops all workers and closes the queue
+func (p *Processor) Stop() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = p.Shutdown(ctx)
+}
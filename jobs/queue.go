@@ -0,0 +1,143 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+var (
+	ErrQueueFull   = &errors.StorageError{Code: "QUEUE_FULL", Message: "Job queue is full"}
+	ErrQueueClosed = &errors.StorageError{Code: "QUEUE_CLOSED", Message: "Job queue is closed"}
+	ErrJobNotFound = &errors.StorageError{Code: "JOB_NOT_FOUND", Message: "Job not found"}
+
+	ErrJobCancelled     = &errors.StorageError{Code: "JOB_CANCELLED", Message: "Job cancelled"}
+	ErrProcessorStopped = &errors.StorageError{Code: "PROCESSOR_STOPPED", Message: "Job processor stopped before the job finished"}
+)
+
+// Queue is the transport that carries jobs from producers to workers
+// Implementations may be in-process or backed by an external broker
+type Queue interface {
+	// Enqueue adds a job to the queue for its type
+	Enqueue(ctx context.Context, job *Job) error
+	// Dequeue blocks until a job of the given type is available or ctx is done
+	Dequeue(ctx context.Context, jobType Type) (*Job, error)
+	// Ack marks a dequeued job as handled so it is not redelivered
+	Ack(ctx context.Context, job *Job) error
+	// Len returns the number of jobs waiting for the given type
+	Len(jobType Type) int
+	// Close releases resources held by the queue
+	Close() error
+}
+
+// volatileQueue is implemented by queues that lose waiting jobs when the process exits; processors
+// drain them on shutdown and fail the jobs left over
+type volatileQueue interface {
+	Volatile() bool
+}
+
+// MemoryQueue is an in-process queue with one bounded channel per job type
+type MemoryQueue struct {
+	size   int
+	queues map[Type]chan *Job
+	closed bool
+	mutex  sync.RWMutex
+}
+
+// NewMemoryQueue creates a new in-memory queue holding up to size jobs per type
+func NewMemoryQueue(size int) *MemoryQueue {
+	if size <= 0 {
+		size = 100
+	}
+	return &MemoryQueue{
+		size:   size,
+		queues: make(map[Type]chan *Job),
+	}
+}
+
+// channel returns the channel for a job type, creating it on first use
+func (q *MemoryQueue) channel(jobType Type) (chan *Job, error) {
+	q.mutex.RLock()
+	ch, exists := q.queues[jobType]
+	closed := q.closed
+	q.mutex.RUnlock()
+
+	if closed {
+		return nil, ErrQueueClosed
+	}
+	if exists {
+		return ch, nil
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if ch, exists = q.queues[jobType]; !exists {
+		ch = make(chan *Job, q.size)
+		q.queues[jobType] = ch
+	}
+	return ch, nil
+}
+
+// Enqueue adds a job without blocking, failing when the queue is full
+func (q *MemoryQueue) Enqueue(ctx context.Context, job *Job) error {
+	ch, err := q.channel(job.Type)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case ch <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Dequeue waits for the next job of the given type
+func (q *MemoryQueue) Dequeue(ctx context.Context, jobType Type) (*Job, error) {
+	ch, err := q.channel(jobType)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case job := <-ch:
+		return job, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Ack is a no-op for the in-memory queue since delivery is destructive
+func (q *MemoryQueue) Ack(ctx context.Context, job *Job) error {
+	return nil
+}
+
+// Len returns the number of waiting jobs for a type
+func (q *MemoryQueue) Len(jobType Type) int {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+
+	if ch, exists := q.queues[jobType]; exists {
+		return len(ch)
+	}
+	return 0
+}
+
+// Volatile reports that waiting jobs are lost with the process
+func (q *MemoryQueue) Volatile() bool {
+	return true
+}
+
+// Close marks the queue as closed; pending jobs are dropped
+func (q *MemoryQueue) Close() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.closed = true
+	return nil
+}
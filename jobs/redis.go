@@ -0,0 +1,361 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisQueueConfig represents Redis Streams queue configuration
+type RedisQueueConfig struct {
+	Prefix       string        `json:"prefix"`        // Stream key prefix, one stream per job type
+	Group        string        `json:"group"`         // Consumer group shared by all workers
+	Consumer     string        `json:"consumer"`      // Unique consumer name for this process
+	BlockTimeout time.Duration `json:"block_timeout"` // How long a single read blocks waiting for jobs
+	ClaimIdle    time.Duration `json:"claim_idle"`    // Unacked jobs idle longer than this are taken over from crashed workers
+	MaxLen       int64         `json:"max_len"`       // Approximate stream length cap, 0 means unbounded
+}
+
+// DefaultRedisQueueConfig returns default Redis queue configuration
+func DefaultRedisQueueConfig() RedisQueueConfig {
+	hostname, _ := os.Hostname()
+	return RedisQueueConfig{
+		Prefix:       "storage:jobs",
+		Group:        "storage-workers",
+		Consumer:     fmt.Sprintf("%s-%d", hostname, os.Getpid()),
+		BlockTimeout: 5 * time.Second,
+		ClaimIdle:    5 * time.Minute,
+	}
+}
+
+// RedisQueue is a durable queue backed by Redis Streams
+// Jobs stay in the consumer group's pending list until acked, so jobs held by
+// a crashed worker are redelivered to another worker after ClaimIdle
+type RedisQueue struct {
+	client  redis.UniversalClient
+	config  RedisQueueConfig
+	groups  map[Type]bool
+	pending map[*Job]string // Dequeued job -> stream message ID
+	mutex   sync.Mutex
+}
+
+// NewRedisQueue creates a new Redis Streams queue
+// The client is owned by the caller and is not closed by Close
+func NewRedisQueue(client redis.UniversalClient, config RedisQueueConfig) *RedisQueue {
+	defaults := DefaultRedisQueueConfig()
+	if config.Prefix == "" {
+		config.Prefix = defaults.Prefix
+	}
+	if config.Group == "" {
+		config.Group = defaults.Group
+	}
+	if config.Consumer == "" {
+		config.Consumer = defaults.Consumer
+	}
+	if config.BlockTimeout <= 0 {
+		config.BlockTimeout = defaults.BlockTimeout
+	}
+
+	return &RedisQueue{
+		client:  client,
+		config:  config,
+		groups:  make(map[Type]bool),
+		pending: make(map[*Job]string),
+	}
+}
+
+// streamKey returns the stream key for a job type
+func (q *RedisQueue) streamKey(jobType Type) string {
+	return q.config.Prefix + ":" + string(jobType)
+}
+
+// ensureGroup creates the stream and consumer group on first use
+func (q *RedisQueue) ensureGroup(ctx context.Context, jobType Type) error {
+	q.mutex.Lock()
+	exists := q.groups[jobType]
+	q.mutex.Unlock()
+	if exists {
+		return nil
+	}
+
+	err := q.client.XGroupCreateMkStream(ctx, q.streamKey(jobType), q.config.Group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("failed to create consumer group: %w", err)
+	}
+
+	q.mutex.Lock()
+	q.groups[jobType] = true
+	q.mutex.Unlock()
+	return nil
+}
+
+// Enqueue appends the job to the stream for its type
+func (q *RedisQueue) Enqueue(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	args := &redis.XAddArgs{
+		Stream: q.streamKey(job.Type),
+		Values: map[string]interface{}{"job": data},
+	}
+	if q.config.MaxLen > 0 {
+		args.MaxLen = q.config.MaxLen
+		args.Approx = true
+	}
+
+	if err := q.client.XAdd(ctx, args).Err(); err != nil {
+		return fmt.Errorf("failed to add job to stream: %w", err)
+	}
+	return nil
+}
+
+// Dequeue waits for the next job of the given type
+// Stale jobs abandoned by other consumers are claimed before new ones are read
+func (q *RedisQueue) Dequeue(ctx context.Context, jobType Type) (*Job, error) {
+	if err := q.ensureGroup(ctx, jobType); err != nil {
+		return nil, err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		message, err := q.next(ctx, jobType)
+		if err != nil {
+			return nil, err
+		}
+		if message == nil {
+			continue
+		}
+
+		job, err := q.decode(message)
+		if err != nil {
+			// Drop messages that can never be processed
+			q.remove(ctx, jobType, message.ID)
+			continue
+		}
+
+		q.mutex.Lock()
+		q.pending[job] = message.ID
+		q.mutex.Unlock()
+		return job, nil
+	}
+}
+
+// next returns the next stream message, or nil when the read timed out
+func (q *RedisQueue) next(ctx context.Context, jobType Type) (*redis.XMessage, error) {
+	key := q.streamKey(jobType)
+
+	if q.config.ClaimIdle > 0 {
+		claimed, _, err := q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   key,
+			Group:    q.config.Group,
+			Consumer: q.config.Consumer,
+			MinIdle:  q.config.ClaimIdle,
+			Start:    "0-0",
+			Count:    1,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("failed to claim stale jobs: %w", err)
+		}
+		if len(claimed) > 0 {
+			return &claimed[0], nil
+		}
+	}
+
+	streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    q.config.Group,
+		Consumer: q.config.Consumer,
+		Streams:  []string{key, ">"},
+		Count:    1,
+		Block:    q.config.BlockTimeout,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from stream: %w", err)
+	}
+	if len(streams) == 0 || len(streams[0].Messages) == 0 {
+		return nil, nil
+	}
+	return &streams[0].Messages[0], nil
+}
+
+// decode parses a job from a stream message
+func (q *RedisQueue) decode(message *redis.XMessage) (*Job, error) {
+	data, ok := message.Values["job"].(string)
+	if !ok {
+		return nil, fmt.Errorf("stream message %s has no job", message.ID)
+	}
+
+	var job Job
+	if err := json.Unmarshal([]byte(data), &job); err != nil {
+		return nil, fmt.Errorf("failed to decode job: %w", err)
+	}
+	return &job, nil
+}
+
+// remove acks and deletes a stream message
+func (q *RedisQueue) remove(ctx context.Context, jobType Type, id string) error {
+	key := q.streamKey(jobType)
+
+	pipe := q.client.TxPipeline()
+	pipe.XAck(ctx, key, q.config.Group, id)
+	pipe.XDel(ctx, key, id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Ack removes a dequeued job from the stream
+func (q *RedisQueue) Ack(ctx context.Context, job *Job) error {
+	q.mutex.Lock()
+	id, exists := q.pending[job]
+	delete(q.pending, job)
+	q.mutex.Unlock()
+
+	if !exists {
+		return nil
+	}
+
+	if err := q.remove(ctx, job.Type, id); err != nil {
+		return fmt.Errorf("failed to ack job: %w", err)
+	}
+	return nil
+}
+
+// Len returns the number of jobs in the stream for a type, including unacked ones
+func (q *RedisQueue) Len(jobType Type) int {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	length, err := q.client.XLen(ctx, q.streamKey(jobType)).Result()
+	if err != nil {
+		return 0
+	}
+	return int(length)
+}
+
+// Close forgets locally tracked deliveries; unacked jobs are redelivered later
+func (q *RedisQueue) Close() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.pending = make(map[*Job]string)
+	return nil
+}
+
+// RedisStore keeps job state in Redis so it can be shared between producers and workers
+type RedisStore struct {
+	client redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisStore creates a new Redis job store
+// Job records expire after ttl, 0 keeps them for 7 days
+func NewRedisStore(client redis.UniversalClient, prefix string, ttl time.Duration) *RedisStore {
+	if prefix == "" {
+		prefix = "storage:jobs"
+	}
+	if ttl <= 0 {
+		ttl = 7 * 24 * time.Hour
+	}
+	return &RedisStore{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+// jobKey returns the key holding a job record
+func (s *RedisStore) jobKey(id string) string {
+	return s.prefix + ":job:" + id
+}
+
+// fileKey returns the key indexing jobs by file
+func (s *RedisStore) fileKey(fileKey string) string {
+	return s.prefix + ":file:" + fileKey
+}
+
+// Save stores the job and indexes it by file key
+func (s *RedisStore) Save(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode job: %w", err)
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Set(ctx, s.jobKey(job.ID), data, s.ttl)
+	pipe.ZAdd(ctx, s.fileKey(job.FileKey), redis.Z{
+		Score:  float64(job.CreatedAt.UnixNano()),
+		Member: job.ID,
+	})
+	pipe.Expire(ctx, s.fileKey(job.FileKey), s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	return nil
+}
+
+// Get returns the job with the given ID
+func (s *RedisStore) Get(ctx context.Context, id string) (*Job, error) {
+	data, err := s.client.Get(ctx, s.jobKey(id)).Bytes()
+	if err == redis.Nil {
+		return nil, ErrJobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to decode job: %w", err)
+	}
+	return &job, nil
+}
+
+// ListByFileKey returns all known jobs for a file, oldest first
+func (s *RedisStore) ListByFileKey(ctx context.Context, fileKey string) ([]*Job, error) {
+	ids, err := s.client.ZRange(ctx, s.fileKey(fileKey), 0, -1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list jobs: %w", err)
+	}
+	if len(ids) == 0 {
+		return []*Job{}, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.jobKey(id)
+	}
+
+	values, err := s.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobs: %w", err)
+	}
+
+	result := make([]*Job, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			// Expired record
+			continue
+		}
+		var job Job
+		if err := json.Unmarshal([]byte(data), &job); err != nil {
+			continue
+		}
+		result = append(result, &job)
+	}
+	return result, nil
+}
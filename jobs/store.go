@@ -0,0 +1,115 @@
+package jobs
+
+import (
+	"context"
+	"sync"
+)
+
+// Store keeps track of job state so it can be queried after submission
+type Store interface {
+	Save(ctx context.Context, job *Job) error
+	Get(ctx context.Context, id string) (*Job, error)
+	ListByFileKey(ctx context.Context, fileKey string) ([]*Job, error)
+}
+
+// MemoryStore is an in-process job store
+// Once MaxJobs is reached the oldest finished jobs are evicted first
+type MemoryStore struct {
+	maxJobs int
+	jobs    map[string]*Job
+	byFile  map[string][]string
+	mutex   sync.RWMutex
+}
+
+// NewMemoryStore creates a new in-memory job store
+func NewMemoryStore(maxJobs int) *MemoryStore {
+	if maxJobs <= 0 {
+		maxJobs = 10000
+	}
+	return &MemoryStore{
+		maxJobs: maxJobs,
+		jobs:    make(map[string]*Job),
+		byFile:  make(map[string][]string),
+	}
+}
+
+// Save stores a copy of the job
+func (s *MemoryStore) Save(ctx context.Context, job *Job) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.jobs[job.ID]; !exists {
+		if len(s.jobs) >= s.maxJobs {
+			s.evictOldest()
+		}
+		s.byFile[job.FileKey] = append(s.byFile[job.FileKey], job.ID)
+	}
+
+	s.jobs[job.ID] = job.Clone()
+	return nil
+}
+
+// Get returns a copy of the job with the given ID
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Job, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return nil, ErrJobNotFound
+	}
+	return job.Clone(), nil
+}
+
+// ListByFileKey returns all known jobs for a file, oldest first
+func (s *MemoryStore) ListByFileKey(ctx context.Context, fileKey string) ([]*Job, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ids := s.byFile[fileKey]
+	result := make([]*Job, 0, len(ids))
+	for _, id := range ids {
+		if job, exists := s.jobs[id]; exists {
+			result = append(result, job.Clone())
+		}
+	}
+	return result, nil
+}
+
+// evictOldest removes the oldest job, preferring finished ones
+func (s *MemoryStore) evictOldest() {
+	var victim *Job
+	for _, job := range s.jobs {
+		if victim == nil {
+			victim = job
+			continue
+		}
+		if job.IsFinished() != victim.IsFinished() {
+			if job.IsFinished() {
+				victim = job
+			}
+			continue
+		}
+		if job.UpdatedAt.Before(victim.UpdatedAt) {
+			victim = job
+		}
+	}
+	if victim == nil {
+		return
+	}
+
+	delete(s.jobs, victim.ID)
+
+	ids := s.byFile[victim.FileKey]
+	for i, id := range ids {
+		if id == victim.ID {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(s.byFile, victim.FileKey)
+	} else {
+		s.byFile[victim.FileKey] = ids
+	}
+}
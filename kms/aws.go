@@ -0,0 +1,79 @@
+package kms
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awskms "github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// AWSClient is the subset of the AWS KMS client used by AWSKeyProvider, satisfied by *kms.Client
+type AWSClient interface {
+	Encrypt(ctx context.Context, params *awskms.EncryptInput, optFns ...func(*awskms.Options)) (*awskms.EncryptOutput, error)
+	Decrypt(ctx context.Context, params *awskms.DecryptInput, optFns ...func(*awskms.Options)) (*awskms.DecryptOutput, error)
+}
+
+// AWSConfig represents AWS KMS key provider configuration
+type AWSConfig struct {
+	// Key ID, ARN or alias of the master key used for new data keys
+	KeyID string `json:"key_id"`
+	// Encryption context bound to every wrapped key
+	EncryptionContext map[string]string `json:"encryption_context,omitempty"`
+}
+
+// AWSKeyProvider wraps data keys with an AWS KMS customer master key
+// Rotating the master key in KMS is transparent; switching to another key only
+// requires a new KeyID since the wrapped keys record the key they belong to
+type AWSKeyProvider struct {
+	client AWSClient
+	config AWSConfig
+}
+
+// NewAWSKeyProvider creates a new AWS KMS key provider
+func NewAWSKeyProvider(client AWSClient, config AWSConfig) (*AWSKeyProvider, error) {
+	if client == nil || config.KeyID == "" {
+		return nil, ErrInvalidConfig
+	}
+	return &AWSKeyProvider{
+		client: client,
+		config: config,
+	}, nil
+}
+
+// WrapKey encrypts a data key with the configured master key
+func (p *AWSKeyProvider) WrapKey(ctx context.Context, plaintext []byte) (string, []byte, error) {
+	return p.WrapKeyWith(ctx, p.config.KeyID, plaintext)
+}
+
+// WrapKeyWith encrypts a data key with another master key (key ID, ARN or alias)
+func (p *AWSKeyProvider) WrapKeyWith(ctx context.Context, keyID string, plaintext []byte) (string, []byte, error) {
+	output, err := p.client.Encrypt(ctx, &awskms.EncryptInput{
+		KeyId:             aws.String(keyID),
+		Plaintext:         plaintext,
+		EncryptionContext: p.config.EncryptionContext,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encrypt data key with AWS KMS: %w", err)
+	}
+
+	// KMS reports the key ARN, which stays valid when KeyID is an alias that later moves
+	return aws.ToString(output.KeyId), output.CiphertextBlob, nil
+}
+
+// UnwrapKey decrypts a data key with the master key it was wrapped with
+func (p *AWSKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	input := &awskms.DecryptInput{
+		CiphertextBlob:    wrapped,
+		EncryptionContext: p.config.EncryptionContext,
+	}
+	if keyID != "" {
+		input.KeyId = aws.String(keyID)
+	}
+
+	output, err := p.client.Decrypt(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key with AWS KMS: %w", err)
+	}
+	return output.Plaintext, nil
+}
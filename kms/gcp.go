@@ -0,0 +1,125 @@
+package kms
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// GCPConfig represents Google Cloud KMS key provider configuration
+type GCPConfig struct {
+	// Crypto key resource name, e.g. projects/p/locations/l/keyRings/r/cryptoKeys/k
+	KeyName string `json:"key_name"`
+	// Additional authenticated data bound to every wrapped key
+	AdditionalData []byte `json:"additional_data,omitempty"`
+	// API endpoint, default https://cloudkms.googleapis.com
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// TokenSource returns an OAuth2 access token, e.g. from golang.org/x/oauth2/google:
+	//
+	//	ts, _ := google.DefaultTokenSource(ctx, "https://www.googleapis.com/auth/cloudkms")
+	//	config.TokenSource = func(ctx context.Context) (string, error) {
+	//		token, err := ts.Token()
+	//		if err != nil {
+	//			return "", err
+	//		}
+	//		return token.AccessToken, nil
+	//	}
+	TokenSource func(ctx context.Context) (string, error) `json:"-"`
+	HTTPClient  *http.Client                              `json:"-"`
+}
+
+// GCPKeyProvider wraps data keys with a Google Cloud KMS symmetric crypto key
+// Key rotation is handled by Cloud KMS: new data keys use the primary version and
+// decryption finds the version from the ciphertext
+type GCPKeyProvider struct {
+	config GCPConfig
+}
+
+// NewGCPKeyProvider creates a new Cloud KMS key provider
+func NewGCPKeyProvider(config GCPConfig) (*GCPKeyProvider, error) {
+	if config.KeyName == "" || config.TokenSource == nil {
+		return nil, ErrInvalidConfig
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://cloudkms.googleapis.com"
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+
+	return &GCPKeyProvider{
+		config: config,
+	}, nil
+}
+
+// WrapKey encrypts a data key with the primary version of the crypto key
+func (p *GCPKeyProvider) WrapKey(ctx context.Context, plaintext []byte) (string, []byte, error) {
+	return p.WrapKeyWith(ctx, p.config.KeyName, plaintext)
+}
+
+// WrapKeyWith encrypts a data key with the primary version of another crypto key
+func (p *GCPKeyProvider) WrapKeyWith(ctx context.Context, keyName string, plaintext []byte) (string, []byte, error) {
+	var resp struct {
+		Name       string `json:"name"` // Crypto key version used
+		Ciphertext string `json:"ciphertext"`
+	}
+	if err := p.call(ctx, keyName, "encrypt", map[string]string{
+		"plaintext":                   base64.StdEncoding.EncodeToString(plaintext),
+		"additionalAuthenticatedData": base64.StdEncoding.EncodeToString(p.config.AdditionalData),
+	}, &resp); err != nil {
+		return "", nil, err
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(resp.Ciphertext)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decode Cloud KMS ciphertext: %w", err)
+	}
+	return resp.Name, wrapped, nil
+}
+
+// UnwrapKey decrypts a data key with the crypto key it was wrapped with
+func (p *GCPKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext string `json:"plaintext"`
+	}
+	if err := p.call(ctx, cryptoKeyName(keyID, p.config.KeyName), "decrypt", map[string]string{
+		"ciphertext":                  base64.StdEncoding.EncodeToString(wrapped),
+		"additionalAuthenticatedData": base64.StdEncoding.EncodeToString(p.config.AdditionalData),
+	}, &resp); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := base64.StdEncoding.DecodeString(resp.Plaintext)
+	if err != nil {
+		return nil, ErrUnwrapFailed
+	}
+	return plaintext, nil
+}
+
+// call invokes a crypto key method, e.g. POST /v1/<key>:encrypt
+func (p *GCPKeyProvider) call(ctx context.Context, keyName, method string, in map[string]string, out interface{}) error {
+	token, err := p.config.TokenSource(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get Cloud KMS access token: %w", err)
+	}
+
+	url := fmt.Sprintf("%s/v1/%s:%s", p.config.Endpoint, keyName, method)
+	headers := map[string]string{"Authorization": "Bearer " + token}
+
+	if err := postJSON(ctx, p.config.HTTPClient, url, headers, in, out); err != nil {
+		return fmt.Errorf("failed to %s data key with Cloud KMS: %w", method, err)
+	}
+	return nil
+}
+
+// cryptoKeyName strips the version from a crypto key version name, decrypt only accepts crypto keys
+func cryptoKeyName(keyID, fallback string) string {
+	if keyID == "" {
+		return fallback
+	}
+	if i := strings.Index(keyID, "/cryptoKeyVersions/"); i >= 0 {
+		return keyID[:i]
+	}
+	return keyID
+}
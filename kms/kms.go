@@ -0,0 +1,129 @@
+package kms
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+var (
+	ErrKeyNotFound   = &errors.StorageError{Code: "KEY_NOT_FOUND", Message: "Encryption key not found"}
+	ErrInvalidKey    = &errors.StorageError{Code: "INVALID_KEY", Message: "Invalid encryption key"}
+	ErrUnwrapFailed  = &errors.StorageError{Code: "KEY_UNWRAP_FAILED", Message: "Failed to unwrap data key"}
+	ErrInvalidConfig = &errors.StorageError{Code: "INVALID_CONFIG", Message: "Invalid key provider configuration"}
+	ErrKeySelection  = &errors.StorageError{Code: "KEY_SELECTION_UNSUPPORTED", Message: "Key provider cannot select master keys"}
+)
+
+// DataKeySize is the size of generated data keys (AES-256)
+const DataKeySize = 32
+
+// KeyProvider wraps and unwraps per-object data keys with a master key it never exposes
+// WrapKey returns the ID of the master key (version) used, which must be passed back to UnwrapKey
+type KeyProvider interface {
+	WrapKey(ctx context.Context, plaintext []byte) (keyID string, wrapped []byte, err error)
+	UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// KeySelector is implemented by providers that can wrap data keys with a named master key
+// instead of their default one, e.g. to use a separate key per tenant
+type KeySelector interface {
+	WrapKeyWith(ctx context.Context, keyName string, plaintext []byte) (keyID string, wrapped []byte, err error)
+}
+
+// Rewrapper is implemented by providers that can re-encrypt a wrapped key under
+// the current master key without exposing the plaintext, e.g. Vault transit
+type Rewrapper interface {
+	RewrapKey(ctx context.Context, keyID string, wrapped []byte) (newKeyID string, rewrapped []byte, err error)
+}
+
+// GenerateDataKey creates a random data key and wraps it with the provider
+// keyName selects the master key, the provider's default key is used when empty
+func GenerateDataKey(ctx context.Context, provider KeyProvider, keyName string) (plaintext []byte, keyID string, wrapped []byte, err error) {
+	plaintext = make([]byte, DataKeySize)
+	if _, err := io.ReadFull(rand.Reader, plaintext); err != nil {
+		return nil, "", nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	keyID, wrapped, err = Wrap(ctx, provider, keyName, plaintext)
+	if err != nil {
+		return nil, "", nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	return plaintext, keyID, wrapped, nil
+}
+
+// Wrap wraps a data key with the named master key, or the provider's default key when keyName is empty
+func Wrap(ctx context.Context, provider KeyProvider, keyName string, plaintext []byte) (string, []byte, error) {
+	if keyName == "" {
+		return provider.WrapKey(ctx, plaintext)
+	}
+
+	selector, ok := provider.(KeySelector)
+	if !ok {
+		return "", nil, ErrKeySelection
+	}
+	return selector.WrapKeyWith(ctx, keyName, plaintext)
+}
+
+// Rewrap re-encrypts a wrapped data key under the current version of the named master key
+// (the default key when keyName is empty). Providers without native rewrap support unwrap
+// and wrap the key again
+func Rewrap(ctx context.Context, provider KeyProvider, keyName, keyID string, wrapped []byte) (string, []byte, error) {
+	if rewrapper, ok := provider.(Rewrapper); ok && keyName == "" {
+		return rewrapper.RewrapKey(ctx, keyID, wrapped)
+	}
+
+	plaintext, err := provider.UnwrapKey(ctx, keyID, wrapped)
+	if err != nil {
+		return "", nil, err
+	}
+	return Wrap(ctx, provider, keyName, plaintext)
+}
+
+// seal encrypts data with AES-GCM, prefixing the nonce
+func seal(key, data, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, data, additionalData), nil
+}
+
+// open decrypts data produced by seal
+func open(key, data, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, ErrUnwrapFailed
+	}
+	plaintext, err := gcm.Open(nil, data[:nonceSize], data[nonceSize:], additionalData)
+	if err != nil {
+		return nil, ErrUnwrapFailed
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
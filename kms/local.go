@@ -0,0 +1,91 @@
+package kms
+
+import (
+	"context"
+	"sync"
+)
+
+// LocalKeyProvider wraps data keys with static AES-256 master keys held in memory
+// Keys are versioned by ID: new data keys use the current key, older keys stay
+// available for unwrapping until every object has been rotated
+type LocalKeyProvider struct {
+	mutex   sync.RWMutex
+	keys    map[string][]byte
+	current string
+}
+
+// NewLocalKeyProvider creates a provider using keys[current] for new data keys
+func NewLocalKeyProvider(current string, keys map[string][]byte) (*LocalKeyProvider, error) {
+	p := &LocalKeyProvider{keys: make(map[string][]byte)}
+	for id, key := range keys {
+		if err := p.AddKey(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.SetCurrent(current); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// AddKey registers a master key, replacing any key with the same ID
+func (p *LocalKeyProvider) AddKey(id string, key []byte) error {
+	if id == "" || len(key) != DataKeySize {
+		return ErrInvalidKey
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// SetCurrent selects the master key used for new data keys
+func (p *LocalKeyProvider) SetCurrent(id string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if _, exists := p.keys[id]; !exists {
+		return ErrKeyNotFound
+	}
+	p.current = id
+	return nil
+}
+
+// WrapKey encrypts a data key with the current master key
+func (p *LocalKeyProvider) WrapKey(ctx context.Context, plaintext []byte) (string, []byte, error) {
+	p.mutex.RLock()
+	current := p.current
+	p.mutex.RUnlock()
+
+	return p.WrapKeyWith(ctx, current, plaintext)
+}
+
+// WrapKeyWith encrypts a data key with the master key of the given ID
+func (p *LocalKeyProvider) WrapKeyWith(ctx context.Context, id string, plaintext []byte) (string, []byte, error) {
+	p.mutex.RLock()
+	key, exists := p.keys[id]
+	p.mutex.RUnlock()
+
+	if !exists {
+		return "", nil, ErrKeyNotFound
+	}
+	wrapped, err := seal(key, plaintext, []byte(id))
+	if err != nil {
+		return "", nil, err
+	}
+	return id, wrapped, nil
+}
+
+// UnwrapKey decrypts a data key with the master key it was wrapped with
+func (p *LocalKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	p.mutex.RLock()
+	key, exists := p.keys[keyID]
+	p.mutex.RUnlock()
+
+	if !exists {
+		return nil, ErrKeyNotFound
+	}
+	return open(key, wrapped, []byte(keyID))
+}
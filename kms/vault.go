@@ -0,0 +1,122 @@
+package kms
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// VaultConfig represents HashiCorp Vault transit key provider configuration
+type VaultConfig struct {
+	Address   string `json:"address"`             // e.g. https://vault:8200
+	Token     string `json:"-"`                   // Vault token with encrypt/decrypt/rewrap on the key
+	Namespace string `json:"namespace,omitempty"` // Vault Enterprise namespace
+	MountPath string `json:"mount_path"`          // Transit mount, default "transit"
+	KeyName   string `json:"key_name"`            // Transit key name
+
+	HTTPClient *http.Client `json:"-"`
+}
+
+// VaultKeyProvider wraps data keys with the Vault transit secrets engine
+// Wrapped keys are Vault ciphertexts ("vault:v<version>:..."); rotating the transit
+// key only affects new data keys until existing ones are rewrapped
+type VaultKeyProvider struct {
+	config VaultConfig
+}
+
+type vaultResponse struct {
+	Data struct {
+		Ciphertext string `json:"ciphertext"`
+		Plaintext  string `json:"plaintext"`
+	} `json:"data"`
+}
+
+// NewVaultKeyProvider creates a new Vault transit key provider
+func NewVaultKeyProvider(config VaultConfig) (*VaultKeyProvider, error) {
+	if config.Address == "" || config.KeyName == "" {
+		return nil, ErrInvalidConfig
+	}
+	if config.MountPath == "" {
+		config.MountPath = "transit"
+	}
+	config.Address = strings.TrimSuffix(config.Address, "/")
+	config.MountPath = strings.Trim(config.MountPath, "/")
+
+	return &VaultKeyProvider{
+		config: config,
+	}, nil
+}
+
+// WrapKey encrypts a data key with the transit key
+func (p *VaultKeyProvider) WrapKey(ctx context.Context, plaintext []byte) (string, []byte, error) {
+	return p.WrapKeyWith(ctx, p.config.KeyName, plaintext)
+}
+
+// WrapKeyWith encrypts a data key with another transit key of the same mount
+func (p *VaultKeyProvider) WrapKeyWith(ctx context.Context, keyName string, plaintext []byte) (string, []byte, error) {
+	var resp vaultResponse
+	err := p.call(ctx, "encrypt", keyName, map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(plaintext),
+	}, &resp)
+	if err != nil {
+		return "", nil, err
+	}
+	return keyName, []byte(resp.Data.Ciphertext), nil
+}
+
+// UnwrapKey decrypts a data key with the transit key it was wrapped with
+func (p *VaultKeyProvider) UnwrapKey(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	var resp vaultResponse
+	err := p.call(ctx, "decrypt", p.keyName(keyID), map[string]string{
+		"ciphertext": string(wrapped),
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+	if err != nil {
+		return nil, ErrUnwrapFailed
+	}
+	return plaintext, nil
+}
+
+// RewrapKey re-encrypts a data key with the latest version of the transit key
+// The plaintext data key never leaves Vault
+func (p *VaultKeyProvider) RewrapKey(ctx context.Context, keyID string, wrapped []byte) (string, []byte, error) {
+	keyName := p.keyName(keyID)
+
+	var resp vaultResponse
+	err := p.call(ctx, "rewrap", keyName, map[string]string{
+		"ciphertext": string(wrapped),
+	}, &resp)
+	if err != nil {
+		return "", nil, err
+	}
+	return keyName, []byte(resp.Data.Ciphertext), nil
+}
+
+// keyName returns the transit key of a wrapped key, falling back to the configured key
+func (p *VaultKeyProvider) keyName(keyID string) string {
+	if keyID == "" {
+		return p.config.KeyName
+	}
+	return keyID
+}
+
+// call invokes a transit endpoint, e.g. POST /v1/transit/encrypt/<key>
+func (p *VaultKeyProvider) call(ctx context.Context, operation, keyName string, in map[string]string, out *vaultResponse) error {
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", p.config.Address, p.config.MountPath, operation, keyName)
+
+	headers := map[string]string{"X-Vault-Token": p.config.Token}
+	if p.config.Namespace != "" {
+		headers["X-Vault-Namespace"] = p.config.Namespace
+	}
+
+	if err := postJSON(ctx, p.config.HTTPClient, url, headers, in, out); err != nil {
+		return fmt.Errorf("failed to %s data key with Vault: %w", operation, err)
+	}
+	return nil
+}
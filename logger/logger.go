@@ -0,0 +1,132 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+)
+
+// Logger is the structured logger used for all internal logging
+// It is a superset of middleware.Logger, so any Logger can also be used for audit logging
+type Logger interface {
+	Debug(msg string, fields map[string]interface{})
+	Info(msg string, fields map[string]interface{})
+	Warn(msg string, fields map[string]interface{})
+	Error(msg string, fields map[string]interface{})
+}
+
+// Default returns a Logger writing to slog.Default()
+func Default() Logger {
+	return NewSlog(slog.Default())
+}
+
+// OrDefault returns l, or the default logger when l is nil
+func OrDefault(l Logger) Logger {
+	if l == nil {
+		return Default()
+	}
+	return l
+}
+
+// Nop returns a Logger that discards everything
+func Nop() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, fields map[string]interface{}) {}
+func (nopLogger) Info(msg string, fields map[string]interface{})  {}
+func (nopLogger) Warn(msg string, fields map[string]interface{})  {}
+func (nopLogger) Error(msg string, fields map[string]interface{}) {}
+
+// SlogLogger adapts a *slog.Logger
+type SlogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlog creates a Logger backed by log/slog
+func NewSlog(l *slog.Logger) *SlogLogger {
+	return &SlogLogger{logger: l}
+}
+
+func (l *SlogLogger) Debug(msg string, fields map[string]interface{}) {
+	l.log(slog.LevelDebug, msg, fields)
+}
+
+func (l *SlogLogger) Info(msg string, fields map[string]interface{}) {
+	l.log(slog.LevelInfo, msg, fields)
+}
+
+func (l *SlogLogger) Warn(msg string, fields map[string]interface{}) {
+	l.log(slog.LevelWarn, msg, fields)
+}
+
+func (l *SlogLogger) Error(msg string, fields map[string]interface{}) {
+	l.log(slog.LevelError, msg, fields)
+}
+
+func (l *SlogLogger) log(level slog.Level, msg string, fields map[string]interface{}) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	l.logger.Log(ctx, level, msg, keysAndValues(fields)...)
+}
+
+// ZapSugaredLogger is the subset of *zap.SugaredLogger used by the zap adapter
+type ZapSugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// ZapLogger adapts a zap sugared logger
+type ZapLogger struct {
+	logger ZapSugaredLogger
+}
+
+// NewZap creates a Logger backed by zap, e.g. NewZap(zapLogger.Sugar())
+func NewZap(l ZapSugaredLogger) *ZapLogger {
+	return &ZapLogger{logger: l}
+}
+
+func (l *ZapLogger) Debug(msg string, fields map[string]interface{}) {
+	l.logger.Debugw(msg, keysAndValues(fields)...)
+}
+
+func (l *ZapLogger) Info(msg string, fields map[string]interface{}) {
+	l.logger.Infow(msg, keysAndValues(fields)...)
+}
+
+func (l *ZapLogger) Warn(msg string, fields map[string]interface{}) {
+	l.logger.Warnw(msg, keysAndValues(fields)...)
+}
+
+func (l *ZapLogger) Error(msg string, fields map[string]interface{}) {
+	l.logger.Errorw(msg, keysAndValues(fields)...)
+}
+
+// keysAndValues flattens fields into alternating keys and values, sorted by key
+func keysAndValues(fields map[string]interface{}) []interface{} {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	args := make([]interface{}, 0, len(fields)*2)
+	for _, k := range keys {
+		value := fields[k]
+		if err, ok := value.(error); ok {
+			value = err.Error()
+		}
+		args = append(args, k, value)
+	}
+	return args
+}
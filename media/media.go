@@ -0,0 +1,231 @@
+// Package media analyzes audio files and transcodes videos with external tools
+// Analyzers and transcoders are pluggable, FFmpegAnalyzer and FFmpegTranscoder run ffprobe and
+// ffmpeg on the same machine
+package media
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+var ErrUnsupported = &errors.StorageError{Code: "UNSUPPORTED_TYPE", Message: "No analyzer for this file type"}
+
+// AudioAnalysis represents the properties and waveform of an audio file
+type AudioAnalysis struct {
+	Duration   float64   `json:"duration"` // Seconds
+	Bitrate    int       `json:"bitrate"`  // Bits per second
+	SampleRate int       `json:"sample_rate"`
+	Channels   int       `json:"channels"`
+	Codec      string    `json:"codec,omitempty"`
+	Waveform   *Waveform `json:"waveform,omitempty"`
+}
+
+// Waveform holds the peaks of an audio file in the JSON format of audiowaveform, which player
+// libraries such as peaks.js and wavesurfer.js read: a minimum and a maximum per point
+type Waveform struct {
+	Version         int   `json:"version"`
+	Channels        int   `json:"channels"`
+	SampleRate      int   `json:"sample_rate"`
+	SamplesPerPixel int   `json:"samples_per_pixel"`
+	Bits            int   `json:"bits"`
+	Length          int   `json:"length"` // Number of points
+	Data            []int `json:"data"`   // Minimum and maximum of each point, from -128 to 127
+}
+
+// AudioAnalyzer extracts the properties and waveform of audio files
+type AudioAnalyzer interface {
+	// Supports reports whether files of a content type can be analyzed
+	Supports(contentType string) bool
+	// Analyze returns the properties of an audio file, with a waveform of about points points when
+	// points is positive
+	Analyze(ctx context.Context, contentType string, data io.Reader, points int) (*AudioAnalysis, error)
+}
+
+// FFmpegConfig represents the ffmpeg and ffprobe executables
+type FFmpegConfig struct {
+	FFmpegPath  string `json:"ffmpeg_path,omitempty"`  // default "ffmpeg" in PATH
+	FFprobePath string `json:"ffprobe_path,omitempty"` // default "ffprobe" in PATH
+}
+
+// withDefaults returns the configuration with defaults for unset values
+func (c FFmpegConfig) withDefaults() FFmpegConfig {
+	if c.FFmpegPath == "" {
+		c.FFmpegPath = "ffmpeg"
+	}
+	if c.FFprobePath == "" {
+		c.FFprobePath = "ffprobe"
+	}
+	return c
+}
+
+// FFmpegAnalyzer analyzes audio with ffprobe and computes waveforms from audio decoded by ffmpeg
+type FFmpegAnalyzer struct {
+	config FFmpegConfig
+}
+
+// NewFFmpegAnalyzer creates a new ffmpeg analyzer
+func NewFFmpegAnalyzer(config FFmpegConfig) *FFmpegAnalyzer {
+	return &FFmpegAnalyzer{config: config.withDefaults()}
+}
+
+// waveformSampleRate is the rate audio is decoded at for waveforms, enough for their resolution
+const waveformSampleRate = 8000
+
+// Supports reports whether a content type is audio
+func (a *FFmpegAnalyzer) Supports(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), "audio/")
+}
+
+// Analyze writes the data to a temporary file, which both tools read, probes it and decodes it
+// for the waveform
+func (a *FFmpegAnalyzer) Analyze(ctx context.Context, contentType string, data io.Reader, points int) (*AudioAnalysis, error) {
+	if !a.Supports(contentType) {
+		return nil, ErrUnsupported
+	}
+	path, err := tempFile(data)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+
+	analysis, err := a.probe(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if points > 0 && analysis.Duration > 0 {
+		if analysis.Waveform, err = a.waveform(ctx, path, analysis.Duration, points); err != nil {
+			return nil, err
+		}
+	}
+	return analysis, nil
+}
+
+// probeOutput is the part of the JSON output of ffprobe the analyzer reads
+type probeOutput struct {
+	Streams []struct {
+		CodecType  string `json:"codec_type"`
+		CodecName  string `json:"codec_name"`
+		SampleRate string `json:"sample_rate"`
+		Channels   int    `json:"channels"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"streams"`
+	Format struct {
+		Duration string `json:"duration"`
+		BitRate  string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// probe reads the properties of the first audio stream of a file
+func (a *FFmpegAnalyzer) probe(ctx context.Context, path string) (*AudioAnalysis, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, a.config.FFprobePath, "-v", "error", "-print_format", "json", "-show_format", "-show_streams", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to probe audio with ffprobe: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var output probeOutput
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	analysis := &AudioAnalysis{}
+	analysis.Duration, _ = strconv.ParseFloat(output.Format.Duration, 64)
+	analysis.Bitrate, _ = strconv.Atoi(output.Format.BitRate)
+	for _, stream := range output.Streams {
+		if stream.CodecType != "audio" {
+			continue
+		}
+		analysis.Codec = stream.CodecName
+		analysis.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+		analysis.Channels = stream.Channels
+		if bitrate, err := strconv.Atoi(stream.BitRate); err == nil && bitrate > 0 {
+			analysis.Bitrate = bitrate
+		}
+		return analysis, nil
+	}
+	return nil, fmt.Errorf("no audio stream found")
+}
+
+// waveform decodes a file to mono 16-bit samples and reduces them to the peaks of points points
+func (a *FFmpegAnalyzer) waveform(ctx context.Context, path string, duration float64, points int) (*Waveform, error) {
+	samplesPerPixel := int(math.Ceil(duration * waveformSampleRate / float64(points)))
+	if samplesPerPixel < 1 {
+		samplesPerPixel = 1
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, a.config.FFmpegPath, "-v", "error", "-i", path,
+		"-ac", "1", "-ar", strconv.Itoa(waveformSampleRate), "-f", "s16le", "-acodec", "pcm_s16le", "-")
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to decode audio with ffmpeg: %w", err)
+	}
+
+	waveform := &Waveform{Version: 2, Channels: 1, SampleRate: waveformSampleRate, SamplesPerPixel: samplesPerPixel, Bits: 8}
+	data := make([]int, 0, 2*(points+1))
+	sample := make([]byte, 2)
+	reader := bufio.NewReader(stdout)
+	minimum, maximum, count := 0, 0, 0
+	for {
+		if _, err := io.ReadFull(reader, sample); err != nil {
+			break
+		}
+		// 16-bit samples are scaled to the 8 bits of the waveform
+		value := int(int16(binary.LittleEndian.Uint16(sample))) >> 8
+		if count == 0 || value < minimum {
+			minimum = value
+		}
+		if count == 0 || value > maximum {
+			maximum = value
+		}
+		count++
+		if count == samplesPerPixel {
+			data = append(data, minimum, maximum)
+			count = 0
+		}
+	}
+	if count > 0 {
+		data = append(data, minimum, maximum)
+	}
+	if err := cmd.Wait(); err != nil {
+		return nil, fmt.Errorf("failed to decode audio with ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	waveform.Data = data
+	waveform.Length = len(data) / 2
+	return waveform, nil
+}
+
+// tempFile writes data to a temporary file and returns its path
+func tempFile(data io.Reader) (string, error) {
+	file, err := os.CreateTemp("", "media-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	_, err = io.Copy(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	return file.Name(), nil
+}
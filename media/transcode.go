@@ -0,0 +1,170 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// HLSManifest is the name of the master playlist of a rendition ladder
+const HLSManifest = "master.m3u8"
+
+// Rendition represents one quality of a transcoded video
+type Rendition struct {
+	Name         string `json:"name"` // Directory of its playlist and segments, e.g. 720p
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	VideoBitrate int    `json:"video_bitrate"` // Kilobits per second
+	AudioBitrate int    `json:"audio_bitrate"` // Kilobits per second
+}
+
+// DefaultRenditions returns a 480p, 720p and 1080p H.264 ladder
+func DefaultRenditions() []Rendition {
+	return []Rendition{
+		{Name: "480p", Width: 854, Height: 480, VideoBitrate: 1400, AudioBitrate: 128},
+		{Name: "720p", Width: 1280, Height: 720, VideoBitrate: 2800, AudioBitrate: 128},
+		{Name: "1080p", Width: 1920, Height: 1080, VideoBitrate: 5000, AudioBitrate: 192},
+	}
+}
+
+// Transcoder produces HLS rendition ladders of videos
+type Transcoder interface {
+	// Supports reports whether files of a content type can be transcoded
+	Supports(contentType string) bool
+	// Transcode writes the playlist and segments of every rendition to a directory named after it
+	// in dir, and HLSManifest referencing them. Renditions taller than the video are skipped, the
+	// smallest is always produced; it returns the produced renditions
+	Transcode(ctx context.Context, contentType string, data io.Reader, renditions []Rendition, segmentSeconds int, dir string) ([]Rendition, error)
+}
+
+// FFmpegTranscoder transcodes videos to H.264 and AAC with ffmpeg
+type FFmpegTranscoder struct {
+	config FFmpegConfig
+}
+
+// NewFFmpegTranscoder creates a new ffmpeg transcoder
+func NewFFmpegTranscoder(config FFmpegConfig) *FFmpegTranscoder {
+	return &FFmpegTranscoder{config: config.withDefaults()}
+}
+
+// Supports reports whether a content type is video
+func (t *FFmpegTranscoder) Supports(contentType string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(contentType)), "video/")
+}
+
+// Transcode writes the video to a temporary file, which every rendition reads, and runs ffmpeg
+// once per rendition
+func (t *FFmpegTranscoder) Transcode(ctx context.Context, contentType string, data io.Reader, renditions []Rendition, segmentSeconds int, dir string) ([]Rendition, error) {
+	if !t.Supports(contentType) {
+		return nil, ErrUnsupported
+	}
+	if len(renditions) == 0 {
+		return nil, fmt.Errorf("no renditions to produce")
+	}
+	if segmentSeconds <= 0 {
+		segmentSeconds = 6
+	}
+	path, err := tempFile(data)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+
+	height, err := t.height(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	ladder := ladder(renditions, height)
+
+	for _, rendition := range ladder {
+		if err := t.rendition(ctx, path, rendition, segmentSeconds, filepath.Join(dir, rendition.Name)); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, HLSManifest), masterPlaylist(ladder), 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write master playlist: %w", err)
+	}
+	return ladder, nil
+}
+
+// ladder returns the renditions not taller than a video by height, at least the smallest one
+func ladder(renditions []Rendition, height int) []Rendition {
+	sorted := append([]Rendition(nil), renditions...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Height < sorted[j].Height
+	})
+	ladder := sorted[:1]
+	for _, rendition := range sorted[1:] {
+		if rendition.Height <= height {
+			ladder = append(ladder, rendition)
+		}
+	}
+	return ladder
+}
+
+// height returns the height of the first video stream of a file
+func (t *FFmpegTranscoder) height(ctx context.Context, path string) (int, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.config.FFprobePath, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=height", "-print_format", "json", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("failed to probe video with ffprobe: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var output struct {
+		Streams []struct {
+			Height int `json:"height"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &output); err != nil {
+		return 0, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	if len(output.Streams) == 0 {
+		return 0, fmt.Errorf("no video stream found")
+	}
+	return output.Streams[0].Height, nil
+}
+
+// rendition transcodes a video to the playlist and segments of one rendition in dir
+func (t *FFmpegTranscoder) rendition(ctx context.Context, path string, rendition Rendition, segmentSeconds int, dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create rendition directory: %w", err)
+	}
+	videoBitrate := strconv.Itoa(rendition.VideoBitrate) + "k"
+	cmd := exec.CommandContext(ctx, t.config.FFmpegPath, "-v", "error", "-y", "-i", path,
+		// Widths are derived from the aspect ratio, H.264 needs them even
+		"-vf", "scale=-2:"+strconv.Itoa(rendition.Height),
+		"-c:v", "libx264", "-preset", "veryfast", "-profile:v", "main",
+		"-b:v", videoBitrate, "-maxrate", videoBitrate, "-bufsize", strconv.Itoa(2*rendition.VideoBitrate)+"k",
+		// Keyframes on segment boundaries let players switch renditions between segments
+		"-force_key_frames", "expr:gte(t,n_forced*"+strconv.Itoa(segmentSeconds)+")",
+		"-c:a", "aac", "-b:a", strconv.Itoa(rendition.AudioBitrate)+"k", "-ac", "2",
+		"-f", "hls", "-hls_time", strconv.Itoa(segmentSeconds), "-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, "segment_%04d.ts"),
+		filepath.Join(dir, "index.m3u8"))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to transcode %s rendition with ffmpeg: %w: %s", rendition.Name, err, bytes.TrimSpace(output))
+	}
+	return nil
+}
+
+// masterPlaylist returns the HLS master playlist of a ladder, lowest quality first
+func masterPlaylist(ladder []Rendition) []byte {
+	var playlist bytes.Buffer
+	playlist.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, rendition := range ladder {
+		fmt.Fprintf(&playlist, "#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,CODECS=\"avc1.4d401f,mp4a.40.2\"\n%s/index.m3u8\n",
+			(rendition.VideoBitrate+rendition.AudioBitrate)*1000, rendition.Width, rendition.Height, rendition.Name)
+	}
+	return playlist.Bytes()
+}
@@ -0,0 +1,90 @@
+package metadata
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// defaultMaxAccessRecords is the number of records kept per file by default
+const defaultMaxAccessRecords = 1000
+
+// MemoryAccessLog is an in-process access log, records are lost on restart
+type MemoryAccessLog struct {
+	records    map[string][]interfaces.AccessRecord
+	maxRecords int
+	mutex      sync.RWMutex
+}
+
+// NewMemoryAccessLog creates a new in-memory access log keeping the latest maxRecords
+// records per file, 1000 when maxRecords is not positive
+func NewMemoryAccessLog(maxRecords int) *MemoryAccessLog {
+	if maxRecords <= 0 {
+		maxRecords = defaultMaxAccessRecords
+	}
+	return &MemoryAccessLog{
+		records:    make(map[string][]interfaces.AccessRecord),
+		maxRecords: maxRecords,
+	}
+}
+
+// Record appends a record, dropping the oldest record of the file when it has too many
+func (l *MemoryAccessLog) Record(ctx context.Context, record *interfaces.AccessRecord) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	records := append(l.records[record.FileKey], *record)
+	if len(records) > l.maxRecords {
+		records = append(records[:0:0], records[len(records)-l.maxRecords:]...)
+	}
+	l.records[record.FileKey] = records
+	return nil
+}
+
+// History returns a copy of the records of a file, newest first
+func (l *MemoryAccessLog) History(ctx context.Context, fileKey string) ([]interfaces.AccessRecord, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	records := l.records[fileKey]
+	history := make([]interfaces.AccessRecord, len(records))
+	for i, record := range records {
+		history[len(records)-1-i] = record
+	}
+	return history, nil
+}
+
+// Delete removes the records of a file
+func (l *MemoryAccessLog) Delete(ctx context.Context, fileKey string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.records, fileKey)
+	return nil
+}
+
+// DeleteUser removes the records of a user's reads of files under a key prefix
+func (l *MemoryAccessLog) DeleteUser(ctx context.Context, prefix, userID string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for fileKey, records := range l.records {
+		if !strings.HasPrefix(fileKey, prefix) {
+			continue
+		}
+		kept := records[:0]
+		for _, record := range records {
+			if record.UserID != userID {
+				kept = append(kept, record)
+			}
+		}
+		if len(kept) == 0 {
+			delete(l.records, fileKey)
+			continue
+		}
+		l.records[fileKey] = kept
+	}
+	return nil
+}
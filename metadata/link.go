@@ -0,0 +1,87 @@
+package metadata
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// MemoryLinkStore is an in-process public link store, links are lost on restart
+type MemoryLinkStore struct {
+	links map[string]interfaces.PublicLink
+	mutex sync.RWMutex
+}
+
+// NewMemoryLinkStore creates a new in-memory public link store
+func NewMemoryLinkStore() *MemoryLinkStore {
+	return &MemoryLinkStore{
+		links: make(map[string]interfaces.PublicLink),
+	}
+}
+
+// Save stores a copy of the link
+func (s *MemoryLinkStore) Save(ctx context.Context, link *interfaces.PublicLink) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.links[link.Token] = *link
+	return nil
+}
+
+// Get returns a copy of a link
+func (s *MemoryLinkStore) Get(ctx context.Context, token string) (*interfaces.PublicLink, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	link, exists := s.links[token]
+	if !exists {
+		return nil, errors.ErrLinkNotFound
+	}
+	return &link, nil
+}
+
+// Delete removes a link
+func (s *MemoryLinkStore) Delete(ctx context.Context, token string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.links, token)
+	return nil
+}
+
+// List returns copies of the links of a file, oldest first
+func (s *MemoryLinkStore) List(ctx context.Context, fileKey string) ([]interfaces.PublicLink, error) {
+	s.mutex.RLock()
+	links := []interfaces.PublicLink{}
+	for _, link := range s.links {
+		if link.FileKey == fileKey {
+			links = append(links, link)
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(links, func(i, j int) bool {
+		return links[i].CreatedAt.Before(links[j].CreatedAt)
+	})
+	return links, nil
+}
+
+// Increment counts a download of a link unless it reached max downloads
+func (s *MemoryLinkStore) Increment(ctx context.Context, token string, max int64) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	link, exists := s.links[token]
+	if !exists {
+		return false, errors.ErrLinkNotFound
+	}
+	if max > 0 && link.Downloads >= max {
+		return false, nil
+	}
+	link.Downloads++
+	s.links[token] = link
+	return true, nil
+}
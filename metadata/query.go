@@ -0,0 +1,137 @@
+package metadata
+
+import (
+	"context"
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// Query answers a query over the records of the store
+func (s *MemoryStore) Query(ctx context.Context, query *interfaces.QueryRequest) (*interfaces.QueryResponse, error) {
+	return Scan(ctx, s, query)
+}
+
+// Scan answers a query by listing the records of a store, for stores without their own Query
+// Every call reads all records under the query prefix, page tokens are offsets into the sorted result
+func Scan(ctx context.Context, store interfaces.MetadataStore, query *interfaces.QueryRequest) (*interfaces.QueryResponse, error) {
+	offset, err := decodePageToken(query.PageToken)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*interfaces.FileMetadata
+	err = store.List(ctx, query.Prefix, func(record *interfaces.FileMetadata) error {
+		if Match(record, query) {
+			records = append(records, record)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	Sort(records, query)
+
+	response := &interfaces.QueryResponse{Success: true, Files: []interfaces.FileMetadata{}}
+	if offset >= len(records) {
+		return response, nil
+	}
+	end := len(records)
+	if query.Limit > 0 && offset+query.Limit < end {
+		end = offset + query.Limit
+		response.NextPageToken = encodePageToken(end)
+	}
+	for _, record := range records[offset:end] {
+		response.Files = append(response.Files, *record)
+	}
+	return response, nil
+}
+
+// Match reports whether a record matches the filters of a query
+func Match(record *interfaces.FileMetadata, query *interfaces.QueryRequest) bool {
+	switch {
+	case !strings.HasPrefix(record.FileKey, query.Prefix),
+		query.Namespace != "" && record.Namespace != query.Namespace,
+		query.EntityType != "" && record.EntityType != query.EntityType,
+		query.EntityID != "" && record.EntityID != query.EntityID,
+		query.Category != "" && record.Category != query.Category,
+		query.UploadedBy != "" && record.UploadedBy != query.UploadedBy,
+		!query.UploadedAfter.IsZero() && record.UploadedAt.Before(query.UploadedAfter),
+		!query.UploadedBefore.IsZero() && !record.UploadedAt.Before(query.UploadedBefore),
+		query.MinSize > 0 && record.FileSize < query.MinSize,
+		query.MaxSize > 0 && record.FileSize > query.MaxSize:
+		return false
+	}
+
+	if query.ContentType != "" {
+		contentType := strings.ToLower(strings.TrimSpace(strings.Split(record.ContentType, ";")[0]))
+		wanted := strings.ToLower(query.ContentType)
+		if contentType != wanted && !(strings.HasSuffix(wanted, "/") && strings.HasPrefix(contentType, wanted)) {
+			return false
+		}
+	}
+
+	for key, value := range query.Tags {
+		found := false
+		for _, tag := range record.Tags {
+			if tag == key+"="+value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Sort orders records by the sort options of a query, ties are ordered by file key
+func Sort(records []*interfaces.FileMetadata, query *interfaces.QueryRequest) {
+	sort.SliceStable(records, func(i, j int) bool {
+		a, b := records[i], records[j]
+		if query.Descending {
+			a, b = b, a
+		}
+		switch query.SortBy {
+		case interfaces.SortByFileSize:
+			if a.FileSize != b.FileSize {
+				return a.FileSize < b.FileSize
+			}
+		case interfaces.SortByFileName:
+			if a.FileName != b.FileName {
+				return a.FileName < b.FileName
+			}
+		case interfaces.SortByFileKey:
+		default:
+			if !a.UploadedAt.Equal(b.UploadedAt) {
+				return a.UploadedAt.Before(b.UploadedAt)
+			}
+		}
+		return a.FileKey < b.FileKey
+	})
+}
+
+// encodePageToken returns the page token of an offset
+func encodePageToken(offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(offset)))
+}
+
+// decodePageToken returns the offset of a page token, 0 for the first page
+func decodePageToken(token string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err == nil {
+		var offset int
+		if offset, err = strconv.Atoi(string(decoded)); err == nil && offset >= 0 {
+			return offset, nil
+		}
+	}
+	return 0, &errors.StorageError{Code: errors.ErrInvalidRequest.Code, Message: "Invalid page token"}
+}
@@ -0,0 +1,73 @@
+package metadata
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// MemoryShareStore is an in-process share store, shares are lost on restart
+type MemoryShareStore struct {
+	shares map[string]interfaces.Share
+	mutex  sync.RWMutex
+}
+
+// NewMemoryShareStore creates a new in-memory share store
+func NewMemoryShareStore() *MemoryShareStore {
+	return &MemoryShareStore{
+		shares: make(map[string]interfaces.Share),
+	}
+}
+
+// Save stores a copy of the share
+func (s *MemoryShareStore) Save(ctx context.Context, share *interfaces.Share) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.shares[share.ID] = *share
+	return nil
+}
+
+// Get returns a copy of a share
+func (s *MemoryShareStore) Get(ctx context.Context, id string) (*interfaces.Share, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	share, exists := s.shares[id]
+	if !exists {
+		return nil, errors.ErrShareNotFound
+	}
+	return &share, nil
+}
+
+// Delete removes a share
+func (s *MemoryShareStore) Delete(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.shares, id)
+	return nil
+}
+
+// List returns copies of the matching shares, oldest first
+func (s *MemoryShareStore) List(ctx context.Context, query interfaces.ShareQuery) ([]interfaces.Share, error) {
+	s.mutex.RLock()
+	shares := []interfaces.Share{}
+	for _, share := range s.shares {
+		if query.Matches(&share) {
+			shares = append(shares, share)
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(shares, func(i, j int) bool {
+		if shares[i].CreatedAt.Equal(shares[j].CreatedAt) {
+			return shares[i].ID < shares[j].ID
+		}
+		return shares[i].CreatedAt.Before(shares[j].CreatedAt)
+	})
+	return shares, nil
+}
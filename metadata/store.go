@@ -0,0 +1,97 @@
+package metadata
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// MemoryStore is an in-process metadata store, records are lost on restart
+type MemoryStore struct {
+	records map[string]*interfaces.FileMetadata
+	mutex   sync.RWMutex
+}
+
+// NewMemoryStore creates a new in-memory metadata store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		records: make(map[string]*interfaces.FileMetadata),
+	}
+}
+
+// Save stores a copy of the record
+func (s *MemoryStore) Save(ctx context.Context, metadata *interfaces.FileMetadata) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.records[metadata.FileKey] = clone(metadata)
+	return nil
+}
+
+// Get returns a copy of the record of a file
+func (s *MemoryStore) Get(ctx context.Context, fileKey string) (*interfaces.FileMetadata, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	record, exists := s.records[fileKey]
+	if !exists {
+		return nil, errors.ErrFileNotFound
+	}
+	return clone(record), nil
+}
+
+// Delete removes the record of a file
+func (s *MemoryStore) Delete(ctx context.Context, fileKey string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.records, fileKey)
+	return nil
+}
+
+// List calls fn with copies of the records under prefix, ordered by file key
+func (s *MemoryStore) List(ctx context.Context, prefix string, fn func(metadata *interfaces.FileMetadata) error) error {
+	s.mutex.RLock()
+	records := make([]*interfaces.FileMetadata, 0, len(s.records))
+	for fileKey, record := range s.records {
+		if strings.HasPrefix(fileKey, prefix) {
+			records = append(records, clone(record))
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].FileKey < records[j].FileKey
+	})
+	for _, record := range records {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clone copies a record including its slices
+func clone(metadata *interfaces.FileMetadata) *interfaces.FileMetadata {
+	copied := *metadata
+	copied.Tags = append([]string(nil), metadata.Tags...)
+	copied.Thumbnails = append([]interfaces.ThumbnailInfo(nil), metadata.Thumbnails...)
+	if metadata.Metadata != nil {
+		copied.Metadata = make(map[string]string, len(metadata.Metadata))
+		for key, value := range metadata.Metadata {
+			copied.Metadata[key] = value
+		}
+	}
+	if metadata.ExpiresAt != nil {
+		expiresAt := *metadata.ExpiresAt
+		copied.ExpiresAt = &expiresAt
+	}
+	return &copied
+}
@@ -8,33 +8,64 @@ import (
 	"image/jpeg"
 	"image/png"
 	"io"
-	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
+	"github.com/darmawan01/storage/jobs"
+	"github.com/darmawan01/storage/logger"
 	"github.com/minio/minio-go/v7"
 )
 
 // AsyncProcessor handles background processing of tasks like thumbnail generation
+// It is backed by a generic jobs.Processor, so other job types can be registered on it as well
 type AsyncProcessor struct {
-	jobQueue chan ThumbnailJob
-	workers  int
-	ctx      context.Context
-	cancel   context.CancelFunc
-	wg       sync.WaitGroup
-	client   *minio.Client // MinIO client
-	config   AsyncConfig
-	bucket   string // Storage bucket name
+	jobs      *jobs.Processor
+	client    *minio.Client // MinIO client
+	config    AsyncConfig
+	bucket    string // Storage bucket name
+	callbacks map[string]func(*ThumbnailResponse)
+
+	// Receivers of finished thumbnail job statuses
+	subscribers map[chan *ThumbnailStatus]struct{}
+	mutex       sync.Mutex
+
+	// Shared by the transfers of all background jobs, nil when unlimited
+	bandwidth *BandwidthLimiter
 }
 
 // AsyncConfig represents async processor configuration
 type AsyncConfig struct {
-	Workers        int           `json:"workers"`         // Number of worker goroutines
+	Workers        int           `json:"workers"`         // Number of thumbnail worker goroutines
 	QueueSize      int           `json:"queue_size"`      // Size of job queue
 	RetryAttempts  int           `json:"retry_attempts"`  // Number of retry attempts
 	RetryDelay     time.Duration `json:"retry_delay"`     // Delay between retries
-	MaxConcurrency int           `json:"max_concurrency"` // Maximum concurrent jobs
+	MaxConcurrency int           `json:"max_concurrency"` // Default worker count for other job types
+
+	// Per job type concurrency limits, e.g. {"transcode": 1, "checksum": 4}
+	Concurrency map[jobs.Type]int `json:"concurrency,omitempty"`
+
+	// Time limit of a single job attempt, 0 means none; timed out attempts are retried
+	JobTimeout time.Duration `json:"job_timeout,omitempty"`
+	// Per job type time limits, e.g. {"transcode": "30m"}
+	Timeouts map[jobs.Type]time.Duration `json:"timeouts,omitempty"`
+
+	// Bytes per second all background jobs may transfer from and to MinIO together, 0 means unlimited
+	// Keeps bulk work such as thumbnail regeneration from saturating the link to MinIO
+	Bandwidth int64 `json:"bandwidth,omitempty"`
+
+	// Pluggable backends, in-memory implementations are used when nil
+	// Use a durable queue (jobs.RedisQueue, jobs.NATSQueue) so pending jobs survive restarts
+	Queue jobs.Queue `json:"-"`
+	Store jobs.Store `json:"-"`
+
+	// Only enqueue jobs, dedicated worker processes sharing Queue run them
+	ProducerOnly bool `json:"producer_only"`
+
+	// Optional URL receiving a JSON ThumbnailStatus when a thumbnail job finishes
+	WebhookURL     string        `json:"webhook_url,omitempty"`
+	WebhookTimeout time.Duration `json:"webhook_timeout,omitempty"`
+
+	Logger logger.Logger `json:"-"` // Defaults to logger.Default()
 }
 
 // ThumbnailJob represents a thumbnail generation job
@@ -50,6 +81,24 @@ type ThumbnailJob struct {
 	Metadata    map[string]interface{}   `json:"metadata"`
 	CreatedAt   time.Time                `json:"created_at"`
 	RetryCount  int                      `json:"retry_count"`
+
+	// Where thumbnails are written, BucketName and the server default storage class when empty
+	DerivedBucket string `json:"derived_bucket,omitempty"`
+	StorageClass  string `json:"storage_class,omitempty"`
+
+	// Source limits, see ThumbnailConfig
+	MaxSourceSize   int64 `json:"max_source_size,omitempty"`
+	MaxSourceWidth  int   `json:"max_source_width,omitempty"`
+	MaxSourceHeight int   `json:"max_source_height,omitempty"`
+
+	// Time limit of each attempt, the processor JobTimeout when 0
+	Timeout time.Duration `json:"timeout,omitempty"`
+}
+
+// derivedDestination is where generated files are stored
+type derivedDestination struct {
+	bucket       string
+	storageClass string
 }
 
 // ThumbnailResponse represents the result of thumbnail generation
@@ -64,52 +113,74 @@ type ThumbnailResponse struct {
 
 // NewAsyncProcessor creates a new async processor
 func NewAsyncProcessor(config AsyncConfig, client *minio.Client, bucket string) *AsyncProcessor {
-	ctx, cancel := context.WithCancel(context.Background())
+	config.Logger = logger.OrDefault(config.Logger)
 
 	processor := &AsyncProcessor{
-		jobQueue: make(chan ThumbnailJob, config.QueueSize),
-		workers:  config.Workers,
-		ctx:      ctx,
-		cancel:   cancel,
-		client:   client,
-		config:   config,
-		bucket:   bucket,
+		jobs: jobs.NewProcessor(jobs.Config{
+			DefaultConcurrency: config.MaxConcurrency,
+			Concurrency:        config.Concurrency,
+			RetryAttempts:      config.RetryAttempts,
+			RetryDelay:         config.RetryDelay,
+			ProducerOnly:       config.ProducerOnly,
+			Timeout:            config.JobTimeout,
+			Timeouts:           config.Timeouts,
+		}, newQueue(config), config.Store),
+		client:      client,
+		config:      config,
+		bucket:      bucket,
+		callbacks:   make(map[string]func(*ThumbnailResponse)),
+		subscribers: make(map[chan *ThumbnailStatus]struct{}),
+		bandwidth:   NewBandwidthLimiter(config.Bandwidth),
 	}
 
-	// Start worker goroutines
-	processor.startWorkers()
+	// Start thumbnail workers
+	_ = processor.jobs.Register(jobs.TypeThumbnail, jobs.HandlerFunc(processor.processJob), config.Workers)
 
 	return processor
 }
 
-// startWorkers starts the worker goroutines
-func (p *AsyncProcessor) startWorkers() {
-	for i := 0; i < p.workers; i++ {
-		p.wg.Add(1)
-		go p.worker(i)
+// newQueue returns the configured queue or an in-memory one
+func newQueue(config AsyncConfig) jobs.Queue {
+	if config.Queue != nil {
+		return config.Queue
 	}
+	return jobs.NewMemoryQueue(config.QueueSize)
 }
 
-// worker processes jobs from the queue
-func (p *AsyncProcessor) worker(workerID int) {
-	defer p.wg.Done()
+// Jobs returns the underlying job processor for registering other job types
+func (p *AsyncProcessor) Jobs() *jobs.Processor {
+	return p.jobs
+}
 
-	for {
-		select {
-		case job := <-p.jobQueue:
-			p.processJob(job)
-		case <-p.ctx.Done():
-			return
-		}
-	}
+// Bandwidth returns the limiter shared by background jobs, nil when unlimited
+// Jobs of other types pass it to ThrottleReader for their transfers
+func (p *AsyncProcessor) Bandwidth() *BandwidthLimiter {
+	return p.bandwidth
 }
 
 // processJob processes a single thumbnail job
-func (p *AsyncProcessor) processJob(job ThumbnailJob) {
+func (p *AsyncProcessor) processJob(ctx context.Context, job *jobs.Job) error {
 	start := time.Now()
 
+	bucket := job.BucketName
+	if bucket == "" {
+		bucket = p.bucket
+	}
+
 	// Process thumbnails
-	thumbnails, err := p.generateThumbnails(job)
+	limits := thumbnailLimits{
+		maxSize:   job.Int64("max_source_size"),
+		maxWidth:  int(job.Int64("max_source_width")),
+		maxHeight: int(job.Int64("max_source_height")),
+	}
+	destination := derivedDestination{
+		bucket:       job.String("derived_bucket"),
+		storageClass: job.String("storage_class"),
+	}
+	if destination.bucket == "" {
+		destination.bucket = bucket
+	}
+	thumbnails, err := p.generateThumbnails(ctx, bucket, job.FileKey, job.StringSlice("sizes"), limits, destination)
 
 	duration := time.Since(start)
 
@@ -123,71 +194,131 @@ func (p *AsyncProcessor) processJob(job ThumbnailJob) {
 		Duration:    duration,
 	}
 
-	// Call callback if provided
-	if job.Callback != nil {
-		job.Callback(response)
+	// Oversized sources are not retried, neither are cancelled jobs
+	tooLarge := err == ErrThumbnailSourceTooLarge
+	cancelled := err != nil && context.Cause(ctx) == jobs.ErrJobCancelled
+
+	// Call callback if provided, dropping it once no more attempts will be made
+	p.mutex.Lock()
+	callback := p.callbacks[job.ID]
+	if err == nil || tooLarge || cancelled || job.Attempts >= job.MaxAttempts {
+		delete(p.callbacks, job.ID)
+	}
+	p.mutex.Unlock()
+
+	if callback != nil {
+		callback(response)
 	}
 
 	// Log processing result
-	if err != nil {
-		fmt.Printf("❌ Thumbnail generation failed for %s: %v\n", job.FileKey, err)
-
-		// Retry if retry count is below limit
-		if job.RetryCount < p.config.RetryAttempts {
-			job.RetryCount++
-			job.CreatedAt = time.Now()
-
-			// Schedule retry with delay
-			go func() {
-				time.Sleep(p.config.RetryDelay)
-				select {
-				case p.jobQueue <- job:
-				case <-p.ctx.Done():
-				}
-			}()
+	switch {
+	case tooLarge:
+		job.Result = map[string]interface{}{
+			"thumbnail_status": ThumbnailStatusTooLarge,
+			"duration":         duration.String(),
 		}
-	} else {
-		fmt.Printf("✅ Thumbnail generation completed for %s in %v\n", job.FileKey, duration)
+		p.config.Logger.Warn("thumbnail generation skipped: source too large", map[string]interface{}{
+			"job_id":   job.ID,
+			"file_key": job.FileKey,
+		})
+		err = nil
+	case err != nil:
+		p.config.Logger.Error("thumbnail generation failed", map[string]interface{}{
+			"job_id":   job.ID,
+			"file_key": job.FileKey,
+			"attempt":  job.Attempts,
+			"error":    err,
+		})
+	default:
+		job.Result = map[string]interface{}{
+			"thumbnails": thumbnails,
+			"duration":   duration.String(),
+		}
+		p.config.Logger.Info("thumbnail generation completed", map[string]interface{}{
+			"job_id":      job.ID,
+			"file_key":    job.FileKey,
+			"thumbnails":  len(thumbnails),
+			"duration_ms": duration.Milliseconds(),
+		})
+	}
+
+	// Notify once the job will not be retried
+	if err == nil || cancelled || (job.Attempts >= job.MaxAttempts && ctx.Err() == nil) {
+		status := newThumbnailStatus(job)
+		status.Status = jobs.StatusDone
+		switch {
+		case cancelled:
+			status.Status = jobs.StatusCancelled
+			status.Error = jobs.ErrJobCancelled.Error()
+		case err != nil:
+			status.Status = jobs.StatusFailed
+			status.Error = err.Error()
+		}
+		status.UpdatedAt = time.Now()
+		p.notify(ctx, status)
 	}
+
+	return err
 }
 
-// generateThumbnails generates thumbnails for the given job
-func (p *AsyncProcessor) generateThumbnails(job ThumbnailJob) ([]ThumbnailInfo, error) {
+// generateThumbnails generates thumbnails for the given file
+func (p *AsyncProcessor) generateThumbnails(ctx context.Context, bucket, fileKey string, sizes []string, limits thumbnailLimits, destination derivedDestination) ([]ThumbnailInfo, error) {
 	var thumbnails []ThumbnailInfo
 
 	// Read the original file data
-	originalData, err := p.getOriginalFile(job.FileKey)
+	originalData, err := p.getOriginalFile(ctx, bucket, fileKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get original file: %w", err)
 	}
 	defer originalData.Close()
 
+	// Check the limits before decoding the full image
+	if err := checkThumbnailSource(originalData, limits); err != nil {
+		return nil, err
+	}
+
 	// Decode the original image
-	originalImg, format, err := image.Decode(originalData)
+	originalImg, format, err := image.Decode(ThrottleReader(ctx, originalData, p.bandwidth))
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode image: %w", err)
 	}
 
 	// Generate thumbnails for each configured size
-	for _, sizeStr := range job.Sizes {
-		width, height, err := parseThumbnailSize(sizeStr)
+	for _, sizeStr := range sizes {
+		// Stop between sizes when the job timed out or was cancelled
+		if err := ctx.Err(); err != nil {
+			return thumbnails, err
+		}
+
+		width, height, mode, err := parseThumbnailSize(sizeStr)
 		if err != nil {
-			fmt.Printf("Invalid thumbnail size %s: %v\n", sizeStr, err)
+			p.config.Logger.Warn("invalid thumbnail size", map[string]interface{}{
+				"size":  sizeStr,
+				"error": err,
+			})
 			continue
 		}
 
 		// Generate thumbnail
-		thumbnailData, err := p.createThumbnail(originalImg, width, height, format)
+		thumbnailData, err := p.createThumbnail(originalImg, width, height, mode, format)
 		if err != nil {
-			fmt.Printf("Failed to create thumbnail %s: %v\n", sizeStr, err)
+			p.config.Logger.Warn("failed to create thumbnail", map[string]interface{}{
+				"file_key": fileKey,
+				"size":     sizeStr,
+				"error":    err,
+			})
 			continue
 		}
 
 		// Upload thumbnail to storage
-		thumbnailKey := p.generateThumbnailKey(job.FileKey, sizeStr)
-		thumbnailURL, err := p.uploadThumbnail(thumbnailKey, thumbnailData, format)
+		thumbnailKey := ThumbnailKey(fileKey, sizeStr)
+		thumbnailURL, err := p.uploadThumbnail(ctx, destination, thumbnailKey, thumbnailData, format)
 		if err != nil {
-			fmt.Printf("Failed to upload thumbnail %s: %v\n", sizeStr, err)
+			p.config.Logger.Warn("failed to upload thumbnail", map[string]interface{}{
+				"file_key": fileKey,
+				"size":     sizeStr,
+				"error":    err,
+			})
 			continue
 		}
 
@@ -205,9 +336,9 @@ func (p *AsyncProcessor) generateThumbnails(job ThumbnailJob) ([]ThumbnailInfo,
 }
 
 // getOriginalFile retrieves the original file from storage
-func (p *AsyncProcessor) getOriginalFile(fileKey string) (io.ReadCloser, error) {
+func (p *AsyncProcessor) getOriginalFile(ctx context.Context, bucket, fileKey string) (*minio.Object, error) {
 	// Get the object from MinIO
-	object, err := p.client.GetObject(context.Background(), p.bucket, fileKey, minio.GetObjectOptions{})
+	object, err := p.client.GetObject(ctx, bucket, fileKey, minio.GetObjectOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get object from MinIO: %w", err)
 	}
@@ -216,9 +347,14 @@ func (p *AsyncProcessor) getOriginalFile(fileKey string) (io.ReadCloser, error)
 }
 
 // createThumbnail creates a thumbnail from the original image
-func (p *AsyncProcessor) createThumbnail(originalImg image.Image, width, height int, format string) ([]byte, error) {
-	// Resize the image
-	resizedImg := p.resizeImage(originalImg, width, height)
+func (p *AsyncProcessor) createThumbnail(originalImg image.Image, width, height int, mode, format string) ([]byte, error) {
+	// Resize the image, cropping it to fill the size in the crop modes
+	var resizedImg image.Image
+	if mode == ThumbnailFit {
+		resizedImg = p.resizeImage(originalImg, width, height)
+	} else {
+		resizedImg = cropImage(originalImg, width, height, mode == ThumbnailSmartCrop)
+	}
 
 	// Encode the resized image
 	var buf bytes.Buffer
@@ -292,9 +428,9 @@ func (p *AsyncProcessor) resizeImage(img image.Image, width, height int) image.I
 }
 
 // uploadThumbnail uploads the thumbnail to storage
-func (p *AsyncProcessor) uploadThumbnail(key string, data []byte, format string) (string, error) {
+func (p *AsyncProcessor) uploadThumbnail(ctx context.Context, destination derivedDestination, key string, data []byte, format string) (string, error) {
 	// Create a reader from the byte data
-	reader := bytes.NewReader(data)
+	reader := ThrottleReader(ctx, bytes.NewReader(data), p.bandwidth)
 
 	// Determine content type based on format
 	contentType := "image/jpeg"
@@ -304,13 +440,14 @@ func (p *AsyncProcessor) uploadThumbnail(key string, data []byte, format string)
 
 	// Upload the thumbnail to MinIO
 	_, err := p.client.PutObject(
-		context.Background(),
-		p.bucket,
+		ctx,
+		destination.bucket,
 		key,
 		reader,
 		int64(len(data)),
 		minio.PutObjectOptions{
-			ContentType: contentType,
+			ContentType:  contentType,
+			StorageClass: destination.storageClass,
 		},
 	)
 	if err != nil {
@@ -322,64 +459,96 @@ func (p *AsyncProcessor) uploadThumbnail(key string, data []byte, format string)
 	return thumbnailURL, nil
 }
 
-// generateThumbnailKey generates a key for the thumbnail using predictable naming
-func (p *AsyncProcessor) generateThumbnailKey(originalKey, size string) string {
-	// Use predictable naming pattern: original_file_key_512x512.png
-	// This makes it easy for users to construct thumbnail URLs
+// SubmitJob submits a thumbnail job for processing
+func (p *AsyncProcessor) SubmitJob(ctx context.Context, job ThumbnailJob) error {
+	genericJob := &jobs.Job{
+		ID:         job.ID,
+		Type:       jobs.TypeThumbnail,
+		FileKey:    job.FileKey,
+		BucketName: job.BucketName,
+		CreatedAt:  job.CreatedAt,
+		Timeout:    job.Timeout,
+		Payload: map[string]interface{}{
+			"sizes":        job.Sizes,
+			"content_type": job.ContentType,
+			"file_size":    job.FileSize,
+
+			"derived_bucket":    job.DerivedBucket,
+			"storage_class":     job.StorageClass,
+			"max_source_size":   job.MaxSourceSize,
+			"max_source_width":  job.MaxSourceWidth,
+			"max_source_height": job.MaxSourceHeight,
+		},
+	}
+	if genericJob.ID == "" {
+		genericJob.ID = fmt.Sprintf("thumb_%d", time.Now().UnixNano())
+	}
 
-	// Get the file extension from the original key
-	ext := filepath.Ext(originalKey)
-	if ext == "" {
-		ext = ".jpg" // Default to jpg for thumbnails
+	if job.Callback != nil {
+		p.mutex.Lock()
+		p.callbacks[genericJob.ID] = job.Callback
+		p.mutex.Unlock()
 	}
 
-	// Remove the extension from the original key
-	baseKey := strings.TrimSuffix(originalKey, ext)
+	if err := p.jobs.Submit(ctx, genericJob); err != nil {
+		p.mutex.Lock()
+		delete(p.callbacks, genericJob.ID)
+		p.mutex.Unlock()
+		return err
+	}
 
-	// Create the thumbnail key with size suffix
-	thumbnailKey := fmt.Sprintf("%s_%s%s", baseKey, size, ext)
+	return nil
+}
 
-	return thumbnailKey
+// Cancel stops a running or queued job, see jobs.Processor.Cancel
+func (p *AsyncProcessor) Cancel(ctx context.Context, id string) (bool, error) {
+	return p.jobs.Cancel(ctx, id)
 }
 
-// SubmitJob submits a thumbnail job for processing
-func (p *AsyncProcessor) SubmitJob(job ThumbnailJob) error {
-	// Set job ID and creation time if not set
-	if job.ID == "" {
-		job.ID = fmt.Sprintf("thumb_%d", time.Now().UnixNano())
-	}
-	if job.CreatedAt.IsZero() {
-		job.CreatedAt = time.Now()
-	}
+// GetJob returns the current state of a job
+func (p *AsyncProcessor) GetJob(ctx context.Context, id string) (*jobs.Job, error) {
+	return p.jobs.Get(ctx, id)
+}
 
-	select {
-	case p.jobQueue <- job:
-		return nil
-	case <-p.ctx.Done():
-		return fmt.Errorf("async processor is shutting down")
-	default:
-		return fmt.Errorf("job queue is full")
-	}
+// JobsForFile returns all known jobs for a file key
+func (p *AsyncProcessor) JobsForFile(ctx context.Context, fileKey string) ([]*jobs.Job, error) {
+	return p.jobs.JobsForFile(ctx, fileKey)
 }
 
 // GetStats returns processor statistics
 func (p *AsyncProcessor) GetStats() map[string]interface{} {
-	return map[string]interface{}{
-		"workers":         p.workers,
-		"queue_size":      len(p.jobQueue),
-		"max_queue_size":  p.config.QueueSize,
-		"retry_attempts":  p.config.RetryAttempts,
-		"retry_delay":     p.config.RetryDelay,
-		"max_concurrency": p.config.MaxConcurrency,
-		"is_running":      p.ctx.Err() == nil,
+	stats := p.jobs.Stats()
+	stats["workers"] = p.jobs.Workers(jobs.TypeThumbnail)
+	stats["queue_size"] = p.jobs.QueueLen(jobs.TypeThumbnail)
+	stats["max_queue_size"] = p.config.QueueSize
+	stats["max_concurrency"] = p.config.MaxConcurrency
+	return stats
+}
+
+// QueueStatus represents the state of the job queue
+type QueueStatus struct {
+	Running bool `json:"running"`
+	Backlog int  `json:"backlog"`  // Waiting jobs of all types
+	MaxSize int  `json:"max_size"` // Thumbnail queue size, submissions fail once it is full
+}
+
+// QueueStatus returns the state of the job queue
+func (p *AsyncProcessor) QueueStatus() QueueStatus {
+	return QueueStatus{
+		Running: p.jobs.Running(),
+		Backlog: p.jobs.Backlog(),
+		MaxSize: p.config.QueueSize,
 	}
 }
 
 // Stop stops the async processor
 func (p *AsyncProcessor) Stop() {
-	p.cancel()
-	p.wg.Wait()
-	close(p.jobQueue)
+	p.jobs.Stop()
+}
+
+// Shutdown stops taking new jobs and waits for running ones until ctx expires
+func (p *AsyncProcessor) Shutdown(ctx context.Context) error {
+	return p.jobs.Shutdown(ctx)
 }
 
 // DefaultAsyncConfig returns a default async processor configuration
@@ -389,6 +558,7 @@ func DefaultAsyncConfig() AsyncConfig {
 		QueueSize:      100,             // Queue up to 100 jobs
 		RetryAttempts:  2,               // Retry failed jobs twice
 		RetryDelay:     5 * time.Second, // 5 second delay between retries
-		MaxConcurrency: 10,              // Maximum 10 concurrent jobs
+		MaxConcurrency: 10,              // 10 workers for other job types
+		JobTimeout:     5 * time.Minute, // Give up on a stuck attempt after 5 minutes
 	}
 }
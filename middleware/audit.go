@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"time"
+
+	"github.com/darmawan01/storage/logger"
 )
 
 // AuditMiddleware handles audit logging for storage operations
@@ -23,6 +25,10 @@ type AuditConfig struct {
 	Fields      []string `json:"fields"`      // ["user_id", "file_key", "operation", "timestamp"]
 	Destination string   `json:"destination"` // "stdout", "file", "database"
 	FilePath    string   `json:"file_path,omitempty"`
+	// Store keeps the events for queries in addition to logging them, see AuditStore
+	Store AuditStore `json:"-"`
+	// Logger reports failures of the middleware itself, defaults to logger.Default()
+	Logger logger.Logger `json:"-"`
 }
 
 // Logger interface for audit logging
@@ -77,6 +83,7 @@ func (l *DefaultLogger) log(level, msg string, fields map[string]interface{}) {
 type AuditEvent struct {
 	Timestamp   time.Time              `json:"timestamp"`
 	Operation   string                 `json:"operation"`
+	RequestID   string                 `json:"request_id,omitempty"`
 	UserID      string                 `json:"user_id,omitempty"`
 	FileKey     string                 `json:"file_key,omitempty"`
 	FileSize    int64                  `json:"file_size,omitempty"`
@@ -89,17 +96,21 @@ type AuditEvent struct {
 	IPAddress   string                 `json:"ip_address,omitempty"`
 	UserAgent   string                 `json:"user_agent,omitempty"`
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
+	// PrevHash and Hash chain the event to the one stored before it, see ChainedAuditStore
+	PrevHash string `json:"prev_hash,omitempty"`
+	Hash     string `json:"hash,omitempty"`
 }
 
 // NewAuditMiddleware creates a new audit middleware
-func NewAuditMiddleware(config AuditConfig, logger Logger) *AuditMiddleware {
-	if logger == nil {
-		logger = &DefaultLogger{}
+func NewAuditMiddleware(config AuditConfig, auditLogger Logger) *AuditMiddleware {
+	if auditLogger == nil {
+		auditLogger = &DefaultLogger{}
 	}
+	config.Logger = logger.OrDefault(config.Logger)
 
 	return &AuditMiddleware{
 		config: config,
-		logger: logger,
+		logger: auditLogger,
 	}
 }
 
@@ -138,10 +149,22 @@ func (m *AuditMiddleware) Process(ctx context.Context, req *StorageRequest, next
 
 	// Log the audit event
 	m.logAuditEvent(event)
+	m.storeAuditEvent(ctx, event)
 
 	return response, err
 }
 
+// Stop flushes loggers that buffer audit events, e.g. zap loggers with Sync
+func (m *AuditMiddleware) Stop() {
+	if syncer, ok := m.logger.(interface{ Sync() error }); ok {
+		if err := syncer.Sync(); err != nil {
+			m.config.Logger.Warn("failed to flush audit log", map[string]interface{}{
+				"error": err,
+			})
+		}
+	}
+}
+
 // shouldAudit checks if the operation should be audited
 func (m *AuditMiddleware) shouldAudit(operation string) bool {
 	if len(m.config.Operations) == 0 {
@@ -161,6 +184,7 @@ func (m *AuditMiddleware) createAuditEvent(req *StorageRequest) *AuditEvent {
 	event := &AuditEvent{
 		Timestamp:   time.Now(),
 		Operation:   req.Operation,
+		RequestID:   req.RequestID,
 		UserID:      req.UserID,
 		FileKey:     req.FileKey,
 		FileSize:    req.FileSize,
@@ -169,10 +193,12 @@ func (m *AuditMiddleware) createAuditEvent(req *StorageRequest) *AuditEvent {
 		EntityType:  req.EntityType,
 		EntityID:    req.EntityID,
 		Metadata:    req.Metadata,
+		IPAddress:   req.IPAddress,
+		UserAgent:   req.UserAgent,
 	}
 
 	// Add additional context from the request
-	if req.Config != nil {
+	if req.Config != nil && req.IPAddress == "" {
 		if ip, ok := req.Config["ip_address"].(string); ok {
 			event.IPAddress = ip
 		}
@@ -184,6 +210,22 @@ func (m *AuditMiddleware) createAuditEvent(req *StorageRequest) *AuditEvent {
 	return event
 }
 
+// storeAuditEvent saves the audit event to the store, failures only log a warning
+func (m *AuditMiddleware) storeAuditEvent(ctx context.Context, event *AuditEvent) {
+	if m.config.Store == nil {
+		return
+	}
+	// The operation is over, a cancelled request still gets its event
+	if err := m.config.Store.Save(context.WithoutCancel(ctx), event); err != nil {
+		m.logger.Warn("Failed to store audit event", map[string]interface{}{
+			"operation":  event.Operation,
+			"file_key":   event.FileKey,
+			"request_id": event.RequestID,
+			"error":      err.Error(),
+		})
+	}
+}
+
 // logAuditEvent logs the audit event
 func (m *AuditMiddleware) logAuditEvent(event *AuditEvent) {
 	// Create log message
@@ -224,6 +266,11 @@ func (m *AuditMiddleware) logAuditEvent(event *AuditEvent) {
 		}
 	}
 
+	// The request ID correlates events with the logs of the caller, whatever the fields
+	if event.RequestID != "" {
+		fields["request_id"] = event.RequestID
+	}
+
 	// Add metadata if present
 	if len(event.Metadata) > 0 {
 		fields["metadata"] = event.Metadata
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+// AuditEventHash returns the hash chaining an event to the one saved before it: the SHA-256 of
+// the previous hash and the JSON encoding of the event without its hash, hex encoded
+func AuditEventHash(event *AuditEvent) (string, error) {
+	unhashed := *event
+	unhashed.Hash = ""
+	encoded, err := json.Marshal(&unhashed)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit event: %w", err)
+	}
+	sum := sha256.Sum256(append([]byte(event.PrevHash), encoded...))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// VerifyAuditChain checks that every event carries its own hash and the hash of the event before
+// it, e.g. the events of a time range returned by a query; the first event is trusted to follow
+// events not passed. A broken chain returns an AUDIT_CHAIN_BROKEN error naming the first
+// event that was changed, removed before, or inserted
+func VerifyAuditChain(events []AuditEvent) error {
+	for i := range events {
+		event := &events[i]
+		hash, err := AuditEventHash(event)
+		if err != nil {
+			return err
+		}
+		if event.Hash == "" || hash != event.Hash {
+			return &errors.StorageError{
+				Code:    "AUDIT_CHAIN_BROKEN",
+				Message: fmt.Sprintf("Audit event %d (%s at %s) does not match its hash", i, event.Operation, event.Timestamp.Format(time.RFC3339Nano)),
+			}
+		}
+		if i > 0 && event.PrevHash != events[i-1].Hash {
+			return &errors.StorageError{
+				Code:    "AUDIT_CHAIN_BROKEN",
+				Message: fmt.Sprintf("Audit event %d (%s at %s) does not follow the event before it", i, event.Operation, event.Timestamp.Format(time.RFC3339Nano)),
+			}
+		}
+	}
+	return nil
+}
+
+// AuditChainHead represents the last event of a hash chain
+type AuditChainHead struct {
+	Hash      string    `json:"hash"`
+	Timestamp time.Time `json:"timestamp"` // Time of the event
+}
+
+// ChainedAuditStore hash-chains the events saved to a store, see AuditEventHash, so changes to
+// stored events are detected by VerifyAuditChain. The chain continues from the last stored event;
+// a store must have a single ChainedAuditStore writing to it, several would fork the chain
+type ChainedAuditStore struct {
+	store  AuditStore
+	head   AuditChainHead
+	loaded bool
+	mutex  sync.Mutex
+}
+
+// NewChainedAuditStore creates a store chaining the events saved to store
+func NewChainedAuditStore(store AuditStore) *ChainedAuditStore {
+	return &ChainedAuditStore{store: store}
+}
+
+// load reads the head of the chain from the store once
+func (s *ChainedAuditStore) load(ctx context.Context) error {
+	if s.loaded {
+		return nil
+	}
+	events, err := s.store.Query(ctx, AuditQuery{})
+	if err != nil {
+		return fmt.Errorf("failed to read audit chain: %w", err)
+	}
+	if len(events) > 0 {
+		last := events[len(events)-1]
+		s.head = AuditChainHead{Hash: last.Hash, Timestamp: last.Timestamp}
+	}
+	s.loaded = true
+	return nil
+}
+
+// Save links the event to the head of the chain and saves it
+func (s *ChainedAuditStore) Save(ctx context.Context, event *AuditEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(ctx); err != nil {
+		return err
+	}
+	event.PrevHash = s.head.Hash
+	hash, err := AuditEventHash(event)
+	if err != nil {
+		return err
+	}
+	event.Hash = hash
+	if err := s.store.Save(ctx, event); err != nil {
+		return err
+	}
+	s.head = AuditChainHead{Hash: hash, Timestamp: event.Timestamp}
+	return nil
+}
+
+// Query returns the events of the store
+func (s *ChainedAuditStore) Query(ctx context.Context, query AuditQuery) ([]AuditEvent, error) {
+	return s.store.Query(ctx, query)
+}
+
+// Prune removes old events from the store; the oldest kept event is trusted from then on
+func (s *ChainedAuditStore) Prune(ctx context.Context, before time.Time) (int, error) {
+	return s.store.Prune(ctx, before)
+}
+
+// Head returns the last event of the chain, with an empty hash while the store has none
+func (s *ChainedAuditStore) Head(ctx context.Context) (AuditChainHead, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := s.load(ctx); err != nil {
+		return AuditChainHead{}, err
+	}
+	return s.head, nil
+}
@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AuditStore keeps audit events so they can be queried later, e.g. in a database
+// Unlike the audit logs, events of deleted files are kept until the retention removes them
+type AuditStore interface {
+	Save(ctx context.Context, event *AuditEvent) error
+	// Query returns the events matching the query, oldest first
+	Query(ctx context.Context, query AuditQuery) ([]AuditEvent, error)
+	// Prune removes the events older than before and returns how many were removed
+	Prune(ctx context.Context, before time.Time) (int, error)
+}
+
+// AuditQuery represents the filters of an audit query, empty fields match all events
+type AuditQuery struct {
+	UserID    string    `json:"user_id,omitempty"`
+	FileKey   string    `json:"file_key,omitempty"`
+	KeyPrefix string    `json:"key_prefix,omitempty"` // Files under a key prefix, e.g. "user/123/"
+	Operation string    `json:"operation,omitempty"`
+	From      time.Time `json:"from,omitempty"` // Events at or after From
+	To        time.Time `json:"to,omitempty"`   // Events before To
+	Limit     int       `json:"limit,omitempty"`
+}
+
+// Matches reports whether an event matches the query
+func (q AuditQuery) Matches(event *AuditEvent) bool {
+	if q.UserID != "" && event.UserID != q.UserID {
+		return false
+	}
+	if q.FileKey != "" && event.FileKey != q.FileKey {
+		return false
+	}
+	if q.KeyPrefix != "" && !strings.HasPrefix(event.FileKey, q.KeyPrefix) {
+		return false
+	}
+	if q.Operation != "" && event.Operation != q.Operation {
+		return false
+	}
+	if !q.From.IsZero() && event.Timestamp.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !event.Timestamp.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// defaultMaxAuditEvents is the number of events kept by default
+const defaultMaxAuditEvents = 100000
+
+// MemoryAuditStore is an in-process audit store, events are lost on restart
+type MemoryAuditStore struct {
+	events    []AuditEvent
+	maxEvents int
+	mutex     sync.RWMutex
+}
+
+// NewMemoryAuditStore creates a new in-memory audit store keeping the latest maxEvents events,
+// 100000 when maxEvents is not positive
+func NewMemoryAuditStore(maxEvents int) *MemoryAuditStore {
+	if maxEvents <= 0 {
+		maxEvents = defaultMaxAuditEvents
+	}
+	return &MemoryAuditStore{maxEvents: maxEvents}
+}
+
+// Save appends an event, dropping the oldest event when the store is full
+func (s *MemoryAuditStore) Save(ctx context.Context, event *AuditEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.events = append(s.events, *event)
+	if len(s.events) > s.maxEvents {
+		s.events = append(s.events[:0:0], s.events[len(s.events)-s.maxEvents:]...)
+	}
+	return nil
+}
+
+// Query returns copies of the matching events, oldest first
+func (s *MemoryAuditStore) Query(ctx context.Context, query AuditQuery) ([]AuditEvent, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	events := []AuditEvent{}
+	for i := range s.events {
+		if query.Limit > 0 && len(events) >= query.Limit {
+			break
+		}
+		if query.Matches(&s.events[i]) {
+			events = append(events, s.events[i])
+		}
+	}
+	return events, nil
+}
+
+// Prune removes the events older than before
+func (s *MemoryAuditStore) Prune(ctx context.Context, before time.Time) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	kept := s.events[:0:0]
+	for _, event := range s.events {
+		if !event.Timestamp.Before(before) {
+			kept = append(kept, event)
+		}
+	}
+	pruned := len(s.events) - len(kept)
+	s.events = kept
+	return pruned, nil
+}
+
+// fileAuditLayout is the date layout of the names of audit files
+const fileAuditLayout = "2006-01-02"
+
+// FileAuditStore keeps audit events as JSON lines in a directory, rotating to a new file every
+// day (UTC), e.g. audit-2024-03-01.jsonl; Prune removes the files of whole days only
+type FileAuditStore struct {
+	dir   string
+	mutex sync.Mutex
+}
+
+// NewFileAuditStore creates an audit store writing to dir, which is created when missing
+func NewFileAuditStore(dir string) (*FileAuditStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+	return &FileAuditStore{dir: dir}, nil
+}
+
+// path returns the file of the events of a day
+func (s *FileAuditStore) path(day time.Time) string {
+	return filepath.Join(s.dir, "audit-"+day.UTC().Format(fileAuditLayout)+".jsonl")
+}
+
+// Save appends an event to the file of its day
+func (s *FileAuditStore) Save(ctx context.Context, event *AuditEvent) error {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit event: %w", err)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	file, err := os.OpenFile(s.path(event.Timestamp), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	if _, err := file.Write(append(encoded, '\n')); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write audit event: %w", err)
+	}
+	return file.Close()
+}
+
+// days returns the days with an audit file, oldest first
+func (s *FileAuditStore) days() ([]time.Time, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit files: %w", err)
+	}
+	var days []time.Time
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, "audit-") || !strings.HasSuffix(name, ".jsonl") {
+			continue
+		}
+		day, err := time.Parse(fileAuditLayout, strings.TrimSuffix(strings.TrimPrefix(name, "audit-"), ".jsonl"))
+		if err != nil {
+			continue
+		}
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Before(days[j])
+	})
+	return days, nil
+}
+
+// Query reads the files of the days of the query's time range, oldest first
+func (s *FileAuditStore) Query(ctx context.Context, query AuditQuery) ([]AuditEvent, error) {
+	days, err := s.days()
+	if err != nil {
+		return nil, err
+	}
+
+	events := []AuditEvent{}
+	for _, day := range days {
+		if !query.From.IsZero() && !day.Add(24*time.Hour).After(query.From) {
+			continue
+		}
+		if !query.To.IsZero() && !day.Before(query.To) {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		events, err = s.readDay(day, query, events)
+		if err != nil {
+			return nil, err
+		}
+		if query.Limit > 0 && len(events) >= query.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+// readDay appends the matching events of the file of a day
+func (s *FileAuditStore) readDay(day time.Time, query AuditQuery, events []AuditEvent) ([]AuditEvent, error) {
+	file, err := os.Open(s.path(day))
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Pruned meanwhile
+			return events, nil
+		}
+		return nil, fmt.Errorf("failed to open audit file: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for scanner.Scan() {
+		if query.Limit > 0 && len(events) >= query.Limit {
+			return events, nil
+		}
+		var event AuditEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// A line cut short by a crash
+			continue
+		}
+		if query.Matches(&event) {
+			events = append(events, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit file: %w", err)
+	}
+	return events, nil
+}
+
+// Prune removes the files of the days ending before before, counting their events
+func (s *FileAuditStore) Prune(ctx context.Context, before time.Time) (int, error) {
+	days, err := s.days()
+	if err != nil {
+		return 0, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pruned := 0
+	for _, day := range days {
+		if day.Add(24 * time.Hour).After(before) {
+			break
+		}
+		events, err := s.readDay(day, AuditQuery{}, nil)
+		if err != nil {
+			return pruned, err
+		}
+		if err := os.Remove(s.path(day)); err != nil && !os.IsNotExist(err) {
+			return pruned, fmt.Errorf("failed to remove audit file: %w", err)
+		}
+		pruned += len(events)
+	}
+	return pruned, nil
+}
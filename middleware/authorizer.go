@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+// Actions checked by an Authorizer, matching StorageRequest operations
+const (
+	ActionUpload   = "upload"
+	ActionDownload = "download"
+	ActionDelete   = "delete"
+	ActionPreview  = "preview"
+	ActionStream   = "stream"
+	ActionReplace  = "replace" // Upload of a new version of a stored file or of data written into it
+)
+
+// User is the identity an operation is authorized for
+type User struct {
+	ID    string   `json:"id"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// HasRole reports whether the user has any of the given roles
+func (u *User) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		for _, userRole := range u.Roles {
+			if userRole == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authorizer decides whether a user may perform an action on a file
+// Integrators plug in JWT claims, OPA or their own RBAC by implementing it;
+// the request being authorized, if any, is available through RequestFromContext
+type Authorizer interface {
+	CheckAccess(ctx context.Context, user *User, fileKey, action string) error
+}
+
+// AuthorizerFunc adapts a function to the Authorizer interface
+type AuthorizerFunc func(ctx context.Context, user *User, fileKey, action string) error
+
+// CheckAccess calls f
+func (f AuthorizerFunc) CheckAccess(ctx context.Context, user *User, fileKey, action string) error {
+	return f(ctx, user, fileKey, action)
+}
+
+// RoleProvider is optionally implemented by authorizers that can look up roles
+// It is used when the request context carries no roles of the user, see WithUser
+type RoleProvider interface {
+	Roles(ctx context.Context, userID string) []string
+}
+
+// requestKey is the context key of the request being authorized
+type requestKey struct{}
+
+// RequestFromContext returns the request being authorized, or nil
+func RequestFromContext(ctx context.Context) *StorageRequest {
+	req, _ := ctx.Value(requestKey{}).(*StorageRequest)
+	return req
+}
+
+// DevAuthorizer is the default authorizer, meant for development only
+// It derives roles from user ID prefixes ("admin-", "premium-", "vip-", "mod-"), lets uploaders,
+// admins and moderators read private files and, with RequireOwner, only uploaders and admins delete them
+type DevAuthorizer struct {
+	requireOwner bool
+}
+
+// NewDevAuthorizer creates the development authorizer for a security configuration
+func NewDevAuthorizer(config SecurityConfig) *DevAuthorizer {
+	return &DevAuthorizer{
+		requireOwner: config.RequireOwner,
+	}
+}
+
+// CheckAccess checks file access based on the request metadata and the user's roles
+func (a *DevAuthorizer) CheckAccess(ctx context.Context, user *User, fileKey, action string) error {
+	var metadata map[string]interface{}
+	if req := RequestFromContext(ctx); req != nil {
+		metadata = req.Metadata
+	}
+
+	switch action {
+	case ActionDownload, ActionPreview, ActionStream:
+		// Public files are accessible to everyone
+		if isPublic, ok := metadata["is_public"].(bool); ok && isPublic {
+			return nil
+		}
+		if a.isUploader(user, metadata) || user.HasRole("admin", "moderator") {
+			return nil
+		}
+		return &errors.StorageError{Code: errors.ErrAccessDenied.Code, Message: "Access denied: insufficient permissions"}
+
+	case ActionDelete, ActionReplace:
+		if !a.requireOwner || a.isUploader(user, metadata) || user.HasRole("admin") {
+			return nil
+		}
+		return &errors.StorageError{Code: errors.ErrAccessDenied.Code, Message: "Access denied: user does not own this file"}
+	}
+
+	return nil
+}
+
+// Roles returns roles based on user ID patterns
+// In a real implementation, this would query a user service or database
+func (a *DevAuthorizer) Roles(ctx context.Context, userID string) []string {
+	roles := []string{"user"} // Default role
+
+	if strings.HasPrefix(userID, "admin-") {
+		roles = append(roles, "admin")
+	} else if strings.HasPrefix(userID, "premium-") {
+		roles = append(roles, "premium")
+	} else if strings.HasPrefix(userID, "vip-") {
+		roles = append(roles, "vip")
+	} else if strings.HasPrefix(userID, "mod-") {
+		roles = append(roles, "moderator")
+	}
+
+	return roles
+}
+
+// isUploader reports whether the user uploaded the file
+// The security middleware sets uploaded_by from the stored object metadata before authorizing
+func (a *DevAuthorizer) isUploader(user *User, metadata map[string]interface{}) bool {
+	uploadedBy, ok := metadata["uploaded_by"].(string)
+	return ok && user.ID != "" && uploadedBy == user.ID
+}
@@ -0,0 +1,164 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the largest read of a throttled reader, so waits stay short and transfers smooth
+const throttleChunk = 32 * 1024
+
+// BandwidthLimiter limits the throughput of the transfers sharing it to a number of bytes per second
+// It is a token bucket holding up to one second of transfer; a nil limiter does not limit
+type BandwidthLimiter struct {
+	rate    float64 // Bytes per second
+	tokens  float64
+	updated time.Time
+	mutex   sync.Mutex
+}
+
+// NewBandwidthLimiter creates a limiter for bytesPerSecond, or returns nil when it is not positive
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &BandwidthLimiter{
+		rate:    float64(bytesPerSecond),
+		tokens:  float64(bytesPerSecond),
+		updated: time.Now(),
+	}
+}
+
+// SetRate changes the limit, transfers in progress continue at the new rate
+func (l *BandwidthLimiter) SetRate(bytesPerSecond int64) {
+	if l == nil || bytesPerSecond <= 0 {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.refill(time.Now())
+	l.rate = float64(bytesPerSecond)
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+}
+
+// Rate returns the limit in bytes per second, 0 for a nil limiter
+func (l *BandwidthLimiter) Rate() int64 {
+	if l == nil {
+		return 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return int64(l.rate)
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done
+// Bytes are reserved up front, so concurrent transfers queue up behind each other
+func (l *BandwidthLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.mutex.Lock()
+	now := time.Now()
+	l.refill(now)
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mutex.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// release returns bytes reserved by WaitN but not transferred
+func (l *BandwidthLimiter) release(n int) {
+	if n <= 0 {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.tokens += float64(n)
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+}
+
+// refill adds the tokens earned since the last update, must be called with the mutex held
+func (l *BandwidthLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.updated).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.updated = now
+}
+
+// ThrottleReader returns r limited by all given limiters, nil limiters are skipped
+// The result implements io.Closer when r does, so throttled downloads can still be closed
+func ThrottleReader(ctx context.Context, r io.Reader, limiters ...*BandwidthLimiter) io.Reader {
+	active := make([]*BandwidthLimiter, 0, len(limiters))
+	for _, limiter := range limiters {
+		if limiter != nil {
+			active = append(active, limiter)
+		}
+	}
+	if len(active) == 0 {
+		return r
+	}
+
+	throttled := &throttledReader{ctx: ctx, reader: r, limiters: active}
+	if closer, ok := r.(io.Closer); ok {
+		return throttledReadCloser{throttledReader: throttled, Closer: closer}
+	}
+	return throttled
+}
+
+// throttledReader waits for its limiters before every read
+type throttledReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	limiters []*BandwidthLimiter
+}
+
+// Read reads at most throttleChunk bytes once the limiters allow them
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	for i, limiter := range t.limiters {
+		if err := limiter.WaitN(t.ctx, len(p)); err != nil {
+			for _, reserved := range t.limiters[:i+1] {
+				reserved.release(len(p))
+			}
+			return 0, err
+		}
+	}
+
+	n, err := t.reader.Read(p)
+	for _, limiter := range t.limiters {
+		limiter.release(len(p) - n)
+	}
+	return n, err
+}
+
+// throttledReadCloser is a throttled reader of a closable source
+type throttledReadCloser struct {
+	*throttledReader
+	io.Closer
+}
@@ -0,0 +1,389 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// BufferConfig is the buffering policy of upload data that middlewares read more than once or
+// transform (validation, placeholders, compression, encryption)
+// Small files are kept in pooled memory buffers, larger ones are spilled to temp files
+type BufferConfig struct {
+	MemoryThreshold int64  `json:"memory_threshold,omitempty"` // Larger files are spilled to temp files, default 4MB
+	MaxMemory       int64  `json:"max_memory,omitempty"`       // Memory held by all buffers at most, further data is spilled; default 64MB
+	TempDir         string `json:"temp_dir,omitempty"`         // Directory of spill files, os.TempDir() when empty
+}
+
+// DefaultBufferConfig returns default buffering configuration
+func DefaultBufferConfig() BufferConfig {
+	return BufferConfig{
+		MemoryThreshold: 4 * 1024 * 1024,  // 4MB
+		MaxMemory:       64 * 1024 * 1024, // 64MB
+	}
+}
+
+// BufferPool hands out upload buffers and accounts for the memory they hold
+type BufferPool struct {
+	config BufferConfig
+	pool   sync.Pool
+
+	memory       atomic.Int64 // Bytes held by memory buffers
+	peakMemory   atomic.Int64
+	active       atomic.Int64 // Buffers not closed yet
+	created      atomic.Int64
+	spilled      atomic.Int64 // Buffers moved to temp files
+	spilledBytes atomic.Int64
+}
+
+// NewBufferPool creates a buffer pool, unset values use DefaultBufferConfig
+func NewBufferPool(config BufferConfig) *BufferPool {
+	defaults := DefaultBufferConfig()
+	if config.MemoryThreshold == 0 {
+		config.MemoryThreshold = defaults.MemoryThreshold
+	}
+	if config.MaxMemory == 0 {
+		config.MaxMemory = defaults.MaxMemory
+	}
+	return &BufferPool{
+		config: config,
+		pool: sync.Pool{
+			New: func() interface{} { return new(bytes.Buffer) },
+		},
+	}
+}
+
+// defaultBufferPool is used by requests without a pool
+var defaultBufferPool = sync.OnceValue(func() *BufferPool {
+	return NewBufferPool(DefaultBufferConfig())
+})
+
+// NewBuffer returns an empty buffer to write to, sizeHint is the expected size or 0 when unknown
+// Data expected to exceed MemoryThreshold is written to a temp file right away
+func (p *BufferPool) NewBuffer(sizeHint int64) (*Buffer, error) {
+	buffer := &Buffer{pool: p}
+	if sizeHint > p.config.MemoryThreshold {
+		if err := buffer.createFile(); err != nil {
+			return nil, err
+		}
+		p.spilled.Add(1)
+	} else {
+		buffer.memory = p.pool.Get().(*bytes.Buffer)
+	}
+	p.created.Add(1)
+	p.active.Add(1)
+	return buffer, nil
+}
+
+// Buffer reads all of r into a new buffer positioned at its start
+func (p *BufferPool) Buffer(r io.Reader, sizeHint int64) (*Buffer, error) {
+	buffer, err := p.NewBuffer(sizeHint)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(buffer, r); err != nil {
+		buffer.Close()
+		return nil, fmt.Errorf("failed to buffer data: %w", err)
+	}
+	if err := buffer.Rewind(); err != nil {
+		buffer.Close()
+		return nil, err
+	}
+	return buffer, nil
+}
+
+// reserve accounts for n more bytes of memory, false when MaxMemory would be exceeded
+func (p *BufferPool) reserve(n int64) bool {
+	for {
+		current := p.memory.Load()
+		if current+n > p.config.MaxMemory {
+			return false
+		}
+		if p.memory.CompareAndSwap(current, current+n) {
+			break
+		}
+	}
+	for {
+		peak := p.peakMemory.Load()
+		if current := p.memory.Load(); current <= peak || p.peakMemory.CompareAndSwap(peak, current) {
+			return true
+		}
+	}
+}
+
+// release returns a memory buffer to the pool
+func (p *BufferPool) release(memory *bytes.Buffer) {
+	p.memory.Add(-int64(memory.Len()))
+	// Keep buffers of large files from pinning memory
+	if int64(memory.Cap()) <= 2*p.config.MemoryThreshold {
+		memory.Reset()
+		p.pool.Put(memory)
+	}
+}
+
+// GetStats returns buffer usage statistics
+func (p *BufferPool) GetStats() map[string]interface{} {
+	return map[string]interface{}{
+		"memory_bytes":      p.memory.Load(),
+		"peak_memory_bytes": p.peakMemory.Load(),
+		"max_memory_bytes":  p.config.MaxMemory,
+		"memory_threshold":  p.config.MemoryThreshold,
+		"active_buffers":    p.active.Load(),
+		"created_buffers":   p.created.Load(),
+		"spilled_buffers":   p.spilled.Load(),
+		"spilled_bytes":     p.spilledBytes.Load(),
+	}
+}
+
+// Buffer holds upload data in memory or in a temp file, see BufferConfig
+// It is written first and then read, rewound as often as needed; Close releases it
+type Buffer struct {
+	pool   *BufferPool
+	memory *bytes.Buffer
+	file   *os.File
+	reader *bytes.Reader // Reads memory once writing is done
+	size   int64
+
+	// Seekable upload data used in place, it is not copied nor closed
+	source      io.ReadSeeker
+	sourceStart int64
+
+	reading bool
+	closed  bool
+}
+
+// sourceBuffer wraps seekable data so it can be rewound without copying it
+func sourceBuffer(source io.ReadSeeker) (*Buffer, error) {
+	start, err := source.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	end, err := source.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := source.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+	return &Buffer{source: source, sourceStart: start, size: end - start, reading: true}, nil
+}
+
+// Write appends data, spilling to a temp file once MemoryThreshold or MaxMemory is exceeded
+func (b *Buffer) Write(p []byte) (int, error) {
+	if b.closed {
+		return 0, fmt.Errorf("buffer is closed")
+	}
+	if b.reading {
+		return 0, fmt.Errorf("buffer is read only once reading started")
+	}
+
+	if b.memory != nil {
+		n := int64(len(p))
+		if int64(b.memory.Len())+n <= b.pool.config.MemoryThreshold && b.pool.reserve(n) {
+			b.memory.Write(p)
+			b.size += n
+			return len(p), nil
+		}
+		if err := b.Spill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := b.file.Write(p)
+	b.size += int64(n)
+	b.pool.spilledBytes.Add(int64(n))
+	return n, err
+}
+
+// Read reads the buffered data, writing is no longer possible afterwards
+func (b *Buffer) Read(p []byte) (int, error) {
+	if err := b.startReading(); err != nil {
+		return 0, err
+	}
+	switch {
+	case b.source != nil:
+		return b.source.Read(p)
+	case b.file != nil:
+		return b.file.Read(p)
+	default:
+		return b.reader.Read(p)
+	}
+}
+
+// Seek sets the read position, relative to the start of the buffered data
+func (b *Buffer) Seek(offset int64, whence int) (int64, error) {
+	if err := b.startReading(); err != nil {
+		return 0, err
+	}
+	switch {
+	case b.source != nil:
+		if whence == io.SeekStart {
+			offset += b.sourceStart
+		}
+		position, err := b.source.Seek(offset, whence)
+		return position - b.sourceStart, err
+	case b.file != nil:
+		return b.file.Seek(offset, whence)
+	default:
+		return b.reader.Seek(offset, whence)
+	}
+}
+
+// Rewind moves the read position back to the start of the data
+func (b *Buffer) Rewind() error {
+	if _, err := b.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind buffer: %w", err)
+	}
+	return nil
+}
+
+// Size returns the number of buffered bytes
+func (b *Buffer) Size() int64 {
+	return b.size
+}
+
+// InMemory reports whether the data is held in memory
+func (b *Buffer) InMemory() bool {
+	return b.memory != nil
+}
+
+// Spill moves data held in memory to a temp file, keeping the read position
+func (b *Buffer) Spill() error {
+	if b.memory == nil {
+		return nil
+	}
+
+	var position int64
+	if b.reading {
+		position, _ = b.reader.Seek(0, io.SeekCurrent)
+	}
+	if err := b.createFile(); err != nil {
+		return err
+	}
+	if _, err := b.file.Write(b.memory.Bytes()); err != nil {
+		return fmt.Errorf("failed to spill buffer: %w", err)
+	}
+	b.pool.spilled.Add(1)
+	b.pool.spilledBytes.Add(int64(b.memory.Len()))
+	b.pool.release(b.memory)
+	b.memory, b.reader = nil, nil
+
+	if b.reading {
+		if _, err := b.file.Seek(position, io.SeekStart); err != nil {
+			return fmt.Errorf("failed to spill buffer: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close releases the memory or removes the temp file of the buffer
+func (b *Buffer) Close() error {
+	if b.closed || b.source != nil {
+		b.closed = true
+		return nil
+	}
+	b.closed = true
+	b.pool.active.Add(-1)
+
+	if b.memory != nil {
+		b.pool.release(b.memory)
+		b.memory, b.reader = nil, nil
+	}
+	if b.file != nil {
+		b.file.Close()
+		if err := os.Remove(b.file.Name()); err != nil {
+			return fmt.Errorf("failed to remove buffer file: %w", err)
+		}
+	}
+	return nil
+}
+
+// createFile opens the temp file of a spilled buffer
+func (b *Buffer) createFile() error {
+	file, err := os.CreateTemp(b.pool.config.TempDir, "storage-buffer-*")
+	if err != nil {
+		return fmt.Errorf("failed to create buffer file: %w", err)
+	}
+	b.file = file
+	return nil
+}
+
+// startReading ends the write phase
+func (b *Buffer) startReading() error {
+	if b.closed {
+		return fmt.Errorf("buffer is closed")
+	}
+	if b.reading {
+		return nil
+	}
+	b.reading = true
+	if b.memory != nil {
+		b.reader = bytes.NewReader(b.memory.Bytes())
+		return nil
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind buffer file: %w", err)
+	}
+	return nil
+}
+
+// BufferData makes the upload data rewindable and returns it positioned at its start
+// Seekable data is used in place, other data is buffered once according to the BufferConfig
+// of the request's pool; the buffer replaces FileData and is released by Release
+func (req *StorageRequest) BufferData() (*Buffer, error) {
+	if buffer, ok := req.FileData.(*Buffer); ok {
+		return buffer, buffer.Rewind()
+	}
+	if req.FileData == nil {
+		return nil, fmt.Errorf("no file data provided")
+	}
+
+	var buffer *Buffer
+	var err error
+	if seeker, ok := req.FileData.(io.ReadSeeker); ok {
+		buffer, err = sourceBuffer(seeker)
+	} else {
+		buffer, err = req.bufferPool().Buffer(req.FileData, req.FileSize)
+		if err == nil {
+			req.buffers = append(req.buffers, buffer)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to buffer file data: %w", err)
+	}
+
+	req.FileData = buffer
+	if req.FileSize <= 0 {
+		req.FileSize = buffer.Size()
+	}
+	return buffer, nil
+}
+
+// NewBuffer returns an empty buffer released with the request, e.g. for transformed data
+func (req *StorageRequest) NewBuffer(sizeHint int64) (*Buffer, error) {
+	buffer, err := req.bufferPool().NewBuffer(sizeHint)
+	if err != nil {
+		return nil, err
+	}
+	req.buffers = append(req.buffers, buffer)
+	return buffer, nil
+}
+
+// Release closes the buffers created for the request, once its data is stored
+func (req *StorageRequest) Release() {
+	for _, buffer := range req.buffers {
+		buffer.Close()
+	}
+	req.buffers = nil
+}
+
+// bufferPool returns the pool of the request
+func (req *StorageRequest) bufferPool() *BufferPool {
+	if req.Buffers != nil {
+		return req.Buffers
+	}
+	return defaultBufferPool()
+}
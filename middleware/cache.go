@@ -2,52 +2,161 @@ package middleware
 
 import (
 	"context"
-	"crypto/md5"
-	"fmt"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/darmawan01/storage/logger"
+	"github.com/minio/minio-go/v7"
+)
+
+// Kinds of cached artifacts
+const (
+	cacheKindStat    = "stat"    // minio.ObjectInfo of a file
+	cacheKindURL     = "url"     // Presigned, signed CDN and thumbnail URLs
+	cacheKindPreview = "preview" // Contents of small derived files, e.g. thumbnails
 )
 
-// CacheMiddleware handles caching of presigned URLs and other data
+// CacheMiddleware gives a category access to the artifact cache
+// Only artifacts that can be reused safely are cached: stat results, URLs and small previews
+// held in memory. Response bodies are never cached, readers can only be consumed once
 type CacheMiddleware struct {
-	config CacheConfig
-	cache  map[string]*CacheEntry
-	mutex  sync.RWMutex
+	config    CacheConfig
+	cache     *Cache
+	ownsCache bool // Whether the cache was created by this middleware
 }
 
 // CacheConfig represents cache middleware configuration
 type CacheConfig struct {
 	Enabled           bool          `json:"enabled"`            // Enable caching
-	DefaultTTL        time.Duration `json:"default_ttl"`        // Default TTL for cache entries
+	DefaultTTL        time.Duration `json:"default_ttl"`        // TTL of previews
 	MaxSize           int           `json:"max_size"`           // Maximum number of cache entries
 	CleanupInterval   time.Duration `json:"cleanup_interval"`   // How often to cleanup expired entries
-	PresignedURLTTL   time.Duration `json:"presigned_url_ttl"`  // TTL for presigned URLs
-	MetadataTTL       time.Duration `json:"metadata_ttl"`       // TTL for metadata
+	PresignedURLTTL   time.Duration `json:"presigned_url_ttl"`  // TTL of URLs, capped to half their remaining validity
+	MetadataTTL       time.Duration `json:"metadata_ttl"`       // TTL of stat results
 	EnableCompression bool          `json:"enable_compression"` // Enable compression for cache values
+
+	MaxPreviewSize  int64 `json:"max_preview_size"`  // Larger files are streamed and never cached, default 256KB
+	MaxPreviewBytes int64 `json:"max_preview_bytes"` // Memory used by cached previews at most, default 64MB
+
+	// Cache shared with other middlewares, e.g. the cache middlewares of all categories of a handler
+	// A cache is created when nil and stopped with the middleware
+	Cache *Cache `json:"-"`
+
+	// Invalidator shares invalidations between instances, e.g. NewRedisCacheInvalidator
+	// Without one, files changed by other instances are served stale until their entries expire
+	Invalidator CacheInvalidator `json:"-"`
+
+	Logger logger.Logger `json:"-"` // Defaults to logger.Default()
 }
 
 // CacheEntry represents a cache entry
 type CacheEntry struct {
 	Value        interface{} `json:"value"`
+	FileKey      string      `json:"file_key"` // File the artifact belongs to, entries of a file are invalidated together
+	Bytes        int64       `json:"bytes"`    // Size of preview data
 	ExpiresAt    time.Time   `json:"expires_at"`
 	CreatedAt    time.Time   `json:"created_at"`
 	AccessCount  int64       `json:"access_count"`
 	LastAccessed time.Time   `json:"last_accessed"`
 }
 
-// NewCacheMiddleware creates a new cache middleware
-func NewCacheMiddleware(config CacheConfig) *CacheMiddleware {
-	middleware := &CacheMiddleware{
-		config: config,
-		cache:  make(map[string]*CacheEntry),
+// CacheInvalidator shares cache invalidations between instances, so a file replaced or deleted
+// on one instance is not served from the caches of the others
+type CacheInvalidator interface {
+	// Publish announces that the cached artifacts of a file are stale
+	Publish(ctx context.Context, fileKey string) error
+	// Subscribe calls invalidate for every announced file until the returned func is called
+	Subscribe(ctx context.Context, invalidate func(fileKey string)) (func(), error)
+}
+
+// cacheSubscribeTimeout bounds waiting for the invalidation subscription when a cache is created
+const cacheSubscribeTimeout = 10 * time.Second
+
+// CachedPreview is a small file held in memory, served through a new reader on every request
+type CachedPreview struct {
+	Data         []byte
+	ContentType  string
+	LastModified time.Time
+}
+
+// Cache keeps artifacts per file key, see CacheMiddleware
+type Cache struct {
+	config       CacheConfig
+	entries      map[string]*CacheEntry
+	files        map[string]map[string]bool // file key -> cache keys of its artifacts
+	previewBytes int64
+	mutex        sync.Mutex
+
+	stop        chan struct{}
+	stopOnce    sync.Once
+	unsubscribe func()
+}
+
+// NewCache creates an artifact cache, unset sizes and TTLs use DefaultCacheConfig
+func NewCache(config CacheConfig) *Cache {
+	config.Logger = logger.OrDefault(config.Logger)
+	defaults := DefaultCacheConfig()
+	if config.MaxSize <= 0 {
+		config.MaxSize = defaults.MaxSize
+	}
+	if config.DefaultTTL <= 0 {
+		config.DefaultTTL = defaults.DefaultTTL
+	}
+	if config.PresignedURLTTL <= 0 {
+		config.PresignedURLTTL = defaults.PresignedURLTTL
+	}
+	if config.MetadataTTL <= 0 {
+		config.MetadataTTL = defaults.MetadataTTL
+	}
+	if config.MaxPreviewSize == 0 {
+		config.MaxPreviewSize = defaults.MaxPreviewSize
+	}
+	if config.MaxPreviewBytes == 0 {
+		config.MaxPreviewBytes = defaults.MaxPreviewBytes
+	}
+
+	cache := &Cache{
+		config:  config,
+		entries: make(map[string]*CacheEntry),
+		files:   make(map[string]map[string]bool),
+		stop:    make(chan struct{}),
 	}
 
 	// Start cleanup routine if enabled
 	if config.CleanupInterval > 0 {
-		go middleware.startCleanupRoutine()
+		go cache.startCleanupRoutine()
+	}
+
+	// Drop entries of files changed by other instances
+	if config.Invalidator != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), cacheSubscribeTimeout)
+		unsubscribe, err := config.Invalidator.Subscribe(ctx, cache.InvalidateFile)
+		cancel()
+		if err != nil {
+			config.Logger.Warn("failed to subscribe to cache invalidations", map[string]interface{}{
+				"error": err,
+			})
+		}
+		cache.unsubscribe = unsubscribe
 	}
 
-	return middleware
+	return cache
+}
+
+// NewCacheMiddleware creates a new cache middleware
+func NewCacheMiddleware(config CacheConfig) *CacheMiddleware {
+	config.Logger = logger.OrDefault(config.Logger)
+
+	cache := config.Cache
+	if cache == nil {
+		cache = NewCache(config)
+	}
+	return &CacheMiddleware{
+		config:    config,
+		cache:     cache,
+		ownsCache: config.Cache == nil,
+	}
 }
 
 // Name returns the middleware name
@@ -55,118 +164,200 @@ func (m *CacheMiddleware) Name() string {
 	return "cache"
 }
 
-// Process processes the request through cache middleware
+// Process passes requests through untouched, see CacheMiddleware
+// Uploads are stored after the chain ran, so the handler invalidates files once they changed
 func (m *CacheMiddleware) Process(ctx context.Context, req *StorageRequest, next MiddlewareFunc) (*StorageResponse, error) {
-	if !m.config.Enabled {
-		return next(ctx, req)
+	return next(ctx, req)
+}
+
+// Enabled reports whether the middleware caches artifacts
+func (m *CacheMiddleware) Enabled() bool {
+	return m.config.Enabled
+}
+
+// Cache returns the artifact cache of the middleware
+func (m *CacheMiddleware) Cache() *Cache {
+	return m.cache
+}
+
+// Stop stops the cleanup routine of a cache created by the middleware
+func (m *CacheMiddleware) Stop() {
+	if m.ownsCache {
+		m.cache.Stop()
+	}
+}
+
+// GetStats returns cache statistics
+func (m *CacheMiddleware) GetStats() map[string]interface{} {
+	stats := m.cache.GetStats()
+	stats["enabled"] = m.config.Enabled
+	return stats
+}
+
+// Status returns the fill level of the cache
+func (m *CacheMiddleware) Status() CacheStatus {
+	status := m.cache.Status()
+	status.Enabled = m.config.Enabled
+	return status
+}
+
+// Clear clears all cache entries
+func (m *CacheMiddleware) Clear() {
+	m.cache.Clear()
+}
+
+// Invalidate removes the cached artifacts of a file in all instances, see Cache.Invalidate
+func (m *CacheMiddleware) Invalidate(ctx context.Context, fileKey string) error {
+	return m.cache.Invalidate(ctx, fileKey)
+}
+
+// Stat returns the cached stat result of a file in a bucket
+func (c *Cache) Stat(bucketName, fileKey string) (minio.ObjectInfo, bool) {
+	value, ok := c.get(cacheKindStat, fileKey, bucketName)
+	if !ok {
+		return minio.ObjectInfo{}, false
 	}
+	info := value.(minio.ObjectInfo)
 
-	// Only cache certain operations
-	if req.Operation != "download" && req.Operation != "preview" {
-		return next(ctx, req)
+	// Callers may modify the metadata of their copy
+	userMetadata := make(minio.StringMap, len(info.UserMetadata))
+	for key, value := range info.UserMetadata {
+		userMetadata[key] = value
 	}
+	info.UserMetadata = userMetadata
+	return info, true
+}
 
-	// Generate cache key
-	cacheKey := m.generateCacheKey(req)
+// SetStat caches the stat result of a file in a bucket for MetadataTTL
+func (c *Cache) SetStat(bucketName, fileKey string, info minio.ObjectInfo) {
+	c.set(cacheKindStat, fileKey, bucketName, info, 0, c.config.MetadataTTL)
+}
 
-	// Try to get from cache
-	if cached := m.get(cacheKey); cached != nil {
-		if response, ok := cached.(*StorageResponse); ok {
-			// Update access statistics
-			m.updateAccessStats(cacheKey)
-			return response, nil
-		}
+// URL returns a cached URL of a file and when it expires
+// variant tells URLs of the same file apart, e.g. the action or thumbnail size
+func (c *Cache) URL(fileKey, variant string) (string, time.Time, bool) {
+	value, ok := c.get(cacheKindURL, fileKey, variant)
+	if !ok {
+		return "", time.Time{}, false
+	}
+	cached := value.(cachedURL)
+	return cached.url, cached.expiresAt, true
+}
+
+// SetURL caches a URL of a file valid until expiresAt
+// It is kept for PresignedURLTTL at most and half its remaining validity, so cached URLs
+// are never handed out shortly before they expire
+func (c *Cache) SetURL(fileKey, variant, url string, expiresAt time.Time) {
+	ttl := min(c.config.PresignedURLTTL, time.Until(expiresAt)/2)
+	if ttl <= 0 {
+		return
 	}
+	c.set(cacheKindURL, fileKey, variant, cachedURL{url: url, expiresAt: expiresAt}, 0, ttl)
+}
 
-	// Process with next middleware
-	response, err := next(ctx, req)
-	if err != nil {
-		return response, err
+// Preview returns the cached contents of a small file belonging to fileKey
+func (c *Cache) Preview(fileKey, variant string) (CachedPreview, bool) {
+	value, ok := c.get(cacheKindPreview, fileKey, variant)
+	if !ok {
+		return CachedPreview{}, false
 	}
+	return value.(CachedPreview), true
+}
 
-	// Cache the response if successful
-	if response.Success {
-		ttl := m.getTTLForOperation(req.Operation)
-		m.set(cacheKey, response, ttl)
+// SetPreview caches the contents of a small file belonging to fileKey for DefaultTTL
+// Files larger than MaxPreviewSize are not cached
+func (c *Cache) SetPreview(fileKey, variant string, preview CachedPreview) {
+	if !c.Cacheable(int64(len(preview.Data))) {
+		return
 	}
+	c.set(cacheKindPreview, fileKey, variant, preview, int64(len(preview.Data)), c.config.DefaultTTL)
+}
 
-	return response, err
+// Cacheable reports whether a file of the given size may be cached as a preview
+func (c *Cache) Cacheable(size int64) bool {
+	return size >= 0 && size <= c.config.MaxPreviewSize && size <= c.config.MaxPreviewBytes
 }
 
-// generateCacheKey generates a cache key for the request
-func (m *CacheMiddleware) generateCacheKey(req *StorageRequest) string {
-	// Create a hash of the request parameters
-	keyData := fmt.Sprintf("%s:%s:%s:%s:%s",
-		req.Operation,
-		req.FileKey,
-		req.UserID,
-		req.ContentType,
-		req.Category)
+// cachedURL is a URL with its expiry
+type cachedURL struct {
+	url       string
+	expiresAt time.Time
+}
 
-	hash := md5.Sum([]byte(keyData))
-	return fmt.Sprintf("%x", hash)
+// cacheKey returns the key of an artifact
+func cacheKey(kind, fileKey, variant string) string {
+	return kind + "\x00" + fileKey + "\x00" + variant
 }
 
 // get retrieves a value from cache
-func (m *CacheMiddleware) get(key string) interface{} {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
-
-	entry, exists := m.cache[key]
-	if !exists {
-		return nil
-	}
+func (c *Cache) get(kind, fileKey, variant string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	// Check if expired
-	if time.Now().After(entry.ExpiresAt) {
-		return nil
+	entry, exists := c.entries[cacheKey(kind, fileKey, variant)]
+	if !exists || time.Now().After(entry.ExpiresAt) {
+		return nil, false
 	}
 
 	// Update access statistics
 	entry.AccessCount++
 	entry.LastAccessed = time.Now()
 
-	return entry.Value
+	return entry.Value, true
 }
 
 // set stores a value in cache
-func (m *CacheMiddleware) set(key string, value interface{}, ttl time.Duration) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+func (c *Cache) set(kind, fileKey, variant string, value interface{}, size int64, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	// Check if cache is full
-	if len(m.cache) >= m.config.MaxSize {
-		m.evictLeastRecentlyUsed()
+	key := cacheKey(kind, fileKey, variant)
+	c.remove(key)
+
+	// Make room for the entry
+	for len(c.entries) > 0 && (len(c.entries) >= c.config.MaxSize || c.previewBytes+size > c.config.MaxPreviewBytes) {
+		c.evictLeastRecentlyUsed()
 	}
 
-	entry := &CacheEntry{
+	now := time.Now()
+	c.entries[key] = &CacheEntry{
 		Value:        value,
-		ExpiresAt:    time.Now().Add(ttl),
-		CreatedAt:    time.Now(),
-		AccessCount:  0,
-		LastAccessed: time.Now(),
+		FileKey:      fileKey,
+		Bytes:        size,
+		ExpiresAt:    now.Add(ttl),
+		CreatedAt:    now,
+		LastAccessed: now,
 	}
-
-	m.cache[key] = entry
+	c.previewBytes += size
+	if c.files[fileKey] == nil {
+		c.files[fileKey] = make(map[string]bool)
+	}
+	c.files[fileKey][key] = true
 }
 
-// updateAccessStats updates access statistics for a cache entry
-func (m *CacheMiddleware) updateAccessStats(key string) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// remove deletes an entry and its index, called with the mutex held
+func (c *Cache) remove(key string) {
+	entry, exists := c.entries[key]
+	if !exists {
+		return
+	}
+	delete(c.entries, key)
+	c.previewBytes -= entry.Bytes
 
-	if entry, exists := m.cache[key]; exists {
-		entry.AccessCount++
-		entry.LastAccessed = time.Now()
+	if keys := c.files[entry.FileKey]; keys != nil {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(c.files, entry.FileKey)
+		}
 	}
 }
 
 // evictLeastRecentlyUsed removes the least recently used entry
-func (m *CacheMiddleware) evictLeastRecentlyUsed() {
+func (c *Cache) evictLeastRecentlyUsed() {
 	var oldestKey string
 	var oldestTime time.Time
 
-	for key, entry := range m.cache {
+	for key, entry := range c.entries {
 		if oldestKey == "" || entry.LastAccessed.Before(oldestTime) {
 			oldestKey = key
 			oldestTime = entry.LastAccessed
@@ -174,97 +365,142 @@ func (m *CacheMiddleware) evictLeastRecentlyUsed() {
 	}
 
 	if oldestKey != "" {
-		delete(m.cache, oldestKey)
+		c.remove(oldestKey)
+	}
+}
+
+// Invalidate removes the cached artifacts of a file and announces it to the other instances
+// The local entries are removed even when announcing fails
+func (c *Cache) Invalidate(ctx context.Context, fileKey string) error {
+	c.InvalidateFile(fileKey)
+	if c.config.Invalidator == nil {
+		return nil
 	}
+	return c.config.Invalidator.Publish(ctx, fileKey)
 }
 
-// getTTLForOperation returns the TTL for a specific operation
-func (m *CacheMiddleware) getTTLForOperation(operation string) time.Duration {
-	switch operation {
-	case "preview":
-		return m.config.PresignedURLTTL
-	case "download":
-		return m.config.MetadataTTL
-	default:
-		return m.config.DefaultTTL
+// InvalidateFile removes the cached artifacts of a file in this instance, including those of its thumbnails
+func (c *Cache) InvalidateFile(fileKey string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for key := range c.files[fileKey] {
+		c.remove(key)
 	}
 }
 
 // startCleanupRoutine starts a background cleanup routine
-func (m *CacheMiddleware) startCleanupRoutine() {
-	ticker := time.NewTicker(m.config.CleanupInterval)
+func (c *Cache) startCleanupRoutine() {
+	ticker := time.NewTicker(c.config.CleanupInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		m.performCleanup()
+	for {
+		select {
+		case <-ticker.C:
+			c.performCleanup()
+		case <-c.stop:
+			return
+		}
 	}
 }
 
+// Stop stops the cleanup routine and the invalidation subscription
+func (c *Cache) Stop() {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		if c.unsubscribe != nil {
+			c.unsubscribe()
+		}
+	})
+}
+
 // performCleanup removes expired entries from cache
-func (m *CacheMiddleware) performCleanup() {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+func (c *Cache) performCleanup() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
 	now := time.Now()
-	expiredKeys := make([]string, 0)
-
-	for key, entry := range m.cache {
+	expired := 0
+	for key, entry := range c.entries {
 		if now.After(entry.ExpiresAt) {
-			expiredKeys = append(expiredKeys, key)
+			c.remove(key)
+			expired++
 		}
 	}
 
-	for _, key := range expiredKeys {
-		delete(m.cache, key)
-	}
-
-	if len(expiredKeys) > 0 {
-		fmt.Printf("🧹 Cache cleanup: removed %d expired entries\n", len(expiredKeys))
+	if expired > 0 {
+		c.config.Logger.Debug("cache cleanup", map[string]interface{}{
+			"expired_entries": expired,
+		})
 	}
 }
 
 // GetStats returns cache statistics
-func (m *CacheMiddleware) GetStats() map[string]interface{} {
-	m.mutex.RLock()
-	defer m.mutex.RUnlock()
+func (c *Cache) GetStats() map[string]interface{} {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
 	totalAccesses := int64(0)
 	now := time.Now()
 	expiredCount := 0
+	kinds := make(map[string]int)
 
-	for _, entry := range m.cache {
+	for key, entry := range c.entries {
 		totalAccesses += entry.AccessCount
 		if now.After(entry.ExpiresAt) {
 			expiredCount++
 		}
+		kind, _, _ := strings.Cut(key, "\x00")
+		kinds[kind]++
 	}
 
-	return map[string]interface{}{
-		"enabled":           m.config.Enabled,
-		"total_entries":     len(m.cache),
-		"max_size":          m.config.MaxSize,
+	stats := map[string]interface{}{
+		"enabled":           c.config.Enabled,
+		"total_entries":     len(c.entries),
+		"max_size":          c.config.MaxSize,
 		"expired_entries":   expiredCount,
 		"total_accesses":    totalAccesses,
-		"default_ttl":       m.config.DefaultTTL,
-		"presigned_url_ttl": m.config.PresignedURLTTL,
-		"metadata_ttl":      m.config.MetadataTTL,
+		"stat_entries":      kinds[cacheKindStat],
+		"url_entries":       kinds[cacheKindURL],
+		"preview_entries":   kinds[cacheKindPreview],
+		"preview_bytes":     c.previewBytes,
+		"default_ttl":       c.config.DefaultTTL,
+		"presigned_url_ttl": c.config.PresignedURLTTL,
+		"metadata_ttl":      c.config.MetadataTTL,
+	}
+	if reporter, ok := c.config.Invalidator.(interface{ GetStats() map[string]interface{} }); ok {
+		stats["invalidation"] = reporter.GetStats()
 	}
+	return stats
 }
 
-// Clear clears all cache entries
-func (m *CacheMiddleware) Clear() {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// CacheStatus represents the fill level of a cache
+type CacheStatus struct {
+	Enabled bool `json:"enabled"`
+	Entries int  `json:"entries"`
+	MaxSize int  `json:"max_size"`
+}
 
-	m.cache = make(map[string]*CacheEntry)
+// Status returns the fill level of the cache
+func (c *Cache) Status() CacheStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return CacheStatus{
+		Enabled: c.config.Enabled,
+		Entries: len(c.entries),
+		MaxSize: c.config.MaxSize,
+	}
 }
 
-// InvalidateKey removes a specific cache entry
-func (m *CacheMiddleware) InvalidateKey(key string) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+// Clear clears all cache entries
+func (c *Cache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
 
-	delete(m.cache, key)
+	c.entries = make(map[string]*CacheEntry)
+	c.files = make(map[string]map[string]bool)
+	c.previewBytes = 0
 }
 
 // DefaultCacheConfig returns a default cache configuration
@@ -277,5 +513,7 @@ func DefaultCacheConfig() CacheConfig {
 		PresignedURLTTL:   1 * time.Hour,    // 1 hour for presigned URLs
 		MetadataTTL:       10 * time.Minute, // 10 minutes for metadata
 		EnableCompression: false,            // Disable compression for now
+		MaxPreviewSize:    256 * 1024,       // 256KB
+		MaxPreviewBytes:   64 * 1024 * 1024, // 64MB
 	}
 }
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisCacheInvalidatorConfig represents Redis cache invalidation configuration
+type RedisCacheInvalidatorConfig struct {
+	Channel string `json:"channel"` // Pub/sub channel shared by all instances, default "storage:cache:invalidate"
+}
+
+// RedisCacheInvalidator shares cache invalidations between instances through Redis pub/sub
+// Pub/sub does not buffer: invalidations sent while an instance is disconnected are lost
+// and its entries stay until their TTL expires
+type RedisCacheInvalidator struct {
+	client    redis.UniversalClient
+	config    RedisCacheInvalidatorConfig
+	published atomic.Int64
+	received  atomic.Int64
+}
+
+// NewRedisCacheInvalidator creates a new Redis cache invalidator
+// The client is owned by the caller and is not closed by the invalidator
+func NewRedisCacheInvalidator(client redis.UniversalClient, config RedisCacheInvalidatorConfig) *RedisCacheInvalidator {
+	if config.Channel == "" {
+		config.Channel = "storage:cache:invalidate"
+	}
+	return &RedisCacheInvalidator{
+		client: client,
+		config: config,
+	}
+}
+
+// Publish announces that the cached artifacts of a file are stale
+func (i *RedisCacheInvalidator) Publish(ctx context.Context, fileKey string) error {
+	if err := i.client.Publish(ctx, i.config.Channel, fileKey).Err(); err != nil {
+		return fmt.Errorf("failed to publish cache invalidation: %w", err)
+	}
+	i.published.Add(1)
+	return nil
+}
+
+// Subscribe calls invalidate for every file announced by any instance, including this one
+func (i *RedisCacheInvalidator) Subscribe(ctx context.Context, invalidate func(fileKey string)) (func(), error) {
+	pubsub := i.client.Subscribe(context.Background(), i.config.Channel)
+
+	// Wait for the subscription, so no invalidation published afterwards is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, fmt.Errorf("failed to subscribe to cache invalidations: %w", err)
+	}
+
+	go func() {
+		for message := range pubsub.Channel() {
+			i.received.Add(1)
+			invalidate(message.Payload)
+		}
+	}()
+	return func() { pubsub.Close() }, nil
+}
+
+// GetStats returns invalidation statistics of this process
+func (i *RedisCacheInvalidator) GetStats() map[string]interface{} {
+	return map[string]interface{}{
+		"backend":   "redis",
+		"channel":   i.config.Channel,
+		"published": i.published.Load(),
+		"received":  i.received.Load(),
+	}
+}
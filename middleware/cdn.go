@@ -2,15 +2,22 @@ package middleware
 
 import (
 	"context"
+	"crypto/rsa"
 	"fmt"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 )
 
 // CDNMiddleware handles CDN integration
 type CDNMiddleware struct {
 	config CDNConfig
+
+	// CloudFront signing key, parsed on first use
+	keyOnce sync.Once
+	key     *rsa.PrivateKey
+	keyErr  error
 }
 
 // CDNConfig represents CDN middleware configuration
@@ -22,6 +29,7 @@ type CDNConfig struct {
 	PurgeOnUpdate bool              `json:"purge_on_update"`
 	Headers       map[string]string `json:"headers,omitempty"`
 	Transform     CDNTransform      `json:"transform,omitempty"`
+	Signing       CDNSigningConfig  `json:"signing,omitempty"` // Signed URLs for private content
 }
 
 // CDNTransform represents CDN transformation settings
@@ -0,0 +1,237 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Object metadata keys written for compressed files
+const (
+	CompressionMetadataKey      = "compression"       // Algorithm the stored data is compressed with
+	UncompressedSizeMetadataKey = "uncompressed-size" // Size of the original file
+)
+
+// Compression algorithms
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// CompressionMiddleware compresses text-like files at rest
+// Downloads and streams are decompressed by the handler, see Decompress
+type CompressionMiddleware struct {
+	config CompressionConfig
+}
+
+// CompressionConfig represents compression middleware configuration
+type CompressionConfig struct {
+	Algorithm    string   `json:"algorithm,omitempty"`     // "gzip" (default) or "zstd"
+	Level        int      `json:"level,omitempty"`         // Algorithm specific level, 0 uses the default
+	ContentTypes []string `json:"content_types,omitempty"` // Compressed types, entries ending in "/" match a family, defaults to text-like types
+	MinSize      int64    `json:"min_size,omitempty"`      // Smaller files are stored as is, default 1KB
+}
+
+// DefaultCompressionConfig returns default compression configuration
+func DefaultCompressionConfig() CompressionConfig {
+	return CompressionConfig{
+		Algorithm: CompressionGzip,
+		ContentTypes: []string{
+			"text/",
+			"application/json",
+			"application/x-ndjson",
+			"application/xml",
+			"application/javascript",
+			"application/x-yaml",
+			"image/svg+xml",
+		},
+		MinSize: 1024,
+	}
+}
+
+// Validate checks the compression configuration
+func (c CompressionConfig) Validate() error {
+	switch c.Algorithm {
+	case "", CompressionGzip, CompressionZstd:
+		return nil
+	}
+	return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Unsupported compression algorithm " + c.Algorithm}
+}
+
+// NewCompressionMiddleware creates a new compression middleware, unset values use DefaultCompressionConfig
+func NewCompressionMiddleware(config CompressionConfig) *CompressionMiddleware {
+	defaults := DefaultCompressionConfig()
+	if config.Algorithm == "" {
+		config.Algorithm = defaults.Algorithm
+	}
+	if len(config.ContentTypes) == 0 {
+		config.ContentTypes = defaults.ContentTypes
+	}
+	if config.MinSize == 0 {
+		config.MinSize = defaults.MinSize
+	}
+	return &CompressionMiddleware{config: config}
+}
+
+// Name returns the middleware name
+func (m *CompressionMiddleware) Name() string {
+	return "compression"
+}
+
+// Process compresses uploads of compressible content types
+// It must run before the encryption middleware, encrypted data does not compress
+func (m *CompressionMiddleware) Process(ctx context.Context, req *StorageRequest, next MiddlewareFunc) (*StorageResponse, error) {
+	if req.Operation != "upload" || req.FileData == nil || !m.compressible(req) {
+		return next(ctx, req)
+	}
+
+	data, err := req.BufferData()
+	if err != nil {
+		return &StorageResponse{
+			Success: false,
+			Error:   &errors.StorageError{Code: errors.ErrMiddlewareFailed.Code, Message: "Failed to read file data", Err: err},
+		}, nil
+	}
+
+	compressed, err := req.NewBuffer(0)
+	if err == nil {
+		err = m.compress(compressed, data)
+	}
+	if err == nil {
+		err = data.Rewind()
+	}
+	if err != nil {
+		return &StorageResponse{
+			Success: false,
+			Error:   &errors.StorageError{Code: errors.ErrMiddlewareFailed.Code, Message: "Failed to compress data", Err: err},
+		}, nil
+	}
+
+	// Keep files that do not get smaller as they are
+	if compressed.Size() >= data.Size() {
+		compressed.Close()
+		return next(ctx, req)
+	}
+
+	if err := compressed.Rewind(); err != nil {
+		return &StorageResponse{
+			Success: false,
+			Error:   &errors.StorageError{Code: errors.ErrMiddlewareFailed.Code, Message: "Failed to compress data", Err: err},
+		}, nil
+	}
+	req.FileData = compressed
+	req.FileSize = compressed.Size()
+
+	// Stored with the object so downloads can decompress it
+	if req.ObjectMetadata == nil {
+		req.ObjectMetadata = make(map[string]string)
+	}
+	req.ObjectMetadata[CompressionMetadataKey] = m.config.Algorithm
+	req.ObjectMetadata[UncompressedSizeMetadataKey] = strconv.FormatInt(data.Size(), 10)
+
+	response, err := next(ctx, req)
+	if err != nil {
+		return response, err
+	}
+
+	if response.Metadata == nil {
+		response.Metadata = make(map[string]interface{})
+	}
+	response.Metadata["compression"] = m.config.Algorithm
+	response.Metadata["compressed_size"] = compressed.Size()
+
+	return response, nil
+}
+
+// compressible reports whether an upload should be compressed
+func (m *CompressionMiddleware) compressible(req *StorageRequest) bool {
+	if req.FileSize > 0 && req.FileSize < m.config.MinSize {
+		return false
+	}
+	// Data encrypted by an earlier middleware is incompressible
+	if IsEncrypted(req.ObjectMetadata) {
+		return false
+	}
+
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(req.ContentType, ";")[0]))
+	for _, allowed := range m.config.ContentTypes {
+		if contentType == allowed || (strings.HasSuffix(allowed, "/") && strings.HasPrefix(contentType, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// compress writes the data compressed with the configured algorithm to dst
+func (m *CompressionMiddleware) compress(dst io.Writer, data io.Reader) error {
+	var writer io.WriteCloser
+
+	switch m.config.Algorithm {
+	case CompressionGzip:
+		level := m.config.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gzipWriter, err := gzip.NewWriterLevel(dst, level)
+		if err != nil {
+			return err
+		}
+		writer = gzipWriter
+	case CompressionZstd:
+		level := zstd.SpeedDefault
+		if m.config.Level > 0 {
+			level = zstd.EncoderLevelFromZstd(m.config.Level)
+		}
+		zstdWriter, err := zstd.NewWriter(dst, zstd.WithEncoderLevel(level))
+		if err != nil {
+			return err
+		}
+		writer = zstdWriter
+	default:
+		return fmt.Errorf("unsupported compression algorithm: %s", m.config.Algorithm)
+	}
+
+	if _, err := io.Copy(writer, data); err != nil {
+		writer.Close()
+		return err
+	}
+	return writer.Close()
+}
+
+// IsCompressed reports whether object metadata marks a file compressed by the compression middleware
+func IsCompressed(metadata map[string]string) bool {
+	return metadataValue(metadata, CompressionMetadataKey) != ""
+}
+
+// OriginalSize returns the size of a file as uploaded, before compression and encryption
+func OriginalSize(metadata map[string]string, storedSize int64) int64 {
+	if IsCompressed(metadata) {
+		if size, err := strconv.ParseInt(metadataValue(metadata, UncompressedSizeMetadataKey), 10, 64); err == nil {
+			return size
+		}
+	}
+	return PlaintextSize(metadata, storedSize)
+}
+
+// Decompress returns a reader of the original data of a file compressed by the compression middleware
+// data is the stored file, decrypted first when it is encrypted as well
+func Decompress(data io.Reader, metadata map[string]string) (io.ReadCloser, error) {
+	switch algorithm := metadataValue(metadata, CompressionMetadataKey); algorithm {
+	case CompressionGzip:
+		return gzip.NewReader(data)
+	case CompressionZstd:
+		decoder, err := zstd.NewReader(data)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression algorithm: %s", algorithm)
+	}
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// DownloadCount holds the download counters of a file
+type DownloadCount struct {
+	FileKey string `json:"file_key"`
+	UserID  string `json:"user_id,omitempty"`
+	Total   int64  `json:"total"` // Downloads of the file by all users
+	User    int64  `json:"user"`  // Downloads of the file by UserID
+}
+
+// DownloadCounter stores per-file and per-user download counters
+type DownloadCounter interface {
+	// Increment records a download unless the file already reached limit (0 means unlimited)
+	// It returns the counters after the call and whether the download was recorded
+	Increment(ctx context.Context, fileKey, userID string, limit int64) (*DownloadCount, bool, error)
+	// Get returns the counters of a file, User is only filled when userID is set
+	Get(ctx context.Context, fileKey, userID string) (*DownloadCount, error)
+	// Reset clears all counters of a file
+	Reset(ctx context.Context, fileKey string) error
+	// GetStats returns counter statistics for monitoring
+	GetStats() map[string]interface{}
+}
+
+// MemoryDownloadCounter keeps download counters in process memory
+// Counters are lost on restart and not shared between instances, use RedisDownloadCounter for that
+type MemoryDownloadCounter struct {
+	files    map[string]*fileDownloads
+	recorded atomic.Int64
+	denied   atomic.Int64
+	mutex    sync.Mutex
+}
+
+// fileDownloads holds the counters of a single file
+type fileDownloads struct {
+	total int64
+	users map[string]int64
+}
+
+// NewMemoryDownloadCounter creates a new in-memory download counter
+func NewMemoryDownloadCounter() *MemoryDownloadCounter {
+	return &MemoryDownloadCounter{
+		files: make(map[string]*fileDownloads),
+	}
+}
+
+// Increment records a download unless the file already reached limit
+func (c *MemoryDownloadCounter) Increment(ctx context.Context, fileKey, userID string, limit int64) (*DownloadCount, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	file, exists := c.files[fileKey]
+	if !exists {
+		file = &fileDownloads{users: make(map[string]int64)}
+		c.files[fileKey] = file
+	}
+
+	if limit > 0 && file.total >= limit {
+		c.denied.Add(1)
+		return file.count(fileKey, userID), false, nil
+	}
+
+	file.total++
+	if userID != "" {
+		file.users[userID]++
+	}
+	c.recorded.Add(1)
+
+	return file.count(fileKey, userID), true, nil
+}
+
+// Get returns the counters of a file
+func (c *MemoryDownloadCounter) Get(ctx context.Context, fileKey, userID string) (*DownloadCount, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	file, exists := c.files[fileKey]
+	if !exists {
+		return &DownloadCount{FileKey: fileKey, UserID: userID}, nil
+	}
+	return file.count(fileKey, userID), nil
+}
+
+// Reset clears all counters of a file
+func (c *MemoryDownloadCounter) Reset(ctx context.Context, fileKey string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.files, fileKey)
+	return nil
+}
+
+// GetStats returns counter statistics
+func (c *MemoryDownloadCounter) GetStats() map[string]interface{} {
+	c.mutex.Lock()
+	files := len(c.files)
+	c.mutex.Unlock()
+
+	return map[string]interface{}{
+		"backend":  "memory",
+		"files":    files,
+		"recorded": c.recorded.Load(),
+		"denied":   c.denied.Load(),
+	}
+}
+
+// count builds a DownloadCount snapshot
+func (f *fileDownloads) count(fileKey, userID string) *DownloadCount {
+	count := &DownloadCount{
+		FileKey: fileKey,
+		UserID:  userID,
+		Total:   f.total,
+	}
+	if userID != "" {
+		count.User = f.users[userID]
+	}
+	return count
+}
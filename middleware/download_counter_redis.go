@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// incrementDownloadScript increments the file and user counters unless the limit is reached
+// KEYS: total counter, per-user hash; ARGV: user ID, limit, TTL in milliseconds
+var incrementDownloadScript = redis.NewScript(`
+local limit = tonumber(ARGV[2])
+local total = tonumber(redis.call('GET', KEYS[1]) or '0')
+local user = 0
+if ARGV[1] ~= '' then
+	user = tonumber(redis.call('HGET', KEYS[2], ARGV[1]) or '0')
+end
+if limit > 0 and total >= limit then
+	return {total, user, 0}
+end
+total = redis.call('INCR', KEYS[1])
+if ARGV[1] ~= '' then
+	user = redis.call('HINCRBY', KEYS[2], ARGV[1], 1)
+end
+local ttl = tonumber(ARGV[3])
+if ttl > 0 then
+	redis.call('PEXPIRE', KEYS[1], ttl)
+	redis.call('PEXPIRE', KEYS[2], ttl)
+end
+return {total, user, 1}
+`)
+
+// RedisDownloadCounterConfig represents Redis download counter configuration
+type RedisDownloadCounterConfig struct {
+	Prefix string        `json:"prefix"` // Key prefix, default "storage:downloads"
+	TTL    time.Duration `json:"ttl"`    // Counters expire after this long without downloads, 0 keeps them forever
+}
+
+// RedisDownloadCounter keeps download counters in Redis so limits hold across instances
+type RedisDownloadCounter struct {
+	client   redis.UniversalClient
+	config   RedisDownloadCounterConfig
+	recorded atomic.Int64
+	denied   atomic.Int64
+}
+
+// NewRedisDownloadCounter creates a new Redis download counter
+// The client is owned by the caller and is not closed by the counter
+func NewRedisDownloadCounter(client redis.UniversalClient, config RedisDownloadCounterConfig) *RedisDownloadCounter {
+	if config.Prefix == "" {
+		config.Prefix = "storage:downloads"
+	}
+	return &RedisDownloadCounter{
+		client: client,
+		config: config,
+	}
+}
+
+// Increment records a download unless the file already reached limit
+func (c *RedisDownloadCounter) Increment(ctx context.Context, fileKey, userID string, limit int64) (*DownloadCount, bool, error) {
+	keys := []string{c.totalKey(fileKey), c.usersKey(fileKey)}
+	result, err := incrementDownloadScript.Run(ctx, c.client, keys, userID, limit, c.config.TTL.Milliseconds()).Int64Slice()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to increment download counter: %w", err)
+	}
+
+	recorded := result[2] == 1
+	if recorded {
+		c.recorded.Add(1)
+	} else {
+		c.denied.Add(1)
+	}
+
+	return &DownloadCount{
+		FileKey: fileKey,
+		UserID:  userID,
+		Total:   result[0],
+		User:    result[1],
+	}, recorded, nil
+}
+
+// Get returns the counters of a file
+func (c *RedisDownloadCounter) Get(ctx context.Context, fileKey, userID string) (*DownloadCount, error) {
+	count := &DownloadCount{FileKey: fileKey, UserID: userID}
+
+	total, err := c.client.Get(ctx, c.totalKey(fileKey)).Int64()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to get download counter: %w", err)
+	}
+	count.Total = total
+
+	if userID != "" {
+		user, err := c.client.HGet(ctx, c.usersKey(fileKey), userID).Int64()
+		if err != nil && err != redis.Nil {
+			return nil, fmt.Errorf("failed to get user download counter: %w", err)
+		}
+		count.User = user
+	}
+
+	return count, nil
+}
+
+// Reset clears all counters of a file
+func (c *RedisDownloadCounter) Reset(ctx context.Context, fileKey string) error {
+	if err := c.client.Del(ctx, c.totalKey(fileKey), c.usersKey(fileKey)).Err(); err != nil {
+		return fmt.Errorf("failed to reset download counters: %w", err)
+	}
+	return nil
+}
+
+// GetStats returns counter statistics of this process
+func (c *RedisDownloadCounter) GetStats() map[string]interface{} {
+	return map[string]interface{}{
+		"backend":  "redis",
+		"recorded": c.recorded.Load(),
+		"denied":   c.denied.Load(),
+	}
+}
+
+func (c *RedisDownloadCounter) totalKey(fileKey string) string {
+	return c.config.Prefix + ":{" + fileKey + "}:total"
+}
+
+func (c *RedisDownloadCounter) usersKey(fileKey string) string {
+	return c.config.Prefix + ":{" + fileKey + "}:users"
+}
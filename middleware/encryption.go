@@ -7,11 +7,27 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"strconv"
 	"strings"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/kms"
+	"github.com/darmawan01/storage/secrets"
+)
+
+// Object metadata keys written for encrypted files
+const (
+	EncryptedMetadataKey           = "encrypted"
+	EncryptionAlgorithmMetadataKey = "encryption-algorithm"
+	EncryptionKeyIDMetadataKey     = "encryption-key-id"
+	EncryptionDataKeyMetadataKey   = "encryption-data-key" // Wrapped per-object data key (base64)
+	EncryptionKeyNameMetadataKey   = "encryption-key-name" // Master key chosen by the KeyResolver
 )
 
 // EncryptionMiddleware handles file encryption/decryption
@@ -23,20 +39,41 @@ type EncryptionMiddleware struct {
 type EncryptionConfig struct {
 	Enabled          bool   `json:"enabled"`
 	Algorithm        string `json:"algorithm"`  // "AES-256-GCM"
-	KeySource        string `json:"key_source"` // "env", "file", "kms"
+	KeySource        string `json:"key_source"` // "env", "file", "secret", "kms"
 	KeyPath          string `json:"key_path,omitempty"`
 	KeyEnvVar        string `json:"key_env_var,omitempty"`
 	KeyID            string `json:"key_id,omitempty"`
+	KeySecret        string `json:"key_secret,omitempty"` // Secret name of the hex key when KeySource is "secret"
 	EncryptAtRest    bool   `json:"encrypt_at_rest"`
 	EncryptInTransit bool   `json:"encrypt_in_transit"`
+
+	// Plaintext bytes per encrypted segment, default 64KB; segments allow range reads
+	SegmentSize int `json:"segment_size,omitempty"`
+
+	// Secrets resolves KeySecret, wrap it in secrets.NewCache to avoid a lookup per object
+	Secrets secrets.Provider `json:"-"`
+
+	// KeyProvider wraps per-object data keys when KeySource is "kms" (envelope encryption)
+	KeyProvider kms.KeyProvider `json:"-"`
+	// KeyResolver selects the master key per request, e.g. one key per tenant
+	// Requires a KeyProvider implementing kms.KeySelector; decryption is refused when
+	// the key recorded with a file differs from the resolved one
+	KeyResolver KeyResolver `json:"-"`
 }
 
 // EncryptedData represents encrypted file data
 type EncryptedData struct {
-	Data      []byte `json:"data"`
-	Nonce     []byte `json:"nonce"`
-	Algorithm string `json:"algorithm"`
-	KeyID     string `json:"key_id,omitempty"`
+	Data       []byte `json:"data"`
+	Nonce      []byte `json:"nonce"`
+	Algorithm  string `json:"algorithm"`
+	KeyID      string `json:"key_id,omitempty"`
+	WrappedKey []byte `json:"wrapped_key,omitempty"` // Data key wrapped by the KMS, envelope encryption only
+	KeyName    string `json:"key_name,omitempty"`    // Master key selected by the KeyResolver
+
+	// Set for files encrypted in segments
+	SegmentSize   int   `json:"segment_size,omitempty"`
+	PlaintextSize int64 `json:"plaintext_size,omitempty"`
+	EncryptedSize int64 `json:"encrypted_size,omitempty"`
 }
 
 // NewEncryptionMiddleware creates a new encryption middleware
@@ -76,27 +113,34 @@ func (m *EncryptionMiddleware) processUpload(ctx context.Context, req *StorageRe
 		return next(ctx, req)
 	}
 
-	// Read the file data
-	data, err := io.ReadAll(req.FileData)
+	// Buffer the file data, large files are spilled to disk
+	data, err := req.BufferData()
 	if err != nil {
 		return &StorageResponse{
 			Success: false,
-			Error:   fmt.Errorf("failed to read file data: %w", err),
+			Error:   &errors.StorageError{Code: errors.ErrMiddlewareFailed.Code, Message: "Failed to read file data", Err: err},
 		}, nil
 	}
 
 	// Encrypt the data
-	encryptedData, err := m.encryptData(data)
+	encrypted, err := req.NewBuffer(data.Size())
+	var encryptedData *EncryptedData
+	if err == nil {
+		encryptedData, err = m.encryptObject(ctx, req, data, data.Size(), encrypted)
+	}
+	if err == nil {
+		err = encrypted.Rewind()
+	}
 	if err != nil {
 		return &StorageResponse{
 			Success: false,
-			Error:   fmt.Errorf("failed to encrypt data: %w", err),
+			Error:   &errors.StorageError{Code: errors.ErrMiddlewareFailed.Code, Message: "Failed to encrypt data", Err: err},
 		}, nil
 	}
 
 	// Update the request with encrypted data
-	req.FileData = bytes.NewReader(encryptedData.Data)
-	req.FileSize = int64(len(encryptedData.Data) + len(encryptedData.Nonce) + len(encryptedData.Algorithm) + 4) // Approximate size
+	req.FileData = encrypted
+	req.FileSize = encrypted.Size()
 
 	// Add encryption metadata
 	if req.Metadata == nil {
@@ -105,6 +149,15 @@ func (m *EncryptionMiddleware) processUpload(ctx context.Context, req *StorageRe
 	req.Metadata["encrypted"] = true
 	req.Metadata["encryption_algorithm"] = encryptedData.Algorithm
 	req.Metadata["encryption_key_id"] = encryptedData.KeyID
+	req.Metadata["plaintext_size"] = encryptedData.PlaintextSize
+
+	// Stored with the object so downloads can decrypt it
+	if req.ObjectMetadata == nil {
+		req.ObjectMetadata = make(map[string]string)
+	}
+	for key, value := range encryptedData.objectMetadata() {
+		req.ObjectMetadata[key] = value
+	}
 
 	// Process with next middleware
 	response, err := next(ctx, req)
@@ -138,16 +191,23 @@ func (m *EncryptionMiddleware) processDownload(ctx context.Context, req *Storage
 			if err != nil {
 				return &StorageResponse{
 					Success: false,
-					Error:   fmt.Errorf("failed to read encrypted data: %w", err),
+					Error:   &errors.StorageError{Code: errors.ErrMiddlewareFailed.Code, Message: "Failed to read encrypted data", Err: err},
 				}, nil
 			}
 
 			// Decrypt the data
-			decryptedData, err := m.decryptData(data)
+			metadata := stringMetadata(response.Metadata)
+			if err := m.CheckKey(ctx, req, metadata); err != nil {
+				return &StorageResponse{
+					Success: false,
+					Error:   err,
+				}, nil
+			}
+			decryptedData, err := m.Decrypt(ctx, data, metadata)
 			if err != nil {
 				return &StorageResponse{
 					Success: false,
-					Error:   fmt.Errorf("failed to decrypt data: %w", err),
+					Error:   &errors.StorageError{Code: errors.ErrMiddlewareFailed.Code, Message: "Failed to decrypt data", Err: err},
 				}, nil
 			}
 
@@ -160,6 +220,169 @@ func (m *EncryptionMiddleware) processDownload(ctx context.Context, req *Storage
 	return response, nil
 }
 
+// encryptObject encrypts size bytes of data in segments to dst, with a fresh data key per object when a key provider is used
+func (m *EncryptionMiddleware) encryptObject(ctx context.Context, req *StorageRequest, data io.Reader, size int64, dst io.Writer) (*EncryptedData, error) {
+	keyName, err := m.resolveKeyName(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		key     []byte
+		keyID   = m.config.KeyID
+		wrapped []byte
+	)
+	if m.config.KeySource == "kms" {
+		if m.config.KeyProvider == nil {
+			return nil, fmt.Errorf("kms key source requires a key provider")
+		}
+		key, keyID, wrapped, err = kms.GenerateDataKey(ctx, m.config.KeyProvider, keyName)
+	} else if keyName != "" {
+		return nil, fmt.Errorf("key resolver requires the kms key source")
+	} else {
+		key, err = m.getEncryptionKey()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+
+	segmentSize := m.config.SegmentSize
+	if segmentSize <= 0 {
+		segmentSize = DefaultEncryptionSegmentSize
+	}
+
+	encrypted, err := encryptSegments(key, data, size, segmentSize, dst)
+	if err != nil {
+		return nil, err
+	}
+	encrypted.Algorithm = m.config.Algorithm
+	encrypted.KeyID = keyID
+	encrypted.WrappedKey = wrapped
+	encrypted.KeyName = keyName
+	return encrypted, nil
+}
+
+// objectMetadata returns the object metadata describing the encryption
+func (d *EncryptedData) objectMetadata() map[string]string {
+	metadata := map[string]string{
+		EncryptedMetadataKey:           "true",
+		EncryptionAlgorithmMetadataKey: d.Algorithm,
+	}
+	if d.KeyID != "" {
+		metadata[EncryptionKeyIDMetadataKey] = d.KeyID
+	}
+	if len(d.WrappedKey) > 0 {
+		metadata[EncryptionDataKeyMetadataKey] = base64.StdEncoding.EncodeToString(d.WrappedKey)
+	}
+	if d.KeyName != "" {
+		metadata[EncryptionKeyNameMetadataKey] = d.KeyName
+	}
+	if d.SegmentSize > 0 {
+		metadata[EncryptionSegmentSizeMetadataKey] = strconv.Itoa(d.SegmentSize)
+		metadata[EncryptionNonceMetadataKey] = base64.StdEncoding.EncodeToString(d.Nonce)
+		metadata[PlaintextSizeMetadataKey] = strconv.FormatInt(d.PlaintextSize, 10)
+		metadata[EncryptedSizeMetadataKey] = strconv.FormatInt(d.EncryptedSize, 10)
+	}
+	return metadata
+}
+
+// IsEncrypted reports whether object metadata marks the object as encrypted by this middleware
+func IsEncrypted(metadata map[string]string) bool {
+	return metadataValue(metadata, EncryptedMetadataKey) == "true"
+}
+
+// Decrypt decrypts stored file data using the object metadata written on upload
+// Envelope encrypted objects unwrap their data key with the key provider first
+func (m *EncryptionMiddleware) Decrypt(ctx context.Context, data []byte, metadata map[string]string) ([]byte, error) {
+	reader, err := m.DecryptRange(ctx, bytes.NewReader(data), metadata, 0, PlaintextSize(metadata, int64(len(data)))-1)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// objectDataKey returns the key an object was encrypted with
+func (m *EncryptionMiddleware) objectDataKey(ctx context.Context, metadata map[string]string) ([]byte, error) {
+	if encodedKey := metadataValue(metadata, EncryptionDataKeyMetadataKey); encodedKey != "" {
+		return m.unwrapDataKey(ctx, metadata, encodedKey)
+	}
+
+	key, err := m.getEncryptionKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encryption key: %w", err)
+	}
+	return key, nil
+}
+
+// RewrapKey re-encrypts the data key of an object under the current master key
+// It returns the object metadata to store, the file data itself is unchanged
+func (m *EncryptionMiddleware) RewrapKey(ctx context.Context, metadata map[string]string) (map[string]string, error) {
+	if m.config.KeyProvider == nil {
+		return nil, fmt.Errorf("key rotation requires a key provider")
+	}
+
+	encodedKey := metadataValue(metadata, EncryptionDataKeyMetadataKey)
+	if encodedKey == "" {
+		return nil, fmt.Errorf("object is not envelope encrypted")
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped data key: %w", err)
+	}
+
+	// Tenant keys stay with their tenant, only their current version changes
+	keyName := metadataValue(metadata, EncryptionKeyNameMetadataKey)
+	keyID, rewrapped, err := kms.Rewrap(ctx, m.config.KeyProvider, keyName, metadataValue(metadata, EncryptionKeyIDMetadataKey), wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rewrap data key: %w", err)
+	}
+
+	return map[string]string{
+		EncryptionKeyIDMetadataKey:   keyID,
+		EncryptionDataKeyMetadataKey: base64.StdEncoding.EncodeToString(rewrapped),
+	}, nil
+}
+
+// unwrapDataKey decodes and unwraps the data key stored with an object
+func (m *EncryptionMiddleware) unwrapDataKey(ctx context.Context, metadata map[string]string, encodedKey string) ([]byte, error) {
+	if m.config.KeyProvider == nil {
+		return nil, fmt.Errorf("envelope encrypted object requires a key provider")
+	}
+
+	wrapped, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped data key: %w", err)
+	}
+
+	dataKey, err := m.config.KeyProvider.UnwrapKey(ctx, metadataValue(metadata, EncryptionKeyIDMetadataKey), wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// metadataValue looks up object metadata as written on upload or as returned by MinIO (canonical keys)
+func metadataValue(metadata map[string]string, key string) string {
+	if value, ok := metadata[key]; ok {
+		return value
+	}
+	return metadata[http.CanonicalHeaderKey(key)]
+}
+
+// stringMetadata keeps the string values of response metadata
+func stringMetadata(metadata map[string]interface{}) map[string]string {
+	result := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		switch v := value.(type) {
+		case string:
+			result[key] = v
+		case bool:
+			result[key] = fmt.Sprint(v)
+		}
+	}
+	return result
+}
+
 // encryptData encrypts the given data
 func (m *EncryptionMiddleware) encryptData(data []byte) (*EncryptedData, error) {
 	// Get encryption key
@@ -168,6 +391,17 @@ func (m *EncryptionMiddleware) encryptData(data []byte) (*EncryptedData, error)
 		return nil, fmt.Errorf("failed to get encryption key: %w", err)
 	}
 
+	encrypted, err := encryptWithKey(key, data)
+	if err != nil {
+		return nil, err
+	}
+	encrypted.Algorithm = m.config.Algorithm
+	encrypted.KeyID = m.config.KeyID
+	return encrypted, nil
+}
+
+// encryptWithKey encrypts data with AES-GCM, the nonce is prepended to the ciphertext
+func encryptWithKey(key, data []byte) (*EncryptedData, error) {
 	// Create cipher
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -190,10 +424,8 @@ func (m *EncryptionMiddleware) encryptData(data []byte) (*EncryptedData, error)
 	ciphertext := gcm.Seal(nonce, nonce, data, nil)
 
 	return &EncryptedData{
-		Data:      ciphertext,
-		Nonce:     nonce,
-		Algorithm: m.config.Algorithm,
-		KeyID:     m.config.KeyID,
+		Data:  ciphertext,
+		Nonce: nonce,
 	}, nil
 }
 
@@ -205,6 +437,11 @@ func (m *EncryptionMiddleware) decryptData(data []byte) ([]byte, error) {
 		return nil, fmt.Errorf("failed to get encryption key: %w", err)
 	}
 
+	return decryptWithKey(key, data)
+}
+
+// decryptWithKey decrypts data produced by encryptWithKey
+func decryptWithKey(key, data []byte) ([]byte, error) {
 	// Create cipher
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -241,6 +478,8 @@ func (m *EncryptionMiddleware) getEncryptionKey() ([]byte, error) {
 		return m.getKeyFromEnv()
 	case "file":
 		return m.getKeyFromFile()
+	case "secret":
+		return m.getKeyFromSecret()
 	case "kms":
 		return m.getKeyFromKMS()
 	default:
@@ -302,11 +541,23 @@ func (m *EncryptionMiddleware) getKeyFromFile() ([]byte, error) {
 	return key, nil
 }
 
+// getKeyFromSecret retrieves the key from the secret provider, rotated keys are used once the provider returns them
+func (m *EncryptionMiddleware) getKeyFromSecret() ([]byte, error) {
+	if m.config.Secrets == nil || m.config.KeySecret == "" {
+		return nil, fmt.Errorf("encryption key secret not configured")
+	}
+
+	key, err := secrets.GetKey(context.Background(), m.config.Secrets, m.config.KeySecret, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %w", err)
+	}
+	return key, nil
+}
+
 // getKeyFromKMS retrieves the key from KMS
 func (m *EncryptionMiddleware) getKeyFromKMS() ([]byte, error) {
-	// KMS integration would require AWS SDK or similar
-	// For now, return an error indicating KMS is not implemented
-	return nil, fmt.Errorf("KMS key retrieval not implemented - requires AWS SDK integration")
+	// Master keys never leave the KMS, objects are encrypted with wrapped data keys instead
+	return nil, fmt.Errorf("kms key source only supports envelope encryption of objects")
 }
 
 // generateKey generates a new encryption key
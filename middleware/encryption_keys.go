@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+// KeyResolver returns the master key a request's file is encrypted with, "" for the provider's default key
+type KeyResolver func(ctx context.Context, req *StorageRequest) (string, error)
+
+// EntityTypeKeyResolver uses one master key per entity type, e.g. {"customer-a": "alias/customer-a"}
+// Entity types without an entry use fallback
+func EntityTypeKeyResolver(keys map[string]string, fallback string) KeyResolver {
+	return func(ctx context.Context, req *StorageRequest) (string, error) {
+		if keyName, exists := keys[req.EntityType]; exists {
+			return keyName, nil
+		}
+		return fallback, nil
+	}
+}
+
+// resolveKeyName returns the master key selected for a request
+func (m *EncryptionMiddleware) resolveKeyName(ctx context.Context, req *StorageRequest) (string, error) {
+	if m.config.KeyResolver == nil {
+		return "", nil
+	}
+
+	keyName, err := m.config.KeyResolver(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve encryption key: %w", err)
+	}
+	return keyName, nil
+}
+
+// CheckKey verifies that a file is encrypted with the key the resolver selects for the request,
+// so files cannot be read through another tenant's context
+func (m *EncryptionMiddleware) CheckKey(ctx context.Context, req *StorageRequest, metadata map[string]string) error {
+	if m.config.KeyResolver == nil {
+		return nil
+	}
+
+	keyName, err := m.resolveKeyName(ctx, req)
+	if err != nil {
+		return err
+	}
+	if keyName != metadataValue(metadata, EncryptionKeyNameMetadataKey) {
+		return errors.ErrEncryptionKeyMismatch
+	}
+	return nil
+}
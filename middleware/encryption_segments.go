@@ -0,0 +1,295 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Object metadata keys describing the segmented encryption format
+const (
+	EncryptionSegmentSizeMetadataKey = "encryption-segment-size"
+	EncryptionNonceMetadataKey       = "encryption-nonce" // Nonce prefix shared by all segments (base64)
+	PlaintextSizeMetadataKey         = "plaintext-size"
+	EncryptedSizeMetadataKey         = "encrypted-size"
+)
+
+// DefaultEncryptionSegmentSize is the plaintext size of an encrypted segment
+const DefaultEncryptionSegmentSize = 64 * 1024
+
+const (
+	segmentNoncePrefixSize = 8  // Random per object, followed by the 4 byte segment index
+	segmentTagSize         = 16 // AES-GCM authentication tag
+)
+
+// Files are encrypted in independently authenticated segments so ranges can be read
+// without downloading the whole object. Segment i is sealed with nonce prefix||i and
+// the final segment is marked in the additional data, which detects truncation.
+// Stored size = plaintext size + 16 bytes per segment; an empty file is one empty segment.
+
+// newGCM creates an AES-GCM cipher
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+// segmentCount returns the number of segments of a plaintext
+func segmentCount(plaintextSize int64, segmentSize int) int64 {
+	if plaintextSize == 0 {
+		return 1
+	}
+	return (plaintextSize + int64(segmentSize) - 1) / int64(segmentSize)
+}
+
+// segmentNonce builds the nonce of a segment
+func segmentNonce(prefix []byte, index int64) []byte {
+	nonce := make([]byte, segmentNoncePrefixSize+4)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[segmentNoncePrefixSize:], uint32(index))
+	return nonce
+}
+
+// segmentAdditionalData marks the final segment
+func segmentAdditionalData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// encryptSegments encrypts data in segments with AES-GCM
+// size bytes are read from src and the ciphertext written to dst one segment at a time
+func encryptSegments(key []byte, src io.Reader, size int64, segmentSize int, dst io.Writer) (*EncryptedData, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, segmentNoncePrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	count := segmentCount(size, segmentSize)
+	plaintext := make([]byte, segmentSize)
+	ciphertext := make([]byte, 0, segmentSize+segmentTagSize)
+	for i := int64(0); i < count; i++ {
+		length := min(int64(segmentSize), size-i*int64(segmentSize))
+		if _, err := io.ReadFull(src, plaintext[:length]); err != nil {
+			return nil, fmt.Errorf("failed to read segment %d: %w", i, err)
+		}
+		ciphertext = gcm.Seal(ciphertext[:0], segmentNonce(prefix, i), plaintext[:length], segmentAdditionalData(i == count-1))
+		if _, err := dst.Write(ciphertext); err != nil {
+			return nil, fmt.Errorf("failed to write segment %d: %w", i, err)
+		}
+	}
+
+	return &EncryptedData{
+		Nonce:         prefix,
+		SegmentSize:   segmentSize,
+		PlaintextSize: size,
+		EncryptedSize: size + count*segmentTagSize,
+	}, nil
+}
+
+// segmentLayout describes a segmented object from its metadata
+type segmentLayout struct {
+	segmentSize   int
+	plaintextSize int64
+	prefix        []byte
+}
+
+// parseSegmentLayout reads the segment layout, ok is false for objects stored as a single GCM message
+func parseSegmentLayout(metadata map[string]string) (*segmentLayout, bool, error) {
+	value := metadataValue(metadata, EncryptionSegmentSizeMetadataKey)
+	if value == "" {
+		return nil, false, nil
+	}
+
+	segmentSize, err := strconv.Atoi(value)
+	if err != nil || segmentSize <= 0 {
+		return nil, false, fmt.Errorf("invalid encryption segment size: %s", value)
+	}
+	plaintextSize, err := strconv.ParseInt(metadataValue(metadata, PlaintextSizeMetadataKey), 10, 64)
+	if err != nil || plaintextSize < 0 {
+		return nil, false, fmt.Errorf("invalid plaintext size")
+	}
+	prefix, err := base64.StdEncoding.DecodeString(metadataValue(metadata, EncryptionNonceMetadataKey))
+	if err != nil || len(prefix) != segmentNoncePrefixSize {
+		return nil, false, fmt.Errorf("invalid encryption nonce")
+	}
+
+	return &segmentLayout{
+		segmentSize:   segmentSize,
+		plaintextSize: plaintextSize,
+		prefix:        prefix,
+	}, true, nil
+}
+
+// PlaintextSize returns the size of the original file for an object of storedSize bytes
+func PlaintextSize(metadata map[string]string, storedSize int64) int64 {
+	if !IsEncrypted(metadata) {
+		return storedSize
+	}
+	if size, err := strconv.ParseInt(metadataValue(metadata, PlaintextSizeMetadataKey), 10, 64); err == nil {
+		return size
+	}
+
+	// Single GCM message: nonce, ciphertext and tag
+	size := storedSize - 12 - segmentTagSize
+	if size < 0 {
+		return 0
+	}
+	return size
+}
+
+// EncryptedRange translates the plaintext byte range [start, end] into the range of stored
+// bytes holding it, aligned to segments. ok is false when the whole object must be read
+func EncryptedRange(metadata map[string]string, start, end int64) (int64, int64, bool) {
+	layout, ok, err := parseSegmentLayout(metadata)
+	if err != nil || !ok {
+		return 0, 0, false
+	}
+
+	stored := int64(layout.segmentSize) + segmentTagSize
+	first := start / int64(layout.segmentSize)
+	last := end / int64(layout.segmentSize)
+	if count := segmentCount(layout.plaintextSize, layout.segmentSize); last >= count {
+		last = count - 1
+	}
+
+	storedSize := layout.plaintextSize + segmentCount(layout.plaintextSize, layout.segmentSize)*segmentTagSize
+	storedEnd := (last+1)*stored - 1
+	if storedEnd >= storedSize {
+		storedEnd = storedSize - 1
+	}
+	return first * stored, storedEnd, true
+}
+
+// DecryptRange returns a reader over the plaintext bytes [start, end] of an encrypted object
+// src must start at the offset returned by EncryptedRange, or at the beginning of the object
+// when it returned false. Segmented objects are decrypted while reading
+func (m *EncryptionMiddleware) DecryptRange(ctx context.Context, src io.Reader, metadata map[string]string, start, end int64) (io.Reader, error) {
+	key, err := m.objectDataKey(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	layout, segmented, err := parseSegmentLayout(metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if !segmented {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read encrypted data: %w", err)
+		}
+		plaintext, err := decryptWithKey(key, data)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(sliceRange(plaintext, start, end)), nil
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if end >= layout.plaintextSize {
+		end = layout.plaintextSize - 1
+	}
+	if start > end {
+		return bytes.NewReader(nil), nil
+	}
+
+	first := start / int64(layout.segmentSize)
+	reader := &segmentReader{
+		src:    src,
+		gcm:    gcm,
+		layout: layout,
+		index:  first,
+		count:  segmentCount(layout.plaintextSize, layout.segmentSize),
+		skip:   start - first*int64(layout.segmentSize),
+	}
+	return io.LimitReader(reader, end-start+1), nil
+}
+
+// sliceRange returns data[start:end+1] clamped to the data
+func sliceRange(data []byte, start, end int64) []byte {
+	if end >= int64(len(data)) {
+		end = int64(len(data)) - 1
+	}
+	if start > end {
+		return nil
+	}
+	return data[start : end+1]
+}
+
+// segmentReader decrypts consecutive segments from src
+type segmentReader struct {
+	src    io.Reader
+	gcm    cipher.AEAD
+	layout *segmentLayout
+	index  int64 // Next segment to decrypt
+	count  int64
+	skip   int64 // Plaintext bytes to drop from the first segment
+	buf    []byte
+	err    error
+}
+
+func (r *segmentReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.index >= r.count {
+			return 0, io.EOF
+		}
+		r.buf, r.err = r.next()
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next segment
+func (r *segmentReader) next() ([]byte, error) {
+	size := int64(r.layout.segmentSize)
+	if remaining := r.layout.plaintextSize - r.index*size; remaining < size {
+		size = remaining
+	}
+
+	sealed := make([]byte, size+segmentTagSize)
+	if _, err := io.ReadFull(r.src, sealed); err != nil {
+		return nil, fmt.Errorf("failed to read encrypted segment %d: %w", r.index, err)
+	}
+
+	plaintext, err := r.gcm.Open(sealed[:0], segmentNonce(r.layout.prefix, r.index), sealed, segmentAdditionalData(r.index == r.count-1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt segment %d: %w", r.index, err)
+	}
+	r.index++
+
+	if r.skip > 0 {
+		plaintext = plaintext[r.skip:]
+		r.skip = 0
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"math"
+	"time"
+)
+
+// latencyBounds are the upper bounds of the buckets of latency histograms, growing by 20% from
+// 100µs to 10 minutes so percentiles are off by at most a fifth; slower operations share a last bucket
+var latencyBounds = exponentialBounds(100*time.Microsecond, 10*time.Minute, 1.2)
+
+// exponentialBounds returns bucket bounds from min to max, each factor times the previous one
+func exponentialBounds(min, max time.Duration, factor float64) []time.Duration {
+	var bounds []time.Duration
+	for bound := float64(min); bound < float64(max)*factor; bound *= factor {
+		bounds = append(bounds, time.Duration(bound))
+	}
+	return bounds
+}
+
+// LatencyBounds returns the upper bounds of the buckets of latency histograms, e.g. for exporters
+func LatencyBounds() []time.Duration {
+	return append([]time.Duration(nil), latencyBounds...)
+}
+
+// LatencyHistogram represents latencies counted in the buckets of LatencyBounds
+type LatencyHistogram struct {
+	Counts []int64       `json:"counts"` // One count per bound, then the count of slower latencies
+	Total  int64         `json:"total"`
+	Max    time.Duration `json:"max"`
+	start  time.Time     // Start of the slot of a window the histogram covers
+}
+
+// NewLatencyHistogram creates an empty histogram
+func NewLatencyHistogram() *LatencyHistogram {
+	return &LatencyHistogram{Counts: make([]int64, len(latencyBounds)+1)}
+}
+
+// add counts a latency
+func (h *LatencyHistogram) add(latency time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && latency > latencyBounds[i] {
+		i++
+	}
+	h.Counts[i]++
+	h.Total++
+	if latency > h.Max {
+		h.Max = latency
+	}
+}
+
+// Merge adds the counts of another histogram, e.g. to combine the histograms of several categories
+func (h *LatencyHistogram) Merge(other *LatencyHistogram) {
+	for i, count := range other.Counts {
+		h.Counts[i] += count
+	}
+	h.Total += other.Total
+	if other.Max > h.Max {
+		h.Max = other.Max
+	}
+}
+
+// reset empties the histogram
+func (h *LatencyHistogram) reset() {
+	for i := range h.Counts {
+		h.Counts[i] = 0
+	}
+	h.Total = 0
+	h.Max = 0
+}
+
+// Percentile returns the latency q (0.0-1.0) of the latencies are at most, the bound of its bucket
+// capped at the slowest latency; 0 without latencies
+func (h *LatencyHistogram) Percentile(q float64) time.Duration {
+	if h.Total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.Total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, count := range h.Counts {
+		seen += count
+		if seen >= rank {
+			if i < len(latencyBounds) && latencyBounds[i] < h.Max {
+				return latencyBounds[i]
+			}
+			return h.Max
+		}
+	}
+	return h.Max
+}
+
+// latencyWindow is a latency histogram of a sliding window, kept as histograms of consecutive
+// slots; the oldest slot is dropped as a new one starts, so the memory used is fixed
+type latencyWindow struct {
+	slot  time.Duration
+	slots []*LatencyHistogram
+}
+
+// latencyWindowSlots is the number of slots of a window, the window slides by a slot at a time
+const latencyWindowSlots = 6
+
+// newLatencyWindow creates a histogram of the latencies of the last window
+func newLatencyWindow(window time.Duration) *latencyWindow {
+	w := &latencyWindow{
+		slot:  window / latencyWindowSlots,
+		slots: make([]*LatencyHistogram, latencyWindowSlots),
+	}
+	if w.slot <= 0 {
+		w.slot = time.Second
+	}
+	for i := range w.slots {
+		w.slots[i] = NewLatencyHistogram()
+	}
+	return w
+}
+
+// add counts a latency measured at now
+func (w *latencyWindow) add(now time.Time, latency time.Duration) {
+	start := now.Truncate(w.slot)
+	slot := w.slots[int(start.UnixNano()/int64(w.slot))%len(w.slots)]
+	if !slot.start.Equal(start) {
+		// The slot held latencies of an earlier window
+		slot.reset()
+		slot.start = start
+	}
+	slot.add(latency)
+}
+
+// snapshot returns the histogram of the latencies of the window ending at now
+func (w *latencyWindow) snapshot(now time.Time) *LatencyHistogram {
+	merged := NewLatencyHistogram()
+	oldest := now.Truncate(w.slot).Add(-time.Duration(len(w.slots)-1) * w.slot)
+	for _, slot := range w.slots {
+		if !slot.start.Before(oldest) {
+			merged.Merge(slot)
+		}
+	}
+	return merged
+}
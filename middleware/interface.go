@@ -3,6 +3,11 @@ package middleware
 import (
 	"context"
 	"io"
+
+	"github.com/darmawan01/storage/clientinfo"
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/requestid"
+	"github.com/minio/minio-go/v7"
 )
 
 // Middleware defines the interface for storage middlewares
@@ -16,7 +21,8 @@ type MiddlewareFunc func(ctx context.Context, req *StorageRequest) (*StorageResp
 
 // StorageRequest represents a request flowing through the middleware chain
 type StorageRequest struct {
-	Operation   string                 `json:"operation"` // upload, download, delete, preview
+	Operation   string                 `json:"operation"`  // upload, download, delete, preview, stream
+	RequestID   string                 `json:"request_id"` // Set from the context by the chain, generated when absent
 	FileKey     string                 `json:"file_key"`
 	FileName    string                 `json:"file_name"`
 	FileData    io.Reader              `json:"-"`
@@ -28,6 +34,27 @@ type StorageRequest struct {
 	UserID      string                 `json:"user_id"`
 	Metadata    map[string]interface{} `json:"metadata"`
 	Config      map[string]interface{} `json:"config"`
+
+	// ObjectMetadata is stored as user metadata with the uploaded object, set by middlewares
+	ObjectMetadata map[string]string `json:"object_metadata,omitempty"`
+	// BucketName holds the file when it differs from the handler bucket, e.g. for tenants with their own bucket
+	BucketName string `json:"bucket_name,omitempty"`
+	// Buffers holds FileData middlewares need to read more than once, see BufferData; a default pool when nil
+	Buffers *BufferPool `json:"-"`
+	// DisableThumbnails skips thumbnail generation of an upload
+	DisableThumbnails bool `json:"disable_thumbnails,omitempty"`
+	// IPAddress and UserAgent of the client, recorded by the audit middleware; set from the context by
+	// the chain when empty, see clientinfo
+	IPAddress string `json:"ip_address,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	// Replace marks an upload of a new version of, a write into or a metadata update of the stored
+	// file FileKey, authorized as ActionReplace
+	Replace bool `json:"replace,omitempty"`
+	// Object is the stat of the stored file FileKey read by the handler, whose owner the security
+	// middleware takes instead of looking it up again
+	Object *minio.ObjectInfo `json:"-"`
+
+	buffers []*Buffer // Released by Release
 }
 
 // StorageResponse represents a response from the middleware chain
@@ -65,8 +92,13 @@ const (
 	MemoryMiddlewareType     MiddlewareType = "memory"
 	CacheMiddlewareType      MiddlewareType = "cache"
 	MonitoringMiddlewareType MiddlewareType = "monitoring"
+	RateLimitMiddlewareType  MiddlewareType = "ratelimit"
 )
 
+// Factory creates a custom middleware of a category from its configuration, see
+// CategoryConfig.MiddlewareConfigs; config is nil when the category has none
+type Factory func(config map[string]interface{}, client *minio.Client) (Middleware, error)
+
 // MiddlewareConfig represents configuration for a middleware
 type MiddlewareConfig struct {
 	Name    string                 `json:"name"`
@@ -93,25 +125,73 @@ func (c *MiddlewareChain) Add(middleware Middleware) {
 
 // Process processes a request through the middleware chain
 func (c *MiddlewareChain) Process(ctx context.Context, req *StorageRequest) (*StorageResponse, error) {
-	if len(c.middlewares) == 0 {
+	return c.ProcessWith(ctx, req, func(ctx context.Context, req *StorageRequest) (*StorageResponse, error) {
 		return &StorageResponse{Success: true}, nil
+	})
+}
+
+// ProcessWith processes a request through the middleware chain, final performs the operation
+// once every middleware passed the request on
+func (c *MiddlewareChain) ProcessWith(ctx context.Context, req *StorageRequest, final MiddlewareFunc) (*StorageResponse, error) {
+	// Every request carries an ID correlating its logs, audit events, jobs and errors
+	if req.RequestID != "" {
+		ctx = requestid.WithID(ctx, req.RequestID)
+	} else {
+		ctx, req.RequestID = requestid.Ensure(ctx)
+	}
+	// Clients are recorded by the audit middleware and limited by address, see clientinfo
+	if req.IPAddress == "" && req.UserAgent == "" {
+		client := clientinfo.FromContext(ctx)
+		req.IPAddress = client.IPAddress
+		req.UserAgent = client.UserAgent
+	}
+	// Requests without a user act for the user of the context, see WithUser
+	if req.UserID == "" {
+		if user, ok := UserFromContext(ctx); ok {
+			req.UserID = user.ID
+		}
+	}
+	if len(c.middlewares) == 0 {
+		return final(ctx, req)
 	}
 
 	// Create the chain by building the next functions
-	var next MiddlewareFunc = func(ctx context.Context, req *StorageRequest) (*StorageResponse, error) {
-		return &StorageResponse{Success: true}, nil
-	}
+	next := final
 
 	// Build the chain in reverse order
 	for i := len(c.middlewares) - 1; i >= 0; i-- {
 		current := c.middlewares[i]
 		nextFunc := next
 		next = func(ctx context.Context, req *StorageRequest) (*StorageResponse, error) {
-			return current.Process(ctx, req, nextFunc)
+			resp, err := current.Process(ctx, req, nextFunc)
+			return coded(current.Name(), resp, err)
 		}
 	}
 
-	return next(ctx, req)
+	resp, err := next(ctx, req)
+	if resp != nil {
+		resp.Error = errors.WithRequestID(resp.Error, req.RequestID)
+	}
+	return resp, errors.WithRequestID(err, req.RequestID)
+}
+
+// coded gives the errors of a middleware an error code: rejections without one are validation
+// failures and returned errors are middleware failures
+func coded(name string, resp *StorageResponse, err error) (*StorageResponse, error) {
+	if resp != nil && resp.Error != nil && errors.Code(resp.Error) == "" {
+		resp.Error = errors.Wrap(errors.ErrValidationFailed, resp.Error)
+	}
+	if err != nil && errors.Code(err) == "" {
+		err = &errors.StorageError{Code: errors.ErrMiddlewareFailed.Code, Message: name + " middleware failed", Err: err}
+	}
+	return resp, err
+}
+
+// Middlewares returns the middlewares in the chain in execution order
+func (c *MiddlewareChain) Middlewares() []Middleware {
+	middlewares := make([]Middleware, len(c.middlewares))
+	copy(middlewares, c.middlewares)
+	return middlewares
 }
 
 // GetMiddlewareNames returns the names of all middlewares in the chain
@@ -5,12 +5,18 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/logger"
 )
 
 // MemoryMiddleware handles memory management and prevents memory leaks
 type MemoryMiddleware struct {
 	config MemoryConfig
 	mutex  sync.RWMutex
+
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // MemoryConfig represents memory middleware configuration
@@ -21,12 +27,20 @@ type MemoryConfig struct {
 	MaxFileSize      int64         `json:"max_file_size"`     // Maximum file size to process
 	EnableMonitoring bool          `json:"enable_monitoring"` // Enable memory monitoring
 	AlertThreshold   float64       `json:"alert_threshold"`   // Alert when usage exceeds this percentage (0.0-1.0)
+
+	// Buffers of uploads, included in the stats; the request's pool is used when nil
+	Buffers *BufferPool `json:"-"`
+
+	Logger logger.Logger `json:"-"` // Defaults to logger.Default()
 }
 
 // NewMemoryMiddleware creates a new memory middleware
 func NewMemoryMiddleware(config MemoryConfig) *MemoryMiddleware {
+	config.Logger = logger.OrDefault(config.Logger)
+
 	middleware := &MemoryMiddleware{
 		config: config,
+		stop:   make(chan struct{}),
 	}
 
 	// Start cleanup routine if enabled
@@ -43,26 +57,63 @@ func (m *MemoryMiddleware) Name() string {
 }
 
 // Process processes the request through memory middleware
+// Uploads are buffered up front according to the BufferConfig of the request, so later middlewares
+// reuse the buffer; buffers that would exceed MaxMemoryUsage are spilled to disk instead of rejected
 func (m *MemoryMiddleware) Process(ctx context.Context, req *StorageRequest, next MiddlewareFunc) (*StorageResponse, error) {
 	// Check if file size exceeds maximum allowed
 	if req.FileSize > m.config.MaxFileSize {
 		return &StorageResponse{
 			Success: false,
-			Error:   fmt.Errorf("file size %d exceeds maximum allowed %d", req.FileSize, m.config.MaxFileSize),
+			Error:   &errors.StorageError{Code: errors.ErrFileTooLarge.Code, Message: fmt.Sprintf("File size %d exceeds maximum allowed %d", req.FileSize, m.config.MaxFileSize)},
 		}, nil
 	}
 
+	// Only data held in memory counts, spilled buffers live on disk
+	memoryUsage := req.FileSize
+	if req.Operation == "upload" && req.FileData != nil {
+		if req.Buffers == nil {
+			req.Buffers = m.config.Buffers
+		}
+		buffer, err := req.BufferData()
+		if err != nil {
+			return &StorageResponse{
+				Success: false,
+				Error:   &errors.StorageError{Code: errors.ErrMiddlewareFailed.Code, Message: "Failed to buffer file data", Err: err},
+			}, nil
+		}
+		if buffer.Size() > m.config.MaxFileSize {
+			return &StorageResponse{
+				Success: false,
+				Error:   &errors.StorageError{Code: errors.ErrFileTooLarge.Code, Message: fmt.Sprintf("File size %d exceeds maximum allowed %d", buffer.Size(), m.config.MaxFileSize)},
+			}, nil
+		}
+
+		memoryUsage = 0
+		if buffer.InMemory() {
+			memoryUsage = buffer.Size()
+			if !m.checkMemoryAvailability(memoryUsage) {
+				if err := buffer.Spill(); err != nil {
+					return &StorageResponse{
+						Success: false,
+						Error:   &errors.StorageError{Code: errors.ErrMiddlewareFailed.Code, Message: "Failed to buffer file data", Err: err},
+					}, nil
+				}
+				memoryUsage = 0
+			}
+		}
+	}
+
 	// Check if we have enough memory available
-	if !m.checkMemoryAvailability(req.FileSize) {
+	if !m.checkMemoryAvailability(memoryUsage) {
 		return &StorageResponse{
 			Success: false,
-			Error:   fmt.Errorf("insufficient memory available for file size %d", req.FileSize),
+			Error:   &errors.StorageError{Code: "INSUFFICIENT_MEMORY", Message: fmt.Sprintf("Insufficient memory available for file size %d", req.FileSize)},
 		}, nil
 	}
 
 	// Track memory usage
-	m.addMemoryUsage(req.FileSize)
-	defer m.removeMemoryUsage(req.FileSize)
+	m.addMemoryUsage(memoryUsage)
+	defer m.removeMemoryUsage(memoryUsage)
 
 	// Process with next middleware
 	response, err := next(ctx, req)
@@ -95,10 +146,11 @@ func (m *MemoryMiddleware) addMemoryUsage(size int64) {
 	if m.config.EnableMonitoring && m.config.AlertThreshold > 0 {
 		usagePercentage := float64(m.config.CurrentUsage) / float64(m.config.MaxMemoryUsage)
 		if usagePercentage >= m.config.AlertThreshold {
-			fmt.Printf("⚠️  Memory usage alert: %.2f%% (%.2f MB / %.2f MB)\n",
-				usagePercentage*100,
-				float64(m.config.CurrentUsage)/(1024*1024),
-				float64(m.config.MaxMemoryUsage)/(1024*1024))
+			m.config.Logger.Warn("memory usage alert", map[string]interface{}{
+				"usage_percent": usagePercentage * 100,
+				"current_bytes": m.config.CurrentUsage,
+				"max_bytes":     m.config.MaxMemoryUsage,
+			})
 		}
 	}
 }
@@ -121,7 +173,7 @@ func (m *MemoryMiddleware) GetMemoryStats() map[string]interface{} {
 
 	usagePercentage := float64(m.config.CurrentUsage) / float64(m.config.MaxMemoryUsage)
 
-	return map[string]interface{}{
+	stats := map[string]interface{}{
 		"current_usage":      m.config.CurrentUsage,
 		"max_usage":          m.config.MaxMemoryUsage,
 		"usage_percentage":   usagePercentage,
@@ -130,6 +182,10 @@ func (m *MemoryMiddleware) GetMemoryStats() map[string]interface{} {
 		"monitoring_enabled": m.config.EnableMonitoring,
 		"alert_threshold":    m.config.AlertThreshold,
 	}
+	if m.config.Buffers != nil {
+		stats["buffers"] = m.config.Buffers.GetStats()
+	}
+	return stats
 }
 
 // startCleanupRoutine starts a background cleanup routine
@@ -137,11 +193,23 @@ func (m *MemoryMiddleware) startCleanupRoutine() {
 	ticker := time.NewTicker(m.config.CleanupInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		m.performCleanup()
+	for {
+		select {
+		case <-ticker.C:
+			m.performCleanup()
+		case <-m.stop:
+			return
+		}
 	}
 }
 
+// Stop stops the cleanup routine
+func (m *MemoryMiddleware) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+	})
+}
+
 // performCleanup performs memory cleanup
 func (m *MemoryMiddleware) performCleanup() {
 	m.mutex.Lock()
@@ -154,7 +222,9 @@ func (m *MemoryMiddleware) performCleanup() {
 	m.config.CurrentUsage = 0
 
 	if oldUsage > 0 {
-		fmt.Printf("🧹 Memory cleanup: freed %.2f MB\n", float64(oldUsage)/(1024*1024))
+		m.config.Logger.Debug("memory cleanup", map[string]interface{}{
+			"freed_bytes": oldUsage,
+		})
 	}
 }
 
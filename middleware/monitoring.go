@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"sync"
 	"time"
+
+	"github.com/darmawan01/storage/logger"
 )
 
 // MonitoringMiddleware handles performance monitoring and metrics collection
@@ -12,6 +14,13 @@ type MonitoringMiddleware struct {
 	config MonitoringConfig
 	stats  *MonitoringStats
 	mutex  sync.RWMutex
+
+	// Latency histograms of the last LatencyWindow, of all operations and per operation
+	latency          *latencyWindow
+	operationLatency map[string]*latencyWindow
+
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
 // MonitoringConfig represents monitoring middleware configuration
@@ -24,9 +33,38 @@ type MonitoringConfig struct {
 	TrackConcurrency    bool          `json:"track_concurrency"`    // Track concurrent operations
 	MetricsInterval     time.Duration `json:"metrics_interval"`     // How often to log metrics
 	EnableAlerts        bool          `json:"enable_alerts"`        // Enable performance alerts
-	LatencyThreshold    time.Duration `json:"latency_threshold"`    // Alert if latency exceeds this
+	LatencyThreshold    time.Duration `json:"latency_threshold"`    // Alert if the p99 latency of the window exceeds this
 	ErrorThreshold      float64       `json:"error_threshold"`      // Alert if error rate exceeds this (0.0-1.0)
 	ThroughputThreshold int64         `json:"throughput_threshold"` // Alert if throughput drops below this
+	// LatencyWindow is the period latency percentiles are reported for, so long-running processes
+	// report recent latencies, default 5 minutes
+	LatencyWindow time.Duration `json:"latency_window,omitempty"`
+
+	// Labels are the dimensions of the stats, e.g. LabelHandler, LabelCategory and LabelBackend;
+	// they are included in the stats, the logged metrics and alerts
+	Labels map[string]string `json:"labels,omitempty"`
+
+	Logger          logger.Logger   `json:"-"` // Defaults to logger.Default()
+	DownloadCounter DownloadCounter `json:"-"` // Download counters included in the stats when set
+}
+
+// Labels of monitoring stats
+const (
+	LabelHandler  = "handler"
+	LabelCategory = "category"
+	LabelBackend  = "backend" // Endpoint of the storage backend
+)
+
+// MonitoringSeries represents the operation stats of a monitoring middleware with its labels, the
+// form exporters read them in
+type MonitoringSeries struct {
+	Labels      map[string]string         `json:"labels"`
+	Operations  map[string]OperationStats `json:"operations"`
+	ErrorCounts map[string]int64          `json:"error_counts"`
+	Since       time.Time                 `json:"since"` // Start of the stats, the last reset
+	// Latencies are the histograms of the recent latencies of each operation, the percentiles are
+	// read from; series of several middlewares are combined with LatencyHistogram.Merge
+	Latencies map[string]*LatencyHistogram `json:"-"`
 }
 
 // MonitoringStats represents collected monitoring statistics
@@ -67,10 +105,25 @@ type OperationStats struct {
 	MaxLatency     time.Duration `json:"max_latency"`
 	BytesProcessed int64         `json:"bytes_processed"`
 	LastOperation  time.Time     `json:"last_operation"`
+
+	// Latency percentiles of the last LatencyWindow, set in the stats returned
+	P50Latency time.Duration `json:"p50_latency"`
+	P90Latency time.Duration `json:"p90_latency"`
+	P99Latency time.Duration `json:"p99_latency"`
 }
 
 // NewMonitoringMiddleware creates a new monitoring middleware
 func NewMonitoringMiddleware(config MonitoringConfig) *MonitoringMiddleware {
+	config.Logger = logger.OrDefault(config.Logger)
+	if config.LatencyWindow <= 0 {
+		config.LatencyWindow = 5 * time.Minute
+	}
+	labels := make(map[string]string, len(config.Labels))
+	for name, value := range config.Labels {
+		labels[name] = value
+	}
+	config.Labels = labels
+
 	stats := &MonitoringStats{
 		ErrorCounts:    make(map[string]int64),
 		OperationStats: make(map[string]*OperationStats),
@@ -79,8 +132,11 @@ func NewMonitoringMiddleware(config MonitoringConfig) *MonitoringMiddleware {
 	}
 
 	middleware := &MonitoringMiddleware{
-		config: config,
-		stats:  stats,
+		config:           config,
+		stats:            stats,
+		latency:          newLatencyWindow(config.LatencyWindow),
+		operationLatency: make(map[string]*latencyWindow),
+		stop:             make(chan struct{}),
 	}
 
 	// Start metrics logging if enabled
@@ -145,7 +201,9 @@ func (m *MonitoringMiddleware) updateStats(operation string, response *StorageRe
 	}
 
 	// Update latency metrics
+	now := time.Now()
 	if m.config.TrackLatency {
+		m.latency.add(now, latency)
 		m.stats.TotalLatency += latency
 		if m.stats.MinLatency == 0 || latency < m.stats.MinLatency {
 			m.stats.MinLatency = latency
@@ -171,7 +229,11 @@ func (m *MonitoringMiddleware) updateStats(operation string, response *StorageRe
 
 	opStats := m.stats.OperationStats[operation]
 	opStats.Count++
-	opStats.LastOperation = time.Now()
+	opStats.LastOperation = now
+	if m.operationLatency[operation] == nil {
+		m.operationLatency[operation] = newLatencyWindow(m.config.LatencyWindow)
+	}
+	m.operationLatency[operation].add(now, latency)
 
 	if err != nil || (response != nil && !response.Success) {
 		opStats.ErrorCount++
@@ -208,19 +270,24 @@ func (m *MonitoringMiddleware) checkAlerts() {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	// Check latency alert
-	if m.config.TrackLatency && m.stats.AvgLatency > m.config.LatencyThreshold {
-		fmt.Printf("⚠️  High latency alert: %.2fms (threshold: %.2fms)\n",
-			float64(m.stats.AvgLatency.Nanoseconds())/1e6,
-			float64(m.config.LatencyThreshold.Nanoseconds())/1e6)
+	// Check latency alert, on recent latencies so an incident is not hidden by the lifetime average
+	if m.config.TrackLatency {
+		if p99 := m.latency.snapshot(time.Now()).Percentile(0.99); p99 > m.config.LatencyThreshold {
+			m.config.Logger.Warn("high latency alert", m.labeled(map[string]interface{}{
+				"p99_latency_ms": float64(p99.Nanoseconds()) / 1e6,
+				"threshold_ms":   float64(m.config.LatencyThreshold.Nanoseconds()) / 1e6,
+			}))
+		}
 	}
 
 	// Check error rate alert
 	if m.stats.TotalOperations > 0 {
 		errorRate := float64(m.stats.FailedOps) / float64(m.stats.TotalOperations)
 		if errorRate > m.config.ErrorThreshold {
-			fmt.Printf("⚠️  High error rate alert: %.2f%% (threshold: %.2f%%)\n",
-				errorRate*100, m.config.ErrorThreshold*100)
+			m.config.Logger.Warn("high error rate alert", m.labeled(map[string]interface{}{
+				"error_rate_percent": errorRate * 100,
+				"threshold_percent":  m.config.ErrorThreshold * 100,
+			}))
 		}
 	}
 
@@ -228,8 +295,10 @@ func (m *MonitoringMiddleware) checkAlerts() {
 	if m.config.TrackThroughput && m.stats.FilesProcessed > 0 {
 		avgThroughput := m.stats.BytesProcessed / m.stats.FilesProcessed
 		if avgThroughput < m.config.ThroughputThreshold {
-			fmt.Printf("⚠️  Low throughput alert: %d bytes/file (threshold: %d bytes/file)\n",
-				avgThroughput, m.config.ThroughputThreshold)
+			m.config.Logger.Warn("low throughput alert", m.labeled(map[string]interface{}{
+				"bytes_per_file": avgThroughput,
+				"threshold":      m.config.ThroughputThreshold,
+			}))
 		}
 	}
 }
@@ -239,37 +308,83 @@ func (m *MonitoringMiddleware) startMetricsLogging() {
 	ticker := time.NewTicker(m.config.MetricsInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		m.logMetrics()
+	for {
+		select {
+		case <-ticker.C:
+			m.logMetrics()
+		case <-m.stop:
+			return
+		}
 	}
 }
 
+// Stop stops metrics logging, logging the metrics collected since the last interval
+func (m *MonitoringMiddleware) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stop)
+		if m.config.MetricsInterval > 0 {
+			m.logMetrics()
+		}
+	})
+}
+
 // logMetrics logs current metrics
 func (m *MonitoringMiddleware) logMetrics() {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	fmt.Printf("📊 Storage Performance Metrics:\n")
-	fmt.Printf("  Operations: %d total, %d successful, %d failed\n",
-		m.stats.TotalOperations, m.stats.SuccessfulOps, m.stats.FailedOps)
+	fields := m.labeled(map[string]interface{}{
+		"total_operations": m.stats.TotalOperations,
+		"successful_ops":   m.stats.SuccessfulOps,
+		"failed_ops":       m.stats.FailedOps,
+	})
 
 	if m.config.TrackLatency {
-		fmt.Printf("  Latency: avg=%.2fms, min=%.2fms, max=%.2fms\n",
-			float64(m.stats.AvgLatency.Nanoseconds())/1e6,
-			float64(m.stats.MinLatency.Nanoseconds())/1e6,
-			float64(m.stats.MaxLatency.Nanoseconds())/1e6)
+		fields["avg_latency_ms"] = float64(m.stats.AvgLatency.Nanoseconds()) / 1e6
+		fields["min_latency_ms"] = float64(m.stats.MinLatency.Nanoseconds()) / 1e6
+		fields["max_latency_ms"] = float64(m.stats.MaxLatency.Nanoseconds()) / 1e6
+		recent := m.latency.snapshot(time.Now())
+		fields["p50_latency_ms"] = float64(recent.Percentile(0.5).Nanoseconds()) / 1e6
+		fields["p90_latency_ms"] = float64(recent.Percentile(0.9).Nanoseconds()) / 1e6
+		fields["p99_latency_ms"] = float64(recent.Percentile(0.99).Nanoseconds()) / 1e6
 	}
 
 	if m.config.TrackThroughput {
-		fmt.Printf("  Throughput: %d files, %.2f MB total\n",
-			m.stats.FilesProcessed, float64(m.stats.BytesProcessed)/(1024*1024))
+		fields["files_processed"] = m.stats.FilesProcessed
+		fields["bytes_processed"] = m.stats.BytesProcessed
 	}
 
-	// Log operation-specific stats
+	// Operation-specific stats
 	for op, stats := range m.stats.OperationStats {
-		fmt.Printf("  %s: %d ops, %.2f%% success rate\n",
-			op, stats.Count, float64(stats.SuccessCount)/float64(stats.Count)*100)
+		fields[op+"_ops"] = stats.Count
+		fields[op+"_success_percent"] = float64(stats.SuccessCount) / float64(stats.Count) * 100
+		if latencies := m.operationLatency[op]; latencies != nil {
+			fields[op+"_p99_latency_ms"] = float64(latencies.snapshot(time.Now()).Percentile(0.99).Nanoseconds()) / 1e6
+		}
 	}
+
+	m.config.Logger.Info("storage performance metrics", fields)
+}
+
+// operationSnapshot returns a copy of the stats of an operation with the percentiles of its recent
+// latencies, called with the mutex held
+func (m *MonitoringMiddleware) operationSnapshot(operation string, now time.Time) OperationStats {
+	stats := *m.stats.OperationStats[operation]
+	if latencies := m.operationLatency[operation]; latencies != nil {
+		recent := latencies.snapshot(now)
+		stats.P50Latency = recent.Percentile(0.5)
+		stats.P90Latency = recent.Percentile(0.9)
+		stats.P99Latency = recent.Percentile(0.99)
+	}
+	return stats
+}
+
+// labeled adds the labels of the stats to log fields
+func (m *MonitoringMiddleware) labeled(fields map[string]interface{}) map[string]interface{} {
+	for name, value := range m.config.Labels {
+		fields[name] = value
+	}
+	return fields
 }
 
 // GetStats returns current monitoring statistics
@@ -277,21 +392,81 @@ func (m *MonitoringMiddleware) GetStats() map[string]interface{} {
 	m.mutex.RLock()
 	defer m.mutex.RUnlock()
 
-	return map[string]interface{}{
+	// Avoid NaN before the first operation, it cannot be encoded as JSON
+	successRate := 0.0
+	if m.stats.TotalOperations > 0 {
+		successRate = float64(m.stats.SuccessfulOps) / float64(m.stats.TotalOperations)
+	}
+
+	// Copy the maps so callers can read them without holding the lock
+	errorCounts := make(map[string]int64, len(m.stats.ErrorCounts))
+	for code, count := range m.stats.ErrorCounts {
+		errorCounts[code] = count
+	}
+	now := time.Now()
+	operationStats := make(map[string]OperationStats, len(m.stats.OperationStats))
+	for operation := range m.stats.OperationStats {
+		operationStats[operation] = m.operationSnapshot(operation, now)
+	}
+	recent := m.latency.snapshot(now)
+
+	labels := make(map[string]string, len(m.config.Labels))
+	for name, value := range m.config.Labels {
+		labels[name] = value
+	}
+
+	stats := map[string]interface{}{
 		"enabled":          m.config.Enabled,
+		"labels":           labels,
 		"total_operations": m.stats.TotalOperations,
 		"successful_ops":   m.stats.SuccessfulOps,
 		"failed_ops":       m.stats.FailedOps,
-		"success_rate":     float64(m.stats.SuccessfulOps) / float64(m.stats.TotalOperations),
+		"success_rate":     successRate,
 		"avg_latency_ms":   float64(m.stats.AvgLatency.Nanoseconds()) / 1e6,
 		"min_latency_ms":   float64(m.stats.MinLatency.Nanoseconds()) / 1e6,
 		"max_latency_ms":   float64(m.stats.MaxLatency.Nanoseconds()) / 1e6,
+		"p50_latency_ms":   float64(recent.Percentile(0.5).Nanoseconds()) / 1e6,
+		"p90_latency_ms":   float64(recent.Percentile(0.9).Nanoseconds()) / 1e6,
+		"p99_latency_ms":   float64(recent.Percentile(0.99).Nanoseconds()) / 1e6,
+		"latency_window":   m.config.LatencyWindow.String(),
 		"bytes_processed":  m.stats.BytesProcessed,
 		"files_processed":  m.stats.FilesProcessed,
-		"error_counts":     m.stats.ErrorCounts,
-		"operation_stats":  m.stats.OperationStats,
+		"error_counts":     errorCounts,
+		"operation_stats":  operationStats,
 		"uptime_seconds":   time.Since(m.stats.StartTime).Seconds(),
 	}
+	if m.config.DownloadCounter != nil {
+		stats["downloads"] = m.config.DownloadCounter.GetStats()
+	}
+	return stats
+}
+
+// Series returns the operation stats with their labels
+func (m *MonitoringMiddleware) Series() MonitoringSeries {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	series := MonitoringSeries{
+		Labels:      make(map[string]string, len(m.config.Labels)),
+		Operations:  make(map[string]OperationStats, len(m.stats.OperationStats)),
+		ErrorCounts: make(map[string]int64, len(m.stats.ErrorCounts)),
+		Since:       m.stats.LastReset,
+		Latencies:   make(map[string]*LatencyHistogram, len(m.operationLatency)),
+	}
+	for name, value := range m.config.Labels {
+		series.Labels[name] = value
+	}
+	now := time.Now()
+	for operation := range m.stats.OperationStats {
+		series.Operations[operation] = m.operationSnapshot(operation, now)
+	}
+	for operation, latencies := range m.operationLatency {
+		series.Latencies[operation] = latencies.snapshot(now)
+	}
+	for code, count := range m.stats.ErrorCounts {
+		series.ErrorCounts[code] = count
+	}
+	return series
 }
 
 // ResetStats resets all monitoring statistics
@@ -305,6 +480,8 @@ func (m *MonitoringMiddleware) ResetStats() {
 		StartTime:      time.Now(),
 		LastReset:      time.Now(),
 	}
+	m.latency = newLatencyWindow(m.config.LatencyWindow)
+	m.operationLatency = make(map[string]*latencyWindow)
 }
 
 // DefaultMonitoringConfig returns a default monitoring configuration
@@ -321,5 +498,6 @@ func DefaultMonitoringConfig() MonitoringConfig {
 		LatencyThreshold:    5 * time.Second, // Alert if latency > 5s
 		ErrorThreshold:      0.1,             // Alert if error rate > 10%
 		ThroughputThreshold: 1024,            // Alert if avg file size < 1KB
+		LatencyWindow:       5 * time.Minute, // Percentiles of the last 5 minutes
 	}
 }
@@ -0,0 +1,167 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"math"
+	"strings"
+)
+
+// BlurHashMetadataKey is the object metadata key holding the BlurHash placeholder of an image
+const BlurHashMetadataKey = "blurhash"
+
+// BlurHash components, 4x3 suits most landscape and portrait images
+const (
+	blurHashXComponents = 4
+	blurHashYComponents = 3
+)
+
+// blurHashSampleSize is the longest side an image is sampled down to before encoding
+// Placeholders are blurry anyway, so this keeps encoding cheap without visible loss
+const blurHashSampleSize = 32
+
+// base83 is the alphabet of BlurHash strings
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash returns a compact BlurHash string (https://blurha.sh) frontends can render as a placeholder
+// while the image loads. Components are the number of horizontal and vertical frequencies, 1 to 9
+func BlurHash(img image.Image, xComponents, yComponents int) (string, error) {
+	if xComponents < 1 || xComponents > 9 || yComponents < 1 || yComponents > 9 {
+		return "", fmt.Errorf("blurhash components must be between 1 and 9")
+	}
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return "", fmt.Errorf("empty image")
+	}
+
+	// Linear RGB samples of the image
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > height && width > blurHashSampleSize {
+		width, height = blurHashSampleSize, max(height*blurHashSampleSize/width, 1)
+	} else if height > blurHashSampleSize {
+		width, height = max(width*blurHashSampleSize/height, 1), blurHashSampleSize
+	}
+	samples := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		sourceY := bounds.Min.Y + y*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			sourceX := bounds.Min.X + x*bounds.Dx()/width
+			r, g, b, _ := img.At(sourceX, sourceY).RGBA()
+			samples[y*width+x] = [3]float64{srgbToLinear(r >> 8), srgbToLinear(g >> 8), srgbToLinear(b >> 8)}
+		}
+	}
+
+	// Cosine transform, the first factor is the average color
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i*x)/float64(width)) * math.Cos(math.Pi*float64(j*y)/float64(height))
+					sample := samples[y*width+x]
+					factor[0] += basis * sample[0]
+					factor[1] += basis * sample[1]
+					factor[2] += basis * sample[2]
+				}
+			}
+			scale := 1 / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	maximum := 1.0
+	if len(factors) > 1 {
+		var actual float64
+		for _, factor := range factors[1:] {
+			actual = math.Max(actual, math.Max(math.Abs(factor[0]), math.Max(math.Abs(factor[1]), math.Abs(factor[2]))))
+		}
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		hash.WriteString(encode83(quantised, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+
+	dc := factors[0]
+	hash.WriteString(encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, factor := range factors[1:] {
+		quantise := func(value float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(value/maximum, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encode83(quantise(factor[0])*19*19+quantise(factor[1])*19+quantise(factor[2]), 2))
+	}
+	return hash.String(), nil
+}
+
+// encode83 encodes a value as length base83 digits
+func encode83(value, length int) string {
+	digits := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		digits[i] = base83[value%83]
+		value /= 83
+	}
+	return string(digits)
+}
+
+// srgbToLinear converts an 8 bit sRGB channel to linear light
+func srgbToLinear(value uint32) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB converts linear light to an 8 bit sRGB channel
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(math.Round(v * 12.92 * 255))
+	}
+	return int(math.Round((1.055*math.Pow(v, 1/2.4) - 0.055) * 255))
+}
+
+// signPow raises the magnitude of a value to exp, keeping its sign
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+// placeholder computes the BlurHash of an uploaded image without consuming its data, see BufferData
+func (m *ThumbnailMiddleware) placeholder(req *StorageRequest) (string, error) {
+	source, err := req.BufferData()
+	if err != nil {
+		return "", err
+	}
+	defer source.Rewind()
+
+	if m.config.MaxSourceSize > 0 && source.Size() > m.config.MaxSourceSize {
+		return "", ErrThumbnailSourceTooLarge
+	}
+
+	// Check the limits before decoding the full image
+	var header bytes.Buffer
+	imageConfig, _, err := image.DecodeConfig(io.TeeReader(source, &header))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image header: %w", err)
+	}
+	if (m.config.MaxSourceWidth > 0 && imageConfig.Width > m.config.MaxSourceWidth) ||
+		(m.config.MaxSourceHeight > 0 && imageConfig.Height > m.config.MaxSourceHeight) {
+		return "", ErrThumbnailSourceTooLarge
+	}
+
+	img, _, err := image.Decode(io.MultiReader(&header, source))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	return BlurHash(img, blurHashXComponents, blurHashYComponents)
+}
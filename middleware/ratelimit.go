@@ -0,0 +1,239 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+// RateLimitMiddleware applies token-bucket limits per user and operation
+type RateLimitMiddleware struct {
+	config   RateLimitConfig
+	category string
+	stats    rateLimitStats
+	mutex    sync.Mutex
+}
+
+// RateLimitConfig represents rate limit middleware configuration
+type RateLimitConfig struct {
+	// Limits per operation (upload, download, delete, preview); "*" applies to operations without their own entry
+	Limits map[string]RateLimit `json:"limits,omitempty"`
+	// Requests without a UserID share this bucket key
+	AnonymousKey string `json:"anonymous_key,omitempty"`
+	// PerAddress limits requests without a UserID per client address (IPAddress) instead
+	PerAddress bool `json:"per_address,omitempty"`
+	// AnonymousOnly leaves requests with a UserID unlimited
+	AnonymousOnly bool `json:"anonymous_only,omitempty"`
+
+	// Limiter stores the buckets, defaults to an in-memory limiter
+	// Use NewRedisRateLimiter to share limits between instances
+	Limiter RateLimiter `json:"-"`
+}
+
+// RateLimit represents the limits of a single operation
+type RateLimit struct {
+	RequestsPerMinute int   `json:"requests_per_minute,omitempty"` // 0 means unlimited
+	BytesPerMinute    int64 `json:"bytes_per_minute,omitempty"`    // 0 means unlimited
+	// Burst sizes default to the per-minute values
+	RequestBurst int   `json:"request_burst,omitempty"`
+	BytesBurst   int64 `json:"bytes_burst,omitempty"`
+}
+
+// RateLimiter takes tokens from named token buckets
+type RateLimiter interface {
+	// Take removes cost tokens from the bucket refilled at rate tokens per second up to burst
+	// When not enough tokens are available nothing is taken and the wait until they are is returned
+	Take(ctx context.Context, key string, rate, burst, cost float64) (bool, time.Duration, error)
+}
+
+// rateLimitStats counts limiter decisions
+type rateLimitStats struct {
+	allowed int64
+	limited int64
+}
+
+// DefaultRateLimitConfig returns default rate limit configuration
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Limits: map[string]RateLimit{
+			"upload": {
+				RequestsPerMinute: 60,
+				BytesPerMinute:    500 * 1024 * 1024, // 500MB
+			},
+		},
+		AnonymousKey: "anonymous",
+	}
+}
+
+// NewRateLimitMiddleware creates a new rate limit middleware for a category
+func NewRateLimitMiddleware(config RateLimitConfig, category string) *RateLimitMiddleware {
+	if config.AnonymousKey == "" {
+		config.AnonymousKey = "anonymous"
+	}
+	if config.Limiter == nil {
+		config.Limiter = NewMemoryRateLimiter()
+	}
+
+	return &RateLimitMiddleware{
+		config:   config,
+		category: category,
+	}
+}
+
+// Name returns the middleware name
+func (m *RateLimitMiddleware) Name() string {
+	return "ratelimit"
+}
+
+// Process processes the request through rate limit middleware
+func (m *RateLimitMiddleware) Process(ctx context.Context, req *StorageRequest, next MiddlewareFunc) (*StorageResponse, error) {
+	limit, exists := m.config.Limits[req.Operation]
+	if !exists {
+		limit, exists = m.config.Limits["*"]
+	}
+	if !exists {
+		return next(ctx, req)
+	}
+
+	userID := req.UserID
+	switch {
+	case userID != "" && m.config.AnonymousOnly:
+		return next(ctx, req)
+	case userID == "" && m.config.PerAddress && req.IPAddress != "":
+		userID = "ip:" + req.IPAddress
+	case userID == "":
+		userID = m.config.AnonymousKey
+	}
+	key := fmt.Sprintf("%s:%s:%s", m.category, req.Operation, userID)
+
+	if limit.RequestsPerMinute > 0 {
+		burst := limit.RequestBurst
+		if burst <= 0 {
+			burst = limit.RequestsPerMinute
+		}
+		if resp, err := m.take(ctx, key+":requests", float64(limit.RequestsPerMinute), float64(burst), 1); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+
+	if limit.BytesPerMinute > 0 && req.FileSize > 0 {
+		burst := limit.BytesBurst
+		if burst <= 0 {
+			burst = limit.BytesPerMinute
+		}
+		// A file larger than the burst would never fit, it takes the whole bucket instead
+		cost := math.Min(float64(req.FileSize), float64(burst))
+		if resp, err := m.take(ctx, key+":bytes", float64(limit.BytesPerMinute), float64(burst), cost); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+
+	return next(ctx, req)
+}
+
+// take takes tokens from a bucket and returns a failed response when the request is limited
+func (m *RateLimitMiddleware) take(ctx context.Context, key string, perMinute, burst, cost float64) (*StorageResponse, error) {
+	allowed, retryAfter, err := m.config.Limiter.Take(ctx, key, perMinute/60, burst, cost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+
+	m.mutex.Lock()
+	if allowed {
+		m.stats.allowed++
+	} else {
+		m.stats.limited++
+	}
+	m.mutex.Unlock()
+
+	if allowed {
+		return nil, nil
+	}
+
+	return &StorageResponse{
+		Success: false,
+		Error: &errors.StorageError{
+			Code:    errors.ErrRateLimited.Code,
+			Message: errors.ErrRateLimited.Message,
+			Details: fmt.Sprintf("retry after %s", retryAfter.Round(time.Second)),
+		},
+		Metadata: map[string]interface{}{
+			"retry_after_seconds": math.Ceil(retryAfter.Seconds()),
+		},
+	}, nil
+}
+
+// GetStats returns rate limit statistics
+func (m *RateLimitMiddleware) GetStats() map[string]interface{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return map[string]interface{}{
+		"allowed": m.stats.allowed,
+		"limited": m.stats.limited,
+	}
+}
+
+// MemoryRateLimiter keeps token buckets in process memory
+type MemoryRateLimiter struct {
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+	mutex       sync.Mutex
+}
+
+// tokenBucket is the state of a single bucket
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewMemoryRateLimiter creates a new in-memory rate limiter
+func NewMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{
+		buckets:     make(map[string]*tokenBucket),
+		lastCleanup: time.Now(),
+	}
+}
+
+// Take removes cost tokens from the bucket
+func (l *MemoryRateLimiter) Take(ctx context.Context, key string, rate, burst, cost float64) (bool, time.Duration, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	bucket, exists := l.buckets[key]
+	if !exists {
+		bucket = &tokenBucket{tokens: burst, updated: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*rate)
+	bucket.updated = now
+
+	if bucket.tokens < cost {
+		wait := time.Duration((cost - bucket.tokens) / rate * float64(time.Second))
+		return false, wait, nil
+	}
+	bucket.tokens -= cost
+
+	// Drop idle buckets now and then so they do not accumulate
+	if now.Sub(l.lastCleanup) > time.Minute {
+		l.cleanup(now)
+	}
+
+	return true, 0, nil
+}
+
+// cleanup removes buckets idle for over an hour, which are full again for any sensible rate
+func (l *MemoryRateLimiter) cleanup(now time.Time) {
+	for key, bucket := range l.buckets {
+		if now.Sub(bucket.updated) > time.Hour {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastCleanup = now
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// takeTokensScript implements a token bucket stored in a Redis hash, using the server clock
+// KEYS: bucket; ARGV: rate per second, burst, cost
+// Returns {allowed, wait in milliseconds}
+var takeTokensScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+
+tokens = math.min(burst, tokens + math.max(0, now - updated) / 1000 * rate)
+
+local allowed = 0
+local wait = 0
+if tokens >= cost then
+	tokens = tokens - cost
+	allowed = 1
+else
+	wait = math.ceil((cost - tokens) / rate * 1000)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, wait}
+`)
+
+// RedisRateLimiter keeps token buckets in Redis so limits are shared between instances
+type RedisRateLimiter struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewRedisRateLimiter creates a new Redis rate limiter, prefix defaults to "storage:ratelimit"
+// The client is owned by the caller and is not closed by the limiter
+func NewRedisRateLimiter(client redis.UniversalClient, prefix string) *RedisRateLimiter {
+	if prefix == "" {
+		prefix = "storage:ratelimit"
+	}
+	return &RedisRateLimiter{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Take removes cost tokens from the bucket
+func (l *RedisRateLimiter) Take(ctx context.Context, key string, rate, burst, cost float64) (bool, time.Duration, error) {
+	result, err := takeTokensScript.Run(ctx, l.client, []string{l.prefix + ":" + key}, rate, burst, cost).Int64Slice()
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to take rate limit tokens: %w", err)
+	}
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
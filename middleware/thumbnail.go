@@ -15,33 +15,63 @@ import (
 
 	_ "image/gif"
 
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/logger"
 	"github.com/minio/minio-go/v7"
 )
 
+// ThumbnailStatusTooLarge marks files skipped because the source exceeds the thumbnail limits
+const ThumbnailStatusTooLarge = "too_large"
+
+var ErrThumbnailSourceTooLarge = &errors.StorageError{Code: "THUMBNAIL_SOURCE_TOO_LARGE", Message: "Thumbnail source image is too large"}
+
+// thumbnailLimits bounds the source images thumbnails are generated from
+type thumbnailLimits struct {
+	maxSize   int64
+	maxWidth  int
+	maxHeight int
+}
+
 // ThumbnailMiddleware handles thumbnail generation
 type ThumbnailMiddleware struct {
 	config         ThumbnailConfig
 	client         *minio.Client
 	asyncProcessor *AsyncProcessor
+	ownsProcessor  bool // Whether the async processor was created by this middleware
 }
 
 // ThumbnailConfig represents thumbnail middleware configuration
 type ThumbnailConfig struct {
 	// Thumbnail settings
 	GenerateThumbnails bool     `json:"generate_thumbnails,omitempty"`
-	ThumbnailSizes     []string `json:"thumbnail_sizes,omitempty"` // ["150x150", "300x300", "600x600"]
+	ThumbnailSizes     []string `json:"thumbnail_sizes,omitempty"` // ["150x150", "300x300:crop", "600x600:smart"], see ThumbnailFit
+
+	// Compute a BlurHash placeholder at upload, stored with the object under BlurHashMetadataKey
+	Placeholder bool `json:"placeholder,omitempty"`
 
 	// Quality settings
 	JPEGQuality int `json:"jpeg_quality,omitempty"` // 1-100, default 85
 	PNGQuality  int `json:"png_quality,omitempty"`  // 1-100, default 100
 
+	// Source limits, larger images are skipped and marked as too large (0 disables a check)
+	MaxSourceSize   int64 `json:"max_source_size,omitempty"`   // Maximum source file size in bytes, default 50MB
+	MaxSourceWidth  int   `json:"max_source_width,omitempty"`  // Maximum decoded width in pixels, default 10000
+	MaxSourceHeight int   `json:"max_source_height,omitempty"` // Maximum decoded height in pixels, default 10000
+
 	// Storage settings
-	ThumbnailBucket string `json:"thumbnail_bucket,omitempty"`
+	SourceBucket    string `json:"source_bucket,omitempty"`    // Bucket holding originals, defaults to ThumbnailBucket
+	ThumbnailBucket string `json:"thumbnail_bucket,omitempty"` // Bucket thumbnails are written to
 	ThumbnailPrefix string `json:"thumbnail_prefix,omitempty"`
+	StorageClass    string `json:"storage_class,omitempty"` // Storage class for thumbnails, server default when empty
 
 	// Async processing settings
 	AsyncProcessing bool        `json:"async_processing,omitempty"` // Enable async thumbnail generation
 	AsyncConfig     AsyncConfig `json:"async_config,omitempty"`     // Async processor configuration
+
+	// Shared processor to submit jobs to; a dedicated one is created from AsyncConfig when nil
+	AsyncProcessor *AsyncProcessor `json:"-"`
+
+	Logger logger.Logger `json:"-"` // Defaults to logger.Default()
 }
 
 // NewThumbnailMiddleware creates a new thumbnail middleware
@@ -56,14 +86,30 @@ func NewThumbnailMiddleware(config ThumbnailConfig, client *minio.Client) *Thumb
 	if config.ThumbnailPrefix == "" {
 		config.ThumbnailPrefix = "thumbnails"
 	}
+	if config.SourceBucket == "" {
+		config.SourceBucket = config.ThumbnailBucket
+	}
+	config.Logger = logger.OrDefault(config.Logger)
+	if config.MaxSourceSize == 0 {
+		config.MaxSourceSize = 50 * 1024 * 1024
+	}
+	if config.MaxSourceWidth == 0 {
+		config.MaxSourceWidth = 10000
+	}
+	if config.MaxSourceHeight == 0 {
+		config.MaxSourceHeight = 10000
+	}
 
 	// Initialize async processor if async processing is enabled
-	var asyncProcessor *AsyncProcessor
-	if config.AsyncProcessing {
+	asyncProcessor := config.AsyncProcessor
+	if config.AsyncProcessing && asyncProcessor == nil {
 		asyncConfig := config.AsyncConfig
 		if asyncConfig.Workers == 0 {
 			asyncConfig = DefaultAsyncConfig()
 		}
+		if asyncConfig.Logger == nil {
+			asyncConfig.Logger = config.Logger
+		}
 		asyncProcessor = NewAsyncProcessor(asyncConfig, client, config.ThumbnailBucket)
 	}
 
@@ -71,6 +117,7 @@ func NewThumbnailMiddleware(config ThumbnailConfig, client *minio.Client) *Thumb
 		config:         config,
 		client:         client,
 		asyncProcessor: asyncProcessor,
+		ownsProcessor:  config.AsyncProcessor == nil,
 	}
 }
 
@@ -87,15 +134,33 @@ func (m *ThumbnailMiddleware) Process(ctx context.Context, req *StorageRequest,
 	}
 
 	// Check if thumbnail generation is enabled
-	if !m.config.GenerateThumbnails {
+	if !m.config.GenerateThumbnails || req.DisableThumbnails {
 		return next(ctx, req)
 	}
 
 	// Check if the file type supports thumbnail generation
-	if !m.supportsThumbnail(req.ContentType) {
+	if !SupportsThumbnail(req.ContentType) {
 		return next(ctx, req)
 	}
 
+	// Compute the placeholder before the upload, so it is stored with the object
+	if m.config.Placeholder {
+		hash, err := m.placeholder(req)
+		switch {
+		case err == ErrThumbnailSourceTooLarge:
+		case err != nil:
+			m.config.Logger.Warn("failed to compute placeholder", map[string]interface{}{
+				"file_key": req.FileKey,
+				"error":    err,
+			})
+		default:
+			if req.ObjectMetadata == nil {
+				req.ObjectMetadata = make(map[string]string)
+			}
+			req.ObjectMetadata[BlurHashMetadataKey] = hash
+		}
+	}
+
 	// Process with next middleware first
 	response, err := next(ctx, req)
 	if err != nil {
@@ -107,16 +172,22 @@ func (m *ThumbnailMiddleware) Process(ctx context.Context, req *StorageRequest,
 		response.FileKey = req.FileKey
 	}
 
+	// Skip sources that are known to be too large before doing any work
+	if response.Success && m.config.MaxSourceSize > 0 && req.FileSize > m.config.MaxSourceSize {
+		m.markTooLarge(response)
+		return response, nil
+	}
+
 	// Generate thumbnails after successful upload
 	if response.Success && response.FileKey != "" {
 		// Generate "fake" thumbnail info immediately with predictable keys
 		// This allows users to construct thumbnail URLs even before async processing completes
 		var thumbnails []ThumbnailInfo
 		for _, size := range m.config.ThumbnailSizes {
-			thumbnailKey := m.generateThumbnailKey(response.FileKey, size)
+			thumbnailKey := ThumbnailKey(response.FileKey, size)
 
 			// Parse size to get width and height
-			width, height, _ := parseThumbnailSize(size)
+			width, height, _, _ := parseThumbnailSize(size)
 
 			thumbnails = append(thumbnails, ThumbnailInfo{
 				Size:     size,
@@ -136,30 +207,37 @@ func (m *ThumbnailMiddleware) Process(ctx context.Context, req *StorageRequest,
 				FileSize:    req.FileSize,
 				ContentType: req.ContentType,
 				Sizes:       m.config.ThumbnailSizes,
-				BucketName:  m.config.ThumbnailBucket,
+				BucketName:  m.sourceBucket(req),
 				Metadata:    req.Metadata,
-			}
 
-			// Set callback to update response when thumbnails are ready
-			job.Callback = func(thumbResponse *ThumbnailResponse) {
-				if thumbResponse.Success {
-					// Update the existing thumbnails with actual file sizes
-					for i, newThumb := range thumbResponse.Thumbnails {
-						if i < len(response.Thumbnails) {
-							response.Thumbnails[i].FileSize = newThumb.FileSize
-						}
-					}
-				}
+				DerivedBucket: m.config.ThumbnailBucket,
+				StorageClass:  m.config.StorageClass,
+
+				MaxSourceSize:   m.config.MaxSourceSize,
+				MaxSourceWidth:  m.config.MaxSourceWidth,
+				MaxSourceHeight: m.config.MaxSourceHeight,
 			}
 
-			if err := m.asyncProcessor.SubmitJob(job); err != nil {
+			// The response is returned before the job runs; progress is available
+			// through ThumbnailStatus and Subscribe on the async processor
+			if err := m.asyncProcessor.SubmitJob(ctx, job); err != nil {
 				// Log error but don't fail the upload
+				m.config.Logger.Error("failed to submit thumbnail job", map[string]interface{}{
+					"file_key": response.FileKey,
+					"error":    err,
+				})
 			}
 		} else {
 			// Synchronous thumbnail generation
 			thumbnails, err := m.generateThumbnails(ctx, req, response.FileKey)
-			if err != nil {
+			if err == ErrThumbnailSourceTooLarge {
+				m.markTooLarge(response)
+			} else if err != nil {
 				// Log error but don't fail the upload
+				m.config.Logger.Error("thumbnail generation failed", map[string]interface{}{
+					"file_key": response.FileKey,
+					"error":    err,
+				})
 			} else {
 				response.Thumbnails = thumbnails
 			}
@@ -169,9 +247,18 @@ func (m *ThumbnailMiddleware) Process(ctx context.Context, req *StorageRequest,
 	return response, nil
 }
 
-// Stop stops the async processor
+// markTooLarge drops the thumbnail info and records why no thumbnails were generated
+func (m *ThumbnailMiddleware) markTooLarge(response *StorageResponse) {
+	response.Thumbnails = nil
+	if response.Metadata == nil {
+		response.Metadata = make(map[string]interface{})
+	}
+	response.Metadata["thumbnail_status"] = ThumbnailStatusTooLarge
+}
+
+// Stop stops the async processor if it is owned by this middleware
 func (m *ThumbnailMiddleware) Stop() {
-	if m.asyncProcessor != nil {
+	if m.asyncProcessor != nil && m.ownsProcessor {
 		m.asyncProcessor.Stop()
 	}
 }
@@ -194,12 +281,22 @@ func (m *ThumbnailMiddleware) generateThumbnails(ctx context.Context, req *Stora
 	var thumbnails []ThumbnailInfo
 
 	// Get the original file from storage
-	originalData, err := m.getOriginalFile(ctx, fileKey)
+	originalData, err := m.getOriginalFile(ctx, m.sourceBucket(req), fileKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get original file: %w", err)
 	}
 	defer originalData.Close()
 
+	// Check the limits before decoding the full image
+	limits := thumbnailLimits{
+		maxSize:   m.config.MaxSourceSize,
+		maxWidth:  m.config.MaxSourceWidth,
+		maxHeight: m.config.MaxSourceHeight,
+	}
+	if err := checkThumbnailSource(originalData, limits); err != nil {
+		return nil, err
+	}
+
 	// Decode the original image
 	originalImg, format, err := image.Decode(originalData)
 	if err != nil {
@@ -208,24 +305,35 @@ func (m *ThumbnailMiddleware) generateThumbnails(ctx context.Context, req *Stora
 
 	// Generate thumbnails for each configured size
 	for _, sizeStr := range m.config.ThumbnailSizes {
-		width, height, err := parseThumbnailSize(sizeStr)
+		width, height, mode, err := parseThumbnailSize(sizeStr)
 		if err != nil {
-			fmt.Printf("Invalid thumbnail size %s: %v\n", sizeStr, err)
+			m.config.Logger.Warn("invalid thumbnail size", map[string]interface{}{
+				"size":  sizeStr,
+				"error": err,
+			})
 			continue
 		}
 
 		// Generate thumbnail
-		thumbnailData, err := m.createThumbnail(originalImg, width, height, format)
+		thumbnailData, err := m.createThumbnail(originalImg, width, height, mode, format)
 		if err != nil {
-			fmt.Printf("Failed to create thumbnail %s: %v\n", sizeStr, err)
+			m.config.Logger.Warn("failed to create thumbnail", map[string]interface{}{
+				"file_key": fileKey,
+				"size":     sizeStr,
+				"error":    err,
+			})
 			continue
 		}
 
 		// Upload thumbnail to storage
-		thumbnailKey := m.generateThumbnailKey(fileKey, sizeStr)
+		thumbnailKey := ThumbnailKey(fileKey, sizeStr)
 		thumbnailURL, err := m.uploadThumbnail(ctx, thumbnailKey, thumbnailData, format)
 		if err != nil {
-			fmt.Printf("Failed to upload thumbnail %s: %v\n", sizeStr, err)
+			m.config.Logger.Warn("failed to upload thumbnail", map[string]interface{}{
+				"file_key": fileKey,
+				"size":     sizeStr,
+				"error":    err,
+			})
 			continue
 		}
 
@@ -242,10 +350,18 @@ func (m *ThumbnailMiddleware) generateThumbnails(ctx context.Context, req *Stora
 	return thumbnails, nil
 }
 
+// sourceBucket returns the bucket holding the original file of a request
+func (m *ThumbnailMiddleware) sourceBucket(req *StorageRequest) string {
+	if req.BucketName != "" {
+		return req.BucketName
+	}
+	return m.config.SourceBucket
+}
+
 // getOriginalFile retrieves the original file from storage
-func (m *ThumbnailMiddleware) getOriginalFile(ctx context.Context, fileKey string) (io.ReadCloser, error) {
+func (m *ThumbnailMiddleware) getOriginalFile(ctx context.Context, bucketName, fileKey string) (*minio.Object, error) {
 	// Get the object from MinIO
-	object, err := m.client.GetObject(ctx, m.config.ThumbnailBucket, fileKey, minio.GetObjectOptions{})
+	object, err := m.client.GetObject(ctx, bucketName, fileKey, minio.GetObjectOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to get object from MinIO: %w", err)
 	}
@@ -253,10 +369,47 @@ func (m *ThumbnailMiddleware) getOriginalFile(ctx context.Context, fileKey strin
 	return object, nil
 }
 
+// checkThumbnailSource rejects sources over the limits
+// Only the image header is decoded; the object is rewound afterwards
+func checkThumbnailSource(source *minio.Object, limits thumbnailLimits) error {
+	if limits.maxSize > 0 {
+		objInfo, err := source.Stat()
+		if err != nil {
+			return fmt.Errorf("failed to get object info: %w", err)
+		}
+		if objInfo.Size > limits.maxSize {
+			return ErrThumbnailSourceTooLarge
+		}
+	}
+
+	if limits.maxWidth <= 0 && limits.maxHeight <= 0 {
+		return nil
+	}
+
+	imageConfig, _, err := image.DecodeConfig(source)
+	if err != nil {
+		return fmt.Errorf("failed to decode image header: %w", err)
+	}
+	if _, err := source.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind object: %w", err)
+	}
+
+	if (limits.maxWidth > 0 && imageConfig.Width > limits.maxWidth) ||
+		(limits.maxHeight > 0 && imageConfig.Height > limits.maxHeight) {
+		return ErrThumbnailSourceTooLarge
+	}
+	return nil
+}
+
 // createThumbnail creates a thumbnail from the original image
-func (m *ThumbnailMiddleware) createThumbnail(originalImg image.Image, width, height int, format string) ([]byte, error) {
-	// Resize the image
-	resizedImg := m.resizeImage(originalImg, width, height)
+func (m *ThumbnailMiddleware) createThumbnail(originalImg image.Image, width, height int, mode, format string) ([]byte, error) {
+	// Resize the image, cropping it to fill the size in the crop modes
+	var resizedImg image.Image
+	if mode == ThumbnailFit {
+		resizedImg = m.resizeImage(originalImg, width, height)
+	} else {
+		resizedImg = cropImage(originalImg, width, height, mode == ThumbnailSmartCrop)
+	}
 
 	// Encode the resized image
 	var buf bytes.Buffer
@@ -348,7 +501,8 @@ func (m *ThumbnailMiddleware) uploadThumbnail(ctx context.Context, key string, d
 		reader,
 		int64(len(data)),
 		minio.PutObjectOptions{
-			ContentType: contentType,
+			ContentType:  contentType,
+			StorageClass: m.config.StorageClass,
 		},
 	)
 	if err != nil {
@@ -360,11 +514,9 @@ func (m *ThumbnailMiddleware) uploadThumbnail(ctx context.Context, key string, d
 	return thumbnailURL, nil
 }
 
-// generateThumbnailKey generates a key for the thumbnail using predictable naming
-func (m *ThumbnailMiddleware) generateThumbnailKey(originalKey, size string) string {
-	// Use predictable naming pattern: original_file_key_512x512.png
-	// This makes it easy for users to construct thumbnail URLs
-
+// ThumbnailKey returns the key of a thumbnail using predictable naming: original_file_key_512x512.png
+// This makes it easy for users to construct thumbnail URLs. Modes are kept: 150x150:crop gives original_file_key_150x150-crop.png
+func ThumbnailKey(originalKey, size string) string {
 	// Get the file extension from the original key
 	ext := filepath.Ext(originalKey)
 	if ext == "" {
@@ -375,12 +527,66 @@ func (m *ThumbnailMiddleware) generateThumbnailKey(originalKey, size string) str
 	baseKey := strings.TrimSuffix(originalKey, ext)
 
 	// Create the thumbnail key with size suffix
-	thumbnailKey := fmt.Sprintf("%s_%s%s", baseKey, size, ext)
-	return thumbnailKey
+	return fmt.Sprintf("%s_%s%s", baseKey, thumbnailSizeName(size), ext)
 }
 
-// supportsThumbnail checks if the content type supports thumbnail generation
-func (m *ThumbnailMiddleware) supportsThumbnail(contentType string) bool {
+// ThumbnailKeyPrefix returns the prefix shared by the thumbnail keys of a file of all sizes
+func ThumbnailKeyPrefix(originalKey string) string {
+	return strings.TrimSuffix(originalKey, filepath.Ext(originalKey)) + "_"
+}
+
+// IsThumbnailKey reports whether a key is a thumbnail of a file, of any size
+func IsThumbnailKey(originalKey, key string) bool {
+	size := strings.TrimPrefix(key, ThumbnailKeyPrefix(originalKey))
+	if size == key {
+		return false
+	}
+	ext := filepath.Ext(originalKey)
+	if ext == "" {
+		ext = ".jpg"
+	}
+	size, found := strings.CutSuffix(size, ext)
+	return found && isThumbnailSize(size)
+}
+
+// ThumbnailOriginalKeys returns the keys the original of a thumbnail can have, none when key is no thumbnail key
+// JPEG thumbnails may belong to an original without extension, so there can be two
+func ThumbnailOriginalKeys(key string) []string {
+	ext := filepath.Ext(key)
+	base := strings.TrimSuffix(key, ext)
+	separator := strings.LastIndex(base, "_")
+	if separator <= 0 || !isThumbnailSize(base[separator+1:]) {
+		return nil
+	}
+
+	originalKeys := []string{base[:separator] + ext}
+	if ext == ".jpg" {
+		originalKeys = append(originalKeys, base[:separator])
+	}
+	return originalKeys
+}
+
+// isThumbnailSize reports whether size is a WIDTHxHEIGHT thumbnail size, optionally followed by -MODE
+func isThumbnailSize(size string) bool {
+	if dimensions, mode, found := strings.Cut(size, "-"); found {
+		if !validThumbnailMode(mode) {
+			return false
+		}
+		size = dimensions
+	}
+	width, height, found := strings.Cut(size, "x")
+	if !found {
+		return false
+	}
+	if _, err := strconv.Atoi(width); err != nil {
+		return false
+	}
+	_, err := strconv.Atoi(height)
+	return err == nil
+}
+
+// SupportsThumbnail checks if the content type supports thumbnail generation
+func SupportsThumbnail(contentType string) bool {
 	supportedTypes := []string{
 		"image/jpeg", "image/jpg", "image/png", "image/gif", "image/webp", "image/bmp",
 	}
@@ -393,34 +599,41 @@ func (m *ThumbnailMiddleware) supportsThumbnail(contentType string) bool {
 	return false
 }
 
-// parseThumbnailSize parses a thumbnail size string (e.g., "150x150")
-func parseThumbnailSize(size string) (width, height int, err error) {
-	parts := strings.Split(size, "x")
+// parseThumbnailSize parses a thumbnail size string (e.g., "150x150" or "150x150:crop")
+func parseThumbnailSize(size string) (width, height int, mode string, err error) {
+	dimensions, mode, found := strings.Cut(size, ":")
+	if !found {
+		mode = ThumbnailFit
+	} else if !validThumbnailMode(mode) {
+		return 0, 0, "", fmt.Errorf("invalid thumbnail mode: %s", mode)
+	}
+
+	parts := strings.Split(dimensions, "x")
 	if len(parts) != 2 {
-		return 0, 0, fmt.Errorf("invalid size format: %s", size)
+		return 0, 0, "", fmt.Errorf("invalid size format: %s", size)
 	}
 
 	width, err = strconv.Atoi(parts[0])
 	if err != nil {
-		return 0, 0, fmt.Errorf("invalid width: %s", parts[0])
+		return 0, 0, "", fmt.Errorf("invalid width: %s", parts[0])
 	}
 
 	height, err = strconv.Atoi(parts[1])
 	if err != nil {
-		return 0, 0, fmt.Errorf("invalid height: %s", parts[1])
+		return 0, 0, "", fmt.Errorf("invalid height: %s", parts[1])
 	}
 
 	if width <= 0 || height <= 0 {
-		return 0, 0, fmt.Errorf("dimensions must be positive: %s", size)
+		return 0, 0, "", fmt.Errorf("dimensions must be positive: %s", size)
 	}
 
-	return width, height, nil
+	return width, height, mode, nil
 }
 
 // GetThumbnailURL generates a thumbnail URL for a file
 func (m *ThumbnailMiddleware) GetThumbnailURL(ctx context.Context, fileKey, size string) (string, error) {
 	// Generate thumbnail key
-	thumbnailKey := m.generateThumbnailKey(fileKey, size)
+	thumbnailKey := ThumbnailKey(fileKey, size)
 
 	// Check if thumbnail exists
 	exists, err := m.thumbnailExists(ctx, thumbnailKey)
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Thumbnail modes, appended to a size as "150x150:crop"
+const (
+	ThumbnailFit       = "fit"   // Scale to fit within the size, keeping the aspect ratio (default)
+	ThumbnailCrop      = "crop"  // Fill the size exactly, cropping around the center
+	ThumbnailSmartCrop = "smart" // Fill the size exactly, cropping around the most detailed region
+)
+
+// entropyGridSize is the longest side of the luminance grid smart cropping analyzes
+const entropyGridSize = 64
+
+// entropyBins is the number of luminance levels of the entropy histogram
+const entropyBins = 32
+
+// validThumbnailMode reports whether mode is a known thumbnail mode
+func validThumbnailMode(mode string) bool {
+	switch mode {
+	case ThumbnailFit, ThumbnailCrop, ThumbnailSmartCrop:
+		return true
+	}
+	return false
+}
+
+// thumbnailSizeName returns the form of a size used in thumbnail keys, "150x150:crop" becomes "150x150-crop"
+func thumbnailSizeName(size string) string {
+	return strings.Replace(size, ":", "-", 1)
+}
+
+// cropImage crops the largest region of the target aspect ratio and scales it to exactly width x height
+// The region is centered, or placed over the part with the most detail when smart is set
+func cropImage(img image.Image, width, height int, smart bool) image.Image {
+	bounds := img.Bounds()
+	sourceWidth, sourceHeight := bounds.Dx(), bounds.Dy()
+
+	// Largest region with the aspect ratio of the thumbnail
+	region := image.Rect(0, 0, sourceWidth, sourceHeight)
+	if sourceWidth*height > sourceHeight*width {
+		cropWidth := max(sourceHeight*width/height, 1)
+		offset := (sourceWidth - cropWidth) / 2
+		if smart {
+			offset = entropyOffset(img, cropWidth, true)
+		}
+		region = image.Rect(offset, 0, offset+cropWidth, sourceHeight)
+	} else if sourceWidth*height < sourceHeight*width {
+		cropHeight := max(sourceWidth*height/width, 1)
+		offset := (sourceHeight - cropHeight) / 2
+		if smart {
+			offset = entropyOffset(img, cropHeight, false)
+		}
+		region = image.Rect(0, offset, sourceWidth, offset+cropHeight)
+	}
+	region = region.Add(bounds.Min)
+
+	// Simple nearest neighbor resize of the region
+	cropped := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sourceY := region.Min.Y + y*region.Dy()/height
+		for x := 0; x < width; x++ {
+			sourceX := region.Min.X + x*region.Dx()/width
+			cropped.Set(x, y, img.At(sourceX, sourceY))
+		}
+	}
+	return cropped
+}
+
+// entropyOffset returns the offset of the crop window of the given length along one axis
+// It trims the edge strip with the least entropy until the window fits, like libvips' entropy crop
+func entropyOffset(img image.Image, length int, horizontal bool) int {
+	grid := luminanceGrid(img)
+	gridHeight := len(grid)
+	gridWidth := len(grid[0])
+
+	bounds := img.Bounds()
+	sourceLength, gridLength := bounds.Dx(), gridWidth
+	if !horizontal {
+		sourceLength, gridLength = bounds.Dy(), gridHeight
+	}
+
+	// Window length in grid cells
+	window := int(math.Round(float64(length) * float64(gridLength) / float64(sourceLength)))
+	window = min(max(window, 1), gridLength)
+
+	// The strip of cells at one position along the axis
+	strip := func(position int) []uint8 {
+		if horizontal {
+			column := make([]uint8, gridHeight)
+			for y := range grid {
+				column[y] = grid[y][position]
+			}
+			return column
+		}
+		return grid[position]
+	}
+
+	low, high := 0, gridLength
+	for high-low > window {
+		if entropy(strip(low)) < entropy(strip(high-1)) {
+			low++
+		} else {
+			high--
+		}
+	}
+
+	offset := low * sourceLength / gridLength
+	return min(max(offset, 0), sourceLength-length)
+}
+
+// luminanceGrid samples the luminance of an image on a grid with entropyGridSize cells on its longest side
+func luminanceGrid(img image.Image) [][]uint8 {
+	bounds := img.Bounds()
+	gridWidth, gridHeight := entropyGridSize, entropyGridSize
+	if bounds.Dx() > bounds.Dy() {
+		gridHeight = max(entropyGridSize*bounds.Dy()/bounds.Dx(), 1)
+	} else {
+		gridWidth = max(entropyGridSize*bounds.Dx()/bounds.Dy(), 1)
+	}
+	gridWidth = min(gridWidth, bounds.Dx())
+	gridHeight = min(gridHeight, bounds.Dy())
+
+	grid := make([][]uint8, gridHeight)
+	for y := range grid {
+		grid[y] = make([]uint8, gridWidth)
+		sourceY := bounds.Min.Y + y*bounds.Dy()/gridHeight
+		for x := range grid[y] {
+			sourceX := bounds.Min.X + x*bounds.Dx()/gridWidth
+			grid[y][x] = color.GrayModel.Convert(img.At(sourceX, sourceY)).(color.Gray).Y
+		}
+	}
+	return grid
+}
+
+// entropy returns the Shannon entropy of luminance values
+func entropy(values []uint8) float64 {
+	var histogram [entropyBins]int
+	for _, value := range values {
+		histogram[int(value)*entropyBins/256]++
+	}
+
+	var result float64
+	for _, count := range histogram {
+		if count > 0 {
+			p := float64(count) / float64(len(values))
+			result -= p * math.Log2(p)
+		}
+	}
+	return result
+}
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/darmawan01/storage/jobs"
+)
+
+// ThumbnailStatus describes the state of the latest thumbnail job for a file
+// It is returned by status queries and delivered to subscribers and webhooks once a job finishes
+type ThumbnailStatus struct {
+	JobID      string          `json:"job_id"`
+	FileKey    string          `json:"file_key"`
+	Status     jobs.Status     `json:"status"`           // pending, processing, done or failed
+	Reason     string          `json:"reason,omitempty"` // Why no thumbnails were generated, e.g. too_large
+	Thumbnails []ThumbnailInfo `json:"thumbnails,omitempty"`
+	Bucket     string          `json:"bucket,omitempty"` // Bucket the thumbnails are stored in
+	Error      string          `json:"error,omitempty"`
+	Attempts   int             `json:"attempts"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+}
+
+// newThumbnailStatus builds a status from a thumbnail job
+func newThumbnailStatus(job *jobs.Job) *ThumbnailStatus {
+	status := &ThumbnailStatus{
+		JobID:     job.ID,
+		FileKey:   job.FileKey,
+		Status:    job.Status,
+		Error:     job.Error,
+		Attempts:  job.Attempts,
+		CreatedAt: job.CreatedAt,
+		UpdatedAt: job.UpdatedAt,
+		Bucket:    job.String("derived_bucket"),
+	}
+	if status.Bucket == "" {
+		status.Bucket = job.BucketName
+	}
+
+	if reason, ok := job.Result["thumbnail_status"].(string); ok {
+		status.Reason = reason
+	}
+
+	// Results that went through a durable store come back as generic maps
+	switch thumbnails := job.Result["thumbnails"].(type) {
+	case []ThumbnailInfo:
+		status.Thumbnails = thumbnails
+	case nil:
+	default:
+		if data, err := json.Marshal(thumbnails); err == nil {
+			_ = json.Unmarshal(data, &status.Thumbnails)
+		}
+	}
+
+	return status
+}
+
+// ThumbnailStatus returns the status of the most recent thumbnail job for a file
+func (p *AsyncProcessor) ThumbnailStatus(ctx context.Context, fileKey string) (*ThumbnailStatus, error) {
+	fileJobs, err := p.jobs.JobsForFile(ctx, fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(fileJobs) - 1; i >= 0; i-- {
+		if fileJobs[i].Type == jobs.TypeThumbnail {
+			return newThumbnailStatus(fileJobs[i]), nil
+		}
+	}
+	return nil, jobs.ErrJobNotFound
+}
+
+// Subscribe returns a channel receiving the status of every finished thumbnail job
+// Notifications are dropped when the channel buffer is full; call the returned func to unsubscribe
+func (p *AsyncProcessor) Subscribe(buffer int) (<-chan *ThumbnailStatus, func()) {
+	if buffer <= 0 {
+		buffer = 100
+	}
+	ch := make(chan *ThumbnailStatus, buffer)
+
+	p.mutex.Lock()
+	p.subscribers[ch] = struct{}{}
+	p.mutex.Unlock()
+
+	return ch, func() {
+		p.mutex.Lock()
+		defer p.mutex.Unlock()
+
+		if _, exists := p.subscribers[ch]; exists {
+			delete(p.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// notify delivers a finished job status to subscribers and the configured webhook
+func (p *AsyncProcessor) notify(ctx context.Context, status *ThumbnailStatus) {
+	p.mutex.Lock()
+	for ch := range p.subscribers {
+		select {
+		case ch <- status:
+		default:
+			// Slow subscriber, drop the notification
+		}
+	}
+	p.mutex.Unlock()
+
+	if p.config.WebhookURL == "" {
+		return
+	}
+	if err := p.sendWebhook(ctx, status); err != nil {
+		p.config.Logger.Warn("thumbnail webhook failed", map[string]interface{}{
+			"job_id":   status.JobID,
+			"file_key": status.FileKey,
+			"error":    err,
+		})
+	}
+}
+
+// sendWebhook posts the status as JSON to the configured webhook URL
+func (p *AsyncProcessor) sendWebhook(ctx context.Context, status *ThumbnailStatus) error {
+	body, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	timeout := p.config.WebhookTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
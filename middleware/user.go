@@ -0,0 +1,31 @@
+package middleware
+
+import "context"
+
+// ContextKey is the type of the context keys of this package, so they never collide with plain
+// string keys of other packages
+type ContextKey string
+
+// Context keys of the requesting user, set by WithUser and read by the security middleware
+const (
+	UserIDKey ContextKey = "user_id"    // string
+	RolesKey  ContextKey = "user_roles" // []string
+)
+
+// WithUser returns a context carrying the user operations are authorized for, e.g. the identity
+// of a verified token; auth.WithIdentity sets it too. A user without roles gets those of the
+// authorizer's RoleProvider
+func WithUser(ctx context.Context, user *User) context.Context {
+	ctx = context.WithValue(ctx, UserIDKey, user.ID)
+	return context.WithValue(ctx, RolesKey, user.Roles)
+}
+
+// UserFromContext returns the user stored by WithUser, false when the context carries none
+func UserFromContext(ctx context.Context) (*User, bool) {
+	userID, ok := ctx.Value(UserIDKey).(string)
+	if !ok || userID == "" {
+		return nil, false
+	}
+	roles, _ := ctx.Value(RolesKey).([]string)
+	return &User{ID: userID, Roles: roles}, true
+}
@@ -11,6 +11,8 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+
+	"github.com/darmawan01/storage/errors"
 )
 
 // ValidationMiddleware handles file validation
@@ -109,6 +111,9 @@ func (m *ValidationMiddleware) Process(ctx context.Context, req *StorageRequest,
 
 	// Perform validation
 	if err := m.validateFile(req); err != nil {
+		if errors.Code(err) == "" {
+			err = errors.Wrap(errors.ErrValidationFailed, err)
+		}
 		return &StorageResponse{
 			Success: false,
 			Error:   err,
@@ -137,7 +142,7 @@ func (m *ValidationMiddleware) validateFile(req *StorageRequest) error {
 func (m *ValidationMiddleware) validateBasicFile(req *StorageRequest) error {
 	// Check file size
 	if m.config.MaxFileSize > 0 && req.FileSize > m.config.MaxFileSize {
-		return fmt.Errorf("file size %d exceeds maximum allowed size %d", req.FileSize, m.config.MaxFileSize)
+		return &errors.StorageError{Code: errors.ErrFileTooLarge.Code, Message: fmt.Sprintf("File size %d exceeds maximum allowed size %d", req.FileSize, m.config.MaxFileSize)}
 	}
 
 	if m.config.MinFileSize > 0 && req.FileSize < m.config.MinFileSize {
@@ -147,7 +152,7 @@ func (m *ValidationMiddleware) validateBasicFile(req *StorageRequest) error {
 	// Check content type
 	if len(m.config.AllowedTypes) > 0 {
 		if !slices.Contains(m.config.AllowedTypes, req.ContentType) {
-			return fmt.Errorf("content type %s is not allowed, allowed types: %v", req.ContentType, m.config.AllowedTypes)
+			return &errors.StorageError{Code: errors.ErrUnsupportedType.Code, Message: fmt.Sprintf("Content type %s is not allowed, allowed types: %v", req.ContentType, m.config.AllowedTypes)}
 		}
 	}
 
@@ -155,7 +160,7 @@ func (m *ValidationMiddleware) validateBasicFile(req *StorageRequest) error {
 	if len(m.config.AllowedExtensions) > 0 {
 		ext := strings.ToLower(filepath.Ext(req.FileName))
 		if !slices.Contains(m.config.AllowedExtensions, ext) {
-			return fmt.Errorf("file extension %s is not allowed, allowed extensions: %v", ext, m.config.AllowedExtensions)
+			return &errors.StorageError{Code: errors.ErrUnsupportedType.Code, Message: fmt.Sprintf("File extension %s is not allowed, allowed extensions: %v", ext, m.config.AllowedExtensions)}
 		}
 	}
 
@@ -166,6 +171,18 @@ func (m *ValidationMiddleware) validateBasicFile(req *StorageRequest) error {
 func (m *ValidationMiddleware) validateContentType(req *StorageRequest) error {
 	contentType := req.ContentType
 
+	// Validators read the data, which must stay available for the upload
+	if (m.isImageType(contentType) && m.config.ImageValidation != nil) ||
+		(m.isPDFType(contentType) && m.config.PDFValidation != nil) ||
+		(m.isVideoType(contentType) && m.config.VideoValidation != nil) ||
+		(m.isAudioType(contentType) && m.config.AudioValidation != nil) {
+		buffer, err := req.BufferData()
+		if err != nil {
+			return err
+		}
+		defer buffer.Rewind()
+	}
+
 	// Image validation
 	if m.isImageType(contentType) && m.config.ImageValidation != nil {
 		if err := m.validateImage(req, *m.config.ImageValidation); err != nil {
@@ -0,0 +1,237 @@
+// Package migrate imports the objects of existing buckets into storage handlers
+// Keys are mapped to the category, entity and filename of the key schema of the handler, files are
+// imported with handler.Import, so thumbnails, metadata callbacks and events are produced as for
+// uploads, and copied server-side when the source is on the same backend
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/handler"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/minio/minio-go/v7"
+)
+
+var ErrInvalidConfig = &errors.StorageError{Code: "INVALID_CONFIG", Message: "Invalid migration configuration"}
+
+// Target represents where an object is imported to
+type Target struct {
+	Category   string                 `json:"category"`
+	EntityType string                 `json:"entity_type"`
+	EntityID   string                 `json:"entity_id"`
+	UserID     string                 `json:"user_id,omitempty"`
+	FileName   string                 `json:"file_name,omitempty"` // default the last segment of the key
+	Metadata   map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// Mapper returns the target of an object, nil to skip it
+type Mapper func(object minio.ObjectInfo) (*Target, error)
+
+// placeholders are the fields of a key pattern
+var placeholders = []string{"category", "entity_type", "entity_id", "user_id", "filename"}
+
+// KeyPattern returns a Mapper reading targets from keys, e.g. "users/{entity_id}/{category}/{filename}"
+// Placeholders match one segment, "*" matches any segments and is ignored; fields missing from
+// the pattern keep those of defaults. Keys not matching the pattern are skipped
+func KeyPattern(pattern string, defaults Target) (Mapper, error) {
+	var expr strings.Builder
+	expr.WriteString("^")
+	var fields []string
+	for rest := pattern; rest != ""; {
+		switch {
+		case strings.HasPrefix(rest, "*"):
+			expr.WriteString(".*")
+			rest = rest[1:]
+		case strings.HasPrefix(rest, "{"):
+			end := strings.Index(rest, "}")
+			if end < 0 {
+				return nil, &errors.StorageError{Code: ErrInvalidConfig.Code, Message: "Unclosed placeholder in pattern " + pattern}
+			}
+			name := rest[1:end]
+			if !slices.Contains(placeholders, name) {
+				return nil, &errors.StorageError{Code: ErrInvalidConfig.Code, Message: "Unknown placeholder {" + name + "} in pattern " + pattern}
+			}
+			expr.WriteString("([^/]+)")
+			fields = append(fields, name)
+			rest = rest[end+1:]
+		default:
+			end := strings.IndexAny(rest, "*{")
+			if end < 0 {
+				end = len(rest)
+			}
+			expr.WriteString(regexp.QuoteMeta(rest[:end]))
+			rest = rest[end:]
+		}
+	}
+	expr.WriteString("$")
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidConfig, err)
+	}
+
+	return func(object minio.ObjectInfo) (*Target, error) {
+		match := re.FindStringSubmatch(object.Key)
+		if match == nil {
+			return nil, nil
+		}
+		target := defaults
+		for i, name := range fields {
+			value := match[i+1]
+			switch name {
+			case "category":
+				target.Category = value
+			case "entity_type":
+				target.EntityType = value
+			case "entity_id":
+				target.EntityID = value
+			case "user_id":
+				target.UserID = value
+			case "filename":
+				target.FileName = value
+			}
+		}
+		return &target, nil
+	}, nil
+}
+
+// Config represents a migration of a bucket into a handler
+type Config struct {
+	Source      *minio.Client // Backend of the bucket, the handler's one when nil
+	Bucket      string
+	Prefix      string
+	Map         Mapper
+	Concurrency int  // Objects imported at once, default 4
+	DryRun      bool // Maps objects without importing them
+	// OnFile is called with the result of every object, one at a time, e.g. to log progress or
+	// record the new keys
+	OnFile func(result Result)
+}
+
+// Result represents the migration of one object
+type Result struct {
+	SourceKey string  `json:"source_key"`
+	FileKey   string  `json:"file_key,omitempty"` // Key in the handler, empty when skipped or in dry runs
+	Target    *Target `json:"target,omitempty"`
+	Skipped   bool    `json:"skipped,omitempty"`
+	Err       error   `json:"-"`
+}
+
+// Report represents the outcome of a migration
+type Report struct {
+	Scanned  int      `json:"scanned"`
+	Migrated int      `json:"migrated"`
+	Skipped  int      `json:"skipped"`
+	Failed   int      `json:"failed"`
+	Failures []Result `json:"failures,omitempty"`
+}
+
+// Run imports the objects under the prefix of a bucket into a handler
+// Objects are mapped by config.Map and imported concurrently; failed objects are reported and do
+// not stop the migration, which can be run again: imported keys are generated anew, so objects
+// already migrated should be excluded by prefix or mapper
+func Run(ctx context.Context, h *handler.Handler, config Config) (*Report, error) {
+	if config.Bucket == "" {
+		return nil, &errors.StorageError{Code: ErrInvalidConfig.Code, Message: "Source bucket is required"}
+	}
+	if config.Map == nil {
+		return nil, &errors.StorageError{Code: ErrInvalidConfig.Code, Message: "Map is required"}
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = 4
+	}
+	client := config.Source
+	if client == nil {
+		client = h.Client
+	}
+
+	report := &Report{}
+	var mutex sync.Mutex
+	record := func(result Result) {
+		mutex.Lock()
+		switch {
+		case result.Err != nil:
+			report.Failed++
+			report.Failures = append(report.Failures, result)
+		case result.Skipped:
+			report.Skipped++
+		default:
+			report.Migrated++
+		}
+		if config.OnFile != nil {
+			config.OnFile(result)
+		}
+		mutex.Unlock()
+	}
+
+	objects := make(chan minio.ObjectInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < config.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for object := range objects {
+				record(migrate(ctx, h, config, object))
+			}
+		}()
+	}
+
+	var listErr error
+	for object := range client.ListObjects(ctx, config.Bucket, minio.ListObjectsOptions{Prefix: config.Prefix, Recursive: true}) {
+		if object.Err != nil {
+			listErr = fmt.Errorf("failed to list objects: %w", object.Err)
+			break
+		}
+		// Directory markers are not files
+		if strings.HasSuffix(object.Key, "/") {
+			continue
+		}
+		report.Scanned++
+		objects <- object
+	}
+	close(objects)
+	wg.Wait()
+
+	if listErr == nil {
+		listErr = ctx.Err()
+	}
+	return report, listErr
+}
+
+// migrate maps and imports one object
+func migrate(ctx context.Context, h *handler.Handler, config Config, object minio.ObjectInfo) Result {
+	result := Result{SourceKey: object.Key}
+	target, err := config.Map(object)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	if target == nil {
+		result.Skipped = true
+		return result
+	}
+	result.Target = target
+	if config.DryRun {
+		return result
+	}
+
+	resp, err := h.Import(ctx, handler.ImportSource{Client: config.Source, Bucket: config.Bucket, Key: object.Key}, &interfaces.UploadRequest{
+		FileName:   target.FileName,
+		Category:   target.Category,
+		EntityType: target.EntityType,
+		EntityID:   target.EntityID,
+		UserID:     target.UserID,
+		Metadata:   target.Metadata,
+	})
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.FileKey = resp.FileKey
+	return result
+}
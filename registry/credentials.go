@@ -0,0 +1,79 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/darmawan01/storage/config"
+	"github.com/darmawan01/storage/secrets"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// newCredentials returns the MinIO credentials of a configuration, static unless they are read from secrets
+func newCredentials(config config.StorageConfig) *credentials.Credentials {
+	if config.AccessKeyFile == "" && config.SecretKeyFile == "" && config.AccessKeySecret == "" && config.SecretKeySecret == "" {
+		return credentials.NewStaticV4(config.AccessKey, config.SecretKey, "")
+	}
+
+	interval := time.Duration(config.SecretRefreshInterval) * time.Second
+	provider := &secretCredentials{
+		config:   config,
+		files:    secrets.NewCache(secrets.NewFileProvider(""), interval),
+		interval: interval,
+	}
+	if config.Secrets != nil {
+		provider.secrets = secrets.NewCache(config.Secrets, interval)
+	}
+	return credentials.New(provider)
+}
+
+// secretCredentials reads the access keys from secret files or a secret provider
+// The client retrieves them again after the refresh interval, so rotated keys are used without a restart
+type secretCredentials struct {
+	credentials.Expiry
+
+	config   config.StorageConfig
+	files    secrets.Provider
+	secrets  secrets.Provider
+	interval time.Duration
+}
+
+// Retrieve reads the current access keys
+func (c *secretCredentials) Retrieve() (credentials.Value, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(c.config.RequestTimeout)*time.Second)
+	defer cancel()
+
+	accessKey, err := c.read(ctx, c.config.AccessKey, c.config.AccessKeyFile, c.config.AccessKeySecret)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to read access key: %w", err)
+	}
+	secretKey, err := c.read(ctx, c.config.SecretKey, c.config.SecretKeyFile, c.config.SecretKeySecret)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to read secret key: %w", err)
+	}
+
+	interval := c.interval
+	if interval <= 0 {
+		interval = secrets.DefaultRefreshInterval
+	}
+	c.SetExpiration(time.Now().Add(interval), 0)
+
+	return credentials.Value{
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+// read returns a key from its secret, its file or the plain value, in that order
+func (c *secretCredentials) read(ctx context.Context, value, file, secret string) (string, error) {
+	switch {
+	case secret != "":
+		return secrets.GetString(ctx, c.secrets, secret)
+	case file != "":
+		return secrets.GetString(ctx, c.files, file)
+	default:
+		return value, nil
+	}
+}
@@ -0,0 +1,153 @@
+package registry
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/handler"
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// UserDataManifest lists the files of a user data export, it is the last entry of the archive
+type UserDataManifest struct {
+	UserID     string         `json:"user_id"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Files      []UserDataFile `json:"files"`
+}
+
+// UserDataFile represents an exported file with its metadata
+type UserDataFile struct {
+	Handler  string                   `json:"handler"`
+	Path     string                   `json:"path"` // Name of the archive entry holding the content
+	Metadata *interfaces.FileMetadata `json:"metadata"`
+}
+
+// userDataManifestName is the archive entry of the manifest
+const userDataManifestName = "manifest.json"
+
+// ExportUserData writes all files a user uploaded through any handler to dst as a tar archive,
+// e.g. for GDPR data access requests. Files are written decrypted and decompressed under
+// files/{handler}/{file key}, followed by manifest.json describing every file (UserDataManifest)
+// Wrap dst in a gzip.Writer for a compressed archive. Returns the number of files written
+func (r *Registry) ExportUserData(ctx context.Context, userID string, dst io.Writer) (int, error) {
+	manifest := UserDataManifest{UserID: userID, ExportedAt: time.Now(), Files: []UserDataFile{}}
+	archive := tar.NewWriter(dst)
+
+	// Handlers share buckets, a file found by several handlers is written once; without metadata
+	// stores, which record the handler of a file, it is attributed to the first handler by name
+	exported := make(map[string]bool)
+	for _, h := range r.sortedHandlers() {
+		files, err := h.UserFiles(ctx, userID)
+		if err != nil {
+			return len(manifest.Files), fmt.Errorf("failed to find files of handler %s: %w", h.Name, err)
+		}
+		for i := range files {
+			file := &files[i]
+			if exported[file.FileKey] {
+				continue
+			}
+			name := "files/" + h.Name + "/" + file.FileKey
+			if err := exportUserFile(ctx, archive, h, name, file.FileKey); err != nil {
+				return len(manifest.Files), err
+			}
+			exported[file.FileKey] = true
+			manifest.Files = append(manifest.Files, UserDataFile{Handler: h.Name, Path: name, Metadata: file})
+		}
+	}
+
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return len(manifest.Files), fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     userDataManifestName,
+		Size:     int64(len(encoded)),
+		Mode:     0o644,
+		ModTime:  manifest.ExportedAt,
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return len(manifest.Files), fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if _, err := archive.Write(encoded); err != nil {
+		return len(manifest.Files), fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := archive.Close(); err != nil {
+		return len(manifest.Files), fmt.Errorf("failed to write archive: %w", err)
+	}
+	return len(manifest.Files), nil
+}
+
+// exportUserFile writes the content of a file to an archive entry
+func exportUserFile(ctx context.Context, archive *tar.Writer, h *handler.Handler, name, fileKey string) error {
+	data, size, err := h.ReadFile(ctx, fileKey)
+	if err != nil {
+		return fmt.Errorf("failed to export file %s: %w", fileKey, err)
+	}
+	defer data.Close()
+
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  time.Now(),
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write archive entry %s: %w", name, err)
+	}
+	if _, err := io.CopyN(archive, data, size); err != nil {
+		return fmt.Errorf("failed to write archive entry %s: %w", name, err)
+	}
+	return nil
+}
+
+// EraseUserData deletes all files a user uploaded through any handler, with their thumbnails,
+// metadata records and access history, e.g. for GDPR erasure requests. Every handler publishes
+// an events.TypeUserDataErased event; erasure continues with the other handlers when one fails
+// Returns the number of files deleted
+func (r *Registry) EraseUserData(ctx context.Context, userID string) (int, error) {
+	erased := 0
+	var failed []string
+	for _, h := range r.sortedHandlers() {
+		count, err := h.EraseUserData(ctx, userID)
+		erased += count
+		if err != nil {
+			if ctx.Err() != nil {
+				return erased, ctx.Err()
+			}
+			failed = append(failed, h.Name+": "+err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return erased, &errors.StorageError{
+			Code:    errors.ErrDeleteFailed.Code,
+			Message: fmt.Sprintf("Failed to erase user data of %d handlers", len(failed)),
+			Details: strings.Join(failed, "; "),
+		}
+	}
+	return erased, nil
+}
+
+// sortedHandlers returns the registered handlers ordered by name
+func (r *Registry) sortedHandlers() []*handler.Handler {
+	r.mutex.RLock()
+	handlers := make([]*handler.Handler, 0, len(r.handlers))
+	for _, h := range r.handlers {
+		handlers = append(handlers, h)
+	}
+	r.mutex.RUnlock()
+
+	sort.Slice(handlers, func(i, j int) bool {
+		return handlers[i].Name < handlers[j].Name
+	})
+	return handlers
+}
@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"context"
+	"time"
+
+	"github.com/darmawan01/storage/handler"
+)
+
+// HealthReport represents the health of the storage system, for readiness and liveness endpoints
+type HealthReport struct {
+	Status    string                            `json:"status"` // Worst status of the backend and all handlers
+	CheckedAt time.Time                         `json:"checked_at"`
+	Duration  time.Duration                     `json:"duration"`
+	Backend   handler.ComponentHealth           `json:"backend"` // Connection to MinIO
+	Handlers  map[string]*handler.HandlerHealth `json:"handlers"`
+}
+
+// Ready reports whether the storage system can serve requests, degraded components included
+func (r *HealthReport) Ready() bool {
+	return r.Status != handler.HealthDown
+}
+
+// HealthReport checks the MinIO connection and every handler in detail: probe latency, circuit
+// breaker, job queue and caches. Unlike HealthCheck it reports all problems instead of the first one
+func (r *Registry) HealthReport(ctx context.Context) *HealthReport {
+	report := &HealthReport{
+		CheckedAt: time.Now(),
+		Handlers:  make(map[string]*handler.HandlerHealth),
+	}
+
+	if r.client == nil {
+		report.Status = handler.HealthDown
+		report.Backend = handler.ComponentHealth{Status: handler.HealthDown, Error: "Registry not initialized"}
+		return report
+	}
+
+	start := time.Now()
+	_, err := r.client.ListBuckets(ctx)
+	report.Backend = handler.ComponentHealth{Status: handler.HealthOK, Latency: time.Since(start)}
+	if err != nil {
+		report.Backend.Status = handler.HealthDown
+		report.Backend.Error = err.Error()
+	}
+	report.Status = report.Backend.Status
+
+	r.mutex.RLock()
+	handlers := make(map[string]*handler.Handler, len(r.handlers))
+	for name, h := range r.handlers {
+		handlers[name] = h
+	}
+	r.mutex.RUnlock()
+
+	for name, h := range handlers {
+		health := h.Health(ctx)
+		report.Handlers[name] = health
+		report.Status = handler.WorstHealth(report.Status, health.Status)
+	}
+
+	report.Duration = time.Since(report.CheckedAt)
+	return report
+}
@@ -0,0 +1,116 @@
+package registry
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MetricsConfig represents metrics endpoint configuration
+type MetricsConfig struct {
+	// Bearer token required in the Authorization header, the endpoint is open when empty
+	Token string `json:"token,omitempty"`
+	// Custom authorization check, used instead of Token when set
+	Authorize func(r *http.Request) bool `json:"-"`
+	// Timeout for the health check included in the snapshot, default 5s
+	HealthTimeout time.Duration `json:"health_timeout,omitempty"`
+}
+
+// MetricsSnapshot is the JSON document served by the metrics endpoint
+type MetricsSnapshot struct {
+	Status    string                 `json:"status"` // healthy or unhealthy
+	Error     string                 `json:"error,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+	Registry  map[string]interface{} `json:"registry"`
+	Handlers  map[string]interface{} `json:"handlers"`
+	Stats     *StatsReport           `json:"stats,omitempty"` // Statistics of all handlers combined, see GetStats
+}
+
+// Snapshot collects registry, handler, cache and async statistics together with the health status
+func (r *Registry) Snapshot(ctx context.Context) *MetricsSnapshot {
+	snapshot := &MetricsSnapshot{
+		Status:    "healthy",
+		Timestamp: time.Now(),
+		Handlers:  make(map[string]interface{}),
+	}
+
+	if err := r.HealthCheck(ctx); err != nil {
+		snapshot.Status = "unhealthy"
+		snapshot.Error = err.Error()
+	}
+	// Collected before locking, GetStats locks the handlers itself
+	if stats, err := r.GetStats(ctx); err == nil {
+		snapshot.Stats = stats
+	}
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	// Registry stats without the connection credentials
+	names := make([]string, 0, len(r.handlers))
+	for name, handler := range r.handlers {
+		names = append(names, name)
+		snapshot.Handlers[name] = handler.GetStats()
+	}
+	snapshot.Registry = map[string]interface{}{
+		"handlers_count": len(r.handlers),
+		"handlers":       names,
+		"endpoint":       r.config.Endpoint,
+		"bucket_name":    r.config.BucketName,
+	}
+
+	return snapshot
+}
+
+// MetricsHandler returns an http.Handler serving the metrics snapshot as JSON
+// Unhealthy snapshots are served with status 503 so the endpoint can double as a health probe
+func (r *Registry) MetricsHandler(config MetricsConfig) http.Handler {
+	if config.HealthTimeout <= 0 {
+		config.HealthTimeout = 5 * time.Second
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !authorizeMetrics(config, req) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), config.HealthTimeout)
+		defer cancel()
+
+		snapshot := r.Snapshot(ctx)
+
+		status := http.StatusOK
+		if snapshot.Status != "healthy" {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(snapshot)
+	})
+}
+
+// authorizeMetrics checks the request against the configured authorization
+func authorizeMetrics(config MetricsConfig, req *http.Request) bool {
+	if config.Authorize != nil {
+		return config.Authorize(req)
+	}
+	if config.Token == "" {
+		return true
+	}
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(config.Token)) == 1
+}
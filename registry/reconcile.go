@@ -0,0 +1,270 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/handler"
+	"github.com/darmawan01/storage/interfaces"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/minio/minio-go/v7"
+)
+
+// ReconcileOptions represents options of a reconciliation run
+type ReconcileOptions struct {
+	// Prefix limits the run to file keys under it, e.g. an entity; large buckets should be reconciled in parts
+	Prefix string `json:"prefix,omitempty"`
+	// MinAge skips objects and records younger than it, which may belong to uploads still in progress, default 1 hour
+	MinAge time.Duration `json:"min_age,omitempty"`
+
+	// Fixes, without them the run only reports
+	DeleteOrphans         bool `json:"delete_orphans,omitempty"`          // Remove objects without a metadata record
+	DeleteStaleThumbnails bool `json:"delete_stale_thumbnails,omitempty"` // Remove thumbnails whose original is gone
+	DeleteMissingRecords  bool `json:"delete_missing_records,omitempty"`  // Remove records whose object is gone
+}
+
+// ReconcileReport represents the findings of a reconciliation run
+type ReconcileReport struct {
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	ObjectsScanned int       `json:"objects_scanned"`
+	RecordsScanned int       `json:"records_scanned"`
+	// MetadataChecked is false when no handler has a MetadataStore; only thumbnails are checked then
+	MetadataChecked bool `json:"metadata_checked"`
+
+	Orphaned        []ReconcileItem `json:"orphaned"`         // Objects without a metadata record
+	Missing         []ReconcileItem `json:"missing"`          // Records without an object
+	StaleThumbnails []ReconcileItem `json:"stale_thumbnails"` // Thumbnails without an original
+}
+
+// ReconcileItem represents an inconsistency found by reconciliation
+type ReconcileItem struct {
+	Bucket  string `json:"bucket,omitempty"`
+	FileKey string `json:"file_key"`
+	Handler string `json:"handler,omitempty"` // Handler owning the record, for missing objects
+	Fixed   bool   `json:"fixed"`
+	Error   string `json:"error,omitempty"` // Why fixing failed
+}
+
+// reconcileRecord is a metadata record with the store it came from
+type reconcileRecord struct {
+	metadata *interfaces.FileMetadata
+	store    interfaces.MetadataStore
+	handler  string
+}
+
+// Reconcile compares the buckets of all handlers with their metadata stores and reports orphaned
+// objects, records of missing objects and thumbnails whose original is gone, fixing them on request
+// All handlers share the buckets, so records of every handler are considered for every object
+func (r *Registry) Reconcile(ctx context.Context, opts ReconcileOptions) (*ReconcileReport, error) {
+	if r.client == nil {
+		return nil, &errors.StorageError{Code: "NOT_INITIALIZED", Message: "Registry not initialized"}
+	}
+	if opts.MinAge == 0 {
+		opts.MinAge = time.Hour
+	}
+
+	// Stop listings when the run fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	report := &ReconcileReport{StartedAt: time.Now()}
+	cutoff := report.StartedAt.Add(-opts.MinAge)
+
+	originalBuckets, derivedBuckets, stores := r.reconcileSources()
+	stagingPrefixes := r.stagingPrefixes()
+	report.MetadataChecked = len(stores) > 0
+
+	// Records of all stores; stores shared by several handlers are read once
+	records := make(map[string]reconcileRecord)
+	for store, name := range stores {
+		err := store.List(ctx, opts.Prefix, func(metadata *interfaces.FileMetadata) error {
+			records[metadata.FileKey] = reconcileRecord{metadata: metadata, store: store, handler: name}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list metadata records of handler %s: %w", name, err)
+		}
+	}
+	report.RecordsScanned = len(records)
+
+	// Objects of all buckets; thumbnails may be stored next to their originals
+	// Listings are ordered by key, so reports are stable
+	var buckets []string
+	objects := make(map[string]map[string]bool)
+	listed := make(map[string][]minio.ObjectInfo)
+	originals := make(map[string]bool)
+	for _, bucketName := range append(append([]string{}, originalBuckets...), derivedBuckets...) {
+		if _, exists := objects[bucketName]; exists {
+			continue
+		}
+		bucketObjects := make(map[string]bool)
+		for object := range r.client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{Prefix: opts.Prefix, Recursive: true}) {
+			if object.Err != nil {
+				return nil, fmt.Errorf("failed to list bucket %s: %w", bucketName, object.Err)
+			}
+			bucketObjects[object.Key] = true
+			listed[bucketName] = append(listed[bucketName], object)
+		}
+		buckets = append(buckets, bucketName)
+		objects[bucketName] = bucketObjects
+		report.ObjectsScanned += len(bucketObjects)
+	}
+	for _, bucketName := range originalBuckets {
+		for fileKey := range objects[bucketName] {
+			if middleware.ThumbnailOriginalKeys(fileKey) == nil && !handler.IsDerivedManifestKey(fileKey) && !handler.IsAuditAnchorKey(fileKey) {
+				// Thumbnails of staged files are kept until they are committed or rolled back
+				originals[unstagedKey(stagingPrefixes, fileKey)] = true
+			}
+		}
+	}
+	// Records name originals as well, which matters for thumbnails when originals are elsewhere
+	for fileKey := range records {
+		originals[fileKey] = true
+	}
+
+	isOriginalBucket := make(map[string]bool)
+	for _, bucketName := range originalBuckets {
+		isOriginalBucket[bucketName] = true
+	}
+
+	for _, bucketName := range buckets {
+		for _, object := range listed[bucketName] {
+			fileKey := object.Key
+			if object.LastModified.After(cutoff) {
+				continue
+			}
+
+			if originalKeys := middleware.ThumbnailOriginalKeys(fileKey); originalKeys != nil {
+				stale := true
+				for _, originalKey := range originalKeys {
+					if originals[originalKey] {
+						stale = false
+					}
+				}
+				if stale {
+					item := ReconcileItem{Bucket: bucketName, FileKey: fileKey}
+					if opts.DeleteStaleThumbnails {
+						r.reconcileRemove(ctx, &item)
+					}
+					report.StaleThumbnails = append(report.StaleThumbnails, item)
+				}
+				continue
+			}
+
+			// Manifests of derived files are deleted with their originals
+			if !report.MetadataChecked || !isOriginalBucket[bucketName] || handler.IsDerivedManifestKey(fileKey) || handler.IsAuditAnchorKey(fileKey) {
+				continue
+			}
+			// Staged files get their records when committed and expire otherwise
+			if unstagedKey(stagingPrefixes, fileKey) != fileKey {
+				continue
+			}
+			if _, exists := records[fileKey]; !exists {
+				item := ReconcileItem{Bucket: bucketName, FileKey: fileKey}
+				if opts.DeleteOrphans {
+					r.reconcileRemove(ctx, &item)
+				}
+				report.Orphaned = append(report.Orphaned, item)
+			}
+		}
+	}
+
+	recordKeys := make([]string, 0, len(records))
+	for fileKey := range records {
+		recordKeys = append(recordKeys, fileKey)
+	}
+	sort.Strings(recordKeys)
+	for _, fileKey := range recordKeys {
+		record := records[fileKey]
+		if record.metadata.UploadedAt.After(cutoff) {
+			continue
+		}
+
+		found := false
+		for _, bucketName := range originalBuckets {
+			if objects[bucketName][fileKey] {
+				found = true
+				break
+			}
+		}
+		if found {
+			continue
+		}
+
+		item := ReconcileItem{FileKey: fileKey, Handler: record.handler}
+		if opts.DeleteMissingRecords {
+			if err := record.store.Delete(ctx, fileKey); err != nil {
+				item.Error = err.Error()
+			} else {
+				item.Fixed = true
+			}
+		}
+		report.Missing = append(report.Missing, item)
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// reconcileSources returns the buckets of originals and derived files of all handlers and tenants,
+// and the metadata stores of the handlers, compared by identity, with the name of one handler using each
+func (r *Registry) reconcileSources() ([]string, []string, map[interfaces.MetadataStore]string) {
+	handlers := r.sortedHandlers()
+
+	originalBuckets := []string{r.config.BucketName}
+	for _, id := range r.tenants.List() {
+		if t, err := r.tenants.Get(id); err == nil && t.BucketName != "" && t.BucketName != r.config.BucketName {
+			originalBuckets = append(originalBuckets, t.BucketName)
+		}
+	}
+
+	var derivedBuckets []string
+	stores := make(map[interfaces.MetadataStore]string)
+	for _, h := range handlers {
+		derivedBuckets = append(derivedBuckets, h.DerivedBuckets()...)
+		if store := h.MetadataStore(); store != nil {
+			if _, seen := stores[store]; !seen {
+				stores[store] = h.Name
+			}
+		}
+	}
+	return originalBuckets, derivedBuckets, stores
+}
+
+// stagingPrefixes returns the key prefixes of staged files and direct uploads of all handlers
+func (r *Registry) stagingPrefixes() []string {
+	var prefixes []string
+	for _, h := range r.sortedHandlers() {
+		if prefix := h.StagingPrefix(); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+		if prefix := h.DirectUploadPrefix(); prefix != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// unstagedKey returns the key a staged file or direct upload is stored under, other keys are returned as is
+func unstagedKey(prefixes []string, fileKey string) string {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(fileKey, prefix) {
+			return strings.TrimPrefix(fileKey, prefix)
+		}
+	}
+	return fileKey
+}
+
+// reconcileRemove removes the object of an item, recording the outcome
+func (r *Registry) reconcileRemove(ctx context.Context, item *ReconcileItem) {
+	if err := r.client.RemoveObject(ctx, item.Bucket, item.FileKey, minio.RemoveObjectOptions{}); err != nil {
+		item.Error = err.Error()
+		return
+	}
+	item.Fixed = true
+}
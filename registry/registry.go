@@ -10,8 +10,10 @@ import (
 	"github.com/darmawan01/storage/config"
 	"github.com/darmawan01/storage/errors"
 	"github.com/darmawan01/storage/handler"
+	"github.com/darmawan01/storage/logger"
+	"github.com/darmawan01/storage/middleware"
+	"github.com/darmawan01/storage/tenant"
 	"github.com/minio/minio-go/v7"
-	"github.com/minio/minio-go/v7/pkg/credentials"
 )
 
 // Registry manages multiple storage handlers with shared MinIO connection
@@ -19,13 +21,21 @@ type Registry struct {
 	client   *minio.Client
 	config   config.StorageConfig
 	handlers map[string]*handler.Handler
+	tenants  *tenant.Directory
 	mutex    sync.RWMutex
+
+	factories map[string]middleware.Factory
+
+	transport *http.Transport
+	closed    bool
 }
 
 // NewRegistry creates a new storage registry
 func NewRegistry() *Registry {
 	return &Registry{
-		handlers: make(map[string]*handler.Handler),
+		handlers:  make(map[string]*handler.Handler),
+		tenants:   tenant.NewDirectory(),
+		factories: make(map[string]middleware.Factory),
 	}
 }
 
@@ -44,9 +54,15 @@ func (r *Registry) Initialize(config config.StorageConfig) error {
 		DisableKeepAlives:   false,
 	}
 
+	// Credentials may come from secrets, fail early when they cannot be read
+	creds := newCredentials(config)
+	if _, err := creds.Get(); err != nil {
+		return fmt.Errorf("failed to load storage credentials: %w", err)
+	}
+
 	// Initialize MinIO client with performance optimizations
 	client, err := minio.New(config.Endpoint, &minio.Options{
-		Creds:     credentials.NewStaticV4(config.AccessKey, config.SecretKey, ""),
+		Creds:     creds,
 		Secure:    config.UseSSL,
 		Region:    config.Region,
 		Transport: transport,
@@ -55,7 +71,9 @@ func (r *Registry) Initialize(config config.StorageConfig) error {
 		return fmt.Errorf("failed to initialize MinIO client: %w", err)
 	}
 
+	config.Logger = logger.OrDefault(config.Logger)
 	r.client = client
+	r.transport = transport
 	r.config = config
 
 	// Test connection with timeout
@@ -81,18 +99,24 @@ func (r *Registry) Initialize(config config.StorageConfig) error {
 
 // Register creates a new storage handler with the given configuration
 func (r *Registry) Register(name string, config *handler.HandlerConfig) (*handler.Handler, error) {
-	if err := config.Validate(); err != nil {
-		return nil, err
-	}
-
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	if r.closed {
+		return nil, errors.ErrRegistryClosed
+	}
+
 	// Check if handler already exists
 	if _, exists := r.handlers[name]; exists {
 		return nil, &errors.StorageError{Code: "HANDLER_EXISTS", Message: "Handler " + name + " already exists"}
 	}
 
+	// Custom middlewares are known once inherited
+	r.inherit(config)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
 	handler := &handler.Handler{
 		Name:       name,
 		Config:     config,
@@ -109,6 +133,56 @@ func (r *Registry) Register(name string, config *handler.HandlerConfig) (*handle
 	return handler, nil
 }
 
+// RegisterMiddlewareFactory adds a custom middleware that categories can list by name in their
+// Middlewares, configured through MiddlewareConfigs. It applies to handlers registered or updated
+// afterwards; factories a handler config sets itself take precedence
+func (r *Registry) RegisterMiddlewareFactory(name string, factory middleware.Factory) error {
+	if name == "" || factory == nil {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Middleware name and factory are required"}
+	}
+	if handler.BuiltinMiddleware(name) {
+		return &errors.StorageError{Code: "INVALID_CONFIG", Message: "Middleware " + name + " is built in"}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.factories[name] = factory
+	return nil
+}
+
+// inherit fills the settings a handler config leaves unset with those of the registry
+func (r *Registry) inherit(config *handler.HandlerConfig) {
+	if config.Logger == nil {
+		config.Logger = r.config.Logger
+	}
+	if config.Tenants == nil {
+		config.Tenants = r.tenants
+	}
+	if config.Secrets == nil {
+		config.Secrets = r.config.Secrets
+	}
+	if config.Region == "" {
+		config.Region = r.config.Region
+	}
+	if len(r.factories) > 0 {
+		// The map of the caller is left alone
+		factories := make(map[string]middleware.Factory, len(r.factories)+len(config.MiddlewareFactories))
+		for name, factory := range r.factories {
+			factories[name] = factory
+		}
+		for name, factory := range config.MiddlewareFactories {
+			factories[name] = factory
+		}
+		config.MiddlewareFactories = factories
+	}
+	if config.Retry == (handler.RetryConfig{}) {
+		config.Retry = handler.RetryConfig{
+			Attempts:     r.config.RetryAttempts,
+			InitialDelay: time.Duration(r.config.RetryDelay) * time.Millisecond,
+		}
+	}
+}
+
 // GetHandler retrieves a registered handler by name
 func (r *Registry) GetHandler(name string) (*handler.Handler, error) {
 	r.mutex.RLock()
@@ -122,6 +196,24 @@ func (r *Registry) GetHandler(name string) (*handler.Handler, error) {
 	return handler, nil
 }
 
+// UpdateHandler replaces the configuration of a registered handler without restarting it
+// Categories can be added, removed or changed; requests in flight finish with the previous configuration
+func (r *Registry) UpdateHandler(name string, config *handler.HandlerConfig) error {
+	handler, err := r.GetHandler(name)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.RLock()
+	r.inherit(config)
+	r.mutex.RUnlock()
+
+	if err := handler.Reload(config); err != nil {
+		return fmt.Errorf("failed to update handler %s: %w", name, err)
+	}
+	return nil
+}
+
 // ListHandlers returns all registered handler names
 func (r *Registry) ListHandlers() []string {
 	r.mutex.RLock()
@@ -134,6 +226,43 @@ func (r *Registry) ListHandlers() []string {
 	return names
 }
 
+// RegisterTenant adds or updates a tenant shared by all handlers
+// Requests are scoped to it with tenant.WithID; a dedicated bucket is created when missing
+func (r *Registry) RegisterTenant(ctx context.Context, tenantID string, config tenant.Config) (*tenant.Tenant, error) {
+	if config.BucketName != "" && config.BucketName != r.config.BucketName {
+		if r.client == nil {
+			return nil, &errors.StorageError{Code: "NOT_INITIALIZED", Message: "Registry not initialized"}
+		}
+
+		exists, err := r.client.BucketExists(ctx, config.BucketName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check bucket existence: %w", err)
+		}
+		if !exists {
+			if err := r.client.MakeBucket(ctx, config.BucketName, minio.MakeBucketOptions{Region: r.config.Region}); err != nil {
+				return nil, fmt.Errorf("failed to create bucket %s: %w", config.BucketName, err)
+			}
+		}
+	}
+
+	return r.tenants.Add(tenantID, config)
+}
+
+// RemoveTenant unregisters a tenant, its files are kept
+func (r *Registry) RemoveTenant(tenantID string) {
+	r.tenants.Remove(tenantID)
+}
+
+// GetTenant retrieves a registered tenant
+func (r *Registry) GetTenant(tenantID string) (*tenant.Tenant, error) {
+	return r.tenants.Get(tenantID)
+}
+
+// Tenants returns the tenant directory used by handlers without their own resolver
+func (r *Registry) Tenants() *tenant.Directory {
+	return r.tenants
+}
+
 // GetConfig returns the storage configuration
 func (r *Registry) GetConfig() config.StorageConfig {
 	return r.config
@@ -144,23 +273,57 @@ func (r *Registry) GetClient() *minio.Client {
 	return r.client
 }
 
-// Close closes all handlers and the MinIO client
+// Close closes all handlers and the MinIO client, waiting for their work without a deadline
 func (r *Registry) Close() error {
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
+	return r.Shutdown(context.Background())
+}
 
-	// Close all handlers
-	for _, handler := range r.handlers {
-		if err := handler.Close(); err != nil {
-			// Log error but continue closing other handlers
-			fmt.Printf("Error closing handler %s: %v\n", handler.Name, err)
-		}
+// Shutdown stops accepting new handlers and operations, then closes all handlers in parallel:
+// in-flight operations finish, queued background jobs are drained, middleware routines stop
+// and pending events are delivered. Work still running when ctx expires is cancelled
+func (r *Registry) Shutdown(ctx context.Context) error {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return nil
+	}
+	r.closed = true
+	handlers := make([]*handler.Handler, 0, len(r.handlers))
+	for _, h := range r.handlers {
+		handlers = append(handlers, h)
+	}
+	r.mutex.Unlock()
+
+	// Handlers stay registered while closing, their operations fail with ErrHandlerClosed
+	var wg sync.WaitGroup
+	errs := make(chan error, len(handlers))
+	for _, h := range handlers {
+		wg.Add(1)
+		go func(h *handler.Handler) {
+			defer wg.Done()
+			if err := h.Close(ctx); err != nil {
+				// Log error but continue closing other handlers
+				logger.OrDefault(r.config.Logger).Error("failed to close handler", map[string]interface{}{
+					"handler": h.Name,
+					"error":   err,
+				})
+				errs <- fmt.Errorf("failed to close handler %s: %w", h.Name, err)
+			}
+		}(h)
 	}
+	wg.Wait()
+	close(errs)
 
-	// Clear handlers map
+	r.mutex.Lock()
 	r.handlers = make(map[string]*handler.Handler)
+	r.mutex.Unlock()
 
-	return nil
+	if r.transport != nil {
+		r.transport.CloseIdleConnections()
+	}
+
+	// Report the first failure, all of them are logged
+	return <-errs
 }
 
 // HealthCheck performs a health check on the storage system
@@ -188,24 +351,6 @@ func (r *Registry) HealthCheck(ctx context.Context) error {
 	return nil
 }
 
-// GetStats returns statistics about the registry
-func (r *Registry) GetStats() map[string]interface{} {
-	r.mutex.RLock()
-	defer r.mutex.RUnlock()
-
-	stats := map[string]interface{}{
-		"handlers_count": len(r.handlers),
-		"handlers":       make([]string, 0, len(r.handlers)),
-		"config":         r.config,
-	}
-
-	for name := range r.handlers {
-		stats["handlers"] = append(stats["handlers"].([]string), name)
-	}
-
-	return stats
-}
-
 // executeWithRetry executes a function with retry logic
 func (r *Registry) executeWithRetry(ctx context.Context, operation func() error) error {
 	var lastErr error
@@ -0,0 +1,175 @@
+package registry
+
+import (
+	"context"
+	"time"
+
+	"github.com/darmawan01/storage/handler"
+	"github.com/darmawan01/storage/middleware"
+)
+
+// StatsReport represents the statistics of a registry and all of its handlers
+type StatsReport struct {
+	Timestamp    time.Time                `json:"timestamp"`
+	Performance  PerformanceStats         `json:"performance"` // Operations of all handlers
+	Handlers     map[string]*HandlerStats `json:"handlers"`
+	Tenants      []string                 `json:"tenants"`
+	OpenCircuits []string                 `json:"open_circuits"` // Handlers whose circuit breaker is not closed
+	Endpoint     string                   `json:"endpoint"`
+	BucketName   string                   `json:"bucket_name"`
+}
+
+// HandlerStats represents the statistics of a handler
+type HandlerStats struct {
+	Bucket         string                        `json:"bucket"`
+	Performance    PerformanceStats              `json:"performance"` // Operations of all categories
+	Categories     map[string]PerformanceStats   `json:"categories"`  // Categories with a monitoring middleware
+	Cache          map[string]interface{}        `json:"cache,omitempty"`
+	Async          map[string]interface{}        `json:"async,omitempty"`
+	CircuitBreaker handler.CircuitBreakerStats   `json:"circuit_breaker"`
+	Replication    *handler.ReplicationStats     `json:"replication,omitempty"`
+	Series         []middleware.MonitoringSeries `json:"-"` // Stats of the monitoring middlewares as collected
+}
+
+// PerformanceStats represents the operations counted by monitoring middlewares, combined across
+// categories or handlers; latency percentiles are those of the recent latency window
+type PerformanceStats struct {
+	TotalOperations int64                                `json:"total_operations"`
+	SuccessfulOps   int64                                `json:"successful_ops"`
+	FailedOps       int64                                `json:"failed_ops"`
+	SuccessRate     float64                              `json:"success_rate"`
+	BytesProcessed  int64                                `json:"bytes_processed"`
+	P50Latency      time.Duration                        `json:"p50_latency"`
+	P90Latency      time.Duration                        `json:"p90_latency"`
+	P99Latency      time.Duration                        `json:"p99_latency"`
+	Operations      map[string]middleware.OperationStats `json:"operations"`
+	ErrorCounts     map[string]int64                     `json:"error_counts"`
+}
+
+// GetStats aggregates the monitoring, cache, background job and circuit breaker statistics of all
+// handlers into one report; ctx bounds the collection, an interrupted one returns ctx's error
+func (r *Registry) GetStats(ctx context.Context) (*StatsReport, error) {
+	report := &StatsReport{
+		Timestamp:    time.Now(),
+		Handlers:     make(map[string]*HandlerStats),
+		Tenants:      r.tenants.List(),
+		OpenCircuits: []string{},
+		Endpoint:     r.config.Endpoint,
+		BucketName:   r.config.BucketName,
+	}
+
+	total := newPerformanceTotals()
+	for _, h := range r.sortedHandlers() {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		stats := &HandlerStats{
+			Bucket:         h.BucketName,
+			Categories:     make(map[string]PerformanceStats),
+			Cache:          h.CacheStats(),
+			CircuitBreaker: h.CircuitBreakerStats(),
+			Series:         h.MonitoringSeries(),
+		}
+		if h.AsyncProcessor != nil {
+			stats.Async = h.AsyncProcessor.GetStats()
+		}
+		if h.ReplicationEnabled() {
+			replication := h.GetReplicationStats()
+			stats.Replication = &replication
+		}
+		if stats.CircuitBreaker.State != handler.CircuitClosed {
+			report.OpenCircuits = append(report.OpenCircuits, h.Name)
+		}
+
+		handlerTotal := newPerformanceTotals()
+		for _, series := range stats.Series {
+			category := newPerformanceTotals()
+			category.add(series)
+			stats.Categories[series.Labels[middleware.LabelCategory]] = category.stats()
+			handlerTotal.add(series)
+			total.add(series)
+		}
+		stats.Performance = handlerTotal.stats()
+		report.Handlers[h.Name] = stats
+	}
+	report.Performance = total.stats()
+	return report, nil
+}
+
+// performanceTotals combines monitoring series into PerformanceStats
+type performanceTotals struct {
+	operations  map[string]middleware.OperationStats
+	latencies   map[string]*middleware.LatencyHistogram
+	errorCounts map[string]int64
+}
+
+// newPerformanceTotals creates empty totals
+func newPerformanceTotals() *performanceTotals {
+	return &performanceTotals{
+		operations:  make(map[string]middleware.OperationStats),
+		latencies:   make(map[string]*middleware.LatencyHistogram),
+		errorCounts: make(map[string]int64),
+	}
+}
+
+// add adds the operations of a monitoring series
+func (t *performanceTotals) add(series middleware.MonitoringSeries) {
+	for operation, stats := range series.Operations {
+		combined := t.operations[operation]
+		combined.Count += stats.Count
+		combined.SuccessCount += stats.SuccessCount
+		combined.ErrorCount += stats.ErrorCount
+		combined.TotalLatency += stats.TotalLatency
+		combined.BytesProcessed += stats.BytesProcessed
+		if combined.MinLatency == 0 || (stats.MinLatency > 0 && stats.MinLatency < combined.MinLatency) {
+			combined.MinLatency = stats.MinLatency
+		}
+		if stats.MaxLatency > combined.MaxLatency {
+			combined.MaxLatency = stats.MaxLatency
+		}
+		if stats.LastOperation.After(combined.LastOperation) {
+			combined.LastOperation = stats.LastOperation
+		}
+		t.operations[operation] = combined
+	}
+	for operation, latencies := range series.Latencies {
+		if t.latencies[operation] == nil {
+			t.latencies[operation] = middleware.NewLatencyHistogram()
+		}
+		t.latencies[operation].Merge(latencies)
+	}
+	for code, count := range series.ErrorCounts {
+		t.errorCounts[code] += count
+	}
+}
+
+// stats returns the combined stats, percentiles read from the merged histograms
+func (t *performanceTotals) stats() PerformanceStats {
+	stats := PerformanceStats{
+		Operations:  make(map[string]middleware.OperationStats, len(t.operations)),
+		ErrorCounts: t.errorCounts,
+	}
+	all := middleware.NewLatencyHistogram()
+	for operation, combined := range t.operations {
+		if latencies := t.latencies[operation]; latencies != nil {
+			combined.P50Latency = latencies.Percentile(0.5)
+			combined.P90Latency = latencies.Percentile(0.9)
+			combined.P99Latency = latencies.Percentile(0.99)
+			all.Merge(latencies)
+		}
+		stats.Operations[operation] = combined
+
+		stats.TotalOperations += combined.Count
+		stats.SuccessfulOps += combined.SuccessCount
+		stats.FailedOps += combined.ErrorCount
+		stats.BytesProcessed += combined.BytesProcessed
+	}
+	if stats.TotalOperations > 0 {
+		stats.SuccessRate = float64(stats.SuccessfulOps) / float64(stats.TotalOperations)
+	}
+	stats.P50Latency = all.Percentile(0.5)
+	stats.P90Latency = all.Percentile(0.9)
+	stats.P99Latency = all.Percentile(0.99)
+	return stats
+}
@@ -0,0 +1,20 @@
+package requestid
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// Gin returns gin middleware giving every request an ID, see FromRequest
+// The ID is stored in the request context, as gin key request_id and in the response Header
+func Gin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := FromContext(c.Request.Context())
+		if id == "" {
+			id = FromRequest(c.Request)
+			c.Request = c.Request.WithContext(WithID(c.Request.Context(), id))
+		}
+		c.Set("request_id", id)
+		c.Header(Header, id)
+		c.Next()
+	}
+}
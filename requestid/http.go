@@ -0,0 +1,31 @@
+package requestid
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// validID limits request IDs taken from clients, others are replaced so they cannot forge log lines
+var validID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// FromRequest returns the request ID a client sent in Header, a new one when it sent none or an invalid one
+func FromRequest(r *http.Request) string {
+	if id := r.Header.Get(Header); validID.MatchString(id) {
+		return id
+	}
+	return New()
+}
+
+// Middleware returns net/http middleware giving every request an ID, see FromRequest
+// The ID is stored in the request context and returned in the response Header
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := FromContext(r.Context())
+		if id == "" {
+			id = FromRequest(r)
+			r = r.WithContext(WithID(r.Context(), id))
+		}
+		w.Header().Set(Header, id)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,40 @@
+// Package requestid carries the ID correlating the storage operations of a request, so logs,
+// audit events, background jobs and errors of the storage can be matched with the request of
+// the API that made them
+package requestid
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header request IDs are read from and returned in
+const Header = "X-Request-ID"
+
+type contextKey struct{}
+
+// WithID returns a context carrying a request ID
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request ID set by WithID, empty when there is none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// New returns a new random request ID
+func New() string {
+	return uuid.NewString()
+}
+
+// Ensure returns a context carrying a request ID and the ID, a new one when ctx has none
+func Ensure(ctx context.Context) (context.Context, string) {
+	if id := FromContext(ctx); id != "" {
+		return ctx, id
+	}
+	id := New()
+	return WithID(ctx, id), id
+}
@@ -0,0 +1,210 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// ElasticsearchConfig represents Elasticsearch (or OpenSearch) index configuration
+type ElasticsearchConfig struct {
+	URL      string `json:"url"`   // e.g. https://elasticsearch:9200
+	Index    string `json:"index"` // Index name, default "storage-documents"
+	Username string `json:"username,omitempty"`
+	Password string `json:"-"`
+	APIKey   string `json:"-"` // Base64 encoded API key, used instead of Username and Password
+
+	HTTPClient *http.Client `json:"-"`
+}
+
+// ElasticsearchIndex indexes documents in Elasticsearch through its REST API
+// Call CreateIndex once to set up the field mappings the search filters rely on
+type ElasticsearchIndex struct {
+	config ElasticsearchConfig
+}
+
+// elasticsearchMapping maps the filter fields as keywords and the text for full-text search
+var elasticsearchMapping = map[string]interface{}{
+	"mappings": map[string]interface{}{
+		"properties": map[string]interface{}{
+			"file_key":     map[string]string{"type": "keyword"},
+			"file_name":    map[string]string{"type": "text"},
+			"content_type": map[string]string{"type": "keyword"},
+			"namespace":    map[string]string{"type": "keyword"},
+			"category":     map[string]string{"type": "keyword"},
+			"entity_type":  map[string]string{"type": "keyword"},
+			"entity_id":    map[string]string{"type": "keyword"},
+			"text":         map[string]string{"type": "text"},
+		},
+	},
+}
+
+// NewElasticsearchIndex creates a new Elasticsearch index
+func NewElasticsearchIndex(config ElasticsearchConfig) (*ElasticsearchIndex, error) {
+	if config.URL == "" {
+		return nil, ErrInvalidConfig
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	if config.Index == "" {
+		config.Index = "storage-documents"
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &ElasticsearchIndex{config: config}, nil
+}
+
+// CreateIndex creates the index with its mappings, an existing index is left as it is
+func (i *ElasticsearchIndex) CreateIndex(ctx context.Context) error {
+	err := i.call(ctx, http.MethodPut, "/"+url.PathEscape(i.config.Index), elasticsearchMapping, nil)
+	if err != nil && !strings.Contains(err.Error(), "resource_already_exists_exception") {
+		return fmt.Errorf("failed to create search index: %w", err)
+	}
+	return nil
+}
+
+// Index stores a document under its file key
+func (i *ElasticsearchIndex) Index(ctx context.Context, document *Document) error {
+	if err := i.call(ctx, http.MethodPut, i.documentPath(document.FileKey), document, nil); err != nil {
+		return fmt.Errorf("failed to index %s: %w", document.FileKey, err)
+	}
+	return nil
+}
+
+// Delete removes the document of a file
+func (i *ElasticsearchIndex) Delete(ctx context.Context, fileKey string) error {
+	err := i.call(ctx, http.MethodDelete, i.documentPath(fileKey), nil, nil)
+	if err != nil && !strings.Contains(err.Error(), "status 404") {
+		return fmt.Errorf("failed to remove %s from the search index: %w", fileKey, err)
+	}
+	return nil
+}
+
+// elasticsearchResponse is the part of search responses used
+type elasticsearchResponse struct {
+	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
+		Hits []struct {
+			Score     float64             `json:"_score"`
+			Source    Document            `json:"_source"`
+			Highlight map[string][]string `json:"highlight"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+// Search returns the documents whose text matches all words of the query, by relevance
+func (i *ElasticsearchIndex) Search(ctx context.Context, req *interfaces.SearchRequest) (*interfaces.SearchResponse, error) {
+	filters := []interface{}{}
+	if req.Prefix != "" {
+		filters = append(filters, map[string]interface{}{"prefix": map[string]string{"file_key": req.Prefix}})
+	}
+	for field, value := range map[string]string{
+		"namespace":   req.Namespace,
+		"category":    req.Category,
+		"entity_type": req.EntityType,
+		"entity_id":   req.EntityID,
+	} {
+		if value != "" {
+			filters = append(filters, map[string]interface{}{"term": map[string]string{field: value}})
+		}
+	}
+
+	query := map[string]interface{}{
+		"from":    max(req.Offset, 0),
+		"size":    req.Limit,
+		"_source": []string{"file_key", "file_name", "content_type"},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"must": map[string]interface{}{
+					"match": map[string]interface{}{
+						"text": map[string]string{"query": req.Query, "operator": "and"},
+					},
+				},
+				"filter": filters,
+			},
+		},
+		"highlight": map[string]interface{}{
+			"fields": map[string]interface{}{
+				"text": map[string]int{"number_of_fragments": maxHighlights},
+			},
+		},
+	}
+
+	var result elasticsearchResponse
+	if err := i.call(ctx, http.MethodPost, "/"+url.PathEscape(i.config.Index)+"/_search", query, &result); err != nil {
+		return nil, fmt.Errorf("failed to search documents: %w", err)
+	}
+
+	response := &interfaces.SearchResponse{
+		Success: true,
+		Hits:    make([]interfaces.SearchHit, 0, len(result.Hits.Hits)),
+		Total:   result.Hits.Total.Value,
+	}
+	for _, hit := range result.Hits.Hits {
+		response.Hits = append(response.Hits, interfaces.SearchHit{
+			FileKey:     hit.Source.FileKey,
+			FileName:    hit.Source.FileName,
+			ContentType: hit.Source.ContentType,
+			Score:       hit.Score,
+			Highlights:  hit.Highlight["text"],
+		})
+	}
+	return response, nil
+}
+
+// documentPath returns the path of the document of a file, file keys contain slashes
+func (i *ElasticsearchIndex) documentPath(fileKey string) string {
+	return "/" + url.PathEscape(i.config.Index) + "/_doc/" + url.PathEscape(fileKey)
+}
+
+// call sends a JSON request to Elasticsearch and decodes the JSON response into out when set
+func (i *ElasticsearchIndex) call(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		encoded, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		body = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, i.config.URL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	switch {
+	case i.config.APIKey != "":
+		req.Header.Set("Authorization", "ApiKey "+i.config.APIKey)
+	case i.config.Username != "":
+		req.SetBasicAuth(i.config.Username, i.config.Password)
+	}
+
+	resp, err := i.config.HTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// Error bodies are small, keep a bounded excerpt for the error message
+	if resp.StatusCode >= 300 {
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("request returned status %d: %s", resp.StatusCode, bytes.TrimSpace(excerpt))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
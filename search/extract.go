@@ -0,0 +1,337 @@
+package search
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// maxPartSize bounds the decompressed size of a single document part or PDF stream
+const maxPartSize = 64 * 1024 * 1024
+
+// TextExtractor reads text files as they are
+type TextExtractor struct{}
+
+// Supports reports whether a content type is text
+func (TextExtractor) Supports(contentType string) bool {
+	switch media := mediaType(contentType); {
+	case strings.HasPrefix(media, "text/"):
+		return true
+	case media == "application/json", media == "application/xml", media == "application/x-ndjson":
+		return true
+	}
+	return false
+}
+
+// Extract returns the data as text
+func (TextExtractor) Extract(ctx context.Context, contentType string, data io.Reader) (string, error) {
+	text, err := io.ReadAll(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to read text: %w", err)
+	}
+	return string(text), nil
+}
+
+// OfficeExtractor extracts the text of Office Open XML (docx, xlsx, pptx) and OpenDocument files
+type OfficeExtractor struct{}
+
+// officeParts are the parts of a document package holding its text, by content type
+var officeParts = map[string]*regexp.Regexp{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   regexp.MustCompile(`^word/(document|header\d*|footer\d*|footnotes|endnotes)\.xml$`),
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         regexp.MustCompile(`^xl/sharedStrings\.xml$`),
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": regexp.MustCompile(`^ppt/(slides/slide|notesSlides/notesSlide)\d+\.xml$`),
+	"application/vnd.oasis.opendocument.text":                                   regexp.MustCompile(`^content\.xml$`),
+	"application/vnd.oasis.opendocument.spreadsheet":                            regexp.MustCompile(`^content\.xml$`),
+	"application/vnd.oasis.opendocument.presentation":                           regexp.MustCompile(`^content\.xml$`),
+}
+
+// officeBreaks are the elements that end a line of text: paragraphs, table cells and shared strings
+var officeBreaks = map[string]bool{"p": true, "tc": true, "si": true, "h": true, "table-cell": true, "br": true, "tab": true}
+
+// Supports reports whether a content type is a supported Office document
+func (OfficeExtractor) Supports(contentType string) bool {
+	_, ok := officeParts[mediaType(contentType)]
+	return ok
+}
+
+// Extract returns the text of the document parts, in part name order
+// Documents are zip packages, which need random access, so the data is read into memory
+func (OfficeExtractor) Extract(ctx context.Context, contentType string, data io.Reader) (string, error) {
+	parts := officeParts[mediaType(contentType)]
+	if parts == nil {
+		return "", ErrUnsupported
+	}
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to read document: %w", err)
+	}
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open document: %w", err)
+	}
+
+	files := make([]*zip.File, 0, len(archive.File))
+	for _, file := range archive.File {
+		if parts.MatchString(file.Name) {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return naturalLess(files[i].Name, files[j].Name)
+	})
+
+	var text strings.Builder
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		part, err := file.Open()
+		if err != nil {
+			return "", fmt.Errorf("failed to open %s: %w", file.Name, err)
+		}
+		err = xmlText(&text, io.LimitReader(part, maxPartSize))
+		part.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+	}
+	return text.String(), nil
+}
+
+// xmlText writes the character data of an XML document, one line per paragraph
+func xmlText(text *strings.Builder, data io.Reader) error {
+	decoder := xml.NewDecoder(data)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := token.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if officeBreaks[t.Name.Local] {
+				text.WriteByte('\n')
+			}
+		}
+	}
+}
+
+// naturalLess orders names with numbers by value, so slide2 comes before slide10
+func naturalLess(a, b string) bool {
+	trim := func(name string) (string, int) {
+		base := strings.TrimSuffix(path.Base(name), path.Ext(name))
+		digits := len(base)
+		for digits > 0 && base[digits-1] >= '0' && base[digits-1] <= '9' {
+			digits--
+		}
+		return path.Dir(name) + "/" + base[:digits], len(base) - digits
+	}
+	prefixA, digitsA := trim(a)
+	prefixB, digitsB := trim(b)
+	if prefixA != prefixB || digitsA == digitsB {
+		return a < b
+	}
+	return digitsA < digitsB
+}
+
+// PDFExtractor extracts the text shown by PDF content streams
+// It covers documents with simple font encodings; scanned documents and fonts with custom
+// encodings yield little or no text, use TikaExtractor for those
+type PDFExtractor struct{}
+
+// Supports reports whether a content type is PDF
+func (PDFExtractor) Supports(contentType string) bool {
+	return mediaType(contentType) == "application/pdf"
+}
+
+var (
+	pdfStream   = regexp.MustCompile(`(?s)<<(.*?)>>\s*stream\r?\n`)
+	pdfTextFlow = regexp.MustCompile(`(?s)\bBT\b(.*?)\bET\b`)
+)
+
+// Extract returns the text of the literal strings in the text objects of all content streams
+func (PDFExtractor) Extract(ctx context.Context, contentType string, data io.Reader) (string, error) {
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to read document: %w", err)
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(content), []byte("%PDF")) {
+		return "", fmt.Errorf("not a PDF document")
+	}
+
+	var text strings.Builder
+	for _, match := range pdfStream.FindAllSubmatchIndex(content, -1) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		dictionary := content[match[2]:match[3]]
+		start := match[1]
+		end := bytes.Index(content[start:], []byte("endstream"))
+		if end < 0 {
+			break
+		}
+		stream := content[start : start+end]
+
+		// Only FlateDecode is supported, images and other filters are skipped
+		if bytes.Contains(dictionary, []byte("/Filter")) {
+			if !bytes.Contains(dictionary, []byte("/FlateDecode")) {
+				continue
+			}
+			reader, err := zlib.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				continue
+			}
+			// Truncated streams still yield the text decoded so far
+			stream, _ = io.ReadAll(io.LimitReader(reader, maxPartSize))
+			reader.Close()
+		}
+
+		for _, block := range pdfTextFlow.FindAllSubmatch(stream, -1) {
+			pdfStrings(&text, block[1])
+			text.WriteByte('\n')
+		}
+	}
+	return text.String(), nil
+}
+
+// pdfStrings writes the literal strings of a text object, strings of separate operators are
+// separated by spaces while the parts of a TJ array are joined
+func pdfStrings(text *strings.Builder, block []byte) {
+	depth := 0
+	inArray := false
+	for i := 0; i < len(block); i++ {
+		switch c := block[i]; {
+		case c == '[':
+			inArray = true
+		case c == ']':
+			inArray = false
+			text.WriteByte(' ')
+		case c == '(':
+			depth = 1
+			for i++; i < len(block) && depth > 0; i++ {
+				switch c := block[i]; c {
+				case '\\':
+					i++
+					if i < len(block) {
+						text.WriteString(pdfEscape(block, &i))
+					}
+				case '(':
+					depth++
+					text.WriteByte(c)
+				case ')':
+					depth--
+					if depth > 0 {
+						text.WriteByte(c)
+					}
+				default:
+					text.WriteByte(c)
+				}
+			}
+			i--
+			if !inArray {
+				text.WriteByte(' ')
+			}
+		}
+	}
+}
+
+// pdfEscape decodes the escape sequence at block[*i], leaving *i on its last byte
+func pdfEscape(block []byte, i *int) string {
+	switch c := block[*i]; c {
+	case 'n', 'r':
+		return "\n"
+	case 't':
+		return "\t"
+	case 'b', 'f', '\r', '\n':
+		return ""
+	case '0', '1', '2', '3', '4', '5', '6', '7':
+		value := 0
+		for digits := 0; digits < 3 && *i < len(block) && block[*i] >= '0' && block[*i] <= '7'; digits++ {
+			value = value*8 + int(block[*i]-'0')
+			*i++
+		}
+		*i--
+		return string(rune(value))
+	default:
+		return string(c)
+	}
+}
+
+// TikaConfig represents Apache Tika server configuration
+type TikaConfig struct {
+	URL string `json:"url"` // e.g. http://tika:9998
+	// Extracted types, entries ending in "/", "." or "-" match by prefix; default PDF and Office documents
+	ContentTypes []string     `json:"content_types,omitempty"`
+	HTTPClient   *http.Client `json:"-"`
+}
+
+// TikaExtractor extracts text with an Apache Tika server, which handles far more formats
+// than the built-in extractors, including OCR of scanned documents when Tika is set up for it
+type TikaExtractor struct {
+	config TikaConfig
+}
+
+// NewTikaExtractor creates a new Tika extractor
+func NewTikaExtractor(config TikaConfig) (*TikaExtractor, error) {
+	if config.URL == "" {
+		return nil, ErrInvalidConfig
+	}
+	config.URL = strings.TrimSuffix(config.URL, "/")
+	if len(config.ContentTypes) == 0 {
+		config.ContentTypes = []string{"application/pdf", "application/msword", "application/vnd.ms-", "application/vnd.openxmlformats-officedocument.", "application/vnd.oasis.opendocument.", "application/rtf"}
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	return &TikaExtractor{config: config}, nil
+}
+
+// Supports reports whether a content type is configured for Tika
+func (e *TikaExtractor) Supports(contentType string) bool {
+	media := mediaType(contentType)
+	for _, allowed := range e.config.ContentTypes {
+		if media == allowed || (strings.ContainsAny(allowed[len(allowed)-1:], "/.-") && strings.HasPrefix(media, allowed)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Extract sends the data to Tika's /tika endpoint and returns the plain text
+func (e *TikaExtractor) Extract(ctx context.Context, contentType string, data io.Reader) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, e.config.URL+"/tika", data)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "text/plain")
+
+	resp, err := e.config.HTTPClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract text with Tika: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("failed to extract text with Tika: status %d: %s", resp.StatusCode, bytes.TrimSpace(excerpt))
+	}
+
+	text, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read Tika response: %w", err)
+	}
+	return string(text), nil
+}
@@ -0,0 +1,207 @@
+package search
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/darmawan01/storage/interfaces"
+)
+
+// Highlight settings of MemoryIndex
+const (
+	maxHighlights    = 3
+	highlightContext = 60 // Characters around a match
+)
+
+// MemoryIndex is an in-process full-text index, documents are lost on restart
+// Every search scans all documents, so it suits development and small deployments
+type MemoryIndex struct {
+	documents map[string]*memoryDocument
+	mutex     sync.RWMutex
+}
+
+// memoryDocument is an indexed document with its word counts
+type memoryDocument struct {
+	document Document
+	words    map[string]int
+}
+
+// NewMemoryIndex creates a new in-memory index
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		documents: make(map[string]*memoryDocument),
+	}
+}
+
+// Index stores a document, replacing the one of the same file key
+func (i *MemoryIndex) Index(ctx context.Context, document *Document) error {
+	words := make(map[string]int)
+	for _, word := range tokenize(document.Text) {
+		words[word]++
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.documents[document.FileKey] = &memoryDocument{document: *document, words: words}
+	return nil
+}
+
+// Delete removes the document of a file
+func (i *MemoryIndex) Delete(ctx context.Context, fileKey string) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	delete(i.documents, fileKey)
+	return nil
+}
+
+// Search returns the documents containing all words of the query, by number of occurrences
+func (i *MemoryIndex) Search(ctx context.Context, req *interfaces.SearchRequest) (*interfaces.SearchResponse, error) {
+	terms := tokenize(req.Query)
+	response := &interfaces.SearchResponse{Success: true, Hits: []interfaces.SearchHit{}}
+	if len(terms) == 0 {
+		return response, nil
+	}
+
+	i.mutex.RLock()
+	var matches []*memoryDocument
+	var scores []float64
+	for _, indexed := range i.documents {
+		document := &indexed.document
+		if !strings.HasPrefix(document.FileKey, req.Prefix) ||
+			(req.Namespace != "" && document.Namespace != req.Namespace) ||
+			(req.Category != "" && document.Category != req.Category) ||
+			(req.EntityType != "" && document.EntityType != req.EntityType) ||
+			(req.EntityID != "" && document.EntityID != req.EntityID) {
+			continue
+		}
+		score := 0
+		for _, term := range terms {
+			count := indexed.words[term]
+			if count == 0 {
+				score = 0
+				break
+			}
+			score += count
+		}
+		if score > 0 {
+			matches = append(matches, indexed)
+			scores = append(scores, float64(score))
+		}
+	}
+	i.mutex.RUnlock()
+
+	order := make([]int, len(matches))
+	for n := range order {
+		order[n] = n
+	}
+	sort.Slice(order, func(a, b int) bool {
+		if scores[order[a]] != scores[order[b]] {
+			return scores[order[a]] > scores[order[b]]
+		}
+		return matches[order[a]].document.FileKey < matches[order[b]].document.FileKey
+	})
+
+	response.Total = len(order)
+	offset := min(max(req.Offset, 0), len(order))
+	end := len(order)
+	if req.Limit > 0 {
+		end = min(offset+req.Limit, end)
+	}
+	for _, n := range order[offset:end] {
+		document := &matches[n].document
+		response.Hits = append(response.Hits, interfaces.SearchHit{
+			FileKey:     document.FileKey,
+			FileName:    document.FileName,
+			ContentType: document.ContentType,
+			Score:       scores[n],
+			Highlights:  highlights(document.Text, terms),
+		})
+	}
+	return response, nil
+}
+
+// tokenize returns the lower case words of a text
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isWordRune(r)
+	})
+}
+
+// isWordRune reports whether a rune is part of a word
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// highlights returns snippets around the first matches of the terms, matches wrapped in <em></em>
+func highlights(text string, terms []string) []string {
+	wanted := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		wanted[term] = true
+	}
+
+	var snippets []string
+	covered := 0
+	runes := []rune(text)
+	for start := 0; start < len(runes) && len(snippets) < maxHighlights; {
+		// Find the next word
+		for start < len(runes) && !isWordRune(runes[start]) {
+			start++
+		}
+		end := start
+		for end < len(runes) && isWordRune(runes[end]) {
+			end++
+		}
+		if end == start {
+			break
+		}
+		if start < covered || !wanted[strings.ToLower(string(runes[start:end]))] {
+			start = end
+			continue
+		}
+
+		// Snippets neither start nor end within a word
+		from := max(start-highlightContext, covered)
+		for from > 0 && from < start && isWordRune(runes[from-1]) {
+			from++
+		}
+		to := min(end+highlightContext, len(runes))
+		for to < len(runes) && isWordRune(runes[to]) {
+			to++
+		}
+		snippets = append(snippets, strings.TrimSpace(markTerms(runes[from:to], wanted)))
+		covered = to
+		start = to
+	}
+	return snippets
+}
+
+// markTerms wraps the wanted words of a snippet in <em></em>
+func markTerms(snippet []rune, wanted map[string]bool) string {
+	var marked strings.Builder
+	for i := 0; i < len(snippet); {
+		if !isWordRune(snippet[i]) {
+			// Snippets are single lines
+			if unicode.IsSpace(snippet[i]) {
+				marked.WriteByte(' ')
+			} else {
+				marked.WriteRune(snippet[i])
+			}
+			i++
+			continue
+		}
+		end := i
+		for end < len(snippet) && isWordRune(snippet[end]) {
+			end++
+		}
+		word := string(snippet[i:end])
+		if wanted[strings.ToLower(word)] {
+			word = "<em>" + word + "</em>"
+		}
+		marked.WriteString(word)
+		i = end
+	}
+	return marked.String()
+}
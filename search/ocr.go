@@ -0,0 +1,156 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// OCREngine recognizes the text of images and scanned documents
+type OCREngine interface {
+	// Supports reports whether files of a content type can be recognized
+	Supports(contentType string) bool
+	Recognize(ctx context.Context, contentType string, data io.Reader) (string, error)
+}
+
+// TesseractConfig represents the tesseract and pdftoppm executables used for OCR
+type TesseractConfig struct {
+	Path         string   `json:"path,omitempty"`          // tesseract executable, default "tesseract" in PATH
+	Languages    []string `json:"languages,omitempty"`     // Trained languages, e.g. ["eng", "deu"], default eng
+	PDFToPPMPath string   `json:"pdftoppm_path,omitempty"` // pdftoppm executable of poppler, PDFs are not supported when empty
+	DPI          int      `json:"dpi,omitempty"`           // Resolution of rendered PDF pages, default 300
+	MaxPages     int      `json:"max_pages,omitempty"`     // PDF pages recognized, default 20
+}
+
+// TesseractEngine recognizes text by running Tesseract, PDF pages are rendered with pdftoppm first
+type TesseractEngine struct {
+	config TesseractConfig
+}
+
+// tesseractTypes are the image types Tesseract reads through Leptonica
+var tesseractTypes = map[string]bool{
+	"image/png": true, "image/jpeg": true, "image/tiff": true, "image/bmp": true, "image/webp": true, "image/gif": true,
+}
+
+// NewTesseractEngine creates a new Tesseract engine
+func NewTesseractEngine(config TesseractConfig) *TesseractEngine {
+	if config.Path == "" {
+		config.Path = "tesseract"
+	}
+	if len(config.Languages) == 0 {
+		config.Languages = []string{"eng"}
+	}
+	if config.DPI <= 0 {
+		config.DPI = 300
+	}
+	if config.MaxPages <= 0 {
+		config.MaxPages = 20
+	}
+	return &TesseractEngine{config: config}
+}
+
+// Supports reports whether a content type is an image Tesseract reads, or PDF when pdftoppm is set
+func (e *TesseractEngine) Supports(contentType string) bool {
+	media := mediaType(contentType)
+	return tesseractTypes[media] || (media == "application/pdf" && e.config.PDFToPPMPath != "")
+}
+
+// Recognize returns the text of an image, or of the pages of a PDF separated by form feeds
+func (e *TesseractEngine) Recognize(ctx context.Context, contentType string, data io.Reader) (string, error) {
+	if !e.Supports(contentType) {
+		return "", ErrUnsupported
+	}
+	if mediaType(contentType) != "application/pdf" {
+		return e.tesseract(ctx, data)
+	}
+
+	dir, err := os.MkdirTemp("", "ocr-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create page directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// pdftoppm reads the document from stdin and writes page-1.png, page-2.png, ...
+	render := exec.CommandContext(ctx, e.config.PDFToPPMPath, "-png", "-r", strconv.Itoa(e.config.DPI),
+		"-l", strconv.Itoa(e.config.MaxPages), "-", filepath.Join(dir, "page"))
+	render.Stdin = data
+	if output, err := render.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("failed to render PDF pages: %w: %s", err, bytes.TrimSpace(output))
+	}
+	pages, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+	if err != nil {
+		return "", err
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return naturalLess(pages[i], pages[j])
+	})
+
+	texts := make([]string, 0, len(pages))
+	for _, page := range pages {
+		file, err := os.Open(page)
+		if err != nil {
+			return "", fmt.Errorf("failed to open rendered page: %w", err)
+		}
+		text, err := e.tesseract(ctx, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+		texts = append(texts, text)
+	}
+	return strings.Join(texts, "\f"), nil
+}
+
+// tesseract recognizes the text of one image read from stdin
+func (e *TesseractEngine) tesseract(ctx context.Context, data io.Reader) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.config.Path, "stdin", "stdout", "-l", strings.Join(e.config.Languages, "+"))
+	cmd.Stdin = data
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to recognize text with tesseract: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return stdout.String(), nil
+}
+
+// OCRExtractor extracts the text of images and scanned documents with an OCR engine
+// Documents the Fallback extractor supports, e.g. PDFs with a text layer, are recognized only when
+// it finds no text, so it can replace the default extractor of a search configuration
+type OCRExtractor struct {
+	Engine   OCREngine
+	Fallback Extractor
+}
+
+// Supports reports whether the engine or the fallback supports a content type
+func (e OCRExtractor) Supports(contentType string) bool {
+	return e.Engine.Supports(contentType) || (e.Fallback != nil && e.Fallback.Supports(contentType))
+}
+
+// Extract returns the text of the fallback extractor, or the text recognized by the engine
+func (e OCRExtractor) Extract(ctx context.Context, contentType string, data io.Reader) (string, error) {
+	if e.Fallback == nil || !e.Fallback.Supports(contentType) {
+		return e.Engine.Recognize(ctx, contentType, data)
+	}
+	if !e.Engine.Supports(contentType) {
+		return e.Fallback.Extract(ctx, contentType, data)
+	}
+
+	// Both read the data, so it is kept in memory
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to read document: %w", err)
+	}
+	text, err := e.Fallback.Extract(ctx, contentType, bytes.NewReader(content))
+	if err == nil && strings.TrimSpace(text) != "" {
+		return text, nil
+	}
+	return e.Engine.Recognize(ctx, contentType, bytes.NewReader(content))
+}
@@ -0,0 +1,82 @@
+// Package search indexes the text of uploaded documents for full-text search
+// Extractors turn files into plain text and indexes store and search it; both are pluggable,
+// MemoryIndex serves development and ElasticsearchIndex production deployments
+package search
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+	"github.com/darmawan01/storage/interfaces"
+)
+
+var (
+	ErrInvalidConfig = &errors.StorageError{Code: "INVALID_CONFIG", Message: "Invalid search configuration"}
+	ErrUnsupported   = &errors.StorageError{Code: "UNSUPPORTED_TYPE", Message: "No text extractor for this file type"}
+)
+
+// Document is the indexed text of a file with the fields searches filter on
+type Document struct {
+	FileKey     string `json:"file_key"`
+	FileName    string `json:"file_name,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Category    string `json:"category,omitempty"`
+	EntityType  string `json:"entity_type,omitempty"`
+	EntityID    string `json:"entity_id,omitempty"`
+	Text        string `json:"text"`
+}
+
+// Index stores documents and searches their text
+// Index replaces the document of a file key, Delete of an unknown key is not an error
+type Index interface {
+	Index(ctx context.Context, document *Document) error
+	Delete(ctx context.Context, fileKey string) error
+	Search(ctx context.Context, req *interfaces.SearchRequest) (*interfaces.SearchResponse, error)
+}
+
+// Extractor turns the data of a file into plain text
+type Extractor interface {
+	// Supports reports whether files of a content type can be extracted
+	Supports(contentType string) bool
+	Extract(ctx context.Context, contentType string, data io.Reader) (string, error)
+}
+
+// Extractors tries extractors in order, the first supporting a content type extracts it
+type Extractors []Extractor
+
+// DefaultExtractor extracts plain text, PDF and Office documents without external services
+func DefaultExtractor() Extractors {
+	return Extractors{TextExtractor{}, PDFExtractor{}, OfficeExtractor{}}
+}
+
+// Supports reports whether any extractor supports a content type
+func (e Extractors) Supports(contentType string) bool {
+	return e.extractor(contentType) != nil
+}
+
+// Extract extracts the text with the first extractor supporting the content type
+func (e Extractors) Extract(ctx context.Context, contentType string, data io.Reader) (string, error) {
+	extractor := e.extractor(contentType)
+	if extractor == nil {
+		return "", ErrUnsupported
+	}
+	return extractor.Extract(ctx, contentType, data)
+}
+
+// extractor returns the first extractor supporting a content type
+func (e Extractors) extractor(contentType string) Extractor {
+	for _, extractor := range e {
+		if extractor.Supports(contentType) {
+			return extractor
+		}
+	}
+	return nil
+}
+
+// mediaType returns the lower case media type of a content type, without parameters
+func mediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
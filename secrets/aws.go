@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+// AWSConfig represents AWS Secrets Manager secret provider configuration
+type AWSConfig struct {
+	Region string `json:"region"`
+	// API endpoint, default https://secretsmanager.<region>.amazonaws.com
+	Endpoint string `json:"endpoint,omitempty"`
+	// Version stage read, default AWSCURRENT
+	VersionStage string `json:"version_stage,omitempty"`
+
+	// Credentials signs requests, e.g. the Credentials of config.LoadDefaultConfig
+	Credentials aws.CredentialsProvider `json:"-"`
+	HTTPClient  *http.Client            `json:"-"`
+}
+
+// AWSProvider reads secrets from AWS Secrets Manager
+// Names are secret IDs or ARNs with an optional key of a JSON secret, e.g. "prod/minio#secret_key"
+type AWSProvider struct {
+	config AWSConfig
+	signer *v4.Signer
+}
+
+// NewAWSProvider creates a new AWS Secrets Manager secret provider
+func NewAWSProvider(config AWSConfig) (*AWSProvider, error) {
+	if config.Region == "" || config.Credentials == nil {
+		return nil, ErrInvalidConfig
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = "https://secretsmanager." + config.Region + ".amazonaws.com"
+	}
+	if config.VersionStage == "" {
+		config.VersionStage = "AWSCURRENT"
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+
+	return &AWSProvider{
+		config: config,
+		signer: v4.NewSigner(),
+	}, nil
+}
+
+// GetSecret reads the current version of a secret, or a key of it when the secret is JSON
+func (p *AWSProvider) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	secretID, key := splitField(name)
+
+	body, err := json.Marshal(map[string]string{
+		"SecretId":     secretID,
+		"VersionStage": p.config.VersionStage,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.Endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+
+	credentials, err := p.config.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+	hash := sha256.Sum256(body)
+	if err := p.signer.SignHTTP(ctx, credentials, req, hex.EncodeToString(hash[:]), "secretsmanager", p.config.Region, time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	var resp struct {
+		SecretString *string `json:"SecretString"`
+		SecretBinary []byte  `json:"SecretBinary"` // Base64 in JSON, decoded by encoding/json
+	}
+	if err := doJSON(p.config.HTTPClient, req, &resp); err != nil {
+		// Missing secrets are reported as 400 ResourceNotFoundException
+		if err == errStatusNotFound || strings.Contains(err.Error(), "ResourceNotFoundException") {
+			return nil, notFound(name)
+		}
+		return nil, fmt.Errorf("failed to read secret %s from AWS Secrets Manager: %w", name, err)
+	}
+
+	if key == "" {
+		if resp.SecretString != nil {
+			return []byte(*resp.SecretString), nil
+		}
+		return resp.SecretBinary, nil
+	}
+
+	// Key/value secrets are stored as a JSON object
+	if resp.SecretString == nil {
+		return nil, notFound(name)
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(*resp.SecretString), &values); err != nil {
+		return nil, &errors.StorageError{Code: ErrInvalidSecret.Code, Message: "Secret " + secretID + " is not a JSON object"}
+	}
+	switch value := values[key].(type) {
+	case string:
+		return []byte(value), nil
+	case nil:
+		return nil, notFound(name)
+	default:
+		encoded, _ := json.Marshal(value)
+		return encoded, nil
+	}
+}
@@ -0,0 +1,70 @@
+package secrets
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultRefreshInterval is how long a Cache keeps secrets by default
+const DefaultRefreshInterval = 5 * time.Minute
+
+// Cache keeps the secrets of another provider for a refresh interval, so rotated
+// secrets are picked up without reading them on every use
+// When a refresh fails the last value is served until the provider recovers
+type Cache struct {
+	provider Provider
+	interval time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   []byte
+	fetched time.Time
+}
+
+// NewCache creates a cache over a provider, interval defaults to DefaultRefreshInterval
+func NewCache(provider Provider, interval time.Duration) *Cache {
+	if interval <= 0 {
+		interval = DefaultRefreshInterval
+	}
+	return &Cache{
+		provider: provider,
+		interval: interval,
+		entries:  make(map[string]cacheEntry),
+	}
+}
+
+// GetSecret returns the cached secret, reading it again once the refresh interval passed
+func (c *Cache) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	c.mutex.Lock()
+	entry, exists := c.entries[name]
+	c.mutex.Unlock()
+
+	if exists && time.Since(entry.fetched) < c.interval {
+		return entry.value, nil
+	}
+
+	value, err := c.provider.GetSecret(ctx, name)
+	if err != nil {
+		if exists {
+			return entry.value, nil
+		}
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	c.entries[name] = cacheEntry{value: value, fetched: time.Now()}
+	c.mutex.Unlock()
+	return value, nil
+}
+
+// Invalidate drops a cached secret, e.g. after a rotation was announced
+func (c *Cache) Invalidate(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, name)
+}
@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+// FileProvider reads secrets from files, e.g. Docker secrets in /run/secrets or
+// Kubernetes secret volumes; rotated files are picked up on the next read
+type FileProvider struct {
+	dir string
+}
+
+// NewFileProvider creates a provider reading secret names relative to dir
+// With an empty dir names are file paths
+func NewFileProvider(dir string) *FileProvider {
+	return &FileProvider{
+		dir: dir,
+	}
+}
+
+// GetSecret reads a secret file, dropping the trailing newline most tools write
+func (p *FileProvider) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	path, err := p.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, notFound(name)
+		}
+		return nil, fmt.Errorf("failed to read secret file %s: %w", path, err)
+	}
+	return bytes.TrimRight(value, "\r\n"), nil
+}
+
+// path resolves a secret name, names may not leave the secrets directory
+func (p *FileProvider) path(name string) (string, error) {
+	if name == "" {
+		return "", ErrInvalidSecret
+	}
+	if p.dir == "" {
+		return name, nil
+	}
+
+	path := filepath.Join(p.dir, name)
+	rel, err := filepath.Rel(p.dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", &errors.StorageError{Code: ErrInvalidSecret.Code, Message: "Secret " + name + " is outside the secrets directory"}
+	}
+	return path, nil
+}
+
+// EnvProvider reads secrets from environment variables, named Prefix + name
+type EnvProvider struct {
+	Prefix string
+}
+
+// GetSecret returns the value of an environment variable
+func (p EnvProvider) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	value, exists := os.LookupEnv(p.Prefix + name)
+	if !exists {
+		return nil, notFound(p.Prefix + name)
+	}
+	return []byte(value), nil
+}
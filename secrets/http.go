@@ -0,0 +1,38 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// errStatusNotFound is returned by doJSON for 404 responses
+var errStatusNotFound = fmt.Errorf("request returned status %d", http.StatusNotFound)
+
+// doJSON sends a request and decodes the JSON response into out
+func doJSON(client *http.Client, req *http.Request, out interface{}) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errStatusNotFound
+	}
+	// Error bodies are small, keep a bounded excerpt for the error message
+	if resp.StatusCode >= 300 {
+		excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("request returned status %d: %s", resp.StatusCode, bytes.TrimSpace(excerpt))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+var (
+	ErrSecretNotFound = &errors.StorageError{Code: "SECRET_NOT_FOUND", Message: "Secret not found"}
+	ErrInvalidSecret  = &errors.StorageError{Code: "INVALID_SECRET", Message: "Invalid secret"}
+	ErrInvalidConfig  = &errors.StorageError{Code: "INVALID_CONFIG", Message: "Invalid secret provider configuration"}
+)
+
+// Provider returns secrets by name, e.g. from mounted files, Vault or AWS Secrets Manager
+// Providers read the current value on every call; wrap them in a Cache to limit lookups
+type Provider interface {
+	GetSecret(ctx context.Context, name string) ([]byte, error)
+}
+
+// GetString returns a secret as a string without surrounding whitespace
+func GetString(ctx context.Context, provider Provider, name string) (string, error) {
+	value, err := provider.GetSecret(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(value)), nil
+}
+
+// GetKey returns a hex encoded key secret of the given size in bytes
+func GetKey(ctx context.Context, provider Provider, name string, size int) ([]byte, error) {
+	value, err := GetString(ctx, provider, name)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := hex.DecodeString(value)
+	if err != nil {
+		return nil, &errors.StorageError{Code: ErrInvalidSecret.Code, Message: "Secret " + name + " is not a hex key"}
+	}
+	if len(key) != size {
+		return nil, &errors.StorageError{
+			Code:    ErrInvalidSecret.Code,
+			Message: fmt.Sprintf("Secret %s must be a %d byte key", name, size),
+		}
+	}
+	return key, nil
+}
+
+// notFound reports a missing secret by name
+func notFound(name string) error {
+	return &errors.StorageError{Code: ErrSecretNotFound.Code, Message: ErrSecretNotFound.Message, Details: name}
+}
+
+// splitField splits "name#field" references to a field of a structured secret
+func splitField(name string) (string, string) {
+	if i := strings.LastIndex(name, "#"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
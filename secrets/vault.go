@@ -0,0 +1,80 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// VaultConfig represents HashiCorp Vault KV secrets engine (version 2) configuration
+type VaultConfig struct {
+	Address   string `json:"address"`             // e.g. https://vault:8200
+	Token     string `json:"-"`                   // Vault token with read access to the secrets
+	Namespace string `json:"namespace,omitempty"` // Vault Enterprise namespace
+	MountPath string `json:"mount_path"`          // KV mount, default "secret"
+	Field     string `json:"field,omitempty"`     // Field read when a name has none, default "value"
+
+	HTTPClient *http.Client `json:"-"`
+}
+
+// VaultProvider reads the latest version of secrets from a Vault KV version 2 engine
+// Names are secret paths with an optional field, e.g. "storage/minio#secret_key"
+type VaultProvider struct {
+	config VaultConfig
+}
+
+// NewVaultProvider creates a new Vault KV secret provider
+func NewVaultProvider(config VaultConfig) (*VaultProvider, error) {
+	if config.Address == "" {
+		return nil, ErrInvalidConfig
+	}
+	if config.MountPath == "" {
+		config.MountPath = "secret"
+	}
+	if config.Field == "" {
+		config.Field = "value"
+	}
+	config.Address = strings.TrimSuffix(config.Address, "/")
+	config.MountPath = strings.Trim(config.MountPath, "/")
+
+	return &VaultProvider{
+		config: config,
+	}, nil
+}
+
+// GetSecret reads a field of the current version of a secret
+func (p *VaultProvider) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	path, field := splitField(name)
+	if field == "" {
+		field = p.config.Field
+	}
+
+	url := fmt.Sprintf("%s/v1/%s/data/%s", p.config.Address, p.config.MountPath, strings.Trim(path, "/"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", p.config.Token)
+	if p.config.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", p.config.Namespace)
+	}
+
+	var resp struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := doJSON(p.config.HTTPClient, req, &resp); err != nil {
+		if err == errStatusNotFound {
+			return nil, notFound(name)
+		}
+		return nil, fmt.Errorf("failed to read secret %s from Vault: %w", name, err)
+	}
+
+	value, ok := resp.Data.Data[field].(string)
+	if !ok {
+		return nil, notFound(name)
+	}
+	return []byte(value), nil
+}
@@ -1,17 +1,82 @@
+// Package storage is the entry point of the library, it creates registries and re-exports the
+// common types of the handler, registry, config and interfaces packages so most callers need a
+// single import. The subpackages hold the only implementation, the aliases below are the same types
 package storage
 
 import (
 	"fmt"
 
+	"github.com/darmawan01/storage/category"
 	"github.com/darmawan01/storage/config"
 	"github.com/darmawan01/storage/handler"
+	"github.com/darmawan01/storage/interfaces"
 	"github.com/darmawan01/storage/registry"
 )
 
+// Configuration types
+type (
+	StorageConfig  = config.StorageConfig
+	HandlerConfig  = handler.HandlerConfig
+	CategoryConfig = category.CategoryConfig
+)
+
+// Handler stores the files of one kind of entity, see handler.Handler
+type Handler = handler.Handler
+
+// Option overrides the behavior of a single Upload, Download or Delete call, see handler.WithTimeout
+type Option = handler.Option
+
+// Request and response types of the handler operations
+type (
+	UploadRequest    = interfaces.UploadRequest
+	UploadResponse   = interfaces.UploadResponse
+	DownloadRequest  = interfaces.DownloadRequest
+	DownloadResponse = interfaces.DownloadResponse
+	DeleteRequest    = interfaces.DeleteRequest
+	ReplaceRequest   = interfaces.ReplaceRequest
+	AppendRequest    = interfaces.AppendRequest
+	AppendResponse   = interfaces.AppendResponse
+	PreviewRequest   = interfaces.PreviewRequest
+	PreviewResponse  = interfaces.PreviewResponse
+	StreamRequest    = interfaces.StreamRequest
+	StreamResponse   = interfaces.StreamResponse
+	ListRequest      = interfaces.ListRequest
+	ListResponse     = interfaces.ListResponse
+	FileMetadata     = interfaces.FileMetadata
+	FileInfo         = interfaces.FileInfo
+	FileStat         = interfaces.FileStat
+)
+
+// Request and response types of PatchRange
+type (
+	PatchRangeRequest  = interfaces.PatchRangeRequest
+	PatchRangeResponse = interfaces.PatchRangeResponse
+)
+
+// ModerationRequest approves or rejects a file, see handler.Handler.Approve
+type ModerationRequest = interfaces.ModerationRequest
+
+// WaveformRequest reads the waveform of an audio file, see handler.Handler.GetWaveform
+type WaveformRequest = interfaces.WaveformRequest
+
+// Request and response types of PresignUpload
+type (
+	DirectUploadRequest  = interfaces.DirectUploadRequest
+	DirectUploadResponse = interfaces.DirectUploadResponse
+)
+
+// DerivedFileRequest reads a derived file of a file, see handler.Handler.GetDerivedFile
+type DerivedFileRequest = interfaces.DerivedFileRequest
+
 // Global registry
+//
+// Deprecated: use the registry returned by NewWithHandlers or registry.NewRegistry, a package
+// level registry cannot be used by two configurations in one program
 var Registry *registry.Registry
 
 // New creates a new storage client with the given configuration
+//
+// Deprecated: use NewWithHandlers, which returns the registry instead of setting Registry
 func New(config *config.StorageConfig) error {
 	Registry = registry.NewRegistry()
 
@@ -0,0 +1,166 @@
+package tenant
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+var (
+	ErrTenantNotFound = &errors.StorageError{Code: "TENANT_NOT_FOUND", Message: "Tenant not found"}
+	ErrTenantRequired = &errors.StorageError{Code: "TENANT_REQUIRED", Message: "Tenant is required"}
+	ErrInvalidTenant  = &errors.StorageError{Code: "INVALID_TENANT", Message: "Invalid tenant"}
+)
+
+// Config represents the storage settings of a tenant
+type Config struct {
+	// Dedicated bucket of the tenant, the registry bucket when empty
+	BucketName string `json:"bucket_name,omitempty"`
+	// Prefix of all file keys of the tenant, default "tenants/<id>"
+	KeyPrefix string `json:"key_prefix,omitempty"`
+
+	// Limits, 0 means unlimited
+	MaxFileSize       int64   `json:"max_file_size,omitempty"`
+	RequestsPerMinute float64 `json:"requests_per_minute,omitempty"`
+	BytesPerMinute    float64 `json:"bytes_per_minute,omitempty"` // Uploaded bytes
+}
+
+// Tenant is a registered tenant
+type Tenant struct {
+	ID string `json:"id"`
+	Config
+}
+
+// Key returns the file key of the tenant for a key generated by a handler
+func (t *Tenant) Key(fileKey string) string {
+	return t.KeyPrefix + "/" + fileKey
+}
+
+// Owns reports whether a file key belongs to the tenant
+func (t *Tenant) Owns(fileKey string) bool {
+	return strings.HasPrefix(fileKey, t.KeyPrefix+"/")
+}
+
+// Resolver maps requests to tenants
+type Resolver interface {
+	// Resolve returns the tenant of a request, nil for requests without a tenant
+	Resolve(ctx context.Context) (*Tenant, error)
+	// Owner returns the tenant owning a file key, nil when it belongs to no tenant
+	Owner(fileKey string) *Tenant
+}
+
+type contextKey struct{}
+
+// WithID returns a context scoped to a tenant
+func WithID(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenantID)
+}
+
+// IDFromContext returns the tenant ID set by WithID
+func IDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// Directory is a Resolver over registered tenants, taking the tenant ID from the request context
+type Directory struct {
+	// Require rejects requests without a tenant ID
+	Require bool
+
+	mutex   sync.RWMutex
+	tenants map[string]*Tenant
+}
+
+// NewDirectory creates an empty tenant directory
+func NewDirectory() *Directory {
+	return &Directory{
+		tenants: make(map[string]*Tenant),
+	}
+}
+
+// Add registers or replaces a tenant
+func (d *Directory) Add(id string, config Config) (*Tenant, error) {
+	if id == "" || strings.ContainsAny(id, "/\\") {
+		return nil, ErrInvalidTenant
+	}
+	if config.KeyPrefix == "" {
+		config.KeyPrefix = "tenants/" + id
+	}
+	config.KeyPrefix = strings.Trim(config.KeyPrefix, "/")
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	// Overlapping prefixes would let one tenant read another's files
+	for otherID, other := range d.tenants {
+		if otherID == id {
+			continue
+		}
+		if strings.HasPrefix(other.KeyPrefix+"/", config.KeyPrefix+"/") || strings.HasPrefix(config.KeyPrefix+"/", other.KeyPrefix+"/") {
+			return nil, &errors.StorageError{Code: "INVALID_TENANT", Message: "Key prefix of tenant " + id + " overlaps tenant " + otherID}
+		}
+	}
+
+	t := &Tenant{ID: id, Config: config}
+	d.tenants[id] = t
+	return t, nil
+}
+
+// Remove unregisters a tenant, its files are kept
+func (d *Directory) Remove(id string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	delete(d.tenants, id)
+}
+
+// Get returns a registered tenant
+func (d *Directory) Get(id string) (*Tenant, error) {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	t, exists := d.tenants[id]
+	if !exists {
+		return nil, ErrTenantNotFound
+	}
+	return t, nil
+}
+
+// List returns the IDs of all registered tenants
+func (d *Directory) List() []string {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	ids := make([]string, 0, len(d.tenants))
+	for id := range d.tenants {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Resolve returns the tenant named in the request context
+func (d *Directory) Resolve(ctx context.Context) (*Tenant, error) {
+	id := IDFromContext(ctx)
+	if id == "" {
+		if d.Require {
+			return nil, ErrTenantRequired
+		}
+		return nil, nil
+	}
+	return d.Get(id)
+}
+
+// Owner returns the tenant whose key prefix contains fileKey
+func (d *Directory) Owner(fileKey string) *Tenant {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+
+	for _, t := range d.tenants {
+		if t.Owns(fileKey) {
+			return t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+// Package watermark draws text and image marks on files as they are served, so copies carry the
+// identity of the user who requested them
+// Watermarkers are pluggable, ImageWatermarker marks JPEG, PNG and GIF images in process and
+// PDFCPUWatermarker runs pdfcpu on the same machine for PDFs; Combine serves both
+package watermark
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/darmawan01/storage/errors"
+)
+
+var (
+	ErrUnsupported = &errors.StorageError{Code: "UNSUPPORTED_TYPE", Message: "No watermarker for this file type"}
+	ErrTooLarge    = &errors.StorageError{Code: "FILE_TOO_LARGE", Message: "File too large to watermark"}
+)
+
+// Positions of marks
+const (
+	PositionTile        = "tile" // Repeated across the whole file
+	PositionCenter      = "center"
+	PositionBottomRight = "bottom-right"
+)
+
+// Mark represents a watermark
+type Mark struct {
+	Text     string  `json:"text"`               // e.g. the requesting user and the date
+	Opacity  float64 `json:"opacity,omitempty"`  // From 0 to 1, default 0.3
+	Position string  `json:"position,omitempty"` // PositionTile (default), PositionCenter or PositionBottomRight
+}
+
+// withDefaults returns the mark with defaults for unset values
+func (m Mark) withDefaults() Mark {
+	if m.Opacity <= 0 {
+		m.Opacity = 0.3
+	}
+	if m.Opacity > 1 {
+		m.Opacity = 1
+	}
+	if m.Position == "" {
+		m.Position = PositionTile
+	}
+	return m
+}
+
+// alpha returns the opacity of the mark as an alpha value
+func (m Mark) alpha() uint8 {
+	return uint8(m.Opacity*255 + 0.5)
+}
+
+// Watermarker draws marks on files
+type Watermarker interface {
+	// Supports reports whether files of a content type can be watermarked
+	Supports(contentType string) bool
+	// Apply returns the watermarked file and its content type, which may differ from the original
+	Apply(ctx context.Context, contentType string, data io.Reader, mark Mark) ([]byte, string, error)
+}
+
+// mediaType returns the lower-case media type of a content type without parameters
+func mediaType(contentType string) string {
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
+
+// Combine returns a watermarker passing every file to the first of watermarkers supporting it
+func Combine(watermarkers ...Watermarker) Watermarker {
+	return combined(watermarkers)
+}
+
+type combined []Watermarker
+
+// Supports reports whether one of the watermarkers supports a content type
+func (c combined) Supports(contentType string) bool {
+	for _, w := range c {
+		if w.Supports(contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply watermarks a file with the first watermarker supporting it
+func (c combined) Apply(ctx context.Context, contentType string, data io.Reader, mark Mark) ([]byte, string, error) {
+	for _, w := range c {
+		if w.Supports(contentType) {
+			return w.Apply(ctx, contentType, data, mark)
+		}
+	}
+	return nil, "", ErrUnsupported
+}
+
+// ImageConfig represents image watermarking configuration
+type ImageConfig struct {
+	// Overlay is drawn at its own size with the opacity of the mark besides the text, e.g. a logo;
+	// centered for centered marks and in the bottom-right corner otherwise
+	Overlay   image.Image `json:"-"`
+	MaxPixels int64       `json:"max_pixels,omitempty"` // Largest image decoded, default 40 megapixels
+	Quality   int         `json:"quality,omitempty"`    // JPEG quality, default 90
+}
+
+// ImageWatermarker draws marks on images with a built-in bitmap font, which covers letters,
+// digits and common punctuation; text is drawn in capitals. GIFs are returned as PNG
+type ImageWatermarker struct {
+	config ImageConfig
+}
+
+// NewImageWatermarker creates a new image watermarker
+func NewImageWatermarker(config ImageConfig) *ImageWatermarker {
+	if config.MaxPixels <= 0 {
+		config.MaxPixels = 40 * 1000 * 1000
+	}
+	if config.Quality <= 0 || config.Quality > 100 {
+		config.Quality = 90
+	}
+	return &ImageWatermarker{config: config}
+}
+
+// Supports reports whether a content type is a JPEG, PNG or GIF image
+func (w *ImageWatermarker) Supports(contentType string) bool {
+	switch mediaType(contentType) {
+	case "image/jpeg", "image/jpg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Apply decodes an image, draws the mark and encodes it again
+func (w *ImageWatermarker) Apply(ctx context.Context, contentType string, data io.Reader, mark Mark) ([]byte, string, error) {
+	if !w.Supports(contentType) {
+		return nil, "", ErrUnsupported
+	}
+	raw, err := io.ReadAll(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read image: %w", err)
+	}
+	// The size is checked before decoding, so small files cannot claim huge images
+	config, _, err := image.DecodeConfig(bytes.NewReader(raw))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+	if int64(config.Width)*int64(config.Height) > w.config.MaxPixels {
+		return nil, "", ErrTooLarge
+	}
+	src, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	bounds := src.Bounds()
+	img := image.NewRGBA(bounds)
+	draw.Draw(img, bounds, src, bounds.Min, draw.Src)
+	w.draw(img, mark.withDefaults())
+
+	var out bytes.Buffer
+	if mediaType(contentType) == "image/png" || mediaType(contentType) == "image/gif" {
+		err = png.Encode(&out, img)
+		contentType = "image/png"
+	} else {
+		err = jpeg.Encode(&out, img, &jpeg.Options{Quality: w.config.Quality})
+		contentType = "image/jpeg"
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode image: %w", err)
+	}
+	return out.Bytes(), contentType, nil
+}
+
+// draw draws the overlay and text of a mark on an image
+func (w *ImageWatermarker) draw(img *image.RGBA, mark Mark) {
+	bounds := img.Bounds()
+	alpha := mark.alpha()
+	opacity := image.NewUniform(color.Alpha{A: alpha})
+
+	if overlay := w.config.Overlay; overlay != nil {
+		size := overlay.Bounds().Size()
+		at := image.Pt(bounds.Max.X-size.X-bounds.Dx()/50, bounds.Max.Y-size.Y-bounds.Dy()/50)
+		if mark.Position == PositionCenter {
+			at = image.Pt(bounds.Min.X+(bounds.Dx()-size.X)/2, bounds.Min.Y+(bounds.Dy()-size.Y)/2)
+		}
+		draw.DrawMask(img, image.Rectangle{Min: at, Max: at.Add(size)}, overlay, overlay.Bounds().Min, opacity, image.Point{}, draw.Over)
+	}
+
+	if strings.TrimSpace(mark.Text) == "" {
+		return
+	}
+	// Centered text spans two thirds of the width, other positions a third
+	width := bounds.Dx() / 3
+	if mark.Position == PositionCenter {
+		width = bounds.Dx() * 2 / 3
+	}
+	scale := textScale(width, bounds.Dy()/8, mark.Text)
+	mask := textMask(mark.Text, scale, alpha)
+	size := mask.Bounds().Size()
+	shadow := scale/3 + 1
+
+	switch mark.Position {
+	case PositionCenter:
+		drawText(img, mask, image.Pt(bounds.Min.X+(bounds.Dx()-size.X)/2, bounds.Min.Y+(bounds.Dy()-size.Y)/2), shadow)
+	case PositionBottomRight:
+		margin := 2 * scale
+		drawText(img, mask, image.Pt(bounds.Max.X-size.X-margin-shadow, bounds.Max.Y-size.Y-margin-shadow), shadow)
+	default:
+		// Rows are staggered, so no column of the image escapes the mark
+		stepX, stepY := size.X+size.X/2, size.Y*4
+		for row, y := 0, bounds.Min.Y+size.Y; y < bounds.Max.Y; row, y = row+1, y+stepY {
+			x := bounds.Min.X - (row%2)*stepX/2
+			for ; x < bounds.Max.X; x += stepX {
+				drawText(img, mask, image.Pt(x, y), shadow)
+			}
+		}
+	}
+}
+
+// drawText draws rendered text in white over a dark shadow, so it shows on light and dark images
+func drawText(img *image.RGBA, mask *image.Alpha, at image.Point, shadow int) {
+	size := mask.Bounds().Size()
+	offset := at.Add(image.Pt(shadow, shadow))
+	draw.DrawMask(img, image.Rectangle{Min: offset, Max: offset.Add(size)}, image.Black, image.Point{}, mask, image.Point{}, draw.Over)
+	draw.DrawMask(img, image.Rectangle{Min: at, Max: at.Add(size)}, image.White, image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+// PDFCPUConfig represents the pdfcpu executable used for PDF watermarks
+type PDFCPUConfig struct {
+	Path string `json:"path,omitempty"` // pdfcpu executable, default "pdfcpu" in PATH
+}
+
+// PDFCPUWatermarker draws text marks on every page of PDFs by running pdfcpu; tiled marks are
+// drawn once diagonally across the page
+type PDFCPUWatermarker struct {
+	config PDFCPUConfig
+}
+
+// NewPDFCPUWatermarker creates a new pdfcpu watermarker
+func NewPDFCPUWatermarker(config PDFCPUConfig) *PDFCPUWatermarker {
+	if config.Path == "" {
+		config.Path = "pdfcpu"
+	}
+	return &PDFCPUWatermarker{config: config}
+}
+
+// Supports reports whether a content type is a PDF
+func (w *PDFCPUWatermarker) Supports(contentType string) bool {
+	return mediaType(contentType) == "application/pdf"
+}
+
+// Apply writes the PDF to a temporary directory and watermarks it there
+func (w *PDFCPUWatermarker) Apply(ctx context.Context, contentType string, data io.Reader, mark Mark) ([]byte, string, error) {
+	if !w.Supports(contentType) {
+		return nil, "", ErrUnsupported
+	}
+	mark = mark.withDefaults()
+
+	dir, err := os.MkdirTemp("", "watermark-")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create watermark directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	input := filepath.Join(dir, "document.pdf")
+	file, err := os.Create(input)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to write document: %w", err)
+	}
+	_, err = io.Copy(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to write document: %w", err)
+	}
+
+	description := fmt.Sprintf("op:%.2f, fillcolor:#808080, ", mark.Opacity)
+	switch mark.Position {
+	case PositionBottomRight:
+		description += "pos:br, rot:0, sc:0.3 rel"
+	case PositionCenter:
+		description += "pos:c, rot:0, sc:0.8 rel"
+	default:
+		description += "pos:c, d:1, sc:0.9 rel"
+	}
+	output := filepath.Join(dir, "watermarked.pdf")
+	// The text follows "--", so marks starting with a dash are not read as flags
+	cmd := exec.CommandContext(ctx, w.config.Path, "watermark", "add", "-mode", "text", "--", mark.Text, description, input, output)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, "", fmt.Errorf("failed to watermark document with pdfcpu: %w: %s", err, bytes.TrimSpace(out))
+	}
+	pdf, err := os.ReadFile(output)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read watermarked document: %w", err)
+	}
+	return pdf, "application/pdf", nil
+}